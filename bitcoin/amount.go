@@ -0,0 +1,283 @@
+package bitcoin
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Error codes
+var (
+	ErrAmountFormat   = errors.New("invalid amount format")
+	ErrAmountUnit     = errors.New("unknown amount unit")
+	ErrAmountPrecise  = errors.New("amount too precise")
+	ErrAmountOverflow = errors.New("amount out of range")
+)
+
+//----------------------------------------------------------------------
+// Amounts are handled as integer multiples of satoshis; conversion
+// from and to decimal strings is exact (no floating point involved).
+//----------------------------------------------------------------------
+
+// Amount in satoshis
+type Amount int64
+
+// Amount units
+const (
+	Satoshi  Amount = 1
+	MilliBTC Amount = 100000
+	BTC      Amount = 100000000
+)
+
+// Unit of an amount representation
+type Unit int
+
+// Known units
+const (
+	UnitBTC Unit = iota
+	UnitMilliBTC
+	UnitSatoshi
+)
+
+// unit labels and number of decimal places
+var units = []struct {
+	label string
+	scale int
+}{
+	{"BTC", 8},
+	{"mBTC", 5},
+	{"sat", 0},
+}
+
+// String returns the label of a unit
+func (u Unit) String() string {
+	if u < 0 || int(u) >= len(units) {
+		return "?"
+	}
+	return units[u].label
+}
+
+// ParseUnit returns the unit for a label (case-insensitive).
+func ParseUnit(label string) (Unit, error) {
+	switch strings.ToLower(label) {
+	case "btc":
+		return UnitBTC, nil
+	case "mbtc":
+		return UnitMilliBTC, nil
+	case "sat", "sats", "satoshi", "satoshis":
+		return UnitSatoshi, nil
+	}
+	return 0, ErrAmountUnit
+}
+
+// ParseAmount converts a string like "0.5", "1.25 BTC", "12 mBTC" or
+// "1000 sat" into an amount. Numbers without unit are BTC.
+func ParseAmount(s string) (Amount, error) {
+	num, label, _ := strings.Cut(strings.TrimSpace(s), " ")
+	u := UnitBTC
+	if label = strings.TrimSpace(label); len(label) > 0 {
+		var err error
+		if u, err = ParseUnit(label); err != nil {
+			return 0, err
+		}
+	}
+	return ParseAmountUnit(num, u)
+}
+
+// ParseAmountUnit converts a decimal number in given unit to an amount.
+func ParseAmountUnit(s string, u Unit) (Amount, error) {
+	if u < 0 || int(u) >= len(units) {
+		return 0, ErrAmountUnit
+	}
+	v, err := parseDecimal(s, units[u].scale)
+	return Amount(v), err
+}
+
+// Format amount as decimal number in given unit (without unit label).
+// BTC amounts always have 8 decimal places; mBTC amounts have trailing
+// zeros removed.
+func (a Amount) Format(u Unit) string {
+	switch u {
+	case UnitBTC:
+		return formatDecimal(int64(a), 8, false)
+	case UnitMilliBTC:
+		return formatDecimal(int64(a), 5, true)
+	}
+	return strconv.FormatInt(int64(a), 10)
+}
+
+// String returns a human-readable amount in BTC.
+func (a Amount) String() string {
+	return a.Format(UnitBTC) + " BTC"
+}
+
+// ToBTC returns the amount as floating point value in BTC (for display
+// or computations where precision is not an issue).
+func (a Amount) ToBTC() float64 {
+	return float64(a) / float64(BTC)
+}
+
+// MarshalJSON encodes the amount as JSON number in BTC.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.Format(UnitBTC)), nil
+}
+
+// UnmarshalJSON decodes a JSON number (or string) in BTC.
+func (a *Amount) UnmarshalJSON(buf []byte) (err error) {
+	s := strings.Trim(string(buf), "\"")
+	*a, err = ParseAmountUnit(s, UnitBTC)
+	return
+}
+
+//----------------------------------------------------------------------
+// Fee rates are handled in satoshis per 1000 virtual bytes (sat/kvB) so
+// both "sat/vB" values (with 3 decimals) and Bitcoin Core's "BTC/kvB"
+// values can be represented exactly.
+//----------------------------------------------------------------------
+
+// FeeRate in sat/kvB
+type FeeRate int64
+
+// NewFeeRate computes the fee rate for a fee paid for given virtual size.
+func NewFeeRate(fee Amount, vsize int) FeeRate {
+	if vsize <= 0 {
+		return 0
+	}
+	return FeeRate(int64(fee) * 1000 / int64(vsize))
+}
+
+// ParseFeeRate converts a string like "12.5 sat/vB" or "0.0001 BTC/kvB"
+// into a fee rate. Numbers without unit are sat/vB.
+func ParseFeeRate(s string) (FeeRate, error) {
+	num, label, _ := strings.Cut(strings.TrimSpace(s), " ")
+	switch strings.ToLower(strings.TrimSpace(label)) {
+	case "", "sat/vb", "sat/b":
+		v, err := parseDecimal(num, 3)
+		return FeeRate(v), err
+	case "sat/kvb":
+		v, err := parseDecimal(num, 0)
+		return FeeRate(v), err
+	case "btc/kvb", "btc/kb":
+		v, err := parseDecimal(num, 8)
+		return FeeRate(v), err
+	}
+	return 0, ErrAmountUnit
+}
+
+// SatPerVByte returns the fee rate as decimal string in sat/vB
+func (r FeeRate) SatPerVByte() string {
+	return formatDecimal(int64(r), 3, true)
+}
+
+// BTCPerKvB returns the fee rate as decimal string in BTC/kvB
+func (r FeeRate) BTCPerKvB() string {
+	return formatDecimal(int64(r), 8, false)
+}
+
+// String returns a human-readable fee rate
+func (r FeeRate) String() string {
+	return r.SatPerVByte() + " sat/vB"
+}
+
+// Fee returns the fee for a transaction of given virtual size (rounded up).
+func (r FeeRate) Fee(vsize int) Amount {
+	return Amount((int64(r)*int64(vsize) + 999) / 1000)
+}
+
+// MarshalJSON encodes the fee rate as JSON number in BTC/kvB (as used by
+// Bitcoin Core).
+func (r FeeRate) MarshalJSON() ([]byte, error) {
+	return []byte(r.BTCPerKvB()), nil
+}
+
+// UnmarshalJSON decodes a JSON number (or string) in BTC/kvB.
+func (r *FeeRate) UnmarshalJSON(buf []byte) error {
+	v, err := parseDecimal(strings.Trim(string(buf), "\""), 8)
+	*r = FeeRate(v)
+	return err
+}
+
+//----------------------------------------------------------------------
+// Helper functions
+//----------------------------------------------------------------------
+
+// parseDecimal converts a decimal string to an integer scaled by
+// 10^scale. Exponent notation is not supported.
+func parseDecimal(s string, scale int) (int64, error) {
+	neg := false
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	ip, fp, _ := strings.Cut(s, ".")
+	if len(ip)+len(fp) == 0 {
+		return 0, ErrAmountFormat
+	}
+	// excess fractional digits must be zero
+	if len(fp) > scale {
+		if strings.Trim(fp[scale:], "0") != "" {
+			return 0, ErrAmountPrecise
+		}
+		fp = fp[:scale]
+	}
+	fp += strings.Repeat("0", scale-len(fp))
+	var v int64
+	for _, ch := range ip + fp {
+		if ch < '0' || ch > '9' {
+			return 0, ErrAmountFormat
+		}
+		if v > (1<<63-1-int64(ch-'0'))/10 {
+			return 0, ErrAmountOverflow
+		}
+		v = v*10 + int64(ch-'0')
+	}
+	if neg {
+		v = -v
+	}
+	return v, nil
+}
+
+// formatDecimal converts an integer scaled by 10^scale to a decimal
+// string (optionally trimming trailing zeros).
+func formatDecimal(v int64, scale int, trim bool) string {
+	sign := ""
+	u := uint64(v)
+	if v < 0 {
+		sign = "-"
+		u = uint64(-v)
+	}
+	s := strconv.FormatUint(u, 10)
+	if scale == 0 {
+		return sign + s
+	}
+	if len(s) <= scale {
+		s = strings.Repeat("0", scale-len(s)+1) + s
+	}
+	ip, fp := s[:len(s)-scale], s[len(s)-scale:]
+	if trim {
+		if fp = strings.TrimRight(fp, "0"); len(fp) == 0 {
+			return sign + ip
+		}
+	}
+	return sign + ip + "." + fp
+}
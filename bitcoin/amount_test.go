@@ -0,0 +1,109 @@
+package bitcoin
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAmountParse(t *testing.T) {
+	for _, x := range []struct {
+		in  string
+		out Amount
+	}{
+		{"1", BTC},
+		{"0.1", 10000000},
+		{"0.00000001", 1},
+		{"21000000", 21000000 * BTC},
+		{"-0.5 BTC", -BTC / 2},
+		{"12.5 mBTC", 1250000},
+		{"1000 sat", 1000},
+		{"0.30000000000", 30000000},
+	} {
+		a, err := ParseAmount(x.in)
+		if err != nil {
+			t.Fatalf("%s: %s", x.in, err.Error())
+		}
+		if a != x.out {
+			t.Fatalf("%s: got %d, expected %d", x.in, a, x.out)
+		}
+	}
+	for _, in := range []string{"", ".", "0.000000001", "1e5", "1 XYZ", "1.5 sat"} {
+		if _, err := ParseAmount(in); err == nil {
+			t.Fatalf("'%s' accepted", in)
+		}
+	}
+}
+
+func TestAmountFormat(t *testing.T) {
+	a := Amount(123456789)
+	if s := a.Format(UnitBTC); s != "1.23456789" {
+		t.Fatal(s)
+	}
+	if s := a.Format(UnitMilliBTC); s != "1234.56789" {
+		t.Fatal(s)
+	}
+	if s := Amount(-5).Format(UnitBTC); s != "-0.00000005" {
+		t.Fatal(s)
+	}
+	if s := Amount(500000).Format(UnitMilliBTC); s != "5" {
+		t.Fatal(s)
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	type entry struct {
+		Value Amount  `json:"value"`
+		Fee   FeeRate `json:"feerate"`
+	}
+	in := `{"value":0.1,"feerate":0.00001234}`
+	e := new(entry)
+	if err := json.Unmarshal([]byte(in), e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Value != 10000000 || e.Fee != 1234 {
+		t.Fatalf("decode failed: %d, %d", e.Value, e.Fee)
+	}
+	buf, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != `{"value":0.10000000,"feerate":0.00001234}` {
+		t.Fatal(string(buf))
+	}
+}
+
+func TestFeeRate(t *testing.T) {
+	r, err := ParseFeeRate("12.5 sat/vB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.String() != "12.5 sat/vB" || r.BTCPerKvB() != "0.00012500" {
+		t.Fatal(r.String())
+	}
+	if fee := r.Fee(141); fee != 1763 {
+		t.Fatalf("fee: %d", fee)
+	}
+	if NewFeeRate(1763, 141) != 12503 {
+		t.Fatal("NewFeeRate failed")
+	}
+}
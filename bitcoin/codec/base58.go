@@ -0,0 +1,170 @@
+package codec
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// Error codes
+var (
+	ErrBase58Char     = errors.New("invalid base58 character")
+	ErrBase58Checksum = errors.New("base58 checksum mismatch")
+	ErrBase58Length   = errors.New("base58 data too short")
+)
+
+// CharError reports an invalid character at a position in the input.
+type CharError struct {
+	Err  error // ErrBase58Char or ErrBech32Char
+	Pos  int   // position in input (in bytes)
+	Char rune  // offending character
+}
+
+// Error returns a human-readable error message.
+func (e *CharError) Error() string {
+	return fmt.Sprintf("%s: '%c' at position %d", e.Err.Error(), e.Char, e.Pos)
+}
+
+// Unwrap returns the underlying error code.
+func (e *CharError) Unwrap() error {
+	return e.Err
+}
+
+//----------------------------------------------------------------------
+// Base58
+//----------------------------------------------------------------------
+
+// Base58 alphabet
+const base58Chars = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58 character values (-1 for invalid characters)
+var base58Map [256]int8
+
+func init() {
+	for i := range base58Map {
+		base58Map[i] = -1
+	}
+	for i, c := range base58Chars {
+		base58Map[c] = int8(i)
+	}
+}
+
+// Base58Encode converts a byte array to base58 representation.
+func Base58Encode(in []byte) string {
+	// leading zero bytes are encoded as '1'
+	zeros := 0
+	for zeros < len(in) && in[zeros] == 0 {
+		zeros++
+	}
+	// convert remaining bytes (big-endian base conversion)
+	size := (len(in)-zeros)*138/100 + 1
+	buf := make([]byte, size)
+	high := size - 1
+	for _, b := range in[zeros:] {
+		carry := int(b)
+		j := size - 1
+		for ; j > high || carry != 0; j-- {
+			carry += 256 * int(buf[j])
+			buf[j] = byte(carry % 58)
+			carry /= 58
+		}
+		high = j
+	}
+	// skip leading zeros in result
+	i := 0
+	for i < size && buf[i] == 0 {
+		i++
+	}
+	out := make([]byte, zeros+size-i)
+	for j := 0; j < zeros; j++ {
+		out[j] = '1'
+	}
+	for j, v := range buf[i:] {
+		out[zeros+j] = base58Chars[v]
+	}
+	return string(out)
+}
+
+// Base58Decode converts a base58 representation into a byte array.
+func Base58Decode(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	size := (len(s)-zeros)*733/1000 + 1
+	buf := make([]byte, size)
+	high := size - 1
+	for i := zeros; i < len(s); i++ {
+		v := base58Map[s[i]]
+		if v < 0 {
+			return nil, &CharError{ErrBase58Char, i, rune(s[i])}
+		}
+		carry := int(v)
+		j := size - 1
+		for ; j > high || carry != 0; j-- {
+			carry += 58 * int(buf[j])
+			buf[j] = byte(carry & 0xff)
+			carry >>= 8
+		}
+		high = j
+	}
+	i := 0
+	for i < size && buf[i] == 0 {
+		i++
+	}
+	out := make([]byte, zeros, zeros+size-i)
+	return append(out, buf[i:]...), nil
+}
+
+// Base58CheckEncode appends a 4-byte checksum (Hash256) to the data
+// and returns the base58 representation.
+func Base58CheckEncode(data []byte) string {
+	buf := make([]byte, 0, len(data)+4)
+	buf = append(buf, data...)
+	return Base58Encode(append(buf, checksum(data)...))
+}
+
+// Base58CheckDecode decodes a base58 representation and verifies and
+// strips the 4-byte checksum.
+func Base58CheckDecode(s string) ([]byte, error) {
+	buf, err := Base58Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 4 {
+		return nil, ErrBase58Length
+	}
+	n := len(buf) - 4
+	if subtle.ConstantTimeCompare(checksum(buf[:n]), buf[n:]) != 1 {
+		return nil, ErrBase58Checksum
+	}
+	return buf[:n], nil
+}
+
+// checksum returns the first four bytes of Hash256(data).
+func checksum(data []byte) []byte {
+	h := sha256.Sum256(data)
+	h = sha256.Sum256(h[:])
+	return h[:4]
+}
@@ -0,0 +1,236 @@
+package codec
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"strings"
+)
+
+// Error codes
+var (
+	ErrBech32Format   = errors.New("invalid bech32 format")
+	ErrBech32Case     = errors.New("mixed case in bech32 string")
+	ErrBech32HRP      = errors.New("invalid bech32 human-readable part")
+	ErrBech32Length   = errors.New("invalid bech32 length")
+	ErrBech32Char     = errors.New("invalid bech32 character")
+	ErrBech32Checksum = errors.New("bech32 checksum mismatch")
+	ErrBech32Padding  = errors.New("invalid bech32 padding")
+)
+
+// Bech32 character set and checksum constants
+const (
+	bech32Chars  = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+// Bech32MaxLength is the maximum length of a Bech32 string (BIP173).
+// Use Bech32DecodeLimit to decode longer strings (e.g. BOLT-11).
+const Bech32MaxLength = 90
+
+// bech32 character values (-1 for invalid characters)
+var bech32Map [256]int8
+
+func init() {
+	for i := range bech32Map {
+		bech32Map[i] = -1
+	}
+	for i, c := range bech32Chars {
+		bech32Map[c] = int8(i)
+		// upper-case letters are valid too
+		if c >= 'a' && c <= 'z' {
+			bech32Map[c-'a'+'A'] = int8(i)
+		}
+	}
+}
+
+// Bech32Encode assembles a Bech32 (or Bech32m) string from a
+// human-readable part and 5-bit data words.
+func Bech32Encode(hrp string, data []byte, isM bool) string {
+	hrp = strings.ToLower(hrp)
+	buf := new(strings.Builder)
+	buf.WriteString(hrp)
+	buf.WriteByte('1')
+	for _, v := range data {
+		buf.WriteByte(bech32Chars[v&31])
+	}
+	buf.WriteString(bech32Checksum(hrp, data, isM))
+	return buf.String()
+}
+
+// Bech32Decode decodes a Bech32 or Bech32m string (of at most
+// Bech32MaxLength characters) into its human-readable part and 5-bit
+// data words (without checksum).
+func Bech32Decode(s string) (hrp string, data []byte, isM bool, err error) {
+	return Bech32DecodeLimit(s, Bech32MaxLength)
+}
+
+// Bech32DecodeLimit decodes a Bech32 or Bech32m string with a custom
+// length limit (no limit if 'limit' is 0).
+func Bech32DecodeLimit(s string, limit int) (hrp string, data []byte, isM bool, err error) {
+	if limit > 0 && len(s) > limit {
+		err = ErrBech32Length
+		return
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		err = ErrBech32Case
+		return
+	}
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 0 {
+		err = ErrBech32Format
+		return
+	}
+	if pos < 1 {
+		err = ErrBech32HRP
+		return
+	}
+	if pos+7 > len(s) {
+		err = ErrBech32Length
+		return
+	}
+	hrp = strings.ToLower(s[:pos])
+	for _, c := range hrp {
+		if c < 33 || c > 126 {
+			err = ErrBech32HRP
+			return
+		}
+	}
+	data = make([]byte, len(s)-pos-1)
+	for i := range data {
+		c := s[pos+1+i]
+		v := bech32Map[c]
+		if v < 0 {
+			err = &CharError{ErrBech32Char, pos + 1 + i, rune(c)}
+			return
+		}
+		data[i] = byte(v)
+	}
+	switch bech32Polymod(hrp, data) {
+	case bech32Const:
+	case bech32mConst:
+		isM = true
+	default:
+		err = ErrBech32Checksum
+		return
+	}
+	return hrp, data[:len(data)-6], isM, nil
+}
+
+// ConvertBits regroups a sequence of 'from'-bit values into 'to'-bit
+// values. If 'pad' is set, incomplete groups are padded with zero bits;
+// otherwise more than 'from-1' or non-zero padding bits are an error.
+func ConvertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var acc, bits uint
+	maxv := uint(1)<<to - 1
+	res := make([]byte, 0, (uint(len(data))*from+to-1)/to)
+	for _, v := range data {
+		if uint(v)>>from != 0 {
+			return nil, ErrBech32Format
+		}
+		acc = acc<<from | uint(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			res = append(res, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			res = append(res, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, ErrBech32Padding
+	}
+	return res, nil
+}
+
+//----------------------------------------------------------------------
+// Checksum computation (BIP173)
+//----------------------------------------------------------------------
+
+// bech32 checksum generator
+var bech32Gen = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// bech32Step feeds a 5-bit value into the checksum state (without
+// data-dependent branches).
+func bech32Step(chk uint32, v byte) uint32 {
+	b := chk >> 25
+	chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+	for i, g := range bech32Gen {
+		chk ^= g & -((b >> i) & 1)
+	}
+	return chk
+}
+
+// bech32Start returns the checksum state after the human-readable part.
+func bech32Start(hrp string) uint32 {
+	chk := uint32(1)
+	for i := 0; i < len(hrp); i++ {
+		chk = bech32Step(chk, hrp[i]>>5)
+	}
+	chk = bech32Step(chk, 0)
+	for i := 0; i < len(hrp); i++ {
+		chk = bech32Step(chk, hrp[i]&31)
+	}
+	return chk
+}
+
+// bech32Polymod computes the checksum state over HRP and data.
+func bech32Polymod(hrp string, data []byte) uint32 {
+	chk := bech32Start(hrp)
+	for _, v := range data {
+		chk = bech32Step(chk, v)
+	}
+	return chk
+}
+
+// bech32Finish returns the checksum characters for a checksum state.
+func bech32Finish(chk uint32, isM bool) string {
+	for i := 0; i < 6; i++ {
+		chk = bech32Step(chk, 0)
+	}
+	if isM {
+		chk ^= bech32mConst
+	} else {
+		chk ^= bech32Const
+	}
+	buf := make([]byte, 6)
+	for i := range buf {
+		buf[i] = bech32Chars[(chk>>(5*(5-i)))&31]
+	}
+	return string(buf)
+}
+
+// bech32Checksum returns the checksum characters for HRP and data.
+func bech32Checksum(hrp string, data []byte, isM bool) string {
+	return bech32Finish(bech32Polymod(hrp, data), isM)
+}
+
+// Bech32CRC returns the Bech32 (or Bech32m) checksum as 5-bit words.
+func Bech32CRC(hrp string, data []byte, isM bool) []byte {
+	crc := []byte(bech32Checksum(hrp, data, isM))
+	for i, c := range crc {
+		crc[i] = byte(bech32Map[c])
+	}
+	return crc
+}
@@ -0,0 +1,234 @@
+package codec
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestBase58(t *testing.T) {
+	for _, tc := range []struct {
+		hex, enc string
+	}{
+		{"", ""},
+		{"61", "2g"},
+		{"626262", "a3gV"},
+		{"636363", "aPEr"},
+		{"73696d706c792061206c6f6e6720737472696e67", "2cFupjhnEsSn59qHXstmK2ffpLv2"},
+		{"00eb15231dfceb60925886b67d065299925915aeb172c06647", "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+		{"516b6fcd0f", "ABnLTmg"},
+		{"bf4f89001e670274dd", "3SEo3LWLoPntC"},
+		{"572e4794", "3EFU7m"},
+		{"ecac89cad93923c02321", "EJDM8drfXA6uyA"},
+		{"10c8511e", "Rt5zm"},
+		{"00000000000000000000", "1111111111"},
+	} {
+		in, _ := hex.DecodeString(tc.hex)
+		if enc := Base58Encode(in); enc != tc.enc {
+			t.Errorf("encode %s: got %s, expected %s", tc.hex, enc, tc.enc)
+		}
+		out, err := Base58Decode(tc.enc)
+		if err != nil || !bytes.Equal(out, in) {
+			t.Errorf("decode %s: got %x (%v)", tc.enc, out, err)
+		}
+	}
+	_, err := Base58Decode("3SEo3LW0oPntC")
+	var ce *CharError
+	if !errors.As(err, &ce) || ce.Pos != 7 || ce.Char != '0' || !errors.Is(err, ErrBase58Char) {
+		t.Fatalf("invalid char: %v", err)
+	}
+}
+
+func TestBase58Check(t *testing.T) {
+	addr := "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+	data, err := Base58CheckDecode(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 21 || data[0] != 0 || Base58CheckEncode(data) != addr {
+		t.Fatal("round-trip failed")
+	}
+	if _, err = Base58CheckDecode("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3"); err != ErrBase58Checksum {
+		t.Fatalf("checksum: %v", err)
+	}
+	if _, err = Base58CheckDecode("2g"); err != ErrBase58Length {
+		t.Fatalf("length: %v", err)
+	}
+}
+
+func TestBech32(t *testing.T) {
+	// BIP173 and BIP350 valid strings
+	for _, tc := range []struct {
+		s   string
+		isM bool
+	}{
+		{"A12UEL5L", false},
+		{"a12uel5l", false},
+		{"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs", false},
+		{"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", false},
+		{"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w", false},
+		{"?1ezyfcl", false},
+		{"a1lqfn3a", true},
+		{"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", true},
+		{"split1checkupstagehandshakeupstreamerranterredcaperredlc445v", true},
+		{"?1v759aa", true},
+	} {
+		hrp, data, isM, err := Bech32Decode(tc.s)
+		if err != nil {
+			t.Errorf("%s: %v", tc.s, err)
+			continue
+		}
+		if isM != tc.isM {
+			t.Errorf("%s: wrong variant", tc.s)
+		}
+		if enc := Bech32Encode(hrp, data, isM); enc != strings.ToLower(tc.s) {
+			t.Errorf("%s: re-encoded as %s", tc.s, enc)
+		}
+	}
+	// invalid strings
+	for _, tc := range []struct {
+		s   string
+		err error
+	}{
+		{"\x201nwldj5", ErrBech32HRP},
+		{"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx", ErrBech32Length},
+		{"pzry9x0s0muk", ErrBech32Format},
+		{"1pzry9x0s0muk", ErrBech32HRP},
+		{"x1b4n0q5v", ErrBech32Char},
+		{"li1dgmt3", ErrBech32Length},
+		{"A1G7SGD8", ErrBech32Checksum},
+		{"10a06t8", ErrBech32HRP},
+		{"1qzzfhee", ErrBech32HRP},
+		{"a12UEL5L", ErrBech32Case},
+		{"a12uel5m", ErrBech32Checksum},
+	} {
+		if _, _, _, err := Bech32Decode(tc.s); !errors.Is(err, tc.err) {
+			t.Errorf("%q: got %v, expected %v", tc.s, err, tc.err)
+		}
+	}
+	// position of invalid character
+	_, _, _, err := Bech32Decode("x1b4n0q5v")
+	var ce *CharError
+	if !errors.As(err, &ce) || ce.Pos != 2 || ce.Char != 'b' {
+		t.Fatalf("char error: %v", err)
+	}
+	// long strings
+	long := Bech32Encode("lnbc", make([]byte, 200), false)
+	if _, _, _, err = Bech32Decode(long); err != ErrBech32Length {
+		t.Fatal("length limit not enforced")
+	}
+	if _, _, _, err = Bech32DecodeLimit(long, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvertBits(t *testing.T) {
+	in := []byte{0xff, 0x00, 0x81}
+	w, err := ConvertBits(in, 8, 5, true)
+	if err != nil || len(w) != 5 {
+		t.Fatal("8->5 failed")
+	}
+	out, err := ConvertBits(w, 5, 8, false)
+	if err != nil || !bytes.Equal(out, in) {
+		t.Fatal("5->8 failed")
+	}
+	if _, err = ConvertBits([]byte{1, 1}, 5, 8, false); err != ErrBech32Padding {
+		t.Fatal("non-zero padding accepted")
+	}
+	if _, err = ConvertBits([]byte{32}, 5, 8, false); err != ErrBech32Format {
+		t.Fatal("invalid word accepted")
+	}
+}
+
+func TestBech32Stream(t *testing.T) {
+	data := make([]byte, 10000)
+	rand.Read(data)
+	for _, isM := range []bool{false, true} {
+		// encode in chunks
+		buf := new(strings.Builder)
+		enc, err := NewBech32Encoder(buf, "test", isM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(data); i += 333 {
+			end := i + 333
+			if end > len(data) {
+				end = len(data)
+			}
+			if _, err = enc.Write(data[i:end]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// compare with block encoder
+		w, _ := ConvertBits(data, 8, 5, true)
+		if buf.String() != Bech32Encode("test", w, isM) {
+			t.Fatal("stream encoding differs")
+		}
+		// decode stream
+		dec := NewBech32Decoder(strings.NewReader(strings.ToUpper(buf.String())), "test")
+		out, err := io.ReadAll(dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, data) || dec.IsM() != isM {
+			t.Fatal("stream decoding failed")
+		}
+		// corrupted stream
+		s := []byte(buf.String())
+		s[5000] = bech32Chars[(bech32Map[s[5000]]+1)&31]
+		if _, err = io.ReadAll(NewBech32Decoder(bytes.NewReader(s), "test")); err != ErrBech32Checksum {
+			t.Fatalf("corrupted stream: %v", err)
+		}
+	}
+	// wrong HRP
+	if _, err := io.ReadAll(NewBech32Decoder(strings.NewReader("a12uel5l"), "b")); err != ErrBech32HRP {
+		t.Fatalf("wrong hrp: %v", err)
+	}
+}
+
+func TestBase58Stream(t *testing.T) {
+	data := make([]byte, 300)
+	rand.Read(data)
+	buf := new(bytes.Buffer)
+	enc := NewBase58Encoder(buf, true)
+	if _, err := enc.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != Base58CheckEncode(data) {
+		t.Fatal("stream encoding differs")
+	}
+	out, err := io.ReadAll(NewBase58Decoder(buf, true))
+	if err != nil || !bytes.Equal(out, data) {
+		t.Fatal("stream decoding failed")
+	}
+}
@@ -0,0 +1,33 @@
+package codec
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+/*
+ * ====================================================================
+ * Text encodings for Bitcoin data
+ * ====================================================================
+ * Base58 (with optional 4-byte Hash256 checksum, "Base58Check") and
+ * Bech32/Bech32m (BIP173/BIP350) codecs with detailed errors: invalid
+ * characters are reported with their position, checksum mismatches
+ * are distinguished from length and format errors. Encoders and
+ * decoders are also available as io.Writer/io.Reader for large
+ * payloads.
+ */
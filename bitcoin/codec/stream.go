@@ -0,0 +1,338 @@
+package codec
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+//----------------------------------------------------------------------
+// Streaming interface: Bech32 data is encoded/decoded on the fly (the
+// checksum is computed incrementally). Base58 is a base conversion of
+// the whole payload, so the Base58 stream types buffer the data and
+// convert it on Close (encoder) or on the first Read (decoder).
+//
+// A stream decoder only detects checksum errors at the end of the
+// input: data returned before the final error must be discarded.
+//----------------------------------------------------------------------
+
+// ErrStreamClosed is returned when writing to a closed encoder.
+var ErrStreamClosed = errors.New("stream closed")
+
+// Bech32Encoder writes the Bech32 encoding of the data written to it.
+type Bech32Encoder struct {
+	wrt    io.Writer
+	isM    bool
+	chk    uint32 // checksum state
+	acc    uint   // bit accumulator
+	bits   uint   // number of bits in accumulator
+	closed bool
+}
+
+// NewBech32Encoder creates a new Bech32 (or Bech32m) encoder for a
+// human-readable part. The string is written to 'w'; the encoder must
+// be closed to write the checksum.
+func NewBech32Encoder(w io.Writer, hrp string, isM bool) (*Bech32Encoder, error) {
+	if len(hrp) == 0 {
+		return nil, ErrBech32HRP
+	}
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 || (hrp[i] >= 'A' && hrp[i] <= 'Z') {
+			return nil, ErrBech32HRP
+		}
+	}
+	if _, err := io.WriteString(w, hrp+"1"); err != nil {
+		return nil, err
+	}
+	return &Bech32Encoder{
+		wrt: w,
+		isM: isM,
+		chk: bech32Start(hrp),
+	}, nil
+}
+
+// Write data to the encoder.
+func (e *Bech32Encoder) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, ErrStreamClosed
+	}
+	out := make([]byte, 0, len(p)*8/5+1)
+	for _, b := range p {
+		e.acc = e.acc<<8 | uint(b)
+		e.bits += 8
+		for e.bits >= 5 {
+			e.bits -= 5
+			out = e.emit(out, byte(e.acc>>e.bits&31))
+		}
+		e.acc &= 1<<e.bits - 1
+	}
+	if _, err := e.wrt.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close the encoder: write the padding and checksum.
+func (e *Bech32Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	var out []byte
+	if e.bits > 0 {
+		out = e.emit(out, byte(e.acc<<(5-e.bits)&31))
+	}
+	out = append(out, bech32Finish(e.chk, e.isM)...)
+	_, err := e.wrt.Write(out)
+	return err
+}
+
+// emit a 5-bit word
+func (e *Bech32Encoder) emit(out []byte, v byte) []byte {
+	e.chk = bech32Step(e.chk, v)
+	return append(out, bech32Chars[v])
+}
+
+//----------------------------------------------------------------------
+
+// Bech32Decoder reads Bech32-encoded data with known human-readable
+// part from a stream.
+type Bech32Decoder struct {
+	rdr   *bufio.Reader
+	hrp   string
+	err   error  // sticky error (including io.EOF)
+	pos   int    // position in input
+	chk   uint32 // checksum state
+	tail  []byte // last six 5-bit words (checksum candidates)
+	acc   uint   // bit accumulator
+	bits  uint   // number of bits in accumulator
+	lower bool   // lower-case characters found
+	upper bool   // upper-case characters found
+	isM   bool   // Bech32m checksum found
+}
+
+// NewBech32Decoder creates a decoder for a Bech32 or Bech32m stream that
+// must start with the given human-readable part and separator.
+func NewBech32Decoder(r io.Reader, hrp string) *Bech32Decoder {
+	return &Bech32Decoder{
+		rdr: bufio.NewReader(r),
+		hrp: hrp,
+	}
+}
+
+// IsM returns true if the stream had a Bech32m checksum (only valid
+// after the decoder returned io.EOF).
+func (d *Bech32Decoder) IsM() bool {
+	return d.isM
+}
+
+// Read decoded data.
+func (d *Bech32Decoder) Read(p []byte) (n int, err error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.pos == 0 {
+		if err = d.header(); err != nil {
+			d.err = err
+			return
+		}
+	}
+	for n < len(p) {
+		// flush complete bytes from accumulator
+		if d.bits >= 8 {
+			d.bits -= 8
+			p[n] = byte(d.acc >> d.bits)
+			d.acc &= 1<<d.bits - 1
+			n++
+			continue
+		}
+		// read next character
+		var c byte
+		if c, err = d.rdr.ReadByte(); err != nil {
+			if err == io.EOF {
+				err = d.finish()
+			}
+			d.err = err
+			if n > 0 {
+				err = nil
+			}
+			return
+		}
+		v := bech32Map[c]
+		if v < 0 {
+			d.err = &CharError{ErrBech32Char, d.pos, rune(c)}
+			return n, d.err
+		}
+		if err = d.caseCheck(c); err != nil {
+			d.err = err
+			return n, err
+		}
+		d.pos++
+		d.chk = bech32Step(d.chk, byte(v))
+		// words leave the checksum window in order
+		d.tail = append(d.tail, byte(v))
+		if len(d.tail) > 6 {
+			d.acc = d.acc<<5 | uint(d.tail[0])
+			d.bits += 5
+			d.tail = d.tail[1:]
+		}
+	}
+	return
+}
+
+// header reads and checks the human-readable part and separator.
+func (d *Bech32Decoder) header() error {
+	buf := make([]byte, len(d.hrp)+1)
+	if _, err := io.ReadFull(d.rdr, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrBech32Length
+		}
+		return err
+	}
+	for _, c := range buf[:len(d.hrp)] {
+		if err := d.caseCheck(c); err != nil {
+			return err
+		}
+	}
+	if !bytes.EqualFold(buf[:len(d.hrp)], []byte(d.hrp)) {
+		return ErrBech32HRP
+	}
+	if buf[len(d.hrp)] != '1' {
+		return ErrBech32Format
+	}
+	d.pos = len(buf)
+	d.chk = bech32Start(string(bytes.ToLower(buf[:len(d.hrp)])))
+	return nil
+}
+
+// caseCheck rejects mixed-case input.
+func (d *Bech32Decoder) caseCheck(c byte) error {
+	switch {
+	case c >= 'a' && c <= 'z':
+		d.lower = true
+	case c >= 'A' && c <= 'Z':
+		d.upper = true
+	}
+	if d.lower && d.upper {
+		return ErrBech32Case
+	}
+	return nil
+}
+
+// finish checks checksum and padding at the end of the input.
+func (d *Bech32Decoder) finish() error {
+	if len(d.tail) < 6 {
+		return ErrBech32Length
+	}
+	switch d.chk {
+	case bech32Const:
+	case bech32mConst:
+		d.isM = true
+	default:
+		return ErrBech32Checksum
+	}
+	if d.bits >= 5 || d.acc != 0 {
+		return ErrBech32Padding
+	}
+	return io.EOF
+}
+
+//----------------------------------------------------------------------
+
+// Base58Encoder writes the Base58 (or Base58Check) encoding of the
+// data written to it when closed.
+type Base58Encoder struct {
+	wrt    io.Writer
+	check  bool
+	buf    bytes.Buffer
+	closed bool
+}
+
+// NewBase58Encoder creates a new encoder; if 'check' is set, a 4-byte
+// checksum is appended to the data.
+func NewBase58Encoder(w io.Writer, check bool) *Base58Encoder {
+	return &Base58Encoder{wrt: w, check: check}
+}
+
+// Write data to the encoder.
+func (e *Base58Encoder) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, ErrStreamClosed
+	}
+	return e.buf.Write(p)
+}
+
+// Close the encoder and write the encoded data.
+func (e *Base58Encoder) Close() (err error) {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	var s string
+	if e.check {
+		s = Base58CheckEncode(e.buf.Bytes())
+	} else {
+		s = Base58Encode(e.buf.Bytes())
+	}
+	_, err = io.WriteString(e.wrt, s)
+	return
+}
+
+// Base58Decoder reads Base58 (or Base58Check) encoded data from a stream.
+type Base58Decoder struct {
+	rdr   io.Reader
+	check bool
+	data  *bytes.Reader
+	err   error
+}
+
+// NewBase58Decoder creates a new decoder; if 'check' is set, the
+// checksum is verified and stripped.
+func NewBase58Decoder(r io.Reader, check bool) *Base58Decoder {
+	return &Base58Decoder{rdr: r, check: check}
+}
+
+// Read decoded data.
+func (d *Base58Decoder) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.data == nil {
+		in, err := io.ReadAll(d.rdr)
+		if err == nil {
+			var out []byte
+			if d.check {
+				out, err = Base58CheckDecode(string(in))
+			} else {
+				out, err = Base58Decode(string(in))
+			}
+			d.data = bytes.NewReader(out)
+		}
+		if err != nil {
+			d.err = err
+			return 0, err
+		}
+	}
+	return d.data.Read(p)
+}
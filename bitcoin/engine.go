@@ -45,12 +45,9 @@ func NewSignatureFromASN1(b []byte) (*Signature, error) {
 	return sig, nil
 }
 
-// Bytes returns an ASN.1-encoded sequence of the signature.
+// Bytes returns an ASN.1-encoded sequence of the signature (strict DER).
 func (s *Signature) Bytes() ([]byte, error) {
-	var tSig struct{ R, S *big.Int }
-	tSig.R = new(big.Int).SetBytes(s.R.Bytes())
-	tSig.S = new(big.Int).SetBytes(s.S.Bytes())
-	return asn1.Marshal(tSig)
+	return s.DER(), nil
 }
 
 // Sign a hash value with private key.
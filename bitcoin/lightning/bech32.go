@@ -0,0 +1,56 @@
+package lightning
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"github.com/bfix/gospel/bitcoin/codec"
+)
+
+// Error codes
+var (
+	ErrBech32Format   = codec.ErrBech32Format
+	ErrBech32Char     = codec.ErrBech32Char
+	ErrBech32Checksum = codec.ErrBech32Checksum
+	ErrBech32Padding  = codec.ErrBech32Padding
+)
+
+// bech32Encode a human-readable part and 5-bit data words.
+// (BOLT-11 invoices are not limited to 90 characters)
+func bech32Encode(hrp string, data []byte) string {
+	return codec.Bech32Encode(hrp, data, false)
+}
+
+// bech32Decode a string into human-readable part and 5-bit data words
+// (without checksum). Only Bech32 checksums (not Bech32m) are valid.
+func bech32Decode(s string) (hrp string, data []byte, err error) {
+	var isM bool
+	if hrp, data, isM, err = codec.Bech32DecodeLimit(s, 0); err == nil && isM {
+		err = ErrBech32Checksum
+	}
+	return
+}
+
+// convertBits regroups a sequence of 'from'-bit values into 'to'-bit
+// values. If 'pad' is set, incomplete groups are padded with zero bits;
+// otherwise non-zero padding is an error.
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	return codec.ConvertBits(data, from, to, pad)
+}
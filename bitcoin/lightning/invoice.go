@@ -0,0 +1,549 @@
+package lightning
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/math"
+)
+
+// Error codes
+var (
+	ErrInvoicePrefix    = errors.New("invalid invoice prefix")
+	ErrInvoiceAmount    = errors.New("invalid invoice amount")
+	ErrInvoiceTooShort  = errors.New("invoice too short")
+	ErrInvoiceField     = errors.New("invalid tagged field")
+	ErrInvoiceNoHash    = errors.New("missing payment hash")
+	ErrInvoiceDescr     = errors.New("missing or ambiguous description")
+	ErrInvoiceSignature = errors.New("invalid invoice signature")
+)
+
+// Tagged field types (as 5-bit values)
+const (
+	FieldPaymentHash   = 1  // 'p': SHA256 payment hash
+	FieldRoute         = 3  // 'r': private route hints
+	FieldFeatures      = 5  // '9': feature bits
+	FieldExpiry        = 6  // 'x': expiry time in seconds
+	FieldFallback      = 9  // 'f': on-chain fallback address
+	FieldDescription   = 13 // 'd': description (UTF-8)
+	FieldPaymentSecret = 16 // 's': payment secret
+	FieldPayee         = 19 // 'n': public key of payee node
+	FieldDescrHash     = 23 // 'h': SHA256 hash of description
+	FieldMinFinalCLTV  = 24 // 'c': min. final CLTV expiry delta
+	FieldMetadata      = 27 // 'm': payment metadata
+)
+
+// Default values for missing fields
+const (
+	DefaultExpiry       = time.Hour
+	DefaultMinFinalCLTV = 18
+)
+
+// Currency prefixes of networks
+var Currencies = map[string]string{
+	"bc":   "mainnet",
+	"tb":   "testnet",
+	"tbs":  "signet",
+	"bcrt": "regtest",
+}
+
+// amount multipliers (in milli-satoshi per unit; 'p' is handled
+// separately as it represents 1/10 msat)
+var multipliers = []struct {
+	m    byte
+	msat uint64
+}{
+	{0, 100000000000},
+	{'m', 100000000},
+	{'u', 100000},
+	{'n', 100},
+}
+
+//----------------------------------------------------------------------
+// Invoice elements
+//----------------------------------------------------------------------
+
+// RouteHop is an entry in a private route hint
+type RouteHop struct {
+	PubKey    []byte // node id (33 bytes)
+	ChannelID uint64 // short channel id
+	FeeBase   uint32 // base fee (msat)
+	FeeRate   uint32 // proportional fee (millionths)
+	CLTVDelta uint16 // CLTV expiry delta
+}
+
+// Fallback is an on-chain fallback address
+type Fallback struct {
+	Version uint8  // witness version (17: P2PKH, 18: P2SH)
+	Data    []byte // hash or witness program
+}
+
+// Field is a (raw) tagged field
+type Field struct {
+	Type uint8  // field type
+	Data []byte // 5-bit data words
+}
+
+// Invoice is a Lightning payment request (BOLT-11)
+type Invoice struct {
+	Currency        string        // currency prefix ("bc", "tb",...)
+	Amount          uint64        // amount in milli-satoshi (0 if not set)
+	Timestamp       time.Time     // creation time
+	PaymentHash     []byte        // payment hash (32 bytes)
+	PaymentSecret   []byte        // payment secret (32 bytes, optional)
+	Description     string        // description
+	DescriptionHash []byte        // hashed description (32 bytes)
+	Payee           []byte        // payee node id (33 bytes)
+	Expiry          time.Duration // expiry (relative to timestamp)
+	MinFinalCLTV    uint64        // min. final CLTV expiry delta
+	Fallbacks       []*Fallback   // on-chain fallback addresses
+	Routes          [][]*RouteHop // private route hints
+	Features        []byte        // feature bits (5-bit words, big-endian)
+	Metadata        []byte        // payment metadata
+	Unknown         []*Field      // unknown fields (kept for signing)
+	Signature       []byte        // signature (R, S and recovery id)
+}
+
+// NewInvoice creates a new invoice for a payment hash.
+func NewInvoice(currency string, amount uint64, hash []byte, descr string) *Invoice {
+	return &Invoice{
+		Currency:     currency,
+		Amount:       amount,
+		Timestamp:    time.Unix(time.Now().Unix(), 0),
+		PaymentHash:  hash,
+		Description:  descr,
+		Expiry:       DefaultExpiry,
+		MinFinalCLTV: DefaultMinFinalCLTV,
+	}
+}
+
+// Expired returns true if the invoice is expired.
+func (inv *Invoice) Expired() bool {
+	return time.Now().After(inv.Timestamp.Add(inv.Expiry))
+}
+
+// Network returns the name of the network of the invoice.
+func (inv *Invoice) Network() string {
+	if n, ok := Currencies[inv.Currency]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// String returns a human-readable invoice summary.
+func (inv *Invoice) String() string {
+	descr := inv.Description
+	if len(inv.DescriptionHash) > 0 {
+		descr = fmt.Sprintf("#%x", inv.DescriptionHash)
+	}
+	return fmt.Sprintf("Invoice{%s,%d msat,%x,'%s',%s}", inv.Network(), inv.Amount,
+		inv.PaymentHash, descr, inv.Timestamp.Add(inv.Expiry).Format(time.RFC3339))
+}
+
+//----------------------------------------------------------------------
+// Decoding
+//----------------------------------------------------------------------
+
+// Decode a BOLT-11 invoice and verify its signature. If the invoice has
+// no payee field, the node id is recovered from the signature.
+func Decode(s string) (inv *Invoice, err error) {
+	hrp, data, err := bech32Decode(strings.TrimPrefix(strings.ToLower(s), "lightning:"))
+	if err != nil {
+		return nil, err
+	}
+	inv = new(Invoice)
+	if err = inv.parsePrefix(hrp); err != nil {
+		return nil, err
+	}
+	// split data into timestamp, fields and signature
+	if len(data) < 7+104 {
+		return nil, ErrInvoiceTooShort
+	}
+	sigPos := len(data) - 104
+	inv.Timestamp = time.Unix(int64(words2int(data[:7])), 0)
+	inv.Expiry = DefaultExpiry
+	inv.MinFinalCLTV = DefaultMinFinalCLTV
+	for pos := 7; pos < sigPos; {
+		if pos+3 > sigPos {
+			return nil, ErrInvoiceField
+		}
+		t := data[pos]
+		n := int(words2int(data[pos+1 : pos+3]))
+		pos += 3
+		if pos+n > sigPos {
+			return nil, ErrInvoiceField
+		}
+		if err = inv.parseField(t, data[pos:pos+n]); err != nil {
+			return nil, err
+		}
+		pos += n
+	}
+	// check mandatory fields
+	if inv.PaymentHash == nil {
+		return nil, ErrInvoiceNoHash
+	}
+	if len(inv.Description) > 0 && inv.DescriptionHash != nil {
+		return nil, ErrInvoiceDescr
+	}
+	// verify signature
+	if inv.Signature, err = convertBits(data[sigPos:], 5, 8, false); err != nil {
+		return nil, err
+	}
+	key, err := recoverKey(sigHash(hrp, data[:sigPos]), inv.Signature)
+	if err != nil {
+		return nil, err
+	}
+	pub := key.Q.Bytes(true)
+	if inv.Payee == nil {
+		inv.Payee = pub
+	} else if !bytes.Equal(inv.Payee, pub) {
+		return nil, ErrInvoiceSignature
+	}
+	return inv, nil
+}
+
+// parse human-readable part (currency and amount)
+func (inv *Invoice) parsePrefix(hrp string) error {
+	if !strings.HasPrefix(hrp, "ln") {
+		return ErrInvoicePrefix
+	}
+	hrp = hrp[2:]
+	pos := strings.IndexAny(hrp, "0123456789")
+	if pos < 0 {
+		inv.Currency = hrp
+		return nil
+	}
+	inv.Currency = hrp[:pos]
+	amount := hrp[pos:]
+	if len(inv.Currency) == 0 {
+		return ErrInvoicePrefix
+	}
+	// parse amount
+	mult := amount[len(amount)-1]
+	if mult >= '0' && mult <= '9' {
+		mult = 0
+	} else {
+		amount = amount[:len(amount)-1]
+	}
+	if len(amount) == 0 || (len(amount) > 1 && amount[0] == '0') {
+		return ErrInvoiceAmount
+	}
+	v, err := strconv.ParseUint(amount, 10, 64)
+	if err != nil {
+		return ErrInvoiceAmount
+	}
+	if mult == 'p' {
+		// pico-bitcoin must be a multiple of 10 (1/10 msat)
+		if v%10 != 0 {
+			return ErrInvoiceAmount
+		}
+		inv.Amount = v / 10
+		return nil
+	}
+	for _, m := range multipliers {
+		if m.m == mult {
+			inv.Amount = v * m.msat
+			if inv.Amount/m.msat != v {
+				return ErrInvoiceAmount
+			}
+			return nil
+		}
+	}
+	return ErrInvoiceAmount
+}
+
+// parse a tagged field. Fields with unexpected lengths are skipped (as
+// required by the specification).
+func (inv *Invoice) parseField(t byte, data []byte) (err error) {
+	// get the byte representation of a field with fixed length
+	fixed := func(words int) []byte {
+		if len(data) != words {
+			return nil
+		}
+		buf, err := convertBits(data, 5, 8, false)
+		if err != nil {
+			return nil
+		}
+		return buf
+	}
+	switch t {
+	case FieldPaymentHash:
+		if inv.PaymentHash == nil {
+			inv.PaymentHash = fixed(52)
+		}
+	case FieldPaymentSecret:
+		if inv.PaymentSecret == nil {
+			inv.PaymentSecret = fixed(52)
+		}
+	case FieldDescrHash:
+		if inv.DescriptionHash == nil {
+			inv.DescriptionHash = fixed(52)
+		}
+	case FieldPayee:
+		if inv.Payee == nil {
+			inv.Payee = fixed(53)
+		}
+	case FieldDescription:
+		var buf []byte
+		if buf, err = convertBits(data, 5, 8, false); err != nil {
+			return ErrInvoiceField
+		}
+		inv.Description = string(buf)
+	case FieldExpiry:
+		inv.Expiry = time.Duration(words2int(data)) * time.Second
+	case FieldMinFinalCLTV:
+		inv.MinFinalCLTV = words2int(data)
+	case FieldFeatures:
+		inv.Features = append([]byte{}, data...)
+	case FieldMetadata:
+		if inv.Metadata, err = convertBits(data, 5, 8, false); err != nil {
+			return ErrInvoiceField
+		}
+	case FieldFallback:
+		if len(data) == 0 {
+			return ErrInvoiceField
+		}
+		fb := &Fallback{Version: data[0]}
+		if fb.Data, err = convertBits(data[1:], 5, 8, false); err != nil {
+			return ErrInvoiceField
+		}
+		inv.Fallbacks = append(inv.Fallbacks, fb)
+	case FieldRoute:
+		var buf []byte
+		if buf, err = convertBits(data, 5, 8, false); err != nil || len(buf)%51 != 0 {
+			return ErrInvoiceField
+		}
+		route := make([]*RouteHop, 0)
+		for pos := 0; pos < len(buf); pos += 51 {
+			hop := buf[pos : pos+51]
+			route = append(route, &RouteHop{
+				PubKey:    hop[:33],
+				ChannelID: be64(hop[33:41]),
+				FeeBase:   uint32(be64(hop[41:45])),
+				FeeRate:   uint32(be64(hop[45:49])),
+				CLTVDelta: uint16(be64(hop[49:51])),
+			})
+		}
+		inv.Routes = append(inv.Routes, route)
+	default:
+		inv.Unknown = append(inv.Unknown, &Field{Type: t, Data: data})
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------
+// Encoding
+//----------------------------------------------------------------------
+
+// Encode and sign the invoice with the private key of the payee node.
+func (inv *Invoice) Encode(prv *bitcoin.PrivateKey) (string, error) {
+	if len(inv.PaymentHash) != 32 {
+		return "", ErrInvoiceNoHash
+	}
+	if len(inv.Description) > 0 && inv.DescriptionHash != nil {
+		return "", ErrInvoiceDescr
+	}
+	hrp, err := inv.prefix()
+	if err != nil {
+		return "", err
+	}
+	// assemble data words
+	data := int2words(uint64(inv.Timestamp.Unix()), 7)
+	add := func(t byte, words []byte) {
+		data = append(data, t)
+		data = append(data, int2words(uint64(len(words)), 2)...)
+		data = append(data, words...)
+	}
+	addBytes := func(t byte, buf []byte) {
+		w, _ := convertBits(buf, 8, 5, true)
+		add(t, w)
+	}
+	addBytes(FieldPaymentHash, inv.PaymentHash)
+	if inv.PaymentSecret != nil {
+		addBytes(FieldPaymentSecret, inv.PaymentSecret)
+	}
+	if inv.DescriptionHash != nil {
+		addBytes(FieldDescrHash, inv.DescriptionHash)
+	} else {
+		addBytes(FieldDescription, []byte(inv.Description))
+	}
+	if inv.Payee != nil {
+		addBytes(FieldPayee, inv.Payee)
+	}
+	if inv.Expiry != DefaultExpiry {
+		add(FieldExpiry, int2words(uint64(inv.Expiry/time.Second), 0))
+	}
+	if inv.MinFinalCLTV != DefaultMinFinalCLTV {
+		add(FieldMinFinalCLTV, int2words(inv.MinFinalCLTV, 0))
+	}
+	for _, fb := range inv.Fallbacks {
+		w, _ := convertBits(fb.Data, 8, 5, true)
+		add(FieldFallback, append([]byte{fb.Version}, w...))
+	}
+	for _, route := range inv.Routes {
+		buf := new(bytes.Buffer)
+		for _, hop := range route {
+			buf.Write(hop.PubKey)
+			buf.Write(put64(hop.ChannelID, 8))
+			buf.Write(put64(uint64(hop.FeeBase), 4))
+			buf.Write(put64(uint64(hop.FeeRate), 4))
+			buf.Write(put64(uint64(hop.CLTVDelta), 2))
+		}
+		addBytes(FieldRoute, buf.Bytes())
+	}
+	if len(inv.Features) > 0 {
+		add(FieldFeatures, inv.Features)
+	}
+	if inv.Metadata != nil {
+		addBytes(FieldMetadata, inv.Metadata)
+	}
+	for _, f := range inv.Unknown {
+		add(f.Type, f.Data)
+	}
+	// sign invoice
+	inv.Signature = signHash(prv, sigHash(hrp, data))
+	w, _ := convertBits(inv.Signature, 8, 5, true)
+	return bech32Encode(hrp, append(data, w...)), nil
+}
+
+// assemble human-readable part (currency and amount)
+func (inv *Invoice) prefix() (string, error) {
+	if len(inv.Currency) == 0 || strings.ContainsAny(inv.Currency, "0123456789") {
+		return "", ErrInvoicePrefix
+	}
+	hrp := "ln" + inv.Currency
+	if inv.Amount == 0 {
+		return hrp, nil
+	}
+	// use the shortest representation
+	for _, m := range multipliers {
+		if inv.Amount%m.msat == 0 {
+			hrp += strconv.FormatUint(inv.Amount/m.msat, 10)
+			if m.m != 0 {
+				hrp += string(m.m)
+			}
+			return hrp, nil
+		}
+	}
+	return hrp + strconv.FormatUint(inv.Amount*10, 10) + "p", nil
+}
+
+//----------------------------------------------------------------------
+// Signatures
+//----------------------------------------------------------------------
+
+// sigHash computes the hash over human-readable part and data words.
+func sigHash(hrp string, data []byte) []byte {
+	buf, _ := convertBits(data, 5, 8, true)
+	h := sha256.New()
+	h.Write([]byte(hrp))
+	h.Write(buf)
+	return h.Sum(nil)
+}
+
+// signHash creates a recoverable signature (R, S and recovery id) with
+// low S value.
+func signHash(prv *bitcoin.PrivateKey, hash []byte) []byte {
+	key := *prv
+	key.IsCompressed = true
+	sig := bitcoin.SignCompact(&key, hash)
+	recID := (sig[0] - 27) & 3
+	s := math.NewIntFromBytes(sig[33:])
+	n := bitcoin.GetCurve().N
+	if s.Cmp(n.Rsh(1)) > 0 {
+		s = n.Sub(s)
+		recID ^= 1
+	}
+	res := make([]byte, 65)
+	copy(res, sig[1:33])
+	copy(res[32:64], s.FixedBytes(32))
+	res[64] = recID
+	return res
+}
+
+// recoverKey returns the public key for a recoverable signature.
+func recoverKey(hash, sig []byte) (*bitcoin.PublicKey, error) {
+	if len(sig) != 65 || sig[64] > 3 {
+		return nil, ErrInvoiceSignature
+	}
+	compact := make([]byte, 65)
+	compact[0] = 27 + 4 + sig[64]
+	copy(compact[1:], sig[:64])
+	key, err := bitcoin.RecoverCompact(hash, compact)
+	if err != nil {
+		return nil, ErrInvoiceSignature
+	}
+	return key, nil
+}
+
+//----------------------------------------------------------------------
+// Helper functions
+//----------------------------------------------------------------------
+
+// words2int converts big-endian 5-bit words to an integer
+func words2int(data []byte) (v uint64) {
+	for _, w := range data {
+		v = v<<5 | uint64(w)
+	}
+	return
+}
+
+// int2words converts an integer to big-endian 5-bit words. If 'n' is
+// zero, the minimal number of words is used.
+func int2words(v uint64, n int) []byte {
+	if n == 0 {
+		for x := v; x > 0; x >>= 5 {
+			n++
+		}
+	}
+	res := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		res[i] = byte(v & 31)
+		v >>= 5
+	}
+	return res
+}
+
+// be64 converts big-endian bytes to an integer
+func be64(buf []byte) (v uint64) {
+	for _, b := range buf {
+		v = v<<8 | uint64(b)
+	}
+	return
+}
+
+// put64 converts an integer to 'n' big-endian bytes
+func put64(v uint64, n int) []byte {
+	buf := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		buf[i] = byte(v)
+		v >>= 8
+	}
+	return buf
+}
@@ -0,0 +1,253 @@
+package lightning
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+func newTestInvoice(amount uint64) *Invoice {
+	hash := sha256.Sum256([]byte("preimage"))
+	inv := NewInvoice("bc", amount, hash[:], "coffee")
+	inv.PaymentSecret = bytes.Repeat([]byte{0x42}, 32)
+	return inv
+}
+
+func TestInvoiceRoundtrip(t *testing.T) {
+	prv := bitcoin.GenerateKeys(true)
+	inv := newTestInvoice(2500000000)
+	inv.Expiry = time.Minute
+	inv.MinFinalCLTV = 40
+	inv.Fallbacks = []*Fallback{{Version: 0, Data: bytes.Repeat([]byte{1}, 20)}}
+	inv.Routes = [][]*RouteHop{{{
+		PubKey:    prv.PublicKey.Q.Bytes(true),
+		ChannelID: 0x0102030405060708,
+		FeeBase:   1,
+		FeeRate:   20,
+		CLTVDelta: 3,
+	}}}
+	inv.Features = []byte{1, 0}
+	for i := 0; i < 16; i++ {
+		s, err := inv.Encode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(s, "lnbc25m1") {
+			t.Fatalf("wrong prefix: %s", s)
+		}
+		out, err := Decode(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Payee, prv.PublicKey.Q.Bytes(true)) {
+			t.Fatal("node id not recovered")
+		}
+		if out.Amount != inv.Amount || out.Description != inv.Description ||
+			!bytes.Equal(out.PaymentHash, inv.PaymentHash) ||
+			!bytes.Equal(out.PaymentSecret, inv.PaymentSecret) ||
+			!out.Timestamp.Equal(inv.Timestamp) ||
+			out.Expiry != inv.Expiry || out.MinFinalCLTV != inv.MinFinalCLTV {
+			t.Fatal("invoice mismatch")
+		}
+		if len(out.Fallbacks) != 1 || !bytes.Equal(out.Fallbacks[0].Data, inv.Fallbacks[0].Data) {
+			t.Fatal("fallback mismatch")
+		}
+		if len(out.Routes) != 1 || len(out.Routes[0]) != 1 {
+			t.Fatal("route mismatch")
+		}
+		if hop := out.Routes[0][0]; !bytes.Equal(hop.PubKey, prv.PublicKey.Q.Bytes(true)) || hop.ChannelID != 0x0102030405060708 ||
+			hop.FeeBase != 1 || hop.FeeRate != 20 || hop.CLTVDelta != 3 {
+			t.Fatal("route mismatch")
+		}
+		if !bytes.Equal(out.Features, inv.Features) {
+			t.Fatal("features mismatch")
+		}
+	}
+	// explicit payee field
+	inv.Payee = prv.PublicKey.Q.Bytes(true)
+	s, err := inv.Encode(prv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	inv.Payee = bitcoin.GenerateKeys(true).PublicKey.Q.Bytes(true)
+	if s, err = inv.Encode(prv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Decode(s); err != ErrInvoiceSignature {
+		t.Fatal("wrong payee accepted")
+	}
+}
+
+func TestInvoiceAmount(t *testing.T) {
+	prv := bitcoin.GenerateKeys(true)
+	for _, x := range []struct {
+		msat   uint64
+		prefix string
+	}{
+		{0, "lnbc1"},
+		{100000000000, "lnbc11"},
+		{200000000, "lnbc2m1"},
+		{2500000, "lnbc25u1"},
+		{1000, "lnbc10n1"},
+		{1, "lnbc10p1"},
+		{123456789, "lnbc1234567890p1"},
+	} {
+		s, err := newTestInvoice(x.msat).Encode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(s, x.prefix) {
+			t.Fatalf("amount %d: got %s", x.msat, s[:len(x.prefix)])
+		}
+		inv, err := Decode(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inv.Amount != x.msat {
+			t.Fatalf("amount mismatch: %d != %d", inv.Amount, x.msat)
+		}
+	}
+	// invalid amounts
+	for _, hrp := range []string{"lnbc1p", "lnbc01m", "lnbc1x", "lnbc1mm"} {
+		inv := new(Invoice)
+		if err := inv.parsePrefix(hrp); err != ErrInvoiceAmount {
+			t.Fatalf("%s: expected invalid amount", hrp)
+		}
+	}
+}
+
+func TestInvoiceTamper(t *testing.T) {
+	prv := bitcoin.GenerateKeys(true)
+	inv := newTestInvoice(1000)
+	inv.Payee = prv.PublicKey.Q.Bytes(true)
+	s, err := inv.Encode(prv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// broken checksum
+	b := []byte(s)
+	pos := len(b) - 10
+	if b[pos] == 'q' {
+		b[pos] = 'p'
+	} else {
+		b[pos] = 'q'
+	}
+	if _, err = Decode(string(b)); err != ErrBech32Checksum {
+		t.Fatalf("expected checksum error: %v", err)
+	}
+	// re-encoded with different amount but same signature
+	hrp, data, _ := bech32Decode(s)
+	if hrp != "lnbc10n" {
+		t.Fatal("unexpected prefix " + hrp)
+	}
+	if _, err = Decode(bech32Encode("lnbc20n", data)); err != ErrInvoiceSignature {
+		t.Fatalf("expected signature error: %v", err)
+	}
+	// upper-case invoices and URI prefix
+	if _, err = Decode("lightning:" + strings.ToUpper(s)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// test vectors from BOLT-11 (signed with the private key
+// e126f68f7eafcc8b74f54d269fe206be715000f94dac067d1c04a8ca3b2db734)
+var bolt11Vectors = []struct {
+	invoice string
+	amount  uint64
+	descr   string
+	expiry  time.Duration
+	secret  bool
+}{
+	// donation (any amount)
+	{"lnbc1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdpl2pkx2ctnv5sxxmmwwd5kgetjypeh2ursdae8g6twvus8g6rfwvs8qun0dfjkxaq8rkx3yf5tcsyz3d73gafnh3cax9rn449d9p5uxz9ezhhypd0elx87sjle52x86fux2ypatgddc6k63n7erqz25le42c4u4ecky03ylcqca784w",
+		0, "Please consider supporting this project", time.Hour, false},
+	{"lnbc1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdpl2pkx2ctnv5sxxmmwwd5kgetjypeh2ursdae8g6twvus8g6rfwvs8qun0dfjkxaq9qrsgq357wnc5r2ueh7ck6q93dj32dlqnls087fxdwk8qakdyafkq3yap9us6v52vjjsrvywa6rt52cm9r9zqt8r2t7mlcwspyetp5h2tztugp9lfyql",
+		0, "Please consider supporting this project", time.Hour, true},
+	// 2500 micro-BTC for "1 cup coffee" (expires in one minute)
+	{"lnbc2500u1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpuaztrnwngzn3kdzw5hydlzf03qdgm2hdq27cqv3agm2awhz5se903vruatfhq77w3ls4evs3ch9zw97j25emudupq63nyw24cg27h2rspfj9srp",
+		250000000, "1 cup coffee", time.Minute, false},
+	{"lnbc2500u1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpu9qrsgquk0rl77nj30yxdy8j9vdx85fkpmdla2087ne0xh8nhedh8w27kyke0lp53ut353s06fv3qfegext0eh0ymjpf39tuven09sam30g4vgpfna3rh",
+		250000000, "1 cup coffee", time.Minute, true},
+}
+
+func TestInvoiceBOLT11(t *testing.T) {
+	key, _ := hex.DecodeString("e126f68f7eafcc8b74f54d269fe206be715000f94dac067d1c04a8ca3b2db734")
+	prv, err := bitcoin.PrivateKeyFromBytes(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payee, _ := hex.DecodeString("03e7156ae33b0a208d0744199163177e909e80176e55d97a2f221ede0f934dd9ad")
+	hash, _ := hex.DecodeString("0001020304050607080900010203040506070809000102030405060708090102")
+	secret := bytes.Repeat([]byte{0x11}, 32)
+
+	check := func(inv *Invoice, v int) {
+		x := bolt11Vectors[v]
+		if inv.Currency != "bc" || inv.Amount != x.amount ||
+			inv.Timestamp.Unix() != 1496314658 || inv.Description != x.descr ||
+			inv.Expiry != x.expiry || !bytes.Equal(inv.PaymentHash, hash) {
+			t.Fatalf("vector %d: field mismatch: %s", v, inv)
+		}
+		if !bytes.Equal(inv.Payee, payee) {
+			t.Fatalf("vector %d: payee mismatch: %x", v, inv.Payee)
+		}
+		if x.secret {
+			if !bytes.Equal(inv.PaymentSecret, secret) || !bytes.Equal(inv.Features, []byte{16, 8, 0}) {
+				t.Fatalf("vector %d: secret/features mismatch", v)
+			}
+		} else if inv.PaymentSecret != nil || inv.Features != nil {
+			t.Fatalf("vector %d: unexpected secret/features", v)
+		}
+	}
+	for v, x := range bolt11Vectors {
+		inv, err := Decode(x.invoice)
+		if err != nil {
+			t.Fatalf("vector %d: %v", v, err)
+		}
+		check(inv, v)
+
+		// re-encode (payee is recovered from the signature): signatures
+		// use random nonces, so only the signed part can match (if the
+		// field order is the same).
+		inv.Payee = nil
+		s, err := inv.Encode(prv)
+		if err != nil {
+			t.Fatalf("vector %d: %v", v, err)
+		}
+		n := len(x.invoice) - 104 - 6
+		if !x.secret && s[:n] != x.invoice[:n] {
+			t.Fatalf("vector %d: re-encoded invoice mismatch:\n%s", v, s)
+		}
+		if inv, err = Decode(s); err != nil {
+			t.Fatalf("vector %d: %v", v, err)
+		}
+		check(inv, v)
+	}
+}
@@ -0,0 +1,179 @@
+package bitcoin
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+
+	"github.com/bfix/gospel/math"
+)
+
+// Error codes
+var (
+	ErrMsgSigInvalid  = errors.New("invalid compact signature")
+	ErrMsgSigRecovery = errors.New("public key recovery failed")
+)
+
+//----------------------------------------------------------------------
+// Signed messages (compatible with 'signmessage'/'verifymessage')
+//----------------------------------------------------------------------
+
+// MessagePrefix for signed messages (all Bitcoin networks)
+const MessagePrefix = "Bitcoin Signed Message:\n"
+
+// MessageHash returns the hash value of a message as used by Bitcoin
+// for signed messages.
+func MessageHash(msg []byte) []byte {
+	return MessageHashPrefix(MessagePrefix, msg)
+}
+
+// MessageHashPrefix returns the hash value of a message for signing
+// with a custom prefix (for other coins).
+func MessageHashPrefix(prefix string, msg []byte) []byte {
+	buf := new(bytes.Buffer)
+	writeVarInt(buf, uint64(len(prefix)))
+	buf.WriteString(prefix)
+	writeVarInt(buf, uint64(len(msg)))
+	buf.Write(msg)
+	return Hash256(buf.Bytes())
+}
+
+// SignMessage signs a message with private key and returns the base64
+// encoded compact signature.
+func SignMessage(key *PrivateKey, msg []byte) string {
+	sig := SignCompact(key, MessageHash(msg))
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// VerifyMessage checks a base64 encoded compact signature for a message
+// and returns the public key of the signer.
+func VerifyMessage(msg []byte, sig string) (*PublicKey, error) {
+	buf, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, err
+	}
+	return RecoverCompact(MessageHash(msg), buf)
+}
+
+//----------------------------------------------------------------------
+// Compact (recoverable) signatures
+//----------------------------------------------------------------------
+
+// SignCompact signs a hash value with private key and returns a 65 byte
+// compact signature (header byte with recovery id, R and S).
+func SignCompact(key *PrivateKey, hash []byte) []byte {
+	for {
+		sig := Sign(key, hash)
+		for recID := 0; recID < 2; recID++ {
+			q, err := RecoverPublicKey(hash, sig, recID)
+			if err != nil || !q.Equals(key.Q) {
+				continue
+			}
+			hdr := byte(27 + recID)
+			if key.IsCompressed {
+				hdr += 4
+			}
+			return append([]byte{hdr}, sig.Compact()...)
+		}
+	}
+}
+
+// RecoverCompact returns the public key for a hash value signed with
+// a compact signature.
+func RecoverCompact(hash, sig []byte) (*PublicKey, error) {
+	if len(sig) != 65 || sig[0] < 27 || sig[0] > 34 {
+		return nil, ErrMsgSigInvalid
+	}
+	recID := int(sig[0] - 27)
+	compr := false
+	if recID > 3 {
+		recID -= 4
+		compr = true
+	}
+	s := &Signature{
+		R: math.NewIntFromBytes(sig[1:33]),
+		S: math.NewIntFromBytes(sig[33:]),
+	}
+	q, err := RecoverPublicKey(hash, s, recID)
+	if err != nil {
+		return nil, err
+	}
+	key := &PublicKey{
+		Q:            q,
+		IsCompressed: compr,
+	}
+	if !Verify(key, hash, s) {
+		return nil, ErrMsgSigInvalid
+	}
+	return key, nil
+}
+
+// RecoverPublicKey computes the public key (point) of the signer from
+// a signature and hash value. The recovery id selects the candidate
+// point 'R' (bit 0: parity of y-coordinate, bit 1: x-coordinate
+// overflow).
+func RecoverPublicKey(hash []byte, sig *Signature, recID int) (*Point, error) {
+	if sig.R.Sign() <= 0 || sig.R.Cmp(c.N) >= 0 || sig.S.Sign() <= 0 || sig.S.Cmp(c.N) >= 0 {
+		return nil, ErrMsgSigInvalid
+	}
+	// reconstruct point 'R'
+	x := sig.R
+	if recID&2 != 0 {
+		x = x.Add(c.N)
+	}
+	if x.Cmp(c.P) >= 0 {
+		return nil, ErrMsgSigRecovery
+	}
+	y, err := computeY(x, uint(recID&1))
+	if err != nil {
+		return nil, ErrMsgSigRecovery
+	}
+	r := NewPoint(x, y)
+
+	// compute 'Q = r^-1 * (s*R - e*G)'
+	e := convertHash(hash)
+	sR := r.Mult(sig.S)
+	eG := MultBase(nMod(e))
+	q := sR.Add(NewPoint(eG.x, c.P.Sub(eG.y))).Mult(nInv(sig.R))
+	if q.IsInf() {
+		return nil, ErrMsgSigRecovery
+	}
+	return q, nil
+}
+
+// write a variable-length integer
+func writeVarInt(buf *bytes.Buffer, n uint64) {
+	switch {
+	case n < 0xfd:
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.Write([]byte{0xfd, byte(n), byte(n >> 8)})
+	case n <= 0xffffffff:
+		buf.Write([]byte{0xfe, byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)})
+	default:
+		buf.WriteByte(0xff)
+		for i := 0; i < 8; i++ {
+			buf.WriteByte(byte(n >> (8 * i)))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package bitcoin
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"testing"
+)
+
+func TestSignMessage(t *testing.T) {
+	msg := []byte("Hello, world!")
+	for i := 0; i < 16; i++ {
+		prv := GenerateKeys(i&1 == 1)
+		sig := SignMessage(prv, msg)
+		pub, err := VerifyMessage(msg, sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pub.Q.Equals(prv.Q) || pub.IsCompressed != prv.IsCompressed {
+			t.Fatal("recovered key mismatch")
+		}
+		if _, err = VerifyMessage([]byte("Hello, World!"), sig); err == nil {
+			if pub, _ = VerifyMessage([]byte("Hello, World!"), sig); pub.Q.Equals(prv.Q) {
+				t.Fatal("verified modified message")
+			}
+		}
+	}
+}
+
+func TestVerifyMessageVector(t *testing.T) {
+	msg := []byte("This is an example of a signed message.")
+	sig := "H9L5yLFjti0QTHhPyFrZCT1V/MMnBtXKmoiKDZ78NDBjERki6ZTQZdSMCtkgoNmp17By9ItJr8o7ChX0XxY91nk="
+	pub, err := VerifyMessage(msg, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := append([]byte{0}, Hash160(pub.Bytes())...)
+	cs := Hash256(a)
+	if addr := Base58Encode(append(a, cs[:4]...)); addr != "1F3sAm6ZtwLAUnj7d38pGFxtP3RVEvtsbV" {
+		t.Fatal("address mismatch: " + addr)
+	}
+}
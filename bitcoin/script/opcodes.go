@@ -319,7 +319,8 @@ var (
 			return RcUnclosedIf
 		}},
 		{"OP_ENDIF", "ENDIF", OpENDIF, func(r *R) int {
-			return RcTxInvalid
+			// reached at the end of an executed branch
+			return RcOK
 		}},
 		{"OP_VERIFY", "VERIFY", OpVERIFY, func(r *R) int {
 			v, rc := r.stack.Pop()
@@ -812,34 +813,45 @@ var (
 			return RcOK
 		}},
 		{"OP_CHECKLOCKTIMEVERIFY", "CHECKLOCKTIME!", OpCHECKLOCKTIMEVERIFY, func(r *R) int {
-			v, rc := r.stack.Peek()
-			if rc != RcOK || v.Sign() == -1 {
+			if r.tx == nil {
+				return RcNoTransaction
+			}
+			vt, rc := r.stack.PeekNumber(0, 5)
+			if rc != RcOK || vt < 0 {
 				return RcTxInvalid
 			}
-			vt := uint64(v.Int64())
-			var bounds uint64 = 500000000
-			if (vt < bounds && r.tx.LockTime > bounds) ||
-				(vt > bounds && r.tx.LockTime < bounds) ||
+			// lock time type (height or time) must match and the lock
+			// time must have passed; the input must not be final.
+			lt := r.tx.LockTime
+			if (uint64(vt) < LockTimeThreshold) != (lt < LockTimeThreshold) ||
+				uint64(vt) > lt ||
 				r.tx.Sequence == 0xffffffff {
 				return RcTxInvalid
 			}
-			return RcDone
+			return RcOK
 		}},
 		{"OP_CHECKSEQUENCEVERIFY", "CHECKSEQ!", OpCHECKSEQUENCEVERIFY, func(r *R) int {
-			v, rc := r.stack.Peek()
-			if rc != RcOK || v.Sign() == -1 {
+			if r.tx == nil {
+				return RcNoTransaction
+			}
+			vt, rc := r.stack.PeekNumber(0, 5)
+			if rc != RcOK || vt < 0 {
 				return RcTxInvalid
 			}
-			vt := uint64(v.Int64())
+			// disabled relative lock time behaves like a NOP
+			if vt&SeqDisable != 0 {
+				return RcOK
+			}
+			// relative lock time type (blocks or time) must match and
+			// the lock time must have passed.
 			inSeq := r.tx.Sequence
-			if vt&(1<<31) == 0 {
-				if r.tx.Version < 2 ||
-					inSeq&(1<<31) != 0 ||
-					vt > inSeq {
-					return RcTxInvalid
-				}
+			if r.tx.Version < 2 ||
+				inSeq&SeqDisable != 0 ||
+				uint64(vt)&SeqTypeFlag != inSeq&SeqTypeFlag ||
+				uint64(vt)&SeqMask > inSeq&SeqMask {
+				return RcTxInvalid
 			}
-			return RcDone
+			return RcOK
 		}},
 		{"OP_NOP4", "NOP4", OpNOP4, func(r *R) int {
 			return RcOK
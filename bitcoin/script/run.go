@@ -253,18 +253,14 @@ func (r *R) checkSig(pkInt, sigInt *math.Int) (bool, int) {
 	if err != nil {
 		return false, RcInvalidPubkey
 	}
-	// get signature and hash type
-	sigData := sigInt.Bytes()
-	hashType := sigData[len(sigData)-1]
-	sigData = sigData[:len(sigData)-1]
-	// compute hash of amended transaction
-	txSign := append(r.tx.SignedData, []byte{hashType, 0, 0, 0}...)
-	txHash := bitcoin.Hash256(txSign)
-	// decode signature from DER data
-	sig, err := bitcoin.NewSignatureFromASN1(sigData)
+	// get signature and hash type (strict DER encoding)
+	sig, hashType, err := bitcoin.NewSignatureFromScript(sigInt.Bytes())
 	if err != nil {
 		return false, RcInvalidSignature
 	}
+	// compute hash of amended transaction
+	txSign := append(r.tx.SignedData, []byte{hashType, 0, 0, 0}...)
+	txHash := bitcoin.Hash256(txSign)
 	// perform signature verify
 	return bitcoin.Verify(pk, txHash, sig), RcOK
 }
@@ -28,13 +28,15 @@ import (
 // Objects on the stack are of type math.Int; byte arrays and intrinsic
 // integers are converted in both way when necessary.
 type Stack struct {
-	d []*math.Int
+	d   []*math.Int
+	raw [][]byte // raw data of pushed byte arrays (nil for integers)
 }
 
 // NewStack creates a new empty stack.
 func NewStack() *Stack {
 	return &Stack{
-		d: make([]*math.Int, 0),
+		d:   make([]*math.Int, 0),
+		raw: make([][]byte, 0),
 	}
 }
 
@@ -52,7 +54,10 @@ func (s *Stack) Values() []*math.Int {
 // Objects can be of type int, []byte, *math.Int or bool; other types return
 // a result code 'RcInvalidStackType'.
 func (s *Stack) Push(v interface{}) int {
-	var i *math.Int
+	var (
+		i   *math.Int
+		raw []byte
+	)
 	switch x := v.(type) {
 	case bool:
 		if x {
@@ -64,12 +69,14 @@ func (s *Stack) Push(v interface{}) int {
 		i = math.NewInt(int64(x))
 	case []byte:
 		i = math.NewIntFromBytes(x)
+		raw = x
 	case *math.Int:
 		i = x
 	default:
 		return RcInvalidStackType
 	}
 	s.d = append(s.d, i)
+	s.raw = append(s.raw, raw)
 	return RcOK
 }
 
@@ -89,6 +96,32 @@ func (s *Stack) PeekAt(i int) (*math.Int, int) {
 	return v, RcOK
 }
 
+// PeekNumber returns the object at depth 'i' of the stack as a number.
+// Byte arrays are decoded as script numbers (little-endian with sign bit)
+// of at most 'size' bytes.
+func (s *Stack) PeekNumber(i, size int) (int64, int) {
+	v, rc := s.PeekAt(i)
+	if rc != RcOK {
+		return 0, rc
+	}
+	raw := s.raw[len(s.raw)-1-i]
+	if raw == nil {
+		return v.Int64(), RcOK
+	}
+	if len(raw) > size {
+		return 0, RcInvalidUint
+	}
+	var n int64
+	for j := len(raw) - 1; j >= 0; j-- {
+		n = n<<8 | int64(raw[j])
+	}
+	if k := len(raw); k > 0 && raw[k-1]&0x80 != 0 {
+		n &^= int64(0x80) << (8 * (k - 1))
+		n = -n
+	}
+	return n, RcOK
+}
+
 // Pop removes the top-level element from the stack and returns it.
 func (s *Stack) Pop() (*math.Int, int) {
 	v, rc := s.Peek()
@@ -97,8 +130,10 @@ func (s *Stack) Pop() (*math.Int, int) {
 	}
 	if l := len(s.d); l > 1 {
 		s.d = s.d[:l-1]
+		s.raw = s.raw[:l-1]
 	} else {
 		s.d = make([]*math.Int, 0)
+		s.raw = make([][]byte, 0)
 	}
 	return v, RcOK
 }
@@ -112,6 +147,7 @@ func (s *Stack) RemoveAt(i int) (*math.Int, int) {
 	}
 	v := s.d[i]
 	s.d = append(s.d[:i], s.d[i+1:]...)
+	s.raw = append(s.raw[:i], s.raw[i+1:]...)
 	return v, RcOK
 }
 
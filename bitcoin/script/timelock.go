@@ -0,0 +1,307 @@
+package script
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+// Error codes
+var (
+	ErrTplMissing  = errors.New("missing witness value")
+	ErrTplLockTime = errors.New("invalid lock time")
+	ErrTplHashSize = errors.New("invalid hash size")
+	ErrTplPath     = errors.New("unknown spending path")
+)
+
+// Lock time and sequence constants (BIP-65, BIP-68, BIP-112)
+const (
+	LockTimeThreshold = 500000000 // lock times below are block heights
+	SeqDisable        = 1 << 31   // relative lock time disabled
+	SeqTypeFlag       = 1 << 22   // relative lock time in units of 512s
+	SeqMask           = 0x0000ffff
+)
+
+//----------------------------------------------------------------------
+// Script numbers
+//----------------------------------------------------------------------
+
+// NewNumStatement creates a statement that pushes a number onto the
+// stack. Small numbers use the opcodes OP_0 to OP_16 (and OP_1NEGATE);
+// other numbers are pushed as minimal script numbers (little-endian
+// with sign bit).
+func NewNumStatement(v int64) *Statement {
+	switch {
+	case v == 0:
+		return NewStatement(OpFALSE)
+	case v == -1:
+		return NewStatement(Op1NEGATE)
+	case v >= 1 && v <= 16:
+		return NewStatement(byte(OpTRUE + v - 1))
+	}
+	return NewDataStatement(NumBytes(v))
+}
+
+// NumBytes returns the minimal script number encoding of a value.
+func NumBytes(v int64) []byte {
+	if v == 0 {
+		return []byte{}
+	}
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	var buf []byte
+	for ; v > 0; v >>= 8 {
+		buf = append(buf, byte(v))
+	}
+	// add sign byte if the most significant bit is used
+	if buf[len(buf)-1]&0x80 != 0 {
+		if neg {
+			buf = append(buf, 0x80)
+		} else {
+			buf = append(buf, 0)
+		}
+	} else if neg {
+		buf[len(buf)-1] |= 0x80
+	}
+	return buf
+}
+
+//----------------------------------------------------------------------
+// Timelocked contracts:
+// A contract is a script (used as witness script for P2WSH or as redeem
+// script for P2SH) together with the templates for the different ways
+// to spend it. A template lists the stack items (bottom first) needed
+// to satisfy the script; named items are filled in by the spender.
+//----------------------------------------------------------------------
+
+// Names of template items
+const (
+	TplSig      = "sig"      // signature (spending key)
+	TplSigA     = "sigA"     // signature of first key (2-of-2)
+	TplSigB     = "sigB"     // signature of second key (2-of-2)
+	TplPreimage = "preimage" // hash preimage (HTLC)
+)
+
+// TplItem is an item in a spending template. Items with a name must be
+// provided by the spender; other items have fixed values.
+type TplItem struct {
+	Name  string // name of item (empty for fixed values)
+	Value []byte // fixed value
+}
+
+// Template for spending a contract
+type Template struct {
+	Name     string     // name of the spending path
+	Items    []*TplItem // stack items (bottom first)
+	LockTime uint64     // required transaction lock time (CLTV)
+	Sequence uint64     // required input sequence (CSV)
+}
+
+// Contract is a script with templates for all spending paths.
+type Contract struct {
+	Script    *Script              // witness or redeem script
+	Templates map[string]*Template // spending paths
+}
+
+// Witness returns the witness stack for a spending path. The witness
+// script is appended as last element.
+func (c *Contract) Witness(path string, vals map[string][]byte) ([][]byte, error) {
+	t, ok := c.Templates[path]
+	if !ok {
+		return nil, ErrTplPath
+	}
+	res := make([][]byte, 0, len(t.Items)+1)
+	for _, it := range t.Items {
+		v := it.Value
+		if len(it.Name) > 0 {
+			if v, ok = vals[it.Name]; !ok {
+				return nil, ErrTplMissing
+			}
+		}
+		res = append(res, v)
+	}
+	return append(res, c.Script.Bytes()), nil
+}
+
+// ScriptSig returns the signature script (for P2SH) of a spending path.
+// The redeem script is pushed as last element.
+func (c *Contract) ScriptSig(path string, vals map[string][]byte) (*Script, error) {
+	items, err := c.Witness(path, vals)
+	if err != nil {
+		return nil, err
+	}
+	scr := NewScript()
+	for _, v := range items {
+		if len(v) == 0 {
+			scr.Add(NewStatement(OpFALSE))
+		} else {
+			scr.Add(NewDataStatement(v))
+		}
+	}
+	return scr, nil
+}
+
+// CLTVLock returns a contract that can be spent by the key holder after
+// an absolute lock time (block height or unix time).
+//
+//	<locktime> OP_CHECKLOCKTIMEVERIFY OP_DROP <pub> OP_CHECKSIG
+func CLTVLock(pub *bitcoin.PublicKey, lockTime uint64) (*Contract, error) {
+	if lockTime == 0 || lockTime > 0xffffffff {
+		return nil, ErrTplLockTime
+	}
+	scr := NewScript()
+	addLock(scr, int64(lockTime), OpCHECKLOCKTIMEVERIFY)
+	scr.Add(NewDataStatement(pub.Bytes()))
+	scr.Add(NewStatement(OpCHECKSIG))
+	return &Contract{
+		Script: scr,
+		Templates: map[string]*Template{
+			"spend": {
+				Name:     "spend",
+				Items:    []*TplItem{{Name: TplSig}},
+				LockTime: lockTime,
+				Sequence: 0xfffffffe,
+			},
+		},
+	}, nil
+}
+
+// CSVLock returns a contract that can be spent by the key holder after
+// a relative lock time (BIP-68 sequence value).
+//
+//	<sequence> OP_CHECKSEQUENCEVERIFY OP_DROP <pub> OP_CHECKSIG
+func CSVLock(pub *bitcoin.PublicKey, seq uint64) (*Contract, error) {
+	if seq&^(SeqTypeFlag|SeqMask) != 0 {
+		return nil, ErrTplLockTime
+	}
+	scr := NewScript()
+	addLock(scr, int64(seq), OpCHECKSEQUENCEVERIFY)
+	scr.Add(NewDataStatement(pub.Bytes()))
+	scr.Add(NewStatement(OpCHECKSIG))
+	return &Contract{
+		Script: scr,
+		Templates: map[string]*Template{
+			"spend": {
+				Name:     "spend",
+				Items:    []*TplItem{{Name: TplSig}},
+				Sequence: seq,
+			},
+		},
+	}, nil
+}
+
+// HTLC returns a hash-timelock contract: the receiver can spend with the
+// preimage of the (SHA-256) hash; the sender can reclaim the funds after
+// an absolute lock time.
+//
+//	OP_IF
+//	    OP_SHA256 <hash> OP_EQUALVERIFY <receiver>
+//	OP_ELSE
+//	    <locktime> OP_CHECKLOCKTIMEVERIFY OP_DROP <sender>
+//	OP_ENDIF
+//	OP_CHECKSIG
+func HTLC(receiver, sender *bitcoin.PublicKey, hash []byte, lockTime uint64) (*Contract, error) {
+	if len(hash) != 32 {
+		return nil, ErrTplHashSize
+	}
+	if lockTime == 0 || lockTime > 0xffffffff {
+		return nil, ErrTplLockTime
+	}
+	scr := NewScript()
+	scr.Add(NewStatement(OpIF))
+	scr.Add(NewStatement(OpSHA256))
+	scr.Add(NewDataStatement(hash))
+	scr.Add(NewStatement(OpEQUALVERIFY))
+	scr.Add(NewDataStatement(receiver.Bytes()))
+	scr.Add(NewStatement(OpELSE))
+	addLock(scr, int64(lockTime), OpCHECKLOCKTIMEVERIFY)
+	scr.Add(NewDataStatement(sender.Bytes()))
+	scr.Add(NewStatement(OpENDIF))
+	scr.Add(NewStatement(OpCHECKSIG))
+	return &Contract{
+		Script: scr,
+		Templates: map[string]*Template{
+			"claim": {
+				Name:     "claim",
+				Items:    []*TplItem{{Name: TplSig}, {Name: TplPreimage}, {Value: []byte{1}}},
+				Sequence: 0xffffffff,
+			},
+			"refund": {
+				Name:     "refund",
+				Items:    []*TplItem{{Name: TplSig}, {Value: []byte{}}},
+				LockTime: lockTime,
+				Sequence: 0xfffffffe,
+			},
+		},
+	}, nil
+}
+
+// Escrow2of2 returns a 2-of-2 multisig contract with a timeout escape:
+// after a relative lock time the first key can spend alone.
+//
+//	OP_IF
+//	    OP_2 <a> <b> OP_2 OP_CHECKMULTISIG
+//	OP_ELSE
+//	    <sequence> OP_CHECKSEQUENCEVERIFY OP_DROP <a> OP_CHECKSIG
+//	OP_ENDIF
+func Escrow2of2(a, b *bitcoin.PublicKey, seq uint64) (*Contract, error) {
+	if seq&^(SeqTypeFlag|SeqMask) != 0 {
+		return nil, ErrTplLockTime
+	}
+	scr := NewScript()
+	scr.Add(NewStatement(OpIF))
+	scr.Add(NewNumStatement(2))
+	scr.Add(NewDataStatement(a.Bytes()))
+	scr.Add(NewDataStatement(b.Bytes()))
+	scr.Add(NewNumStatement(2))
+	scr.Add(NewStatement(OpCHECKMULTISIG))
+	scr.Add(NewStatement(OpELSE))
+	addLock(scr, int64(seq), OpCHECKSEQUENCEVERIFY)
+	scr.Add(NewDataStatement(a.Bytes()))
+	scr.Add(NewStatement(OpCHECKSIG))
+	scr.Add(NewStatement(OpENDIF))
+	return &Contract{
+		Script: scr,
+		Templates: map[string]*Template{
+			"cooperative": {
+				Name:     "cooperative",
+				Items:    []*TplItem{{Value: []byte{}}, {Name: TplSigA}, {Name: TplSigB}, {Value: []byte{1}}},
+				Sequence: 0xffffffff,
+			},
+			"timeout": {
+				Name:     "timeout",
+				Items:    []*TplItem{{Name: TplSigA}, {Value: []byte{}}},
+				Sequence: seq,
+			},
+		},
+	}, nil
+}
+
+// add lock time check (and drop lock time from stack)
+func addLock(scr *Script, v int64, op byte) {
+	scr.Add(NewNumStatement(v))
+	scr.Add(NewStatement(op))
+	scr.Add(NewStatement(OpDROP))
+}
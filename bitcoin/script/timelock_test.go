@@ -0,0 +1,196 @@
+package script
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+// test transaction data (signed part)
+var tlData = bytes.Repeat([]byte{0x5a}, 64)
+
+// sign test transaction with SIGHASH_ALL
+func tlSign(t *testing.T, prv *bitcoin.PrivateKey) []byte {
+	hash := bitcoin.Hash256(append(append([]byte{}, tlData...), 1, 0, 0, 0))
+	sig, err := bitcoin.Sign(prv, hash).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(sig, 1)
+}
+
+// execute a spending path of a contract in a transaction
+func tlExec(t *testing.T, c *Contract, path string, vals map[string][]byte, lockTime, seq uint64, version int) bool {
+	sigScr, err := c.ScriptSig(path, vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replace redeem script push with the script itself
+	scr := NewScript()
+	scr.Stmts = append(scr.Stmts, sigScr.Stmts[:len(sigScr.Stmts)-1]...)
+	scr.Stmts = append(scr.Stmts, c.Script.Stmts...)
+	r := NewRuntime(&Tx{
+		SignedData: tlData,
+		LockTime:   lockTime,
+		Sequence:   seq,
+		Version:    version,
+	})
+	ok, rc := r.ExecScript(scr)
+	return ok && rc == RcOK
+}
+
+func TestNumBytes(t *testing.T) {
+	for _, x := range []struct {
+		v   int64
+		enc string
+	}{
+		{0, ""}, {1, "01"}, {127, "7f"}, {128, "8000"}, {255, "ff00"},
+		{256, "0001"}, {-1, "81"}, {-128, "8080"}, {500000, "20a107"},
+	} {
+		if enc := hex.EncodeToString(NumBytes(x.v)); enc != x.enc {
+			t.Fatalf("%d: got '%s', expected '%s'", x.v, enc, x.enc)
+		}
+		// decode from stack
+		s := NewStack()
+		s.Push(NumBytes(x.v))
+		if v, rc := s.PeekNumber(0, 5); rc != RcOK || v != x.v {
+			t.Fatalf("%d: decoded %d", x.v, v)
+		}
+	}
+	if src := (&Script{Stmts: []*Statement{NewNumStatement(16), NewNumStatement(-1)}}).Decompile(); src != "OP_16 OP_1NEGATE" {
+		t.Fatalf("wrong small numbers: %s", src)
+	}
+}
+
+func TestCLTVLock(t *testing.T) {
+	prv := bitcoin.GenerateKeys(true)
+	vals := map[string][]byte{TplSig: tlSign(t, prv)}
+	for _, lock := range []uint64{256, 500000} {
+		c, err := CLTVLock(&prv.PublicKey, lock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tlExec(t, c, "spend", vals, lock, 0xfffffffe, 1) {
+			t.Fatal("spending at lock time failed")
+		}
+		if tlExec(t, c, "spend", vals, lock-1, 0xfffffffe, 1) {
+			t.Fatal("spent before lock time")
+		}
+		if tlExec(t, c, "spend", vals, lock, 0xffffffff, 1) {
+			t.Fatal("spent with final sequence")
+		}
+		if tlExec(t, c, "spend", vals, LockTimeThreshold+lock, 0xfffffffe, 1) {
+			t.Fatal("spent with lock time of wrong type")
+		}
+	}
+	if _, err := CLTVLock(&prv.PublicKey, 0); err != ErrTplLockTime {
+		t.Fatal("invalid lock time accepted")
+	}
+}
+
+func TestCSVLock(t *testing.T) {
+	prv := bitcoin.GenerateKeys(true)
+	vals := map[string][]byte{TplSig: tlSign(t, prv)}
+	c, err := CSVLock(&prv.PublicKey, 144)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tlExec(t, c, "spend", vals, 0, 144, 2) {
+		t.Fatal("spending after relative lock failed")
+	}
+	if tlExec(t, c, "spend", vals, 0, 143, 2) {
+		t.Fatal("spent before relative lock")
+	}
+	if tlExec(t, c, "spend", vals, 0, 144, 1) {
+		t.Fatal("spent with tx version 1")
+	}
+	if tlExec(t, c, "spend", vals, 0, SeqTypeFlag|144, 2) {
+		t.Fatal("spent with relative lock of wrong type")
+	}
+}
+
+func TestHTLC(t *testing.T) {
+	rcv := bitcoin.GenerateKeys(true)
+	snd := bitcoin.GenerateKeys(true)
+	preimage := []byte("secret preimage")
+	hash := sha256.Sum256(preimage)
+	c, err := HTLC(&rcv.PublicKey, &snd.PublicKey, hash[:], 700000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim := map[string][]byte{TplSig: tlSign(t, rcv), TplPreimage: preimage}
+	if !tlExec(t, c, "claim", claim, 0, 0xffffffff, 1) {
+		t.Fatal("claim failed")
+	}
+	claim[TplPreimage] = []byte("wrong preimage")
+	if tlExec(t, c, "claim", claim, 0, 0xffffffff, 1) {
+		t.Fatal("claimed with wrong preimage")
+	}
+	refund := map[string][]byte{TplSig: tlSign(t, snd)}
+	if !tlExec(t, c, "refund", refund, 700000, 0xfffffffe, 1) {
+		t.Fatal("refund failed")
+	}
+	if tlExec(t, c, "refund", refund, 699999, 0xfffffffe, 1) {
+		t.Fatal("refunded before timeout")
+	}
+	if _, err = c.Witness("refund", nil); err != ErrTplMissing {
+		t.Fatal("missing signature not detected")
+	}
+	w, err := c.Witness("refund", refund)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w) != 3 || len(w[1]) != 0 || !bytes.Equal(w[2], c.Script.Bytes()) {
+		t.Fatal("wrong witness")
+	}
+}
+
+func TestEscrow2of2(t *testing.T) {
+	a := bitcoin.GenerateKeys(true)
+	b := bitcoin.GenerateKeys(true)
+	c, err := Escrow2of2(&a.PublicKey, &b.PublicKey, SeqTypeFlag|10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coop := map[string][]byte{TplSigA: tlSign(t, a), TplSigB: tlSign(t, b)}
+	if !tlExec(t, c, "cooperative", coop, 0, 0xffffffff, 2) {
+		t.Fatal("cooperative spend failed")
+	}
+	coop[TplSigB] = tlSign(t, a)
+	if tlExec(t, c, "cooperative", coop, 0, 0xffffffff, 2) {
+		t.Fatal("spent with single key")
+	}
+	escape := map[string][]byte{TplSigA: tlSign(t, a)}
+	if !tlExec(t, c, "timeout", escape, 0, SeqTypeFlag|10, 2) {
+		t.Fatal("timeout spend failed")
+	}
+	if tlExec(t, c, "timeout", escape, 0, SeqTypeFlag|9, 2) {
+		t.Fatal("spent before timeout")
+	}
+	if _, err = c.ScriptSig("unknown", nil); err != ErrTplPath {
+		t.Fatal("unknown path accepted")
+	}
+}
@@ -0,0 +1,166 @@
+package bitcoin
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+
+	"github.com/bfix/gospel/math"
+)
+
+//----------------------------------------------------------------------
+// Signature encodings: strict DER (as required by BIP-66 for scripts)
+// and the 64 byte compact form (R||S, big-endian). Scripts append a
+// sighash type byte to the DER encoding.
+//----------------------------------------------------------------------
+
+// Error codes
+var (
+	ErrSigDERLength   = errors.New("invalid DER signature length")
+	ErrSigDERTag      = errors.New("invalid DER signature tag")
+	ErrSigDERNegative = errors.New("negative value in DER signature")
+	ErrSigDERPadding  = errors.New("excessive padding in DER signature")
+	ErrSigCompactSize = errors.New("invalid compact signature size")
+	ErrSigRange       = errors.New("signature value out of range")
+)
+
+// NewSignatureFromDER returns a signature from a strict DER encoding
+// (BIP-66 rules without sighash type).
+func NewSignatureFromDER(b []byte) (*Signature, error) {
+	if err := CheckDER(b); err != nil {
+		return nil, err
+	}
+	lenR := int(b[3])
+	return &Signature{
+		R: math.NewIntFromBytes(b[4 : 4+lenR]),
+		S: math.NewIntFromBytes(b[6+lenR:]),
+	}, nil
+}
+
+// NewSignatureFromScript returns a signature and sighash type from a
+// script signature (strict DER followed by the sighash type byte).
+func NewSignatureFromScript(b []byte) (*Signature, byte, error) {
+	if len(b) == 0 {
+		return nil, 0, ErrSigDERLength
+	}
+	sig, err := NewSignatureFromDER(b[:len(b)-1])
+	return sig, b[len(b)-1], err
+}
+
+// NewSignatureFromCompact returns a signature from its 64 byte compact
+// form. Both values must be in range [1,N-1].
+func NewSignatureFromCompact(b []byte) (*Signature, error) {
+	if len(b) != 64 {
+		return nil, ErrSigCompactSize
+	}
+	sig := &Signature{
+		R: math.NewIntFromBytes(b[:32]),
+		S: math.NewIntFromBytes(b[32:]),
+	}
+	if !sig.inRange() {
+		return nil, ErrSigRange
+	}
+	return sig, nil
+}
+
+// DER returns the strict DER encoding of the signature.
+func (s *Signature) DER() []byte {
+	r := derInt(s.R)
+	sv := derInt(s.S)
+	buf := []byte{0x30, byte(4 + len(r) + len(sv)), 0x02, byte(len(r))}
+	buf = append(buf, r...)
+	buf = append(buf, 0x02, byte(len(sv)))
+	return append(buf, sv...)
+}
+
+// Compact returns the 64 byte compact form of the signature.
+func (s *Signature) Compact() []byte {
+	return append(coordAsBytes(s.R), coordAsBytes(s.S)...)
+}
+
+// IsLowS returns true if S is not greater than N/2 (BIP-146).
+func (s *Signature) IsLowS() bool {
+	return s.S.Cmp(c.N.Rsh(1)) <= 0
+}
+
+// LowS returns the signature with S normalized to the lower half of
+// the range (the signature remains valid).
+func (s *Signature) LowS() *Signature {
+	if s.IsLowS() {
+		return s
+	}
+	return &Signature{R: s.R, S: c.N.Sub(s.S)}
+}
+
+// CheckDER validates a DER-encoded signature (without sighash type)
+// following the rules of BIP-66.
+func CheckDER(sig []byte) error {
+	n := len(sig)
+	// format: 0x30 [total] 0x02 [lenR] [R] 0x02 [lenS] [S]
+	if n < 8 || n > 72 {
+		return ErrSigDERLength
+	}
+	if sig[0] != 0x30 {
+		return ErrSigDERTag
+	}
+	if int(sig[1]) != n-2 {
+		return ErrSigDERLength
+	}
+	lenR := int(sig[3])
+	if 5+lenR >= n {
+		return ErrSigDERLength
+	}
+	lenS := int(sig[5+lenR])
+	if lenR+lenS+6 != n {
+		return ErrSigDERLength
+	}
+	if sig[2] != 0x02 || sig[4+lenR] != 0x02 {
+		return ErrSigDERTag
+	}
+	if lenR == 0 || lenS == 0 {
+		return ErrSigDERLength
+	}
+	if sig[4]&0x80 != 0 || sig[6+lenR]&0x80 != 0 {
+		return ErrSigDERNegative
+	}
+	// no leading zero bytes (unless required for positive values)
+	if lenR > 1 && sig[4] == 0 && sig[5]&0x80 == 0 {
+		return ErrSigDERPadding
+	}
+	if lenS > 1 && sig[6+lenR] == 0 && sig[7+lenR]&0x80 == 0 {
+		return ErrSigDERPadding
+	}
+	return nil
+}
+
+// inRange returns true if R and S are in range [1,N-1].
+func (s *Signature) inRange() bool {
+	return s.R.Sign() > 0 && s.R.Cmp(c.N) < 0 && s.S.Sign() > 0 && s.S.Cmp(c.N) < 0
+}
+
+// derInt returns the minimal DER encoding of a positive integer value.
+func derInt(v *math.Int) []byte {
+	b := v.Bytes()
+	if len(b) == 0 || b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
@@ -0,0 +1,116 @@
+package bitcoin
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSignatureDER(t *testing.T) {
+	// valid signature (from script tests)
+	der, _ := hex.DecodeString("3045022074f35af390c41ef1f5395d11f6041cf55a6d7dab0acdac8ee746c1f2de7a43b3" +
+		"022100b3dc3d916b557d378268a856b8f9a98b9afaf45442f5c9d726fce343de835a58")
+	sig, err := NewSignatureFromDER(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sig.DER(), der) {
+		t.Fatal("DER round-trip failed")
+	}
+	if sig.IsLowS() {
+		t.Fatal("high S not detected")
+	}
+	low := sig.LowS()
+	if !low.IsLowS() || len(low.DER()) != len(der)-1 {
+		t.Fatal("S normalization failed")
+	}
+	// compact form
+	cmp := sig.Compact()
+	if len(cmp) != 64 {
+		t.Fatal("compact size")
+	}
+	sig2, err := NewSignatureFromCompact(cmp)
+	if err != nil || sig2.R.Cmp(sig.R) != 0 || sig2.S.Cmp(sig.S) != 0 {
+		t.Fatal("compact round-trip failed")
+	}
+	// script signature with sighash type
+	if _, ht, err := NewSignatureFromScript(append(der, 0x01)); err != nil || ht != 1 {
+		t.Fatal("script signature")
+	}
+
+	// non-canonical encodings
+	for _, tc := range []struct {
+		enc string
+		err error
+	}{
+		{"30050201010201", ErrSigDERLength},                        // too short
+		{"3106020101020101", ErrSigDERTag},                         // wrong sequence tag
+		{"3007020101020101", ErrSigDERLength},                      // wrong total length
+		{"3006030101020101", ErrSigDERTag},                         // wrong integer tag
+		{"3006020181020101", ErrSigDERNegative},                    // negative R
+		{"300702020001020101", ErrSigDERPadding},                   // padded R
+		{"30070201010202007f", ErrSigDERPadding},                   // padded S
+		{"3006020101020201", ErrSigDERLength},                      // S length overflow
+		{"3006020002020101", ErrSigDERLength},                      // empty R
+		{"300702028000020101", ErrSigDERNegative},                  // negative R (2 bytes)
+		{"30060201010201ff", ErrSigDERNegative},                    // negative S
+		{"300602010102010100", ErrSigDERLength},                    // trailing data
+		{"3006020101020101", nil},                                  // minimal
+		{"3007020200800201" + "01", nil},                           // required padding
+		{"3006020101020101" + "3006020101020101", ErrSigDERLength}, // concatenated
+	} {
+		buf, _ := hex.DecodeString(tc.enc)
+		if err := CheckDER(buf); err != tc.err {
+			t.Errorf("%s: got %v, expected %v", tc.enc, err, tc.err)
+		}
+	}
+	// compact range checks
+	zero := make([]byte, 64)
+	if _, err = NewSignatureFromCompact(zero); err != ErrSigRange {
+		t.Fatal("zero compact signature accepted")
+	}
+	high := bytes.Repeat([]byte{0xff}, 64)
+	if _, err = NewSignatureFromCompact(high); err != ErrSigRange {
+		t.Fatal("out of range compact signature accepted")
+	}
+	if _, err = NewSignatureFromCompact(cmp[:63]); err != ErrSigCompactSize {
+		t.Fatal("short compact signature accepted")
+	}
+}
+
+func TestSignatureSignDER(t *testing.T) {
+	prv := GenerateKeys(true)
+	hash := Hash256([]byte("test"))
+	sig := Sign(prv, hash)
+	sig2, err := NewSignatureFromDER(sig.DER())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(&prv.PublicKey, hash, sig2) || !Verify(&prv.PublicKey, hash, sig2.LowS()) {
+		t.Fatal("verify failed")
+	}
+	asn, _ := sig.Bytes()
+	if sig3, err := NewSignatureFromASN1(asn); err != nil || sig3.S.Cmp(sig.S) != 0 {
+		t.Fatal("ASN.1 round-trip failed")
+	}
+}
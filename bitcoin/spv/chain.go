@@ -0,0 +1,365 @@
+package spv
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/bfix/gospel/math"
+)
+
+//======================================================================
+// Header chain with fork-choice by cumulative work. The chain keeps a
+// tree of all (valid) headers received and selects the tip with most
+// accumulated work as the active chain; tip changes are reported as
+// events (including reorg depth). Only context-free checks (linkage,
+// proof-of-work against the header target) are performed; difficulty
+// retargeting is left to the caller.
+//======================================================================
+
+// Error codes
+var (
+	ErrChainOrphan    = errors.New("unknown parent block")
+	ErrChainDuplicate = errors.New("header already known")
+	ErrChainInvalid   = errors.New("header extends invalid chain")
+	ErrChainUnknown   = errors.New("unknown block")
+	ErrChainGenesis   = errors.New("can't invalidate genesis block")
+)
+
+// Chain tip status (same semantics as 'getchaintips')
+const (
+	TipActive       = "active"        // tip of the active chain
+	TipValidHeaders = "valid-headers" // headers valid, branch not active
+	TipInvalid      = "invalid"       // branch contains an invalid block
+)
+
+// ChainTip describes the tip of a branch in the header chain.
+type ChainTip struct {
+	Height    int    `json:"height"`
+	Hash      string `json:"hash"`
+	BranchLen int    `json:"branchlen"`
+	Status    string `json:"status"`
+}
+
+// ChainEvent is emitted when the active tip changes.
+type ChainEvent struct {
+	Old          *ChainTip // previous active tip
+	New          *ChainTip // new active tip
+	Fork         int       // height of the common ancestor
+	Disconnected int       // number of blocks removed from the active chain
+	Connected    int       // number of blocks added to the active chain
+}
+
+// Reorg returns true if blocks of the old active chain were disconnected.
+func (e *ChainEvent) Reorg() bool {
+	return e.Disconnected > 0
+}
+
+// chainNode is a header in the block tree.
+type chainNode struct {
+	hdr     *Header
+	id      string
+	parent  *chainNode
+	height  int
+	work    *math.Int // cumulative work up to (and including) this block
+	seq     int       // arrival order (tie-breaker for equal work)
+	invalid bool      // block (or one of its ancestors) is invalid
+	failed  bool      // block was explicitly invalidated
+}
+
+// HeaderChain is a tree of block headers with fork-choice.
+type HeaderChain struct {
+	sync.Mutex
+
+	nodes map[string]*chainNode // known headers by block ID
+	kids  map[string][]*chainNode
+	root  *chainNode // genesis block
+	best  *chainNode // tip of active chain
+	seq   int        // arrival counter
+	subs  []chan *ChainEvent
+}
+
+// NewHeaderChain creates a new header chain starting at a genesis block.
+func NewHeaderChain(genesis *Header) *HeaderChain {
+	root := &chainNode{
+		hdr:  genesis,
+		id:   genesis.ID(),
+		work: genesis.Work(),
+	}
+	return &HeaderChain{
+		nodes: map[string]*chainNode{root.id: root},
+		kids:  make(map[string][]*chainNode),
+		root:  root,
+		best:  root,
+	}
+}
+
+// Subscribe returns a channel for tip change events. Events are
+// dropped if the channel buffer is full.
+func (c *HeaderChain) Subscribe(size int) <-chan *ChainEvent {
+	c.Lock()
+	defer c.Unlock()
+	ch := make(chan *ChainEvent, size)
+	c.subs = append(c.subs, ch)
+	return ch
+}
+
+// Height of the active chain.
+func (c *HeaderChain) Height() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.best.height
+}
+
+// Best returns the active chain tip.
+func (c *HeaderChain) Best() *ChainTip {
+	c.Lock()
+	defer c.Unlock()
+	return c.tip(c.best, TipActive)
+}
+
+// Header returns a known header by block ID.
+func (c *HeaderChain) Header(id string) (*Header, bool) {
+	c.Lock()
+	defer c.Unlock()
+	n, ok := c.nodes[id]
+	if !ok {
+		return nil, false
+	}
+	return n.hdr, true
+}
+
+// Add a header to the chain. If the active tip changes, the
+// corresponding event is returned (nil otherwise).
+func (c *HeaderChain) Add(h *Header) (*ChainEvent, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	id := h.ID()
+	if _, ok := c.nodes[id]; ok {
+		return nil, ErrChainDuplicate
+	}
+	parent, ok := c.nodes[HashID(h.PrevBlock)]
+	if !ok {
+		return nil, ErrChainOrphan
+	}
+	if !h.CheckPoW() {
+		return nil, ErrHeaderPoW
+	}
+	c.seq++
+	n := &chainNode{
+		hdr:     h,
+		id:      id,
+		parent:  parent,
+		height:  parent.height + 1,
+		work:    parent.work.Add(h.Work()),
+		seq:     c.seq,
+		invalid: parent.invalid,
+	}
+	c.nodes[id] = n
+	c.kids[parent.id] = append(c.kids[parent.id], n)
+	if n.invalid {
+		return nil, ErrChainInvalid
+	}
+	if n.work.Cmp(c.best.work) > 0 {
+		return c.activate(n), nil
+	}
+	return nil, nil
+}
+
+// Invalidate marks a block (and all its descendants) as invalid and
+// switches the active chain if required (like 'invalidateblock').
+func (c *HeaderChain) Invalidate(id string) (*ChainEvent, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	n, ok := c.nodes[id]
+	if !ok {
+		return nil, ErrChainUnknown
+	}
+	if n == c.root {
+		return nil, ErrChainGenesis
+	}
+	n.failed = true
+	c.mark(n, true)
+	return c.choose(), nil
+}
+
+// Reconsider removes the invalidity status of a block, its ancestors
+// and descendants and switches to the best chain (like
+// 'reconsiderblock').
+func (c *HeaderChain) Reconsider(id string) (*ChainEvent, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	n, ok := c.nodes[id]
+	if !ok {
+		return nil, ErrChainUnknown
+	}
+	// clear explicit failures on the path to genesis and in the subtree
+	for p := n.parent; p != nil; p = p.parent {
+		p.failed = false
+	}
+	c.clear(n)
+	c.mark(c.root, false)
+	return c.choose(), nil
+}
+
+// Tips returns all known chain tips (like 'getchaintips').
+func (c *HeaderChain) Tips() []*ChainTip {
+	c.Lock()
+	defer c.Unlock()
+
+	var tips []*ChainTip
+	for id, n := range c.nodes {
+		if len(c.kids[id]) > 0 {
+			continue
+		}
+		status := TipValidHeaders
+		if n == c.best {
+			status = TipActive
+		} else if n.invalid {
+			status = TipInvalid
+		}
+		tips = append(tips, c.tip(n, status))
+	}
+	// the active tip might have (invalid) descendants
+	if len(c.kids[c.best.id]) > 0 {
+		tips = append(tips, c.tip(c.best, TipActive))
+	}
+	// sort by height (descending)
+	for i := 1; i < len(tips); i++ {
+		for j := i; j > 0 && tips[j].Height > tips[j-1].Height; j-- {
+			tips[j], tips[j-1] = tips[j-1], tips[j]
+		}
+	}
+	return tips
+}
+
+// ReorgDepth returns the number of blocks that are disconnected from
+// the chain ending in block 'from' when switching to the chain ending
+// in block 'to'.
+func (c *HeaderChain) ReorgDepth(from, to string) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	a, ok := c.nodes[from]
+	if !ok {
+		return 0, ErrChainUnknown
+	}
+	b, ok := c.nodes[to]
+	if !ok {
+		return 0, ErrChainUnknown
+	}
+	return a.height - fork(a, b).height, nil
+}
+
+//----------------------------------------------------------------------
+// helper methods (called with lock held)
+//----------------------------------------------------------------------
+
+// tip returns the chain tip for a node.
+func (c *HeaderChain) tip(n *chainNode, status string) *ChainTip {
+	return &ChainTip{
+		Height:    n.height,
+		Hash:      n.id,
+		BranchLen: n.height - fork(n, c.best).height,
+		Status:    status,
+	}
+}
+
+// mark a node and its descendants as (in)valid. A node is only
+// revalidated if no ancestor has failed.
+func (c *HeaderChain) mark(n *chainNode, invalid bool) {
+	if !invalid {
+		invalid = n.failed || (n.parent != nil && n.parent.invalid)
+	}
+	n.invalid = invalid
+	for _, k := range c.kids[n.id] {
+		c.mark(k, invalid || k.failed)
+	}
+}
+
+// clear explicit failures of a node and its descendants.
+func (c *HeaderChain) clear(n *chainNode) {
+	n.failed = false
+	for _, k := range c.kids[n.id] {
+		c.clear(k)
+	}
+}
+
+// choose the valid node with most work as new tip (first received wins
+// on equal work).
+func (c *HeaderChain) choose() *ChainEvent {
+	var best *chainNode
+	for _, n := range c.nodes {
+		if n.invalid {
+			continue
+		}
+		if best == nil {
+			best = n
+			continue
+		}
+		if cmp := n.work.Cmp(best.work); cmp > 0 || (cmp == 0 && n.seq < best.seq) {
+			best = n
+		}
+	}
+	if best == c.best {
+		return nil
+	}
+	return c.activate(best)
+}
+
+// activate a new chain tip and notify subscribers.
+func (c *HeaderChain) activate(n *chainNode) *ChainEvent {
+	old := c.tip(c.best, TipActive)
+	f := fork(c.best, n)
+	c.best = n
+	ev := &ChainEvent{
+		Old:          old,
+		New:          c.tip(n, TipActive),
+		Fork:         f.height,
+		Disconnected: old.Height - f.height,
+		Connected:    n.height - f.height,
+	}
+	for _, ch := range c.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	return ev
+}
+
+// fork returns the common ancestor of two nodes.
+func fork(a, b *chainNode) *chainNode {
+	for a.height > b.height {
+		a = a.parent
+	}
+	for b.height > a.height {
+		b = b.parent
+	}
+	for a != b {
+		a, b = a.parent, b.parent
+	}
+	return a
+}
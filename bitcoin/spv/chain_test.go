@@ -0,0 +1,206 @@
+package spv
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// regtest genesis block
+var (
+	genesisID     = "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"
+	genesisMerkle = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+)
+
+func regtestGenesis(t *testing.T) *Header {
+	mr, err := hex.DecodeString(genesisMerkle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Header{
+		Version:    1,
+		PrevBlock:  make([]byte, 32),
+		MerkleRoot: reverse(mr),
+		Time:       1296688602,
+		Bits:       0x207fffff,
+		Nonce:      2,
+	}
+}
+
+// mine a regtest header on top of 'prev'
+func mine(prev *Header, tag byte) *Header {
+	h := &Header{
+		Version:    4,
+		PrevBlock:  prev.Hash(),
+		MerkleRoot: make([]byte, 32),
+		Time:       prev.Time + 600,
+		Bits:       0x207fffff,
+	}
+	h.MerkleRoot[0] = tag
+	for !h.CheckPoW() {
+		h.Nonce++
+	}
+	return h
+}
+
+// mine a branch of 'n' headers on top of 'prev'
+func branch(t *testing.T, c *HeaderChain, prev *Header, n int, tag byte) (list []*Header) {
+	for i := 0; i < n; i++ {
+		prev = mine(prev, tag)
+		if _, err := c.Add(prev); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, prev)
+	}
+	return
+}
+
+func TestHeader(t *testing.T) {
+	g := regtestGenesis(t)
+	if g.ID() != genesisID {
+		t.Fatalf("genesis: %s", g.ID())
+	}
+	if !g.CheckPoW() {
+		t.Fatal("genesis PoW failed")
+	}
+	h, err := ParseHeader(g.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ID() != genesisID {
+		t.Fatal("parse mismatch")
+	}
+	if _, err = ParseHeader(g.Bytes()[:79]); err != ErrHeaderSize {
+		t.Fatal("short header accepted")
+	}
+	// mainnet genesis difficulty
+	if CompactToTarget(0x1d00ffff).Cmp(CompactToTarget(0x207fffff)) >= 0 {
+		t.Fatal("target order")
+	}
+	if g.Work().Int64() != 2 {
+		t.Fatalf("regtest work: %s", g.Work())
+	}
+}
+
+func TestChainReorg(t *testing.T) {
+	g := regtestGenesis(t)
+	c := NewHeaderChain(g)
+	events := c.Subscribe(16)
+
+	// active chain: 5 blocks
+	a := branch(t, c, g, 5, 'a')
+	if c.Height() != 5 || c.Best().Hash != a[4].ID() {
+		t.Fatal("active chain")
+	}
+	// fork at height 2 with equal work: no reorg
+	b := branch(t, c, a[1], 3, 'b')
+	if c.Best().Hash != a[4].ID() {
+		t.Fatal("reorg on equal work")
+	}
+	for len(events) > 0 {
+		<-events
+	}
+	// extend fork: reorg of depth 3
+	ev, err := c.Add(mine(b[2], 'b'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev == nil || !ev.Reorg() || ev.Fork != 2 || ev.Disconnected != 3 || ev.Connected != 4 {
+		t.Fatalf("reorg event: %+v", ev)
+	}
+	if e := <-events; e != ev {
+		t.Fatal("event not delivered")
+	}
+	if d, _ := c.ReorgDepth(a[4].ID(), c.Best().Hash); d != 3 {
+		t.Fatalf("reorg depth: %d", d)
+	}
+	tips := c.Tips()
+	if len(tips) != 2 || tips[0].Status != TipActive || tips[0].Height != 6 {
+		t.Fatalf("tips: %+v", tips)
+	}
+	if tips[1].Status != TipValidHeaders || tips[1].BranchLen != 3 {
+		t.Fatalf("fork tip: %+v", tips[1])
+	}
+
+	// duplicates and orphans
+	if _, err = c.Add(a[0]); err != ErrChainDuplicate {
+		t.Fatal("duplicate accepted")
+	}
+	orphan := mine(mine(a[4], 'x'), 'x')
+	if _, err = c.Add(orphan); err != ErrChainOrphan {
+		t.Fatal("orphan accepted")
+	}
+	// insufficient PoW
+	bad := mine(a[4], 'y')
+	bad.Bits = 0x1d00ffff
+	if _, err = c.Add(bad); err != ErrHeaderPoW {
+		t.Fatal("bad PoW accepted")
+	}
+}
+
+func TestChainInvalidate(t *testing.T) {
+	g := regtestGenesis(t)
+	c := NewHeaderChain(g)
+	a := branch(t, c, g, 4, 'a')
+	b := branch(t, c, a[0], 5, 'b')
+	if c.Best().Hash != b[4].ID() {
+		t.Fatal("fork not active")
+	}
+	// invalidate fork: back to original chain
+	ev, err := c.Invalidate(b[1].ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev == nil || ev.New.Hash != a[3].ID() || ev.Disconnected != 5 {
+		t.Fatalf("invalidate event: %+v", ev)
+	}
+	var invalid int
+	for _, tip := range c.Tips() {
+		if tip.Status == TipInvalid {
+			invalid++
+			if tip.Hash != b[4].ID() {
+				t.Fatal("wrong invalid tip")
+			}
+		}
+	}
+	if invalid != 1 {
+		t.Fatal("missing invalid tip")
+	}
+	// headers on invalid branch are rejected
+	if _, err = c.Add(mine(b[4], 'b')); err != ErrChainInvalid {
+		t.Fatal("invalid branch extended")
+	}
+	// invalidate active tip: previous block becomes active
+	if ev, err = c.Invalidate(a[3].ID()); err != nil || ev.New.Hash != a[2].ID() {
+		t.Fatal("invalidate tip")
+	}
+	if _, err = c.Invalidate(genesisID); err != ErrChainGenesis {
+		t.Fatal("genesis invalidated")
+	}
+	// reconsider fork
+	if ev, err = c.Reconsider(b[1].ID()); err != nil || ev == nil {
+		t.Fatal("reconsider")
+	}
+	if c.Best().Height != 7 {
+		t.Fatalf("best after reconsider: %+v", c.Best())
+	}
+}
@@ -0,0 +1,143 @@
+package spv
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/math"
+)
+
+//======================================================================
+// Block headers (80 bytes) and proof-of-work.
+//======================================================================
+
+// HeaderSize is the size of a serialized block header
+const HeaderSize = 80
+
+// Error codes
+var (
+	ErrHeaderSize = errors.New("invalid header size")
+	ErrHeaderPoW  = errors.New("insufficient proof-of-work")
+)
+
+// Header of a block
+type Header struct {
+	Version    int32  // block version
+	PrevBlock  []byte // hash of previous block (internal byte order)
+	MerkleRoot []byte // Merkle root of transactions (internal byte order)
+	Time       uint32 // block time (Unix epoch)
+	Bits       uint32 // difficulty target (compact form)
+	Nonce      uint32 // nonce
+}
+
+// ParseHeader reads a serialized block header.
+func ParseHeader(buf []byte) (*Header, error) {
+	if len(buf) != HeaderSize {
+		return nil, ErrHeaderSize
+	}
+	return &Header{
+		Version:    int32(binary.LittleEndian.Uint32(buf[0:4])),
+		PrevBlock:  bytes.Clone(buf[4:36]),
+		MerkleRoot: bytes.Clone(buf[36:68]),
+		Time:       binary.LittleEndian.Uint32(buf[68:72]),
+		Bits:       binary.LittleEndian.Uint32(buf[72:76]),
+		Nonce:      binary.LittleEndian.Uint32(buf[76:80]),
+	}, nil
+}
+
+// Bytes returns the serialized header.
+func (h *Header) Bytes() []byte {
+	buf := make([]byte, HeaderSize)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(h.Version))
+	copy(buf[4:36], h.PrevBlock)
+	copy(buf[36:68], h.MerkleRoot)
+	binary.LittleEndian.PutUint32(buf[68:72], h.Time)
+	binary.LittleEndian.PutUint32(buf[72:76], h.Bits)
+	binary.LittleEndian.PutUint32(buf[76:80], h.Nonce)
+	return buf
+}
+
+// Hash of the header (internal byte order)
+func (h *Header) Hash() []byte {
+	return bitcoin.Hash256(h.Bytes())
+}
+
+// ID returns the block hash as displayed (reversed byte order, hex).
+func (h *Header) ID() string {
+	return HashID(h.Hash())
+}
+
+// HashID converts a hash in internal byte order into a block ID.
+func HashID(hash []byte) string {
+	return hex.EncodeToString(reverse(hash))
+}
+
+// Target returns the difficulty target of the header.
+func (h *Header) Target() *math.Int {
+	return CompactToTarget(h.Bits)
+}
+
+// Work returns the expected number of hashes for the header target
+// (computed as '2^256 / (target+1)').
+func (h *Header) Work() *math.Int {
+	t := h.Target()
+	if t.Sign() <= 0 {
+		return math.ZERO
+	}
+	return math.TWO.Pow(256).Div(t.Add(math.ONE))
+}
+
+// CheckPoW returns true if the header hash satisfies its target.
+func (h *Header) CheckPoW() bool {
+	t := h.Target()
+	if t.Sign() <= 0 {
+		return false
+	}
+	return math.NewIntFromBytes(reverse(h.Hash())).Cmp(t) <= 0
+}
+
+// CompactToTarget converts a compact difficulty representation into
+// the target value (negative and overflowing targets are returned as 0).
+func CompactToTarget(bits uint32) *math.Int {
+	exp := uint(bits >> 24)
+	mant := int64(bits & 0x007fffff)
+	if bits&0x00800000 != 0 || exp > 32 {
+		return math.ZERO
+	}
+	if exp <= 3 {
+		return math.NewInt(mant >> (8 * (3 - exp)))
+	}
+	return math.NewInt(mant).Lsh(8 * (exp - 3))
+}
+
+// reverse the byte order of a hash
+func reverse(buf []byte) []byte {
+	out := make([]byte, len(buf))
+	for i, b := range buf {
+		out[len(buf)-1-i] = b
+	}
+	return out
+}
@@ -0,0 +1,397 @@
+package spv
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/bitcoin/script"
+	"github.com/bfix/gospel/bitcoin/wallet"
+	gerr "github.com/bfix/gospel/errors"
+)
+
+//======================================================================
+// Signet (BIP325): blocks on a signet must carry a solution for the
+// network challenge script in the witness commitment of the coinbase
+// transaction. The solution is verified against a virtual transaction
+// ("to_sign") that spends the challenge in a virtual transaction
+// ("to_spend") committing to the block header (with the Merkle root
+// computed without the solution).
+//
+// Solutions are verified by the script runtime; as the runtime only
+// supports legacy signature hashes (SIGHASH_ALL), challenges must be
+// non-segwit scripts (like the default 1-of-2 multisig challenge).
+//======================================================================
+
+// Error codes
+var (
+	ErrSignetSolution  = errors.New("invalid signet solution")
+	ErrSignetSegwit    = errors.New("segwit signet challenges not supported")
+	ErrSignetChallenge = errors.New("invalid signet challenge")
+	ErrSignetBlock     = errors.New("invalid signet block signature")
+	ErrSignetCoinbase  = errors.New("not a coinbase transaction")
+	ErrSignetMerkle    = errors.New("coinbase not committed to by header")
+)
+
+var (
+	// signetHeader tags the solution in the witness commitment
+	signetHeader = []byte{0xec, 0xc7, 0xda, 0xa2}
+	// witnessHeader starts the witness commitment output script
+	witnessHeader = []byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}
+)
+
+// SignetGenesis returns the genesis block header of signets.
+func SignetGenesis() *Header {
+	mr, _ := hex.DecodeString("4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b")
+	return &Header{
+		Version:    1,
+		PrevBlock:  make([]byte, 32),
+		MerkleRoot: reverse(mr),
+		Time:       1598918400,
+		Bits:       0x1e0377ae,
+		Nonce:      52613770,
+	}
+}
+
+// SignetMagic returns the network message start bytes of a signet
+// (first four bytes of the hash of the serialized challenge).
+func SignetMagic(challenge []byte) []byte {
+	buf := new(bytes.Buffer)
+	writeVarBytes(buf, challenge)
+	return bitcoin.Hash256(buf.Bytes())[:4]
+}
+
+// SignetSolution is the solution for a signet challenge.
+type SignetSolution struct {
+	ScriptSig []byte   // signature script
+	Witness   [][]byte // witness stack
+}
+
+// ParseSignetSolution extracts the signet solution from a coinbase
+// transaction. It returns the solution (empty if none is included)
+// and a copy of the coinbase transaction with the solution removed
+// (as used for computing the signet Merkle root).
+func ParseSignetSolution(coinbase *Tx) (*SignetSolution, *Tx, error) {
+	if len(coinbase.Inputs) != 1 {
+		return nil, nil, ErrSignetCoinbase
+	}
+	sol := new(SignetSolution)
+	// copy coinbase (shallow; outputs are replaced if modified)
+	cb := *coinbase
+	cb.Outputs = append([]*TxOut(nil), coinbase.Outputs...)
+
+	// find witness commitment (last matching output)
+	idx := -1
+	for i, out := range cb.Outputs {
+		if len(out.Script) >= 38 && bytes.HasPrefix(out.Script, witnessHeader) {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return sol, &cb, nil
+	}
+	// scan pushes for the signet solution; the push is replaced by the
+	// signet header only.
+	var (
+		data  []byte
+		found bool
+		repl  = new(bytes.Buffer)
+		code  = cb.Outputs[idx].Script
+	)
+	for len(code) > 0 {
+		op, push, rest, ok := scriptOp(code)
+		if !ok {
+			return nil, nil, ErrSignetSolution
+		}
+		code = rest
+		if len(push) == 0 {
+			repl.WriteByte(op)
+			continue
+		}
+		if !found && len(push) > len(signetHeader) && bytes.HasPrefix(push, signetHeader) {
+			data = push[len(signetHeader):]
+			push = signetHeader
+			found = true
+		}
+		writePush(repl, push)
+	}
+	cb.Outputs[idx] = &TxOut{
+		Value:  cb.Outputs[idx].Value,
+		Script: repl.Bytes(),
+	}
+	if !found {
+		return sol, &cb, nil
+	}
+	// decode solution
+	rdr := &txReader{buf: data}
+	sol.ScriptSig = rdr.varBytes()
+	if len(rdr.buf) > 0 {
+		sol.Witness = rdr.stack()
+	}
+	if rdr.err != nil || len(rdr.buf) > 0 {
+		return nil, nil, ErrSignetSolution
+	}
+	return sol, &cb, nil
+}
+
+// Signet validates blocks for a signet challenge.
+type Signet struct {
+	Challenge []byte // challenge script
+}
+
+// NewSignet creates a validator for a signet challenge (nil for the
+// default signet).
+func NewSignet(challenge []byte) *Signet {
+	if challenge == nil {
+		challenge = wallet.DefaultSignetChallenge
+	}
+	return &Signet{Challenge: challenge}
+}
+
+// Magic returns the network message start bytes.
+func (s *Signet) Magic() []byte {
+	return SignetMagic(s.Challenge)
+}
+
+// CheckBlock verifies the signet solution of a block. The coinbase
+// transaction must be committed to by the header; its inclusion is
+// proven by the Merkle branch (as returned by MerkleBranch for position
+// 0). The genesis block needs no solution.
+func (s *Signet) CheckBlock(hdr *Header, coinbase *Tx, branch [][]byte) error {
+	if bytes.Equal(hdr.Hash(), SignetGenesis().Hash()) {
+		return nil
+	}
+	if !bytes.Equal(MerkleRootFromBranch(coinbase.Hash(), 0, branch), hdr.MerkleRoot) {
+		return ErrSignetMerkle
+	}
+	sol, cb, err := ParseSignetSolution(coinbase)
+	if err != nil {
+		return err
+	}
+	root := MerkleRootFromBranch(cb.Hash(), 0, branch)
+	return s.CheckSolution(hdr, root, sol)
+}
+
+// CheckSolution verifies a solution for a block header; 'root' is the
+// Merkle root computed with the solution removed from the coinbase.
+func (s *Signet) CheckSolution(hdr *Header, root []byte, sol *SignetSolution) error {
+	if isWitnessProgram(s.Challenge) {
+		return ErrSignetSegwit
+	}
+	if len(sol.Witness) > 0 {
+		return gerr.New(ErrSignetSolution, "unexpected witness")
+	}
+	toSign := s.ToSign(hdr, root, sol)
+
+	// assemble script (solution and challenge)
+	sigScr, rc := script.ParseBin(sol.ScriptSig)
+	if rc != script.RcOK {
+		return gerr.New(ErrSignetSolution, "%s", script.RcString[rc])
+	}
+	for _, stmt := range sigScr.Stmts {
+		if stmt.Opcode > script.Op16 {
+			return gerr.New(ErrSignetSolution, "script not push-only")
+		}
+	}
+	chScr, rc := script.ParseBin(s.Challenge)
+	if rc != script.RcOK {
+		return gerr.New(ErrSignetChallenge, "%s", script.RcString[rc])
+	}
+	scr := script.NewScript()
+	scr.Stmts = append(scr.Stmts, sigScr.Stmts...)
+	scr.Stmts = append(scr.Stmts, chScr.Stmts...)
+
+	// data to be signed: 'to_sign' with the challenge as script code
+	// (the signature hash type is appended by the runtime).
+	cpy := *toSign
+	cpy.Inputs = []*TxIn{{
+		PrevHash:  toSign.Inputs[0].PrevHash,
+		PrevIndex: toSign.Inputs[0].PrevIndex,
+		Script:    s.Challenge,
+	}}
+	tx := &script.Tx{
+		SignedData: cpy.Bytes(false),
+	}
+	r := script.NewRuntime(tx)
+	ok, rc := r.ExecScript(scr)
+	if rc != script.RcOK {
+		return gerr.New(ErrSignetBlock, "%s", script.RcString[rc])
+	}
+	if !ok {
+		return ErrSignetBlock
+	}
+	return nil
+}
+
+// ToSpend returns the virtual transaction committing to the block
+// header and paying to the challenge.
+func (s *Signet) ToSpend(hdr *Header, root []byte) *Tx {
+	// block data: version, previous block, signet Merkle root and time
+	data := make([]byte, 72)
+	binary.LittleEndian.PutUint32(data[0:4], uint32(hdr.Version))
+	copy(data[4:36], hdr.PrevBlock)
+	copy(data[36:68], root)
+	binary.LittleEndian.PutUint32(data[68:72], hdr.Time)
+	sig := new(bytes.Buffer)
+	sig.WriteByte(0)
+	writePush(sig, data)
+	return &Tx{
+		Inputs: []*TxIn{{
+			PrevHash:  make([]byte, 32),
+			PrevIndex: 0xffffffff,
+			Script:    sig.Bytes(),
+		}},
+		Outputs: []*TxOut{{Script: s.Challenge}},
+	}
+}
+
+// ToSign returns the virtual transaction spending 'to_spend' with the
+// solution.
+func (s *Signet) ToSign(hdr *Header, root []byte, sol *SignetSolution) *Tx {
+	return &Tx{
+		Inputs: []*TxIn{{
+			PrevHash: s.ToSpend(hdr, root).Hash(),
+			Script:   sol.ScriptSig,
+			Witness:  sol.Witness,
+		}},
+		Outputs: []*TxOut{{Script: []byte{script.OpRETURN}}},
+	}
+}
+
+// SignatureHash returns the hash signed by a solution (SIGHASH_ALL).
+func (s *Signet) SignatureHash(hdr *Header, root []byte) []byte {
+	tx := s.ToSign(hdr, root, new(SignetSolution))
+	tx.Inputs[0].Script = s.Challenge
+	return bitcoin.Hash256(append(tx.Bytes(false), 1, 0, 0, 0))
+}
+
+// AddSignetSolution adds a solution to the coinbase transaction of a block: the
+// solution is appended to the witness commitment output (which must
+// exist). Returns the modified coinbase.
+func AddSignetSolution(coinbase *Tx, sol *SignetSolution) (*Tx, error) {
+	_, cb, err := ParseSignetSolution(coinbase)
+	if err != nil {
+		return nil, err
+	}
+	idx := -1
+	for i, out := range cb.Outputs {
+		if len(out.Script) >= 38 && bytes.HasPrefix(out.Script, witnessHeader) {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil, gerr.New(ErrSignetSolution, "no witness commitment")
+	}
+	// replace existing (empty) solution or append a new one
+	code := cb.Outputs[idx].Script
+	tag := concat([]byte{byte(len(signetHeader))}, signetHeader)
+	if bytes.HasSuffix(code, tag) {
+		code = code[:len(code)-len(tag)]
+	}
+	buf := new(bytes.Buffer)
+	writeVarBytes(buf, sol.ScriptSig)
+	if len(sol.Witness) > 0 {
+		writeVarInt(buf, uint64(len(sol.Witness)))
+		for _, item := range sol.Witness {
+			writeVarBytes(buf, item)
+		}
+	}
+	out := bytes.NewBuffer(bytes.Clone(code))
+	writePush(out, concat(signetHeader, buf.Bytes()))
+	cb.Outputs[idx] = &TxOut{
+		Value:  cb.Outputs[idx].Value,
+		Script: out.Bytes(),
+	}
+	return cb, nil
+}
+
+//----------------------------------------------------------------------
+// helpers
+//----------------------------------------------------------------------
+
+// isWitnessProgram returns true for segwit output scripts.
+func isWitnessProgram(scr []byte) bool {
+	n := len(scr)
+	if n < 4 || n > 42 {
+		return false
+	}
+	if scr[0] != script.OpFALSE && (scr[0] < script.OpTRUE || scr[0] > script.Op16) {
+		return false
+	}
+	return int(scr[1])+2 == n
+}
+
+// scriptOp reads the next operation from a script; returns the opcode,
+// the pushed data (if any) and the remaining script.
+func scriptOp(code []byte) (op byte, push, rest []byte, ok bool) {
+	op = code[0]
+	code = code[1:]
+	var n int
+	switch {
+	case op > 0 && op < script.OpPUSHDATA1:
+		n = int(op)
+	case op == script.OpPUSHDATA1:
+		if len(code) < 1 {
+			return
+		}
+		n, code = int(code[0]), code[1:]
+	case op == script.OpPUSHDATA2:
+		if len(code) < 2 {
+			return
+		}
+		n, code = int(binary.LittleEndian.Uint16(code)), code[2:]
+	case op == script.OpPUSHDATA4:
+		if len(code) < 4 {
+			return
+		}
+		n, code = int(binary.LittleEndian.Uint32(code)), code[4:]
+	default:
+		return op, nil, code, true
+	}
+	if n > len(code) {
+		return
+	}
+	return op, code[:n], code[n:], true
+}
+
+// writePush writes a (minimal) data push to a script.
+func writePush(buf *bytes.Buffer, data []byte) {
+	n := len(data)
+	switch {
+	case n < script.OpPUSHDATA1:
+		buf.WriteByte(byte(n))
+	case n <= 0xff:
+		buf.Write([]byte{script.OpPUSHDATA1, byte(n)})
+	case n <= 0xffff:
+		buf.WriteByte(script.OpPUSHDATA2)
+		var b [2]byte
+		binary.LittleEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(script.OpPUSHDATA4)
+		writeUint32(buf, uint32(n))
+	}
+	buf.Write(data)
+}
@@ -0,0 +1,211 @@
+package spv
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+// coinbase transaction of the genesis block
+var genesisCoinbase = "01000000010000000000000000000000000000000000000000000000000000000000000000" +
+	"ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72" +
+	"206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f205" +
+	"2a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4c" +
+	"ef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+func TestTx(t *testing.T) {
+	buf, _ := hex.DecodeString(genesisCoinbase)
+	tx, err := ParseTx(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.ID() != genesisMerkle {
+		t.Fatalf("txid: %s", tx.ID())
+	}
+	if !bytes.Equal(tx.Bytes(true), buf) {
+		t.Fatal("serialization mismatch")
+	}
+	if !bytes.Equal(MerkleRoot([][]byte{tx.Hash()}), tx.Hash()) {
+		t.Fatal("merkle root mismatch")
+	}
+	if _, err := ParseTx(append(buf, 0)); err != ErrTxTrailing {
+		t.Fatal("trailing data accepted")
+	}
+	if _, err := ParseTx(buf[:50]); err != ErrTxFormat {
+		t.Fatal("truncated tx accepted")
+	}
+}
+
+func TestMerkleBranch(t *testing.T) {
+	var hashes [][]byte
+	for i := 0; i < 7; i++ {
+		hashes = append(hashes, bitcoin.Hash256([]byte{byte(i)}))
+	}
+	root := MerkleRoot(hashes)
+	for i, h := range hashes {
+		if !bytes.Equal(MerkleRootFromBranch(h, i, MerkleBranch(hashes, i)), root) {
+			t.Fatalf("branch %d failed", i)
+		}
+	}
+}
+
+func TestSignetParams(t *testing.T) {
+	if hex.EncodeToString(NewSignet(nil).Magic()) != "0a03cf40" {
+		t.Fatal("signet magic mismatch")
+	}
+	g := SignetGenesis()
+	if g.ID() != "00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6" {
+		t.Fatalf("signet genesis: %s", g.ID())
+	}
+	if !g.CheckPoW() {
+		t.Fatal("signet genesis PoW failed")
+	}
+	if err := NewSignet(nil).CheckBlock(g, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signet block with a segwit coinbase and additional transactions
+func signetBlock() (*Header, *Tx, [][]byte) {
+	commit := append([]byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}, make([]byte, 32)...)
+	cb := &Tx{
+		Version: 2,
+		Inputs: []*TxIn{{
+			PrevHash:  make([]byte, 32),
+			PrevIndex: 0xffffffff,
+			Script:    []byte{0x01, 0x07},
+			Sequence:  0xffffffff,
+			Witness:   [][]byte{make([]byte, 32)},
+		}},
+		Outputs: []*TxOut{
+			{Value: 5000000000, Script: []byte{0x51}},
+			{Value: 0, Script: commit},
+		},
+	}
+	hdr := &Header{
+		Version:   0x20000000,
+		PrevBlock: SignetGenesis().Hash(),
+		Time:      1598918400 + 600,
+		Bits:      0x207fffff,
+	}
+	others := [][]byte{
+		bitcoin.Hash256([]byte("tx1")),
+		bitcoin.Hash256([]byte("tx2")),
+	}
+	return hdr, cb, others
+}
+
+// sign a signet block: returns the coinbase with solution and the
+// coinbase Merkle branch
+func signBlock(t *testing.T, s *Signet, hdr *Header, cb *Tx, others [][]byte, prv *bitcoin.PrivateKey) (*Tx, [][]byte) {
+	// compute signet Merkle root (solution removed)
+	dummy, err := AddSignetSolution(cb, &SignetSolution{ScriptSig: []byte{0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, stripped, err := ParseSignetSolution(dummy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := append([][]byte{stripped.Hash()}, others...)
+	root := MerkleRoot(hashes)
+
+	// sign block and add solution
+	sig := bitcoin.Sign(prv, s.SignatureHash(hdr, root)).LowS()
+	sigBuf := append(sig.DER(), 1)
+	scr := append([]byte{0, byte(len(sigBuf))}, sigBuf...)
+	signed, err := AddSignetSolution(cb, &SignetSolution{ScriptSig: scr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes[0] = signed.Hash()
+	hdr.MerkleRoot = MerkleRoot(hashes)
+	return signed, MerkleBranch(hashes, 0)
+}
+
+func TestSignetBlock(t *testing.T) {
+	// 1-of-2 multisig challenge
+	k1 := bitcoin.GenerateKeys(true)
+	k2 := bitcoin.GenerateKeys(true)
+	challenge := []byte{0x51, 0x21}
+	challenge = append(challenge, k1.PublicKey.Bytes()...)
+	challenge = append(challenge, 0x21)
+	challenge = append(challenge, k2.PublicKey.Bytes()...)
+	challenge = append(challenge, 0x52, 0xae)
+	s := NewSignet(challenge)
+
+	hdr, cb, others := signetBlock()
+	signed, branch := signBlock(t, s, hdr, cb, others, k2)
+	if err := s.CheckBlock(hdr, signed, branch); err != nil {
+		t.Fatal(err)
+	}
+	// round-trip of signed coinbase
+	tx, err := ParseTx(signed.Bytes(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.CheckBlock(hdr, tx, branch); err != nil {
+		t.Fatal(err)
+	}
+	// header changes invalidate the signature
+	hdr2 := *hdr
+	hdr2.Time++
+	if err = s.CheckBlock(&hdr2, signed, branch); !errors.Is(err, ErrSignetBlock) {
+		t.Fatalf("modified header: %v", err)
+	}
+	// coinbase not committed
+	if err = s.CheckBlock(hdr, cb, branch); err != ErrSignetMerkle {
+		t.Fatalf("modified coinbase: %v", err)
+	}
+	// signature by unknown key
+	hdr, cb, others = signetBlock()
+	signed, branch = signBlock(t, s, hdr, cb, others, bitcoin.GenerateKeys(true))
+	if err = s.CheckBlock(hdr, signed, branch); !errors.Is(err, ErrSignetBlock) {
+		t.Fatalf("foreign key: %v", err)
+	}
+	// missing solution
+	hashes := append([][]byte{cb.Hash()}, others...)
+	hdr.MerkleRoot = MerkleRoot(hashes)
+	if err = s.CheckBlock(hdr, cb, MerkleBranch(hashes, 0)); err == nil {
+		t.Fatal("missing solution accepted")
+	}
+}
+
+func TestSignetTrivial(t *testing.T) {
+	// OP_TRUE challenge needs no solution
+	s := NewSignet([]byte{0x51})
+	hdr, cb, others := signetBlock()
+	hashes := append([][]byte{cb.Hash()}, others...)
+	hdr.MerkleRoot = MerkleRoot(hashes)
+	if err := s.CheckBlock(hdr, cb, MerkleBranch(hashes, 0)); err != nil {
+		t.Fatal(err)
+	}
+	// segwit challenges are rejected
+	s = NewSignet(append([]byte{0, 20}, make([]byte, 20)...))
+	if err := s.CheckBlock(hdr, cb, MerkleBranch(hashes, 0)); err != ErrSignetSegwit {
+		t.Fatalf("segwit challenge: %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+package spv
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+//======================================================================
+// Minimal transaction codec: enough to dissect coinbase transactions,
+// compute transaction IDs and Merkle roots and to assemble the virtual
+// transactions used for signet block signatures.
+//======================================================================
+
+// Error codes
+var (
+	ErrTxFormat   = errors.New("invalid transaction format")
+	ErrTxTrailing = errors.New("trailing data after transaction")
+)
+
+// TxIn is a transaction input
+type TxIn struct {
+	PrevHash  []byte   // hash of previous transaction (internal byte order)
+	PrevIndex uint32   // index of output in previous transaction
+	Script    []byte   // signature script
+	Sequence  uint32   // sequence number
+	Witness   [][]byte // witness stack (segwit only)
+}
+
+// TxOut is a transaction output
+type TxOut struct {
+	Value  int64  // amount in satoshi
+	Script []byte // public key script
+}
+
+// Tx is a (serialized) Bitcoin transaction
+type Tx struct {
+	Version  int32
+	Inputs   []*TxIn
+	Outputs  []*TxOut
+	LockTime uint32
+}
+
+// ParseTx reads a serialized transaction (with or without witness data).
+func ParseTx(buf []byte) (*Tx, error) {
+	rdr := &txReader{buf: buf}
+	tx := new(Tx)
+	tx.Version = int32(rdr.uint32())
+	segwit := false
+	if len(rdr.buf) > 1 && rdr.buf[0] == 0 && rdr.buf[1] == 1 {
+		segwit = true
+		rdr.buf = rdr.buf[2:]
+	}
+	n := rdr.varInt()
+	for i := uint64(0); i < n && rdr.err == nil; i++ {
+		in := &TxIn{
+			PrevHash:  rdr.bytes(32),
+			PrevIndex: rdr.uint32(),
+			Script:    rdr.varBytes(),
+			Sequence:  rdr.uint32(),
+		}
+		tx.Inputs = append(tx.Inputs, in)
+	}
+	n = rdr.varInt()
+	for i := uint64(0); i < n && rdr.err == nil; i++ {
+		out := &TxOut{
+			Value:  int64(rdr.uint64()),
+			Script: rdr.varBytes(),
+		}
+		tx.Outputs = append(tx.Outputs, out)
+	}
+	if segwit {
+		for _, in := range tx.Inputs {
+			in.Witness = rdr.stack()
+		}
+	}
+	tx.LockTime = rdr.uint32()
+	if rdr.err != nil {
+		return nil, rdr.err
+	}
+	if len(rdr.buf) > 0 {
+		return nil, ErrTxTrailing
+	}
+	return tx, nil
+}
+
+// HasWitness returns true if any input carries witness data.
+func (tx *Tx) HasWitness() bool {
+	for _, in := range tx.Inputs {
+		if len(in.Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Bytes returns the serialized transaction; witness data is included
+// only if requested (and present).
+func (tx *Tx) Bytes(witness bool) []byte {
+	witness = witness && tx.HasWitness()
+	buf := new(bytes.Buffer)
+	writeUint32(buf, uint32(tx.Version))
+	if witness {
+		buf.Write([]byte{0, 1})
+	}
+	writeVarInt(buf, uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		buf.Write(in.PrevHash)
+		writeUint32(buf, in.PrevIndex)
+		writeVarBytes(buf, in.Script)
+		writeUint32(buf, in.Sequence)
+	}
+	writeVarInt(buf, uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		var v [8]byte
+		binary.LittleEndian.PutUint64(v[:], uint64(out.Value))
+		buf.Write(v[:])
+		writeVarBytes(buf, out.Script)
+	}
+	if witness {
+		for _, in := range tx.Inputs {
+			writeVarInt(buf, uint64(len(in.Witness)))
+			for _, item := range in.Witness {
+				writeVarBytes(buf, item)
+			}
+		}
+	}
+	writeUint32(buf, tx.LockTime)
+	return buf.Bytes()
+}
+
+// Hash of the transaction without witness data (internal byte order)
+func (tx *Tx) Hash() []byte {
+	return bitcoin.Hash256(tx.Bytes(false))
+}
+
+// ID returns the transaction ID as displayed (reversed byte order, hex).
+func (tx *Tx) ID() string {
+	return HashID(tx.Hash())
+}
+
+//----------------------------------------------------------------------
+// Merkle trees
+//----------------------------------------------------------------------
+
+// MerkleRoot computes the Merkle root for a list of transaction hashes
+// (internal byte order).
+func MerkleRoot(hashes [][]byte) []byte {
+	if len(hashes) == 0 {
+		return make([]byte, 32)
+	}
+	level := hashes
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([][]byte, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, bitcoin.Hash256(concat(level[i], level[i+1])))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// MerkleBranch returns the list of sibling hashes required to prove the
+// inclusion of the transaction at 'pos' in the Merkle tree.
+func MerkleBranch(hashes [][]byte, pos int) (branch [][]byte) {
+	level := hashes
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		branch = append(branch, level[pos^1])
+		next := make([][]byte, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, bitcoin.Hash256(concat(level[i], level[i+1])))
+		}
+		level = next
+		pos /= 2
+	}
+	return
+}
+
+// MerkleRootFromBranch computes the Merkle root from a transaction hash,
+// its position in the block and the Merkle branch.
+func MerkleRootFromBranch(hash []byte, pos int, branch [][]byte) []byte {
+	for _, sib := range branch {
+		if pos&1 == 1 {
+			hash = bitcoin.Hash256(concat(sib, hash))
+		} else {
+			hash = bitcoin.Hash256(concat(hash, sib))
+		}
+		pos /= 2
+	}
+	return hash
+}
+
+//----------------------------------------------------------------------
+// helpers
+//----------------------------------------------------------------------
+
+// txReader reads serialized transaction fields; the first error is
+// sticky.
+type txReader struct {
+	buf []byte
+	err error
+}
+
+func (r *txReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if uint64(len(r.buf)) < n {
+		r.err = ErrTxFormat
+		return nil
+	}
+	out := bytes.Clone(r.buf[:n])
+	r.buf = r.buf[n:]
+	return out
+}
+
+func (r *txReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *txReader) uint64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *txReader) varInt() uint64 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	switch b[0] {
+	case 0xfd:
+		if v := r.bytes(2); v != nil {
+			return uint64(binary.LittleEndian.Uint16(v))
+		}
+	case 0xfe:
+		return uint64(r.uint32())
+	case 0xff:
+		return r.uint64()
+	default:
+		return uint64(b[0])
+	}
+	return 0
+}
+
+func (r *txReader) varBytes() []byte {
+	n := r.varInt()
+	if r.err == nil && n > uint64(len(r.buf)) {
+		r.err = ErrTxFormat
+	}
+	return r.bytes(n)
+}
+
+func (r *txReader) stack() (items [][]byte) {
+	n := r.varInt()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		items = append(items, r.varBytes())
+	}
+	return
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeVarInt(buf *bytes.Buffer, v uint64) {
+	switch {
+	case v < 0xfd:
+		buf.WriteByte(byte(v))
+	case v <= 0xffff:
+		buf.WriteByte(0xfd)
+		var b [2]byte
+		binary.LittleEndian.PutUint16(b[:], uint16(v))
+		buf.Write(b[:])
+	case v <= 0xffffffff:
+		buf.WriteByte(0xfe)
+		writeUint32(buf, uint32(v))
+	default:
+		buf.WriteByte(0xff)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], v)
+		buf.Write(b[:])
+	}
+}
+
+func writeVarBytes(buf *bytes.Buffer, data []byte) {
+	writeVarInt(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// concat byte slices into a new slice
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
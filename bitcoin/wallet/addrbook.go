@@ -0,0 +1,371 @@
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/data"
+)
+
+// Error codes
+var (
+	ErrBookUnknownAddr   = errors.New("unknown address")
+	ErrBookImportFormat  = errors.New("unsupported import entry")
+	ErrBookDescChecksum  = errors.New("invalid descriptor checksum")
+	ErrBookDescMalformed = errors.New("malformed descriptor")
+)
+
+//----------------------------------------------------------------------
+// Watch-only address book
+//----------------------------------------------------------------------
+
+// AddrBookEntry is an address (with metadata) in an address book
+type AddrBookEntry struct {
+	Address   string // address string
+	Label     string // label for address (can be empty)
+	Path      string // HD derivation path (can be empty)
+	FirstSeen int64  `order:"big"` // first seen (unix epoch)
+	LastUsed  int64  `order:"big"` // last used (unix epoch)
+}
+
+// addrBookData is the binary representation of an address book
+type addrBookData struct {
+	Count   uint32           `order:"big"`
+	Entries []*AddrBookEntry `size:"Count"`
+}
+
+// AddressBook holds a list of (watch-only) addresses.
+type AddressBook struct {
+	entries map[string]*AddrBookEntry
+	lock    sync.RWMutex
+}
+
+// NewAddressBook creates an empty address book
+func NewAddressBook() *AddressBook {
+	return &AddressBook{
+		entries: make(map[string]*AddrBookEntry),
+	}
+}
+
+// NewAddressBookFromBytes restores an address book from its binary
+// representation.
+func NewAddressBookFromBytes(buf []byte) (*AddressBook, error) {
+	d := new(addrBookData)
+	if err := data.Unmarshal(d, buf); err != nil {
+		return nil, err
+	}
+	ab := NewAddressBook()
+	for _, e := range d.Entries {
+		ab.entries[e.Address] = e
+	}
+	return ab, nil
+}
+
+// Bytes returns the binary representation of an address book (for
+// persistent storage).
+func (ab *AddressBook) Bytes() ([]byte, error) {
+	list := ab.List()
+	d := &addrBookData{
+		Count:   uint32(len(list)),
+		Entries: list,
+	}
+	return data.Marshal(d)
+}
+
+// Add an address with label and derivation path. An existing entry is
+// updated (label and path are only changed if not empty).
+func (ab *AddressBook) Add(addr, label, path string) *AddrBookEntry {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	e, ok := ab.entries[addr]
+	if !ok {
+		e = &AddrBookEntry{
+			Address:   addr,
+			FirstSeen: time.Now().Unix(),
+		}
+		ab.entries[addr] = e
+	}
+	if len(label) > 0 {
+		e.Label = label
+	}
+	if len(path) > 0 {
+		e.Path = path
+	}
+	return e
+}
+
+// Get entry for address (or nil if not found).
+func (ab *AddressBook) Get(addr string) *AddrBookEntry {
+	ab.lock.RLock()
+	defer ab.lock.RUnlock()
+	return ab.entries[addr]
+}
+
+// Remove address from book.
+func (ab *AddressBook) Remove(addr string) error {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	if _, ok := ab.entries[addr]; !ok {
+		return ErrBookUnknownAddr
+	}
+	delete(ab.entries, addr)
+	return nil
+}
+
+// SetLabel sets the label of an address.
+func (ab *AddressBook) SetLabel(addr, label string) error {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	e, ok := ab.entries[addr]
+	if !ok {
+		return ErrBookUnknownAddr
+	}
+	e.Label = label
+	return nil
+}
+
+// Touch marks an address as used at given time.
+func (ab *AddressBook) Touch(addr string, t time.Time) error {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	e, ok := ab.entries[addr]
+	if !ok {
+		return ErrBookUnknownAddr
+	}
+	ts := t.Unix()
+	if ts > e.LastUsed {
+		e.LastUsed = ts
+	}
+	if e.FirstSeen == 0 || ts < e.FirstSeen {
+		e.FirstSeen = ts
+	}
+	return nil
+}
+
+// List returns all entries (ordered by address).
+func (ab *AddressBook) List() []*AddrBookEntry {
+	ab.lock.RLock()
+	defer ab.lock.RUnlock()
+	list := make([]*AddrBookEntry, 0, len(ab.entries))
+	for _, e := range ab.entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Address < list[j].Address
+	})
+	return list
+}
+
+// ByLabel returns all entries with given label.
+func (ab *AddressBook) ByLabel(label string) []*AddrBookEntry {
+	var res []*AddrBookEntry
+	for _, e := range ab.List() {
+		if e.Label == label {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+//----------------------------------------------------------------------
+// Import/export in Bitcoin Core formats ('importmulti' and
+// 'importdescriptors' requests)
+//----------------------------------------------------------------------
+
+// importEntry is a request object for 'importmulti' or 'importdescriptors'
+type importEntry struct {
+	Desc         string          `json:"desc,omitempty"`
+	ScriptPubKey json.RawMessage `json:"scriptPubKey,omitempty"`
+	Timestamp    json.RawMessage `json:"timestamp"`
+	Label        string          `json:"label,omitempty"`
+	WatchOnly    bool            `json:"watchonly,omitempty"`
+}
+
+// ExportImportMulti returns the address book as a JSON request for
+// 'importmulti'.
+func (ab *AddressBook) ExportImportMulti() ([]byte, error) {
+	list := make([]*importEntry, 0)
+	for _, e := range ab.List() {
+		spk, _ := json.Marshal(map[string]string{"address": e.Address})
+		list = append(list, &importEntry{
+			ScriptPubKey: spk,
+			Timestamp:    exportTimestamp(e.FirstSeen),
+			Label:        e.Label,
+			WatchOnly:    true,
+		})
+	}
+	return json.Marshal(list)
+}
+
+// ExportDescriptors returns the address book as a JSON request for
+// 'importdescriptors'.
+func (ab *AddressBook) ExportDescriptors() ([]byte, error) {
+	list := make([]*importEntry, 0)
+	for _, e := range ab.List() {
+		desc := "addr(" + e.Address + ")"
+		list = append(list, &importEntry{
+			Desc:      desc + "#" + DescriptorChecksum(desc),
+			Timestamp: exportTimestamp(e.FirstSeen),
+			Label:     e.Label,
+		})
+	}
+	return json.Marshal(list)
+}
+
+// Import address entries from a JSON request for either 'importmulti'
+// or 'importdescriptors'. Only address-based entries are supported.
+// Returns the number of imported addresses.
+func (ab *AddressBook) Import(buf []byte) (int, error) {
+	var list []*importEntry
+	if err := json.Unmarshal(buf, &list); err != nil {
+		return 0, err
+	}
+	// parse all entries before changing the address book
+	type item struct {
+		addr, label string
+		ts          int64
+	}
+	items := make([]*item, 0, len(list))
+	for _, e := range list {
+		it := &item{label: e.Label}
+		switch {
+		case len(e.Desc) > 0:
+			desc, err := checkDescriptor(e.Desc)
+			if err != nil {
+				return 0, err
+			}
+			if !strings.HasPrefix(desc, "addr(") || !strings.HasSuffix(desc, ")") {
+				return 0, ErrBookImportFormat
+			}
+			it.addr = desc[5 : len(desc)-1]
+		case len(e.ScriptPubKey) > 0:
+			var spk struct {
+				Address string `json:"address"`
+			}
+			if err := json.Unmarshal(e.ScriptPubKey, &spk); err != nil || len(spk.Address) == 0 {
+				return 0, ErrBookImportFormat
+			}
+			it.addr = spk.Address
+		default:
+			return 0, ErrBookImportFormat
+		}
+		// timestamp is either a number or "now"
+		if err := json.Unmarshal(e.Timestamp, &it.ts); err != nil {
+			it.ts = time.Now().Unix()
+		}
+		items = append(items, it)
+	}
+	// add addresses to book
+	for _, it := range items {
+		e := ab.Add(it.addr, it.label, "")
+		ab.lock.Lock()
+		if it.ts < e.FirstSeen {
+			e.FirstSeen = it.ts
+		}
+		ab.lock.Unlock()
+	}
+	return len(items), nil
+}
+
+// timestamp for export (0 if unknown, "now" is never used as this would
+// skip the rescan of older transactions)
+func exportTimestamp(ts int64) json.RawMessage {
+	buf, _ := json.Marshal(ts)
+	return buf
+}
+
+//----------------------------------------------------------------------
+// Output descriptor checksum (BIP-380)
+//----------------------------------------------------------------------
+
+const (
+	descInputCharset    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	descChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+var descGenerator = []uint64{0xf5dee51989, 0xa9fdca3312, 0x1bb7cde5a, 0x3706b1677a, 0x644d626ffd}
+
+// DescriptorChecksum computes the checksum for an output descriptor
+// (without the '#' separator). Returns an empty string if the
+// descriptor contains invalid characters.
+func DescriptorChecksum(desc string) string {
+	c := uint64(1)
+	cls, clsCount := 0, 0
+	for _, ch := range desc {
+		pos := strings.IndexRune(descInputCharset, ch)
+		if pos == -1 {
+			return ""
+		}
+		c = descPolymod(c, pos&31)
+		cls = cls*3 + (pos >> 5)
+		if clsCount++; clsCount == 3 {
+			c = descPolymod(c, cls)
+			cls, clsCount = 0, 0
+		}
+	}
+	if clsCount > 0 {
+		c = descPolymod(c, cls)
+	}
+	for i := 0; i < 8; i++ {
+		c = descPolymod(c, 0)
+	}
+	c ^= 1
+	res := make([]byte, 8)
+	for i := range res {
+		res[i] = descChecksumCharset[(c>>(5*(7-i)))&31]
+	}
+	return string(res)
+}
+
+// checkDescriptor verifies an (optional) checksum and returns the
+// descriptor without checksum.
+func checkDescriptor(desc string) (string, error) {
+	parts := strings.Split(desc, "#")
+	switch len(parts) {
+	case 1:
+		return desc, nil
+	case 2:
+		if DescriptorChecksum(parts[0]) != parts[1] {
+			return "", ErrBookDescChecksum
+		}
+		return parts[0], nil
+	}
+	return "", ErrBookDescMalformed
+}
+
+// polymod step for descriptor checksum
+func descPolymod(c uint64, val int) uint64 {
+	c0 := c >> 35
+	c = ((c & 0x7ffffffff) << 5) ^ uint64(val)
+	for i, g := range descGenerator {
+		if (c0>>i)&1 != 0 {
+			c ^= g
+		}
+	}
+	return c
+}
@@ -0,0 +1,76 @@
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package wallet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDescriptorChecksum(t *testing.T) {
+	for _, desc := range []string{
+		"raw(deadbeef)#camjj878",
+		"addr(mkmZxiEcEd8ZqjQWVZuC6so5dFMKEFpN2j)#35tr9xup",
+	} {
+		if _, err := checkDescriptor(desc); err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+	}
+	if _, err := checkDescriptor("raw(deadbeef)#camjj877"); err != ErrBookDescChecksum {
+		t.Fatal("invalid checksum accepted")
+	}
+}
+
+func TestAddressBook(t *testing.T) {
+	ab := NewAddressBook()
+	ab.Add("1F3sAm6ZtwLAUnj7d38pGFxtP3RVEvtsbV", "donations", "m/44'/0'/0'/0/0")
+	ab.Add("bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", "savings", "")
+	if err := ab.Touch("bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// persistence
+	buf, err := ab.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ab2, err := NewAddressBookFromBytes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := ab2.Get("1F3sAm6ZtwLAUnj7d38pGFxtP3RVEvtsbV"); e == nil || e.Label != "donations" || e.Path != "m/44'/0'/0'/0/0" {
+		t.Fatal("restore failed")
+	}
+	// export/import
+	for _, export := range []func() ([]byte, error){ab.ExportImportMulti, ab.ExportDescriptors} {
+		buf, err := export()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ab3 := NewAddressBook()
+		n, err := ab3.Import(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 || len(ab3.ByLabel("savings")) != 1 {
+			t.Fatal("import failed: " + string(buf))
+		}
+	}
+}
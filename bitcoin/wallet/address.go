@@ -26,20 +26,21 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
-	"math/big"
 	"strings"
 
 	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/bitcoin/codec"
 	"github.com/bfix/gospel/bitcoin/script"
 	"golang.org/x/crypto/sha3"
 )
 
 // Address constants
 const (
-	// Mainnet/Testnet/Regnet
-	NetwMain = 0
-	NetwTest = 1
-	NetwReg  = 2
+	// Mainnet/Testnet/Regnet/Signet
+	NetwMain   = 0
+	NetwTest   = 1
+	NetwReg    = 2
+	NetwSignet = 3
 
 	// Address usage
 	AddrP2PKH        = 0
@@ -55,6 +56,8 @@ var (
 	ErrMkAddrPrefix         = errors.New("unknown address prefix")
 	ErrMkAddrVersion        = errors.New("unknown address version")
 	ErrMkAddrNotImplemented = errors.New("address not implemented")
+	ErrBech32Format         = codec.ErrBech32Format
+	ErrBech32Checksum       = codec.ErrBech32Checksum
 )
 
 // GetAddrMode returns the numeric value for mode (P2PKH, P2SH, ...)
@@ -107,11 +110,13 @@ func MakeAddress(obj any, coin, version, network int) (string, error) {
 		}
 
 	case *script.Script:
-		// sanity check: only P2SH allowed
-		if version != AddrP2SH {
+		// sanity check: only script hash addresses allowed
+		switch version {
+		case AddrP2SH, AddrP2WSH, AddrP2WSHinP2SH:
+			return makeAddress(x, hrp, version, prefix)
+		default:
 			return "", ErrMkAddrVersion
 		}
-		return makeAddress(x, hrp, version, prefix)
 	}
 	// address not handled
 	return "", ErrMkAddrNotImplemented
@@ -137,6 +142,11 @@ func makeAddress(obj Serializable, hrp string, version, prefix int) (string, err
 		kh := bitcoin.Hash160(obj.Bytes())
 		redeem = append(redeem, kh...)
 		data = redeem
+	case AddrP2WSHinP2SH:
+		redeem := append([]byte(nil), 0)
+		redeem = append(redeem, 0x20)
+		redeem = append(redeem, bitcoin.Sha256(obj.Bytes())...)
+		data = redeem
 	default:
 		// can't create address for unknown version
 		return "", ErrMkAddrVersion
@@ -146,11 +156,8 @@ func makeAddress(obj Serializable, hrp string, version, prefix int) (string, err
 		addr = append(addr, byte((prefix>>8)&0xff))
 	}
 	addr = append(addr, byte(prefix&0xff))
-	kh := bitcoin.Hash160(data)
-	addr = append(addr, kh...)
-	cs := bitcoin.Hash256(addr)
-	addr = append(addr, cs[:4]...)
-	return bitcoin.Base58Encode(addr), nil
+	addr = append(addr, bitcoin.Hash160(data)...)
+	return codec.Base58CheckEncode(addr), nil
 }
 
 func makeAddressSegWit(obj Serializable, hrp string, version int) (string, error) {
@@ -160,26 +167,12 @@ func makeAddressSegWit(obj Serializable, hrp string, version int) (string, error
 	case AddrP2WPKH:
 		data = bitcoin.Hash160(obj.Bytes())
 	case AddrP2WSH:
-		fallthrough
+		data = bitcoin.Sha256(obj.Bytes())
 	default:
 		return "", ErrMkAddrVersion
 	}
 	// encode data to 5-bit sequence and add leading witness version
-	buf := new(bytes.Buffer)
-	buf.WriteByte(0) // witness version
-	buf.Write(Bech32Bit5(data))
-
-	// compute checksum and append to buffer
-	crc := Bech32CRC(hrp, buf.Bytes())
-	buf.Write(crc)
-
-	// encode to Bech32 charset
-	b32enc := "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
-	addr := ""
-	for _, v := range buf.Bytes() {
-		addr += string(b32enc[v])
-	}
-	return hrp + "1" + addr, nil
+	return Bech32Encode(hrp, append([]byte{0}, Bech32Bit5(data)...), false), nil
 }
 
 //======================================================================
@@ -264,57 +257,33 @@ func makeAddressBCH(key *bitcoin.PublicKey, coin, version, network, prefix int)
 
 // Bech32Bit5 splits a byte array into 5-bit chunks
 func Bech32Bit5(data []byte) []byte {
-	size := len(data) * 8
-	v := new(big.Int).SetBytes(data)
-	pad := size % 5
-	if pad != 0 {
-		v = new(big.Int).Lsh(v, uint(5-pad))
-	}
-	num := (size + 4) / 5
-	res := make([]byte, num)
-	for i := num - 1; i >= 0; i-- {
-		res[i] = byte(v.Int64() & 31)
-		v = new(big.Int).Rsh(v, 5)
-	}
+	res, _ := codec.ConvertBits(data, 8, 5, true)
 	return res
 }
 
+// Bech32CRC computes the Bech32 checksum (BIP173) for 5-bit data
 func Bech32CRC(hrp string, data []byte) (crc []byte) {
-	buf := new(bytes.Buffer)
-	buf.Write(bech32ExpandHRP(hrp))
-	buf.Write(data)
-	buf.Write([]byte{0, 0, 0, 0, 0, 0})
-	pm := bech32Polymod(buf.Bytes()) ^ 1
-	crc = make([]byte, 6)
-	for i := range crc {
-		crc[i] = byte((pm >> (5 * (5 - i))) & 31)
-	}
-	return
+	return codec.Bech32CRC(hrp, data, false)
 }
 
-func bech32Polymod(data []byte) (chk uint32) {
-	gen := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
-	chk = 1
-	for _, v := range data {
-		b := (chk >> 25)
-		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
-		for i, g := range gen {
-			if (b>>i)&1 == 1 {
-				chk ^= g
-			}
-		}
-	}
-	return chk
+// Bech32mCRC computes the Bech32m checksum (BIP350) for 5-bit data
+func Bech32mCRC(hrp string, data []byte) (crc []byte) {
+	return codec.Bech32CRC(hrp, data, true)
 }
 
-func bech32ExpandHRP(hrp string) (buf []byte) {
-	n := len(hrp)
-	buf = make([]byte, 2*n+1)
-	buf[n] = 0
-	for i, c := range hrp {
-		b := byte(c)
-		buf[i] = b >> 5
-		buf[i+n+1] = b & 31
-	}
-	return
+// Bech32Decode decodes a Bech32 or Bech32m string into its human-readable
+// part and 5-bit data (without checksum). No length limit is enforced.
+func Bech32Decode(s string) (hrp string, data []byte, isM bool, err error) {
+	return codec.Bech32DecodeLimit(s, 0)
+}
+
+// Bech32Encode assembles a Bech32 (or Bech32m) string from 5-bit data.
+func Bech32Encode(hrp string, data []byte, isM bool) string {
+	return codec.Bech32Encode(hrp, data, isM)
+}
+
+// Bech32Bit8 joins 5-bit chunks into a byte array. Trailing bits must
+// be zero and less than eight.
+func Bech32Bit8(data []byte) ([]byte, error) {
+	return codec.ConvertBits(data, 5, 8, false)
 }
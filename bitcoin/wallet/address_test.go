@@ -45,6 +45,22 @@ func TestAddrP2WPKH(t *testing.T) {
 	}
 }
 
+func TestAddrP2WSH(t *testing.T) {
+	// see: BIP-173 test vectors
+	data, err := hex.DecodeString("210279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798ac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := makeAddressSegWit(bytes.NewBuffer(data), "bc", AddrP2WSH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3" {
+		t.Log(addr)
+		t.Fatal("addr mismatch")
+	}
+}
+
 type testData struct {
 	path    string
 	xpub    string
@@ -23,9 +23,9 @@ package wallet
 // GetXDVersion returns the extended data version for a given coin mode
 func GetXDVersion(coin, mode, network int, pub bool) uint32 {
 	for _, addr := range AddrList {
-		if addr.CoinID == coin {
+		if addr.CoinID == coin && network >= 0 && network < len(addr.Formats) {
 			v := addr.Formats[network]
-			if v != nil {
+			if v != nil && mode >= 0 && mode < len(v.Versions) {
 				w := v.Versions[mode]
 				if w != nil {
 					if pub {
@@ -47,10 +47,10 @@ func getPrefix(coin, version, network int) (prefix int, hrp string, conv Address
 	// get info for selected coin/version/network
 	prefix = -1
 	for _, addr := range AddrList {
-		if addr.CoinID == coin {
+		if addr.CoinID == coin && network >= 0 && network < len(addr.Formats) {
 			conv = addr.Conv
 			v := addr.Formats[network]
-			if v != nil {
+			if v != nil && version >= 0 && version < len(v.Versions) {
 				hrp = v.Bech32
 				w := v.Versions[version]
 				if w != nil {
@@ -121,6 +121,15 @@ var (
 				{0xc4, 0x044a5262, 0x044a4e28}, // P2WPKHinP2SH
 				{0xc4, 0x024289ef, 0x024285b5}, // P2WSHinP2SH
 			}},
+			// Signet (testnet versions)
+			{"tb", 0xef, []*AddrVersion{
+				{0x6f, 0x043587cf, 0x04358394}, // P2PKH
+				{0xc4, 0x043587cf, 0x04358394}, // P2SH
+				{0x6f, 0x045f1cf6, 0x045f18bc}, // P2WPKH
+				{0xc4, 0x02575483, 0x02575048}, // P2WSH
+				{0xc4, 0x044a5262, 0x044a4e28}, // P2WPKHinP2SH
+				{0xc4, 0x024289ef, 0x024285b5}, // P2WSHinP2SH
+			}},
 		}, nil},
 		//--------------------------------------------------------------
 		// LTC (Litecoin)
@@ -0,0 +1,261 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/hex"
+	"sort"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+//----------------------------------------------------------------------
+// Coin analytics over unspent transaction outputs (as returned by the
+// 'listunspent' or 'scantxoutset' RPC calls): age and value
+// distribution, dust detection and consolidation suggestions.
+// Input sizes and dust thresholds follow Bitcoin Core's policy rules.
+//----------------------------------------------------------------------
+
+// UTXO is an unspent transaction output. JSON field names match the
+// 'listunspent' and 'scantxoutset' results.
+type UTXO struct {
+	TxID          string         `json:"txid"`
+	Vout          uint32         `json:"vout"`
+	Address       string         `json:"address,omitempty"`
+	Script        string         `json:"scriptPubKey"` // hex-encoded
+	Amount        bitcoin.Amount `json:"amount"`
+	Confirmations int            `json:"confirmations,omitempty"`
+	Height        int            `json:"height,omitempty"`
+}
+
+// Age of the output in blocks for a given chain height. Returns 0 for
+// unconfirmed outputs.
+func (u *UTXO) Age(tip int) int {
+	if u.Confirmations > 0 {
+		return u.Confirmations
+	}
+	if u.Height > 0 && tip >= u.Height {
+		return tip - u.Height + 1
+	}
+	return 0
+}
+
+// InputSize returns the estimated virtual size of an input spending
+// the output.
+func (u *UTXO) InputSize() int {
+	scr, _ := hex.DecodeString(u.Script)
+	return InputSize(scr)
+}
+
+// SpendCost returns the fee for spending the output at given rate.
+func (u *UTXO) SpendCost(rate bitcoin.FeeRate) bitcoin.Amount {
+	return rate.Fee(u.InputSize())
+}
+
+// IsDust returns true if the output is dust at given (dust relay) fee
+// rate: its value is less than the cost of creating and spending it.
+func (u *UTXO) IsDust(rate bitcoin.FeeRate) bool {
+	scr, _ := hex.DecodeString(u.Script)
+	return u.Amount < DustThreshold(scr, rate)
+}
+
+// InputSize returns the estimated virtual size of an input spending an
+// output with given scriptPubKey. P2SH outputs are assumed to wrap
+// P2WPKH; unknown scripts are treated like P2PKH.
+func InputSize(script []byte) int {
+	switch {
+	case isWitness(script, 20): // P2WPKH
+		return 68
+	case isWitness(script, 32) && script[0] == 0x51: // P2TR (key path)
+		return 58
+	case isWitness(script, 32): // P2WSH (assume 2-of-3 multisig)
+		return 105
+	case len(script) == 23 && script[0] == 0xa9: // P2SH-P2WPKH
+		return 91
+	}
+	return 148
+}
+
+// DustThreshold returns the minimum value of a non-dust output with
+// given scriptPubKey at given (dust relay) fee rate.
+func DustThreshold(script []byte, rate bitcoin.FeeRate) bitcoin.Amount {
+	// serialized output size
+	size := 8 + 1 + len(script)
+	// spending input: outpoint, script length, sequence and (witness
+	// discounted) signature data
+	if isWitness(script, len(script)-2) {
+		size += 32 + 4 + 1 + 107/4 + 4
+	} else {
+		size += 32 + 4 + 1 + 107 + 4
+	}
+	return bitcoin.Amount(int64(rate) * int64(size) / 1000)
+}
+
+// isWitness returns true for a witness program of given length.
+func isWitness(script []byte, n int) bool {
+	if n < 2 || n > 40 || len(script) != n+2 || int(script[1]) != n {
+		return false
+	}
+	return script[0] == 0 || (script[0] >= 0x51 && script[0] <= 0x60)
+}
+
+//----------------------------------------------------------------------
+// Distributions
+//----------------------------------------------------------------------
+
+// Default bucket bounds
+var (
+	// AgeBounds in blocks (day, week, month, year)
+	AgeBounds = []int{144, 1008, 4320, 52560}
+
+	// ValueBounds in satoshis (powers of ten)
+	ValueBounds = []bitcoin.Amount{1000, 10000, 100000, 1000000, 10000000, 100000000}
+)
+
+// AgeBucket holds outputs with age in range [Min,Max) blocks; a Max
+// of -1 denotes an open range.
+type AgeBucket struct {
+	Min   int            `json:"min"`
+	Max   int            `json:"max"`
+	Count int            `json:"count"`
+	Total bitcoin.Amount `json:"total"`
+}
+
+// AgeDistribution returns the age distribution of outputs for a given
+// chain height. If no bounds are given, AgeBounds is used.
+func AgeDistribution(utxos []*UTXO, tip int, bounds []int) []*AgeBucket {
+	if len(bounds) == 0 {
+		bounds = AgeBounds
+	}
+	list := make([]*AgeBucket, len(bounds)+1)
+	lower := 0
+	for i, b := range bounds {
+		list[i] = &AgeBucket{Min: lower, Max: b}
+		lower = b
+	}
+	list[len(bounds)] = &AgeBucket{Min: lower, Max: -1}
+	for _, u := range utxos {
+		age := u.Age(tip)
+		i := sort.SearchInts(bounds, age+1)
+		list[i].Count++
+		list[i].Total += u.Amount
+	}
+	return list
+}
+
+// ValueBucket holds outputs with value in range [Min,Max); a Max of
+// -1 denotes an open range.
+type ValueBucket struct {
+	Min   bitcoin.Amount `json:"min"`
+	Max   bitcoin.Amount `json:"max"`
+	Count int            `json:"count"`
+	Total bitcoin.Amount `json:"total"`
+}
+
+// ValueHistogram returns the value distribution of outputs. If no
+// bounds are given, ValueBounds is used.
+func ValueHistogram(utxos []*UTXO, bounds []bitcoin.Amount) []*ValueBucket {
+	if len(bounds) == 0 {
+		bounds = ValueBounds
+	}
+	list := make([]*ValueBucket, len(bounds)+1)
+	var lower bitcoin.Amount
+	for i, b := range bounds {
+		list[i] = &ValueBucket{Min: lower, Max: b}
+		lower = b
+	}
+	list[len(bounds)] = &ValueBucket{Min: lower, Max: -1}
+	for _, u := range utxos {
+		i := sort.Search(len(bounds), func(i int) bool { return u.Amount < bounds[i] })
+		list[i].Count++
+		list[i].Total += u.Amount
+	}
+	return list
+}
+
+//----------------------------------------------------------------------
+// Dust and consolidation
+//----------------------------------------------------------------------
+
+// DustReport lists outputs that are dust at a given fee rate.
+type DustReport struct {
+	Rate  bitcoin.FeeRate `json:"feerate"`
+	UTXOs []*UTXO         `json:"utxos"`
+	Total bitcoin.Amount  `json:"total"`
+}
+
+// FindDust returns all outputs that are dust at given fee rate.
+func FindDust(utxos []*UTXO, rate bitcoin.FeeRate) *DustReport {
+	rep := &DustReport{Rate: rate}
+	for _, u := range utxos {
+		if u.IsDust(rate) {
+			rep.UTXOs = append(rep.UTXOs, u)
+			rep.Total += u.Amount
+		}
+	}
+	return rep
+}
+
+// Consolidation is a suggestion to merge outputs into a single output
+// while fees are low.
+type Consolidation struct {
+	UTXOs   []*UTXO        `json:"utxos"`   // outputs to merge
+	Total   bitcoin.Amount `json:"total"`   // total value of outputs
+	VSize   int            `json:"vsize"`   // estimated transaction size
+	Fee     bitcoin.Amount `json:"fee"`     // fee at low rate
+	Savings bitcoin.Amount `json:"savings"` // saved fees (spent later at current rate)
+}
+
+// consolidation transaction overhead (version, locktime, counters,
+// segwit marker) and P2WPKH output
+const consolidateOverhead = 11 + 31
+
+// SuggestConsolidation selects outputs with a value below 'limit' that
+// are cheaper to merge now at fee rate 'low' than to spend later at
+// fee rate 'current'. Outputs that are uneconomic even at the low rate
+// are skipped. Returns nil if merging saves nothing.
+func SuggestConsolidation(utxos []*UTXO, current, low bitcoin.FeeRate, limit bitcoin.Amount) *Consolidation {
+	c := &Consolidation{VSize: consolidateOverhead}
+	var saved bitcoin.Amount
+	for _, u := range utxos {
+		// skip large and unconfirmed outputs
+		if u.Amount >= limit || (u.Confirmations == 0 && u.Height == 0) {
+			continue
+		}
+		size := u.InputSize()
+		if u.Amount <= low.Fee(size) {
+			continue
+		}
+		c.UTXOs = append(c.UTXOs, u)
+		c.Total += u.Amount
+		c.VSize += size
+		saved += current.Fee(size)
+	}
+	if len(c.UTXOs) < 2 {
+		return nil
+	}
+	c.Fee = low.Fee(c.VSize)
+	// spending the merged output later costs one input at current rate
+	if c.Savings = saved - c.Fee - current.Fee(68); c.Savings <= 0 {
+		return nil
+	}
+	return c
+}
@@ -0,0 +1,101 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+const (
+	scrP2PKH  = "76a914000102030405060708090a0b0c0d0e0f1011121388ac"
+	scrP2WPKH = "0014000102030405060708090a0b0c0d0e0f10111213"
+	scrP2TR   = "5120000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+)
+
+func TestDustThreshold(t *testing.T) {
+	u := &UTXO{Script: scrP2PKH, Amount: 545}
+	if !u.IsDust(3000) {
+		t.Fatal("P2PKH: 545 sat not dust")
+	}
+	u.Amount = 546
+	if u.IsDust(3000) {
+		t.Fatal("P2PKH: 546 sat is dust")
+	}
+	u = &UTXO{Script: scrP2WPKH, Amount: 294}
+	if u.IsDust(3000) || u.InputSize() != 68 {
+		t.Fatal("P2WPKH threshold")
+	}
+	u = &UTXO{Script: scrP2TR, Amount: 329}
+	if !u.IsDust(3000) || u.InputSize() != 58 {
+		t.Fatal("P2TR threshold")
+	}
+	rep := FindDust([]*UTXO{u, {Script: scrP2WPKH, Amount: 100000}}, 3000)
+	if len(rep.UTXOs) != 1 || rep.Total != 329 {
+		t.Fatalf("dust report: %+v", rep)
+	}
+}
+
+func TestDistributions(t *testing.T) {
+	in := `[
+		{"txid":"aa","vout":0,"scriptPubKey":"` + scrP2WPKH + `","amount":0.00000500,"confirmations":3},
+		{"txid":"bb","vout":1,"scriptPubKey":"` + scrP2WPKH + `","amount":0.00050000,"confirmations":200},
+		{"txid":"cc","vout":0,"scriptPubKey":"` + scrP2PKH + `","amount":1.5,"height":100}
+	]`
+	var utxos []*UTXO
+	if err := json.Unmarshal([]byte(in), &utxos); err != nil {
+		t.Fatal(err)
+	}
+	ages := AgeDistribution(utxos, 60000, nil)
+	if len(ages) != 5 || ages[0].Count != 1 || ages[1].Count != 1 || ages[4].Count != 1 {
+		t.Fatalf("age distribution: %v %v %v", ages[0], ages[1], ages[4])
+	}
+	vals := ValueHistogram(utxos, nil)
+	if vals[0].Total != 500 || vals[2].Count != 1 || vals[6].Total != 150000000 {
+		t.Fatalf("value histogram: %v %v %v", vals[0], vals[2], vals[6])
+	}
+}
+
+func TestConsolidation(t *testing.T) {
+	var utxos []*UTXO
+	for i := 0; i < 10; i++ {
+		utxos = append(utxos, &UTXO{Script: scrP2WPKH, Amount: 20000, Confirmations: 10})
+	}
+	// uneconomic, unconfirmed and large outputs are skipped
+	utxos = append(utxos,
+		&UTXO{Script: scrP2WPKH, Amount: 50, Confirmations: 10},
+		&UTXO{Script: scrP2WPKH, Amount: 20000},
+		&UTXO{Script: scrP2WPKH, Amount: bitcoin.BTC, Confirmations: 10},
+	)
+	c := SuggestConsolidation(utxos, 50000, 1000, 1000000)
+	if c == nil || len(c.UTXOs) != 10 || c.Total != 200000 {
+		t.Fatalf("consolidation: %+v", c)
+	}
+	if c.VSize != consolidateOverhead+680 || c.Fee != 722 || c.Savings != 34000-722-3400 {
+		t.Fatalf("consolidation costs: %+v", c)
+	}
+	// no savings if fees are already low
+	if SuggestConsolidation(utxos, 1000, 1000, 1000000) != nil {
+		t.Fatal("consolidation without savings")
+	}
+}
@@ -27,11 +27,13 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
+	"hash"
+	"sync"
 
 	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/bitcoin/codec"
 	"github.com/bfix/gospel/data"
+	gerr "github.com/bfix/gospel/errors"
 	"github.com/bfix/gospel/math"
 )
 
@@ -42,6 +44,14 @@ var (
 	ErrHDKey     = errors.New("invalid HD key")
 )
 
+// Typed derivation errors (all match ErrHDPath in 'errors.Is()')
+var (
+	ErrHDHardened = gerr.New(ErrHDPath, "hardened derivation from public key")
+	ErrHDIndex    = gerr.New(ErrHDPath, "child index out of range")
+	ErrHDSegment  = gerr.New(ErrHDPath, "invalid path segment")
+	ErrHDDepth    = gerr.New(ErrHDPath, "maximum depth exceeded")
+)
+
 //----------------------------------------------------------------------
 // ExtendedData objects represent public/private extended keys
 //----------------------------------------------------------------------
@@ -52,23 +62,11 @@ var (
 //	 1 if extended data refers to a private key
 //	 0 if version is unknown
 func CheckVersion(version uint32) (int, uint32) {
-	for _, as := range AddrList {
-		for _, af := range as.Formats {
-			if af == nil {
-				continue
-			}
-			for _, av := range af.Versions {
-				if av == nil {
-					continue
-				}
-				if version == av.PubVersion {
-					return -1, av.PrvVersion
-				}
-				if version == av.PrvVersion {
-					return 1, av.PubVersion
-				}
-			}
+	for _, info := range xdVersions[version] {
+		if info.pub {
+			return -1, info.other
 		}
+		return 1, info.other
 	}
 	return 0, 0
 }
@@ -112,11 +110,7 @@ func (d *ExtendedData) String() string {
 	if err != nil {
 		return ""
 	}
-	var r []byte
-	r = append(r, b...)
-	cs := bitcoin.Hash256(b)
-	r = append(r, cs[:4]...)
-	return bitcoin.Base58Encode(r)
+	return codec.Base58CheckEncode(b)
 }
 
 //----------------------------------------------------------------------
@@ -194,9 +188,14 @@ func ParseExtendedPrivateKey(s string) (k *ExtendedPrivateKey, err error) {
 	return k, nil
 }
 
-// Public returns the associated public key
+// Public returns the associated public key. The version is the public
+// counterpart of the private version (like zprv -> zpub); unknown
+// versions yield a generic xpub.
 func (k *ExtendedPrivateKey) Public() *ExtendedPublicKey {
-	_, pubVersion := CheckVersion(k.Data.Version)
+	pubVersion, ok := PublicVersion(k.Data.Version)
+	if !ok {
+		pubVersion = XpubVersion
+	}
 	r := new(ExtendedPublicKey)
 	r.Key = bitcoin.MultBase(k.Key)
 	r.Data = NewExtendedData()
@@ -246,7 +245,7 @@ func NewHD(seed []byte) (*HD, error) {
 	hd.m = new(ExtendedPrivateKey)
 	hd.m.Key = mKey
 	hd.m.Data = NewExtendedData()
-	hd.m.Data.Version = 0x0488ade4 // generic xpub version
+	hd.m.Data.Version = XprvVersion // generic version
 	copy(hd.m.Data.Keydata, hd.m.Key.FixedBytes(33))
 	copy(hd.m.Data.Chaincode, i[32:])
 	hd.m.Data.Child = 0
@@ -255,6 +254,17 @@ func NewHD(seed []byte) (*HD, error) {
 	return hd, nil
 }
 
+// NewHDNet initializes a new HD from a seed value with extended key
+// versions for a network and address mode (AddrP2PKH, AddrP2WPKH, ...).
+func NewHDNet(seed []byte, params *NetworkParams, mode int) (*HD, error) {
+	hd, err := NewHD(seed)
+	if err != nil {
+		return nil, err
+	}
+	hd.m.Data.Version = params.XDVersion(mode, false)
+	return hd, nil
+}
+
 // MasterPrivate returns the master private key.
 func (hd *HD) MasterPrivate() *ExtendedPrivateKey {
 	return hd.m
@@ -266,29 +276,22 @@ func (hd *HD) MasterPublic() *ExtendedPublicKey {
 }
 
 // Private returns an extended private key for a given path (BIP32,BIP44)
-func (hd *HD) Private(path string) (prv *ExtendedPrivateKey, err error) {
-	if !strings.HasPrefix(path, "m/") {
-		return nil, ErrHDPath
+func (hd *HD) Private(path string) (*ExtendedPrivateKey, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
 	}
+	return hd.PrivateAt(p)
+}
+
+// PrivateAt returns an extended private key for a derivation path.
+// If a child key is invalid, the next index is used instead (BIP32);
+// the 'Child' field of the key data holds the index actually used.
+func (hd *HD) PrivateAt(p Path) (prv *ExtendedPrivateKey, err error) {
 	prv = hd.m
-	for _, id := range strings.Split(path[2:], "/") {
-		var (
-			j int64
-			i uint32
-		)
-		if strings.HasSuffix(id, "'") {
-			j, err = strconv.ParseInt(id[:len(id)-1], 10, 32)
-			i = uint32(j) + (1 << 31)
-		} else {
-			j, err = strconv.ParseInt(id, 10, 32)
-			i = uint32(j)
-		}
-		if err != nil {
-			return
-		}
-		prv = CKDprv(prv, i)
-		if prv == nil {
-			return nil, ErrHDKey
+	for _, i := range p {
+		if prv, err = ckdPrvNext(prv, i); err != nil {
+			return nil, err
 		}
 	}
 	return prv, nil
@@ -303,6 +306,15 @@ func (hd *HD) Public(path string) (pub *ExtendedPublicKey, err error) {
 	return prv.Public(), nil
 }
 
+// PublicAt returns an extended public key for a derivation path.
+func (hd *HD) PublicAt(p Path) (*ExtendedPublicKey, error) {
+	prv, err := hd.PrivateAt(p)
+	if err != nil {
+		return nil, err
+	}
+	return prv.Public(), nil
+}
+
 //----------------------------------------------------------------------
 // Hierarchically deterministic key space (public keys only)
 //----------------------------------------------------------------------
@@ -310,16 +322,21 @@ func (hd *HD) Public(path string) (pub *ExtendedPublicKey, err error) {
 // HDPublic represents a public branch in a hierarchically deterministic
 // key space.
 type HDPublic struct {
-	m    *ExtendedPublicKey
-	path string
+	sync.Mutex
+
+	m     *ExtendedPublicKey
+	path  Path
+	cache map[string]*ExtendedPublicKey // intermediate nodes (relative path)
 }
 
 // NewHDPublic initializes a new HDPublic from an extended public key
-// with a given path.
+// with a given path. An invalid path is treated as the empty path.
 func NewHDPublic(key *ExtendedPublicKey, path string) *HDPublic {
+	p, _ := ParsePath(path)
 	return &HDPublic{
-		m:    key.Clone(),
-		path: path,
+		m:     key.Clone(),
+		path:  p,
+		cache: make(map[string]*ExtendedPublicKey),
 	}
 }
 
@@ -327,38 +344,135 @@ func NewHDPublic(key *ExtendedPublicKey, path string) *HDPublic {
 // NOT contain hardened elements and must start with the path of the
 // public key in HDPublic!
 func (hd *HDPublic) Public(path string) (pub *ExtendedPublicKey, err error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
 	// check for matching relative path
-	if !strings.HasPrefix(path, hd.path) {
+	if !p.HasPrefix(hd.path) {
 		return nil, ErrHDPath
 	}
-	// trim to relative path
-	path = path[len(hd.path)+1:]
-	// check for hardened levels
-	if strings.Contains(path, "'") {
-		return nil, ErrHDPath
+	return hd.PublicAt(p[len(hd.path):])
+}
+
+// PublicAt returns an extended public key for a path relative to the
+// public key in HDPublic. The path MUST NOT contain hardened elements
+// (ErrHDHardened). Intermediate nodes are cached, so only the last
+// element is derived for siblings of a previous call. Invalid child
+// keys are skipped like in HD.PrivateAt.
+func (hd *HDPublic) PublicAt(rel Path) (pub *ExtendedPublicKey, err error) {
+	if rel.IsHardened() {
+		return nil, ErrHDHardened
+	}
+	if len(rel) == 0 {
+		return hd.m, nil
+	}
+	parent, err := hd.node(rel.Parent())
+	if err != nil {
+		return nil, err
 	}
-	// follow the path...
+	return ckdPubNext(parent, rel[len(rel)-1])
+}
+
+// DeriveRange returns the public keys for the relative path
+// "account/chain/i" for indices 'from' to 'to' (exclusive) as used for
+// address discovery. The chain node is derived (and cached) only once;
+// children are derived with pre-computed parent data. Indices with
+// invalid child keys are omitted (BIP32).
+func (hd *HDPublic) DeriveRange(account, chain, from, to uint32) ([]*ExtendedPublicKey, error) {
+	if to < from || to > Hardened {
+		return nil, ErrHDIndex
+	}
+	parent, err := hd.node(Path{account, chain})
+	if err != nil {
+		return nil, err
+	}
+	if parent.Data.Depth == 255 {
+		return nil, ErrHDDepth
+	}
+	list := make([]*ExtendedPublicKey, 0, to-from)
+	ckd := newCKDParent(parent)
+	for i := from; i < to; i++ {
+		if pub := ckd.child(i); pub != nil {
+			list = append(list, pub)
+		}
+	}
+	return list, nil
+}
+
+// node returns the (cached) public key for a relative path; missing
+// nodes are derived from the longest cached prefix.
+func (hd *HDPublic) node(rel Path) (pub *ExtendedPublicKey, err error) {
+	if rel.IsHardened() {
+		return nil, ErrHDHardened
+	}
+	hd.Lock()
+	defer hd.Unlock()
+	// find longest cached prefix
+	n := 0
 	pub = hd.m
-	for _, id := range strings.Split(path, "/") {
-		var j int64
-		j, err = strconv.ParseInt(id, 10, 32)
-		if err != nil {
-			return
+	for d := len(rel); d > 0; d-- {
+		if k, ok := hd.cache[rel[:d].String()]; ok {
+			pub, n = k, d
+			break
 		}
-		pub = CKDpub(pub, uint32(j))
-		if pub == nil {
-			return nil, ErrHDKey
+	}
+	// derive and cache missing nodes
+	for d := n; d < len(rel); d++ {
+		if pub, err = ckdPubNext(pub, rel[d]); err != nil {
+			return nil, err
 		}
+		hd.cache[rel[:d+1].String()] = pub
 	}
-	return
+	return pub, nil
 }
 
 //----------------------------------------------------------------------
 // Key derivation methods
 //----------------------------------------------------------------------
 
-// CKDprv is a key derivation function for private keys
+// ckdPrvNext derives the child 'i' of a private key. If the child key is
+// invalid (probability lower than 2^-127), the next index is used as
+// mandated by BIP32; the index never crosses into the hardened (or out
+// of the hardened) range.
+func ckdPrvNext(k *ExtendedPrivateKey, i uint32) (*ExtendedPrivateKey, error) {
+	if k.Data.Depth == 255 {
+		return nil, ErrHDDepth
+	}
+	for {
+		if ki := CKDprv(k, i); ki != nil {
+			return ki, nil
+		}
+		if i++; i == Hardened || i == 0 {
+			return nil, ErrHDIndex
+		}
+	}
+}
+
+// ckdPubNext derives the (non-hardened) child 'i' of a public key;
+// invalid child keys are skipped like in ckdPrvNext.
+func ckdPubNext(k *ExtendedPublicKey, i uint32) (*ExtendedPublicKey, error) {
+	if i >= Hardened {
+		return nil, ErrHDHardened
+	}
+	if k.Data.Depth == 255 {
+		return nil, ErrHDDepth
+	}
+	ckd := newCKDParent(k)
+	for ; i < Hardened; i++ {
+		if ki := ckd.child(i); ki != nil {
+			return ki, nil
+		}
+	}
+	return nil, ErrHDIndex
+}
+
+// CKDprv is a key derivation function for private keys. It returns nil
+// if the child key is invalid or the maximum depth is reached.
 func CKDprv(k *ExtendedPrivateKey, i uint32) (ki *ExtendedPrivateKey) {
+	if k.Data.Depth == 255 {
+		return nil
+	}
 	mac := hmac.New(sha512.New, k.Data.Chaincode)
 	if i >= 1<<31 {
 		mac.Write([]byte{0})
@@ -387,27 +501,55 @@ func CKDprv(k *ExtendedPrivateKey, i uint32) (ki *ExtendedPrivateKey) {
 	return
 }
 
-// CKDpub is a key derivation function for public keys
+// CKDpub is a key derivation function for public keys. It returns nil
+// for hardened indices, if the child key is invalid or the maximum
+// depth is reached.
 func CKDpub(k *ExtendedPublicKey, i uint32) (ki *ExtendedPublicKey) {
-	if i >= 1<<31 {
+	return newCKDParent(k).child(i)
+}
+
+// ckdParent holds pre-computed data of a parent public key for the
+// derivation of (many) children.
+type ckdParent struct {
+	key *ExtendedPublicKey
+	ser []byte    // compressed public key
+	fp  uint32    // fingerprint
+	mac hash.Hash // HMAC keyed with chaincode
+}
+
+// newCKDParent prepares a public key for child derivation.
+func newCKDParent(k *ExtendedPublicKey) *ckdParent {
+	ser := k.Key.Bytes(true)
+	return &ckdParent{
+		key: k,
+		ser: ser,
+		fp:  binary.BigEndian.Uint32(bitcoin.Hash160(ser)),
+		mac: hmac.New(sha512.New, k.Data.Chaincode),
+	}
+}
+
+// child derives the non-hardened child 'i' (nil if 'i' is hardened or
+// the key is invalid).
+func (p *ckdParent) child(i uint32) (ki *ExtendedPublicKey) {
+	if i >= 1<<31 || p.key.Data.Depth == 255 {
 		return nil
 	}
-	mac := hmac.New(sha512.New, k.Data.Chaincode)
-	mac.Write(k.Key.Bytes(true))
-	_ = binary.Write(mac, binary.BigEndian, i)
-	x := mac.Sum(nil)
+	p.mac.Reset()
+	p.mac.Write(p.ser)
+	_ = binary.Write(p.mac, binary.BigEndian, i)
+	x := p.mac.Sum(nil)
 
 	j := math.NewIntFromBytes(x[:32])
 	if j.Equals(math.ZERO) || j.Cmp(c.N) >= 0 {
 		return nil
 	}
 	ki = new(ExtendedPublicKey)
-	ki.Key = bitcoin.MultBase(j).Add(k.Key)
+	ki.Key = bitcoin.MultBase(j).Add(p.key.Key)
 	ki.Data = NewExtendedData()
-	ki.Data.Version = k.Data.Version
-	ki.Data.Depth = k.Data.Depth + 1
+	ki.Data.Version = p.key.Data.Version
+	ki.Data.Depth = p.key.Data.Depth + 1
 	ki.Data.Child = i
-	ki.Data.ParentFP = k.Fingerprint()
+	ki.Data.ParentFP = p.fp
 	copy(ki.Data.Chaincode, x[32:])
 	copy(ki.Data.Keydata, ki.Key.Bytes(true))
 	return
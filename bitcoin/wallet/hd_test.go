@@ -22,6 +22,7 @@ package wallet
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"testing"
 )
@@ -147,3 +148,152 @@ func TestPath(t *testing.T) {
 		}
 	}
 }
+
+func TestHDDeriveRange(t *testing.T) {
+	hd := testHD()
+	pub, err := hd.Public("m/0'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdp := NewHDPublic(pub, "m/0'")
+	list, err := hdp.DeriveRange(1, 0, 5, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 5 {
+		t.Fatalf("derived %d keys", len(list))
+	}
+	for i, k := range list {
+		exp, err := hdp.Public(fmt.Sprintf("m/0'/1/0/%d", i+5))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.String() != exp.String() {
+			t.Fatalf("key #%d mismatch", i+5)
+		}
+		// cross-check with private derivation
+		prv, err := hd.Public(fmt.Sprintf("m/0'/1/0/%d", i+5))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !k.Key.Equals(prv.Key) {
+			t.Fatalf("key #%d differs from private derivation", i+5)
+		}
+	}
+	if _, err = hdp.DeriveRange(Hardened, 0, 0, 1); err != ErrHDHardened {
+		t.Fatal("hardened account accepted")
+	}
+	if _, err = hdp.DeriveRange(0, 0, 2, 1); err != ErrHDIndex {
+		t.Fatal("invalid range accepted")
+	}
+}
+
+func BenchmarkHDPublic(b *testing.B) {
+	hd := testHD()
+	pub, _ := hd.Public("m/0'")
+	hdp := NewHDPublic(pub, "m/0'")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := hdp.Public(fmt.Sprintf("m/0'/0/0/%d", i%1000)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHDDeriveRange(b *testing.B) {
+	hd := testHD()
+	pub, _ := hd.Public("m/0'")
+	hdp := NewHDPublic(pub, "m/0'")
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 100 {
+		if _, err := hdp.DeriveRange(0, 0, 0, 100); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BIP32 test vectors 2-4 (vector 1 is covered by pathData)
+var bip32Vectors = []struct {
+	seed string
+	path string
+	pub  string
+	prv  string
+}{
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0",
+		"xpub69H7F5d8KSRgmmdJg2KhpAK8SR3DjMwAdkxj3ZuxV27CprR9LgpeyGmXUbC6wb7ERfvrnKZjXoUmmDznezpbZb7ap6r1D3tgFxHmwMkQTPH",
+		"xprv9vHkqa6EV4sPZHYqZznhT2NPtPCjKuDKGY38FBWLvgaDx45zo9WQRUT3dKYnjwih2yJD9mkrocEZXo1ex8G81dwSM1fwqWpWkeS3v86pgKt"},
+	// retention of leading zeros
+	{"4b381541583be4423346c643850da4b320e46a87ae3d2a4e6da11eba819cd4acba45d239319ac14f863b8d5ab5a0d0c64d2e8a1e7d1457df2e5a3c51c73235be", "m",
+		"xpub661MyMwAqRbcEZVB4dScxMAdx6d4nFc9nvyvH3v4gJL378CSRZiYmhRoP7mBy6gSPSCYk6SzXPTf3ND1cZAceL7SfJ1Z3GC8vBgp2epUt13",
+		"xprv9s21ZrQH143K25QhxbucbDDuQ4naNntJRi4KUfWT7xo4EKsHt2QJDu7KXp1A3u7Bi1j8ph3EGsZ9Xvz9dGuVrtHHs7pXeTzjuxBrCmmhgC6"},
+	{"4b381541583be4423346c643850da4b320e46a87ae3d2a4e6da11eba819cd4acba45d239319ac14f863b8d5ab5a0d0c64d2e8a1e7d1457df2e5a3c51c73235be", "m/0H",
+		"xpub68NZiKmJWnxxS6aaHmn81bvJeTESw724CRDs6HbuccFQN9Ku14VQrADWgqbhhTHBaohPX4CjNLf9fq9MYo6oDaPPLPxSb7gwQN3ih19Zm4Y",
+		"xprv9uPDJpEQgRQfDcW7BkF7eTya6RPxXeJCqCJGHuCJ4GiRVLzkTXBAJMu2qaMWPrS7AANYqdq6vcBcBUdJCVVFceUvJFjaPdGZ2y9WACViL4L"},
+	// retention of leading zeros (hardened derivation)
+	{"3ddd5602285899a946114506157c7997e5444528f3003f6134712147db19b678", "m/0H",
+		"xpub69AUMk3qDBi3uW1sXgjCmVjJ2G6WQoYSnNHyzkmdCHEhSZ4tBok37xfFEqHd2AddP56Tqp4o56AePAgCjYdvpW2PU2jbUPFKsav5ut6Ch1m",
+		"xprv9vB7xEWwNp9kh1wQRfCCQMnZUEG21LpbR9NPCNN1dwhiZkjjeGRnaALmPXCX7SgjFTiCTT6bXes17boXtjq3xLpcDjzEuGLQBM5ohqkao9G"},
+	{"3ddd5602285899a946114506157c7997e5444528f3003f6134712147db19b678", "m/0H/1H",
+		"xpub6BJA1jSqiukeaesWfxe6sNK9CCGaujFFSJLomWHprUL9DePQ4JDkM5d88n49sMGJxrhpjazuXYWdMf17C9T5XnxkopaeS7jGk1GyyVziaMt",
+		"xprv9xJocDuwtYCMNAo3Zw76WENQeAS6WGXQ55RCy7tDJ8oALr4FWkuVoHJeHVAcAqiZLE7Je3vZJHxspZdFHfnBEjHqU5hG1Jaj32dVoS6XLT1"},
+}
+
+func TestHDVectors(t *testing.T) {
+	for _, v := range bip32Vectors {
+		seed, _ := hex.DecodeString(v.seed)
+		hd, err := NewHD(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prv, err := hd.Private(v.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prv.String() != v.prv {
+			t.Fatalf("%s: private key mismatch", v.path)
+		}
+		if prv.Public().String() != v.pub {
+			t.Fatalf("%s: public key mismatch", v.path)
+		}
+	}
+}
+
+func TestHDErrors(t *testing.T) {
+	hd := testHD()
+	for _, tc := range []struct {
+		path string
+		err  error
+	}{
+		{"m/0'/x", ErrHDSegment},
+		{"m/0'//1", ErrHDSegment},
+		{"m/2147483648", ErrHDIndex},
+		{"m/4294967296'", ErrHDIndex},
+	} {
+		_, err := hd.Private(tc.path)
+		if !errors.Is(err, tc.err) || !errors.Is(err, ErrHDPath) {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+	}
+	// hardened derivation from public key
+	pub, err := hd.Public("m/0'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdp := NewHDPublic(pub, "m/0'")
+	if _, err = hdp.Public("m/0'/1'"); err != ErrHDHardened {
+		t.Fatalf("hardened: %v", err)
+	}
+	if CKDpub(pub, Hardened) != nil {
+		t.Fatal("CKDpub: hardened child")
+	}
+	// depth overflow
+	deep := pub.Clone()
+	deep.Data.Depth = 255
+	if _, err = NewHDPublic(deep, "m").PublicAt(Path{0}); err != ErrHDDepth {
+		t.Fatalf("public depth: %v", err)
+	}
+	hd.m.Data.Depth = 255
+	if _, err = hd.PrivateAt(Path{0}); err != ErrHDDepth {
+		t.Fatalf("private depth: %v", err)
+	}
+}
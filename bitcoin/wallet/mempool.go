@@ -0,0 +1,231 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"sort"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+//----------------------------------------------------------------------
+// Mempool statistics over the entries of a node's mempool (as returned
+// by the 'getrawmempool true' RPC call): feerate histogram, estimated
+// confirmation times per feerate band and ancestor-package aware
+// (child-pays-for-parent) feerates. The statistics are a building block
+// for local fee estimation when 'estimatesmartfee' is too coarse.
+//----------------------------------------------------------------------
+
+// BlockVSize is the max. virtual size of a block
+const BlockVSize = 1000000
+
+// MempoolFees are the fees of a mempool entry.
+type MempoolFees struct {
+	Base       bitcoin.Amount `json:"base"`       // fee of transaction
+	Modified   bitcoin.Amount `json:"modified"`   // fee with prioritisation
+	Ancestor   bitcoin.Amount `json:"ancestor"`   // fees of in-mempool ancestors (incl. tx)
+	Descendant bitcoin.Amount `json:"descendant"` // fees of in-mempool descendants (incl. tx)
+}
+
+// MempoolEntry is a transaction in the mempool. JSON field names match
+// the 'getrawmempool true' and 'getmempoolentry' results.
+type MempoolEntry struct {
+	VSize           int         `json:"vsize"`
+	Weight          int         `json:"weight,omitempty"`
+	Time            int64       `json:"time"`
+	Height          int         `json:"height"`
+	DescendantCount int         `json:"descendantcount"`
+	DescendantSize  int         `json:"descendantsize"`
+	AncestorCount   int         `json:"ancestorcount"`
+	AncestorSize    int         `json:"ancestorsize"`
+	Fees            MempoolFees `json:"fees"`
+	Depends         []string    `json:"depends,omitempty"` // unconfirmed parents
+	SpentBy         []string    `json:"spentby,omitempty"` // unconfirmed children
+}
+
+// fee paid by the entry (as seen by miners)
+func (e *MempoolEntry) fee() bitcoin.Amount {
+	if e.Fees.Modified != 0 {
+		return e.Fees.Modified
+	}
+	return e.Fees.Base
+}
+
+// FeeRate returns the feerate of the transaction alone.
+func (e *MempoolEntry) FeeRate() bitcoin.FeeRate {
+	return bitcoin.NewFeeRate(e.fee(), e.VSize)
+}
+
+// AncestorFeeRate returns the feerate of the transaction including all
+// its unconfirmed ancestors (the ancestor package).
+func (e *MempoolEntry) AncestorFeeRate() bitcoin.FeeRate {
+	if e.AncestorSize == 0 {
+		return e.FeeRate()
+	}
+	return bitcoin.NewFeeRate(e.Fees.Ancestor, e.AncestorSize)
+}
+
+// EffectiveFeeRates returns the feerate at which miners will include
+// each transaction: a transaction with low-fee ancestors is mined at
+// its ancestor package rate; a transaction with a child paying for it
+// is mined at the (higher) package rate of that child.
+func EffectiveFeeRates(entries map[string]*MempoolEntry) map[string]bitcoin.FeeRate {
+	res := make(map[string]bitcoin.FeeRate, len(entries))
+	for id, e := range entries {
+		rate := e.FeeRate()
+		if anc := e.AncestorFeeRate(); anc < rate {
+			rate = anc
+		}
+		// walk all descendants
+		seen := map[string]bool{id: true}
+		pending := append([]string{}, e.SpentBy...)
+		for len(pending) > 0 {
+			cid := pending[0]
+			pending = pending[1:]
+			if seen[cid] {
+				continue
+			}
+			seen[cid] = true
+			c, ok := entries[cid]
+			if !ok {
+				continue
+			}
+			if anc := c.AncestorFeeRate(); anc > rate {
+				rate = anc
+			}
+			pending = append(pending, c.SpentBy...)
+		}
+		res[id] = rate
+	}
+	return res
+}
+
+//----------------------------------------------------------------------
+// Feerate histogram
+//----------------------------------------------------------------------
+
+// FeeBounds are the default band bounds in sat/kvB
+var FeeBounds = []bitcoin.FeeRate{
+	1000, 2000, 3000, 5000, 8000, 10000, 15000, 20000, 30000,
+	50000, 80000, 100000, 150000, 200000, 300000, 500000, 1000000,
+}
+
+// FeeBand holds mempool transactions with an effective feerate in range
+// [Min,Max); a Max of -1 denotes an open range. Blocks is the estimated
+// number of blocks until a transaction at the lower bound of the band
+// is mined (if no new transactions arrive).
+type FeeBand struct {
+	Min    bitcoin.FeeRate `json:"min"`
+	Max    bitcoin.FeeRate `json:"max"`
+	Count  int             `json:"count"`
+	VSize  int             `json:"vsize"`
+	Fees   bitcoin.Amount  `json:"fees"`
+	Blocks int             `json:"blocks"`
+}
+
+// MempoolStats is the summary of a mempool
+type MempoolStats struct {
+	Count    int             `json:"count"`    // number of transactions
+	VSize    int             `json:"vsize"`    // total virtual size
+	Fees     bitcoin.Amount  `json:"fees"`     // total fees
+	Packages int             `json:"packages"` // transactions with unconfirmed ancestors
+	Median   bitcoin.FeeRate `json:"median"`   // median effective feerate (by vsize)
+	Bands    []*FeeBand      `json:"bands"`    // feerate histogram (ascending)
+}
+
+// MempoolHistogram computes the statistics for the entries of a mempool
+// (keyed by transaction id). If no bounds are given, FeeBounds is used.
+func MempoolHistogram(entries map[string]*MempoolEntry, bounds []bitcoin.FeeRate) *MempoolStats {
+	if len(bounds) == 0 {
+		bounds = FeeBounds
+	}
+	stats := &MempoolStats{
+		Bands: make([]*FeeBand, len(bounds)+1),
+	}
+	var lower bitcoin.FeeRate
+	for i, b := range bounds {
+		stats.Bands[i] = &FeeBand{Min: lower, Max: b}
+		lower = b
+	}
+	stats.Bands[len(bounds)] = &FeeBand{Min: lower, Max: -1}
+
+	// sort transactions by descending effective feerate
+	type item struct {
+		rate  bitcoin.FeeRate
+		entry *MempoolEntry
+	}
+	rates := EffectiveFeeRates(entries)
+	list := make([]item, 0, len(entries))
+	for id, e := range entries {
+		list = append(list, item{rates[id], e})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].rate > list[j].rate })
+
+	for _, it := range list {
+		i := sort.Search(len(bounds), func(i int) bool { return it.rate < bounds[i] })
+		b := stats.Bands[i]
+		b.Count++
+		b.VSize += it.entry.VSize
+		b.Fees += it.entry.fee()
+		stats.Count++
+		stats.VSize += it.entry.VSize
+		stats.Fees += it.entry.fee()
+		if it.entry.AncestorCount > 1 {
+			stats.Packages++
+		}
+	}
+	// median by virtual size
+	acc := 0
+	for _, it := range list {
+		if acc += it.entry.VSize; 2*acc >= stats.VSize {
+			stats.Median = it.rate
+			break
+		}
+	}
+	// estimated blocks: all transactions in higher bands and the band
+	// itself are mined first
+	acc = 0
+	for i := len(stats.Bands) - 1; i >= 0; i-- {
+		acc += stats.Bands[i].VSize
+		stats.Bands[i].Blocks = (acc + BlockVSize - 1) / BlockVSize
+		if stats.Bands[i].Blocks == 0 {
+			stats.Bands[i].Blocks = 1
+		}
+	}
+	return stats
+}
+
+// EstimateFee returns the lowest feerate (band bound) that is expected
+// to be mined within the given number of blocks. If the whole mempool
+// is mined within that time, the lowest bound (0) is returned; callers
+// should apply the minimum relay feerate.
+func (s *MempoolStats) EstimateFee(blocks int) bitcoin.FeeRate {
+	for _, b := range s.Bands {
+		if b.Blocks <= blocks {
+			return b.Min
+		}
+	}
+	// no band qualifies: use the highest band
+	if n := len(s.Bands); n > 0 {
+		return s.Bands[n-1].Min
+	}
+	return 0
+}
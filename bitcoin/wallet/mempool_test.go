@@ -0,0 +1,87 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+func TestMempoolFeeRates(t *testing.T) {
+	// parent (1 sat/vB) with child paying for it (39 sat/vB), an
+	// unrelated transaction (10 sat/vB) and a child of a high-fee parent
+	in := `{
+		"p1": {"vsize":200,"ancestorcount":1,"ancestorsize":200,"fees":{"base":0.00000200,"modified":0.00000200,"ancestor":0.00000200},"spentby":["c1"]},
+		"c1": {"vsize":200,"ancestorcount":2,"ancestorsize":400,"fees":{"base":0.00007800,"modified":0.00007800,"ancestor":0.00008000},"depends":["p1"]},
+		"t1": {"vsize":100,"ancestorcount":1,"ancestorsize":100,"fees":{"base":0.00001000,"modified":0.00001000,"ancestor":0.00001000}},
+		"p2": {"vsize":100,"ancestorcount":1,"ancestorsize":100,"fees":{"base":0.00005000,"modified":0.00005000,"ancestor":0.00005000},"spentby":["c2"]},
+		"c2": {"vsize":100,"ancestorcount":2,"ancestorsize":200,"fees":{"base":0.00000100,"modified":0.00000100,"ancestor":0.00005100},"depends":["p2"]}
+	}`
+	entries := make(map[string]*MempoolEntry)
+	if err := json.Unmarshal([]byte(in), &entries); err != nil {
+		t.Fatal(err)
+	}
+	rates := EffectiveFeeRates(entries)
+	for id, r := range map[string]bitcoin.FeeRate{
+		"p1": 20000, "c1": 20000, "t1": 10000, "p2": 50000, "c2": 1000,
+	} {
+		if rates[id] != r {
+			t.Errorf("%s: got %s, expected %s", id, rates[id], r)
+		}
+	}
+	stats := MempoolHistogram(entries, []bitcoin.FeeRate{5000, 20000})
+	if stats.Count != 5 || stats.VSize != 700 || stats.Fees != 14100 || stats.Packages != 2 {
+		t.Fatalf("stats: %+v", stats)
+	}
+	if len(stats.Bands) != 3 || stats.Bands[0].Count != 1 || stats.Bands[1].Count != 1 || stats.Bands[2].Count != 3 {
+		t.Fatalf("bands: %v %v %v", stats.Bands[0], stats.Bands[1], stats.Bands[2])
+	}
+	if stats.Median != 20000 {
+		t.Fatalf("median: %s", stats.Median)
+	}
+}
+
+func TestMempoolEstimate(t *testing.T) {
+	// 2.5 blocks at 50 sat/vB, 1 block at 10 sat/vB, 2 blocks at 2 sat/vB
+	// (a transaction is mined after all transactions in higher bands)
+	entries := make(map[string]*MempoolEntry)
+	add := func(id string, n int, rate bitcoin.FeeRate) {
+		for i := 0; i < n; i++ {
+			e := &MempoolEntry{VSize: 100000, AncestorCount: 1, AncestorSize: 100000}
+			e.Fees.Modified = rate.Fee(e.VSize)
+			e.Fees.Ancestor = e.Fees.Modified
+			entries[id+string(rune('a'+i))] = e
+		}
+	}
+	add("h", 25, 50000)
+	add("m", 10, 10000)
+	add("l", 20, 2000)
+	stats := MempoolHistogram(entries, nil)
+	for blocks, rate := range map[int]bitcoin.FeeRate{
+		1: 80000, 3: 15000, 4: 3000, 5: 3000, 6: 0,
+	} {
+		if r := stats.EstimateFee(blocks); r != rate {
+			t.Errorf("%d blocks: got %s, expected %s", blocks, r, rate)
+		}
+	}
+}
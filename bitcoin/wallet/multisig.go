@@ -0,0 +1,209 @@
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/bitcoin/script"
+)
+
+// Error codes
+var (
+	ErrMultiQuorum    = errors.New("invalid multisig quorum")
+	ErrMultiMode      = errors.New("unsupported multisig address mode")
+	ErrMultiDuplicate = errors.New("duplicate cosigner key")
+	ErrMultiDerive    = errors.New("multisig key derivation failed")
+)
+
+// MaxMultisigKeys is the maximum number of cosigners (limited by the
+// size of P2SH redeem scripts)
+const MaxMultisigKeys = 15
+
+//----------------------------------------------------------------------
+// Multi-signature accounts (m-of-n):
+// An account combines the extended public keys of all cosigners; the
+// keys for an address are derived from all xpubs and sorted (BIP-67)
+// to build a "sortedmulti" script. The account can be exported as an
+// output descriptor (BIP-380/383) for watch-only wallets.
+//----------------------------------------------------------------------
+
+// Cosigner is a participant in a multi-signature account.
+type Cosigner struct {
+	Name        string             // name of cosigner (informational)
+	Fingerprint uint32             // fingerprint of master key
+	Path        string             // derivation path of xpub (e.g. "m/48'/0'/0'/2'")
+	Xpub        *ExtendedPublicKey // account-level extended public key
+}
+
+// MultisigAccount is a m-of-n multi-signature account
+type MultisigAccount struct {
+	M         int         // number of required signatures
+	Mode      int         // address mode (AddrP2SH, AddrP2WSH, AddrP2WSHinP2SH)
+	Network   int         // network (NetwMain, NetwTest, NetwReg)
+	Cosigners []*Cosigner // list of cosigners
+}
+
+// NewMultisigAccount creates a new m-of-n account for the cosigners.
+func NewMultisigAccount(m, mode, network int, cosigners ...*Cosigner) (*MultisigAccount, error) {
+	n := len(cosigners)
+	if m < 1 || m > n || n > MaxMultisigKeys {
+		return nil, ErrMultiQuorum
+	}
+	switch mode {
+	case AddrP2SH, AddrP2WSH, AddrP2WSHinP2SH:
+	default:
+		return nil, ErrMultiMode
+	}
+	keys := make(map[string]bool)
+	for _, cs := range cosigners {
+		id := string(cs.Xpub.Key.Bytes(true))
+		if keys[id] {
+			return nil, ErrMultiDuplicate
+		}
+		keys[id] = true
+	}
+	return &MultisigAccount{
+		M:         m,
+		Mode:      mode,
+		Network:   network,
+		Cosigners: cosigners,
+	}, nil
+}
+
+// Cosigner returns the cosigner with given master key fingerprint (or
+// nil if not found).
+func (a *MultisigAccount) Cosigner(fp uint32) *Cosigner {
+	for _, cs := range a.Cosigners {
+		if cs.Fingerprint == fp {
+			return cs
+		}
+	}
+	return nil
+}
+
+// Satisfied returns true if the cosigners (identified by master key
+// fingerprints) reach the quorum of the account.
+func (a *MultisigAccount) Satisfied(signers ...uint32) bool {
+	seen := make(map[uint32]bool)
+	for _, fp := range signers {
+		if a.Cosigner(fp) != nil {
+			seen[fp] = true
+		}
+	}
+	return len(seen) >= a.M
+}
+
+// Keys returns the sorted public keys (BIP-67) for an address.
+func (a *MultisigAccount) Keys(change bool, idx uint32) ([]*bitcoin.PublicKey, error) {
+	chain := uint32(0)
+	if change {
+		chain = 1
+	}
+	list := make([]*bitcoin.PublicKey, len(a.Cosigners))
+	for i, cs := range a.Cosigners {
+		k := CKDpub(cs.Xpub, chain)
+		if k != nil {
+			k = CKDpub(k, idx)
+		}
+		if k == nil {
+			return nil, ErrMultiDerive
+		}
+		list[i] = &bitcoin.PublicKey{Q: k.Key, IsCompressed: true}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].Bytes(), list[j].Bytes()) < 0
+	})
+	return list, nil
+}
+
+// Script returns the multisig script for an address (redeem script for
+// P2SH or witness script for P2WSH).
+func (a *MultisigAccount) Script(change bool, idx uint32) (*script.Script, error) {
+	keys, err := a.Keys(change, idx)
+	if err != nil {
+		return nil, err
+	}
+	scr := script.NewScript()
+	scr.Add(script.NewStatement(byte(script.OpTRUE + a.M - 1)))
+	for _, k := range keys {
+		scr.Add(script.NewDataStatement(k.Bytes()))
+	}
+	scr.Add(script.NewStatement(byte(script.OpTRUE + len(keys) - 1)))
+	scr.Add(script.NewStatement(script.OpCHECKMULTISIG))
+	return scr, nil
+}
+
+// Address returns the address for given chain (receive or change) and
+// index.
+func (a *MultisigAccount) Address(change bool, idx uint32) (string, error) {
+	scr, err := a.Script(change, idx)
+	if err != nil {
+		return "", err
+	}
+	return MakeAddress(scr, 0, a.Mode, a.Network)
+}
+
+// Receive returns the receiving address with given index.
+func (a *MultisigAccount) Receive(idx uint32) (string, error) {
+	return a.Address(false, idx)
+}
+
+// Change returns the change address with given index.
+func (a *MultisigAccount) Change(idx uint32) (string, error) {
+	return a.Address(true, idx)
+}
+
+// Descriptor returns the output descriptor (with checksum) for the
+// receiving or change addresses of the account.
+func (a *MultisigAccount) Descriptor(change bool) string {
+	chain := 0
+	if change {
+		chain = 1
+	}
+	// plain xpub/tpub versions are used in descriptors
+	version := GetXDVersion(0, AddrP2PKH, a.Network, true)
+	keys := make([]string, len(a.Cosigners))
+	for i, cs := range a.Cosigners {
+		xpub := cs.Xpub.Clone()
+		xpub.Data.Version = version
+		origin := fmt.Sprintf("%08x", cs.Fingerprint)
+		if p, err := ParsePath(cs.Path); err == nil && len(p) > 0 {
+			origin += strings.TrimPrefix(p.Format("h"), "m")
+		}
+		keys[i] = fmt.Sprintf("[%s]%s/%d/*", origin, xpub, chain)
+	}
+	desc := fmt.Sprintf("sortedmulti(%d,%s)", a.M, strings.Join(keys, ","))
+	switch a.Mode {
+	case AddrP2SH:
+		desc = "sh(" + desc + ")"
+	case AddrP2WSH:
+		desc = "wsh(" + desc + ")"
+	case AddrP2WSHinP2SH:
+		desc = "sh(wsh(" + desc + "))"
+	}
+	return desc + "#" + DescriptorChecksum(desc)
+}
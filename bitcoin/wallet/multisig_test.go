@@ -0,0 +1,124 @@
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func newTestCosigners(t *testing.T, n int) []*Cosigner {
+	list := make([]*Cosigner, n)
+	for i := range list {
+		seed := bytes.Repeat([]byte{byte(i + 1)}, 32)
+		hd, err := NewHD(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := "m/48'/0'/0'/2'"
+		xpub, err := hd.Public(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list[i] = &Cosigner{
+			Name:        fmt.Sprintf("cosigner%d", i),
+			Fingerprint: hd.MasterPublic().Fingerprint(),
+			Path:        path,
+			Xpub:        xpub,
+		}
+	}
+	return list
+}
+
+func TestMultisigAccount(t *testing.T) {
+	cs := newTestCosigners(t, 3)
+	if _, err := NewMultisigAccount(4, AddrP2WSH, NetwMain, cs...); err != ErrMultiQuorum {
+		t.Fatal("invalid quorum accepted")
+	}
+	if _, err := NewMultisigAccount(2, AddrP2WSH, NetwMain, cs[0], cs[0]); err != ErrMultiDuplicate {
+		t.Fatal("duplicate cosigner accepted")
+	}
+	acc, err := NewMultisigAccount(2, AddrP2WSH, NetwMain, cs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cosigner order does not matter (sorted keys)
+	acc2, err := NewMultisigAccount(2, AddrP2WSH, NetwMain, cs[2], cs[0], cs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(0); i < 3; i++ {
+		a1, err := acc.Receive(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a2, _ := acc2.Receive(i)
+		c1, _ := acc.Change(i)
+		if a1 != a2 {
+			t.Fatal("address depends on cosigner order")
+		}
+		if a1 == c1 || !strings.HasPrefix(a1, "bc1q") || len(a1) != 62 {
+			t.Fatalf("invalid address %s", a1)
+		}
+	}
+	// script structure
+	scr, err := acc.Script(false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src := scr.Decompile(); !strings.HasPrefix(src, "OP_2 ") || !strings.HasSuffix(src, " OP_3 OP_CHECKMULTISIG") {
+		t.Fatalf("invalid script: %s", src)
+	}
+	// other address modes
+	for _, mode := range []int{AddrP2SH, AddrP2WSHinP2SH} {
+		acc.Mode = mode
+		addr, err := acc.Receive(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr[0] != '3' {
+			t.Fatalf("invalid address %s", addr)
+		}
+	}
+	// quorum
+	if acc.Satisfied(cs[0].Fingerprint, cs[0].Fingerprint) || !acc.Satisfied(cs[0].Fingerprint, cs[2].Fingerprint) {
+		t.Fatal("quorum check failed")
+	}
+}
+
+func TestMultisigDescriptor(t *testing.T) {
+	cs := newTestCosigners(t, 2)
+	acc, err := NewMultisigAccount(1, AddrP2WSHinP2SH, NetwMain, cs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := acc.Descriptor(true)
+	plain, err := checkDescriptor(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := fmt.Sprintf("sh(wsh(sortedmulti(1,[%08x/48h/0h/0h/2h]xpub", cs[0].Fingerprint)
+	if !strings.HasPrefix(plain, prefix) || !strings.HasSuffix(plain, "/1/*)))") {
+		t.Fatalf("invalid descriptor: %s", desc)
+	}
+}
@@ -0,0 +1,154 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+//----------------------------------------------------------------------
+// Network parameter sets for Bitcoin (mainnet, testnet, signet and
+// regtest). Address and key versions are taken from the BTC entry in
+// AddrList; the parameter set bundles them with other network-specific
+// values so test environments can be addressed by a single object.
+//----------------------------------------------------------------------
+
+// Error codes
+var (
+	ErrNetworkUnknown = errors.New("unknown network")
+)
+
+// NetworkParams for a Bitcoin network
+type NetworkParams struct {
+	Name          string // network name (as used by Bitcoin Core)
+	Network       int    // network index (NetwMain, NetwTest, ...)
+	Bech32        string // human-readable part of segwit addresses
+	SPBech32      string // human-readable part of silent payment addresses
+	WIFVersion    byte   // version byte of WIF private keys
+	PubKeyHash    byte   // version byte of P2PKH addresses
+	ScriptHash    byte   // version byte of P2SH addresses
+	MessagePrefix string // prefix for signed messages
+	DefaultPort   int    // default P2P port
+	RPCPort       int    // default RPC port
+	Challenge     []byte // block challenge script (signet only)
+}
+
+// Built-in network parameter sets
+var (
+	MainNetParams = newNetworkParams("main", NetwMain, "sp", 8333, 8332)
+	TestNetParams = newNetworkParams("test", NetwTest, "tsp", 18333, 18332)
+	SigNetParams  = newNetworkParams("signet", NetwSignet, "tsp", 38333, 38332)
+	RegTestParams = newNetworkParams("regtest", NetwReg, "tsp", 18444, 18443)
+)
+
+// DefaultSignetChallenge is the block challenge of the default signet
+// (1-of-2 multisig).
+var DefaultSignetChallenge, _ = hex.DecodeString(
+	"512103ad5e0edad18cb1f0fc0d28a3d4f1f3e445640337489abb10404f2d1e086be4" +
+		"30210359ef5021964fe22d6f8e05b2463c9540ce96883fe3b278760f048f5189f2e6c452ae")
+
+func init() {
+	SigNetParams.Challenge = DefaultSignetChallenge
+}
+
+// CustomSigNetParams returns the parameter set for a custom signet
+// with given block challenge.
+func CustomSigNetParams(challenge []byte) *NetworkParams {
+	p := *SigNetParams
+	p.Challenge = bytes.Clone(challenge)
+	return &p
+}
+
+// newNetworkParams creates a parameter set from the BTC address formats.
+func newNetworkParams(name string, netw int, sp string, port, rpc int) *NetworkParams {
+	af := AddrList[0].Formats[netw]
+	return &NetworkParams{
+		Name:          name,
+		Network:       netw,
+		Bech32:        af.Bech32,
+		SPBech32:      sp,
+		WIFVersion:    af.WifVersion,
+		PubKeyHash:    byte(af.Versions[AddrP2PKH].Version),
+		ScriptHash:    byte(af.Versions[AddrP2SH].Version),
+		MessagePrefix: bitcoin.MessagePrefix,
+		DefaultPort:   port,
+		RPCPort:       rpc,
+	}
+}
+
+// GetNetworkParams returns the parameter set for a network name
+// ("main", "test", "signet" or "regtest"; common aliases accepted).
+func GetNetworkParams(name string) (*NetworkParams, error) {
+	switch strings.ToLower(name) {
+	case "main", "mainnet", "bitcoin":
+		return MainNetParams, nil
+	case "test", "testnet", "testnet3":
+		return TestNetParams, nil
+	case "signet":
+		return SigNetParams, nil
+	case "regtest", "reg", "regnet":
+		return RegTestParams, nil
+	}
+	return nil, ErrNetworkUnknown
+}
+
+// NetworkParamsFor returns the parameter set for a network index
+// (NetwMain, NetwTest, NetwReg or NetwSignet).
+func NetworkParamsFor(netw int) (*NetworkParams, error) {
+	switch netw {
+	case NetwMain:
+		return MainNetParams, nil
+	case NetwTest:
+		return TestNetParams, nil
+	case NetwSignet:
+		return SigNetParams, nil
+	case NetwReg:
+		return RegTestParams, nil
+	}
+	return nil, ErrNetworkUnknown
+}
+
+// Address generates an address of given version (AddrP2PKH, ...) for
+// a public key or script.
+func (p *NetworkParams) Address(obj any, version int) (string, error) {
+	return MakeAddress(obj, 0, version, p.Network)
+}
+
+// XDVersion returns the BIP32 version for extended keys of an address
+// mode.
+func (p *NetworkParams) XDVersion(mode int, pub bool) uint32 {
+	return GetXDVersion(0, mode, p.Network, pub)
+}
+
+// WIF encodes a private key in Wallet Import Format.
+func (p *NetworkParams) WIF(key *bitcoin.PrivateKey) string {
+	return ToWIF(key, p.WIFVersion)
+}
+
+// MessageHash returns the hash of a message for signing.
+func (p *NetworkParams) MessageHash(msg []byte) []byte {
+	return bitcoin.MessageHashPrefix(p.MessagePrefix, msg)
+}
@@ -0,0 +1,106 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+func TestNetworkParams(t *testing.T) {
+	key := bitcoin.GenerateKeys(true)
+	for _, tc := range []struct {
+		name   string
+		params *NetworkParams
+		segwit string
+		legacy string
+		wif    byte
+	}{
+		{"mainnet", MainNetParams, "bc1q", "1", 'K'},
+		{"testnet3", TestNetParams, "tb1q", "mn", 'c'},
+		{"signet", SigNetParams, "tb1q", "mn", 'c'},
+		{"regtest", RegTestParams, "bcrt1q", "mn", 'c'},
+	} {
+		p, err := GetNetworkParams(tc.name)
+		if err != nil || p != tc.params {
+			t.Fatalf("%s: lookup failed", tc.name)
+		}
+		if q, _ := NetworkParamsFor(p.Network); q != p {
+			t.Fatalf("%s: lookup by index failed", tc.name)
+		}
+		addr, err := p.Address(&key.PublicKey, AddrP2WPKH)
+		if err != nil || !strings.HasPrefix(addr, tc.segwit) {
+			t.Fatalf("%s: segwit address %s (%v)", tc.name, addr, err)
+		}
+		if addr, err = p.Address(&key.PublicKey, AddrP2PKH); err != nil || !strings.ContainsAny(addr[:1], tc.legacy) {
+			t.Fatalf("%s: legacy address %s (%v)", tc.name, addr, err)
+		}
+		wif := p.WIF(key)
+		if wif[0] != tc.wif && !(tc.wif == 'K' && wif[0] == 'L') {
+			t.Fatalf("%s: WIF %s", tc.name, wif)
+		}
+		if !bytes.Equal(p.MessageHash([]byte("test")), bitcoin.MessageHash([]byte("test"))) {
+			t.Fatalf("%s: message hash", tc.name)
+		}
+	}
+	if _, err := GetNetworkParams("florin"); err != ErrNetworkUnknown {
+		t.Fatal("unknown network accepted")
+	}
+
+	// HD key versions
+	seed := make([]byte, 32)
+	hd, err := NewHDNet(seed, SigNetParams, AddrP2WPKH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := hd.MasterPrivate().String(); !strings.HasPrefix(s, "vprv") {
+		t.Fatalf("signet zprv: %s", s)
+	}
+	if s := hd.MasterPublic().String(); !strings.HasPrefix(s, "vpub") {
+		t.Fatalf("signet zpub: %s", s)
+	}
+	// silent payment address prefix
+	sp := &SPAddress{Scan: key.Q, Spend: key.Q, Network: NetwSignet}
+	if !strings.HasPrefix(sp.String(), "tsp1") {
+		t.Fatal("signet SP address")
+	}
+}
+
+func TestSignetParams(t *testing.T) {
+	if !bytes.Equal(SigNetParams.Challenge, DefaultSignetChallenge) {
+		t.Fatal("default signet challenge missing")
+	}
+	p := CustomSigNetParams([]byte{0x51})
+	if p.Name != "signet" || p.Bech32 != "tb" || !bytes.Equal(p.Challenge, []byte{0x51}) {
+		t.Fatal("custom signet params")
+	}
+	if !bytes.Equal(SigNetParams.Challenge, DefaultSignetChallenge) {
+		t.Fatal("default signet params modified")
+	}
+	for _, p := range []*NetworkParams{MainNetParams, TestNetParams, RegTestParams} {
+		if p.Challenge != nil {
+			t.Fatalf("%s: unexpected challenge", p.Name)
+		}
+	}
+}
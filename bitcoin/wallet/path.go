@@ -0,0 +1,248 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	gerr "github.com/bfix/gospel/errors"
+)
+
+//----------------------------------------------------------------------
+// BIP32 derivation paths like "m/44'/0'/0'/0/1". Hardened elements can
+// be marked with "'", "h" or "H"; the leading "m" is optional (relative
+// paths like "84'/1'/0'" are accepted). Paths are formatted canonically
+// with "'" marks.
+//----------------------------------------------------------------------
+
+// Hardened is the offset of hardened child indices.
+const Hardened uint32 = 1 << 31
+
+// Path is a BIP32 derivation path (list of child indices)
+type Path []uint32
+
+// ParsePath parses a derivation path.
+func ParsePath(s string) (Path, error) {
+	elems, err := splitPath(s)
+	if err != nil {
+		return nil, err
+	}
+	p := make(Path, 0, len(elems))
+	for _, id := range elems {
+		i, err := parseIndex(id)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, i)
+	}
+	return p, nil
+}
+
+// String returns the canonical representation of the path.
+func (p Path) String() string {
+	return p.Format("'")
+}
+
+// Format returns the path with given hardened mark ("'", "h" or "H").
+func (p Path) Format(mark string) string {
+	buf := new(strings.Builder)
+	buf.WriteString("m")
+	for _, i := range p {
+		buf.WriteString("/")
+		buf.WriteString(formatIndex(i, mark))
+	}
+	return buf.String()
+}
+
+// Depth of the path (number of elements).
+func (p Path) Depth() int {
+	return len(p)
+}
+
+// Child returns the path to child 'i' (use 'Hardened+i' for hardened
+// children).
+func (p Path) Child(i uint32) Path {
+	return p.Append(Path{i})
+}
+
+// Parent returns the path to the parent (nil for the master key).
+func (p Path) Parent() Path {
+	if len(p) == 0 {
+		return nil
+	}
+	return append(Path{}, p[:len(p)-1]...)
+}
+
+// Append a (relative) path.
+func (p Path) Append(q Path) Path {
+	out := make(Path, 0, len(p)+len(q))
+	out = append(out, p...)
+	return append(out, q...)
+}
+
+// IsHardened returns true if the path contains hardened elements.
+func (p Path) IsHardened() bool {
+	for _, i := range p {
+		if i >= Hardened {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPrefix returns true if the path starts with path 'q'.
+func (p Path) HasPrefix(q Path) bool {
+	if len(q) > len(p) {
+		return false
+	}
+	return p[:len(q)].Equals(q)
+}
+
+// Equals returns true if both paths are the same.
+func (p Path) Equals(q Path) bool {
+	if len(p) != len(q) {
+		return false
+	}
+	for i, v := range p {
+		if v != q[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ExpandPath expands a path template as used in output descriptors: a
+// wildcard ("*", or "*'" for hardened children) as last element is
+// replaced by the indices 'from' to 'to' (inclusive); a multipath
+// element ("<0;1>") yields a path for each alternative.
+func ExpandPath(s string, from, to uint32) ([]Path, error) {
+	if from > to || to >= Hardened {
+		return nil, gerr.New(ErrHDIndex, "range %d-%d", from, to)
+	}
+	elems, err := splitPath(s)
+	if err != nil {
+		return nil, err
+	}
+	list := []Path{{}}
+	multi := false
+	for n, id := range elems {
+		var alts []uint32
+		switch {
+		case id == "*" || isHardenedWildcard(id):
+			if n != len(elems)-1 {
+				return nil, gerr.New(ErrHDSegment, "wildcard not last element")
+			}
+			var offs uint32
+			if id != "*" {
+				offs = Hardened
+			}
+			for i := from; i <= to; i++ {
+				alts = append(alts, i+offs)
+			}
+		case strings.HasPrefix(id, "<") && strings.HasSuffix(id, ">"):
+			if multi {
+				return nil, gerr.New(ErrHDSegment, "multiple multipath elements")
+			}
+			multi = true
+			parts := strings.Split(id[1:len(id)-1], ";")
+			if len(parts) < 2 {
+				return nil, gerr.New(ErrHDSegment, "'%s'", id)
+			}
+			for _, part := range parts {
+				i, err := parseIndex(part)
+				if err != nil {
+					return nil, err
+				}
+				alts = append(alts, i)
+			}
+		default:
+			i, err := parseIndex(id)
+			if err != nil {
+				return nil, err
+			}
+			alts = []uint32{i}
+		}
+		var next []Path
+		for _, p := range list {
+			for _, i := range alts {
+				next = append(next, p.Child(i))
+			}
+		}
+		list = next
+	}
+	return list, nil
+}
+
+//----------------------------------------------------------------------
+// helpers
+//----------------------------------------------------------------------
+
+// splitPath splits a path into its elements.
+func splitPath(s string) ([]string, error) {
+	if s == "m" || s == "M" {
+		return nil, nil
+	}
+	if strings.HasPrefix(s, "m/") || strings.HasPrefix(s, "M/") {
+		s = s[2:]
+	} else {
+		s = strings.TrimPrefix(s, "/")
+	}
+	if len(s) == 0 {
+		return nil, gerr.New(ErrHDSegment, "empty path")
+	}
+	return strings.Split(s, "/"), nil
+}
+
+// parseIndex parses a path element.
+func parseIndex(id string) (uint32, error) {
+	var offs uint32
+	if n := len(id); n > 0 && (id[n-1] == '\'' || id[n-1] == 'h' || id[n-1] == 'H') {
+		offs = Hardened
+		id = id[:n-1]
+	}
+	// reject signs and other non-digit prefixes accepted by ParseUint
+	if len(id) == 0 || id[0] < '0' || id[0] > '9' {
+		return 0, gerr.New(ErrHDSegment, "'%s'", id)
+	}
+	i, err := strconv.ParseUint(id, 10, 31)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, gerr.New(ErrHDIndex, "'%s'", id)
+		}
+		return 0, gerr.New(ErrHDSegment, "'%s'", id)
+	}
+	return uint32(i) + offs, nil
+}
+
+// formatIndex returns the string representation of a path element.
+func formatIndex(i uint32, mark string) string {
+	if i >= Hardened {
+		return strconv.FormatUint(uint64(i-Hardened), 10) + mark
+	}
+	return strconv.FormatUint(uint64(i), 10)
+}
+
+// isHardenedWildcard returns true for hardened wildcard elements.
+func isHardenedWildcard(id string) bool {
+	return id == "*'" || id == "*h" || id == "*H"
+}
@@ -0,0 +1,156 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPathParse(t *testing.T) {
+	for _, tc := range []struct {
+		in, out string
+		depth   int
+	}{
+		{"m", "m", 0},
+		{"m/44'/0'/0'/0/1", "m/44'/0'/0'/0/1", 5},
+		{"m/44h/0h/0h/0/1", "m/44'/0'/0'/0/1", 5},
+		{"m/44H/0H/0H/1/0", "m/44'/0'/0'/1/0", 5},
+		{"84'/1'/0'", "m/84'/1'/0'", 3},
+		{"/48h/0h/0h/2h", "m/48'/0'/0'/2'", 4},
+		{"m/2147483647'", "m/2147483647'", 1},
+	} {
+		p, err := ParsePath(tc.in)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.in, err)
+		}
+		if p.String() != tc.out || p.Depth() != tc.depth {
+			t.Fatalf("%s: got %s (%d)", tc.in, p, p.Depth())
+		}
+	}
+	for _, s := range []string{"", "m/", "m//1", "m/x", "m/-1", "m/+1", "m/1''", "m/2147483648", "m/1'/a'"} {
+		if _, err := ParsePath(s); !errors.Is(err, ErrHDPath) {
+			t.Fatalf("%s: accepted (%v)", s, err)
+		}
+	}
+}
+
+func TestPathArithmetic(t *testing.T) {
+	p, err := ParsePath("m/84'/0'/0'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := p.Child(0).Child(7)
+	if c.String() != "m/84'/0'/0'/0/7" {
+		t.Fatalf("child: %s", c)
+	}
+	if p.String() != "m/84'/0'/0'" {
+		t.Fatal("parent modified")
+	}
+	if !c.HasPrefix(p) || p.HasPrefix(c) {
+		t.Fatal("prefix check")
+	}
+	if !c.Parent().Parent().Equals(p) {
+		t.Fatal("parent")
+	}
+	if Path(nil).Parent() != nil {
+		t.Fatal("parent of master")
+	}
+	if !p.Append(Path{1, 2}).Equals(p.Child(1).Child(2)) {
+		t.Fatal("append")
+	}
+	if !p.IsHardened() || (Path{0, 1}).IsHardened() {
+		t.Fatal("hardened check")
+	}
+	if s := p.Child(Hardened + 1).Format("h"); s != "m/84h/0h/0h/1h" {
+		t.Fatalf("format: %s", s)
+	}
+}
+
+func TestPathExpand(t *testing.T) {
+	list, err := ExpandPath("m/84h/0h/0h/<0;1>/*", 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		"m/84'/0'/0'/0/3", "m/84'/0'/0'/0/4", "m/84'/0'/0'/0/5",
+		"m/84'/0'/0'/1/3", "m/84'/0'/0'/1/4", "m/84'/0'/0'/1/5",
+	}
+	if len(list) != len(exp) {
+		t.Fatalf("expanded %d paths", len(list))
+	}
+	for i, p := range list {
+		if p.String() != exp[i] {
+			t.Fatalf("expand #%d: %s", i, p)
+		}
+	}
+	if list, err = ExpandPath("0/*'", 0, 1); err != nil || len(list) != 2 || list[1].String() != "m/0/1'" {
+		t.Fatalf("hardened wildcard: %v %v", list, err)
+	}
+	for _, s := range []string{"m/*/0", "m/<0>/*", "m/<0;1>/<2;3>/*", "m/<0;x>/*"} {
+		if _, err := ExpandPath(s, 0, 1); !errors.Is(err, ErrHDPath) {
+			t.Fatalf("%s: accepted (%v)", s, err)
+		}
+	}
+	if _, err := ExpandPath("m/*", 2, 1); !errors.Is(err, ErrHDPath) {
+		t.Fatal("invalid range accepted")
+	}
+}
+
+func TestPathDerive(t *testing.T) {
+	hd := testHD()
+	for _, d := range pathData {
+		p, err := ParsePath(d[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		prv, err := hd.PrivateAt(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prv.String() != d[2] {
+			t.Fatalf("%s: private key mismatch", d[0])
+		}
+	}
+	// public derivation from relative paths
+	pub, err := hd.Public("m/0'/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdp := NewHDPublic(pub, "m/0h/1")
+	if _, err = hdp.PublicAt(Path{Hardened + 2}); err != ErrHDHardened {
+		t.Fatal("hardened public derivation")
+	}
+	k1, err := hdp.PublicAt(Path{2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := hdp.Public("m/0'/1/2/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1.String() != k2.String() {
+		t.Fatal("relative derivation mismatch")
+	}
+	if _, err = hdp.Public("m/0'/2/2"); err != ErrHDPath {
+		t.Fatal("foreign path accepted")
+	}
+}
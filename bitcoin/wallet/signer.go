@@ -0,0 +1,233 @@
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+// Error codes
+var (
+	ErrSignerNotSupported = errors.New("operation not supported by signer")
+	ErrSignerDevice       = errors.New("signing device failed")
+)
+
+//----------------------------------------------------------------------
+// Signer is an abstraction for entities holding private keys (software
+// wallets or hardware devices). Applications request public keys and
+// signatures from a signer without access to the private keys.
+// Paths are BIP32 derivation paths like "m/44'/0'/0'/0/1".
+//----------------------------------------------------------------------
+
+// Signer interface
+type Signer interface {
+	// GetXpub returns the extended public key for a derivation path.
+	GetXpub(path string) (*ExtendedPublicKey, error)
+
+	// SignPSBT signs a (base64-encoded) PSBT and returns the updated PSBT.
+	SignPSBT(psbt string) (string, error)
+
+	// SignMessage signs a message with the key at derivation path and
+	// returns a base64-encoded compact signature ("signmessage" format).
+	SignMessage(path string, msg []byte) (string, error)
+
+	// DisplayAddress returns the address of given version (AddrP2PKH,
+	// AddrP2WPKH or AddrP2WPKHinP2SH) for the key at derivation path. A
+	// hardware signer shows the address on the device for verification.
+	DisplayAddress(path string, version int) (string, error)
+}
+
+//----------------------------------------------------------------------
+// Software signer (HD key space)
+//----------------------------------------------------------------------
+
+// HDSigner is a software signer based on a HD key space.
+type HDSigner struct {
+	hd      *HD // key space
+	coin    int // coin identifier
+	network int // network (NetwMain, NetwTest, NetwReg)
+}
+
+// NewHDSigner creates a new software signer for a HD key space.
+func NewHDSigner(hd *HD, coin, network int) *HDSigner {
+	return &HDSigner{
+		hd:      hd,
+		coin:    coin,
+		network: network,
+	}
+}
+
+// GetXpub returns the extended public key for a derivation path.
+func (s *HDSigner) GetXpub(path string) (*ExtendedPublicKey, error) {
+	return s.hd.Public(path)
+}
+
+// SignPSBT is not supported by the software signer.
+func (s *HDSigner) SignPSBT(psbt string) (string, error) {
+	return "", ErrSignerNotSupported
+}
+
+// SignMessage signs a message with the key at derivation path.
+func (s *HDSigner) SignMessage(path string, msg []byte) (string, error) {
+	prv, err := s.hd.Private(path)
+	if err != nil {
+		return "", err
+	}
+	key, err := bitcoin.PrivateKeyFromBytes(prv.Key.FixedBytes(32))
+	if err != nil {
+		return "", err
+	}
+	key.IsCompressed = true
+	return bitcoin.SignMessage(key, msg), nil
+}
+
+// DisplayAddress returns the address for the key at derivation path.
+func (s *HDSigner) DisplayAddress(path string, version int) (string, error) {
+	pub, err := s.hd.Public(path)
+	if err != nil {
+		return "", err
+	}
+	key := &bitcoin.PublicKey{
+		Q:            pub.Key,
+		IsCompressed: true,
+	}
+	return MakeAddress(key, s.coin, version, s.network)
+}
+
+//----------------------------------------------------------------------
+// Hardware signer (HWI-compatible)
+//----------------------------------------------------------------------
+
+// HWISigner delegates signing to a hardware device by calling the
+// HWI command line tool (https://github.com/bitcoin-core/HWI). Only
+// Bitcoin is supported.
+type HWISigner struct {
+	// Binary is the path to the 'hwi' executable
+	Binary string
+	// Fingerprint of the master key on the device (hex)
+	Fingerprint string
+	// Network of the device (NetwMain, NetwTest, NetwReg, NetwSignet)
+	Network int
+
+	// run command (replaceable for testing)
+	run func(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// NewHWISigner creates a new signer for the device with given master key
+// fingerprint.
+func NewHWISigner(fingerprint string, network int) *HWISigner {
+	return &HWISigner{
+		Binary:      "hwi",
+		Fingerprint: fingerprint,
+		Network:     network,
+		run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+			return exec.CommandContext(ctx, name, args...).Output()
+		},
+	}
+}
+
+// GetXpub returns the extended public key for a derivation path.
+func (s *HWISigner) GetXpub(path string) (*ExtendedPublicKey, error) {
+	var res struct {
+		Xpub string `json:"xpub"`
+	}
+	if err := s.call(&res, "getxpub", path); err != nil {
+		return nil, err
+	}
+	return ParseExtendedPublicKey(res.Xpub)
+}
+
+// SignPSBT signs a (base64-encoded) PSBT on the device.
+func (s *HWISigner) SignPSBT(psbt string) (string, error) {
+	var res struct {
+		Psbt string `json:"psbt"`
+	}
+	if err := s.call(&res, "signtx", psbt); err != nil {
+		return "", err
+	}
+	return res.Psbt, nil
+}
+
+// SignMessage signs a message on the device with the key at derivation path.
+func (s *HWISigner) SignMessage(path string, msg []byte) (string, error) {
+	var res struct {
+		Signature string `json:"signature"`
+	}
+	if err := s.call(&res, "signmessage", string(msg), path); err != nil {
+		return "", err
+	}
+	return res.Signature, nil
+}
+
+// DisplayAddress shows the address for the key at derivation path on the
+// device and returns it.
+func (s *HWISigner) DisplayAddress(path string, version int) (string, error) {
+	var addrType string
+	switch version {
+	case AddrP2PKH:
+		addrType = "legacy"
+	case AddrP2WPKHinP2SH:
+		addrType = "sh_wit"
+	case AddrP2WPKH:
+		addrType = "wit"
+	default:
+		return "", ErrMkAddrVersion
+	}
+	var res struct {
+		Address string `json:"address"`
+	}
+	if err := s.call(&res, "displayaddress", "--path", path, "--addr-type", addrType); err != nil {
+		return "", err
+	}
+	return res.Address, nil
+}
+
+// call HWI command and decode JSON result
+func (s *HWISigner) call(res any, cmd string, args ...string) error {
+	params, err := NetworkParamsFor(s.Network)
+	if err != nil {
+		return err
+	}
+	argv := []string{"--fingerprint", s.Fingerprint, "--chain", params.Name, cmd}
+	argv = append(argv, args...)
+	out, err := s.run(context.Background(), s.Binary, argv...)
+	if err != nil {
+		return err
+	}
+	// check for error response
+	var e struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}
+	if err = json.Unmarshal(out, &e); err != nil {
+		return err
+	}
+	if len(e.Error) > 0 {
+		return fmt.Errorf("%w: %s (%d)", ErrSignerDevice, e.Error, e.Code)
+	}
+	return json.Unmarshal(out, res)
+}
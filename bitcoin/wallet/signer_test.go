@@ -0,0 +1,105 @@
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package wallet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bfix/gospel/bitcoin"
+)
+
+func TestHDSigner(t *testing.T) {
+	seed := make([]byte, 32)
+	hd, err := NewHD(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Signer = NewHDSigner(hd, 0, NetwMain)
+
+	path := "m/44'/0'/0'/0/0"
+	xpub, err := s.GetXpub(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("Signed by gospel")
+	sig, err := s.SignMessage(path, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := bitcoin.VerifyMessage(msg, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Q.Equals(xpub.Key) {
+		t.Fatal("signer key mismatch")
+	}
+	addr, err := s.DisplayAddress(path, AddrP2PKH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr2, err := MakeAddress(pub, 0, AddrP2PKH, NetwMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != addr2 {
+		t.Fatal("address mismatch")
+	}
+	if _, err = s.SignPSBT(""); !errors.Is(err, ErrSignerNotSupported) {
+		t.Fatal("PSBT signing should not be supported")
+	}
+}
+
+func TestHWISigner(t *testing.T) {
+	hd, err := NewHD(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "m/84'/0'/0'"
+	xpub, err := hd.Public(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewHWISigner("deadbeef", NetwMain)
+	s.run = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		switch args[4] {
+		case "getxpub":
+			return []byte(`{"xpub":"` + xpub.String() + `"}`), nil
+		case "displayaddress":
+			return []byte(`{"address":"bc1qaddress"}`), nil
+		}
+		return []byte(`{"error":"Not implemented","code":-9}`), nil
+	}
+	key, err := s.GetXpub(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.String() != xpub.String() {
+		t.Fatal("xpub mismatch")
+	}
+	if addr, err := s.DisplayAddress(path, AddrP2WPKH); err != nil || addr != "bc1qaddress" {
+		t.Fatalf("address failed: %v", err)
+	}
+	if _, err = s.SignPSBT("cHNidP8B"); !errors.Is(err, ErrSignerDevice) {
+		t.Fatal("expected device error")
+	}
+}
@@ -0,0 +1,396 @@
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/math"
+)
+
+//======================================================================
+// Silent payments (BIP-352)
+//
+// A silent payment address carries two public keys (scan and spend).
+// A sender derives a fresh taproot output for the recipient from the
+// private keys of the transaction inputs (ECDH with the scan key); the
+// recipient finds incoming payments by scanning transactions with the
+// private scan key. No interaction between sender and recipient is
+// required and no output can be linked to the published address.
+//======================================================================
+
+// Errors
+var (
+	ErrSPAddress  = errors.New("invalid silent payment address")
+	ErrSPVersion  = errors.New("unsupported silent payment version")
+	ErrSPInputs   = errors.New("no eligible inputs")
+	ErrSPOutpoint = errors.New("invalid outpoint")
+	ErrSPKey      = errors.New("invalid silent payment key")
+)
+
+// tagged hash prefixes (BIP-340 style)
+const (
+	spTagInputs = "BIP0352/Inputs"
+	spTagShared = "BIP0352/SharedSecret"
+	spTagLabel  = "BIP0352/Label"
+)
+
+//----------------------------------------------------------------------
+// Silent payment address
+//----------------------------------------------------------------------
+
+// SPAddress is a silent payment address
+type SPAddress struct {
+	Scan    *bitcoin.Point // public scan key
+	Spend   *bitcoin.Point // public spend key (labeled if applicable)
+	Network int            // network (NetwMain, NetwTest, NetwReg)
+}
+
+// String returns the Bech32m encoding of the address.
+func (a *SPAddress) String() string {
+	hrp := "tsp"
+	if params, err := NetworkParamsFor(a.Network); err == nil {
+		hrp = params.SPBech32
+	}
+	data := append(a.Scan.Bytes(true), a.Spend.Bytes(true)...)
+	return Bech32Encode(hrp, append([]byte{0}, Bech32Bit5(data)...), true)
+}
+
+// ParseSPAddress decodes a silent payment address. Addresses with
+// versions 1 to 30 are parsed in a forward-compatible way (only the
+// first 66 bytes of the payload are used).
+func ParseSPAddress(s string) (*SPAddress, error) {
+	hrp, data, isM, err := Bech32Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	addr := new(SPAddress)
+	switch hrp {
+	case "sp":
+		addr.Network = NetwMain
+	case "tsp":
+		addr.Network = NetwTest
+	default:
+		return nil, ErrSPAddress
+	}
+	if !isM || len(data) == 0 {
+		return nil, ErrSPAddress
+	}
+	if data[0] == 31 {
+		return nil, ErrSPVersion
+	}
+	buf, err := Bech32Bit8(data[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 66 || (data[0] == 0 && len(buf) != 66) {
+		return nil, ErrSPAddress
+	}
+	if addr.Scan, err = spPoint(buf[:33]); err != nil {
+		return nil, err
+	}
+	if addr.Spend, err = spPoint(buf[33:66]); err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
+//----------------------------------------------------------------------
+// Silent payment keys (recipient)
+//----------------------------------------------------------------------
+
+// SPKeys is the set of private keys of a silent payment recipient.
+type SPKeys struct {
+	Scan    *math.Int // private scan key
+	Spend   *math.Int // private spend key
+	Network int       // network (NetwMain, NetwTest, NetwReg)
+}
+
+// NewSPKeys derives the scan and spend keys for an account from a HD
+// key space ("m/352'/coin'/account'/1'/0" for the scan key and
+// "m/352'/coin'/account'/0'/0" for the spend key).
+func NewSPKeys(hd *HD, account, network int) (*SPKeys, error) {
+	coin := 0
+	if network != NetwMain {
+		coin = 1
+	}
+	path := Path{Hardened + 352, Hardened + uint32(coin), Hardened + uint32(account)}
+	scan, err := hd.PrivateAt(path.Append(Path{Hardened + 1, 0}))
+	if err != nil {
+		return nil, err
+	}
+	spend, err := hd.PrivateAt(path.Append(Path{Hardened, 0}))
+	if err != nil {
+		return nil, err
+	}
+	return &SPKeys{
+		Scan:    scan.Key,
+		Spend:   spend.Key,
+		Network: network,
+	}, nil
+}
+
+// Address returns the (unlabeled) silent payment address.
+func (k *SPKeys) Address() *SPAddress {
+	return &SPAddress{
+		Scan:    bitcoin.MultBase(k.Scan),
+		Spend:   bitcoin.MultBase(k.Spend),
+		Network: k.Network,
+	}
+}
+
+// LabeledAddress returns the silent payment address for label 'm'.
+// Label 0 is reserved for change outputs.
+func (k *SPKeys) LabeledAddress(m uint32) *SPAddress {
+	addr := k.Address()
+	addr.Spend = addr.Spend.Add(bitcoin.MultBase(k.label(m)))
+	return addr
+}
+
+// label computes the tweak for label 'm'
+func (k *SPKeys) label(m uint32) *math.Int {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, m)
+	return spTaggedInt(spTagLabel, k.Scan.FixedBytes(32), buf)
+}
+
+// SPOutput is a payment found by scanning a transaction.
+type SPOutput struct {
+	XOnly []byte    // x-only public key of output (32 bytes)
+	Tweak *math.Int // tweak to add to the spend key
+	Label int       // label of output (-1 if unlabeled)
+}
+
+// ScanTx scans a transaction for silent payments. 'outpoints' are the
+// serialized outpoints (txid and vout; 36 bytes) of all inputs and 'inputs'
+// the public keys of all eligible inputs of the transaction (taproot keys
+// with even y). 'outputs' are the x-only keys of the taproot outputs and
+// 'labels' the list of labels in use by the recipient.
+func (k *SPKeys) ScanTx(outpoints [][]byte, inputs []*bitcoin.Point, outputs [][]byte, labels []uint32) ([]*SPOutput, error) {
+	if len(inputs) == 0 {
+		return nil, ErrSPInputs
+	}
+	A := inputs[0]
+	for _, p := range inputs[1:] {
+		A = A.Add(p)
+	}
+	if A.IsInf() {
+		return nil, ErrSPInputs
+	}
+	hash, err := spInputHash(outpoints, A)
+	if err != nil {
+		return nil, err
+	}
+	shared := A.Mult(hash.Mul(k.Scan).Mod(c.N))
+
+	// precompute label points
+	lblPnts := make(map[uint32]*bitcoin.Point)
+	for _, m := range labels {
+		lblPnts[m] = bitcoin.MultBase(k.label(m))
+	}
+	// check outputs for increasing counter values
+	B := bitcoin.MultBase(k.Spend)
+	pending := make([][]byte, len(outputs))
+	copy(pending, outputs)
+	var res []*SPOutput
+	for n := uint32(0); len(pending) > 0; n++ {
+		t := spSharedTweak(shared, n)
+		P := B.Add(bitcoin.MultBase(t))
+		found := false
+	loop:
+		for i, x := range pending {
+			out := &SPOutput{XOnly: x, Tweak: t, Label: -1}
+			if bytes.Equal(x, P.Bytes(true)[1:]) {
+				found = true
+			} else if len(labels) > 0 {
+				Q, err := spPoint(append([]byte{2}, x...))
+				if err != nil {
+					continue
+				}
+				// output = P + label*G (for both possible y values)
+				for _, cand := range []*bitcoin.Point{Q, spNeg(Q)} {
+					diff := cand.Add(spNeg(P))
+					for m, L := range lblPnts {
+						if diff.Equals(L) {
+							out.Tweak = t.Add(k.label(m)).Mod(c.N)
+							out.Label = int(m)
+							found = true
+							break
+						}
+					}
+					if found {
+						break
+					}
+				}
+			}
+			if found {
+				res = append(res, out)
+				pending = append(pending[:i], pending[i+1:]...)
+				break loop
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return res, nil
+}
+
+// PrivateKey returns the private key to spend a found output. The key
+// is normalized to a public key with even y (as required for taproot).
+func (k *SPKeys) PrivateKey(out *SPOutput) *math.Int {
+	d := k.Spend.Add(out.Tweak).Mod(c.N)
+	if bitcoin.MultBase(d).Y().Bit(0) == 1 {
+		d = c.N.Sub(d)
+	}
+	return d
+}
+
+//----------------------------------------------------------------------
+// Sending silent payments
+//----------------------------------------------------------------------
+
+// SPInput is a transaction input of a sender. Inputs that are not
+// eligible for silent payments have no key; they only contribute their
+// outpoint to the input hash.
+type SPInput struct {
+	Outpoint []byte    // serialized outpoint (txid and vout; 36 bytes)
+	Key      *math.Int // private key of the input (nil if not eligible)
+	Taproot  bool      // key is used in a taproot output
+}
+
+// SPOutputs computes the x-only output keys for a list of recipients
+// spending the given inputs. The keys are returned in the same order
+// as the recipients.
+func SPOutputs(inputs []*SPInput, recipients []*SPAddress) ([][]byte, error) {
+	if len(inputs) == 0 {
+		return nil, ErrSPInputs
+	}
+	// sum input keys (taproot keys with odd y are negated)
+	a := math.ZERO
+	outpoints := make([][]byte, len(inputs))
+	for i, in := range inputs {
+		outpoints[i] = in.Outpoint
+		d := in.Key
+		if d == nil {
+			continue
+		}
+		if d.Sign() == 0 || d.Cmp(c.N) >= 0 {
+			return nil, ErrSPKey
+		}
+		if in.Taproot && bitcoin.MultBase(d).Y().Bit(0) == 1 {
+			d = c.N.Sub(d)
+		}
+		a = a.Add(d).Mod(c.N)
+	}
+	if a.Sign() == 0 {
+		return nil, ErrSPInputs
+	}
+	hash, err := spInputHash(outpoints, bitcoin.MultBase(a))
+	if err != nil {
+		return nil, err
+	}
+	a = a.Mul(hash).Mod(c.N)
+
+	// compute outputs (counter per scan key)
+	res := make([][]byte, len(recipients))
+	shared := make(map[string]*bitcoin.Point)
+	count := make(map[string]uint32)
+	for i, r := range recipients {
+		id := string(r.Scan.Bytes(true))
+		S, ok := shared[id]
+		if !ok {
+			S = r.Scan.Mult(a)
+			shared[id] = S
+		}
+		t := spSharedTweak(S, count[id])
+		count[id]++
+		res[i] = r.Spend.Add(bitcoin.MultBase(t)).Bytes(true)[1:]
+	}
+	return res, nil
+}
+
+// SPScript returns the (taproot) output script for an x-only key.
+func SPScript(xonly []byte) []byte {
+	return append([]byte{0x51, 0x20}, xonly...)
+}
+
+//----------------------------------------------------------------------
+// Helper functions
+//----------------------------------------------------------------------
+
+// spInputHash computes the input hash from the smallest outpoint and
+// the sum of input public keys.
+func spInputHash(outpoints [][]byte, A *bitcoin.Point) (*math.Int, error) {
+	var min []byte
+	for _, op := range outpoints {
+		if len(op) != 36 {
+			return nil, ErrSPOutpoint
+		}
+		if min == nil || bytes.Compare(op, min) < 0 {
+			min = op
+		}
+	}
+	if min == nil {
+		return nil, ErrSPOutpoint
+	}
+	return spTaggedInt(spTagInputs, min, A.Bytes(true)), nil
+}
+
+// spSharedTweak computes the output tweak for counter 'n'
+func spSharedTweak(shared *bitcoin.Point, n uint32) *math.Int {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, n)
+	return spTaggedInt(spTagShared, shared.Bytes(true), buf)
+}
+
+// spTaggedInt computes a tagged hash (BIP-340) as scalar.
+func spTaggedInt(tag string, data ...[]byte) *math.Int {
+	th := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(th[:])
+	h.Write(th[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+	return math.NewIntFromBytes(h.Sum(nil)).Mod(c.N)
+}
+
+// spNeg returns the negated point (with normalized y coordinate).
+func spNeg(p *bitcoin.Point) *bitcoin.Point {
+	return bitcoin.NewPoint(p.X(), c.P.Sub(p.Y()))
+}
+
+// spPoint decodes a compressed public key.
+func spPoint(buf []byte) (*bitcoin.Point, error) {
+	if len(buf) != 33 || (buf[0] != 2 && buf[0] != 3) {
+		return nil, ErrSPKey
+	}
+	p, _, err := bitcoin.NewPointFromBytes(buf)
+	if err != nil || !p.IsOnCurve() {
+		return nil, ErrSPKey
+	}
+	return p, nil
+}
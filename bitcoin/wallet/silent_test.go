@@ -0,0 +1,465 @@
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package wallet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/math"
+)
+
+func newTestSPKeys(t *testing.T) *SPKeys {
+	seed := make([]byte, 32)
+	_, _ = rand.Read(seed)
+	hd, err := NewHD(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewSPKeys(hd, 0, NetwMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestSPAddress(t *testing.T) {
+	keys := newTestSPKeys(t)
+	for _, addr := range []*SPAddress{keys.Address(), keys.LabeledAddress(7)} {
+		s := addr.String()
+		if !strings.HasPrefix(s, "sp1q") {
+			t.Fatalf("wrong address prefix: %s", s)
+		}
+		a2, err := ParseSPAddress(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !a2.Scan.Equals(addr.Scan) || !a2.Spend.Equals(addr.Spend) || a2.Network != NetwMain {
+			t.Fatal("address mismatch")
+		}
+		// checksum must be Bech32m
+		hrp, data, _, _ := Bech32Decode(s)
+		if _, err = ParseSPAddress(Bech32Encode(hrp, data, false)); err != ErrSPAddress {
+			t.Fatal("Bech32 checksum accepted")
+		}
+	}
+	if keys.Address().Spend.Equals(keys.LabeledAddress(1).Spend) {
+		t.Fatal("label not applied")
+	}
+}
+
+func TestSPPayment(t *testing.T) {
+	keys := newTestSPKeys(t)
+	addr := keys.Address()
+	lbl := keys.LabeledAddress(3)
+
+	// sender inputs (one taproot input)
+	inputs := make([]*SPInput, 3)
+	pubs := make([]*bitcoin.Point, 3)
+	outpoints := make([][]byte, 3)
+	for i := range inputs {
+		prv := bitcoin.GenerateKeys(true)
+		op := make([]byte, 36)
+		_, _ = rand.Read(op)
+		inputs[i] = &SPInput{Outpoint: op, Key: prv.D, Taproot: i == 1}
+		outpoints[i] = op
+		pubs[i] = prv.Q
+		if i == 1 && prv.Q.Y().Bit(0) == 1 {
+			pubs[i] = spNeg(prv.Q)
+		}
+	}
+	outs, err := SPOutputs(inputs, []*SPAddress{addr, lbl, addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(outs[0], outs[2]) {
+		t.Fatal("outputs for same recipient not distinct")
+	}
+	if s := SPScript(outs[0]); len(s) != 34 || s[0] != 0x51 {
+		t.Fatal("wrong output script")
+	}
+	// add unrelated output
+	decoy := bitcoin.GenerateKeys(true).Q.Bytes(true)[1:]
+	scanned := append([][]byte{decoy}, outs...)
+
+	// recipient scans transaction
+	found, err := keys.ScanTx(outpoints, pubs, scanned, []uint32{3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 {
+		t.Fatalf("expected 3 outputs, found %d", len(found))
+	}
+	labeled := 0
+	for _, out := range found {
+		if out.Label == 3 {
+			labeled++
+		}
+		d := keys.PrivateKey(out)
+		if !bytes.Equal(bitcoin.MultBase(d).Bytes(true)[1:], out.XOnly) {
+			t.Fatal("spending key mismatch")
+		}
+	}
+	if labeled != 1 {
+		t.Fatal("labeled output not detected")
+	}
+	// without label only unlabeled outputs are found
+	if found, _ = keys.ScanTx(outpoints, pubs, scanned, nil); len(found) != 1 {
+		t.Fatalf("expected 1 output, found %d", len(found))
+	}
+	// other recipient finds nothing
+	if found, _ = newTestSPKeys(t).ScanTx(outpoints, pubs, scanned, nil); len(found) != 0 {
+		t.Fatal("foreign outputs detected")
+	}
+}
+
+//----------------------------------------------------------------------
+// BIP-352 test vectors (send_and_receive_test_vectors.json)
+//----------------------------------------------------------------------
+
+// NUMS point used as taproot internal key for script-path only outputs
+const spNUMS = "50929b74c1a04954b78b4b6035e97a5e078a5a0f28ec96d547bfee9ace803ac0"
+
+type spTestVin struct {
+	Txid      string `json:"txid"`
+	Vout      uint32 `json:"vout"`
+	ScriptSig string `json:"scriptSig"`
+	Witness   string `json:"txinwitness"`
+	Prevout   struct {
+		ScriptPubKey struct {
+			Hex string `json:"hex"`
+		} `json:"scriptPubKey"`
+	} `json:"prevout"`
+	PrivateKey string `json:"private_key"`
+}
+
+type spTestData struct {
+	Comment string `json:"comment"`
+	Sending []struct {
+		Given struct {
+			Vin        []*spTestVin  `json:"vin"`
+			Recipients []interface{} `json:"recipients"`
+		} `json:"given"`
+		Expected struct {
+			Outputs []interface{} `json:"outputs"`
+		} `json:"expected"`
+	} `json:"sending"`
+	Receiving []struct {
+		Given struct {
+			Vin         []*spTestVin `json:"vin"`
+			Outputs     []string     `json:"outputs"`
+			KeyMaterial struct {
+				Spend string `json:"spend_priv_key"`
+				Scan  string `json:"scan_priv_key"`
+			} `json:"key_material"`
+			Labels []uint32 `json:"labels"`
+		} `json:"given"`
+		Expected struct {
+			Addresses []string `json:"addresses"`
+			Outputs   []struct {
+				PubKey string `json:"pub_key"`
+				Tweak  string `json:"priv_key_tweak"`
+			} `json:"outputs"`
+		} `json:"expected"`
+	} `json:"receiving"`
+}
+
+func TestSPVector(t *testing.T) {
+
+	if testing.Short() {
+		return
+	}
+
+	// the official test vectors from the BIP repository are expected as
+	// "silent_test.json" next to this file.
+	data, err := os.ReadFile("./silent_test.json")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			t.Skip("BIP-352 test vectors not available")
+		}
+		t.Fatal(err)
+	}
+	tests := make([]*spTestData, 0)
+	if err = json.Unmarshal(data, &tests); err != nil {
+		t.Fatal(err)
+	}
+
+	hex2bin := func(s string) []byte {
+		buf, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+	// get outpoint and (eligible) public key of an input
+	input := func(vin *spTestVin) (op []byte, pub *bitcoin.Point, taproot bool) {
+		op = make([]byte, 36)
+		txid := hex2bin(vin.Txid)
+		for i, b := range txid {
+			op[len(txid)-1-i] = b
+		}
+		binary.LittleEndian.PutUint32(op[32:], vin.Vout)
+		key, taproot := spInputKey(hex2bin(vin.Prevout.ScriptPubKey.Hex), hex2bin(vin.ScriptSig), spWitness(hex2bin(vin.Witness)))
+		if key != nil {
+			pub, _ = spPoint(key)
+		}
+		return
+	}
+	// hex-encoded keys from a list (entries can be a key or a tuple
+	// of key and amount)
+	keyList := func(list []interface{}) (res []string) {
+		for _, e := range list {
+			switch x := e.(type) {
+			case string:
+				res = append(res, x)
+			case []interface{}:
+				if len(x) > 0 {
+					if s, ok := x[0].(string); ok {
+						res = append(res, s)
+					}
+				}
+			}
+		}
+		sort.Strings(res)
+		return
+	}
+
+	for _, td := range tests {
+		for _, snd := range td.Sending {
+			var inputs []*SPInput
+			for _, vin := range snd.Given.Vin {
+				op, pub, taproot := input(vin)
+				in := &SPInput{Outpoint: op, Taproot: taproot}
+				if pub != nil {
+					in.Key = math.NewIntFromHex(vin.PrivateKey)
+				}
+				inputs = append(inputs, in)
+			}
+			var recipients []*SPAddress
+			for _, r := range keyList(snd.Given.Recipients) {
+				addr, err := ParseSPAddress(r)
+				if err != nil {
+					t.Fatalf("%s: %v", td.Comment, err)
+				}
+				recipients = append(recipients, addr)
+			}
+			outs, err := SPOutputs(inputs, recipients)
+			if err != nil && err != ErrSPInputs {
+				t.Fatalf("%s: %v", td.Comment, err)
+			}
+			var got []string
+			for _, out := range outs {
+				got = append(got, hex.EncodeToString(out))
+			}
+			sort.Strings(got)
+
+			// expected outputs are either a single list of keys or a list
+			// of alternative key sets (if outputs can be ordered differently)
+			var sets [][]string
+			for _, e := range snd.Expected.Outputs {
+				if x, ok := e.([]interface{}); ok && len(x) > 0 {
+					if _, ok = x[len(x)-1].(string); ok {
+						sets = append(sets, keyList(x))
+						continue
+					}
+				}
+				sets = nil
+				break
+			}
+			if sets == nil {
+				sets = [][]string{keyList(snd.Expected.Outputs)}
+			}
+			match := false
+			for _, set := range sets {
+				if strings.Join(set, ",") == strings.Join(got, ",") {
+					match = true
+					break
+				}
+			}
+			if !match {
+				t.Fatalf("%s: sending outputs mismatch: %v", td.Comment, got)
+			}
+		}
+
+		for _, rcv := range td.Receiving {
+			keys := &SPKeys{
+				Scan:    math.NewIntFromHex(rcv.Given.KeyMaterial.Scan),
+				Spend:   math.NewIntFromHex(rcv.Given.KeyMaterial.Spend),
+				Network: NetwMain,
+			}
+			// check addresses
+			addrs := []string{keys.Address().String()}
+			for _, m := range rcv.Given.Labels {
+				addrs = append(addrs, keys.LabeledAddress(m).String())
+			}
+			sort.Strings(addrs)
+			exp := append([]string{}, rcv.Expected.Addresses...)
+			sort.Strings(exp)
+			if strings.Join(addrs, ",") != strings.Join(exp, ",") {
+				t.Fatalf("%s: address mismatch: %v", td.Comment, addrs)
+			}
+			// scan transaction
+			var (
+				outpoints [][]byte
+				pubs      []*bitcoin.Point
+				outputs   [][]byte
+			)
+			for _, vin := range rcv.Given.Vin {
+				op, pub, _ := input(vin)
+				outpoints = append(outpoints, op)
+				if pub != nil {
+					pubs = append(pubs, pub)
+				}
+			}
+			for _, out := range rcv.Given.Outputs {
+				outputs = append(outputs, hex2bin(out))
+			}
+			found, err := keys.ScanTx(outpoints, pubs, outputs, rcv.Given.Labels)
+			if err != nil && err != ErrSPInputs {
+				t.Fatalf("%s: %v", td.Comment, err)
+			}
+			if len(found) != len(rcv.Expected.Outputs) {
+				t.Fatalf("%s: expected %d outputs, found %d", td.Comment, len(rcv.Expected.Outputs), len(found))
+			}
+			for _, e := range rcv.Expected.Outputs {
+				var out *SPOutput
+				for _, f := range found {
+					if hex.EncodeToString(f.XOnly) == e.PubKey {
+						out = f
+						break
+					}
+				}
+				if out == nil {
+					t.Fatalf("%s: output %s not found", td.Comment, e.PubKey)
+				}
+				if hex.EncodeToString(out.Tweak.FixedBytes(32)) != e.Tweak {
+					t.Fatalf("%s: tweak mismatch for %s", td.Comment, e.PubKey)
+				}
+				d := keys.PrivateKey(out)
+				if !bytes.Equal(bitcoin.MultBase(d).Bytes(true)[1:], out.XOnly) {
+					t.Fatalf("%s: spending key mismatch for %s", td.Comment, e.PubKey)
+				}
+			}
+		}
+	}
+}
+
+// spInputKey returns the compressed public key of an input that is eligible
+// for silent payments (nil otherwise) and flags taproot inputs.
+func spInputKey(spk, scriptSig []byte, witness [][]byte) ([]byte, bool) {
+	compressed := func(key []byte) []byte {
+		if len(key) == 33 && (key[0] == 2 || key[0] == 3) {
+			return key
+		}
+		return nil
+	}
+	last := func() []byte {
+		if len(witness) == 0 {
+			return nil
+		}
+		return witness[len(witness)-1]
+	}
+	isP2WPKH := func(s []byte) bool {
+		return len(s) == 22 && s[0] == 0x00 && s[1] == 0x14
+	}
+	switch {
+	// P2TR (key path or script path with a spendable internal key)
+	case len(spk) == 34 && spk[0] == 0x51 && spk[1] == 0x20:
+		w := witness
+		if len(w) > 1 && len(w[len(w)-1]) > 0 && w[len(w)-1][0] == 0x50 {
+			w = w[:len(w)-1]
+		}
+		if len(w) > 1 {
+			cb := w[len(w)-1]
+			if len(cb) >= 33 && hex.EncodeToString(cb[1:33]) == spNUMS {
+				return nil, false
+			}
+		}
+		return append([]byte{2}, spk[2:]...), true
+
+	// P2WPKH
+	case isP2WPKH(spk):
+		return compressed(last()), false
+
+	// P2SH-P2WPKH
+	case len(spk) == 23 && spk[0] == 0xa9 && spk[1] == 0x14 && spk[22] == 0x87:
+		if len(scriptSig) > 0 && isP2WPKH(scriptSig[1:]) {
+			return compressed(last()), false
+		}
+
+	// P2PKH (key is found at the end of a script push)
+	case len(spk) == 25 && spk[0] == 0x76 && spk[1] == 0xa9 && spk[2] == 0x14 && spk[23] == 0x88 && spk[24] == 0xac:
+		for i := len(scriptSig); i >= 33; i-- {
+			key := scriptSig[i-33 : i]
+			if bytes.Equal(bitcoin.Hash160(key), spk[3:23]) {
+				return compressed(key), false
+			}
+		}
+	}
+	return nil, false
+}
+
+// spWitness decodes a serialized witness stack.
+func spWitness(buf []byte) (items [][]byte) {
+	rdr := bytes.NewReader(buf)
+	varInt := func() int {
+		b, err := rdr.ReadByte()
+		if err != nil {
+			return -1
+		}
+		size := 0
+		switch b {
+		case 0xfd:
+			size = 2
+		case 0xfe:
+			size = 4
+		case 0xff:
+			size = 8
+		default:
+			return int(b)
+		}
+		v := make([]byte, 8)
+		if _, err = rdr.Read(v[:size]); err != nil {
+			return -1
+		}
+		return int(binary.LittleEndian.Uint64(v))
+	}
+	n := varInt()
+	for i := 0; i < n; i++ {
+		size := varInt()
+		if size < 0 || size > rdr.Len() {
+			return nil
+		}
+		item := make([]byte, size)
+		_, _ = rdr.Read(item)
+		items = append(items, item)
+	}
+	return
+}
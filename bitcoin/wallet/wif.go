@@ -0,0 +1,119 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/bitcoin/codec"
+)
+
+//----------------------------------------------------------------------
+// Wallet Import Format (WIF) for private keys:
+// Base58Check(version || key [|| 0x01 if compressed])
+// The version byte depends on coin and network (see AddrList).
+//----------------------------------------------------------------------
+
+// Error codes
+var (
+	ErrWIFLength      = errors.New("invalid WIF length")
+	ErrWIFCompression = errors.New("invalid WIF compression flag")
+	ErrWIFKey         = errors.New("WIF key out of range")
+	ErrWIFVersion     = errors.New("unknown WIF version")
+)
+
+// ParseWIF decodes a private key in WIF and returns the key, the
+// version (network) byte and the compression flag. The checksum is
+// verified (codec.ErrBase58Checksum on mismatch).
+func ParseWIF(s string) (key *bitcoin.PrivateKey, version byte, compr bool, err error) {
+	var data []byte
+	if data, err = codec.Base58CheckDecode(s); err != nil {
+		return
+	}
+	switch len(data) {
+	case 33:
+	case 34:
+		if data[33] != 1 {
+			err = ErrWIFCompression
+			return
+		}
+		compr = true
+	default:
+		err = ErrWIFLength
+		return
+	}
+	version = data[0]
+	if key, err = bitcoin.PrivateKeyFromBytes(data[1:]); err != nil {
+		return
+	}
+	if key.D.Sign() <= 0 || key.D.Cmp(bitcoin.GetCurve().N) >= 0 {
+		err = ErrWIFKey
+		key = nil
+	}
+	return
+}
+
+// ToWIF encodes a private key in WIF with given version byte. The
+// compression flag is taken from the key.
+func ToWIF(key *bitcoin.PrivateKey, version byte) string {
+	return codec.Base58CheckEncode(append([]byte{version}, key.Bytes()...))
+}
+
+// GetWIFVersion returns the WIF version byte for a coin and network
+// (NetwMain, NetwTest or NetwReg).
+func GetWIFVersion(coin, network int) (byte, error) {
+	for _, addr := range AddrList {
+		if addr.CoinID == coin {
+			if network >= 0 && network < len(addr.Formats) && addr.Formats[network] != nil {
+				return addr.Formats[network].WifVersion, nil
+			}
+			break
+		}
+	}
+	return 0, ErrWIFVersion
+}
+
+// GetWIFNetwork returns the network for a WIF version byte of a coin.
+// Testnet and regtest usually share a version byte; the first matching
+// network is returned (-1 if unknown).
+func GetWIFNetwork(coin int, version byte) int {
+	for _, addr := range AddrList {
+		if addr.CoinID == coin {
+			for netw, f := range addr.Formats {
+				if f != nil && f.WifVersion == version {
+					return netw
+				}
+			}
+			break
+		}
+	}
+	return -1
+}
+
+// ToWIFCoin encodes a private key in WIF for a coin and network.
+func ToWIFCoin(key *bitcoin.PrivateKey, coin, network int) (string, error) {
+	v, err := GetWIFVersion(coin, network)
+	if err != nil {
+		return "", err
+	}
+	return ToWIF(key, v), nil
+}
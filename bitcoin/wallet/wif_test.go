@@ -0,0 +1,95 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/bitcoin/codec"
+)
+
+func TestWIF(t *testing.T) {
+	kd, _ := hex.DecodeString("0c28fca386c7a227600b2fe50b7cae11ec86d3bf1fbe471be89827e19d72aa1d")
+	for _, tc := range []struct {
+		wif   string
+		compr bool
+	}{
+		{"5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTJ", false},
+		{"KwdMAjGmerYanjeui5SHS7JkmpZvVipYvB2LJGU1ZxJwYvP98617", true},
+	} {
+		key, v, compr, err := ParseWIF(tc.wif)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != 0x80 || compr != tc.compr || key.IsCompressed != tc.compr {
+			t.Fatalf("%s: version %x, compressed %v", tc.wif, v, compr)
+		}
+		if hex.EncodeToString(key.Bytes()[:32]) != hex.EncodeToString(kd) {
+			t.Fatal("key mismatch")
+		}
+		if ToWIF(key, v) != tc.wif {
+			t.Fatal("round-trip failed")
+		}
+		if GetWIFNetwork(0, v) != NetwMain {
+			t.Fatal("network lookup failed")
+		}
+	}
+	// testnet/regtest
+	key := bitcoin.GenerateKeys(true)
+	s, err := ToWIFCoin(key, 0, NetwReg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s[0] != 'c' {
+		t.Fatalf("regtest WIF: %s", s)
+	}
+	k2, v, _, err := ParseWIF(s)
+	if err != nil || v != 0xef || k2.D.Cmp(key.D) != 0 {
+		t.Fatal("regtest round-trip failed")
+	}
+	if GetWIFNetwork(0, v) != NetwTest {
+		t.Fatal("testnet lookup failed")
+	}
+	// per-coin versions
+	if v, err = GetWIFVersion(2, NetwMain); err != nil || v != 0xb0 {
+		t.Fatal("LTC version")
+	}
+	if _, err = GetWIFVersion(-5, NetwMain); err != ErrWIFVersion {
+		t.Fatal("unknown coin accepted")
+	}
+
+	// invalid encodings
+	if _, _, _, err = ParseWIF("KwdMAjGmerYanjeui5SHS7JkmpZvVipYvB2LJGU1ZxJwYvP98618"); err != codec.ErrBase58Checksum {
+		t.Fatalf("checksum: %v", err)
+	}
+	bad := append([]byte{0x80}, kd...)
+	if _, _, _, err = ParseWIF(codec.Base58CheckEncode(append(bad, 2))); err != ErrWIFCompression {
+		t.Fatalf("compression flag: %v", err)
+	}
+	if _, _, _, err = ParseWIF(codec.Base58CheckEncode(bad[:20])); err != ErrWIFLength {
+		t.Fatalf("length: %v", err)
+	}
+	if _, _, _, err = ParseWIF(codec.Base58CheckEncode(make([]byte, 33))); err != ErrWIFKey {
+		t.Fatalf("zero key: %v", err)
+	}
+}
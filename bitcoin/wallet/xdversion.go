@@ -0,0 +1,165 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	gerr "github.com/bfix/gospel/errors"
+	"github.com/bfix/gospel/math"
+)
+
+//----------------------------------------------------------------------
+// Version mapping for extended keys: every known BIP32 version (xpub,
+// ypub, zpub, Ypub, Zpub, tpub, ... and the coin-specific variants) is
+// paired with its private/public counterpart. The table is derived
+// from AddrList; the first entry wins for ambiguous versions.
+//----------------------------------------------------------------------
+
+// Generic (BIP32) extended key versions
+const (
+	XpubVersion uint32 = 0x0488b21e
+	XprvVersion uint32 = 0x0488ade4
+)
+
+// xdInfo describes the use of a version in AddrList
+type xdInfo struct {
+	pub     bool   // public version?
+	other   uint32 // counterpart (public <-> private)
+	coin    int    // coin identifier
+	network int    // network index
+}
+
+// xdVersions maps versions to their uses
+var xdVersions = buildXDVersions()
+
+// buildXDVersions creates the version mapping table.
+func buildXDVersions() map[uint32][]*xdInfo {
+	tbl := make(map[uint32][]*xdInfo)
+	for _, as := range AddrList {
+		for netw, af := range as.Formats {
+			if af == nil {
+				continue
+			}
+			for _, av := range af.Versions {
+				if av == nil {
+					continue
+				}
+				tbl[av.PubVersion] = append(tbl[av.PubVersion], &xdInfo{true, av.PrvVersion, as.CoinID, netw})
+				tbl[av.PrvVersion] = append(tbl[av.PrvVersion], &xdInfo{false, av.PubVersion, as.CoinID, netw})
+			}
+		}
+	}
+	return tbl
+}
+
+// PublicVersion returns the public version matching a private version.
+func PublicVersion(prv uint32) (uint32, bool) {
+	for _, info := range xdVersions[prv] {
+		if !info.pub {
+			return info.other, true
+		}
+	}
+	return 0, false
+}
+
+// PrivateVersion returns the private version matching a public version.
+func PrivateVersion(pub uint32) (uint32, bool) {
+	for _, info := range xdVersions[pub] {
+		if info.pub {
+			return info.other, true
+		}
+	}
+	return 0, false
+}
+
+// compatibleVersions returns true if both versions are used for the
+// same coin and network (so a conversion keeps the key semantics).
+func compatibleVersions(v1, v2 uint32) bool {
+	for _, a := range xdVersions[v1] {
+		for _, b := range xdVersions[v2] {
+			if a.pub == b.pub && a.coin == b.coin && a.network == b.network {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ConvertVersion returns a copy of the public key with a different
+// version (like zpub to xpub). The new version must be a public version
+// for the same coin and network.
+func (e *ExtendedPublicKey) ConvertVersion(version uint32) (*ExtendedPublicKey, error) {
+	if _, ok := PrivateVersion(version); !ok {
+		return nil, gerr.New(ErrHDVersion, "not a public version: %08x", version)
+	}
+	if !compatibleVersions(e.Data.Version, version) {
+		return nil, gerr.New(ErrHDVersion, "incompatible version: %08x", version)
+	}
+	r := e.Clone()
+	r.Data.Version = version
+	return r, nil
+}
+
+// ConvertVersion returns a copy of the private key with a different
+// version (like zprv to xprv). The new version must be a private
+// version for the same coin and network.
+func (k *ExtendedPrivateKey) ConvertVersion(version uint32) (*ExtendedPrivateKey, error) {
+	if _, ok := PublicVersion(version); !ok {
+		return nil, gerr.New(ErrHDVersion, "not a private version: %08x", version)
+	}
+	if !compatibleVersions(k.Data.Version, version) {
+		return nil, gerr.New(ErrHDVersion, "incompatible version: %08x", version)
+	}
+	r := new(ExtendedPrivateKey)
+	r.Key = math.NewIntFromBytes(k.Key.Bytes())
+	r.Data = NewExtendedData()
+	*r.Data = *k.Data
+	r.Data.Chaincode = append([]byte{}, k.Data.Chaincode...)
+	r.Data.Keydata = append([]byte{}, k.Data.Keydata...)
+	r.Data.Version = version
+	return r, nil
+}
+
+// ConvertVersion converts a serialized extended key (public or private)
+// to a different version of the same kind, coin and network.
+func ConvertVersion(s string, version uint32) (string, error) {
+	d, err := ParseExtended(s)
+	if err != nil {
+		return "", err
+	}
+	rc, _ := CheckVersion(d.Version)
+	switch rc {
+	case -1:
+		if _, ok := PrivateVersion(version); !ok {
+			return "", gerr.New(ErrHDVersion, "not a public version: %08x", version)
+		}
+	case 1:
+		if _, ok := PublicVersion(version); !ok {
+			return "", gerr.New(ErrHDVersion, "not a private version: %08x", version)
+		}
+	default:
+		return "", gerr.New(ErrHDVersion, "unknown version: %08x", d.Version)
+	}
+	if !compatibleVersions(d.Version, version) {
+		return "", gerr.New(ErrHDVersion, "incompatible version: %08x", version)
+	}
+	d.Version = version
+	return d.String(), nil
+}
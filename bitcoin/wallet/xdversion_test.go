@@ -0,0 +1,110 @@
+package wallet
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestXDVersionPublic(t *testing.T) {
+	seed := make([]byte, 32)
+	for _, tc := range []struct {
+		params *NetworkParams
+		mode   int
+		prv    string
+		pub    string
+	}{
+		{MainNetParams, AddrP2PKH, "xprv", "xpub"},
+		{MainNetParams, AddrP2WPKHinP2SH, "yprv", "ypub"},
+		{MainNetParams, AddrP2WPKH, "zprv", "zpub"},
+		{MainNetParams, AddrP2WSHinP2SH, "Yprv", "Ypub"},
+		{MainNetParams, AddrP2WSH, "Zprv", "Zpub"},
+		{TestNetParams, AddrP2WPKH, "vprv", "vpub"},
+		{TestNetParams, AddrP2WSH, "Vprv", "Vpub"},
+	} {
+		hd, err := NewHDNet(seed, tc.params, tc.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prv, err := hd.Private("m/48'/0'/0'/2'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := prv.String(); !strings.HasPrefix(s, tc.prv) {
+			t.Fatalf("private: %s", s)
+		}
+		if s := prv.Public().String(); !strings.HasPrefix(s, tc.pub) {
+			t.Fatalf("public of %s: %s", tc.prv, s)
+		}
+	}
+}
+
+func TestXDVersionConvert(t *testing.T) {
+	seed := make([]byte, 32)
+	hd, err := NewHDNet(seed, MainNetParams, AddrP2WPKH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prv, err := hd.Private("m/84'/0'/0'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := prv.Public()
+
+	// zpub -> xpub (same key)
+	xpub, err := pub.ConvertVersion(XpubVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(xpub.String(), "xpub") || !xpub.Key.Equals(pub.Key) {
+		t.Fatalf("converted: %s", xpub)
+	}
+	if pub.Data.Version == XpubVersion {
+		t.Fatal("original key modified")
+	}
+	xprv, err := prv.ConvertVersion(XprvVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xprv.Public().String() != xpub.String() {
+		t.Fatal("private conversion mismatch")
+	}
+	// string conversion
+	s, err := ConvertVersion(pub.String(), XpubVersion)
+	if err != nil || s != xpub.String() {
+		t.Fatalf("string conversion: %s (%v)", s, err)
+	}
+	// safety checks
+	tpub := GetXDVersion(0, AddrP2PKH, NetwTest, true)
+	for _, v := range []uint32{XprvVersion, tpub, 0x12345678} {
+		if _, err = pub.ConvertVersion(v); !errors.Is(err, ErrHDVersion) {
+			t.Fatalf("%08x: accepted", v)
+		}
+		if _, err = ConvertVersion(pub.String(), v); !errors.Is(err, ErrHDVersion) {
+			t.Fatalf("%08x: string accepted", v)
+		}
+	}
+	if _, err = prv.ConvertVersion(XpubVersion); !errors.Is(err, ErrHDVersion) {
+		t.Fatal("private to public version accepted")
+	}
+}
@@ -0,0 +1,140 @@
+package concurrent
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"sync"
+)
+
+//----------------------------------------------------------------------
+// Futures: typed placeholders for results that become available later.
+// A future is resolved exactly once (with a value or an error); any
+// number of goroutines can wait for the result.
+//----------------------------------------------------------------------
+
+// Future holds the (eventual) result of an asynchronous computation.
+type Future[T any] struct {
+	once sync.Once
+	done chan struct{}
+	val  T
+	err  error
+}
+
+// NewFuture returns an unresolved future (to be resolved by the producer).
+func NewFuture[T any]() *Future[T] {
+	return &Future[T]{
+		done: make(chan struct{}),
+	}
+}
+
+// Async runs 'fn' in a separate goroutine and returns a future for
+// its result.
+func Async[T any](ctx context.Context, fn func(ctx context.Context) (T, error)) *Future[T] {
+	f := NewFuture[T]()
+	go func() {
+		f.Resolve(fn(ctx))
+	}()
+	return f
+}
+
+// Resolve sets the result of the future and wakes up all waiting
+// goroutines. Returns false if the future was already resolved.
+func (f *Future[T]) Resolve(val T, err error) (ok bool) {
+	f.once.Do(func() {
+		f.val, f.err = val, err
+		close(f.done)
+		ok = true
+	})
+	return
+}
+
+// Done returns a channel that is closed when the future is resolved.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get waits for the result of the future. If the context is done before
+// the future is resolved, the context error is returned.
+func (f *Future[T]) Get(ctx context.Context) (val T, err error) {
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+//----------------------------------------------------------------------
+// In-flight request deduplication: concurrent calls with the same key
+// share a single execution of the (expensive) request and its result.
+//----------------------------------------------------------------------
+
+// Inflight deduplicates concurrent requests identified by a key.
+type Inflight[K comparable, T any] struct {
+	sync.Mutex
+	calls map[K]*Future[T]
+}
+
+// NewInflight returns a new request deduplicator.
+func NewInflight[K comparable, T any]() *Inflight[K, T] {
+	return &Inflight[K, T]{
+		calls: make(map[K]*Future[T]),
+	}
+}
+
+// Future returns the future for a request 'key': if no such request is
+// running, 'fn' is started (with the context of the first caller).
+// The bool result is true if an already running request is shared.
+func (g *Inflight[K, T]) Future(ctx context.Context, key K, fn func(ctx context.Context) (T, error)) (*Future[T], bool) {
+	g.Lock()
+	defer g.Unlock()
+	if f, ok := g.calls[key]; ok {
+		return f, true
+	}
+	f := NewFuture[T]()
+	g.calls[key] = f
+	go func() {
+		val, err := fn(ctx)
+		g.Lock()
+		delete(g.calls, key)
+		g.Unlock()
+		f.Resolve(val, err)
+	}()
+	return f, false
+}
+
+// Do executes a request 'key' (or joins a running one) and waits for
+// its result. The bool result is true if the result was shared.
+func (g *Inflight[K, T]) Do(ctx context.Context, key K, fn func(ctx context.Context) (T, error)) (val T, shared bool, err error) {
+	var f *Future[T]
+	f, shared = g.Future(ctx, key, fn)
+	val, err = f.Get(ctx)
+	return
+}
+
+// Pending returns the number of running requests.
+func (g *Inflight[K, T]) Pending() int {
+	g.Lock()
+	defer g.Unlock()
+	return len(g.calls)
+}
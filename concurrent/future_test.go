@@ -0,0 +1,96 @@
+package concurrent
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFuture(t *testing.T) {
+	f := NewFuture[int]()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout: %v", err)
+	}
+	if !f.Resolve(42, nil) || f.Resolve(0, errTransient) {
+		t.Fatal("resolve once failed")
+	}
+	if v, err := f.Get(context.Background()); err != nil || v != 42 {
+		t.Fatalf("wrong result: %d, %v", v, err)
+	}
+	// asynchronous computation
+	a := Async(context.Background(), func(context.Context) (string, error) {
+		return "", errTransient
+	})
+	<-a.Done()
+	if _, err := a.Get(context.Background()); err != errTransient {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
+
+func TestInflight(t *testing.T) {
+	g := NewInflight[string, int]()
+	var calls int32
+	shared := 0
+	release := make(chan struct{})
+	fn := func(context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 7, nil
+	}
+	// start identical requests while the first is running
+	futures := make([]*Future[int], 10)
+	for i := range futures {
+		var s bool
+		if futures[i], s = g.Future(context.Background(), "best", fn); s {
+			shared++
+		}
+	}
+	// concurrent waiters
+	wg := new(sync.WaitGroup)
+	for _, f := range futures {
+		wg.Add(1)
+		go func(f *Future[int]) {
+			defer wg.Done()
+			if v, err := f.Get(context.Background()); err != nil || v != 7 {
+				t.Errorf("wrong result: %d, %v", v, err)
+			}
+		}(f)
+	}
+	close(release)
+	wg.Wait()
+	if atomic.LoadInt32(&calls) != 1 || shared != 9 {
+		t.Fatalf("calls=%d, shared=%d", calls, shared)
+	}
+	for g.Pending() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// finished requests are not cached
+	if _, s, _ := g.Do(context.Background(), "best", fn); s || calls != 2 {
+		t.Fatalf("request not repeated: %v, %d", s, calls)
+	}
+}
@@ -0,0 +1,136 @@
+package concurrent
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	gtime "github.com/bfix/gospel/time"
+)
+
+//----------------------------------------------------------------------
+// Retry with exponential backoff: a function is called until it
+// succeeds, the maximum number of attempts is reached, the error is
+// classified as permanent or the context is done. The delay between
+// attempts grows by a factor (up to a limit) and is randomized by a
+// relative jitter to avoid synchronized retries.
+//----------------------------------------------------------------------
+
+// RetryPolicy defines the behavior of Retry.
+type RetryPolicy struct {
+	MaxAttempts int           // max. number of attempts (0: unlimited)
+	Initial     time.Duration // delay after first failed attempt
+	Max         time.Duration // max. delay between attempts (0: unlimited)
+	Factor      float64       // backoff factor (<= 1: constant delay)
+	Jitter      float64       // relative jitter of delay (0..1)
+
+	// Retryable classifies errors: returns false for permanent errors
+	// that end the retry loop (nil: all errors are retryable).
+	Retryable func(err error) bool
+
+	// OnRetry is called before waiting for the next attempt (optional).
+	OnRetry func(attempt int, err error, delay time.Duration)
+
+	// Clock used for waiting (nil: real time)
+	Clock gtime.Clock
+}
+
+// DefaultRetryPolicy is used if no policy is specified: five attempts,
+// starting with a one second delay (doubled per attempt) and 20% jitter.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts: 5,
+	Initial:     time.Second,
+	Max:         30 * time.Second,
+	Factor:      2,
+	Jitter:      0.2,
+}
+
+// permanentError wraps an error that must not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error as permanent: Retry returns it (unwrapped)
+// without further attempts.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// Delay returns the (unjittered) delay after the n-th failed attempt.
+func (p *RetryPolicy) Delay(n int) time.Duration {
+	d := float64(p.Initial)
+	for i := 1; i < n && p.Factor > 1; i++ {
+		d *= p.Factor
+		if p.Max > 0 && d >= float64(p.Max) {
+			break
+		}
+	}
+	if p.Max > 0 && d > float64(p.Max) {
+		return p.Max
+	}
+	return time.Duration(d)
+}
+
+// Retry calls 'fn' until it succeeds or the policy ends the retry loop.
+// The error of the last attempt is returned; if the context is done
+// while waiting, the context error is returned.
+func Retry(ctx context.Context, policy *RetryPolicy, fn func(ctx context.Context) error) error {
+	if policy == nil {
+		policy = DefaultRetryPolicy
+	}
+	clk := policy.Clock
+	if clk == nil {
+		clk = gtime.Real
+	}
+	for n := 1; ; n++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if policy.Retryable != nil && !policy.Retryable(err) {
+			return err
+		}
+		if policy.MaxAttempts > 0 && n >= policy.MaxAttempts {
+			return err
+		}
+		delay := gtime.Jitter(policy.Delay(n), policy.Jitter)
+		if policy.OnRetry != nil {
+			policy.OnRetry(n, err, delay)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clk.After(delay):
+		}
+	}
+}
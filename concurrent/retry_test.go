@@ -0,0 +1,112 @@
+package concurrent
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gtime "github.com/bfix/gospel/time"
+)
+
+var errTransient = errors.New("transient")
+
+func TestRetryDelay(t *testing.T) {
+	p := &RetryPolicy{Initial: time.Second, Max: 10 * time.Second, Factor: 2}
+	for n, exp := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		if d := p.Delay(n + 1); d != exp {
+			t.Fatalf("delay #%d: %v", n+1, d)
+		}
+	}
+	p.Factor = 0
+	if d := p.Delay(5); d != time.Second {
+		t.Fatalf("constant delay: %v", d)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var delays []time.Duration
+	p := &RetryPolicy{
+		MaxAttempts: 4,
+		Initial:     time.Millisecond,
+		Factor:      2,
+		OnRetry: func(n int, err error, d time.Duration) {
+			delays = append(delays, d)
+		},
+	}
+	// success after three attempts
+	n := 0
+	err := Retry(context.Background(), p, func(context.Context) error {
+		if n++; n < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil || n != 3 || len(delays) != 2 || delays[1] != 2*time.Millisecond {
+		t.Fatalf("success: %v %d %v", err, n, delays)
+	}
+	// max. attempts
+	n = 0
+	if err = Retry(context.Background(), p, func(context.Context) error {
+		n++
+		return errTransient
+	}); err != errTransient || n != 4 {
+		t.Fatalf("max attempts: %v %d", err, n)
+	}
+	// permanent errors
+	errFatal := errors.New("fatal")
+	n = 0
+	if err = Retry(context.Background(), p, func(context.Context) error {
+		n++
+		return Permanent(errFatal)
+	}); err != errFatal || n != 1 {
+		t.Fatalf("permanent: %v %d", err, n)
+	}
+	p.Retryable = func(err error) bool { return err != errFatal }
+	n = 0
+	if err = Retry(context.Background(), p, func(context.Context) error {
+		n++
+		return errFatal
+	}); err != errFatal || n != 1 {
+		t.Fatalf("classified: %v %d", err, n)
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	clk := gtime.NewFakeClock(time.Now())
+	p := &RetryPolicy{Initial: time.Hour, Clock: clk}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Retry(ctx, p, func(context.Context) error { return errTransient })
+	}()
+	// wait for the retry loop to block on the clock, then advance it
+	// for a second attempt and cancel while waiting again.
+	clk.BlockUntil(1)
+	clk.Advance(time.Hour)
+	clk.BlockUntil(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("cancel: %v", err)
+	}
+}
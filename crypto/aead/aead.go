@@ -0,0 +1,99 @@
+package aead
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Error codes
+var (
+	ErrCiphertextSize = errors.New("ciphertext too short")
+)
+
+// KeySize of keys for both AEAD schemes
+const KeySize = 32
+
+//----------------------------------------------------------------------
+// AEAD wrapper with built-in nonce management: the nonce is randomly
+// generated for each sealing operation and prepended to the ciphertext.
+//----------------------------------------------------------------------
+
+// AEAD for authenticated encryption with additional data
+type AEAD struct {
+	aead cipher.AEAD // underlying AEAD scheme
+}
+
+// NewXChaCha20Poly1305 returns an AEAD using XChaCha20-Poly1305 with a
+// 32 byte key.
+func NewXChaCha20Poly1305(key []byte) (*AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	a, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return &AEAD{aead: a}, nil
+}
+
+// NewGCMSIV returns an AEAD using AES-256-GCM-SIV with a 32 byte key.
+func NewGCMSIV(key []byte) (*AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	a, err := NewAESGCMSIV(key)
+	if err != nil {
+		return nil, err
+	}
+	return &AEAD{aead: a}, nil
+}
+
+// Overhead returns the difference between the size of a sealed message
+// and the plaintext (nonce and authentication tag).
+func (a *AEAD) Overhead() int {
+	return a.aead.NonceSize() + a.aead.Overhead()
+}
+
+// Seal encrypts and authenticates a plaintext and additional data.
+// The returned ciphertext includes the random nonce.
+func (a *AEAD) Seal(plaintext, ad []byte) ([]byte, error) {
+	ns := a.aead.NonceSize()
+	out := make([]byte, ns, len(plaintext)+a.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, err
+	}
+	return a.aead.Seal(out, out[:ns], plaintext, ad), nil
+}
+
+// Open decrypts and authenticates a ciphertext (created by Seal) and
+// additional data.
+func (a *AEAD) Open(ciphertext, ad []byte) ([]byte, error) {
+	ns := a.aead.NonceSize()
+	if len(ciphertext) < a.Overhead() {
+		return nil, ErrCiphertextSize
+	}
+	return a.aead.Open(nil, ciphertext[:ns], ciphertext[ns:], ad)
+}
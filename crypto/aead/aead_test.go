@@ -0,0 +1,192 @@
+package aead
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// test vectors from RFC 8452, appendix A and C
+func TestPolyval(t *testing.T) {
+	var h [16]byte
+	hk, _ := hex.DecodeString("25629347589242761d31f826ba4b757b")
+	copy(h[:], hk)
+	x, _ := hex.DecodeString("4f4f95668c83dfb6401762bb2d01a262d1a24ddd2721d006bbe45f20d3c9f362")
+	p := newPolyval(h)
+	p.update(x)
+	s := p.sum()
+	if hex.EncodeToString(s[:]) != "f7a3b47b846119fae5b7866cf5e5b77e" {
+		t.Fatal("POLYVAL mismatch")
+	}
+}
+
+func TestGCMSIVVectors(t *testing.T) {
+	for _, v := range []struct {
+		key, nonce, plain, result string
+	}{
+		{
+			"01000000000000000000000000000000",
+			"030000000000000000000000",
+			"",
+			"dc20e2d83f25705bb49e439eca56de25",
+		},
+		{
+			"01000000000000000000000000000000",
+			"030000000000000000000000",
+			"0100000000000000",
+			"b5d839330ac7b786578782fff6013b815b287c22493a364c",
+		},
+		{
+			"0100000000000000000000000000000000000000000000000000000000000000",
+			"030000000000000000000000",
+			"",
+			"07f5f4169bbf55a8400cd47ea6fd400f",
+		},
+	} {
+		key, _ := hex.DecodeString(v.key)
+		nonce, _ := hex.DecodeString(v.nonce)
+		plain, _ := hex.DecodeString(v.plain)
+		a, err := NewAESGCMSIV(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct := a.Seal(nil, nonce, plain, nil)
+		if hex.EncodeToString(ct) != v.result {
+			t.Fatalf("ciphertext mismatch: %x", ct)
+		}
+		out, err := a.Open(nil, nonce, ct, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, plain) {
+			t.Fatal("plaintext mismatch")
+		}
+	}
+}
+
+func TestAEAD(t *testing.T) {
+	key := make([]byte, KeySize)
+	key[0] = 1
+	msg := []byte("authenticated encryption")
+	ad := []byte("header")
+
+	for _, f := range []func([]byte) (*AEAD, error){NewXChaCha20Poly1305, NewGCMSIV} {
+		a, err := f(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, err := a.Seal(msg, ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ct) != len(msg)+a.Overhead() {
+			t.Fatal("wrong ciphertext size")
+		}
+		ct2, _ := a.Seal(msg, ad)
+		if bytes.Equal(ct, ct2) {
+			t.Fatal("nonce reused")
+		}
+		out, err := a.Open(ct, ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, msg) {
+			t.Fatal("plaintext mismatch")
+		}
+		if _, err = a.Open(ct, nil); err == nil {
+			t.Fatal("wrong additional data accepted")
+		}
+		ct[len(ct)-1] ^= 1
+		if _, err = a.Open(ct, ad); err == nil {
+			t.Fatal("modified ciphertext accepted")
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	key := make([]byte, KeySize)
+	a, _ := NewXChaCha20Poly1305(key)
+	ad := []byte("stream")
+
+	for _, size := range []int{0, 64, 100, 256} {
+		msg := make([]byte, size)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		buf := new(bytes.Buffer)
+		w, err := a.NewWriter(buf, ad, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(msg[:size/2]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(msg[size/2:]); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		enc := buf.Bytes()
+
+		// decrypt stream
+		r, err := a.NewReader(bytes.NewReader(enc), ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := new(bytes.Buffer)
+		if _, err = out.ReadFrom(r); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), msg) {
+			t.Fatalf("stream mismatch (size %d)", size)
+		}
+		// truncated stream (drop final chunk)
+		if size > 64 {
+			cut := len(enc) - (size - (size-1)/64*64 + a.aead.Overhead())
+			r, _ = a.NewReader(bytes.NewReader(enc[:cut]), ad)
+			if _, err = new(bytes.Buffer).ReadFrom(r); err == nil {
+				t.Fatalf("truncated stream accepted (size %d)", size)
+			}
+		}
+	}
+}
+
+func TestKeyWrap(t *testing.T) {
+	kek := make([]byte, KeySize)
+	key := []byte("0123456789abcdef0123456789abcdef")
+	w, err := WrapKey(kek, key, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := UnwrapKey(kek, w, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k, key) {
+		t.Fatal("key mismatch")
+	}
+	if _, err = UnwrapKey(kek, w, "other"); err == nil {
+		t.Fatal("wrong label accepted")
+	}
+}
@@ -0,0 +1,34 @@
+package aead
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+/*
+ * --------------------------------------------------------------------
+ * Misuse-resistant wrappers for authenticated encryption:
+ *     - XChaCha20-Poly1305 (192-bit random nonces)
+ *     - AES-GCM-SIV (RFC 8452; nonce misuse-resistant)
+ *
+ * Nonces are generated and managed by the wrappers (no caller-supplied
+ * nonces); large payloads are encrypted in authenticated chunks with
+ * protection against reordering and truncation (STREAM construction).
+ * Keys can be wrapped (encrypted) with a key-encryption key.
+ * --------------------------------------------------------------------
+ */
@@ -0,0 +1,265 @@
+package aead
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// Error codes
+var (
+	ErrKeySize   = errors.New("invalid key size")
+	ErrNonceSize = errors.New("invalid nonce size")
+	ErrOpen      = errors.New("message authentication failed")
+)
+
+//----------------------------------------------------------------------
+// AES-GCM-SIV (RFC 8452): nonce misuse-resistant AEAD. Reusing a nonce
+// only reveals if the same message (and additional data) was encrypted
+// twice; it does not compromise the key or other messages.
+//----------------------------------------------------------------------
+
+// gcmSIV implements the cipher.AEAD interface
+type gcmSIV struct {
+	blk    cipher.Block // key-generating cipher
+	keyLen int          // size of key-generating key
+}
+
+// NewAESGCMSIV returns an AES-GCM-SIV AEAD for a 16 or 32 byte key.
+func NewAESGCMSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, ErrKeySize
+	}
+	blk, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmSIV{blk: blk, keyLen: len(key)}, nil
+}
+
+// NonceSize returns the size of the nonce (96 bits)
+func (g *gcmSIV) NonceSize() int {
+	return 12
+}
+
+// Overhead returns the size of the authentication tag
+func (g *gcmSIV) Overhead() int {
+	return 16
+}
+
+// Seal encrypts and authenticates plaintext and appends the result to dst.
+func (g *gcmSIV) Seal(dst, nonce, plaintext, ad []byte) []byte {
+	if len(nonce) != 12 {
+		panic(ErrNonceSize)
+	}
+	authKey, enc := g.deriveKeys(nonce)
+	tag := g.tag(authKey, enc, nonce, plaintext, ad)
+
+	ret, out := sliceForAppend(dst, len(plaintext)+16)
+	ctr(enc, tag, out, plaintext)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+// Open decrypts and authenticates ciphertext and appends the result to dst.
+func (g *gcmSIV) Open(dst, nonce, ciphertext, ad []byte) ([]byte, error) {
+	if len(nonce) != 12 {
+		return nil, ErrNonceSize
+	}
+	if len(ciphertext) < 16 {
+		return nil, ErrOpen
+	}
+	n := len(ciphertext) - 16
+	var tag [16]byte
+	copy(tag[:], ciphertext[n:])
+
+	authKey, enc := g.deriveKeys(nonce)
+	ret, out := sliceForAppend(dst, n)
+	ctr(enc, tag, out, ciphertext[:n])
+
+	exp := g.tag(authKey, enc, nonce, out, ad)
+	if subtle.ConstantTimeCompare(exp[:], tag[:]) != 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, ErrOpen
+	}
+	return ret, nil
+}
+
+// deriveKeys computes the message authentication and encryption keys
+// for a nonce.
+func (g *gcmSIV) deriveKeys(nonce []byte) (authKey [16]byte, enc cipher.Block) {
+	var in, out [16]byte
+	copy(in[4:], nonce)
+	num := 4
+	if g.keyLen == 32 {
+		num = 6
+	}
+	key := make([]byte, 0, 8*num)
+	for i := 0; i < num; i++ {
+		binary.LittleEndian.PutUint32(in[:4], uint32(i))
+		g.blk.Encrypt(out[:], in[:])
+		key = append(key, out[:8]...)
+	}
+	copy(authKey[:], key[:16])
+	// key size is always valid
+	enc, _ = aes.NewCipher(key[16:])
+	return
+}
+
+// tag computes the authentication tag for plaintext and additional data.
+func (g *gcmSIV) tag(authKey [16]byte, enc cipher.Block, nonce, plaintext, ad []byte) (tag [16]byte) {
+	p := newPolyval(authKey)
+	p.update(ad)
+	p.update(plaintext)
+	var lens [16]byte
+	binary.LittleEndian.PutUint64(lens[:8], uint64(len(ad))*8)
+	binary.LittleEndian.PutUint64(lens[8:], uint64(len(plaintext))*8)
+	p.update(lens[:])
+	s := p.sum()
+	for i := range nonce {
+		s[i] ^= nonce[i]
+	}
+	s[15] &= 0x7f
+	enc.Encrypt(tag[:], s[:])
+	return
+}
+
+// ctr encrypts/decrypts 'in' to 'out' in counter mode starting with the
+// counter block derived from the tag.
+func ctr(enc cipher.Block, tag [16]byte, out, in []byte) {
+	blk := tag
+	blk[15] |= 0x80
+	var ks [16]byte
+	for pos := 0; pos < len(in); pos += 16 {
+		enc.Encrypt(ks[:], blk[:])
+		end := pos + 16
+		if end > len(in) {
+			end = len(in)
+		}
+		subtle.XORBytes(out[pos:end], in[pos:end], ks[:end-pos])
+		c := binary.LittleEndian.Uint32(blk[:4])
+		binary.LittleEndian.PutUint32(blk[:4], c+1)
+	}
+}
+
+// sliceForAppend extends 'in' by 'n' bytes and returns the extended
+// slice and the appended part.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
+
+//----------------------------------------------------------------------
+// POLYVAL (RFC 8452, section 3): arithmetic in GF(2^128) defined by
+// the polynomial 'x^128 + x^127 + x^126 + x^121 + 1' with little-endian
+// element representation. 'dot(a,b) = a*b*x^-128'.
+//----------------------------------------------------------------------
+
+// element of GF(2^128): bit 'i' is the coefficient of 'x^i'
+type gfElement struct {
+	lo, hi uint64
+}
+
+// 'x^-128 = x^127 + x^124 + x^121 + x^114 + 1'
+var gfInvX128 = gfElement{
+	lo: 1,
+	hi: 1<<63 | 1<<60 | 1<<57 | 1<<50,
+}
+
+func gfFromBytes(b []byte) gfElement {
+	return gfElement{
+		lo: binary.LittleEndian.Uint64(b[:8]),
+		hi: binary.LittleEndian.Uint64(b[8:16]),
+	}
+}
+
+func (a gfElement) bytes() (b [16]byte) {
+	binary.LittleEndian.PutUint64(b[:8], a.lo)
+	binary.LittleEndian.PutUint64(b[8:], a.hi)
+	return
+}
+
+// mul returns 'a*b mod p(x)'
+func (a gfElement) mul(b gfElement) (r gfElement) {
+	for i := 127; i >= 0; i-- {
+		// r = r*x mod p(x)
+		carry := r.hi >> 63
+		r.hi = r.hi<<1 | r.lo>>63
+		r.lo <<= 1
+		if carry != 0 {
+			r.lo ^= 1
+			r.hi ^= 1<<63 | 1<<62 | 1<<57
+		}
+		// add 'a' if bit is set
+		var bit uint64
+		if i >= 64 {
+			bit = (b.hi >> (i - 64)) & 1
+		} else {
+			bit = (b.lo >> i) & 1
+		}
+		mask := -bit
+		r.lo ^= a.lo & mask
+		r.hi ^= a.hi & mask
+	}
+	return
+}
+
+// polyval computes POLYVAL over a sequence of zero-padded inputs
+type polyval struct {
+	h gfElement // 'H*x^-128' (so a single multiplication computes dot())
+	s gfElement // accumulator
+}
+
+func newPolyval(key [16]byte) *polyval {
+	return &polyval{
+		h: gfFromBytes(key[:]).mul(gfInvX128),
+	}
+}
+
+// update processes an input (padded with zeros to a multiple of 16 bytes)
+func (p *polyval) update(in []byte) {
+	var blk [16]byte
+	for pos := 0; pos < len(in); pos += 16 {
+		blk = [16]byte{}
+		copy(blk[:], in[pos:])
+		x := gfFromBytes(blk[:])
+		p.s.lo ^= x.lo
+		p.s.hi ^= x.hi
+		p.s = p.s.mul(p.h)
+	}
+}
+
+// sum returns the current POLYVAL value
+func (p *polyval) sum() [16]byte {
+	return p.s.bytes()
+}
@@ -0,0 +1,47 @@
+package aead
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+//----------------------------------------------------------------------
+// Key wrapping: keys are encrypted with a key-encryption key (KEK)
+// using AES-GCM-SIV (so even a faulty random generator does not
+// compromise the KEK). The purpose of the wrapped key is bound to the
+// wrapped data as a label (additional data).
+//----------------------------------------------------------------------
+
+// WrapKey encrypts a key with a key-encryption key for a given purpose.
+func WrapKey(kek, key []byte, label string) ([]byte, error) {
+	a, err := NewGCMSIV(kek)
+	if err != nil {
+		return nil, err
+	}
+	return a.Seal(key, []byte(label))
+}
+
+// UnwrapKey decrypts a wrapped key with a key-encryption key. The label
+// must match the label used for wrapping.
+func UnwrapKey(kek, wrapped []byte, label string) ([]byte, error) {
+	a, err := NewGCMSIV(kek)
+	if err != nil {
+		return nil, err
+	}
+	return a.Open(wrapped, []byte(label))
+}
@@ -0,0 +1,227 @@
+package aead
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Error codes
+var (
+	ErrStreamChunkSize = errors.New("invalid chunk size")
+	ErrStreamTruncated = errors.New("stream truncated")
+	ErrStreamTooLong   = errors.New("too many chunks in stream")
+	ErrStreamClosed    = errors.New("stream closed")
+)
+
+// Chunk sizes for streams
+const (
+	DefaultChunkSize = 64 * 1024
+	MaxChunkSize     = 16 * 1024 * 1024
+)
+
+//----------------------------------------------------------------------
+// Chunked encryption of large payloads (STREAM construction):
+// The stream starts with a header (random nonce prefix and chunk size);
+// the nonce of each chunk is composed of the nonce prefix, the chunk
+// counter (32 bit) and a flag byte marking the final chunk. Chunks can
+// therefore neither be reordered nor dropped, and a truncated stream
+// is detected.
+//----------------------------------------------------------------------
+
+// streamNonce returns the nonce for a chunk
+func streamNonce(prefix []byte, ctr uint32, last bool) []byte {
+	nonce := make([]byte, len(prefix)+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], ctr)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// streamWriter encrypts chunks of data written to it
+type streamWriter struct {
+	a      *AEAD     // AEAD scheme
+	w      io.Writer // underlying writer
+	ad     []byte    // additional data
+	prefix []byte    // nonce prefix
+	buf    []byte    // pending plaintext
+	size   int       // chunk size
+	ctr    uint32    // chunk counter
+	closed bool      // stream closed?
+}
+
+// NewWriter returns a writer that encrypts data in chunks of given size
+// (or DefaultChunkSize if size is 0). The writer must be closed to
+// write the final chunk.
+func (a *AEAD) NewWriter(w io.Writer, ad []byte, size int) (io.WriteCloser, error) {
+	if size == 0 {
+		size = DefaultChunkSize
+	}
+	if size < 0 || size > MaxChunkSize {
+		return nil, ErrStreamChunkSize
+	}
+	// write stream header
+	ns := a.aead.NonceSize() - 5
+	hdr := make([]byte, ns+4)
+	if _, err := rand.Read(hdr[:ns]); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(hdr[ns:], uint32(size))
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &streamWriter{
+		a:      a,
+		w:      w,
+		ad:     ad,
+		prefix: hdr[:ns],
+		buf:    make([]byte, 0, size),
+		size:   size,
+	}, nil
+}
+
+// Write plaintext to the stream. Full chunks are only written when more
+// data follows (the final chunk is written on Close).
+func (s *streamWriter) Write(p []byte) (n int, err error) {
+	if s.closed {
+		return 0, ErrStreamClosed
+	}
+	for len(p) > 0 {
+		if len(s.buf) == s.size {
+			if err = s.flush(false); err != nil {
+				return
+			}
+		}
+		k := copy(s.buf[len(s.buf):s.size], p)
+		s.buf = s.buf[:len(s.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return
+}
+
+// Close writes the final chunk.
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+// flush pending plaintext as a chunk
+func (s *streamWriter) flush(last bool) error {
+	if s.ctr == 1<<32-1 {
+		return ErrStreamTooLong
+	}
+	nonce := streamNonce(s.prefix, s.ctr, last)
+	ct := s.a.aead.Seal(nil, nonce, s.buf, s.ad)
+	s.ctr++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(ct)
+	return err
+}
+
+// streamReader decrypts chunks of data read from a stream
+type streamReader struct {
+	a      *AEAD         // AEAD scheme
+	r      *bufio.Reader // underlying reader
+	ad     []byte        // additional data
+	prefix []byte        // nonce prefix
+	chunk  []byte        // encrypted chunk
+	buf    []byte        // pending plaintext
+	ctr    uint32        // chunk counter
+	done   bool          // final chunk processed?
+}
+
+// NewReader returns a reader that decrypts a stream created by a
+// writer returned from NewWriter.
+func (a *AEAD) NewReader(r io.Reader, ad []byte) (io.Reader, error) {
+	ns := a.aead.NonceSize() - 5
+	hdr := make([]byte, ns+4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrStreamTruncated
+		}
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint32(hdr[ns:]))
+	if size == 0 || size > MaxChunkSize {
+		return nil, ErrStreamChunkSize
+	}
+	return &streamReader{
+		a:      a,
+		r:      bufio.NewReader(r),
+		ad:     ad,
+		prefix: hdr[:ns],
+		chunk:  make([]byte, size+a.aead.Overhead()),
+	}, nil
+}
+
+// Read decrypted data from the stream.
+func (s *streamReader) Read(p []byte) (n int, err error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err = s.next(); err != nil {
+			return
+		}
+	}
+	n = copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return
+}
+
+// next reads and decrypts the next chunk.
+func (s *streamReader) next() error {
+	n, err := io.ReadFull(s.r, s.chunk)
+	last := false
+	switch err {
+	case nil:
+		// a full chunk is final if no more data follows
+		if _, err = s.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+	if n < s.a.aead.Overhead() {
+		return ErrStreamTruncated
+	}
+	nonce := streamNonce(s.prefix, s.ctr, last)
+	if s.buf, err = s.a.aead.Open(s.chunk[:0], nonce, s.chunk[:n], s.ad); err != nil {
+		return err
+	}
+	s.ctr++
+	s.done = last
+	return nil
+}
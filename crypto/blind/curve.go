@@ -0,0 +1,204 @@
+package blind
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/math"
+)
+
+// Error codes
+var (
+	ErrBlindUnknownCurve = errors.New("unknown curve")
+	ErrBlindInvalidPoint = errors.New("invalid curve point")
+)
+
+//----------------------------------------------------------------------
+// Curves (and points on curves) usable for blind signatures
+//----------------------------------------------------------------------
+
+// Curve identifiers (used in serialized session states)
+const (
+	CurveSecp256k1 uint8 = 1
+	CurveEd25519   uint8 = 2
+)
+
+// Point on a curve
+type Point interface {
+	// Add two points
+	Add(q Point) Point
+
+	// Mult multiplies a point with a scalar
+	Mult(k *math.Int) Point
+
+	// Equals returns true if two points are equal
+	Equals(q Point) bool
+
+	// Bytes returns the (compressed) binary representation of a point
+	Bytes() []byte
+}
+
+// Curve used for blind signatures
+type Curve interface {
+	// ID returns the curve identifier
+	ID() uint8
+
+	// Order of the base point
+	Order() *math.Int
+
+	// MultBase multiplies the base point with a scalar
+	MultBase(k *math.Int) Point
+
+	// NewPoint reconstructs a point from its binary representation
+	NewPoint(data []byte) (Point, error)
+}
+
+// GetCurve returns the curve for a given identifier.
+func GetCurve(id uint8) (Curve, error) {
+	switch id {
+	case CurveSecp256k1:
+		return Secp256k1, nil
+	case CurveEd25519:
+		return Ed25519, nil
+	}
+	return nil, ErrBlindUnknownCurve
+}
+
+// scalar returns the 32-byte representation of a scalar
+func scalar(k *math.Int) []byte {
+	return k.FixedBytes(32)
+}
+
+//----------------------------------------------------------------------
+// Bitcoin curve (secp256k1)
+//----------------------------------------------------------------------
+
+// Secp256k1 is the Bitcoin curve
+var Secp256k1 Curve = new(secpCurve)
+
+type secpCurve struct{}
+
+type secpPoint struct {
+	p *bitcoin.Point
+}
+
+func (c *secpCurve) ID() uint8 {
+	return CurveSecp256k1
+}
+
+func (c *secpCurve) Order() *math.Int {
+	return bitcoin.GetCurve().N
+}
+
+func (c *secpCurve) MultBase(k *math.Int) Point {
+	return &secpPoint{bitcoin.MultBase(k)}
+}
+
+func (c *secpCurve) NewPoint(data []byte) (Point, error) {
+	if len(data) != 33 {
+		return nil, ErrBlindInvalidPoint
+	}
+	p, _, err := bitcoin.NewPointFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if p.IsInf() || !p.IsOnCurve() {
+		return nil, ErrBlindInvalidPoint
+	}
+	return &secpPoint{p}, nil
+}
+
+func (p *secpPoint) Add(q Point) Point {
+	return &secpPoint{p.p.Add(q.(*secpPoint).p)}
+}
+
+func (p *secpPoint) Mult(k *math.Int) Point {
+	return &secpPoint{p.p.Mult(k)}
+}
+
+func (p *secpPoint) Equals(q Point) bool {
+	qp, ok := q.(*secpPoint)
+	return ok && p.p.Equals(qp.p)
+}
+
+func (p *secpPoint) Bytes() []byte {
+	return p.p.Bytes(true)
+}
+
+//----------------------------------------------------------------------
+// Ed25519
+//----------------------------------------------------------------------
+
+// Ed25519 is the twisted Edwards curve used in EdDSA
+var Ed25519 Curve = new(edCurve)
+
+type edCurve struct{}
+
+type edPoint struct {
+	p *ed25519.Point
+}
+
+func (c *edCurve) ID() uint8 {
+	return CurveEd25519
+}
+
+func (c *edCurve) Order() *math.Int {
+	return ed25519.GetCurve().N
+}
+
+func (c *edCurve) MultBase(k *math.Int) Point {
+	return &edPoint{ed25519.GetCurve().MultBase(k)}
+}
+
+func (c *edCurve) NewPoint(data []byte) (Point, error) {
+	if len(data) != 32 {
+		return nil, ErrBlindInvalidPoint
+	}
+	p, err := ed25519.NewPointFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	// point must be in the prime-order subgroup
+	if p.IsInf() || !p.IsOnCurve() || !p.Mult(c.Order()).IsInf() {
+		return nil, ErrBlindInvalidPoint
+	}
+	return &edPoint{p}, nil
+}
+
+func (p *edPoint) Add(q Point) Point {
+	return &edPoint{p.p.Add(q.(*edPoint).p)}
+}
+
+func (p *edPoint) Mult(k *math.Int) Point {
+	return &edPoint{p.p.Mult(k)}
+}
+
+func (p *edPoint) Equals(q Point) bool {
+	qp, ok := q.(*edPoint)
+	return ok && p.p.Equals(qp.p)
+}
+
+func (p *edPoint) Bytes() []byte {
+	return p.p.Bytes()
+}
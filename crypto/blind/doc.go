@@ -0,0 +1,53 @@
+package blind
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+/*
+ * --------------------------------------------------------------------
+ * Schnorr blind signatures over the curves supported by Gospel
+ * (secp256k1 and Ed25519):
+ *
+ *   Signer                                 User
+ *   ------                                 ----
+ *   k random, R = k*G         --- R --->   a, b random
+ *                                          R' = R + a*G + b*X
+ *                                          c' = H(R'||X||m)
+ *                             <--- c ---   c  = c' + b
+ *   s = k + c*x               --- s --->   s' = s + a
+ *
+ * The unblinded signature (R',s') satisfies "s'*G = R' + c'*X" and
+ * can't be linked to the signing session by the signer.
+ *
+ * The state of signer and user sessions can be serialized, so the
+ * protocol can be run over (asynchronous) network services. A signer
+ * session must only be used once: answering two different challenges
+ * for the same commitment reveals the private key. The 'Signer' keeps
+ * track of open sessions, so restored session states can't be answered
+ * twice. Open sessions expire after 'BlindSessionTTL' and are not kept
+ * across restarts of the signer.
+ *
+ * Running many signer sessions concurrently is insecure: a user can
+ * forge an additional signature from parallel sessions (ROS attack,
+ * Benhamouda et al. 2021; Wagner's algorithm for fewer sessions). The
+ * number of open sessions of a signer is therefore limited (see
+ * 'BlindMaxSessions').
+ * --------------------------------------------------------------------
+ */
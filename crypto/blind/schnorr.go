@@ -0,0 +1,414 @@
+package blind
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/data"
+	"github.com/bfix/gospel/math"
+	gtime "github.com/bfix/gospel/time"
+)
+
+// Error codes
+var (
+	ErrBlindSessionUsed    = errors.New("signer session already used")
+	ErrBlindCurveMismatch  = errors.New("curve mismatch")
+	ErrBlindInvalidSig     = errors.New("invalid blind signature")
+	ErrBlindMalformed      = errors.New("malformed data")
+	ErrBlindSessionLimit   = errors.New("too many open signer sessions")
+	ErrBlindSessionExpired = errors.New("signer session expired")
+)
+
+// Signer session parameters
+var (
+	// BlindMaxSessions is the max. number of concurrently open sessions of
+	// a signer. Concurrent sessions allow ROS attacks (forging an
+	// additional signature from many parallel sessions); the polynomial
+	// attack needs more sessions than the bit length of the group order
+	// (256), so keep the limit well below that. Raise with care.
+	BlindMaxSessions = 16
+
+	// BlindSessionTTL is the time a signer session stays open; expired
+	// sessions can't be answered and don't count against the limit.
+	BlindSessionTTL = 5 * time.Minute
+)
+
+//----------------------------------------------------------------------
+// Keys
+//----------------------------------------------------------------------
+
+// PublicKey for blind signatures
+type PublicKey struct {
+	Curve Curve // curve used
+	Q     Point // public point 'X = x*G'
+}
+
+// NewPublicKeyFromBytes reconstructs a public key from its binary
+// representation (curve identifier and point).
+func NewPublicKeyFromBytes(buf []byte) (*PublicKey, error) {
+	if len(buf) < 2 {
+		return nil, ErrBlindMalformed
+	}
+	crv, err := GetCurve(buf[0])
+	if err != nil {
+		return nil, err
+	}
+	q, err := crv.NewPoint(buf[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKey{Curve: crv, Q: q}, nil
+}
+
+// Bytes returns the binary representation of a public key.
+func (pub *PublicKey) Bytes() []byte {
+	return append([]byte{pub.Curve.ID()}, pub.Q.Bytes()...)
+}
+
+// Verify an (unblinded) signature for a message.
+func (pub *PublicKey) Verify(msg []byte, sig *Signature) bool {
+	if sig == nil || sig.R == nil || sig.S == nil {
+		return false
+	}
+	c := challenge(pub, sig.R, msg)
+	return pub.Curve.MultBase(sig.S).Equals(sig.R.Add(pub.Q.Mult(c)))
+}
+
+// PrivateKey for blind signatures
+type PrivateKey struct {
+	PublicKey
+	D *math.Int // private scalar 'x'
+}
+
+// NewPrivateKey creates a new random private key on the given curve.
+func NewPrivateKey(crv Curve) *PrivateKey {
+	return NewPrivateKeyFromD(crv, math.NewIntRndRange(math.ONE, crv.Order()))
+}
+
+// NewPrivateKeyFromD creates a private key from a scalar value.
+func NewPrivateKeyFromD(crv Curve, d *math.Int) *PrivateKey {
+	return &PrivateKey{
+		PublicKey: PublicKey{
+			Curve: crv,
+			Q:     crv.MultBase(d),
+		},
+		D: d,
+	}
+}
+
+// Public returns the public key for a private key.
+func (prv *PrivateKey) Public() *PublicKey {
+	return &prv.PublicKey
+}
+
+//----------------------------------------------------------------------
+// Signature
+//----------------------------------------------------------------------
+
+// Signature (R',s') as created by unblinding a blind signature
+type Signature struct {
+	R Point
+	S *math.Int
+}
+
+// NewSignatureFromBytes reconstructs a signature on a given curve from
+// its binary representation.
+func NewSignatureFromBytes(crv Curve, buf []byte) (*Signature, error) {
+	n := len(buf) - 32
+	if n < 1 {
+		return nil, ErrBlindMalformed
+	}
+	r, err := crv.NewPoint(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{
+		R: r,
+		S: math.NewIntFromBytes(buf[n:]),
+	}, nil
+}
+
+// Bytes returns the binary representation of a signature.
+func (s *Signature) Bytes() []byte {
+	return append(s.R.Bytes(), scalar(s.S)...)
+}
+
+//----------------------------------------------------------------------
+// Signer session
+//----------------------------------------------------------------------
+
+// SignerSession holds the state of the signer in a blind signing
+// operation (the secret nonce 'k').
+type SignerSession struct {
+	Curve uint8  // curve identifier
+	Used  bool   // session used for signing?
+	K     []byte `size:"32"` // secret nonce
+}
+
+// NewSignerSessionFromBytes restores a signer session.
+func NewSignerSessionFromBytes(buf []byte) (s *SignerSession, err error) {
+	s = new(SignerSession)
+	if err = data.Unmarshal(s, buf); err != nil {
+		return nil, err
+	}
+	if _, err = GetCurve(s.Curve); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Bytes returns the binary representation of the session state.
+func (s *SignerSession) Bytes() []byte {
+	buf, _ := data.Marshal(s)
+	return buf
+}
+
+// Commitment returns the nonce commitment 'R = k*G' to be sent to the
+// user.
+func (s *SignerSession) Commitment() ([]byte, error) {
+	crv, err := GetCurve(s.Curve)
+	if err != nil {
+		return nil, err
+	}
+	return crv.MultBase(math.NewIntFromBytes(s.K)).Bytes(), nil
+}
+
+//----------------------------------------------------------------------
+// Signer
+//----------------------------------------------------------------------
+
+// Signer creates and answers signer sessions for a private key. It keeps
+// track of open sessions: a session can only be answered once, even if
+// an earlier (serialized) state of the session is restored, and only
+// within BlindSessionTTL after it was started.
+// The set of open sessions is kept in memory only: sessions don't
+// survive a restart of the signer (or a new signer for the same key)
+// and must be started again.
+type Signer struct {
+	prv   *PrivateKey          // private key of signer
+	open  map[string]time.Time // open sessions (deadline by nonce)
+	clock gtime.Clock          // clock for session deadlines
+	lock  sync.Mutex           // lock for concurrent access
+}
+
+// NewSigner creates a new signer for a private key.
+func NewSigner(prv *PrivateKey) *Signer {
+	return &Signer{
+		prv:   prv,
+		open:  make(map[string]time.Time),
+		clock: gtime.Real,
+	}
+}
+
+// SetClock sets the clock used for session deadlines (default: real
+// time).
+func (s *Signer) SetClock(clk gtime.Clock) {
+	s.lock.Lock()
+	s.clock = clk
+	s.lock.Unlock()
+}
+
+// NewSession starts a new signing session. At most BlindMaxSessions
+// sessions can be open at the same time; expired sessions are dropped.
+func (s *Signer) NewSession() (*SignerSession, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.clock.Now()
+	for k, t := range s.open {
+		if now.After(t) {
+			delete(s.open, k)
+		}
+	}
+	if len(s.open) >= BlindMaxSessions {
+		return nil, ErrBlindSessionLimit
+	}
+	k := math.NewIntRndRange(math.ONE, s.prv.Curve.Order())
+	ss := &SignerSession{
+		Curve: s.prv.Curve.ID(),
+		Used:  false,
+		K:     scalar(k),
+	}
+	s.open[string(ss.K)] = now.Add(BlindSessionTTL)
+	return ss, nil
+}
+
+// Abort an open session without signing.
+func (s *Signer) Abort(ss *SignerSession) {
+	s.lock.Lock()
+	delete(s.open, string(ss.K))
+	s.lock.Unlock()
+	ss.Used = true
+	ss.K = make([]byte, 32)
+}
+
+// Sign a blinded challenge 'c' received from the user: 's = k + c*x'.
+// A session can only sign once and before its deadline; the nonce is
+// wiped after use.
+func (s *Signer) Sign(ss *SignerSession, c []byte) ([]byte, error) {
+	if ss.Used {
+		return nil, ErrBlindSessionUsed
+	}
+	prv := s.prv
+	if prv.Curve.ID() != ss.Curve {
+		return nil, ErrBlindCurveMismatch
+	}
+	n := prv.Curve.Order()
+	ch := math.NewIntFromBytes(c)
+	if len(c) != 32 || ch.Cmp(n) >= 0 {
+		return nil, ErrBlindMalformed
+	}
+	// only open sessions can be answered
+	s.lock.Lock()
+	deadline, ok := s.open[string(ss.K)]
+	if !ok {
+		s.lock.Unlock()
+		return nil, ErrBlindSessionUsed
+	}
+	delete(s.open, string(ss.K))
+	expired := s.clock.Now().After(deadline)
+	s.lock.Unlock()
+	if expired {
+		ss.Used = true
+		ss.K = make([]byte, 32)
+		return nil, ErrBlindSessionExpired
+	}
+
+	k := math.NewIntFromBytes(ss.K)
+	ss.Used = true
+	ss.K = make([]byte, 32)
+	return scalar(k.Add(ch.Mul(prv.D)).Mod(n)), nil
+}
+
+//----------------------------------------------------------------------
+// User session
+//----------------------------------------------------------------------
+
+// UserSession holds the state of the user in a blind signing operation
+// (blinding factors and message).
+type UserSession struct {
+	Curve  uint8  // curve identifier
+	PtLen  uint8  // size of point representations
+	Pub    []byte `size:"PtLen"`  // public key of signer
+	R      []byte `size:"PtLen"`  // blinded nonce commitment R'
+	Alpha  []byte `size:"32"`     // blinding factor 'a'
+	CB     []byte `size:"32"`     // blinded challenge c
+	MsgLen uint32 `order:"big"`   // size of message
+	Msg    []byte `size:"MsgLen"` // message to be signed
+}
+
+// NewUserSession starts a blind signing session with a signer (public
+// key) for a message. 'r' is the nonce commitment received from the
+// signer.
+func NewUserSession(pub *PublicKey, r []byte, msg []byte) (*UserSession, error) {
+	crv := pub.Curve
+	R, err := crv.NewPoint(r)
+	if err != nil {
+		return nil, err
+	}
+	// blind the commitment and compute challenges
+	n := crv.Order()
+	a := math.NewIntRndRange(math.ONE, n)
+	b := math.NewIntRndRange(math.ONE, n)
+	Rb := R.Add(crv.MultBase(a)).Add(pub.Q.Mult(b))
+	c := challenge(pub, Rb, msg)
+	cb := c.Add(b).Mod(n)
+
+	pb := pub.Q.Bytes()
+	return &UserSession{
+		Curve:  crv.ID(),
+		PtLen:  uint8(len(pb)),
+		Pub:    pb,
+		R:      Rb.Bytes(),
+		Alpha:  scalar(a),
+		CB:     scalar(cb),
+		MsgLen: uint32(len(msg)),
+		Msg:    msg,
+	}, nil
+}
+
+// NewUserSessionFromBytes restores a user session.
+func NewUserSessionFromBytes(buf []byte) (u *UserSession, err error) {
+	u = new(UserSession)
+	if err = data.Unmarshal(u, buf); err != nil {
+		return nil, err
+	}
+	if _, err = GetCurve(u.Curve); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Bytes returns the binary representation of the session state.
+func (u *UserSession) Bytes() []byte {
+	buf, _ := data.Marshal(u)
+	return buf
+}
+
+// Challenge returns the blinded challenge 'c' to be sent to the signer.
+func (u *UserSession) Challenge() []byte {
+	return u.CB
+}
+
+// Unblind the response 's' of the signer and return the signature for
+// the message. The signature is verified before it is returned.
+func (u *UserSession) Unblind(s []byte) (*Signature, error) {
+	crv, err := GetCurve(u.Curve)
+	if err != nil {
+		return nil, err
+	}
+	if len(s) != 32 {
+		return nil, ErrBlindMalformed
+	}
+	pub, err := NewPublicKeyFromBytes(append([]byte{u.Curve}, u.Pub...))
+	if err != nil {
+		return nil, err
+	}
+	R, err := crv.NewPoint(u.R)
+	if err != nil {
+		return nil, err
+	}
+	a := math.NewIntFromBytes(u.Alpha)
+	sig := &Signature{
+		R: R,
+		S: math.NewIntFromBytes(s).Add(a).Mod(crv.Order()),
+	}
+	if !pub.Verify(u.Msg, sig) {
+		return nil, ErrBlindInvalidSig
+	}
+	return sig, nil
+}
+
+//----------------------------------------------------------------------
+// Helper functions
+//----------------------------------------------------------------------
+
+// challenge computes 'H(R||X||m) mod N'
+func challenge(pub *PublicKey, r Point, msg []byte) *math.Int {
+	h := sha256.New()
+	h.Write(r.Bytes())
+	h.Write(pub.Q.Bytes())
+	h.Write(msg)
+	return math.NewIntFromBytes(h.Sum(nil)).Mod(pub.Curve.Order())
+}
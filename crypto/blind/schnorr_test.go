@@ -0,0 +1,204 @@
+package blind
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"testing"
+	"time"
+
+	gtime "github.com/bfix/gospel/time"
+)
+
+func TestBlindSignature(t *testing.T) {
+	msg := []byte("token #1")
+	for _, crv := range []Curve{Secp256k1, Ed25519} {
+		prv := NewPrivateKey(crv)
+		pub, err := NewPublicKeyFromBytes(prv.Public().Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// signer: start session
+		signer := NewSigner(prv)
+		ss, err := signer.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := ss.Commitment()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// user: blind challenge (with session round-trip)
+		us, err := NewUserSession(pub, r, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if us, err = NewUserSessionFromBytes(us.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		// signer: sign blinded challenge (with session round-trip)
+		if ss, err = NewSignerSessionFromBytes(ss.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		s, err := signer.Sign(ss, us.Challenge())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = signer.Sign(ss, us.Challenge()); err != ErrBlindSessionUsed {
+			t.Fatal("session re-used")
+		}
+		// user: unblind and verify
+		sig, err := us.Unblind(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig, err = NewSignatureFromBytes(crv, sig.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if !pub.Verify(msg, sig) {
+			t.Fatal("verify failed")
+		}
+		if pub.Verify([]byte("token #2"), sig) {
+			t.Fatal("verify succeeded for wrong message")
+		}
+		// signature is unlinkable to the session commitment
+		if string(sig.R.Bytes()) == string(r) {
+			t.Fatal("commitment not blinded")
+		}
+		// wrong response
+		s[31] ^= 1
+		if _, err = us.Unblind(s); err != ErrBlindInvalidSig {
+			t.Fatal("invalid response accepted")
+		}
+	}
+}
+
+func TestBlindSessionRestore(t *testing.T) {
+	max := BlindMaxSessions
+	BlindMaxSessions = 1
+	defer func() { BlindMaxSessions = max }()
+
+	prv := NewPrivateKey(Ed25519)
+	signer := NewSigner(prv)
+	ss, err := signer.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// concurrent sessions are limited
+	if _, err = signer.NewSession(); err != ErrBlindSessionLimit {
+		t.Fatalf("session limit not enforced: %v", err)
+	}
+	// keep a copy of the session state before signing
+	state := ss.Bytes()
+	r, err := ss.Commitment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	us1, err := NewUserSession(prv.Public(), r, []byte("token #1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signer.Sign(ss, us1.Challenge()); err != nil {
+		t.Fatal(err)
+	}
+	// restored session can't sign a second challenge
+	restored, err := NewSignerSessionFromBytes(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us2, err := NewUserSession(prv.Public(), r, []byte("token #2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signer.Sign(restored, us2.Challenge()); err != ErrBlindSessionUsed {
+		t.Fatalf("restored session signed again: %v", err)
+	}
+	// aborted sessions free a slot and can't sign
+	if ss, err = signer.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	state = ss.Bytes()
+	signer.Abort(ss)
+	if restored, err = NewSignerSessionFromBytes(state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signer.Sign(restored, us2.Challenge()); err != ErrBlindSessionUsed {
+		t.Fatalf("aborted session signed: %v", err)
+	}
+	if _, err = signer.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlindSessionExpiry(t *testing.T) {
+	max := BlindMaxSessions
+	BlindMaxSessions = 1
+	defer func() { BlindMaxSessions = max }()
+
+	prv := NewPrivateKey(Ed25519)
+	signer := NewSigner(prv)
+	clk := gtime.NewFakeClock(time.Now())
+	signer.SetClock(clk)
+
+	// abandoned session blocks new sessions until it expires
+	ss, err := signer.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ss.Commitment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signer.NewSession(); err != ErrBlindSessionLimit {
+		t.Fatalf("session limit not enforced: %v", err)
+	}
+	clk.Advance(BlindSessionTTL + time.Second)
+	if _, err = signer.NewSession(); err != nil {
+		t.Fatalf("expired session still open: %v", err)
+	}
+	// expired session can't sign
+	us, err := NewUserSession(prv.Public(), r, []byte("token #1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signer.Sign(ss, us.Challenge()); err != ErrBlindSessionUsed {
+		t.Fatalf("expired session signed: %v", err)
+	}
+
+	// session answered after its deadline is rejected
+	signer = NewSigner(prv)
+	signer.SetClock(clk)
+	if ss, err = signer.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = ss.Commitment(); err != nil {
+		t.Fatal(err)
+	}
+	if us, err = NewUserSession(prv.Public(), r, []byte("token #2")); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(BlindSessionTTL + time.Second)
+	if _, err = signer.Sign(ss, us.Challenge()); err != ErrBlindSessionExpired {
+		t.Fatalf("late answer accepted: %v", err)
+	}
+	if _, err = signer.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+}
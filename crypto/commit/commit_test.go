@@ -0,0 +1,80 @@
+package commit
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"testing"
+
+	"github.com/bfix/gospel/math"
+)
+
+func TestCommitBalance(t *testing.T) {
+	// inputs: 30 + 12, outputs: 40 + 2
+	rIn := []*math.Int{NewBlinding(), NewBlinding()}
+	rOut := []*math.Int{NewBlinding()}
+	rOut = append(rOut, SumBlinding(rIn, rOut))
+
+	in := Commit(30, rIn[0]).Add(Commit(12, rIn[1]))
+	out := Commit(40, rOut[0]).Add(Commit(2, rOut[1]))
+	if !in.Sub(out).IsZero() {
+		t.Fatal("commitments not balanced")
+	}
+	if !Commit(7, rIn[0]).Open(7, rIn[0]) || Commit(7, rIn[0]).Open(8, rIn[0]) {
+		t.Fatal("open failed")
+	}
+	c, err := NewCommitmentFromBytes(in.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Equals(in) {
+		t.Fatal("restored commitment mismatch")
+	}
+}
+
+func TestRangeProof(t *testing.T) {
+	r := NewBlinding()
+	c, rp, err := ProveRange(12345, r, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Open(12345, r) {
+		t.Fatal("wrong commitment")
+	}
+	buf, err := rp.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp2, err := NewRangeProofFromBytes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyRange(c, rp2); err != nil {
+		t.Fatal(err)
+	}
+	// proof must not verify for other commitments
+	if err = VerifyRange(Commit(12345, NewBlinding()), rp2); err == nil {
+		t.Fatal("proof verified for wrong commitment")
+	}
+	// value out of range
+	if _, _, err = ProveRange(1<<16, r, 16); err != ErrRangeValue {
+		t.Fatal("out-of-range value accepted")
+	}
+}
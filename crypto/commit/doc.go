@@ -0,0 +1,31 @@
+package commit
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+/*
+ * --------------------------------------------------------------------
+ * Commitment schemes over the Bitcoin curve (secp256k1):
+ *     - Pedersen commitments 'C = r*G + v*H' with blinding factor
+ *       arithmetic and homomorphic addition
+ *     - Range proofs (bit decomposition with ring signatures) showing
+ *       that a committed value is in range [0,2^n[
+ * --------------------------------------------------------------------
+ */
@@ -0,0 +1,177 @@
+package commit
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/math"
+)
+
+// Error codes
+var (
+	ErrCommitInvalid = errors.New("invalid commitment")
+)
+
+var (
+	// curve parameters
+	curve = bitcoin.GetCurve()
+
+	// second generator 'H' (nothing-up-my-sleeve point with unknown
+	// discrete logarithm w.r.t. 'G')
+	genH = hashToPoint([]byte("gospel/crypto/commit: generator H"))
+)
+
+// GeneratorH returns the second generator used in commitments.
+func GeneratorH() *bitcoin.Point {
+	return genH
+}
+
+//----------------------------------------------------------------------
+// Blinding factors (scalars modulo the group order)
+//----------------------------------------------------------------------
+
+// NewBlinding returns a random blinding factor
+func NewBlinding() *math.Int {
+	return math.NewIntRndRange(math.ONE, curve.N.Sub(math.ONE))
+}
+
+// SumBlinding returns the sum of positive blinding factors minus the sum
+// of negative blinding factors (mod N). Used to balance commitments
+// like 'sum(inputs) - sum(outputs) = 0'.
+func SumBlinding(pos, neg []*math.Int) *math.Int {
+	s := math.ZERO
+	for _, r := range pos {
+		s = s.Add(r)
+	}
+	for _, r := range neg {
+		s = s.Sub(r)
+	}
+	return s.Mod(curve.N)
+}
+
+//----------------------------------------------------------------------
+// Pedersen commitment
+//----------------------------------------------------------------------
+
+// Commitment to a value 'v' with blinding factor 'r': 'C = r*G + v*H'
+type Commitment struct {
+	P *bitcoin.Point
+}
+
+// Commit to a value with given blinding factor.
+func Commit(v uint64, r *math.Int) *Commitment {
+	return CommitInt(math.NewIntFromBytes(u64Bytes(v)), r)
+}
+
+// CommitInt commits to an arbitrary (scalar) value.
+func CommitInt(v, r *math.Int) *Commitment {
+	p := bitcoin.MultBase(r.Mod(curve.N)).Add(genH.Mult(v.Mod(curve.N)))
+	return &Commitment{P: p}
+}
+
+// NewCommitmentFromBytes restores a commitment from its binary
+// representation (compressed point).
+func NewCommitmentFromBytes(buf []byte) (*Commitment, error) {
+	if len(buf) != 33 {
+		return nil, ErrCommitInvalid
+	}
+	p, _, err := bitcoin.NewPointFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	if !p.IsOnCurve() {
+		return nil, ErrCommitInvalid
+	}
+	return &Commitment{P: p}, nil
+}
+
+// Bytes returns the binary representation of a commitment.
+func (c *Commitment) Bytes() []byte {
+	return c.P.Bytes(true)
+}
+
+// Add commitments: the result commits to the sum of values with the
+// sum of blinding factors.
+func (c *Commitment) Add(d *Commitment) *Commitment {
+	return &Commitment{P: c.P.Add(d.P)}
+}
+
+// Sub subtracts commitments: the result commits to the difference of
+// values with the difference of blinding factors.
+func (c *Commitment) Sub(d *Commitment) *Commitment {
+	return &Commitment{P: c.P.Add(neg(d.P))}
+}
+
+// Equals checks if two commitments are the same.
+func (c *Commitment) Equals(d *Commitment) bool {
+	return c.P.Equals(d.P)
+}
+
+// Open checks if the commitment is for value 'v' with blinding factor 'r'.
+func (c *Commitment) Open(v uint64, r *math.Int) bool {
+	return c.Equals(Commit(v, r))
+}
+
+// IsZero checks if the commitment is to the value 0 with blinding factor
+// 0 (e.g. a balanced sum of commitments).
+func (c *Commitment) IsZero() bool {
+	return c.P.IsInf()
+}
+
+//----------------------------------------------------------------------
+// Helper functions
+//----------------------------------------------------------------------
+
+// negate a point
+func neg(p *bitcoin.Point) *bitcoin.Point {
+	if p.IsInf() {
+		return p
+	}
+	return bitcoin.NewPoint(p.X(), curve.P.Sub(p.Y()))
+}
+
+// hashToPoint maps data to a curve point (try-and-increment)
+func hashToPoint(data []byte) *bitcoin.Point {
+	h := sha256.Sum256(data)
+	x := math.NewIntFromBytes(h[:]).Mod(curve.P)
+	for {
+		if y, ok := bitcoin.Solve(x); ok {
+			p := bitcoin.NewPoint(x, y)
+			if p.IsOnCurve() {
+				return p
+			}
+		}
+		x = x.Add(math.ONE).Mod(curve.P)
+	}
+}
+
+// convert unsigned integer to big-endian bytes
+func u64Bytes(v uint64) []byte {
+	buf := make([]byte, 8)
+	for i := 7; i >= 0; i-- {
+		buf[i] = byte(v)
+		v >>= 8
+	}
+	return buf
+}
@@ -0,0 +1,187 @@
+package commit
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/bfix/gospel/bitcoin"
+	"github.com/bfix/gospel/data"
+	"github.com/bfix/gospel/math"
+)
+
+// Error codes
+var (
+	ErrRangeBits    = errors.New("invalid number of range bits")
+	ErrRangeValue   = errors.New("value out of range")
+	ErrRangeInvalid = errors.New("invalid range proof")
+)
+
+//----------------------------------------------------------------------
+// Range proof:
+// The committed value 'v' is decomposed into bits 'b_i' with
+// 'v = sum(b_i * 2^i)'. For each bit a commitment 'C_i = r_i*G +
+// b_i*2^i*H' is created with 'sum(r_i) = r', so 'sum(C_i) = C'. For
+// each 'C_i' a ring signature (AOS) over the two keys 'C_i' and
+// 'C_i - 2^i*H' proves that one of them is a multiple of 'G' (and
+// the bit commitment is either to 0 or to 2^i) without revealing which.
+//----------------------------------------------------------------------
+
+// bitProof is the proof for a single bit
+type bitProof struct {
+	C  []byte `size:"33"` // bit commitment
+	E0 []byte `size:"32"` // ring challenge
+	S0 []byte `size:"32"` // ring response for key 0
+	S1 []byte `size:"32"` // ring response for key 1
+}
+
+// RangeProof shows that a commitment is to a value in range [0,2^n[
+type RangeProof struct {
+	N    uint8       // number of bits
+	Bits []*bitProof `size:"N"` // proofs for bits
+}
+
+// NewRangeProofFromBytes restores a range proof from binary data.
+func NewRangeProofFromBytes(buf []byte) (*RangeProof, error) {
+	rp := new(RangeProof)
+	if err := data.Unmarshal(rp, buf); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+// Bytes returns the binary representation of a range proof.
+func (rp *RangeProof) Bytes() ([]byte, error) {
+	return data.Marshal(rp)
+}
+
+// ProveRange creates a commitment for value 'v' with blinding factor
+// 'r' and a proof that the value is in range [0,2^n[ (n <= 64).
+func ProveRange(v uint64, r *math.Int, n int) (*Commitment, *RangeProof, error) {
+	if n < 1 || n > 64 {
+		return nil, nil, ErrRangeBits
+	}
+	if n < 64 && v >= 1<<uint(n) {
+		return nil, nil, ErrRangeValue
+	}
+	c := Commit(v, r)
+	rp := &RangeProof{
+		N:    uint8(n),
+		Bits: make([]*bitProof, n),
+	}
+	// blinding factors for bits (last one balances the sum)
+	rSum := math.ZERO
+	for i := 0; i < n; i++ {
+		var ri *math.Int
+		if i < n-1 {
+			ri = NewBlinding()
+			rSum = rSum.Add(ri)
+		} else {
+			ri = r.Sub(rSum).Mod(curve.N)
+		}
+		bit := int((v >> uint(i)) & 1)
+		rp.Bits[i] = proveBit(c, i, bit, ri)
+	}
+	return c, rp, nil
+}
+
+// VerifyRange checks a range proof for a commitment.
+func VerifyRange(c *Commitment, rp *RangeProof) error {
+	n := int(rp.N)
+	if n < 1 || n > 64 || len(rp.Bits) != n {
+		return ErrRangeBits
+	}
+	sum := bitcoin.Inf
+	for i, bp := range rp.Bits {
+		ci, err := NewCommitmentFromBytes(bp.C)
+		if err != nil {
+			return ErrRangeInvalid
+		}
+		if !verifyBit(c, i, ci.P, bp) {
+			return ErrRangeInvalid
+		}
+		sum = sum.Add(ci.P)
+	}
+	if !sum.Equals(c.P) {
+		return ErrRangeInvalid
+	}
+	return nil
+}
+
+// create proof for bit 'i' of commitment 'c'
+func proveBit(c *Commitment, i, bit int, ri *math.Int) *bitProof {
+	// bit commitment and ring keys
+	pow := powH(i)
+	ci := bitcoin.MultBase(ri)
+	if bit == 1 {
+		ci = ci.Add(pow)
+	}
+	keys := []*bitcoin.Point{ci, ci.Add(neg(pow))}
+
+	// ring signature (signer index is 'bit')
+	e := make([]*math.Int, 2)
+	s := make([]*math.Int, 2)
+	k := NewBlinding()
+	other := 1 - bit
+	e[other] = ringHash(c, i, ci, bitcoin.MultBase(k))
+	s[other] = NewBlinding()
+	rOther := bitcoin.MultBase(s[other]).Add(neg(keys[other].Mult(e[other])))
+	e[bit] = ringHash(c, i, ci, rOther)
+	s[bit] = k.Add(e[bit].Mul(ri)).Mod(curve.N)
+
+	return &bitProof{
+		C:  ci.Bytes(true),
+		E0: e[0].FixedBytes(32),
+		S0: s[0].FixedBytes(32),
+		S1: s[1].FixedBytes(32),
+	}
+}
+
+// verify proof for bit 'i' of commitment 'c'
+func verifyBit(c *Commitment, i int, ci *bitcoin.Point, bp *bitProof) bool {
+	keys := []*bitcoin.Point{ci, ci.Add(neg(powH(i)))}
+	e0 := math.NewIntFromBytes(bp.E0)
+	s0 := math.NewIntFromBytes(bp.S0)
+	s1 := math.NewIntFromBytes(bp.S1)
+	if e0.Cmp(curve.N) >= 0 || s0.Cmp(curve.N) >= 0 || s1.Cmp(curve.N) >= 0 {
+		return false
+	}
+	r0 := bitcoin.MultBase(s0).Add(neg(keys[0].Mult(e0)))
+	e1 := ringHash(c, i, ci, r0)
+	r1 := bitcoin.MultBase(s1).Add(neg(keys[1].Mult(e1)))
+	return ringHash(c, i, ci, r1).Equals(e0)
+}
+
+// compute '2^i * H'
+func powH(i int) *bitcoin.Point {
+	return genH.Mult(math.ONE.Lsh(uint(i)))
+}
+
+// ring challenge 'e = H(C, i, C_i, R) mod N'
+func ringHash(c *Commitment, i int, ci, r *bitcoin.Point) *math.Int {
+	h := sha256.New()
+	h.Write(c.Bytes())
+	h.Write([]byte{byte(i)})
+	h.Write(ci.Bytes(true))
+	h.Write(r.Bytes(true))
+	return math.NewIntFromBytes(h.Sum(nil)).Mod(curve.N)
+}
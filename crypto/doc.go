@@ -27,5 +27,6 @@ package crypto
  *     - Cryptographically strong random number generation
  *     - Paillier cryptographic counters
  *     - OpenPGP helper functions
+ *     - MuHash3072 homomorphic set hash
  * --------------------------------------------------------------------
  */
@@ -29,6 +29,7 @@ package ed25519
  *   - EdDSA signatures
  *   - ECDSA signatures
  *   - ECDHE key exchange
+ *   - (linkable) ring signatures
  *
  * A private key can either defined by a secret seed (see RFC 8032) or
  * by specifying the private scalar 'd'. If the private key is defined
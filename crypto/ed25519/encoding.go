@@ -0,0 +1,291 @@
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package ed25519
+
+import (
+	"bytes"
+	stded "crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+
+	"github.com/bfix/gospel/math"
+	"golang.org/x/crypto/ssh"
+)
+
+// Error codes for key serialization
+var (
+	ErrKeyNoSeed = errors.New("private key has no seed")
+	ErrKeyFormat = errors.New("invalid key format")
+	ErrKeyType   = errors.New("not an Ed25519 key")
+)
+
+//----------------------------------------------------------------------
+// Key serialization:
+// PKCS#8/PKIX (DER and PEM) and OpenSSH formats store the seed of a
+// private key; keys without seed (e.g. derived from a scalar) can't be
+// exported in these formats. Tor uses the expanded private key (scalar
+// and nonce) for hidden services ("ED25519-V3" key blobs and on-disk
+// key files).
+//----------------------------------------------------------------------
+
+// convert to standard library key
+func (prv *PrivateKey) std() (stded.PrivateKey, error) {
+	if len(prv.Seed) != 32 {
+		return nil, ErrKeyNoSeed
+	}
+	return stded.NewKeyFromSeed(prv.Seed), nil
+}
+
+// convert from standard library key
+func fromStd(key any) (*PrivateKey, error) {
+	var k stded.PrivateKey
+	switch x := key.(type) {
+	case stded.PrivateKey:
+		k = x
+	case *stded.PrivateKey:
+		k = *x
+	default:
+		return nil, ErrKeyType
+	}
+	return NewPrivateKeyFromSeed(k.Seed()), nil
+}
+
+//----------------------------------------------------------------------
+// PKCS#8 and PKIX
+//----------------------------------------------------------------------
+
+// MarshalPKCS8 returns the PKCS#8 (DER) encoding of a private key.
+func (prv *PrivateKey) MarshalPKCS8() ([]byte, error) {
+	key, err := prv.std()
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+// ParsePKCS8PrivateKey decodes a PKCS#8 (DER) private key.
+func ParsePKCS8PrivateKey(der []byte) (*PrivateKey, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return fromStd(key)
+}
+
+// MarshalPKIX returns the PKIX (DER) encoding of a public key.
+func (pub *PublicKey) MarshalPKIX() ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(stded.PublicKey(pub.Bytes()))
+}
+
+// ParsePKIXPublicKey decodes a PKIX (DER) public key.
+func ParsePKIXPublicKey(der []byte) (*PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := key.(stded.PublicKey)
+	if !ok {
+		return nil, ErrKeyType
+	}
+	return newPublicKey(k)
+}
+
+// PEM returns the PEM-encoded PKCS#8 private key.
+func (prv *PrivateKey) PEM() ([]byte, error) {
+	der, err := prv.MarshalPKCS8()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// PEM returns the PEM-encoded PKIX public key.
+func (pub *PublicKey) PEM() ([]byte, error) {
+	der, err := pub.MarshalPKIX()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKeyPEM decodes a PEM-encoded private key (PKCS#8 or
+// OpenSSH format).
+func ParsePrivateKeyPEM(data []byte) (*PrivateKey, error) {
+	blk, _ := pem.Decode(data)
+	if blk == nil {
+		return nil, ErrKeyFormat
+	}
+	switch blk.Type {
+	case "PRIVATE KEY":
+		return ParsePKCS8PrivateKey(blk.Bytes)
+	case "OPENSSH PRIVATE KEY":
+		return ParseOpenSSHPrivateKey(data)
+	}
+	return nil, ErrKeyFormat
+}
+
+// ParsePublicKeyPEM decodes a PEM-encoded PKIX public key.
+func ParsePublicKeyPEM(data []byte) (*PublicKey, error) {
+	blk, _ := pem.Decode(data)
+	if blk == nil || blk.Type != "PUBLIC KEY" {
+		return nil, ErrKeyFormat
+	}
+	return ParsePKIXPublicKey(blk.Bytes)
+}
+
+//----------------------------------------------------------------------
+// OpenSSH
+//----------------------------------------------------------------------
+
+// MarshalOpenSSH returns the private key in OpenSSH format (PEM-encoded,
+// unencrypted).
+func (prv *PrivateKey) MarshalOpenSSH(comment string) ([]byte, error) {
+	key, err := prv.std()
+	if err != nil {
+		return nil, err
+	}
+	blk, err := ssh.MarshalPrivateKey(key, comment)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(blk), nil
+}
+
+// ParseOpenSSHPrivateKey decodes an (unencrypted) OpenSSH private key.
+func ParseOpenSSHPrivateKey(data []byte) (*PrivateKey, error) {
+	key, err := ssh.ParseRawPrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return fromStd(key)
+}
+
+// AuthorizedKey returns the public key as a line in OpenSSH's
+// "authorized_keys" format ("ssh-ed25519 <base64> <comment>").
+func (pub *PublicKey) AuthorizedKey(comment string) (string, error) {
+	key, err := ssh.NewPublicKey(stded.PublicKey(pub.Bytes()))
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	if len(comment) > 0 {
+		line += " " + comment
+	}
+	return line, nil
+}
+
+// ParseAuthorizedKey decodes a public key in "authorized_keys" format
+// and returns the key and its comment.
+func ParseAuthorizedKey(line string) (*PublicKey, string, error) {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return nil, "", err
+	}
+	ck, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, "", ErrKeyType
+	}
+	k, ok := ck.CryptoPublicKey().(stded.PublicKey)
+	if !ok {
+		return nil, "", ErrKeyType
+	}
+	pub, err := newPublicKey(k)
+	return pub, comment, err
+}
+
+//----------------------------------------------------------------------
+// Tor hidden service keys
+//----------------------------------------------------------------------
+
+// Tor key file headers
+var (
+	torSecretHdr = []byte("== ed25519v1-secret: type0 ==\x00\x00\x00")
+	torPublicHdr = []byte("== ed25519v1-public: type0 ==\x00\x00\x00")
+)
+
+// TorBlob returns the expanded private key (scalar in little-endian
+// order and nonce) as used in "ED25519-V3" key blobs.
+func (prv *PrivateKey) TorBlob() []byte {
+	buf := make([]byte, 64)
+	copy(buf, scalarBytes(prv.D))
+	copy(buf[32:], prv.Nonce)
+	return buf
+}
+
+// NewPrivateKeyFromTorBlob returns a private key for an expanded key.
+func NewPrivateKeyFromTorBlob(blob []byte) (*PrivateKey, error) {
+	if len(blob) != 64 {
+		return nil, ErrKeyFormat
+	}
+	d := math.NewIntFromBytes(reverse(blob[:32]))
+	if d.Sign() == 0 {
+		return nil, ErrKeyFormat
+	}
+	key := &PrivateKey{
+		D:     d,
+		Nonce: make([]byte, 32),
+	}
+	copy(key.Nonce, blob[32:])
+	key.Q = c.MultBase(d)
+	return key, nil
+}
+
+// TorSecretKeyFile returns the content of a Tor secret key file
+// ("hs_ed25519_secret_key").
+func (prv *PrivateKey) TorSecretKeyFile() []byte {
+	return append(append([]byte{}, torSecretHdr...), prv.TorBlob()...)
+}
+
+// ParseTorSecretKeyFile decodes a Tor secret key file.
+func ParseTorSecretKeyFile(data []byte) (*PrivateKey, error) {
+	if len(data) != 96 || !bytes.Equal(data[:32], torSecretHdr) {
+		return nil, ErrKeyFormat
+	}
+	return NewPrivateKeyFromTorBlob(data[32:])
+}
+
+// TorPublicKeyFile returns the content of a Tor public key file
+// ("hs_ed25519_public_key").
+func (pub *PublicKey) TorPublicKeyFile() []byte {
+	return append(append([]byte{}, torPublicHdr...), pub.Bytes()...)
+}
+
+// ParseTorPublicKeyFile decodes a Tor public key file.
+func ParseTorPublicKeyFile(data []byte) (*PublicKey, error) {
+	if len(data) != 64 || !bytes.Equal(data[:32], torPublicHdr) {
+		return nil, ErrKeyFormat
+	}
+	return newPublicKey(data[32:])
+}
+
+// newPublicKey decodes a binary public key
+func newPublicKey(data []byte) (*PublicKey, error) {
+	if len(data) != 32 {
+		return nil, ErrKeyFormat
+	}
+	pub := NewPublicKeyFromBytes(data)
+	if pub == nil {
+		return nil, ErrKeyFormat
+	}
+	return pub, nil
+}
@@ -67,6 +67,7 @@ func (pub *PublicKey) Mult(n *math.Int) *PublicKey {
 type PrivateKey struct {
 	PublicKey
 
+	Seed  []byte    // 32-byte seed (nil if key is not derived from seed)
 	Nonce []byte    // 32-byte nonce
 	D     *math.Int // private scalar
 }
@@ -87,7 +88,9 @@ func NewPrivateKeyFromSeed(seed []byte) *PrivateKey {
 	d[0] = (d[0] & 0x3f) | 0x40
 	d[31] &= 0xf8
 
-	// save nonce
+	// save seed and nonce
+	key.Seed = make([]byte, 32)
+	copy(key.Seed, seed)
 	key.Nonce = make([]byte, 32)
 	copy(key.Nonce, md[32:])
 
@@ -0,0 +1,255 @@
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+package ed25519
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/bfix/gospel/math"
+)
+
+// ringLabel for hashing public keys to curve points
+var ringLabel = []byte("ring-hp")
+
+// Error codes for ring signatures
+var (
+	ErrRingTooSmall     = errors.New("ring too small")
+	ErrRingNoSigner     = errors.New("signer not in ring")
+	ErrRingSigMalformed = errors.New("malformed ring signature")
+)
+
+//----------------------------------------------------------------------
+// Ring signatures (SAG and LSAG; see "Linkable Spontaneous Anonymous
+// Group Signature for Ad Hoc Groups" by Liu, Wei and Wong, 2004)
+//
+// A ring signature proves that the message was signed by one of the
+// private keys belonging to the public keys in the ring without
+// revealing which one. A linkable ring signature (LSAG) additionally
+// carries a key image 'I = x * Hp(P)' that is unique for the signing
+// key: two signatures with the same key image were created by the same
+// signer (independent of the ring used).
+//----------------------------------------------------------------------
+
+// RingSignature is a (linkable) ring signature. The key image is nil
+// for non-linkable signatures.
+type RingSignature struct {
+	I  *Point      // key image (LSAG only)
+	C0 *math.Int   // initial challenge
+	S  []*math.Int // responses (one for each ring member)
+}
+
+// NewRingSignatureFromBytes reconstructs a ring signature from its
+// binary representation.
+func NewRingSignatureFromBytes(data []byte) (*RingSignature, error) {
+	// check header
+	if len(data) < 3 {
+		return nil, ErrRingSigMalformed
+	}
+	n := int(binary.BigEndian.Uint16(data[:2]))
+	linked := data[2] != 0
+	size := 3 + 32*(n+1)
+	if linked {
+		size += 32
+	}
+	if n < 2 || len(data) != size {
+		return nil, ErrRingSigMalformed
+	}
+	// extract values
+	sig := &RingSignature{
+		S: make([]*math.Int, n),
+	}
+	pos := 3
+	if linked {
+		var err error
+		if sig.I, err = NewPointFromBytes(data[pos : pos+32]); err != nil {
+			return nil, err
+		}
+		pos += 32
+	}
+	sig.C0 = math.NewIntFromBytes(reverse(data[pos : pos+32]))
+	for i := range sig.S {
+		pos += 32
+		sig.S[i] = math.NewIntFromBytes(reverse(data[pos : pos+32]))
+	}
+	return sig, nil
+}
+
+// Bytes returns the binary representation of a ring signature.
+func (s *RingSignature) Bytes() []byte {
+	buf := make([]byte, 3, 3+32*(len(s.S)+2))
+	binary.BigEndian.PutUint16(buf, uint16(len(s.S)))
+	if s.I != nil {
+		buf[2] = 1
+		buf = append(buf, s.I.Bytes()...)
+	}
+	buf = append(buf, scalarBytes(s.C0)...)
+	for _, v := range s.S {
+		buf = append(buf, scalarBytes(v)...)
+	}
+	return buf
+}
+
+// Linkable returns true if the signature carries a key image.
+func (s *RingSignature) Linkable() bool {
+	return s.I != nil
+}
+
+// Linked returns true if both signatures were created by the same
+// private key. Only linkable signatures can be linked.
+func (s *RingSignature) Linked(t *RingSignature) bool {
+	if s.I == nil || t.I == nil {
+		return false
+	}
+	return s.I.Equals(t.I)
+}
+
+// KeyImage returns the (unique) key image of the private key as used in
+// linkable ring signatures.
+func (prv *PrivateKey) KeyImage() *Point {
+	return HashToPoint(ringLabel, prv.Public().Bytes()).Mult(prv.D)
+}
+
+// RingSign creates a non-linkable ring signature (SAG) for a message.
+// The public key of the signer must be part of the ring.
+func (prv *PrivateKey) RingSign(msg []byte, ring []*PublicKey) (*RingSignature, error) {
+	return prv.ringSign(msg, ring, false)
+}
+
+// LinkedRingSign creates a linkable ring signature (LSAG) for a message.
+// The public key of the signer must be part of the ring.
+func (prv *PrivateKey) LinkedRingSign(msg []byte, ring []*PublicKey) (*RingSignature, error) {
+	return prv.ringSign(msg, ring, true)
+}
+
+// ringSign creates a ring signature for a message.
+func (prv *PrivateKey) ringSign(msg []byte, ring []*PublicKey, linked bool) (*RingSignature, error) {
+	// check ring and find signer
+	n := len(ring)
+	if n < 2 {
+		return nil, ErrRingTooSmall
+	}
+	pub := prv.Public()
+	pi := -1
+	for i, p := range ring {
+		if p.Q.Equals(pub.Q) {
+			pi = i
+			break
+		}
+	}
+	if pi < 0 {
+		return nil, ErrRingNoSigner
+	}
+	pfx := ringPrefix(msg, ring)
+
+	// compute key image (for linkable signatures)
+	sig := &RingSignature{
+		S: make([]*math.Int, n),
+	}
+	var hp *Point
+	if linked {
+		hp = HashToPoint(ringLabel, pub.Bytes())
+		sig.I = hp.Mult(prv.D)
+	}
+	// start the ring at the signer
+	alpha := math.NewIntRndRange(math.ONE, c.N)
+	var R *Point
+	if linked {
+		R = hp.Mult(alpha)
+	}
+	ch := make([]*math.Int, n)
+	i := (pi + 1) % n
+	ch[i] = ringChallenge(pfx, c.MultBase(alpha), R)
+
+	// close the ring with random responses for all other members
+	for i != pi {
+		sig.S[i] = math.NewIntRndRange(math.ONE, c.N)
+		L, R := ringCommit(ring[i], sig.I, sig.S[i], ch[i])
+		ch[(i+1)%n] = ringChallenge(pfx, L, R)
+		i = (i + 1) % n
+	}
+	// compute the response of the signer
+	sig.S[pi] = alpha.Sub(ch[pi].Mul(prv.D)).Mod(c.N)
+	sig.C0 = ch[0]
+	return sig, nil
+}
+
+// RingVerify checks a (linkable or non-linkable) ring signature for a
+// message and a ring of public keys.
+func RingVerify(msg []byte, ring []*PublicKey, sig *RingSignature) bool {
+	n := len(ring)
+	if n < 2 || len(sig.S) != n || sig.C0 == nil {
+		return false
+	}
+	// a key image must be in the prime-order subgroup
+	if sig.I != nil {
+		if !sig.I.IsOnCurve() || sig.I.IsInf() || !sig.I.Mult(c.N).IsInf() {
+			return false
+		}
+	}
+	pfx := ringPrefix(msg, ring)
+	ch := sig.C0
+	for i := 0; i < n; i++ {
+		if sig.S[i] == nil {
+			return false
+		}
+		L, R := ringCommit(ring[i], sig.I, sig.S[i], ch)
+		ch = ringChallenge(pfx, L, R)
+	}
+	return ch.Cmp(sig.C0) == 0
+}
+
+// ringCommit computes 'L = s*G + c*P' and (for linkable signatures)
+// 'R = s*Hp(P) + c*I' for a ring member.
+func ringCommit(pub *PublicKey, img *Point, s, ch *math.Int) (L, R *Point) {
+	L = c.MultBase(s).Add(pub.Q.Mult(ch))
+	if img != nil {
+		R = HashToPoint(ringLabel, pub.Bytes()).Mult(s).Add(img.Mult(ch))
+	}
+	return
+}
+
+// ringPrefix returns the common hash input (message and ring members)
+// for all challenges of a ring signature.
+func ringPrefix(msg []byte, ring []*PublicKey) []byte {
+	buf := make([]byte, 0, 32*len(ring)+len(msg))
+	for _, p := range ring {
+		buf = append(buf, p.Bytes()...)
+	}
+	return append(buf, msg...)
+}
+
+// ringChallenge computes the challenge 'c = H(ring||msg||L||R) mod N'.
+func ringChallenge(pfx []byte, L, R *Point) *math.Int {
+	var rb []byte
+	if R != nil {
+		rb = R.Bytes()
+	}
+	return h2i(pfx, L.Bytes(), rb).Mod(c.N)
+}
+
+// scalarBytes returns the 32-byte little-endian representation of a
+// scalar value.
+func scalarBytes(v *math.Int) []byte {
+	buf := make([]byte, 32)
+	copyBlock(buf, v.Bytes())
+	return reverse(buf)
+}
@@ -0,0 +1,108 @@
+package ed25519
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"testing"
+)
+
+func newTestRing(n int) ([]*PublicKey, []*PrivateKey) {
+	ring := make([]*PublicKey, n)
+	keys := make([]*PrivateKey, n)
+	for i := range ring {
+		ring[i], keys[i] = NewKeypair()
+	}
+	return ring, keys
+}
+
+func TestRingSignature(t *testing.T) {
+	msg := []byte("ring signature test")
+	ring, keys := newTestRing(4)
+
+	for _, linked := range []bool{false, true} {
+		sig, err := keys[2].ringSign(msg, ring, linked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.Linkable() != linked {
+			t.Fatal("wrong signature type")
+		}
+		if !RingVerify(msg, ring, sig) {
+			t.Fatal("ring signature failed")
+		}
+		if RingVerify([]byte("other message"), ring, sig) {
+			t.Fatal("ring signature verified for wrong message")
+		}
+		other, _ := newTestRing(4)
+		if RingVerify(msg, other, sig) {
+			t.Fatal("ring signature verified for wrong ring")
+		}
+		// serialization
+		sig2, err := NewRingSignatureFromBytes(sig.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !RingVerify(msg, ring, sig2) {
+			t.Fatal("deserialized ring signature failed")
+		}
+	}
+	// signer not in ring
+	prv := NewPrivateKeyFromD(keys[0].D.Add(keys[1].D))
+	if _, err := prv.RingSign(msg, ring); err != ErrRingNoSigner {
+		t.Fatal("signed with key outside ring")
+	}
+}
+
+func TestRingLinked(t *testing.T) {
+	ring, keys := newTestRing(3)
+	ring2, _ := newTestRing(2)
+	ring2 = append(ring2, ring[1])
+
+	s1, err := keys[1].LinkedRingSign([]byte("msg1"), ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := keys[1].LinkedRingSign([]byte("msg2"), ring2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3, err := keys[0].LinkedRingSign([]byte("msg1"), ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !RingVerify([]byte("msg2"), ring2, s2) {
+		t.Fatal("ring signature failed")
+	}
+	if !s1.Linked(s2) {
+		t.Fatal("key image reuse not detected")
+	}
+	if s1.Linked(s3) {
+		t.Fatal("different signers linked")
+	}
+	if !s1.I.Equals(keys[1].KeyImage()) {
+		t.Fatal("key image mismatch")
+	}
+	// tampered key image
+	s1.I = s3.I
+	if RingVerify([]byte("msg1"), ring, s1) {
+		t.Fatal("forged key image accepted")
+	}
+}
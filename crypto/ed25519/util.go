@@ -22,6 +22,7 @@ package ed25519
 
 import (
 	"crypto/sha512"
+	"encoding/binary"
 
 	"github.com/bfix/gospel/math"
 )
@@ -66,3 +67,21 @@ func h2i(blks ...[]byte) *math.Int {
 	md := hsh.Sum(nil)
 	return math.NewIntFromBytes(reverse(md))
 }
+
+// HashToPoint maps a sequence of byte arrays to a point in the prime-
+// order subgroup with unknown discrete logarithm (try-and-increment on
+// the y-coordinate; cofactor cleared).
+func HashToPoint(blks ...[]byte) *Point {
+	ctr := make([]byte, 4)
+	for i := uint32(0); ; i++ {
+		binary.BigEndian.PutUint32(ctr, i)
+		y := h2i(append(blks[:len(blks):len(blks)], ctr)...).Mod(c.P)
+		p := NewPoint(c.SolveX(y), y)
+		if !p.IsOnCurve() {
+			continue
+		}
+		if p = p.Mult(math.EIGHT); !p.IsInf() {
+			return p
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package crypto
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+
+	"github.com/bfix/gospel/data"
+	"github.com/bfix/gospel/math"
+)
+
+// Error codes
+var (
+	ErrElGamalProof   = errors.New("invalid decryption proof")
+	ErrElGamalDLog    = errors.New("discrete logarithm out of range")
+	ErrElGamalMessage = errors.New("message not in group")
+	ErrElGamalCipher  = errors.New("ciphertext not in group")
+)
+
+//----------------------------------------------------------------------
+// ElGamal encryption in the prime-order subgroup of a prime field
+// with safe prime modulus 'p = 2q + 1'. The group generator 'g' has
+// order 'q'.
+//----------------------------------------------------------------------
+
+// ElGamalGroup defines the group parameters
+type ElGamalGroup struct {
+	FieldP           // prime field
+	Q      *math.Int // order of subgroup
+	G      *math.Int // generator of subgroup
+}
+
+// NewElGamalGroup generates new group parameters with a safe prime of
+// given bit size. This can take quite a while for large sizes.
+func NewElGamalGroup(bits int) *ElGamalGroup {
+	var p, q *math.Int
+	for {
+		q = math.NewIntRndPrimeBits(bits - 1)
+		p = q.Lsh(1).Add(math.ONE)
+		if p.BitLen() == bits && p.ProbablyPrime(128) {
+			break
+		}
+	}
+	// find generator of subgroup (quadratic residues)
+	var g *math.Int
+	for {
+		h := math.NewIntRndRange(math.TWO, p.Sub(math.TWO))
+		if g = h.ModPow(math.TWO, p); !g.Equals(math.ONE) {
+			break
+		}
+	}
+	return &ElGamalGroup{
+		FieldP: FieldP{P: p},
+		Q:      q,
+		G:      g,
+	}
+}
+
+// Exp computes 'g^k mod p'
+func (grp *ElGamalGroup) Exp(k *math.Int) *math.Int {
+	return grp.G.ModPow(k, grp.P)
+}
+
+// Contains checks if a value is an element of the subgroup
+func (grp *ElGamalGroup) Contains(v *math.Int) bool {
+	if v.Sign() <= 0 || v.Cmp(grp.P) >= 0 {
+		return false
+	}
+	return v.ModPow(grp.Q, grp.P).Equals(math.ONE)
+}
+
+// ElGamalPublicKey is the public key 'Y = g^x mod p'
+type ElGamalPublicKey struct {
+	*ElGamalGroup
+	Y *math.Int
+}
+
+// ElGamalPrivateKey is a random value 'x' from '[1,q['
+type ElGamalPrivateKey struct {
+	*ElGamalPublicKey
+	X *math.Int
+}
+
+// NewElGamalPrivateKey creates a new key pair for given group.
+func NewElGamalPrivateKey(grp *ElGamalGroup) *ElGamalPrivateKey {
+	x := math.NewIntRndRange(math.ONE, grp.Q.Sub(math.ONE))
+	return &ElGamalPrivateKey{
+		ElGamalPublicKey: &ElGamalPublicKey{
+			ElGamalGroup: grp,
+			Y:            grp.Exp(x),
+		},
+		X: x,
+	}
+}
+
+// Public returns the public key for a private key.
+func (k *ElGamalPrivateKey) Public() *ElGamalPublicKey {
+	return k.ElGamalPublicKey
+}
+
+// ElGamalCiphertext is an encrypted message '(c1,c2) = (g^r, m*Y^r)'
+type ElGamalCiphertext struct {
+	C1, C2 *math.Int
+}
+
+// Encrypt a message (a group element) with public key.
+func (k *ElGamalPublicKey) Encrypt(m *math.Int) (*ElGamalCiphertext, error) {
+	if !k.Contains(m) {
+		return nil, ErrElGamalMessage
+	}
+	r := math.NewIntRndRange(math.ONE, k.Q.Sub(math.ONE))
+	return &ElGamalCiphertext{
+		C1: k.Exp(r),
+		C2: k.FieldP.Mul(m, k.Y.ModPow(r, k.P)),
+	}, nil
+}
+
+// EncryptExp encrypts a small integer 'm' as group element 'g^m'
+// ("exponential ElGamal"). Ciphertexts of this kind are additively
+// homomorphic.
+func (k *ElGamalPublicKey) EncryptExp(m *math.Int) (*ElGamalCiphertext, error) {
+	return k.Encrypt(k.Exp(m))
+}
+
+// MulCipher multiplies two ciphertexts: the result decrypts to the
+// product of the plain messages (or the sum for exponential ElGamal).
+func (k *ElGamalPublicKey) MulCipher(c1, c2 *ElGamalCiphertext) *ElGamalCiphertext {
+	return &ElGamalCiphertext{
+		C1: k.FieldP.Mul(c1.C1, c2.C1),
+		C2: k.FieldP.Mul(c1.C2, c2.C2),
+	}
+}
+
+// Decrypt a ciphertext with private key. Both values of the ciphertext
+// must be elements of the group.
+func (k *ElGamalPrivateKey) Decrypt(c *ElGamalCiphertext) (*math.Int, error) {
+	if !k.validCipher(c) {
+		return nil, ErrElGamalCipher
+	}
+	d := c.C1.ModPow(k.X, k.P)
+	return k.Div(c.C2, d).Mod(k.P), nil
+}
+
+// DecryptExp decrypts an exponential ElGamal ciphertext; the plain
+// message must be in range '[0,max]'.
+func (k *ElGamalPrivateKey) DecryptExp(c *ElGamalCiphertext, max int64) (*math.Int, error) {
+	m, err := k.Decrypt(c)
+	if err != nil {
+		return nil, err
+	}
+	return k.dlog(m, max)
+}
+
+// validCipher checks if both values of a ciphertext are group elements.
+func (grp *ElGamalGroup) validCipher(c *ElGamalCiphertext) bool {
+	return c != nil && c.C1 != nil && c.C2 != nil && grp.Contains(c.C1) && grp.Contains(c.C2)
+}
+
+// compute discrete logarithm of 'v' (brute force for small values)
+func (k *ElGamalPublicKey) dlog(v *math.Int, max int64) (*math.Int, error) {
+	acc := math.ONE
+	for i := int64(0); i <= max; i++ {
+		if acc.Equals(v) {
+			return math.NewInt(i), nil
+		}
+		acc = k.FieldP.Mul(acc, k.G)
+	}
+	return nil, ErrElGamalDLog
+}
+
+//----------------------------------------------------------------------
+// Zero-knowledge proof of correct decryption (Chaum-Pedersen)
+//----------------------------------------------------------------------
+
+// ElGamalProof proves that 'D = c1^x' for the private key 'x' of the
+// public key 'Y = g^x' without revealing 'x' (equality of discrete
+// logarithms). The decrypted message is 'm = c2/D'.
+type ElGamalProof struct {
+	D      *Integer // decryption factor 'c1^x'
+	A1, A2 *Integer // commitments 'g^w' and 'c1^w'
+	Z      *Integer // response 'w + e*x mod q'
+}
+
+// ProveDecryption decrypts a ciphertext and returns the plain message
+// with a proof of correct decryption.
+func (k *ElGamalPrivateKey) ProveDecryption(c *ElGamalCiphertext) (*math.Int, *ElGamalProof, error) {
+	if !k.validCipher(c) {
+		return nil, nil, ErrElGamalCipher
+	}
+	d := c.C1.ModPow(k.X, k.P)
+	w := math.NewIntRndRange(math.ONE, k.Q.Sub(math.ONE))
+	a1 := k.Exp(w)
+	a2 := c.C1.ModPow(w, k.P)
+	e := challenge(k.P, k.G, k.Y, c.C1, c.C2, d, a1, a2).Mod(k.Q)
+	z := w.Add(e.Mul(k.X)).Mod(k.Q)
+	prf := &ElGamalProof{
+		D:  NewInteger(d),
+		A1: NewInteger(a1),
+		A2: NewInteger(a2),
+		Z:  NewInteger(z),
+	}
+	return k.Div(c.C2, d).Mod(k.P), prf, nil
+}
+
+// VerifyDecryption checks the proof and returns the decrypted message.
+// All ciphertext and proof values must be elements of the group (or
+// in range '[0,q)' for the response).
+func (k *ElGamalPublicKey) VerifyDecryption(c *ElGamalCiphertext, prf *ElGamalProof) (*math.Int, error) {
+	if !k.validCipher(c) || prf == nil ||
+		prf.D == nil || prf.A1 == nil || prf.A2 == nil || prf.Z == nil {
+		return nil, ErrElGamalProof
+	}
+	d, a1, a2, z := prf.D.Int(), prf.A1.Int(), prf.A2.Int(), prf.Z.Int()
+	for _, v := range []*math.Int{d, a1, a2} {
+		if !k.Contains(v) {
+			return nil, ErrElGamalProof
+		}
+	}
+	if z.Cmp(k.Q) >= 0 {
+		return nil, ErrElGamalProof
+	}
+	e := challenge(k.P, k.G, k.Y, c.C1, c.C2, d, a1, a2).Mod(k.Q)
+	// check 'g^z = a1 * Y^e'
+	if !k.Exp(z).Equals(k.FieldP.Mul(a1, k.Y.ModPow(e, k.P))) {
+		return nil, ErrElGamalProof
+	}
+	// check 'c1^z = a2 * D^e'
+	if !c.C1.ModPow(z, k.P).Equals(k.FieldP.Mul(a2, d.ModPow(e, k.P))) {
+		return nil, ErrElGamalProof
+	}
+	return k.Div(c.C2, d).Mod(k.P), nil
+}
+
+//----------------------------------------------------------------------
+// Serialization
+//----------------------------------------------------------------------
+
+// elGamalKeyData is the binary representation of ElGamal keys
+type elGamalKeyData struct {
+	P, Q, G, Y *Integer
+	HasPrv     bool
+	X          *Integer `opt:"HasPrv"`
+}
+
+// Bytes returns the binary representation of a public key
+func (k *ElGamalPublicKey) Bytes() ([]byte, error) {
+	return data.Marshal(&elGamalKeyData{
+		P:      NewInteger(k.P),
+		Q:      NewInteger(k.Q),
+		G:      NewInteger(k.G),
+		Y:      NewInteger(k.Y),
+		HasPrv: false,
+	})
+}
+
+// Bytes returns the binary representation of a private key
+func (k *ElGamalPrivateKey) Bytes() ([]byte, error) {
+	return data.Marshal(&elGamalKeyData{
+		P:      NewInteger(k.P),
+		Q:      NewInteger(k.Q),
+		G:      NewInteger(k.G),
+		Y:      NewInteger(k.Y),
+		HasPrv: true,
+		X:      NewInteger(k.X),
+	})
+}
+
+// NewElGamalPublicKeyFromBytes restores a public key from binary data.
+func NewElGamalPublicKeyFromBytes(buf []byte) (*ElGamalPublicKey, error) {
+	d := new(elGamalKeyData)
+	if err := data.Unmarshal(d, buf); err != nil {
+		return nil, err
+	}
+	return d.public(), nil
+}
+
+// NewElGamalPrivateKeyFromBytes restores a private key from binary data.
+func NewElGamalPrivateKeyFromBytes(buf []byte) (*ElGamalPrivateKey, error) {
+	d := new(elGamalKeyData)
+	if err := data.Unmarshal(d, buf); err != nil {
+		return nil, err
+	}
+	if !d.HasPrv {
+		return nil, data.ErrMarshalInvalid
+	}
+	return &ElGamalPrivateKey{
+		ElGamalPublicKey: d.public(),
+		X:                d.X.Int(),
+	}, nil
+}
+
+// get public key from key data
+func (d *elGamalKeyData) public() *ElGamalPublicKey {
+	return &ElGamalPublicKey{
+		ElGamalGroup: &ElGamalGroup{
+			FieldP: FieldP{P: d.P.Int()},
+			Q:      d.Q.Int(),
+			G:      d.G.Int(),
+		},
+		Y: d.Y.Int(),
+	}
+}
@@ -0,0 +1,143 @@
+package crypto
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"testing"
+
+	"github.com/bfix/gospel/math"
+)
+
+func TestElGamal(t *testing.T) {
+	grp := NewElGamalGroup(256)
+	prv := NewElGamalPrivateKey(grp)
+	pub := prv.Public()
+
+	// multiplicative encryption
+	m := grp.Exp(math.NewIntRnd(grp.Q))
+	c, err := pub.Encrypt(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, err := prv.Decrypt(c); err != nil || !d.Equals(m) {
+		t.Fatal("decryption failed")
+	}
+	// additive (exponential) encryption
+	c1, _ := pub.EncryptExp(math.NewInt(17))
+	c2, _ := pub.EncryptExp(math.NewInt(25))
+	sum := pub.MulCipher(c1, c2)
+	v, err := prv.DecryptExp(sum, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Int64() != 42 {
+		t.Fatalf("homomorphic addition failed: %v", v)
+	}
+	// proof of correct decryption
+	d, prf, err := prv.ProveDecryption(sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, err := pub.VerifyDecryption(sum, prf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Equals(d2) {
+		t.Fatal("proof message mismatch")
+	}
+	z := prf.Z
+	prf.Z = NewInteger(z.Int().Add(math.ONE))
+	if _, err = pub.VerifyDecryption(sum, prf); err != ErrElGamalProof {
+		t.Fatal("invalid proof accepted")
+	}
+	// out-of-range and incomplete proofs
+	prf.Z = NewInteger(z.Int().Add(grp.Q))
+	if _, err = pub.VerifyDecryption(sum, prf); err != ErrElGamalProof {
+		t.Fatal("response out of range accepted")
+	}
+	prf.Z = z
+	prf.A1 = NewInteger(prf.A1.Int().Add(grp.P))
+	if _, err = pub.VerifyDecryption(sum, prf); err != ErrElGamalProof {
+		t.Fatal("commitment out of range accepted")
+	}
+	prf.A1 = nil
+	if _, err = pub.VerifyDecryption(sum, prf); err != ErrElGamalProof {
+		t.Fatal("incomplete proof accepted")
+	}
+	if _, err = pub.VerifyDecryption(nil, prf); err != ErrElGamalProof {
+		t.Fatal("missing ciphertext accepted")
+	}
+}
+
+func TestElGamalMalformed(t *testing.T) {
+	grp := NewElGamalGroup(128)
+	prv := NewElGamalPrivateKey(grp)
+	c, err := prv.Public().EncryptExp(math.NewInt(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []*ElGamalCiphertext{
+		nil,
+		{C1: nil, C2: c.C2},
+		{C1: c.C1, C2: nil},
+		{C1: math.ZERO, C2: c.C2},
+		{C1: grp.P, C2: c.C2},
+		{C1: c.C1, C2: grp.P.Add(c.C2)},
+	} {
+		if _, err = prv.Decrypt(bad); err != ErrElGamalCipher {
+			t.Fatalf("malformed ciphertext decrypted: %v", err)
+		}
+		if _, err = prv.DecryptExp(bad, 10); err != ErrElGamalCipher {
+			t.Fatalf("malformed ciphertext decrypted: %v", err)
+		}
+		if _, _, err = prv.ProveDecryption(bad); err != ErrElGamalCipher {
+			t.Fatalf("malformed ciphertext proven: %v", err)
+		}
+	}
+}
+
+func TestElGamalSerialize(t *testing.T) {
+	prv := NewElGamalPrivateKey(NewElGamalGroup(128))
+	buf, err := prv.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prv2, err := NewElGamalPrivateKeyFromBytes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prv.X.Equals(prv2.X) || !prv.P.Equals(prv2.P) {
+		t.Fatal("private key mismatch")
+	}
+	if buf, err = prv.Public().Bytes(); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := NewElGamalPublicKeyFromBytes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Y.Equals(prv.Y) || !pub.G.Equals(prv.G) {
+		t.Fatal("public key mismatch")
+	}
+	if _, err = NewElGamalPrivateKeyFromBytes(buf); err == nil {
+		t.Fatal("public key restored as private key")
+	}
+}
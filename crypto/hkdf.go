@@ -0,0 +1,105 @@
+package crypto
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Error codes
+var (
+	ErrKDFLength = errors.New("invalid key length")
+)
+
+//----------------------------------------------------------------------
+// HKDF (RFC 5869) key derivation with SHA-256 or SHA-512
+//----------------------------------------------------------------------
+
+// HKDFExtract computes a pseudo-random key from input key material and
+// an (optional) salt.
+func HKDFExtract(hsh func() hash.Hash, secret, salt []byte) []byte {
+	return hkdf.Extract(hsh, secret, salt)
+}
+
+// HKDFExpand derives 'length' bytes of output key material from a
+// pseudo-random key and context information.
+func HKDFExpand(hsh func() hash.Hash, prk, info []byte, length int) ([]byte, error) {
+	if length < 1 || length > 255*hsh().Size() {
+		return nil, ErrKDFLength
+	}
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(hsh, prk, info), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HKDF derives 'length' bytes of output key material from input key
+// material, salt and context information (extract and expand).
+func HKDF(hsh func() hash.Hash, secret, salt, info []byte, length int) ([]byte, error) {
+	return HKDFExpand(hsh, HKDFExtract(hsh, secret, salt), info, length)
+}
+
+//----------------------------------------------------------------------
+// Labeled key derivation: keys for different purposes are derived
+// from a common secret with unique context labels (key separation).
+//----------------------------------------------------------------------
+
+// KDF for labeled key derivation
+type KDF struct {
+	hsh func() hash.Hash // hash function
+	prk []byte           // pseudo-random key
+}
+
+// NewKDF returns a key derivation instance (HKDF-SHA256) for a secret
+// and an optional salt.
+func NewKDF(secret, salt []byte) *KDF {
+	return &KDF{
+		hsh: sha256.New,
+		prk: HKDFExtract(sha256.New, secret, salt),
+	}
+}
+
+// NewKDF512 returns a key derivation instance (HKDF-SHA512) for a
+// secret and an optional salt.
+func NewKDF512(secret, salt []byte) *KDF {
+	return &KDF{
+		hsh: sha512.New,
+		prk: HKDFExtract(sha512.New, secret, salt),
+	}
+}
+
+// Derive a key of given length for a context label.
+func (k *KDF) Derive(label string, length int) ([]byte, error) {
+	return HKDFExpand(k.hsh, k.prk, []byte(label), length)
+}
+
+// DeriveKey derives a key of given length from a secret for a context
+// label (HKDF-SHA256 without salt).
+func DeriveKey(secret []byte, label string, length int) ([]byte, error) {
+	return NewKDF(secret, nil).Derive(label, length)
+}
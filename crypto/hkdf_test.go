@@ -0,0 +1,63 @@
+package crypto
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// test case 1 from RFC 5869
+func TestHKDF(t *testing.T) {
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+
+	prk := HKDFExtract(sha256.New, ikm, salt)
+	if hex.EncodeToString(prk) != "077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5" {
+		t.Fatal("PRK mismatch")
+	}
+	okm, err := HKDF(sha256.New, ikm, salt, info, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(okm) != "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865" {
+		t.Fatal("OKM mismatch")
+	}
+	if _, err = HKDFExpand(sha256.New, prk, info, 256*32); err != ErrKDFLength {
+		t.Fatal("invalid length accepted")
+	}
+}
+
+func TestKDFLabels(t *testing.T) {
+	kdf := NewKDF([]byte("secret"), nil)
+	k1, _ := kdf.Derive("label 1", 32)
+	k2, _ := kdf.Derive("label 2", 32)
+	if bytes.Equal(k1, k2) {
+		t.Fatal("labels not separated")
+	}
+	k3, _ := DeriveKey([]byte("secret"), "label 1", 32)
+	if !bytes.Equal(k1, k3) {
+		t.Fatal("derivation not deterministic")
+	}
+}
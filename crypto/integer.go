@@ -0,0 +1,69 @@
+package crypto
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/sha256"
+
+	"github.com/bfix/gospel/math"
+)
+
+//----------------------------------------------------------------------
+// Integer is a serializable representation of an arbitrary-size
+// (unsigned) integer for use in binary data structures handled by
+// the data marshaller.
+//----------------------------------------------------------------------
+
+// Integer in binary format
+type Integer struct {
+	Len  uint16 `order:"big"` // length of integer data
+	Data []byte `size:"Len"`  // integer data (big-endian)
+}
+
+// NewInteger encapsulates an integer value
+func NewInteger(i *math.Int) *Integer {
+	buf := i.Bytes()
+	return &Integer{
+		Len:  uint16(len(buf)),
+		Data: buf,
+	}
+}
+
+// Int returns the integer value
+func (i *Integer) Int() *math.Int {
+	return math.NewIntFromBytes(i.Data)
+}
+
+//----------------------------------------------------------------------
+// Helper functions
+//----------------------------------------------------------------------
+
+// challenge computes a non-interactive challenge (Fiat-Shamir) from a
+// list of values. The challenge is a 256-bit value.
+func challenge(vals ...*math.Int) *math.Int {
+	h := sha256.New()
+	for _, v := range vals {
+		buf := v.Bytes()
+		h.Write([]byte{byte(len(buf) >> 8), byte(len(buf))})
+		h.Write(buf)
+	}
+	return math.NewIntFromBytes(h.Sum(nil))
+}
@@ -0,0 +1,156 @@
+package crypto
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/bfix/gospel/math"
+	"golang.org/x/crypto/chacha20"
+)
+
+//----------------------------------------------------------------------
+// MuHash3072: a homomorphic hash for (multi-)sets as used by Bitcoin
+// Core for UTXO set hashes ('gettxoutsetinfo' with hash_type "muhash").
+// Elements are mapped to numbers in the multiplicative group modulo the
+// prime 2^3072 - 1103717; adding/removing elements multiplies the
+// numerator/denominator of the state. The set hash is independent of
+// the order of operations and can be computed incrementally.
+//----------------------------------------------------------------------
+
+// MuHashSize is the size of a serialized MuHash3072 state
+const MuHashSize = 2 * muNumSize
+
+// size of a number in the group (3072 bits)
+const muNumSize = 384
+
+// Error codes
+var (
+	ErrMuHashSize = errors.New("invalid MuHash state size")
+)
+
+// muPrime is the modulus of the group (2^3072 - 1103717)
+var muPrime = math.ONE.Lsh(3072).Sub(math.NewInt(1103717))
+
+// MuHash3072 is the state of a set hash.
+type MuHash3072 struct {
+	num *math.Int // numerator (product of added elements)
+	den *math.Int // denominator (product of removed elements)
+}
+
+// NewMuHash3072 creates a set hash for the empty set.
+func NewMuHash3072() *MuHash3072 {
+	return &MuHash3072{
+		num: math.ONE,
+		den: math.ONE,
+	}
+}
+
+// Insert an element into the set.
+func (m *MuHash3072) Insert(data []byte) *MuHash3072 {
+	m.num = m.num.Mul(muToNum(data)).Mod(muPrime)
+	return m
+}
+
+// Remove an element from the set.
+func (m *MuHash3072) Remove(data []byte) *MuHash3072 {
+	m.den = m.den.Mul(muToNum(data)).Mod(muPrime)
+	return m
+}
+
+// Combine adds all elements of another set hash (set union).
+func (m *MuHash3072) Combine(o *MuHash3072) *MuHash3072 {
+	m.num = m.num.Mul(o.num).Mod(muPrime)
+	m.den = m.den.Mul(o.den).Mod(muPrime)
+	return m
+}
+
+// Subtract removes all elements of another set hash (set difference).
+func (m *MuHash3072) Subtract(o *MuHash3072) *MuHash3072 {
+	m.num = m.num.Mul(o.den).Mod(muPrime)
+	m.den = m.den.Mul(o.num).Mod(muPrime)
+	return m
+}
+
+// Equals returns true if both states represent the same set.
+func (m *MuHash3072) Equals(o *MuHash3072) bool {
+	return m.num.Mul(o.den).Mod(muPrime).Equals(o.num.Mul(m.den).Mod(muPrime))
+}
+
+// Finalize returns the 32-byte set hash. The state is normalized
+// (denominator set to one), but remains usable.
+func (m *MuHash3072) Finalize() []byte {
+	m.num = m.num.Mul(m.den.ModInverse(muPrime)).Mod(muPrime)
+	m.den = math.ONE
+	h := sha256.Sum256(muBytes(m.num))
+	return h[:]
+}
+
+// Bytes returns the serialized state (numerator and denominator as
+// little-endian numbers).
+func (m *MuHash3072) Bytes() []byte {
+	return append(muBytes(m.num), muBytes(m.den)...)
+}
+
+// NewMuHash3072FromBytes restores a serialized state.
+func NewMuHash3072FromBytes(buf []byte) (*MuHash3072, error) {
+	if len(buf) != MuHashSize {
+		return nil, ErrMuHashSize
+	}
+	return &MuHash3072{
+		num: muFromBytes(buf[:muNumSize]).Mod(muPrime),
+		den: muFromBytes(buf[muNumSize:]).Mod(muPrime),
+	}, nil
+}
+
+//----------------------------------------------------------------------
+// helpers
+//----------------------------------------------------------------------
+
+// muToNum maps data to a group element: the data is hashed and the hash
+// used as key for a ChaCha20 keystream of 3072 bits (little-endian).
+func muToNum(data []byte) *math.Int {
+	key := sha256.Sum256(data)
+	cipher, _ := chacha20.NewUnauthenticatedCipher(key[:], make([]byte, chacha20.NonceSize))
+	buf := make([]byte, muNumSize)
+	cipher.XORKeyStream(buf, buf)
+	return muFromBytes(buf).Mod(muPrime)
+}
+
+// muFromBytes converts a little-endian number.
+func muFromBytes(buf []byte) *math.Int {
+	return math.NewIntFromBytes(reverseBytes(buf))
+}
+
+// muBytes returns a number as little-endian byte array.
+func muBytes(v *math.Int) []byte {
+	return reverseBytes(v.FixedBytes(muNumSize))
+}
+
+// reverseBytes returns a reversed copy of a byte array.
+func reverseBytes(buf []byte) []byte {
+	out := make([]byte, len(buf))
+	for i, b := range buf {
+		out[len(buf)-1-i] = b
+	}
+	return out
+}
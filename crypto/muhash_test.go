@@ -0,0 +1,88 @@
+package crypto
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// element with first byte 'i' (like in Bitcoin Core tests)
+func muElement(i byte) []byte {
+	buf := make([]byte, 32)
+	buf[0] = i
+	return buf
+}
+
+func TestMuHashVector(t *testing.T) {
+	// test vector from Bitcoin Core (hash in reversed byte order)
+	m := NewMuHash3072().Insert(muElement(0)).Insert(muElement(1)).Remove(muElement(2))
+	out := reverseBytes(m.Finalize())
+	if hex.EncodeToString(out) != "10d312b100cbd32ada024a6646e40d3482fcff103668d2625f10002a607d5863" {
+		t.Fatalf("hash mismatch: %x", out)
+	}
+}
+
+func TestMuHashSet(t *testing.T) {
+	// order independence
+	a := NewMuHash3072()
+	b := NewMuHash3072()
+	for i := 0; i < 10; i++ {
+		a.Insert(muElement(byte(i)))
+		b.Insert(muElement(byte(9 - i)))
+	}
+	if !bytes.Equal(a.Finalize(), b.Finalize()) || !a.Equals(b) {
+		t.Fatal("order dependence")
+	}
+	// removal restores previous set
+	h := a.Finalize()
+	a.Insert([]byte("extra")).Remove([]byte("extra"))
+	if !bytes.Equal(a.Finalize(), h) {
+		t.Fatal("remove failed")
+	}
+	// empty set
+	e := NewMuHash3072().Insert([]byte("x")).Remove([]byte("x"))
+	if !bytes.Equal(e.Finalize(), NewMuHash3072().Finalize()) {
+		t.Fatal("empty set mismatch")
+	}
+	// union and difference
+	c := NewMuHash3072().Insert(muElement(20))
+	u := NewMuHash3072().Combine(a).Combine(c)
+	if u.Equals(a) {
+		t.Fatal("union equals subset")
+	}
+	if !u.Subtract(c).Equals(a) {
+		t.Fatal("difference failed")
+	}
+	// serialization (unnormalized state)
+	s := NewMuHash3072().Insert(muElement(1)).Remove(muElement(2))
+	r, err := NewMuHash3072FromBytes(s.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Equals(s) || !bytes.Equal(r.Finalize(), s.Finalize()) {
+		t.Fatal("serialization failed")
+	}
+	if _, err = NewMuHash3072FromBytes(make([]byte, 10)); err != ErrMuHashSize {
+		t.Fatal("invalid size accepted")
+	}
+}
@@ -21,9 +21,17 @@ package crypto
 //----------------------------------------------------------------------
 
 import (
+	"errors"
+
+	"github.com/bfix/gospel/data"
 	"github.com/bfix/gospel/math"
 )
 
+// Error codes
+var (
+	ErrPaillierProof = errors.New("invalid decryption proof")
+)
+
 // PaillierPublicKey data structure
 type PaillierPublicKey struct {
 	N, G *math.Int
@@ -146,3 +154,177 @@ func (p *PaillierPublicKey) Encrypt(m *math.Int) (c *math.Int, err error) {
 	c = c1.Mul(c2).Mod(n2)
 	return c, nil
 }
+
+//----------------------------------------------------------------------
+// Homomorphic operations
+//----------------------------------------------------------------------
+
+// Add two ciphertexts: the result decrypts to the sum of the plain
+// messages (mod n).
+//
+//	E(m1) * E(m2) mod n^2 = E(m1 + m2)
+func (p *PaillierPublicKey) Add(c1, c2 *math.Int) *math.Int {
+	n2 := p.N.Mul(p.N)
+	return c1.Mul(c2).Mod(n2)
+}
+
+// AddPlain adds a plain value to a ciphertext.
+//
+//	E(m1) * g^m2 mod n^2 = E(m1 + m2)
+func (p *PaillierPublicKey) AddPlain(c, m *math.Int) *math.Int {
+	n2 := p.N.Mul(p.N)
+	return c.Mul(p.G.ModPow(m, n2)).Mod(n2)
+}
+
+// MulPlain multiplies a ciphertext with a plain value.
+//
+//	E(m1)^m2 mod n^2 = E(m1 * m2)
+func (p *PaillierPublicKey) MulPlain(c, k *math.Int) *math.Int {
+	n2 := p.N.Mul(p.N)
+	return c.ModPow(k, n2)
+}
+
+//----------------------------------------------------------------------
+// Zero-knowledge proof of correct decryption
+//----------------------------------------------------------------------
+
+// PaillierProof proves that a ciphertext 'c' decrypts to a message 'm'
+// without revealing the private key: The prover shows knowledge of 'r'
+// with 'c * g^-m = r^n mod n^2' (n-th root). Using a random value
+// 's' the prover computes the commitment 'A = s^n mod n^2', derives the
+// challenge 'e = H(n,g,c,m,A)' and the response 'Z = s * r^e mod n'.
+// The verifier checks 'Z^n = A * (c * g^-m)^e mod n^2'.
+type PaillierProof struct {
+	A *Integer // commitment
+	Z *Integer // response
+}
+
+// ProveDecryption decrypts a ciphertext and returns the plain message
+// and a proof of correct decryption.
+func (p *PaillierPrivateKey) ProveDecryption(c *math.Int) (m *math.Int, prf *PaillierProof, err error) {
+	if m, err = p.Decrypt(c); err != nil {
+		return
+	}
+	n := p.N
+	n2 := n.Mul(n)
+
+	// compute 'r' as n-th root of 'c * g^-m' (only possible with
+	// knowledge of the factorization of 'n')
+	gm := p.G.ModPow(m, n2).ModInverse(n2)
+	x := c.Mul(gm).Mod(n2).Mod(n)
+	phi := p.P.Sub(math.ONE).Mul(p.Q.Sub(math.ONE))
+	r := x.ModPow(n.ModInverse(phi), n)
+
+	// compute commitment, challenge and response
+	s := math.NewIntRnd(n)
+	a := s.ModPow(n, n2)
+	e := challenge(n, p.G, c, m, a)
+	z := s.Mul(r.ModPow(e, n)).Mod(n)
+	prf = &PaillierProof{
+		A: NewInteger(a),
+		Z: NewInteger(z),
+	}
+	return
+}
+
+// VerifyDecryption checks the proof that a ciphertext decrypts to given
+// message. The ciphertext and commitment must be units modulo 'n^2',
+// the response a unit modulo 'n' and the message in range '[0,n)'.
+func (p *PaillierPublicKey) VerifyDecryption(c, m *math.Int, prf *PaillierProof) error {
+	if c == nil || m == nil || prf == nil || prf.A == nil || prf.Z == nil {
+		return ErrPaillierProof
+	}
+	n2 := p.N.Mul(p.N)
+	a, z := prf.A.Int(), prf.Z.Int()
+	if !p.unit(c, n2) || !p.unit(a, n2) || !p.unit(z, p.N) ||
+		m.Sign() < 0 || m.Cmp(p.N) >= 0 {
+		return ErrPaillierProof
+	}
+	gm := p.G.ModPow(m, n2).ModInverse(n2)
+	if gm == nil {
+		return ErrPaillierProof
+	}
+	x := c.Mul(gm).Mod(n2)
+	e := challenge(p.N, p.G, c, m, a)
+	lhs := z.ModPow(p.N, n2)
+	rhs := a.Mul(x.ModPow(e, n2)).Mod(n2)
+	if !lhs.Equals(rhs) {
+		return ErrPaillierProof
+	}
+	return nil
+}
+
+// unit checks if 'v' is in range '[1,mod)' and co-prime to 'n'.
+func (p *PaillierPublicKey) unit(v, mod *math.Int) bool {
+	if v.Sign() <= 0 || v.Cmp(mod) >= 0 {
+		return false
+	}
+	return v.GCD(p.N).Equals(math.ONE)
+}
+
+//----------------------------------------------------------------------
+// Serialization
+//----------------------------------------------------------------------
+
+// paillierKeyData is the binary representation of Paillier keys. For
+// public keys only 'N' and 'G' are set.
+type paillierKeyData struct {
+	N, G       *Integer
+	HasPrv     bool
+	L, U, P, Q *Integer `opt:"HasPrv"`
+}
+
+// Bytes returns the binary representation of a public key
+func (p *PaillierPublicKey) Bytes() ([]byte, error) {
+	return data.Marshal(&paillierKeyData{
+		N:      NewInteger(p.N),
+		G:      NewInteger(p.G),
+		HasPrv: false,
+	})
+}
+
+// NewPaillierPublicKeyFromBytes restores a public key from binary data.
+func NewPaillierPublicKeyFromBytes(buf []byte) (*PaillierPublicKey, error) {
+	d := new(paillierKeyData)
+	if err := data.Unmarshal(d, buf); err != nil {
+		return nil, err
+	}
+	return &PaillierPublicKey{
+		N: d.N.Int(),
+		G: d.G.Int(),
+	}, nil
+}
+
+// Bytes returns the binary representation of a private key
+func (p *PaillierPrivateKey) Bytes() ([]byte, error) {
+	return data.Marshal(&paillierKeyData{
+		N:      NewInteger(p.N),
+		G:      NewInteger(p.G),
+		HasPrv: true,
+		L:      NewInteger(p.L),
+		U:      NewInteger(p.U),
+		P:      NewInteger(p.P),
+		Q:      NewInteger(p.Q),
+	})
+}
+
+// NewPaillierPrivateKeyFromBytes restores a private key from binary data.
+func NewPaillierPrivateKeyFromBytes(buf []byte) (*PaillierPrivateKey, error) {
+	d := new(paillierKeyData)
+	if err := data.Unmarshal(d, buf); err != nil {
+		return nil, err
+	}
+	if !d.HasPrv {
+		return nil, data.ErrMarshalInvalid
+	}
+	return &PaillierPrivateKey{
+		PaillierPublicKey: &PaillierPublicKey{
+			N: d.N.Int(),
+			G: d.G.Int(),
+		},
+		L: d.L.Int(),
+		U: d.U.Int(),
+		P: d.P.Int(),
+		Q: d.Q.Int(),
+	}, nil
+}
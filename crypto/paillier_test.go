@@ -49,3 +49,66 @@ func TestPaillier(t *testing.T) {
 		}
 	}
 }
+
+func TestPaillierHomomorphic(t *testing.T) {
+	priv, err := NewPaillierPrivateKey(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := priv.GetPublicKey()
+	c1, _ := pub.Encrypt(math.NewInt(17))
+	c2, _ := pub.Encrypt(math.NewInt(25))
+	c := pub.MulPlain(pub.AddPlain(pub.Add(c1, c2), math.NewInt(3)), math.NewInt(2))
+	m, prf, err := priv.ProveDecryption(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Int64() != 90 {
+		t.Fatalf("homomorphic operations failed: %v", m)
+	}
+	if err = pub.VerifyDecryption(c, m, prf); err != nil {
+		t.Fatal(err)
+	}
+	if err = pub.VerifyDecryption(c, m.Add(math.ONE), prf); err != ErrPaillierProof {
+		t.Fatal("invalid proof accepted")
+	}
+	// degenerate and incomplete proofs
+	zero := &PaillierProof{A: NewInteger(math.ZERO), Z: NewInteger(math.ZERO)}
+	if err = pub.VerifyDecryption(c, m.Add(math.ONE), zero); err != ErrPaillierProof {
+		t.Fatal("zero proof accepted")
+	}
+	if err = pub.VerifyDecryption(c, m, &PaillierProof{A: prf.A}); err != ErrPaillierProof {
+		t.Fatal("incomplete proof accepted")
+	}
+	if err = pub.VerifyDecryption(c, m.Add(pub.N), prf); err != ErrPaillierProof {
+		t.Fatal("message out of range accepted")
+	}
+}
+
+func TestPaillierSerialize(t *testing.T) {
+	priv, err := NewPaillierPrivateKey(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := priv.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv2, err := NewPaillierPrivateKeyFromBytes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !priv.U.Equals(priv2.U) || !priv.N.Equals(priv2.N) {
+		t.Fatal("private key mismatch")
+	}
+	if buf, err = priv.GetPublicKey().Bytes(); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := NewPaillierPublicKeyFromBytes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.G.Equals(priv.G) {
+		t.Fatal("public key mismatch")
+	}
+}
@@ -0,0 +1,48 @@
+package pake
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+/*
+ * --------------------------------------------------------------------
+ * Password-authenticated key exchange (SPAKE2, see RFC 9382) over the
+ * Ed25519 group:
+ *
+ * Two parties (A and B) sharing a (short) passphrase establish a strong
+ * shared session key. An attacker can't test passphrases offline; an
+ * active attacker can only test a single guess per protocol run.
+ *
+ *   A                                      B
+ *   -                                      -
+ *   x random                               y random
+ *   pA = x*G + w*M       --- pA --->
+ *                        <--- pB ---       pB = y*G + w*N
+ *   K = h*x*(pB - w*N)                     K = h*y*(pA - w*M)
+ *                        <-- cA,cB -->     (key confirmation)
+ *
+ * 'w' is derived from the passphrase and the identities of both
+ * parties with a memory-hard function (scrypt); 'M' and 'N' are points
+ * with unknown discrete logarithm (hashed to curve).
+ *
+ * Typical use is the pairing of devices or nodes: the identities are
+ * the (long-term) addresses of the peers, so a successful run also
+ * authenticates the peer addresses.
+ * --------------------------------------------------------------------
+ */
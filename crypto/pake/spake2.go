@@ -0,0 +1,195 @@
+package pake
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+
+	"github.com/bfix/gospel/crypto"
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/math"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Error codes
+var (
+	ErrPakeMessage = errors.New("invalid key exchange message")
+	ErrPakeState   = errors.New("invalid protocol state")
+	ErrPakeConfirm = errors.New("key confirmation failed")
+)
+
+// Roles in the key exchange
+const (
+	RoleA = iota // initiator
+	RoleB        // responder
+)
+
+// scrypt parameters for password hardening
+const (
+	ScryptN = 32768
+	ScryptR = 8
+	ScryptP = 1
+)
+
+var (
+	// curve parameters
+	curve = ed25519.GetCurve()
+
+	// points with unknown discrete logarithm
+	pointM = ed25519.HashToPoint([]byte("gospel/crypto/pake: M"))
+	pointN = ed25519.HashToPoint([]byte("gospel/crypto/pake: N"))
+)
+
+// SPAKE2 key exchange state for one party
+type SPAKE2 struct {
+	role     int       // role of the party (RoleA, RoleB)
+	idA, idB []byte    // identities of parties
+	w        *math.Int // password scalar
+	x        *math.Int // ephemeral secret
+	msg      []byte    // own key exchange message
+	tt       []byte    // protocol transcript
+	ke       []byte    // session key
+	kcA, kcB []byte    // confirmation keys
+	done     bool      // key exchange finished?
+}
+
+// NewSPAKE2 starts a key exchange in the given role for a passphrase
+// and the identities of both parties (A and B, in this order for both
+// roles).
+func NewSPAKE2(role int, passphrase, idA, idB []byte) (*SPAKE2, error) {
+	if role != RoleA && role != RoleB {
+		return nil, ErrPakeState
+	}
+	// derive password scalar
+	salt := appendLen(appendLen([]byte("gospel/crypto/pake"), idA), idB)
+	wb, err := scrypt.Key(passphrase, salt, ScryptN, ScryptR, ScryptP, 64)
+	if err != nil {
+		return nil, err
+	}
+	s := &SPAKE2{
+		role: role,
+		idA:  idA,
+		idB:  idB,
+		w:    math.NewIntFromBytes(wb).Mod(curve.N),
+		x:    math.NewIntRndRange(math.ONE, curve.N),
+	}
+	// compute own message
+	blind := pointM
+	if role == RoleB {
+		blind = pointN
+	}
+	s.msg = curve.MultBase(s.x).Add(blind.Mult(s.w)).Bytes()
+	return s, nil
+}
+
+// Message returns the key exchange message to be sent to the peer.
+func (s *SPAKE2) Message() []byte {
+	return s.msg
+}
+
+// Finish processes the key exchange message of the peer and returns
+// the key confirmation message to be sent to the peer.
+func (s *SPAKE2) Finish(peer []byte) ([]byte, error) {
+	if s.tt != nil {
+		return nil, ErrPakeState
+	}
+	if len(peer) != 32 {
+		return nil, ErrPakeMessage
+	}
+	p, err := ed25519.NewPointFromBytes(peer)
+	if err != nil {
+		return nil, err
+	}
+	if !p.IsOnCurve() || p.IsInf() || !p.Mult(curve.N).IsInf() {
+		return nil, ErrPakeMessage
+	}
+	// remove blinding of peer message and compute shared point
+	blind, pA, pB := pointN, s.msg, peer
+	if s.role == RoleB {
+		blind, pA, pB = pointM, peer, s.msg
+	}
+	nw := curve.N.Sub(s.w)
+	K := p.Add(blind.Mult(nw)).Mult(s.x.Mul(math.EIGHT))
+	if K.IsInf() {
+		return nil, ErrPakeMessage
+	}
+	// compute transcript and derive keys
+	tt := appendLen(nil, s.idA)
+	tt = appendLen(tt, s.idB)
+	tt = appendLen(tt, pA)
+	tt = appendLen(tt, pB)
+	tt = appendLen(tt, K.Bytes())
+	tt = appendLen(tt, s.w.FixedBytes(32))
+	s.tt = tt
+	h := sha512.Sum512(tt)
+	s.ke = h[:32]
+	kc, err := crypto.HKDF(sha256.New, h[32:], nil, []byte("ConfirmationKeys"), 64)
+	if err != nil {
+		return nil, err
+	}
+	s.kcA, s.kcB = kc[:32], kc[32:]
+
+	// compute own confirmation
+	kc = s.kcA
+	if s.role == RoleB {
+		kc = s.kcB
+	}
+	return mac(kc, s.tt), nil
+}
+
+// Verify the key confirmation message of the peer and return the
+// shared session key on success.
+func (s *SPAKE2) Verify(confirm []byte) ([]byte, error) {
+	if s.tt == nil || s.done {
+		return nil, ErrPakeState
+	}
+	kc := s.kcB
+	if s.role == RoleB {
+		kc = s.kcA
+	}
+	if !hmac.Equal(confirm, mac(kc, s.tt)) {
+		return nil, ErrPakeConfirm
+	}
+	s.done = true
+	return s.ke, nil
+}
+
+//----------------------------------------------------------------------
+// Helper functions
+//----------------------------------------------------------------------
+
+// appendLen appends a length-prefixed byte array
+func appendLen(buf, data []byte) []byte {
+	var l [8]byte
+	binary.LittleEndian.PutUint64(l[:], uint64(len(data)))
+	return append(append(buf, l[:]...), data...)
+}
+
+// mac computes a HMAC-SHA256
+func mac(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
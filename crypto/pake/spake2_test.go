@@ -0,0 +1,84 @@
+package pake
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"testing"
+)
+
+func exchange(t *testing.T, pwA, pwB string) ([]byte, []byte, error) {
+	idA, idB := []byte("node A"), []byte("node B")
+	a, err := NewSPAKE2(RoleA, []byte(pwA), idA, idB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewSPAKE2(RoleB, []byte(pwB), idA, idB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cA, err := a.Finish(b.Message())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cB, err := b.Finish(a.Message())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kB, err := b.Verify(cA)
+	if err != nil {
+		return nil, nil, err
+	}
+	kA, err := a.Verify(cB)
+	return kA, kB, err
+}
+
+func TestSPAKE2(t *testing.T) {
+	kA, kB, err := exchange(t, "correct horse", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kA, kB) {
+		t.Fatal("session keys differ")
+	}
+	if _, _, err = exchange(t, "correct horse", "battery staple"); err != ErrPakeConfirm {
+		t.Fatal("wrong passphrase not detected")
+	}
+}
+
+func TestSPAKE2Message(t *testing.T) {
+	a, err := NewSPAKE2(RoleA, []byte("pw"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Verify(nil); err != ErrPakeState {
+		t.Fatal("verify before finish")
+	}
+	if _, err = a.Finish(make([]byte, 31)); err != ErrPakeMessage {
+		t.Fatal("short message accepted")
+	}
+	// identity point
+	inf := make([]byte, 32)
+	inf[0] = 1
+	if _, err = a.Finish(inf); err != ErrPakeMessage {
+		t.Fatal("identity point accepted")
+	}
+}
@@ -0,0 +1,481 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	gerr "github.com/bfix/gospel/errors"
+)
+
+//======================================================================
+// Persistent append-only log (write-ahead log):
+//
+// Records are appended to segment files in a directory; each record is
+// prefixed with its size and CRC32 checksum (both uint32, big-endian).
+// If a segment exceeds its maximum size, a new segment is started.
+// Every segment file starts with a header (magic and the number of the
+// first segment covered by the file): a compacted segment replaces all
+// segments before it. A torn record at the end of the log (from a
+// crash during a write) is discarded when the log is opened.
+//======================================================================
+
+// Error codes
+var (
+	ErrLogClosed     = errors.New("log closed")
+	ErrLogCorrupt    = errors.New("log corrupted")
+	ErrLogRecordSize = errors.New("log record too large")
+)
+
+// Log constants
+const (
+	MaxLogRecord = 1 << 24 // max. size of a log record
+
+	logMagic   = "GLOG"        // segment file magic
+	logHdrSize = 12            // size of segment header
+	logRecHdr  = 8             // size of record header
+	logSuffix  = ".log"        // segment file suffix
+	logTmpFile = "compact.tmp" // temporary file during compaction
+)
+
+// AppendLog is a persistent append-only log of records.
+type AppendLog struct {
+	SyncAlways bool // sync to disk after every append
+
+	mtx     sync.Mutex
+	dir     string   // directory for segment files
+	maxSize int64    // max. size of a segment file
+	segs    []uint64 // list of segments (last is active)
+	file    *os.File // active segment file
+	size    int64    // size of active segment
+}
+
+// OpenAppendLog opens (or creates) a log in the given directory. A new
+// segment is started if the active segment exceeds 'maxSize' bytes.
+func OpenAppendLog(dir string, maxSize int64) (l *AppendLog, err error) {
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return
+	}
+	l = &AppendLog{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+	// remove leftovers from an interrupted compaction
+	if err = os.Remove(filepath.Join(dir, logTmpFile)); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	if err = l.scanSegments(); err != nil {
+		return
+	}
+	// start a new log
+	if len(l.segs) == 0 {
+		l.segs = []uint64{1}
+		l.file, err = l.createSegment(l.segPath(1), 1)
+		l.size = logHdrSize
+		return
+	}
+	// open active segment and discard a torn tail
+	num := l.segs[len(l.segs)-1]
+	if l.file, err = os.OpenFile(l.segPath(num), os.O_RDWR, 0o600); err != nil {
+		return
+	}
+	var valid int64
+	if _, err = readLogHeader(l.file); err != nil {
+		// broken header: segment was never written
+		l.file.Close()
+		l.file, err = l.createSegment(l.segPath(num), num)
+		l.size = logHdrSize
+		return
+	}
+	if valid, _, err = scanLogRecords(l.file, nil); err != nil {
+		return
+	}
+	l.size = logHdrSize + valid
+	if err = l.file.Truncate(l.size); err != nil {
+		return
+	}
+	_, err = l.file.Seek(l.size, io.SeekStart)
+	return
+}
+
+// Append a (marshalled) object to the log.
+func (l *AppendLog) Append(obj interface{}) error {
+	rec, err := Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return l.AppendRaw(rec)
+}
+
+// AppendRaw appends a binary record to the log.
+func (l *AppendLog) AppendRaw(rec []byte) (err error) {
+	if len(rec) > MaxLogRecord {
+		return ErrLogRecordSize
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.file == nil {
+		return ErrLogClosed
+	}
+	// start new segment if required
+	n := int64(logRecHdr + len(rec))
+	if l.maxSize > 0 && l.size > logHdrSize && l.size+n > l.maxSize {
+		if err = l.rotate(); err != nil {
+			return
+		}
+	}
+	// write record
+	buf := make([]byte, n)
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(rec)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(rec))
+	copy(buf[logRecHdr:], rec)
+	if _, err = l.file.Write(buf); err != nil {
+		return
+	}
+	l.size += n
+	if l.SyncAlways {
+		err = l.file.Sync()
+	}
+	return
+}
+
+// Sync the active segment to disk.
+func (l *AppendLog) Sync() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.file == nil {
+		return ErrLogClosed
+	}
+	return l.file.Sync()
+}
+
+// Rotate seals the active segment and starts a new one.
+func (l *AppendLog) Rotate() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.file == nil {
+		return ErrLogClosed
+	}
+	return l.rotate()
+}
+
+// Replay calls 'fn' for all records in the log (in order of appending).
+func (l *AppendLog) Replay(fn func(rec []byte) error) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.file == nil {
+		return ErrLogClosed
+	}
+	for _, num := range l.segs {
+		if err := l.replaySegment(num, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplayObjects unmarshals all records in the log into new objects
+// (created by 'mk') and calls 'fn' for each of them.
+func (l *AppendLog) ReplayObjects(mk func() interface{}, fn func(obj interface{}) error) error {
+	return l.Replay(func(rec []byte) error {
+		obj := mk()
+		if err := Unmarshal(obj, rec); err != nil {
+			return err
+		}
+		return fn(obj)
+	})
+}
+
+// Compact rewrites all sealed segments (all but the active one) into a
+// single segment: 'fn' is called for every record and returns the
+// record to keep (or nil to drop it). Callbacks usually keep track of
+// state (like the latest value for a key) and drop obsolete records.
+func (l *AppendLog) Compact(fn func(rec []byte) ([]byte, error)) (err error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.file == nil {
+		return ErrLogClosed
+	}
+	n := len(l.segs) - 1
+	if n < 1 {
+		return nil
+	}
+	// write compacted records into temporary file
+	tmp := filepath.Join(l.dir, logTmpFile)
+	var f *os.File
+	if f, err = l.createSegment(tmp, l.segs[0]); err != nil {
+		return
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+	hdr := make([]byte, logRecHdr)
+	for _, num := range l.segs[:n] {
+		err = l.replaySegment(num, func(rec []byte) error {
+			out, err := fn(rec)
+			if err != nil || out == nil {
+				return err
+			}
+			binary.BigEndian.PutUint32(hdr[:4], uint32(len(out)))
+			binary.BigEndian.PutUint32(hdr[4:8], crc32.ChecksumIEEE(out))
+			if _, err = f.Write(hdr); err == nil {
+				_, err = f.Write(out)
+			}
+			return err
+		})
+		if err != nil {
+			return
+		}
+	}
+	if err = f.Sync(); err != nil {
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	f = nil
+	// replace last sealed segment (atomic) and remove older segments:
+	// if we crash while removing, the header of the compacted segment
+	// makes sure the remaining old segments are ignored. The directory
+	// is synced so the rename is durable before old segments go away.
+	last := l.segs[n-1]
+	if err = os.Rename(tmp, l.segPath(last)); err != nil {
+		return
+	}
+	if err = syncDir(l.dir); err != nil {
+		return
+	}
+	for _, num := range l.segs[:n-1] {
+		if err = os.Remove(l.segPath(num)); err != nil {
+			return
+		}
+	}
+	l.segs = l.segs[n-1:]
+	return syncDir(l.dir)
+}
+
+// Close the log.
+func (l *AppendLog) Close() (err error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.file == nil {
+		return ErrLogClosed
+	}
+	if err = l.file.Sync(); err == nil {
+		err = l.file.Close()
+	}
+	l.file = nil
+	return
+}
+
+//----------------------------------------------------------------------
+// helper methods and functions
+//----------------------------------------------------------------------
+
+// path of a segment file
+func (l *AppendLog) segPath(num uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%016x%s", num, logSuffix))
+}
+
+// scanSegments collects the segments in the log directory (removing
+// segments that are replaced by a compacted segment).
+func (l *AppendLog) scanSegments() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, logSuffix) {
+			continue
+		}
+		num, err := strconv.ParseUint(strings.TrimSuffix(name, logSuffix), 16, 64)
+		if err != nil {
+			continue
+		}
+		l.segs = append(l.segs, num)
+	}
+	sort.Slice(l.segs, func(i, j int) bool { return l.segs[i] < l.segs[j] })
+
+	// drop segments covered by a compacted segment
+	keep := make([]uint64, 0, len(l.segs))
+	for i := len(l.segs) - 1; i >= 0; i-- {
+		num := l.segs[i]
+		first, err := l.segFirst(num)
+		if err != nil {
+			if i == len(l.segs)-1 {
+				// active segment with torn header
+				keep = append(keep, num)
+				continue
+			}
+			return gerr.New(ErrLogCorrupt, "segment %d", num)
+		}
+		keep = append(keep, num)
+		for i > 0 && l.segs[i-1] >= first {
+			i--
+			if err = os.Remove(l.segPath(l.segs[i])); err != nil {
+				return err
+			}
+		}
+	}
+	// reverse order
+	for i, j := 0, len(keep)-1; i < j; i, j = i+1, j-1 {
+		keep[i], keep[j] = keep[j], keep[i]
+	}
+	l.segs = keep
+	return nil
+}
+
+// segFirst returns the first segment covered by a segment file.
+func (l *AppendLog) segFirst(num uint64) (uint64, error) {
+	f, err := os.Open(l.segPath(num))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return readLogHeader(f)
+}
+
+// createSegment creates a new segment file.
+func (l *AppendLog) createSegment(path string, first uint64) (f *os.File, err error) {
+	if f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600); err != nil {
+		return
+	}
+	hdr := make([]byte, logHdrSize)
+	copy(hdr, logMagic)
+	binary.BigEndian.PutUint64(hdr[4:], first)
+	if _, err = f.Write(hdr); err != nil {
+		f.Close()
+		f = nil
+	}
+	return
+}
+
+// syncDir flushes the directory entries (created, renamed or removed
+// files) of a directory to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// rotate seals the active segment and starts a new one.
+func (l *AppendLog) rotate() (err error) {
+	if err = l.file.Sync(); err != nil {
+		return
+	}
+	if err = l.file.Close(); err != nil {
+		return
+	}
+	num := l.segs[len(l.segs)-1] + 1
+	if l.file, err = l.createSegment(l.segPath(num), num); err != nil {
+		return
+	}
+	l.segs = append(l.segs, num)
+	l.size = logHdrSize
+	return syncDir(l.dir)
+}
+
+// replaySegment calls 'fn' for all records in a segment.
+func (l *AppendLog) replaySegment(num uint64, fn func(rec []byte) error) error {
+	f, err := os.Open(l.segPath(num))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = readLogHeader(f); err != nil {
+		return gerr.New(ErrLogCorrupt, "segment %d", num)
+	}
+	valid, complete, err := scanLogRecords(f, fn)
+	if err != nil {
+		return err
+	}
+	if !complete {
+		return gerr.New(ErrLogCorrupt, "segment %d, offset %d", num, logHdrSize+valid)
+	}
+	return nil
+}
+
+// readLogHeader reads a segment header and returns the first segment
+// covered by the file.
+func readLogHeader(rdr io.Reader) (uint64, error) {
+	hdr := make([]byte, logHdrSize)
+	if _, err := io.ReadFull(rdr, hdr); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(hdr[:4], []byte(logMagic)) {
+		return 0, ErrLogCorrupt
+	}
+	return binary.BigEndian.Uint64(hdr[4:]), nil
+}
+
+// scanLogRecords reads records (calling 'fn' if not nil) until the end of
+// the segment or an invalid record is found. Returns the size of valid
+// records and a flag indicating that all data was valid.
+func scanLogRecords(rdr io.Reader, fn func(rec []byte) error) (valid int64, complete bool, err error) {
+	hdr := make([]byte, logRecHdr)
+	for {
+		var n int
+		if n, err = io.ReadFull(rdr, hdr); err != nil {
+			complete = (n == 0 && err == io.EOF)
+			err = nil
+			return
+		}
+		size := binary.BigEndian.Uint32(hdr[:4])
+		if size > MaxLogRecord {
+			return
+		}
+		rec := make([]byte, size)
+		if _, err = io.ReadFull(rdr, rec); err != nil {
+			err = nil
+			return
+		}
+		if crc32.ChecksumIEEE(rec) != binary.BigEndian.Uint32(hdr[4:]) {
+			return
+		}
+		if fn != nil {
+			if err = fn(rec); err != nil {
+				return
+			}
+		}
+		valid += int64(logRecHdr + size)
+	}
+}
@@ -0,0 +1,179 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type LogEntry struct {
+	Key   uint16
+	Value uint32
+}
+
+func TestAppendLog(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenAppendLog(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err = l.Append(&LogEntry{Key: uint16(i % 4), Value: uint32(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(l.segs) < 2 {
+		t.Fatal("no segment rotation")
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// simulate torn write at end of log
+	last := l.segPath(l.segs[len(l.segs)-1])
+	f, err := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte{0, 0, 0, 6, 1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// reopen and replay
+	if l, err = OpenAppendLog(dir, 64); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Append(&LogEntry{Key: 0, Value: 20}); err != nil {
+		t.Fatal(err)
+	}
+	replay := func(l *AppendLog) (list []*LogEntry) {
+		err := l.ReplayObjects(
+			func() interface{} { return new(LogEntry) },
+			func(obj interface{}) error {
+				list = append(list, obj.(*LogEntry))
+				return nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	list := replay(l)
+	if len(list) != 21 {
+		t.Fatalf("replayed %d records", len(list))
+	}
+	for i, e := range list {
+		if e.Value != uint32(i) {
+			t.Fatalf("record %d: value %d", i, e.Value)
+		}
+	}
+	// compact sealed segments (keep latest value per key)
+	old := l.segPath(l.segs[0])
+	oldData, err := os.ReadFile(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := make(map[uint16]uint32)
+	if err = l.ReplayObjects(
+		func() interface{} { return new(LogEntry) },
+		func(obj interface{}) error {
+			e := obj.(*LogEntry)
+			latest[e.Key] = e.Value
+			return nil
+		}); err != nil {
+		t.Fatal(err)
+	}
+	err = l.Compact(func(rec []byte) ([]byte, error) {
+		e := new(LogEntry)
+		if err := Unmarshal(e, rec); err != nil {
+			return nil, err
+		}
+		if latest[e.Key] != e.Value {
+			return nil, nil
+		}
+		return rec, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.segs) != 2 {
+		t.Fatalf("%d segments after compaction", len(l.segs))
+	}
+	compacted := replay(l)
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// simulate crash during compaction (old segment not removed)
+	if err = os.WriteFile(old, oldData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = OpenAppendLog(dir, 64); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	list = replay(l)
+	if len(list) != len(compacted) || len(list) > 8 {
+		t.Fatalf("replayed %d records after compaction", len(list))
+	}
+	for _, e := range list {
+		if latest[e.Key] != e.Value {
+			t.Fatalf("obsolete record %v", e)
+		}
+	}
+	if _, err = os.Stat(old); !os.IsNotExist(err) {
+		t.Fatal("old segment not removed")
+	}
+}
+
+func TestAppendLogCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenAppendLog(dir, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		if err = l.AppendRaw([]byte("record data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// corrupt a sealed segment
+	path := filepath.Join(dir, "0000000000000001.log")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Replay(func([]byte) error { return nil }); !errors.Is(err, ErrLogCorrupt) {
+		t.Fatalf("corruption not detected: %v", err)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.AppendRaw([]byte("x")); !errors.Is(err, ErrLogClosed) {
+		t.Fatal("append to closed log")
+	}
+}
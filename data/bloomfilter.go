@@ -24,10 +24,55 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
+	"hash/crc64"
+	"hash/fnv"
 	"math"
 	"math/big"
+	"math/bits"
+	"reflect"
 )
 
+//----------------------------------------------------------------------
+// Hash strategies
+//----------------------------------------------------------------------
+
+// BloomHash computes two (independent) 64-bit hash values for an entry.
+// Filters using a BloomHash derive the indices by enhanced double hashing
+// (Dillinger-Manolios): idx_i = (h1 + i*h2 + (i^3-i)/6) mod NumBits.
+type BloomHash func(entry []byte) (h1, h2 uint64)
+
+// BloomHashFNV uses the two halves of FNV-1a (128 bit) as hash values.
+func BloomHashFNV(entry []byte) (h1, h2 uint64) {
+	f := fnv.New128a()
+	f.Write(entry)
+	var buf [16]byte
+	h := f.Sum(buf[:0])
+	return binary.BigEndian.Uint64(h[:8]), binary.BigEndian.Uint64(h[8:])
+}
+
+// CRC64 tables for BloomHashCRC64
+var (
+	crcISO  = crc64.MakeTable(crc64.ISO)
+	crcECMA = crc64.MakeTable(crc64.ECMA)
+)
+
+// BloomHashCRC64 uses CRC-64 (ISO and ECMA polynomials) as hash functions.
+// CRCs are linear, so both checksums are passed through a non-linear
+// finalizer to decorrelate the hash values.
+func BloomHashCRC64(entry []byte) (h1, h2 uint64) {
+	return mix64(crc64.Checksum(entry, crcISO)), mix64(crc64.Checksum(entry, crcECMA))
+}
+
+// mix64 is the 64-bit finalizer of MurmurHash3.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
 // BloomFilterBase for custom bloom filter implementations
 // (e.g. simple, salted, counting, ...)
 type BloomFilterBase struct {
@@ -35,10 +80,32 @@ type BloomFilterBase struct {
 	NumIdx     uint8  `size:"big" json:"numIdx"`  // number of indices
 	NumIdxBits uint8  `json:"numIdxBits"`         // number of bits per index
 	NumHash    uint8  `json:"numHash"`            // number of SHA256 hashes needed
+
+	hash BloomHash // double hashing (nil for chained SHA256)
+}
+
+// SetHash switches the filter to double hashing with the given hash
+// function. A nil value restores the default (chained SHA256) scheme.
+// The hash strategy is not part of the binary representation, so it
+// has to be set again after unmarshalling; only filters using the
+// default scheme are compatible with other implementations.
+func (bf *BloomFilterBase) SetHash(h BloomHash) {
+	bf.hash = h
+}
+
+// sameHash returns true if both filters use the same hash strategy.
+func (bf *BloomFilterBase) sameHash(bf2 *BloomFilterBase) bool {
+	if bf.hash == nil || bf2.hash == nil {
+		return bf.hash == nil && bf2.hash == nil
+	}
+	return reflect.ValueOf(bf.hash).Pointer() == reflect.ValueOf(bf2.hash).Pointer()
 }
 
 // Helper method to extract the list of indices for an entry.
 func (bf *BloomFilterBase) indexList(entry []byte) []int {
+	if bf.hash != nil {
+		return bf.indexListDouble(entry)
+	}
 	totalIdx := make([]byte, 0)
 	hasher := sha256.New()
 	var i uint8
@@ -57,6 +124,36 @@ func (bf *BloomFilterBase) indexList(entry []byte) []int {
 	return list
 }
 
+// Helper method to extract the list of indices for an entry using
+// enhanced double hashing (the increment changes with every index, so
+// indices don't repeat if h2 is a multiple of a factor of NumBits).
+func (bf *BloomFilterBase) indexListDouble(entry []byte) []int {
+	h1, h2 := bf.hash(entry)
+	n := uint64(bf.NumBits)
+	a, b := h1%n, h2%n
+	list := make([]int, bf.NumIdx)
+	for i := range list {
+		list[i] = int(a)
+		a = (a + b) % n
+		b = (b + uint64(i) + 1) % n
+	}
+	return list
+}
+
+// Helper method to estimate filter statistics from the number of set
+// bits: fill ratio, number of entries and false-positive rate.
+func (bf *BloomFilterBase) estimate(set int) (fill, count, fpRate float64) {
+	m := float64(bf.NumBits)
+	k := float64(bf.NumIdx)
+	fill = float64(set) / m
+	if fill >= 1 {
+		return 1, math.Inf(1), 1
+	}
+	count = -m / k * math.Log1p(-fill)
+	fpRate = math.Pow(fill, k)
+	return
+}
+
 //----------------------------------------------------------------------
 // Generic bloomfilter
 //----------------------------------------------------------------------
@@ -115,7 +212,8 @@ func (bf *BloomFilter) SameKind(bf2 *BloomFilter) bool {
 	return bf.NumBits == bf2.NumBits &&
 		bf.NumHash == bf2.NumHash &&
 		bf.NumIdx == bf2.NumIdx &&
-		bf.NumIdxBits == bf2.NumIdxBits
+		bf.NumIdxBits == bf2.NumIdxBits &&
+		bf.sameHash(&bf2.BloomFilterBase)
 }
 
 // Add an entry to the BloomFilter.
@@ -137,6 +235,7 @@ func (bf *BloomFilter) Combine(bf2 *BloomFilter) *BloomFilter {
 			NumIdx:     bf.NumIdx,
 			NumIdxBits: bf.NumIdxBits,
 			NumHash:    bf.NumHash,
+			hash:       bf.hash,
 		},
 		Bits: make([]byte, len(bf.Bits)),
 	}
@@ -160,6 +259,41 @@ func (bf *BloomFilter) Contains(entry []byte) bool {
 	return true
 }
 
+// Reset removes all entries from the BloomFilter.
+func (bf *BloomFilter) Reset() {
+	for i := range bf.Bits {
+		bf.Bits[i] = 0
+	}
+}
+
+// numSet returns the number of set bits in the filter.
+func (bf *BloomFilter) numSet() (n int) {
+	for _, b := range bf.Bits {
+		n += bits.OnesCount8(b)
+	}
+	return
+}
+
+// FillRatio returns the ratio of set bits in the filter.
+func (bf *BloomFilter) FillRatio() float64 {
+	fill, _, _ := bf.estimate(bf.numSet())
+	return fill
+}
+
+// EstimatedCount returns the estimated number of (distinct) entries
+// in the filter.
+func (bf *BloomFilter) EstimatedCount() float64 {
+	_, count, _ := bf.estimate(bf.numSet())
+	return count
+}
+
+// FalsePositiveRate returns the current (estimated) false-positive
+// rate of the filter.
+func (bf *BloomFilter) FalsePositiveRate() float64 {
+	_, _, fpRate := bf.estimate(bf.numSet())
+	return fpRate
+}
+
 // Helper method to resolve an index into byte/bit positions in the data
 // of the BloomFilter.
 func (bf *BloomFilter) resolve(idx int) (int, byte) {
@@ -304,7 +438,8 @@ func (bf *CountingBloomFilter) SameKind(bf2 *CountingBloomFilter) bool {
 	return bf.NumBits == bf2.NumBits &&
 		bf.NumHash == bf2.NumHash &&
 		bf.NumIdx == bf2.NumIdx &&
-		bf.NumIdxBits == bf2.NumIdxBits
+		bf.NumIdxBits == bf2.NumIdxBits &&
+		bf.sameHash(&bf2.BloomFilterBase)
 }
 
 // Combine merges two BloomFilters (of same kind) into a new one.
@@ -318,6 +453,7 @@ func (bf *CountingBloomFilter) Combine(bf2 *CountingBloomFilter) *CountingBloomF
 			NumIdx:     bf.NumIdx,
 			NumIdxBits: bf.NumIdxBits,
 			NumHash:    bf.NumHash,
+			hash:       bf.hash,
 		},
 		Counts: make([]uint32, bf.NumBits),
 	}
@@ -340,6 +476,43 @@ func (bf *CountingBloomFilter) Contains(entry []byte) bool {
 	return true
 }
 
+// Reset removes all entries from the BloomFilter.
+func (bf *CountingBloomFilter) Reset() {
+	for i := range bf.Counts {
+		bf.Counts[i] = 0
+	}
+}
+
+// numSet returns the number of non-zero counters in the filter.
+func (bf *CountingBloomFilter) numSet() (n int) {
+	for _, c := range bf.Counts {
+		if c != 0 {
+			n++
+		}
+	}
+	return
+}
+
+// FillRatio returns the ratio of non-zero counters in the filter.
+func (bf *CountingBloomFilter) FillRatio() float64 {
+	fill, _, _ := bf.estimate(bf.numSet())
+	return fill
+}
+
+// EstimatedCount returns the estimated number of (distinct) entries
+// in the filter.
+func (bf *CountingBloomFilter) EstimatedCount() float64 {
+	_, count, _ := bf.estimate(bf.numSet())
+	return count
+}
+
+// FalsePositiveRate returns the current (estimated) false-positive
+// rate of the filter.
+func (bf *CountingBloomFilter) FalsePositiveRate() float64 {
+	_, _, fpRate := bf.estimate(bf.numSet())
+	return fpRate
+}
+
 // Remove an entry from the bloomfilter
 func (bf *CountingBloomFilter) Remove(entry []byte) bool {
 	// make sure the entry is stored in the filter
@@ -354,3 +527,74 @@ func (bf *CountingBloomFilter) Remove(entry []byte) bool {
 	}
 	return true
 }
+
+//----------------------------------------------------------------------
+// Rotating bloomfilter (sliding window)
+//----------------------------------------------------------------------
+
+// RotatingBloomFilter is a pair of bloom filters implementing a sliding
+// window over a stream of entries: new entries are added to the current
+// filter; lookups check both the current and the previous filter. The
+// filters are rotated when the number of additions to the current filter
+// reaches 'numExpected': the previous filter is dropped and the current
+// filter becomes the previous one. Every call to 'Add()' counts, even if
+// the entry is already contained in the filter. An entry is "remembered"
+// for at least 'numExpected' subsequent additions.
+type RotatingBloomFilter struct {
+	numExpected int
+	added       int // number of entries added to current filter
+	curr, prev  *BloomFilter
+}
+
+// NewRotatingBloomFilter creates a new rotating filter for the given
+// window size and "false-positive" rate.
+func NewRotatingBloomFilter(numExpected int, falsePositiveRate float64) *RotatingBloomFilter {
+	return &RotatingBloomFilter{
+		numExpected: numExpected,
+		curr:        NewBloomFilter(numExpected, falsePositiveRate),
+		prev:        NewBloomFilter(numExpected, falsePositiveRate),
+	}
+}
+
+// SetHash sets the hash strategy for both filters (see BloomFilterBase).
+func (bf *RotatingBloomFilter) SetHash(h BloomHash) {
+	bf.curr.SetHash(h)
+	bf.prev.SetHash(h)
+}
+
+// Add an entry to the current filter (rotating filters if required).
+// Filters are rotated after 'numExpected' additions, so the most recent
+// 'numExpected' entries are always remembered.
+func (bf *RotatingBloomFilter) Add(entry []byte) {
+	if bf.added >= bf.numExpected {
+		bf.Rotate()
+	}
+	bf.curr.Add(entry)
+	bf.added++
+}
+
+// Contains returns true if the entry is in the current or previous filter.
+func (bf *RotatingBloomFilter) Contains(entry []byte) bool {
+	return bf.curr.Contains(entry) || bf.prev.Contains(entry)
+}
+
+// Rotate drops the previous filter and starts a new current filter.
+func (bf *RotatingBloomFilter) Rotate() {
+	bf.prev, bf.curr = bf.curr, bf.prev
+	bf.curr.Reset()
+	bf.added = 0
+}
+
+// Reset removes all entries from both filters.
+func (bf *RotatingBloomFilter) Reset() {
+	bf.curr.Reset()
+	bf.prev.Reset()
+	bf.added = 0
+}
+
+// FalsePositiveRate returns the current (estimated) false-positive
+// rate of the rotating filter.
+func (bf *RotatingBloomFilter) FalsePositiveRate() float64 {
+	p1, p2 := bf.curr.FalsePositiveRate(), bf.prev.FalsePositiveRate()
+	return 1 - (1-p1)*(1-p2)
+}
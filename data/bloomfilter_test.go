@@ -23,6 +23,8 @@ package data
 import (
 	"bytes"
 	"crypto/rand"
+	"fmt"
+	"math"
 	mrand "math/rand"
 	"sort"
 	"testing"
@@ -240,3 +242,144 @@ func TestCountingBloomfilter(t *testing.T) {
 		t.Fatal("bf not empty")
 	}
 }
+
+func TestBloomfilterDoubleHash(t *testing.T) {
+	n := 500
+	fpRate := 0.001
+
+	for _, h := range []BloomHash{BloomHashFNV, BloomHashCRC64} {
+		bf := NewBloomFilter(n, fpRate)
+		bf.SetHash(h)
+		positives := make(EntryList, n)
+		for i := range positives {
+			positives[i] = []byte(fmt.Sprintf("entry #%d", i))
+			bf.Add(positives[i])
+		}
+		for _, e := range positives {
+			if !bf.Contains(e) {
+				t.Fatal("false-negative")
+			}
+		}
+		count := 0
+		for i := 0; i < n; i++ {
+			if bf.Contains([]byte(fmt.Sprintf("other #%d", i))) {
+				count++
+			}
+		}
+		if fpReal := float64(count) / float64(n); fpReal > 10*fpRate {
+			t.Fatalf("false-positive rate %f > %f", fpReal, fpRate)
+		}
+		// hash strategies must match for combining filters
+		bf2 := NewBloomFilter(n, fpRate)
+		if bf.SameKind(bf2) || bf.Combine(bf2) != nil {
+			t.Fatal("different hash strategies combined")
+		}
+		bf2.SetHash(h)
+		if !bf.SameKind(bf2) {
+			t.Fatal("same hash strategy not recognized")
+		}
+		if bfc := bf.Combine(bf2); bfc == nil || !bfc.Contains(positives[0]) {
+			t.Fatal("combine failed")
+		}
+	}
+}
+
+func TestBloomfilterFalsePositiveRate(t *testing.T) {
+	n := 10000
+	fpRate := 0.01
+	for i, h := range []BloomHash{nil, BloomHashFNV, BloomHashCRC64} {
+		bf := NewBloomFilter(n, fpRate)
+		bf.SetHash(h)
+		for j := 0; j < n; j++ {
+			bf.Add([]byte(fmt.Sprintf("entry #%d", j)))
+		}
+		count, m := 0, 10*n
+		for j := 0; j < m; j++ {
+			if bf.Contains([]byte(fmt.Sprintf("other #%d", j))) {
+				count++
+			}
+		}
+		if fpReal := float64(count) / float64(m); fpReal > 1.5*fpRate {
+			t.Fatalf("strategy #%d: false-positive rate %f > %f", i, fpReal, 1.5*fpRate)
+		}
+	}
+}
+
+func benchmarkBloomfilter(b *testing.B, h BloomHash) {
+	bf := NewBloomFilter(10000, 0.0001)
+	bf.SetHash(h)
+	entry := make([]byte, 32)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entry[0], entry[1], entry[2] = byte(i), byte(i>>8), byte(i>>16)
+		bf.Add(entry)
+		bf.Contains(entry)
+	}
+}
+
+func BenchmarkBloomfilterSHA256(b *testing.B) { benchmarkBloomfilter(b, nil) }
+func BenchmarkBloomfilterFNV(b *testing.B)    { benchmarkBloomfilter(b, BloomHashFNV) }
+func BenchmarkBloomfilterCRC64(b *testing.B)  { benchmarkBloomfilter(b, BloomHashCRC64) }
+
+func TestBloomfilterEstimate(t *testing.T) {
+	n := 1000
+	bf := NewBloomFilter(n, 0.001)
+	cbf := NewCountingBloomFilter(n, 0.001)
+	if bf.FillRatio() != 0 || bf.EstimatedCount() != 0 || bf.FalsePositiveRate() != 0 {
+		t.Fatal("empty filter not empty")
+	}
+	for i := 0; i < n; i++ {
+		e := []byte(fmt.Sprintf("entry #%d", i))
+		bf.Add(e)
+		cbf.Add(e)
+	}
+	for _, f := range []interface {
+		FillRatio() float64
+		EstimatedCount() float64
+		FalsePositiveRate() float64
+	}{bf, cbf} {
+		if cnt := f.EstimatedCount(); math.Abs(cnt-float64(n)) > 0.05*float64(n) {
+			t.Fatalf("estimated count %f (expected %d)", cnt, n)
+		}
+		if fill := f.FillRatio(); fill < 0.4 || fill > 0.6 {
+			t.Fatalf("fill ratio %f", fill)
+		}
+		if fp := f.FalsePositiveRate(); fp > 0.002 {
+			t.Fatalf("false-positive rate %f", fp)
+		}
+	}
+	bf.Reset()
+	cbf.Reset()
+	if bf.EstimatedCount() != 0 || cbf.EstimatedCount() != 0 {
+		t.Fatal("reset failed")
+	}
+}
+
+func TestRotatingBloomfilter(t *testing.T) {
+	n := 100
+	bf := NewRotatingBloomFilter(n, 0.001)
+	bf.SetHash(BloomHashFNV)
+	entry := func(i int) []byte { return []byte(fmt.Sprintf("entry #%d", i)) }
+	for i := 0; i < 5*n; i++ {
+		bf.Add(entry(i))
+		// the last 'n' entries must be remembered
+		for j := i; j >= 0 && j > i-n; j-- {
+			if !bf.Contains(entry(j)) {
+				t.Fatalf("entry %d forgotten after %d", j, i)
+			}
+		}
+	}
+	// old entries are dropped
+	count := 0
+	for i := 0; i < n; i++ {
+		if bf.Contains(entry(i)) {
+			count++
+		}
+	}
+	if count > n/10 {
+		t.Fatalf("%d old entries remembered", count)
+	}
+	if fp := bf.FalsePositiveRate(); fp > 0.01 {
+		t.Fatalf("false-positive rate %f", fp)
+	}
+}
@@ -0,0 +1,575 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+
+	gerr "github.com/bfix/gospel/errors"
+)
+
+//######################################################################
+//
+// CBOR (RFC 8949) serialization of Golang objects. The supported types
+// are the same as for Marshal/Unmarshal:
+//
+//    int{8,16,32,64}       -- major type 0 (unsigned) or 1 (negative)
+//    uint{8,16,32,64}      -- major type 0
+//    []uint8,[]byte        -- major type 2 (byte string)
+//    string                -- major type 3 (text string)
+//    bool                  -- simple values 'false' and 'true'
+//    *struct{}, struct{}   -- major type 5 (map with field names as keys)
+//    []*T, []T             -- major type 4 (array)
+//
+// Nil pointers are encoded as 'null'. The encoding is deterministic
+// (RFC 8949, section 4.2.1): shortest argument encoding, definite
+// lengths and map keys sorted by their encoded bytes.
+//
+// The "size", "opt" and "init" field tags are evaluated like in the
+// binary format: optional fields that are not used are omitted from
+// the map, sizes are checked against the tag. The "order" tag has no
+// effect (CBOR integers are always big-endian). The map key for a
+// field is its name or the value of a "cbor" tag; fields tagged with
+// 'cbor:"-"' are skipped. Unknown map keys are ignored on decoding.
+//
+//######################################################################
+
+// Errors
+var (
+	ErrCBORType       = errors.New("unexpected CBOR type")
+	ErrCBORIndefinite = errors.New("indefinite length not supported")
+	ErrCBOROverflow   = errors.New("CBOR integer out of range")
+	ErrCBORMissing    = errors.New("missing CBOR map entry")
+	ErrCBORTrailing   = errors.New("trailing data after CBOR item")
+)
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// CBOR simple values
+const (
+	cborFalse = 0xf4
+	cborTrue  = 0xf5
+	cborNull  = 0xf6
+)
+
+//======================================================================
+// Marshal Golang objects to CBOR.
+//======================================================================
+
+// MarshalCBOR creates the (deterministic) CBOR encoding of an object.
+func MarshalCBOR(obj interface{}) ([]byte, error) {
+	return MarshalCBORLimited(obj, DefaultLimits)
+}
+
+// MarshalCBORLimited creates the CBOR encoding of an object with custom
+// resource limits.
+func MarshalCBORLimited(obj interface{}, limits Limits) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	inst := reflect.ValueOf(obj)
+	ctx := _NewMarshalContext(buf, inst)
+	ctx.limits = limits
+	if err := cborEncode(ctx, inst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encode a single value
+func cborEncode(ctx *_MarshalContext, v reflect.Value) (err error) {
+	if err = ctx.enter(v); err != nil {
+		return ctx.fail(err)
+	}
+	defer ctx.leave(v)
+
+	wrt := ctx.wrt.(*bytes.Buffer)
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			wrt.WriteByte(cborTrue)
+		} else {
+			wrt.WriteByte(cborFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := v.Int(); i < 0 {
+			cborHead(wrt, cborNegInt, uint64(-1-i))
+		} else {
+			cborHead(wrt, cborUint, uint64(i))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		cborHead(wrt, cborUint, v.Uint())
+	case reflect.String:
+		cborHead(wrt, cborText, uint64(v.Len()))
+		wrt.WriteString(v.String())
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			wrt.WriteByte(cborNull)
+			break
+		}
+		e := v.Elem()
+		ctx.use(e)
+		err = cborEncode(ctx, e)
+	case reflect.Struct:
+		err = cborEncodeStruct(ctx, v)
+	case reflect.Slice:
+		var count int
+		if count, err = ctx.parseSize(v.Len()); err != nil {
+			return ctx.fail(err)
+		}
+		if count < 0 {
+			count = v.Len()
+		}
+		if count > v.Len() {
+			return ctx.fail(ErrMarshalSizeMismatch)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			cborHead(wrt, cborBytes, uint64(count))
+			wrt.Write(v.Bytes()[:count])
+			break
+		}
+		cborHead(wrt, cborArray, uint64(count))
+		for i := 0; i < count; i++ {
+			if err = cborEncode(ctx, v.Index(i)); err != nil {
+				return
+			}
+		}
+	default:
+		err = gerr.New(ErrMarshalUnknownType,
+			"marshal: field '%s', type '%v', kind '%v'",
+			ctx.string(), v.Type(), v.Kind())
+	}
+	return
+}
+
+// encode a struct as map
+func cborEncodeStruct(ctx *_MarshalContext, x reflect.Value) error {
+	type entry struct {
+		key, val []byte
+	}
+	var list []*entry
+	wrt := ctx.wrt
+	defer func() { ctx.wrt = wrt }()
+	for i := 0; i < x.NumField(); i++ {
+		f := x.Field(i)
+		// do not serialize unexported fields
+		if !f.CanSet() {
+			continue
+		}
+		ft := x.Type().Field(i)
+		name := cborKey(ft)
+		if len(name) == 0 {
+			continue
+		}
+		ctx.push(ft.Name, f, ft.Tag)
+		used, err := ctx.isUsed()
+		if err != nil {
+			return ctx.fail(err)
+		}
+		if used {
+			key := new(bytes.Buffer)
+			cborHead(key, cborText, uint64(len(name)))
+			key.WriteString(name)
+			val := new(bytes.Buffer)
+			ctx.wrt = val
+			if err = cborEncode(ctx, f); err != nil {
+				return err
+			}
+			list = append(list, &entry{key.Bytes(), val.Bytes()})
+		}
+		ctx.pop()
+	}
+	// canonical order of map entries
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].key, list[j].key) < 0
+	})
+	buf := wrt.(*bytes.Buffer)
+	cborHead(buf, cborMap, uint64(len(list)))
+	for _, e := range list {
+		buf.Write(e.key)
+		buf.Write(e.val)
+	}
+	return nil
+}
+
+// cborKey returns the map key for a struct field (empty if skipped)
+func cborKey(ft reflect.StructField) string {
+	switch tag := ft.Tag.Get("cbor"); tag {
+	case "-":
+		return ""
+	case "":
+		return ft.Name
+	default:
+		return tag
+	}
+}
+
+// cborHead writes the initial byte(s) of a data item (shortest form).
+func cborHead(buf *bytes.Buffer, major byte, arg uint64) {
+	mt := major << 5
+	switch {
+	case arg < 24:
+		buf.WriteByte(mt | byte(arg))
+	case arg <= math.MaxUint8:
+		buf.Write([]byte{mt | 24, byte(arg)})
+	case arg <= math.MaxUint16:
+		buf.WriteByte(mt | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(arg)))
+	case arg <= math.MaxUint32:
+		buf.WriteByte(mt | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(arg)))
+	default:
+		buf.WriteByte(mt | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, arg))
+	}
+}
+
+//======================================================================
+// Unmarshal Golang objects from CBOR.
+//======================================================================
+
+// UnmarshalCBOR decodes CBOR data into the object pointed to by 'obj'.
+func UnmarshalCBOR(obj interface{}, data []byte) error {
+	return UnmarshalCBORLimited(obj, data, DefaultLimits)
+}
+
+// UnmarshalCBORLimited decodes CBOR data with custom resource limits.
+func UnmarshalCBORLimited(obj interface{}, data []byte, limits Limits) error {
+	rdr := bytes.NewReader(data)
+	inst := reflect.ValueOf(obj)
+	ctx := _NewUnmarshalContext(rdr, len(data), inst)
+	ctx.limits = limits
+	if err := cborDecode(ctx, inst); err != nil {
+		return err
+	}
+	if rdr.Len() > 0 {
+		return ctx.fail(ErrCBORTrailing)
+	}
+	return nil
+}
+
+// decode a single value
+func cborDecode(ctx *_UnmarshalContext, v reflect.Value) (err error) {
+	if err = ctx.enter(v); err != nil {
+		return ctx.fail(err)
+	}
+	defer ctx.leave(v)
+
+	rdr := ctx.rdr.(*bytes.Reader)
+	// handle pointers (and 'null' values)
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		var b byte
+		if b, err = rdr.ReadByte(); err != nil {
+			return ctx.fail(err)
+		}
+		if b == cborNull {
+			if !v.CanSet() {
+				return ctx.fail(ErrMarshalInvalid)
+			}
+			v.SetZero()
+			return nil
+		}
+		_ = rdr.UnreadByte()
+		e := v.Elem()
+		if !e.IsValid() {
+			if v.Kind() == reflect.Interface || !v.CanSet() {
+				return ctx.fail(ErrMarshalInvalid)
+			}
+			ep := reflect.New(v.Type().Elem())
+			e = ep.Elem()
+			v.Set(ep)
+		} else if e.Kind() == reflect.Ptr {
+			e = e.Elem()
+		}
+		ctx.use(e)
+		return cborDecode(ctx, e)
+	}
+	major, arg, err := cborReadHead(rdr)
+	if err != nil {
+		return ctx.fail(err)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if major != cborSimple || (arg != cborFalse&0x1f && arg != cborTrue&0x1f) {
+			return ctx.fail(ErrCBORType)
+		}
+		v.SetBool(arg == cborTrue&0x1f)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch {
+		case arg > math.MaxInt64:
+			return ctx.fail(ErrCBOROverflow)
+		case major == cborUint:
+			i = int64(arg)
+		case major == cborNegInt:
+			i = -1 - int64(arg)
+		default:
+			return ctx.fail(ErrCBORType)
+		}
+		if v.OverflowInt(i) {
+			return ctx.fail(ErrCBOROverflow)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if major != cborUint {
+			return ctx.fail(ErrCBORType)
+		}
+		if v.OverflowUint(arg) {
+			return ctx.fail(ErrCBOROverflow)
+		}
+		v.SetUint(arg)
+	case reflect.String:
+		if major != cborText {
+			return ctx.fail(ErrCBORType)
+		}
+		var buf []byte
+		if buf, err = cborReadBytes(ctx, arg); err != nil {
+			return ctx.fail(err)
+		}
+		v.SetString(string(buf))
+	case reflect.Struct:
+		if major != cborMap {
+			return ctx.fail(ErrCBORType)
+		}
+		return cborDecodeStruct(ctx, v, arg)
+	case reflect.Slice:
+		var count int
+		if count, err = sizeValue(arg); err != nil {
+			return ctx.fail(err)
+		}
+		if _, err = ctx._Context.parseSize(count, -1); err != nil {
+			return ctx.fail(err)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if major != cborBytes {
+				return ctx.fail(ErrCBORType)
+			}
+			var buf []byte
+			if buf, err = cborReadBytes(ctx, arg); err != nil {
+				return ctx.fail(err)
+			}
+			v.SetBytes(buf)
+			break
+		}
+		if major != cborArray {
+			return ctx.fail(ErrCBORType)
+		}
+		// each element needs at least one byte
+		if count > rdr.Len() {
+			return ctx.fail(ErrMarshalSizeMismatch)
+		}
+		et := v.Type().Elem()
+		if err = ctx.allocate(count, int(et.Size())*count); err != nil {
+			return ctx.fail(err)
+		}
+		s := reflect.MakeSlice(v.Type(), count, count)
+		for i := 0; i < count; i++ {
+			if err = cborDecode(ctx, s.Index(i)); err != nil {
+				return
+			}
+		}
+		v.Set(s)
+	default:
+		err = gerr.New(ErrMarshalUnknownType,
+			"unmarshal: field '%s', type '%v', kind '%v'",
+			ctx.string(), v.Type(), v.Kind())
+	}
+	return
+}
+
+// decode a map with 'n' entries into a struct
+func cborDecodeStruct(ctx *_UnmarshalContext, x reflect.Value, n uint64) error {
+	rdr := ctx.rdr.(*bytes.Reader)
+	if n > uint64(rdr.Len()) {
+		return ctx.fail(ErrMarshalSizeMismatch)
+	}
+	depth := ctx.limits.MaxDepth
+	if depth <= 0 {
+		depth = math.MaxInt
+	}
+	// collect offsets of map values
+	entries := make(map[string]int64)
+	for i := uint64(0); i < n; i++ {
+		major, arg, err := cborReadHead(rdr)
+		if err != nil {
+			return ctx.fail(err)
+		}
+		var key []byte
+		if major == cborText {
+			if key, err = cborReadBytes(ctx, arg); err != nil {
+				return ctx.fail(err)
+			}
+		} else if err = cborSkip(rdr, major, arg, depth); err != nil {
+			return ctx.fail(err)
+		}
+		if key != nil {
+			entries[string(key)] = rdr.Size() - int64(rdr.Len())
+		}
+		if major, arg, err = cborReadHead(rdr); err == nil {
+			err = cborSkip(rdr, major, arg, depth)
+		}
+		if err != nil {
+			return ctx.fail(err)
+		}
+	}
+	pos, _ := rdr.Seek(0, io.SeekCurrent)
+	defer rdr.Seek(pos, io.SeekStart) //nolint:errcheck // seek on valid position
+
+	// decode struct fields
+	for i := 0; i < x.NumField(); i++ {
+		f := x.Field(i)
+		if !f.CanSet() {
+			continue
+		}
+		ft := x.Type().Field(i)
+		name := cborKey(ft)
+		if len(name) == 0 {
+			continue
+		}
+		ctx.push(ft.Name, f, ft.Tag)
+		used, err := ctx.isUsed()
+		if err != nil {
+			return ctx.fail(err)
+		}
+		if used {
+			start, ok := entries[name]
+			if !ok {
+				return ctx.fail(ErrCBORMissing)
+			}
+			if _, err = rdr.Seek(start, io.SeekStart); err != nil {
+				return ctx.fail(err)
+			}
+			if err = cborDecode(ctx, f); err != nil {
+				return err
+			}
+			if init := ft.Tag.Get("init"); len(init) > 0 {
+				ret, err := ctx.callFieldMethod(f, init)
+				if err != nil {
+					return err
+				}
+				if len(ret) == 1 && ret[0].CanInterface() {
+					if err, _ = ret[0].Interface().(error); err != nil {
+						return ctx.fail(err)
+					}
+				}
+			}
+		}
+		ctx.pop()
+	}
+	return nil
+}
+
+// cborReadHead reads the initial byte(s) of a data item.
+func cborReadHead(rdr *bytes.Reader) (major byte, arg uint64, err error) {
+	var b byte
+	if b, err = rdr.ReadByte(); err != nil {
+		return
+	}
+	major, arg = b>>5, uint64(b&0x1f)
+	var n int
+	switch {
+	case arg < 24:
+		return
+	case arg == 24:
+		n = 1
+	case arg == 25:
+		n = 2
+	case arg == 26:
+		n = 4
+	case arg == 27:
+		n = 8
+	default:
+		err = ErrCBORIndefinite
+		return
+	}
+	buf := make([]byte, 8)
+	if _, err = io.ReadFull(rdr, buf[8-n:]); err != nil {
+		return
+	}
+	arg = binary.BigEndian.Uint64(buf)
+	return
+}
+
+// cborReadBytes reads the content of a byte or text string.
+func cborReadBytes(ctx *_UnmarshalContext, n uint64) ([]byte, error) {
+	rdr := ctx.rdr.(*bytes.Reader)
+	if n > uint64(rdr.Len()) {
+		return nil, ErrMarshalSizeMismatch
+	}
+	if err := ctx.allocate(int(n), int(n)); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(rdr, buf)
+	return buf, err
+}
+
+// cborSkip skips the content of a data item (with head already read).
+func cborSkip(rdr *bytes.Reader, major byte, arg uint64, depth int) (err error) {
+	if depth--; depth < 0 {
+		return ErrMarshalDepth
+	}
+	switch major {
+	case cborBytes, cborText:
+		if arg > uint64(rdr.Len()) {
+			return ErrMarshalSizeMismatch
+		}
+		_, err = rdr.Seek(int64(arg), io.SeekCurrent)
+	case cborArray, cborMap:
+		if major == cborMap {
+			if arg > math.MaxUint64/2 {
+				return ErrMarshalSizeMismatch
+			}
+			arg *= 2
+		}
+		if arg > uint64(rdr.Len()) {
+			return ErrMarshalSizeMismatch
+		}
+		for i := uint64(0); i < arg && err == nil; i++ {
+			var m byte
+			var a uint64
+			if m, a, err = cborReadHead(rdr); err == nil {
+				err = cborSkip(rdr, m, a, depth)
+			}
+		}
+	case cborTag:
+		var m byte
+		var a uint64
+		if m, a, err = cborReadHead(rdr); err == nil {
+			err = cborSkip(rdr, m, a, depth)
+		}
+	}
+	return
+}
@@ -0,0 +1,160 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestCBORVectors(t *testing.T) {
+	// RFC 8949, appendix A
+	for _, tc := range []struct {
+		val any
+		enc string
+	}{
+		{uint8(0), "00"},
+		{int32(23), "17"},
+		{uint16(24), "1818"},
+		{int64(1000), "1903e8"},
+		{uint32(1000000), "1a000f4240"},
+		{uint64(18446744073709551615), "1bffffffffffffffff"},
+		{int8(-1), "20"},
+		{int16(-1000), "3903e7"},
+		{int64(-9223372036854775808), "3b7fffffffffffffff"},
+		{true, "f5"},
+		{"IETF", "6449455446"},
+		{"ü", "62c3bc"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{[]uint16{1, 2, 3}, "83010203"},
+		{[][]int{{1}, {2, 3}}, "828101820203"},
+	} {
+		buf, err := MarshalCBOR(tc.val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(buf) != tc.enc {
+			t.Errorf("%v: got %x, expected %s", tc.val, buf, tc.enc)
+		}
+	}
+}
+
+type cborInner struct {
+	Name string
+	Data []byte `size:"4"`
+}
+
+type cborTest struct {
+	Version  uint16
+	HasExtra bool
+	Extra    *cborInner `opt:"HasExtra"`
+	List     []*cborInner
+	Value    int64  `cbor:"v"`
+	Ignored  string `cbor:"-"`
+	Next     *cborTest
+	private  int
+}
+
+func TestCBORStruct(t *testing.T) {
+	in := &cborTest{
+		Version: 3,
+		List: []*cborInner{
+			{"a", []byte{1, 2, 3, 4}},
+			{"b", []byte{5, 6, 7, 8}},
+		},
+		Value:   -500,
+		Ignored: "x",
+		private: 1,
+	}
+	buf, err := MarshalCBOR(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// map keys are sorted by encoding: "v" (length 1) comes first,
+	// the optional field is omitted
+	if !bytes.HasPrefix(buf, []byte{0xa5, 0x61, 'v', 0x39, 0x01, 0xf3, 0x64, 'L', 'i', 's', 't'}) {
+		t.Fatalf("encoding: %x", buf)
+	}
+	out := new(cborTest)
+	if err = UnmarshalCBOR(out, buf); err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != 3 || out.Value != -500 || out.Extra != nil || out.Next != nil ||
+		len(out.List) != 2 || out.List[1].Name != "b" || !bytes.Equal(out.List[1].Data, in.List[1].Data) ||
+		out.Ignored != "" {
+		t.Fatalf("decoded: %+v", out)
+	}
+
+	// optional field used
+	in.HasExtra = true
+	in.Extra = &cborInner{"extra", []byte{9, 9, 9, 9}}
+	if buf, err = MarshalCBOR(in); err != nil {
+		t.Fatal(err)
+	}
+	out = new(cborTest)
+	if err = UnmarshalCBOR(out, buf); err != nil {
+		t.Fatal(err)
+	}
+	if out.Extra == nil || out.Extra.Name != "extra" {
+		t.Fatalf("optional field: %+v", out.Extra)
+	}
+
+	// size tag is enforced
+	in.Extra.Data = []byte{1}
+	if _, err = MarshalCBOR(in); !errors.Is(err, ErrMarshalSizeMismatch) {
+		t.Fatalf("size mismatch: %v", err)
+	}
+}
+
+func TestCBORDecodeErrors(t *testing.T) {
+	var v8 uint8
+	if err := UnmarshalCBOR(&v8, []byte{0x19, 0x01, 0x00}); !errors.Is(err, ErrCBOROverflow) {
+		t.Fatalf("overflow: %v", err)
+	}
+	var s string
+	if err := UnmarshalCBOR(&s, []byte{0x01}); !errors.Is(err, ErrCBORType) {
+		t.Fatalf("type: %v", err)
+	}
+	if err := UnmarshalCBOR(&s, []byte{0x7f, 0x61, 0x61, 0xff}); !errors.Is(err, ErrCBORIndefinite) {
+		t.Fatalf("indefinite: %v", err)
+	}
+	if err := UnmarshalCBOR(&v8, []byte{0x01, 0x02}); !errors.Is(err, ErrCBORTrailing) {
+		t.Fatalf("trailing: %v", err)
+	}
+	// huge array length
+	var list []uint32
+	if err := UnmarshalCBOR(&list, []byte{0x9b, 0, 0, 0, 0, 0xff, 0, 0, 0}); err == nil {
+		t.Fatal("huge array accepted")
+	}
+	// unknown keys are skipped, missing entries are rejected
+	// {"Name": "x", "Zz": [1, {"a": 2}], "Data": h'01020304'}
+	in, _ := hex.DecodeString("a3644e616d656178625a7a8201a1616102644461746144" + "01020304")
+	out := new(cborInner)
+	if err := UnmarshalCBOR(out, in); err != nil || out.Name != "x" {
+		t.Fatalf("unknown key: %v", err)
+	}
+	in, _ = hex.DecodeString("a1644e616d656178")
+	if err := UnmarshalCBOR(out, in); !errors.Is(err, ErrCBORMissing) {
+		t.Fatalf("missing entry: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"container/heap"
+)
+
+//----------------------------------------------------------------------
+// Set of comparable elements
+//----------------------------------------------------------------------
+
+// Set is an unordered collection of unique elements
+type Set[T comparable] struct {
+	data map[T]struct{} // set elements
+}
+
+// NewSet creates a new set with given elements
+func NewSet[T comparable](list ...T) *Set[T] {
+	s := &Set[T]{
+		data: make(map[T]struct{}),
+	}
+	for _, v := range list {
+		s.Add(v)
+	}
+	return s
+}
+
+// Add element to set. Returns false if the element was already in the set.
+func (s *Set[T]) Add(v T) bool {
+	if _, ok := s.data[v]; ok {
+		return false
+	}
+	s.data[v] = struct{}{}
+	return true
+}
+
+// Remove element from set. Returns false if the element was not in the set.
+func (s *Set[T]) Remove(v T) bool {
+	if _, ok := s.data[v]; !ok {
+		return false
+	}
+	delete(s.data, v)
+	return true
+}
+
+// Contains returns true if the element is in the set
+func (s *Set[T]) Contains(v T) bool {
+	_, ok := s.data[v]
+	return ok
+}
+
+// Len returns the number of elements in the set
+func (s *Set[T]) Len() int {
+	return len(s.data)
+}
+
+// List returns the elements of the set (in random order)
+func (s *Set[T]) List() []T {
+	list := make([]T, 0, len(s.data))
+	for v := range s.data {
+		list = append(list, v)
+	}
+	return list
+}
+
+// Union returns a new set with elements from both sets
+func (s *Set[T]) Union(o *Set[T]) *Set[T] {
+	res := NewSet(s.List()...)
+	for v := range o.data {
+		res.Add(v)
+	}
+	return res
+}
+
+// Intersect returns a new set with elements contained in both sets
+func (s *Set[T]) Intersect(o *Set[T]) *Set[T] {
+	res := NewSet[T]()
+	for v := range s.data {
+		if o.Contains(v) {
+			res.Add(v)
+		}
+	}
+	return res
+}
+
+//----------------------------------------------------------------------
+// Queue (first-in-first-out)
+//----------------------------------------------------------------------
+
+// Queue is a first-in-first-out list of elements
+type Queue[T any] struct {
+	data []T // list of elements
+}
+
+// NewQueue creates a new (empty) queue
+func NewQueue[T any]() *Queue[T] {
+	return &Queue[T]{
+		data: make([]T, 0),
+	}
+}
+
+// Put element at the end of the queue
+func (q *Queue[T]) Put(v T) {
+	q.data = append(q.data, v)
+}
+
+// Get first element from the queue. Returns false if the queue is empty.
+func (q *Queue[T]) Get() (v T, ok bool) {
+	if len(q.data) == 0 {
+		return
+	}
+	var zero T
+	v, q.data[0] = q.data[0], zero
+	q.data = q.data[1:]
+	return v, true
+}
+
+// Peek at the first element without removing it.
+func (q *Queue[T]) Peek() (v T, ok bool) {
+	if len(q.data) == 0 {
+		return
+	}
+	return q.data[0], true
+}
+
+// Len returns the number of elements in the queue
+func (q *Queue[T]) Len() int {
+	return len(q.data)
+}
+
+//----------------------------------------------------------------------
+// Priority queue
+//----------------------------------------------------------------------
+
+// PriorityQueue returns elements ordered by priority; elements with
+// the same priority are returned in insertion order.
+type PriorityQueue[T any] struct {
+	list pqList[T] // heap of entries
+	seq  uint64    // insertion counter
+}
+
+// pqEntry is an element in a priority queue
+type pqEntry[T any] struct {
+	val  T      // element
+	prio int    // priority (lower values first)
+	seq  uint64 // insertion order
+}
+
+// pqList implements heap.Interface
+type pqList[T any] []*pqEntry[T]
+
+func (l pqList[T]) Len() int { return len(l) }
+func (l pqList[T]) Less(i, j int) bool {
+	if l[i].prio == l[j].prio {
+		return l[i].seq < l[j].seq
+	}
+	return l[i].prio < l[j].prio
+}
+func (l pqList[T]) Swap(i, j int)       { l[i], l[j] = l[j], l[i] }
+func (l *pqList[T]) Push(x interface{}) { *l = append(*l, x.(*pqEntry[T])) }
+func (l *pqList[T]) Pop() interface{} {
+	old := *l
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*l = old[:n-1]
+	return e
+}
+
+// NewPriorityQueue creates a new (empty) priority queue
+func NewPriorityQueue[T any]() *PriorityQueue[T] {
+	return &PriorityQueue[T]{
+		list: make(pqList[T], 0),
+	}
+}
+
+// Put element with given priority (lower values are returned first)
+func (q *PriorityQueue[T]) Put(v T, prio int) {
+	q.seq++
+	heap.Push(&q.list, &pqEntry[T]{
+		val:  v,
+		prio: prio,
+		seq:  q.seq,
+	})
+}
+
+// Get element with highest priority (lowest value). Returns false if
+// the queue is empty.
+func (q *PriorityQueue[T]) Get() (v T, prio int, ok bool) {
+	if len(q.list) == 0 {
+		return
+	}
+	e := heap.Pop(&q.list).(*pqEntry[T])
+	return e.val, e.prio, true
+}
+
+// Peek at the element with highest priority without removing it.
+func (q *PriorityQueue[T]) Peek() (v T, prio int, ok bool) {
+	if len(q.list) == 0 {
+		return
+	}
+	e := q.list[0]
+	return e.val, e.prio, true
+}
+
+// Len returns the number of elements in the queue
+func (q *PriorityQueue[T]) Len() int {
+	return len(q.list)
+}
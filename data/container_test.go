@@ -0,0 +1,101 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	s := NewSet(1, 2, 3)
+	if s.Add(2) || !s.Add(4) || s.Len() != 4 {
+		t.Fatal("add failed")
+	}
+	if !s.Remove(1) || s.Remove(1) || s.Contains(1) {
+		t.Fatal("remove failed")
+	}
+	o := NewSet(3, 4, 5)
+	list := s.Intersect(o).List()
+	sort.Ints(list)
+	if len(list) != 2 || list[0] != 3 || list[1] != 4 {
+		t.Fatalf("intersect failed: %v", list)
+	}
+	if s.Union(o).Len() != 4 {
+		t.Fatal("union failed")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	q := NewQueue[string]()
+	if _, ok := q.Get(); ok {
+		t.Fatal("empty queue returned element")
+	}
+	for _, v := range []string{"a", "b", "c"} {
+		q.Put(v)
+	}
+	if v, _ := q.Peek(); v != "a" {
+		t.Fatal("peek failed")
+	}
+	for _, exp := range []string{"a", "b", "c"} {
+		if v, ok := q.Get(); !ok || v != exp {
+			t.Fatalf("expected %s, got %s", exp, v)
+		}
+	}
+	if q.Len() != 0 {
+		t.Fatal("queue not empty")
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	q := NewPriorityQueue[string]()
+	q.Put("low", 10)
+	q.Put("high1", 1)
+	q.Put("mid", 5)
+	q.Put("high2", 1)
+	for _, exp := range []string{"high1", "high2", "mid", "low"} {
+		if v, _, ok := q.Get(); !ok || v != exp {
+			t.Fatalf("expected %s, got %s", exp, v)
+		}
+	}
+	if _, _, ok := q.Get(); ok {
+		t.Fatal("empty queue returned element")
+	}
+}
+
+func TestTypedStack(t *testing.T) {
+	s := NewStackOf[string]()
+	if s.Peek() != "" {
+		t.Fatal("peek on empty stack failed")
+	}
+	s.Push("a")
+	s.Push("b")
+	if s.Pop() != "b" || s.Pop() != "a" || s.Len() != 0 {
+		t.Fatal("push/pop failed")
+	}
+	v := NewVectorOf[int]()
+	v.Add(1)
+	v.Insert(0, 0)
+	v.Insert(3, 3)
+	if v.Len() != 4 || v.At(0) != 0 || v.At(1) != 1 || v.At(2) != 0 || v.At(3) != 3 {
+		t.Fatal("vector failed")
+	}
+}
@@ -0,0 +1,130 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"errors"
+
+	gerr "github.com/bfix/gospel/errors"
+)
+
+//======================================================================
+// Fuzzing support for the (reflection-based) unmarshaller:
+// FuzzUnmarshal decodes arbitrary data into a fresh object and reports
+// only errors that indicate a bug (panics, unstable re-encoding);
+// decoding errors for malformed input are expected and ignored.
+// FuzzCorpus derives a deterministic set of (mostly malformed) inputs
+// from a valid object as seeds for a fuzzer.
+//======================================================================
+
+// Error codes
+var (
+	ErrMarshalPanic     = errors.New("unmarshal panicked")
+	ErrMarshalRoundtrip = errors.New("unstable re-encoding")
+)
+
+// FuzzLimits are tight resource limits used when fuzzing
+var FuzzLimits = Limits{
+	MaxDepth:    64,
+	MaxElements: 1 << 16,
+	MaxSliceLen: 1 << 12,
+	MaxAlloc:    1 << 20,
+}
+
+// FuzzUnmarshal decodes 'data' into a new object created by 'mk' with
+// the given limits. If decoding succeeds, the object is marshalled and
+// decoded again; both encodings must be identical. Returns an error
+// only if the unmarshaller panicked or the roundtrip is not stable.
+func FuzzUnmarshal(mk func() interface{}, data []byte, limits Limits) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = gerr.New(ErrMarshalPanic, "%v", r)
+		}
+	}()
+	// decode input (errors are expected for malformed data)
+	obj := mk()
+	if UnmarshalLimited(obj, data, limits) != nil {
+		return nil
+	}
+	// check roundtrip
+	var d1, d2 []byte
+	if d1, err = MarshalLimited(obj, limits); err != nil {
+		return gerr.New(ErrMarshalRoundtrip, "marshal: %s", err.Error())
+	}
+	obj2 := mk()
+	if err = UnmarshalLimited(obj2, d1, limits); err != nil {
+		return gerr.New(ErrMarshalRoundtrip, "unmarshal: %s", err.Error())
+	}
+	if d2, err = MarshalLimited(obj2, limits); err != nil {
+		return gerr.New(ErrMarshalRoundtrip, "re-marshal: %s", err.Error())
+	}
+	if !bytes.Equal(d1, d2) {
+		return gerr.New(ErrMarshalRoundtrip, "%x != %x", d1, d2)
+	}
+	return nil
+}
+
+// FuzzCorpus returns a deterministic set of inputs derived from the
+// encoding of 'obj': the encoding itself, all truncations, single byte
+// mutations (0x00, 0xff and bit-flip) and appended garbage. At most
+// 'maxPos' byte positions (from the start) are mutated; a value of
+// zero mutates all positions.
+func FuzzCorpus(obj interface{}, maxPos int) (corpus [][]byte, err error) {
+	var data []byte
+	if data, err = Marshal(obj); err != nil {
+		return
+	}
+	n := len(data)
+	if maxPos <= 0 || maxPos > n {
+		maxPos = n
+	}
+	corpus = append(corpus, data)
+
+	// truncated inputs
+	for i := 0; i < n; i++ {
+		corpus = append(corpus, clone(data[:i]))
+	}
+	// single byte mutations
+	for i := 0; i < maxPos; i++ {
+		for _, f := range []func(byte) byte{
+			func(byte) byte { return 0x00 },
+			func(byte) byte { return 0xff },
+			func(b byte) byte { return b ^ 0x80 },
+		} {
+			if b := f(data[i]); b != data[i] {
+				d := clone(data)
+				d[i] = b
+				corpus = append(corpus, d)
+			}
+		}
+	}
+	// appended garbage
+	for _, tail := range [][]byte{{0x00}, {0xff, 0xff, 0xff, 0xff}, bytes.Repeat([]byte{0x7f}, 16)} {
+		corpus = append(corpus, append(clone(data), tail...))
+	}
+	return
+}
+
+// clone a byte slice
+func clone(d []byte) []byte {
+	return append([]byte(nil), d...)
+}
@@ -0,0 +1,177 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+//----------------------------------------------------------------------
+
+type FuzzStruct struct {
+	N uint32   `order:"big"`
+	A []uint16 `size:"N"`
+	S string
+	L uint64
+	B []byte          `size:"L"`
+	C []*NestedStruct `size:"*"`
+}
+
+func fuzzSample() *FuzzStruct {
+	return &FuzzStruct{
+		N: 3,
+		A: []uint16{1, 2, 3},
+		S: "fuzz",
+		L: 4,
+		B: []byte{0xde, 0xad, 0xbe, 0xef},
+		C: []*NestedStruct{{A: 1, B: 2}, {A: 3, B: 4}},
+	}
+}
+
+func newFuzzStruct() interface{} { return new(FuzzStruct) }
+
+func TestFuzzCorpus(t *testing.T) {
+	corpus, err := FuzzCorpus(fuzzSample(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range corpus {
+		if err = FuzzUnmarshal(newFuzzStruct, data, FuzzLimits); err != nil {
+			t.Fatalf("entry %d [%x]: %s", i, data, err.Error())
+		}
+	}
+}
+
+func TestUnmarshalLimits(t *testing.T) {
+	// crafted size tag (larger than data)
+	data, err := Marshal(fuzzSample())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		data[i] = 0xff
+	}
+	if err = Unmarshal(new(FuzzStruct), data); err == nil {
+		t.Fatal("crafted size accepted")
+	}
+	// slice limit
+	lim := Limits{MaxSliceLen: 2}
+	data, _ = Marshal(fuzzSample())
+	if err = UnmarshalLimited(new(FuzzStruct), data, lim); !errors.Is(err, ErrMarshalLimit) {
+		t.Fatalf("slice limit: %v", err)
+	}
+	// allocation limit
+	lim = Limits{MaxAlloc: 8}
+	if err = UnmarshalLimited(new(FuzzStruct), data, lim); !errors.Is(err, ErrMarshalLimit) {
+		t.Fatalf("alloc limit: %v", err)
+	}
+	// large byte array and string are charged with their full size
+	lim = Limits{MaxAlloc: 1024}
+	for _, mod := range []func(*FuzzStruct){
+		func(fs *FuzzStruct) { fs.L, fs.B = 4096, make([]byte, 4096) },
+		func(fs *FuzzStruct) { fs.S = strings.Repeat("x", 4096) },
+	} {
+		fs := fuzzSample()
+		mod(fs)
+		buf, err := Marshal(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = UnmarshalLimited(new(FuzzStruct), buf, lim); !errors.Is(err, ErrMarshalLimit) {
+			t.Fatalf("alloc limit (large array): %v", err)
+		}
+	}
+	// no limits hit
+	if err = UnmarshalLimited(new(FuzzStruct), data, FuzzLimits); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func FuzzUnmarshalData(f *testing.F) {
+	corpus, err := FuzzCorpus(fuzzSample(), 0)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, data := range corpus {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := FuzzUnmarshal(newFuzzStruct, data, FuzzLimits); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+//----------------------------------------------------------------------
+
+type ListNode struct {
+	V    uint8
+	More bool
+	N    *ListNode `opt:"More"`
+}
+
+func TestMarshalCycle(t *testing.T) {
+	n := &ListNode{V: 1, More: true}
+	n.N = &ListNode{V: 2, More: true, N: n}
+	if _, err := Marshal(n); !errors.Is(err, ErrMarshalCycle) {
+		t.Fatalf("cycle not detected: %v", err)
+	}
+	// shared (non-cyclic) references are fine
+	shared := &NestedStruct{A: 1, B: 2}
+	if _, err := Marshal(&FuzzStruct{C: []*NestedStruct{shared, shared}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnmarshalDepth(t *testing.T) {
+	// build a deeply nested list
+	data := make([]byte, 0)
+	for i := 0; i < 100; i++ {
+		data = append(data, byte(i), 1)
+	}
+	data = append(data, 0xff, 0)
+
+	lim := DefaultLimits
+	lim.MaxDepth = 32
+	if err := UnmarshalLimited(new(ListNode), data, lim); !errors.Is(err, ErrMarshalDepth) {
+		t.Fatalf("depth: %v", err)
+	}
+	lim = DefaultLimits
+	lim.MaxElements = 50
+	if err := UnmarshalLimited(new(ListNode), data, lim); !errors.Is(err, ErrMarshalLimit) {
+		t.Fatalf("elements: %v", err)
+	}
+	n := new(ListNode)
+	if err := Unmarshal(n, data); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if n.V != byte(i) {
+			t.Fatalf("value mismatch at %d", i)
+		}
+		n = n.N
+	}
+	if n.V != 0xff || n.More || n.N != nil {
+		t.Fatal("list end mismatch")
+	}
+}
@@ -0,0 +1,276 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+//----------------------------------------------------------------------
+// Canonical JSON (following RFC 8785 "JSON Canonicalization Scheme"):
+// no whitespace, object members sorted by the UTF-16 code units of
+// their names, minimal string escaping and ECMAScript number format.
+// All numbers are handled as IEEE 754 doubles; as required for I-JSON
+// input, integer literals that can't be represented exactly (like most
+// 64-bit values above 2^53) are rejected instead of silently changed.
+// Such values should be encoded as strings. The canonical form of a
+// value is suitable for hashing and signing.
+//----------------------------------------------------------------------
+
+// Error codes
+var (
+	ErrJSONNumber   = errors.New("invalid JSON number")
+	ErrJSONDupKey   = errors.New("duplicate JSON object key")
+	ErrJSONTrailing = errors.New("trailing data after JSON value")
+)
+
+// CanonicalJSON returns the canonical JSON encoding of a value. The
+// value is marshalled with encoding/json first (so struct tags and
+// custom marshallers apply).
+func CanonicalJSON(v any) ([]byte, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return CanonicalizeJSON(buf)
+}
+
+// CanonicalizeJSON converts a JSON document into canonical form.
+func CanonicalizeJSON(in []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(in))
+	dec.UseNumber()
+	val, err := decodeJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = dec.Token(); err == nil {
+		return nil, ErrJSONTrailing
+	}
+	out := new(bytes.Buffer)
+	if err = encodeJSON(out, val); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// jsonMember of a JSON object
+type jsonMember struct {
+	key string
+	val any
+}
+
+// decodeJSON reads a JSON value from a token stream. Objects are
+// returned as lists of members (to detect duplicate keys).
+func decodeJSON(dec *json.Decoder) (any, error) {
+	tk, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch d := tk.(type) {
+	case json.Delim:
+		switch d {
+		case '[':
+			list := make([]any, 0)
+			for dec.More() {
+				v, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			_, err = dec.Token()
+			return list, err
+		case '{':
+			obj := make([]*jsonMember, 0)
+			keys := make(map[string]bool)
+			for dec.More() {
+				tk, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key := tk.(string)
+				if keys[key] {
+					return nil, ErrJSONDupKey
+				}
+				keys[key] = true
+				v, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj = append(obj, &jsonMember{key, v})
+			}
+			_, err = dec.Token()
+			return obj, err
+		}
+	}
+	return tk, nil
+}
+
+// encodeJSON writes a decoded value in canonical form.
+func encodeJSON(out *bytes.Buffer, val any) error {
+	switch v := val.(type) {
+	case nil:
+		out.WriteString("null")
+	case bool:
+		out.WriteString(strconv.FormatBool(v))
+	case string:
+		writeJSONString(out, v)
+	case json.Number:
+		s, err := formatJSONNumber(string(v))
+		if err != nil {
+			return err
+		}
+		out.WriteString(s)
+	case []any:
+		out.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := encodeJSON(out, e); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	case []*jsonMember:
+		sort.Slice(v, func(i, j int) bool {
+			return lessUTF16(v[i].key, v[j].key)
+		})
+		out.WriteByte('{')
+		for i, m := range v {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			writeJSONString(out, m.key)
+			out.WriteByte(':')
+			if err := encodeJSON(out, m.val); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	}
+	return nil
+}
+
+// writeJSONString writes a string with minimal escaping.
+func writeJSONString(out *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	out.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\b':
+			out.WriteString(`\b`)
+		case '\f':
+			out.WriteString(`\f`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				out.WriteString(`\u00`)
+				out.WriteByte(hex[r>>4])
+				out.WriteByte(hex[r&0xF])
+			} else {
+				out.WriteRune(r)
+			}
+		}
+	}
+	out.WriteByte('"')
+}
+
+// lessUTF16 compares strings by their UTF-16 code units.
+func lessUTF16(a, b string) bool {
+	ua := utf16.Encode([]rune(a))
+	ub := utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+// formatJSONNumber returns the canonical form of a JSON number.
+func formatJSONNumber(s string) (string, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", ErrJSONNumber
+	}
+	// integer literals must be exact
+	if !strings.ContainsAny(s, ".eE") {
+		exact, ok := new(big.Int).SetString(s, 10)
+		if i, _ := big.NewFloat(f).Int(nil); !ok || i.Cmp(exact) != 0 {
+			return "", ErrJSONNumber
+		}
+	}
+	return FormatES6(f), nil
+}
+
+// FormatES6 formats a float like ECMAScript's Number.prototype.toString.
+func FormatES6(f float64) string {
+	if f == 0 {
+		return "0"
+	}
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+	// shortest round-trip digits and decimal exponent
+	e := strconv.FormatFloat(f, 'e', -1, 64)
+	mant, exp, _ := strings.Cut(e, "e")
+	digits := strings.Replace(mant, ".", "", 1)
+	n, _ := strconv.Atoi(exp)
+	n++ // position of decimal point
+	k := len(digits)
+
+	switch {
+	case k <= n && n <= 21:
+		return sign + digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		return sign + digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		return sign + "0." + strings.Repeat("0", -n) + digits
+	}
+	s := digits[:1]
+	if k > 1 {
+		s += "." + digits[1:]
+	}
+	if n-1 >= 0 {
+		return sign + s + "e+" + strconv.Itoa(n-1)
+	}
+	return sign + s + "e" + strconv.Itoa(n-1)
+}
@@ -0,0 +1,111 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"testing"
+)
+
+func TestFormatES6(t *testing.T) {
+	for f, s := range map[float64]string{
+		4.50:               "4.5",
+		2e-3:               "0.002",
+		0.000001:           "0.000001",
+		1e-7:               "1e-7",
+		1e21:               "1e+21",
+		1e20:               "100000000000000000000",
+		-1.5e30:            "-1.5e+30",
+		333333333.33333329: "333333333.3333333",
+		5e-324:             "5e-324",
+	} {
+		if out := FormatES6(f); out != s {
+			t.Errorf("%v: got %s, expected %s", f, out, s)
+		}
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	// RFC 8785, section 3.2.3 (sorting by UTF-16 code units)
+	in := `{
+		"\u20ac": "Euro Sign",
+		"\r": "Carriage Return",
+		"\ufb33": "Hebrew Letter Dalet With Dagesh",
+		"1": "One",
+		"\ud83d\ude00": "Emoji: Grinning Face",
+		"\u0080": "Control",
+		"\u00f6": "Latin Small Letter O With Diaeresis"
+	}`
+	out, err := CanonicalizeJSON([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\"," +
+		"\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\"," +
+		"\"\U0001F600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}"
+	if string(out) != exp {
+		t.Fatalf("got %s", out)
+	}
+
+	// structs, maps, numbers and escaping
+	v := struct {
+		Z map[string]any `json:"z"`
+		A []any          `json:"a"`
+	}{
+		Z: map[string]any{"b": 1.0, "a": "x<y>\u2028\x01"},
+		A: []any{int64(9007199254740992), -0.0, 1e-7, nil, true},
+	}
+	if out, err = CanonicalJSON(v); err != nil {
+		t.Fatal(err)
+	}
+	exp = `{"a":[9007199254740992,0,1e-7,null,true],"z":{"a":"x<y>` + "\u2028" + `\u0001","b":1}}`
+	if string(out) != exp {
+		t.Fatalf("got %s", out)
+	}
+
+	// numbers (RFC 8785, section 3.2.2.3)
+	in = `[1e2,-0,1E+2,1000000000000000000000,0.10,1180591620717411303424,-9007199254740991]`
+	if out, err = CanonicalizeJSON([]byte(in)); err != nil {
+		t.Fatal(err)
+	}
+	exp = `[100,0,100,1e+21,0.1,1.1805916207174113e+21,-9007199254740991]`
+	if string(out) != exp {
+		t.Fatalf("got %s", out)
+	}
+	for _, n := range []string{"9007199254740993", "-18446744073709551615", "1e400"} {
+		if _, err = CanonicalizeJSON([]byte(n)); err != ErrJSONNumber {
+			t.Fatalf("number %s accepted", n)
+		}
+	}
+	if _, err = CanonicalJSON(uint64(1<<63 + 1)); err != ErrJSONNumber {
+		t.Fatal("inexact integer accepted")
+	}
+
+	// invalid input
+	if _, err = CanonicalizeJSON([]byte(`{"a":1,"a":2}`)); err != ErrJSONDupKey {
+		t.Fatal("duplicate key accepted")
+	}
+	if _, err = CanonicalizeJSON([]byte(`{"a":1} 2`)); err != ErrJSONTrailing {
+		t.Fatal("trailing data accepted")
+	}
+	if _, err = CanonicalizeJSON([]byte(`{"a":}`)); err == nil {
+		t.Fatal("invalid JSON accepted")
+	}
+}
@@ -97,6 +97,14 @@ import (
 // of a struct method used to initialize the instance after
 // unmarshalling the binary representation.
 //
+// ------------------------------
+// Resource limits
+// ------------------------------
+// (Un-)marshalling is restricted by resource limits (nesting depth,
+// number of values, slice lengths and allocated memory) to protect
+// against malicious input; cyclic references are rejected. Use the
+// "...Limited" functions to override the default limits.
+//
 //######################################################################
 
 // Errors
@@ -115,8 +123,28 @@ var (
 	ErrMarshalMthdArgType   = errors.New("method argument not a string")
 	ErrMarshalMthdResult    = errors.New("invalid method result")
 	ErrMarshalParentMissing = errors.New("parent missing")
+	ErrMarshalLimit         = errors.New("resource limit exceeded")
+	ErrMarshalDepth         = errors.New("max. nesting depth exceeded")
+	ErrMarshalCycle         = errors.New("cyclic reference")
 )
 
+// Limits restrict the resources used when (un-)marshalling objects
+// (from untrusted data). A limit of zero is not enforced.
+type Limits struct {
+	MaxDepth    int // max. nesting depth of values
+	MaxElements int // max. number of values processed
+	MaxSliceLen int // max. number of elements in a slice or byte array
+	MaxAlloc    int // max. number of bytes allocated for an object
+}
+
+// DefaultLimits are used by (Un)Marshal and (Un)MarshalStream.
+var DefaultLimits = Limits{
+	MaxDepth:    256,
+	MaxElements: 1 << 22,
+	MaxSliceLen: 1 << 20,
+	MaxAlloc:    1 << 26,
+}
+
 //======================================================================
 // Marshal Golang objects to byte arrays.
 //======================================================================
@@ -133,13 +161,34 @@ func Marshal(obj interface{}) ([]byte, error) {
 
 // MarshalStream writes an object to stream
 func MarshalStream(wrt io.Writer, obj interface{}) error {
+	return MarshalStreamLimited(wrt, obj, DefaultLimits)
+}
+
+// MarshalLimited creates a byte array from an object with custom
+// resource limits.
+func MarshalLimited(obj interface{}, limits Limits) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := MarshalStreamLimited(buf, obj, limits)
+	return buf.Bytes(), err
+}
+
+// MarshalStreamLimited writes an object to stream with custom
+// resource limits.
+func MarshalStreamLimited(wrt io.Writer, obj interface{}, limits Limits) error {
 	inst := reflect.ValueOf(obj)
 	ctx := _NewMarshalContext(wrt, inst)
+	ctx.limits = limits
 	return marshalValue(ctx, inst)
 }
 
 // marshal a single value instance
 func marshalValue(ctx *_MarshalContext, v reflect.Value) error {
+	// check limits and references
+	if err := ctx.enter(v); err != nil {
+		return ctx.fail(err)
+	}
+	defer ctx.leave(v)
+
 	// try intrinsic types first
 	if ok, err := marshalIntrinsic(ctx, v); ok {
 		return err
@@ -346,15 +395,39 @@ func Unmarshal(obj interface{}, data []byte) error {
 	return UnmarshalStream(buf, obj, len(data))
 }
 
-// UnmarshalStream reads an object from strean.
+// UnmarshalStream reads an object from strean. 'pending' is the number of
+// bytes available for the object in the stream: byte arrays that are
+// larger than the remaining pending data are rejected with
+// ErrMarshalSizeMismatch, so 'pending' must not be less than the size
+// of the marshalled object.
 func UnmarshalStream(rdr io.Reader, obj interface{}, pending int) error {
+	return UnmarshalStreamLimited(rdr, obj, pending, DefaultLimits)
+}
+
+// UnmarshalLimited reads a byte array to fill an object with custom
+// resource limits.
+func UnmarshalLimited(obj interface{}, data []byte, limits Limits) error {
+	buf := bytes.NewBuffer(data)
+	return UnmarshalStreamLimited(buf, obj, len(data), limits)
+}
+
+// UnmarshalStreamLimited reads an object from stream with custom
+// resource limits ('pending' as in UnmarshalStream).
+func UnmarshalStreamLimited(rdr io.Reader, obj interface{}, pending int, limits Limits) error {
 	inst := reflect.ValueOf(obj)
 	ctx := _NewUnmarshalContext(rdr, pending, inst)
+	ctx.limits = limits
 	return unmarshalValue(ctx, inst)
 }
 
 // unmarshal a single value instance
 func unmarshalValue(ctx *_UnmarshalContext, v reflect.Value) error {
+	// check limits and references
+	if err := ctx.enter(v); err != nil {
+		return ctx.fail(err)
+	}
+	defer ctx.leave(v)
+
 	// try intrinsic types first
 	if ok, err := unmarshalIntrinsic(ctx, v); ok {
 		return err
@@ -382,7 +455,7 @@ func unmarshalIntrinsic(ctx *_UnmarshalContext, f reflect.Value) (ok bool, err e
 	// Strings
 	//----------------------------------------------------------
 	case string:
-		s := ""
+		s := new(strings.Builder)
 		b := make([]byte, 1)
 		for {
 			if _, err = ctx.rdr.Read(b); err != nil {
@@ -392,10 +465,14 @@ func unmarshalIntrinsic(ctx *_UnmarshalContext, f reflect.Value) (ok bool, err e
 			if b[0] == 0 {
 				break
 			}
-			s += string(b)
+			if err = ctx.allocBytes(s.Len()+1, 1); err != nil {
+				err = ctx.fail(err)
+				return
+			}
+			s.WriteByte(b[0])
 		}
-		f.SetString(s)
-		ctx.pending -= len(s) + 1
+		f.SetString(s.String())
+		ctx.pending -= s.Len() + 1
 	//----------------------------------------------------------
 	// Booleans
 	//----------------------------------------------------------
@@ -488,15 +565,21 @@ func unmarshalIntrinsic(ctx *_UnmarshalContext, f reflect.Value) (ok bool, err e
 			err = ctx.fail(err)
 			return
 		}
+		// the size must be covered by the pending data
+		if size < 0 || size > ctx.pending {
+			err = ctx.fail(ErrMarshalSizeMismatch)
+			return
+		}
+		if err = ctx.allocBytes(size, size); err != nil {
+			err = ctx.fail(err)
+			return
+		}
 		a := make([]byte, size)
 		var n int
-		if n, err = ctx.rdr.Read(a); err != nil {
+		if n, err = io.ReadFull(ctx.rdr, a); err != nil {
 			err = ctx.fail(err)
 			return
 		}
-		if n != size {
-			err = ctx.fail(ErrMarshalSizeMismatch)
-		}
 		f.SetBytes(a)
 		ctx.pending -= n
 
@@ -602,7 +685,10 @@ func unmarshalComplex(ctx *_UnmarshalContext, f reflect.Value) (ok bool, err err
 			err = ctx.fail(err)
 			return
 		}
-		add := (count > f.Len())
+		if count > 0 && ctx.limits.MaxSliceLen > 0 && count > ctx.limits.MaxSliceLen {
+			err = ctx.fail(ErrMarshalLimit)
+			return
+		}
 		// If the element type is a pointer, get the type of the
 		// referenced object and remember to use a pointer.
 		et := f.Type().Elem()
@@ -619,19 +705,21 @@ func unmarshalComplex(ctx *_UnmarshalContext, f reflect.Value) (ok bool, err err
 			}
 			// address the slice element. If the element does not
 			// exist, create a new one and append it to the slice.
-			var e reflect.Value
-			if add {
+			if i >= f.Len() {
+				if err = ctx.allocate(i+1, int(et.Size())); err != nil {
+					err = ctx.fail(err)
+					return
+				}
 				// create and add new element
 				ep := reflect.New(et)
-				e = ep.Elem()
 				if isPtr {
 					f.Set(reflect.Append(f, ep))
 				} else {
-					f.Set(reflect.Append(f, e))
+					f.Set(reflect.Append(f, ep.Elem()))
 				}
 			}
 			// use existing element
-			e = f.Index(i)
+			e := f.Index(i)
 
 			// unmarshal element
 			if err = unmarshalValue(ctx, e); err != nil {
@@ -700,15 +788,27 @@ type _Element struct {
 // _Context keeps track of fields in nested data structures.
 // The top-level struct is anonymous and labeled "@".
 type _Context struct {
-	path []*_Element
-	num  int
+	path   []*_Element
+	num    int
+	limits Limits        // resource limits
+	depth  int           // current nesting depth
+	elems  int           // number of processed values
+	active map[_Ref]bool // referenced objects in process
+}
+
+// _Ref identifies a referenced object (a pointer can address
+// different types, e.g. a struct and its first field).
+type _Ref struct {
+	addr uintptr
+	typ  reflect.Type
 }
 
 // create a new path with top-level reference set
 func _NewContext(inst reflect.Value) *_Context {
 	p := &_Context{
-		path: make([]*_Element, 0),
-		num:  0,
+		path:   make([]*_Element, 0),
+		num:    0,
+		active: make(map[_Ref]bool),
 	}
 	p.push("@", inst, "")
 	return p
@@ -721,6 +821,34 @@ func (c *_Context) push(name string, value reflect.Value, tag reflect.StructTag)
 	c.num++
 }
 
+// enter a new value: check limits and detect cyclic references
+func (c *_Context) enter(v reflect.Value) error {
+	c.depth++
+	c.elems++
+	if c.limits.MaxDepth > 0 && c.depth > c.limits.MaxDepth {
+		return ErrMarshalDepth
+	}
+	if c.limits.MaxElements > 0 && c.elems > c.limits.MaxElements {
+		return ErrMarshalLimit
+	}
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		ref := _Ref{v.Pointer(), v.Type()}
+		if c.active[ref] {
+			return ErrMarshalCycle
+		}
+		c.active[ref] = true
+	}
+	return nil
+}
+
+// leave a value
+func (c *_Context) leave(v reflect.Value) {
+	c.depth--
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		delete(c.active, _Ref{v.Pointer(), v.Type()})
+	}
+}
+
 // update current value
 func (c *_Context) use(v reflect.Value) {
 	c.path[c.num-1].value = v
@@ -791,7 +919,9 @@ func (c *_Context) parseSize(inSize, pending int) (count int, err error) {
 			err = ErrMarshalMthdResult
 			return
 		}
-		count = int(res[0].Uint())
+		if count, err = sizeValue(res[0].Uint()); err != nil {
+			return
+		}
 	} else {
 		var n int64
 		if n, err = strconv.ParseInt(tagSize, 10, 16); err == nil {
@@ -804,7 +934,9 @@ func (c *_Context) parseSize(inSize, pending int) (count int, err error) {
 				err = ErrMarshalFieldRef
 				return
 			}
-			count = int(ref.Uint())
+			if count, err = sizeValue(ref.Uint()); err != nil {
+				return
+			}
 		} else {
 			err = ErrMarshalNoSize
 			return
@@ -959,6 +1091,7 @@ type _UnmarshalContext struct {
 	*_Context
 	rdr     io.Reader
 	pending int
+	alloc   int // number of allocated bytes
 }
 
 // create a new unmarshal context
@@ -975,6 +1108,38 @@ func (c *_UnmarshalContext) fail(err error) error {
 	return c._Context.fail(err, "unmarshal")
 }
 
+// allocate checks the resource limits for a slice of 'count' elements
+// of given size (counting the last element only).
+func (c *_UnmarshalContext) allocate(count, size int) error {
+	if c.limits.MaxSliceLen > 0 && count > c.limits.MaxSliceLen {
+		return ErrMarshalLimit
+	}
+	if size < 1 {
+		size = 1
+	}
+	if count == 0 {
+		return nil
+	}
+	c.alloc += size
+	if c.limits.MaxAlloc > 0 && c.alloc > c.limits.MaxAlloc {
+		return ErrMarshalLimit
+	}
+	return nil
+}
+
+// allocBytes checks the resource limits for a byte array (or string) of
+// given length and charges 'n' bytes against the allocation limit.
+func (c *_UnmarshalContext) allocBytes(length, n int) error {
+	if c.limits.MaxSliceLen > 0 && length > c.limits.MaxSliceLen {
+		return ErrMarshalLimit
+	}
+	c.alloc += n
+	if c.limits.MaxAlloc > 0 && c.alloc > c.limits.MaxAlloc {
+		return ErrMarshalLimit
+	}
+	return nil
+}
+
 // parse size for unmarshal operation
 func (c *_UnmarshalContext) parseSize(inSize int) (count int, err error) {
 	pending := -1
@@ -989,6 +1154,14 @@ func (c *_UnmarshalContext) parseSize(inSize int) (count int, err error) {
 // helper functions
 //----------------------------------------------------------------------
 
+// sizeValue converts a size value to int (rejecting unrealistic sizes)
+func sizeValue(v uint64) (int, error) {
+	if v > 1<<31-1 {
+		return 0, ErrMarshalSizeMismatch
+	}
+	return int(v), nil
+}
+
 // read integer based on given endianess
 func readInt(rdr io.Reader, tag string, v interface{}) (err error) {
 	if tag == "big" {
@@ -0,0 +1,138 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+//----------------------------------------------------------------------
+// MerkleTree is a binary hash tree over a list of data blocks (see RFC
+// 6962 for the hashing of leaves and nodes): leaves are hashed as
+// 'H(0x00||block)', inner nodes as 'H(0x01||left||right)'. A node
+// without sibling is promoted to the next level unchanged.
+//----------------------------------------------------------------------
+
+// MerkleTree of SHA-256 hashes
+type MerkleTree struct {
+	levels [][][]byte // hashes on all levels (leaves first)
+}
+
+// NewMerkleTree builds the hash tree for a list of data blocks.
+func NewMerkleTree(blocks [][]byte) *MerkleTree {
+	leaves := make([][]byte, len(blocks))
+	for i, b := range blocks {
+		leaves[i] = merkleLeaf(b)
+	}
+	t := &MerkleTree{
+		levels: [][][]byte{leaves},
+	}
+	for lvl := leaves; len(lvl) > 1; {
+		next := make([][]byte, (len(lvl)+1)/2)
+		for i := range next {
+			if 2*i+1 < len(lvl) {
+				next[i] = merkleNode(lvl[2*i], lvl[2*i+1])
+			} else {
+				next[i] = lvl[2*i]
+			}
+		}
+		t.levels = append(t.levels, next)
+		lvl = next
+	}
+	return t
+}
+
+// Size returns the number of leaves in the tree.
+func (t *MerkleTree) Size() int {
+	return len(t.levels[0])
+}
+
+// Root returns the root hash of the tree. The root of an empty tree is
+// the hash of an empty string.
+func (t *MerkleTree) Root() []byte {
+	top := t.levels[len(t.levels)-1]
+	if len(top) == 0 {
+		h := sha256.Sum256(nil)
+		return h[:]
+	}
+	return top[0]
+}
+
+// Leaf returns the hash of a leaf.
+func (t *MerkleTree) Leaf(i int) []byte {
+	return t.levels[0][i]
+}
+
+// Proof returns the inclusion proof (list of sibling hashes) for the
+// leaf at given index.
+func (t *MerkleTree) Proof(i int) [][]byte {
+	proof := make([][]byte, 0)
+	for _, lvl := range t.levels[:len(t.levels)-1] {
+		if j := i ^ 1; j < len(lvl) {
+			proof = append(proof, lvl[j])
+		}
+		i /= 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof checks if a data block is the leaf at index 'i' in a
+// tree with 'n' leaves and given root hash.
+func VerifyMerkleProof(root, block []byte, i, n int, proof [][]byte) bool {
+	if i < 0 || i >= n {
+		return false
+	}
+	h := merkleLeaf(block)
+	for n > 1 {
+		if i%2 == 1 || i+1 < n {
+			if len(proof) == 0 {
+				return false
+			}
+			if i%2 == 1 {
+				h = merkleNode(proof[0], h)
+			} else {
+				h = merkleNode(h, proof[0])
+			}
+			proof = proof[1:]
+		}
+		i /= 2
+		n = (n + 1) / 2
+	}
+	return len(proof) == 0 && bytes.Equal(h, root)
+}
+
+// merkleLeaf returns the hash of a leaf
+func merkleLeaf(b []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// merkleNode returns the hash of an inner node
+func merkleNode(l, r []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(l)
+	h.Write(r)
+	return h.Sum(nil)
+}
@@ -0,0 +1,55 @@
+package data
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleTree(t *testing.T) {
+	for n := 1; n < 12; n++ {
+		blocks := make([][]byte, n)
+		for i := range blocks {
+			blocks[i] = []byte(fmt.Sprintf("block %d", i))
+		}
+		tree := NewMerkleTree(blocks)
+		root := tree.Root()
+		for i, b := range blocks {
+			proof := tree.Proof(i)
+			if !VerifyMerkleProof(root, b, i, n, proof) {
+				t.Fatalf("proof failed (%d/%d)", i, n)
+			}
+			if VerifyMerkleProof(root, []byte("other"), i, n, proof) {
+				t.Fatalf("proof succeeded for wrong block (%d/%d)", i, n)
+			}
+			if n > 1 && VerifyMerkleProof(root, b, (i+1)%n, n, proof) {
+				t.Fatalf("proof succeeded for wrong index (%d/%d)", i, n)
+			}
+		}
+	}
+	// single leaf: root is leaf hash (RFC 6962)
+	tree := NewMerkleTree([][]byte{{}})
+	if hex.EncodeToString(tree.Root()) != "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d" {
+		t.Fatal("wrong leaf hash")
+	}
+}
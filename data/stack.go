@@ -20,44 +20,55 @@ package data
 // SPDX-License-Identifier: AGPL3.0-or-later
 //----------------------------------------------------------------------
 
-// Stack for generic data types.
-type Stack struct {
-	data [](interface{}) // list of stack elements
+// StackOf is a typed last-in-first-out data structure
+type StackOf[T any] struct {
+	data []T // list of stack elements
 }
 
-// NewStack instantiates a new generic Stack object.
-func NewStack() *Stack {
-	return &Stack{
-		data: make([](interface{}), 0),
+// NewStackOf creates a new (empty) typed stack
+func NewStackOf[T any]() *StackOf[T] {
+	return &StackOf[T]{
+		data: make([]T, 0),
 	}
 }
 
 // Pop last entry from stack and return it to caller.
-func (s *Stack) Pop() (v interface{}) {
+func (s *StackOf[T]) Pop() (v T) {
 	pos := len(s.data) - 1
 	v, s.data = s.data[pos], s.data[:pos]
 	return
 }
 
 // Push generic entry to stack.
-func (s *Stack) Push(v interface{}) {
+func (s *StackOf[T]) Push(v T) {
 	s.data = append(s.data, v)
 }
 
-// Len returns the number of elements on stack.
-func (s *Stack) Len() int {
+// Len returns the number of elements on the stack
+func (s *StackOf[T]) Len() int {
 	return len(s.data)
 }
 
 // Peek at the last element pushed to stack without dropping it.
-func (s *Stack) Peek() (v interface{}) {
+// Returns the zero value of T on an empty stack.
+func (s *StackOf[T]) Peek() (v T) {
 	pos := len(s.data) - 1
 	if pos < 0 {
-		return nil
+		return
 	}
 	return s.data[pos]
 }
 
+// Stack for generic data types.
+type Stack = StackOf[interface{}]
+
+// NewStack instantiates a new generic Stack object.
+func NewStack() *Stack {
+	return NewStackOf[interface{}]()
+}
+
+//----------------------------------------------------------------------
+
 // IntStack is an Integer-based Stack type and implementation.
 type IntStack struct {
 	data []int // list of stack elements
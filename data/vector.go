@@ -20,71 +20,82 @@ package data
 // SPDX-License-Identifier: AGPL3.0-or-later
 //----------------------------------------------------------------------
 
-// Vector data structure
-type Vector struct {
-	data [](interface{}) // list of elements
+// VectorOf is a typed vector data structure
+type VectorOf[T any] struct {
+	data []T // list of elements
 }
 
-// NewVector instantiates a new (empty) Vector object.
-func NewVector() *Vector {
-	return &Vector{
-		data: make([](interface{}), 0),
+// NewVectorOf instantiates a new (empty) typed vector.
+func NewVectorOf[T any]() *VectorOf[T] {
+	return &VectorOf[T]{
+		data: make([]T, 0),
 	}
 }
 
 // Len returns the number of elements in the vector.
-func (vec *Vector) Len() int {
+func (vec *VectorOf[T]) Len() int {
 	return len(vec.data)
 }
 
 // Add element to the end of the vector.
-func (vec *Vector) Add(v interface{}) {
+func (vec *VectorOf[T]) Add(v T) {
 	vec.data = append(vec.data, v)
 }
 
-// Insert element at given position. Add 'nil' elements if index
+// Insert element at given position. Add zero-value elements if index
 // is beyond the end of the vector.
-func (vec *Vector) Insert(i int, v interface{}) {
+func (vec *VectorOf[T]) Insert(i int, v T) {
 
 	if i < 0 {
 		// create a prepending slice
-		pre := make([](interface{}), -i)
+		pre := make([]T, -i)
 		pre[0] = v
 		vec.data = append(pre, vec.data...)
 	} else if i >= len(vec.data) {
 		// create appending slice
 		idx := i - len(vec.data) + 1
-		app := make([](interface{}), idx)
+		app := make([]T, idx)
 		app[idx-1] = v
 		vec.data = append(vec.data, app...)
 	} else {
-		pre := vec.data[:i]
-		app := vec.data[i:]
-		vec.data = append(append(pre, v), app...)
+		// shift tail (don't overwrite the tail while inserting)
+		var zero T
+		vec.data = append(vec.data, zero)
+		copy(vec.data[i+1:], vec.data[i:])
+		vec.data[i] = v
 	}
 }
 
 // Drop the last element from the vector.
-func (vec *Vector) Drop() (v interface{}) {
+func (vec *VectorOf[T]) Drop() (v T) {
 	pos := len(vec.data) - 1
 	v, vec.data = vec.data[pos], vec.data[:pos]
 	return
 }
 
-// Delete indexed element from the vector.
-func (vec *Vector) Delete(i int) (v interface{}) {
+// Delete indexed element from the vector (zero value if out of range)
+func (vec *VectorOf[T]) Delete(i int) (v T) {
 	if i < 0 || i > len(vec.data)-1 {
-		return nil
+		return
 	}
 	v = vec.data[i]
 	vec.data = append(vec.data[:i], vec.data[i+1:]...)
 	return
 }
 
-// At return the indexed element from vector.
-func (vec *Vector) At(i int) (v interface{}) {
+// At returns the indexed element from the vector (zero value if out of
+// range)
+func (vec *VectorOf[T]) At(i int) (v T) {
 	if i < 0 || i > len(vec.data)-1 {
-		return nil
+		return
 	}
 	return vec.data[i]
 }
+
+// Vector data structure
+type Vector = VectorOf[interface{}]
+
+// NewVector instantiates a new (empty) Vector object.
+func NewVector() *Vector {
+	return NewVectorOf[interface{}]()
+}
@@ -0,0 +1,278 @@
+package logger
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/data"
+	gerr "github.com/bfix/gospel/errors"
+)
+
+//======================================================================
+// Audit log: a tamper-evident log of security events (like wallet
+// spends or administrative actions on a node).
+//
+// Records are stored as canonical JSON in a persistent append-only log.
+// Each record contains the SHA-256 digest of the previous record, so
+// changing, inserting or removing a record breaks the hash chain.
+// Anchor records are signed with an Ed25519 key; a valid anchor vouches
+// for all records before it (so a rewritten chain is detected unless
+// the signing key is compromised).
+//======================================================================
+
+// Error codes
+var (
+	ErrAuditChain     = errors.New("audit log hash chain broken")
+	ErrAuditSequence  = errors.New("audit log sequence mismatch")
+	ErrAuditSignature = errors.New("audit log anchor signature invalid")
+	ErrAuditNoKey     = errors.New("audit log has no signing key")
+)
+
+// Audit log constants
+const (
+	AuditAnchor      = "anchor" // kind of anchor records
+	AuditSegmentSize = 1 << 24  // max. size of a log segment
+)
+
+// AuditRecord is an entry in the audit log.
+type AuditRecord struct {
+	Seq    uint64         `json:"seq"`              // sequence number
+	Time   time.Time      `json:"time"`             // time of event
+	Kind   string         `json:"kind"`             // kind of event
+	Fields map[string]any `json:"fields,omitempty"` // event details
+	Prev   []byte         `json:"prev,omitempty"`   // digest of previous record
+	Sig    []byte         `json:"sig,omitempty"`    // signature (anchor only)
+}
+
+// AuditReport is the result of an audit log verification.
+type AuditReport struct {
+	Events     int    // number of events
+	Anchors    int    // number of (valid) anchors
+	Unanchored int    // number of events after last anchor
+	Head       []byte // digest of last record
+}
+
+// AuditLog for tamper-evident event logging
+type AuditLog struct {
+	mtx     sync.Mutex
+	log     *data.AppendLog     // persistent log
+	key     *ed25519.PrivateKey // signing key for anchors
+	every   int                 // anchor after number of events
+	pending int                 // events since last anchor
+	seq     uint64              // sequence number of next record
+	head    []byte              // digest of last record
+}
+
+// OpenAuditLog opens (or creates) an audit log in a directory. If a
+// signing key is given, an anchor is written after every 'every' events
+// (if 'every' is greater than 0) and when the log is closed. The
+// existing log is verified; a broken chain is reported as an error.
+func OpenAuditLog(dir string, key *ed25519.PrivateKey, every int) (a *AuditLog, err error) {
+	a = &AuditLog{
+		key:   key,
+		every: every,
+	}
+	if a.log, err = data.OpenAppendLog(dir, AuditSegmentSize); err != nil {
+		return nil, err
+	}
+	var pub *ed25519.PublicKey
+	if key != nil {
+		pub = key.Public()
+	}
+	var rpt *AuditReport
+	if rpt, err = verifyAudit(a.log, pub); err != nil {
+		a.log.Close()
+		return nil, err
+	}
+	a.seq = uint64(rpt.Events + rpt.Anchors)
+	a.head = rpt.Head
+	a.pending = rpt.Unanchored
+	return
+}
+
+// Event appends an event with optional details to the log. Records are
+// hashed in canonical JSON form, so integer details must be exact as
+// IEEE 754 doubles (use strings for larger 64-bit values).
+func (a *AuditLog) Event(kind string, fields map[string]any) (err error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if err = a.append(a.record(kind, fields)); err != nil {
+		return
+	}
+	a.pending++
+	if a.key != nil && a.every > 0 && a.pending >= a.every {
+		err = a.anchor()
+	}
+	return
+}
+
+// Anchor appends a signed anchor for all previous records.
+func (a *AuditLog) Anchor() error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.anchor()
+}
+
+// Head returns the digest of the last record in the log.
+func (a *AuditLog) Head() []byte {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return bytes.Clone(a.head)
+}
+
+// Close the audit log (anchoring pending events if possible).
+func (a *AuditLog) Close() (err error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.key != nil && a.pending > 0 {
+		err = a.anchor()
+	}
+	if e := a.log.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+// record creates the next record in the chain.
+func (a *AuditLog) record(kind string, fields map[string]any) *AuditRecord {
+	return &AuditRecord{
+		Seq:    a.seq,
+		Time:   time.Now().UTC(),
+		Kind:   kind,
+		Fields: fields,
+		Prev:   a.head,
+	}
+}
+
+// anchor writes a signed anchor record (lock is held).
+func (a *AuditLog) anchor() error {
+	if a.key == nil {
+		return ErrAuditNoKey
+	}
+	rec := a.record(AuditAnchor, nil)
+	msg, err := data.CanonicalJSON(rec)
+	if err != nil {
+		return err
+	}
+	sig, err := a.key.EdSign(msg)
+	if err != nil {
+		return err
+	}
+	rec.Sig = sig.Bytes()
+	if err = a.append(rec); err != nil {
+		return err
+	}
+	a.pending = 0
+	return nil
+}
+
+// append a record to the log and advance the chain (lock is held).
+func (a *AuditLog) append(rec *AuditRecord) error {
+	raw, err := data.CanonicalJSON(rec)
+	if err != nil {
+		return err
+	}
+	if err = a.log.AppendRaw(raw); err != nil {
+		return err
+	}
+	h := sha256.Sum256(raw)
+	a.head = h[:]
+	a.seq++
+	return nil
+}
+
+// VerifyAuditLog checks the hash chain of an audit log in a directory
+// and the anchor signatures (if a public key is given).
+func VerifyAuditLog(dir string, pub *ed25519.PublicKey) (*AuditReport, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	log, err := data.OpenAppendLog(dir, AuditSegmentSize)
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+	return verifyAudit(log, pub)
+}
+
+// verifyAudit replays a log and checks its records.
+func verifyAudit(log *data.AppendLog, pub *ed25519.PublicKey) (rpt *AuditReport, err error) {
+	rpt = new(AuditReport)
+	var seq uint64
+	err = log.Replay(func(raw []byte) (err error) {
+		rec := new(AuditRecord)
+		if err = json.Unmarshal(raw, rec); err != nil {
+			return gerr.New(data.ErrLogCorrupt, "record #%d: %s", seq, err.Error())
+		}
+		if rec.Seq != seq {
+			return gerr.New(ErrAuditSequence, "record #%d: found #%d", seq, rec.Seq)
+		}
+		if !bytes.Equal(rec.Prev, rpt.Head) {
+			return gerr.New(ErrAuditChain, "record #%d", seq)
+		}
+		if rec.Kind == AuditAnchor {
+			if pub != nil {
+				if err = verifyAnchor(rec, pub); err != nil {
+					return gerr.New(err, "record #%d", seq)
+				}
+			}
+			rpt.Anchors++
+			rpt.Unanchored = 0
+		} else {
+			rpt.Events++
+			rpt.Unanchored++
+		}
+		h := sha256.Sum256(raw)
+		rpt.Head = h[:]
+		seq++
+		return nil
+	})
+	if err != nil {
+		rpt = nil
+	}
+	return
+}
+
+// verifyAnchor checks the signature of an anchor record.
+func verifyAnchor(rec *AuditRecord, pub *ed25519.PublicKey) error {
+	sig, err := ed25519.NewEdSignatureFromBytes(rec.Sig)
+	if err != nil {
+		return ErrAuditSignature
+	}
+	unsigned := *rec
+	unsigned.Sig = nil
+	msg, err := data.CanonicalJSON(&unsigned)
+	if err != nil {
+		return err
+	}
+	if ok, err := pub.EdVerify(msg, sig); err != nil || !ok {
+		return ErrAuditSignature
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package logger
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/data"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "audit")
+	pub, prv := ed25519.NewKeypair()
+
+	a, err := OpenAuditLog(dir, prv, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = a.Event("wallet.spend", map[string]any{"txid": "abcd", "amount": 100000 + i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// re-open and continue the chain
+	if a, err = OpenAuditLog(dir, prv, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Event("node.admin", map[string]any{"action": "ban", "peer": "1234"}); err != nil {
+		t.Fatal(err)
+	}
+	head := a.Head()
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rpt, err := VerifyAuditLog(dir, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rpt.Events != 4 || rpt.Anchors != 3 || rpt.Unanchored != 0 {
+		t.Fatalf("wrong report: %+v", rpt)
+	}
+	// head changed by the final anchor
+	if string(rpt.Head) == string(head) {
+		t.Fatal("missing final anchor")
+	}
+	// wrong key
+	other, _ := ed25519.NewKeypair()
+	if _, err = VerifyAuditLog(dir, other); !errors.Is(err, ErrAuditSignature) {
+		t.Fatalf("expected signature error: %v", err)
+	}
+}
+
+// forgeAudit copies an audit log and modifies the first event; if
+// 'rechain' is set, the digests of all following records are updated.
+func forgeAudit(t *testing.T, src string, rechain bool) string {
+	t.Helper()
+	var recs [][]byte
+	in, err := data.OpenAppendLog(src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = in.Replay(func(raw []byte) error {
+		recs = append(recs, append([]byte{}, raw...))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	in.Close()
+
+	dst := filepath.Join(t.TempDir(), "forged")
+	out, err := data.OpenAppendLog(dst, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	var prev []byte
+	for i, raw := range recs {
+		if i == 0 || rechain {
+			rec := new(AuditRecord)
+			if err = json.Unmarshal(raw, rec); err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				rec.Fields["amount"] = 1
+			}
+			rec.Prev = prev
+			if raw, err = data.CanonicalJSON(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = out.AppendRaw(raw); err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.Sum256(raw)
+		prev = h[:]
+	}
+	return dst
+}
+
+func TestAuditLogTamper(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "audit")
+	pub, prv := ed25519.NewKeypair()
+	a, err := OpenAuditLog(dir, prv, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = a.Event("wallet.spend", map[string]any{"amount": 1000 * (i + 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// modified record breaks the chain
+	forged := forgeAudit(t, dir, false)
+	if _, err = VerifyAuditLog(forged, nil); !errors.Is(err, ErrAuditChain) {
+		t.Fatalf("expected chain error: %v", err)
+	}
+	if _, err = OpenAuditLog(forged, nil, 0); !errors.Is(err, ErrAuditChain) {
+		t.Fatalf("expected chain error on open: %v", err)
+	}
+	// re-chained log is consistent but the anchor signature fails
+	forged = forgeAudit(t, dir, true)
+	if _, err = VerifyAuditLog(forged, nil); err != nil {
+		t.Fatalf("re-chained log: %v", err)
+	}
+	if _, err = VerifyAuditLog(forged, pub); !errors.Is(err, ErrAuditSignature) {
+		t.Fatalf("expected signature error: %v", err)
+	}
+}
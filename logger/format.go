@@ -58,3 +58,9 @@ func ColorFormat(msg *logMsg) string {
 	txt = strings.Trim(txt, "\n")
 	return fmt.Sprintf("\033[01;%dm%s\033[01;0m\n", col, txt)
 }
+
+// PlainFormat only returns the message text (for sinks like syslog that
+// add timestamp and level themselves)
+func PlainFormat(msg *logMsg) string {
+	return strings.Trim(msg.text, "\n")
+}
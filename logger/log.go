@@ -23,6 +23,8 @@ package logger
 import (
 	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -57,10 +59,14 @@ type logger struct {
 	cmdChan   chan int     // commands to be executed
 	logfile   *os.File     // current log file (can be stdout/stderr)
 	started   time.Time    // start time of current log file
-	level     int          // current log level
+	level     atomic.Int32 // current log level
 	lastMsg   *logMsg      // last log message
 	repeats   int          // number of repeats of last message
 	formatter Formatter    // log message formatter
+
+	sinks    map[string]*sink // additional log destinations
+	sinkMax  atomic.Int32     // max. log level of all sinks
+	sinkLock sync.RWMutex     // lock for sink list
 }
 
 var (
@@ -74,10 +80,12 @@ func init() {
 	logInst.cmdChan = make(chan int)
 	logInst.logfile = os.Stdout
 	logInst.started = time.Now()
-	logInst.level = DBG
+	logInst.level.Store(DBG)
 	logInst.lastMsg = &logMsg{}
 	logInst.repeats = 0
 	logInst.formatter = SimpleFormat
+	logInst.sinks = make(map[string]*sink)
+	logInst.sinkMax.Store(-1)
 
 	go func() {
 		for {
@@ -93,13 +101,11 @@ func init() {
 						text:  fmt.Sprintf("...(last message repeated %d times)\n", logInst.repeats),
 						ts:    time.Now(),
 					}
-					s := logInst.formatter(rep)
-					_, _ = logInst.logfile.WriteString(s)
+					logInst.write(rep)
 				}
 				logInst.repeats = 0
 				logInst.lastMsg = msg
-				s := logInst.formatter(msg)
-				_, _ = logInst.logfile.WriteString(s)
+				logInst.write(msg)
 			case cmd := <-logInst.cmdChan:
 				switch cmd {
 				case ROTATE:
@@ -114,9 +120,10 @@ func init() {
 								logInst.started = time.Now()
 							}
 						}
-					} else {
+					} else if len(Sinks()) == 0 {
 						Println(WARN, "[log] log rotation for 'stdout' not applicable.")
 					}
+					logInst.rotateSinks()
 				case FLUSH:
 					// Flush log messages: Llog messages have been processed
 					// before this command is handled but repetition might
@@ -132,9 +139,22 @@ func init() {
 	}()
 }
 
+// write a log message to all destinations that accept its level.
+func (l *logger) write(msg *logMsg) {
+	if msg.level <= int(l.level.Load()) {
+		_, _ = l.logfile.WriteString(l.formatter(msg))
+	}
+	l.writeSinks(msg)
+}
+
+// enabled returns true if a message of given level is logged at all.
+func (l *logger) enabled(level int) bool {
+	return level <= int(l.level.Load()) || level <= int(l.sinkMax.Load())
+}
+
 // Println punches logging data for given level.
 func Println(level int, line string) {
-	if level <= logInst.level {
+	if logInst.enabled(level) {
 		logInst.msgChan <- &logMsg{
 			level: level,
 			text:  line,
@@ -145,7 +165,7 @@ func Println(level int, line string) {
 
 // Printf punches formatted logging data for givel level
 func Printf(level int, format string, v ...interface{}) {
-	if level <= logInst.level {
+	if logInst.enabled(level) {
 		logInst.msgChan <- &logMsg{
 			level: level,
 			text:  fmt.Sprintf(format, v...),
@@ -188,12 +208,12 @@ func Flush() {
 
 // GetLogLevel returns a numeric log level.
 func GetLogLevel() int {
-	return logInst.level
+	return int(logInst.level.Load())
 }
 
 // GetLogLevelName returns the current loglevel in human-readable form.
 func GetLogLevelName() string {
-	switch logInst.level {
+	switch int(logInst.level.Load()) {
 	case CRITICAL:
 		return "CRITICAL"
 	case SEVERE:
@@ -215,27 +235,35 @@ func SetLogLevel(lvl int) {
 	if lvl < CRITICAL || lvl > DBG {
 		Printf(WARN, "[logger] Unknown loglevel '%d' requested -- ignored.\n", lvl)
 	}
-	logInst.level = lvl
+	logInst.level.Store(int32(lvl))
 }
 
 // SetLogLevelFromName sets the logging level from symbolic name.
 func SetLogLevelFromName(name string) {
+	if lvl, ok := levelFromName(name); ok {
+		logInst.level.Store(int32(lvl))
+		return
+	}
+	Println(WARN, "[logger] Unknown loglevel '"+name+"' requested.")
+}
+
+// levelFromName returns the numeric log level for a symbolic name.
+func levelFromName(name string) (int, bool) {
 	switch name {
 	case "CRITICAL":
-		logInst.level = CRITICAL
+		return CRITICAL, true
 	case "SEVERE":
-		logInst.level = SEVERE
+		return SEVERE, true
 	case "ERROR":
-		logInst.level = ERROR
+		return ERROR, true
 	case "WARN":
-		logInst.level = WARN
+		return WARN, true
 	case "INFO":
-		logInst.level = INFO
+		return INFO, true
 	case "DBG":
-		logInst.level = DBG
-	default:
-		Println(WARN, "[logger] Unknown loglevel '"+name+"' requested.")
+		return DBG, true
 	}
+	return 0, false
 }
 
 // GetTag returns the loglevel tag as prefix for message
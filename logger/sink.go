@@ -0,0 +1,320 @@
+package logger
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	gerr "github.com/bfix/gospel/errors"
+)
+
+// Error codes
+var (
+	ErrSinkUnknown     = errors.New("unknown log sink")
+	ErrSinkSpec        = errors.New("invalid log sink specification")
+	ErrSinkUnsupported = errors.New("log sink not supported on this platform")
+)
+
+//----------------------------------------------------------------------
+// Log sinks: additional destinations for log messages (besides the
+// default output on stdout or log file). Each sink has its own level
+// filter and format and can be added, changed or removed at runtime.
+//----------------------------------------------------------------------
+
+// SinkWriter writes formatted log messages of given level.
+type SinkWriter interface {
+	WriteLog(level int, text string) error
+	Close() error
+}
+
+// sink is a named log destination
+type sink struct {
+	level  int        // max. level of messages
+	format Formatter  // message formatter
+	out    SinkWriter // output writer
+}
+
+// AddSink adds (or replaces) a named sink that logs messages up to the
+// given level. If no formatter is specified, SimpleFormat is used.
+func AddSink(name string, level int, format Formatter, out SinkWriter) {
+	if format == nil {
+		format = SimpleFormat
+	}
+	logInst.sinkLock.Lock()
+	if old, ok := logInst.sinks[name]; ok {
+		_ = old.out.Close()
+	}
+	logInst.sinks[name] = &sink{
+		level:  level,
+		format: format,
+		out:    out,
+	}
+	logInst.updateSinkLevel()
+	logInst.sinkLock.Unlock()
+}
+
+// RemoveSink closes and removes a named sink.
+func RemoveSink(name string) error {
+	logInst.sinkLock.Lock()
+	defer logInst.sinkLock.Unlock()
+	s, ok := logInst.sinks[name]
+	if !ok {
+		return ErrSinkUnknown
+	}
+	delete(logInst.sinks, name)
+	logInst.updateSinkLevel()
+	return s.out.Close()
+}
+
+// SetSinkLevel changes the log level of a named sink.
+func SetSinkLevel(name string, level int) error {
+	logInst.sinkLock.Lock()
+	defer logInst.sinkLock.Unlock()
+	s, ok := logInst.sinks[name]
+	if !ok {
+		return ErrSinkUnknown
+	}
+	s.level = level
+	logInst.updateSinkLevel()
+	return nil
+}
+
+// Sinks returns the (sorted) names of all sinks.
+func Sinks() (names []string) {
+	logInst.sinkLock.RLock()
+	defer logInst.sinkLock.RUnlock()
+	for name := range logInst.sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// updateSinkLevel computes the max. level of all sinks (lock is held).
+func (l *logger) updateSinkLevel() {
+	max := -1
+	for _, s := range l.sinks {
+		if s.level > max {
+			max = s.level
+		}
+	}
+	l.sinkMax.Store(int32(max))
+}
+
+// writeSinks writes a message to all sinks that accept its level.
+func (l *logger) writeSinks(msg *logMsg) {
+	l.sinkLock.RLock()
+	defer l.sinkLock.RUnlock()
+	for _, s := range l.sinks {
+		if msg.level <= s.level {
+			_ = s.out.WriteLog(msg.level, s.format(msg))
+		}
+	}
+}
+
+// rotateSinks rotates all sinks that support it.
+func (l *logger) rotateSinks() {
+	l.sinkLock.RLock()
+	defer l.sinkLock.RUnlock()
+	for _, s := range l.sinks {
+		if r, ok := s.out.(interface{ Rotate() error }); ok {
+			_ = r.Rotate()
+		}
+	}
+}
+
+//----------------------------------------------------------------------
+// Stream sink (e.g. stderr)
+//----------------------------------------------------------------------
+
+// StreamSink writes log messages to a stream; the stream is not closed
+// when the sink is removed.
+type StreamSink struct {
+	w io.Writer
+}
+
+// NewStreamSink creates a sink writing to a stream.
+func NewStreamSink(w io.Writer) *StreamSink {
+	return &StreamSink{w: w}
+}
+
+// WriteLog writes a log message to the stream.
+func (s *StreamSink) WriteLog(level int, text string) error {
+	_, err := io.WriteString(s.w, text)
+	return err
+}
+
+// Close the sink.
+func (s *StreamSink) Close() error {
+	return nil
+}
+
+//----------------------------------------------------------------------
+// File sink with rotation
+//----------------------------------------------------------------------
+
+// FileSink writes log messages to a file. The file is rotated when its
+// size exceeds a limit (or on request); a number of rotated files is
+// kept as "<name>.1" (most recent) to "<name>.<backups>".
+type FileSink struct {
+	sync.Mutex
+	name    string   // name of log file
+	file    *os.File // current log file
+	size    int64    // current file size
+	maxSize int64    // max. file size (0: no limit)
+	backups int      // number of rotated files to keep
+}
+
+// NewFileSink opens (or creates) a log file for appending messages.
+func NewFileSink(name string, maxSize int64, backups int) (s *FileSink, err error) {
+	s = &FileSink{
+		name:    name,
+		maxSize: maxSize,
+		backups: backups,
+	}
+	if err = s.open(); err != nil {
+		s = nil
+	}
+	return
+}
+
+// open the log file
+func (s *FileSink) open() (err error) {
+	if s.file, err = os.OpenFile(s.name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+		return
+	}
+	var fi os.FileInfo
+	if fi, err = s.file.Stat(); err == nil {
+		s.size = fi.Size()
+	}
+	return
+}
+
+// WriteLog appends a log message to the file (rotating it if required).
+func (s *FileSink) WriteLog(level int, text string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(text)) > s.maxSize {
+		if err = s.rotate(); err != nil {
+			return
+		}
+	}
+	var n int
+	n, err = s.file.WriteString(text)
+	s.size += int64(n)
+	return
+}
+
+// Rotate the log file.
+func (s *FileSink) Rotate() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.rotate()
+}
+
+// rotate the log file (lock is held)
+func (s *FileSink) rotate() (err error) {
+	if err = s.file.Close(); err != nil {
+		return
+	}
+	if s.backups > 0 {
+		// shift backup files
+		for i := s.backups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", s.name, i), fmt.Sprintf("%s.%d", s.name, i+1))
+		}
+		if err = os.Rename(s.name, s.name+".1"); err != nil {
+			return
+		}
+	} else if err = os.Truncate(s.name, 0); err != nil {
+		return
+	}
+	return s.open()
+}
+
+// Close the log file.
+func (s *FileSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.file.Close()
+}
+
+//----------------------------------------------------------------------
+// Sink configuration
+//----------------------------------------------------------------------
+
+// AddSinks adds sinks from a comma-separated list of specifications in
+// the form "<kind>:<level>[:<arg>]" like "stderr:INFO,file:DBG:node.log":
+//   - "stdout" and "stderr" (no argument)
+//   - "file" (argument is the file name; required)
+//   - "syslog" and "journal" (argument is the tag; optional)
+//
+// Sinks are named after their kind.
+func AddSinks(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) < 2 {
+			return gerr.New(ErrSinkSpec, "'%s'", entry)
+		}
+		level, ok := levelFromName(parts[1])
+		if !ok {
+			return gerr.New(ErrSinkSpec, "unknown level '%s'", parts[1])
+		}
+		arg := ""
+		if len(parts) == 3 {
+			arg = parts[2]
+		}
+		var (
+			out    SinkWriter
+			format Formatter = SimpleFormat
+			err    error
+		)
+		switch parts[0] {
+		case "stdout":
+			out = NewStreamSink(os.Stdout)
+		case "stderr":
+			out = NewStreamSink(os.Stderr)
+		case "file":
+			if len(arg) == 0 {
+				return gerr.New(ErrSinkSpec, "missing file name")
+			}
+			out, err = NewFileSink(arg, 0, 0)
+		case "syslog":
+			out, err = NewSyslogSink(arg)
+			format = PlainFormat
+		case "journal":
+			out, err = NewJournalSink(arg)
+			format = PlainFormat
+		default:
+			return gerr.New(ErrSinkSpec, "unknown sink '%s'", parts[0])
+		}
+		if err != nil {
+			return err
+		}
+		AddSink(parts[0], level, format, out)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package logger
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// JournalSocket is the default socket of the systemd journal.
+var JournalSocket = "/run/systemd/journal/socket"
+
+// JournalSink writes log messages to the systemd journal (using the
+// native journal protocol).
+type JournalSink struct {
+	conn net.Conn
+	tag  string
+}
+
+// NewJournalSink connects to the systemd journal; messages are tagged
+// with 'tag' (program name if empty).
+func NewJournalSink(tag string) (*JournalSink, error) {
+	if len(tag) == 0 {
+		tag = filepath.Base(os.Args[0])
+	}
+	conn, err := net.Dial("unixgram", JournalSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &JournalSink{conn: conn, tag: tag}, nil
+}
+
+// WriteLog sends a log message with matching priority to the journal.
+func (s *JournalSink) WriteLog(level int, text string) error {
+	buf := new(bytes.Buffer)
+	journalField(buf, "PRIORITY", strconv.Itoa(syslogPriority(level)))
+	journalField(buf, "SYSLOG_IDENTIFIER", s.tag)
+	journalField(buf, "MESSAGE", text)
+	_, err := s.conn.Write(buf.Bytes())
+	return err
+}
+
+// Close the connection to the journal.
+func (s *JournalSink) Close() error {
+	return s.conn.Close()
+}
+
+// journalField encodes a field in the native journal protocol: values
+// with newlines are written in binary form (with explicit length).
+func journalField(buf *bytes.Buffer, key, val string) {
+	buf.WriteString(key)
+	if strings.ContainsRune(val, '\n') {
+		buf.WriteByte('\n')
+		_ = binary.Write(buf, binary.LittleEndian, uint64(len(val)))
+	} else {
+		buf.WriteByte('=')
+	}
+	buf.WriteString(val)
+	buf.WriteByte('\n')
+}
+
+// syslogPriority maps a log level to a syslog priority.
+func syslogPriority(level int) int {
+	switch level {
+	case CRITICAL:
+		return 1
+	case SEVERE:
+		return 2
+	case ERROR:
+		return 3
+	case WARN:
+		return 4
+	case INFO:
+		return 6
+	}
+	return 7
+}
@@ -0,0 +1,64 @@
+//go:build !windows && !plan9
+
+package logger
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"log/syslog"
+)
+
+// SyslogSink writes log messages to the system logger.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to the local system logger; messages are
+// tagged with 'tag' (program name if empty).
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+// WriteLog sends a log message with matching syslog severity.
+func (s *SyslogSink) WriteLog(level int, text string) error {
+	switch level {
+	case CRITICAL:
+		return s.w.Alert(text)
+	case SEVERE:
+		return s.w.Crit(text)
+	case ERROR:
+		return s.w.Err(text)
+	case WARN:
+		return s.w.Warning(text)
+	case INFO:
+		return s.w.Info(text)
+	}
+	return s.w.Debug(text)
+}
+
+// Close the connection to the system logger.
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,164 @@
+package logger
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a buffer safe for concurrent use
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestSinkLevels(t *testing.T) {
+	level := GetLogLevel()
+	defer SetLogLevel(level)
+	SetLogLevel(ERROR)
+	Flush()
+
+	dbg, warn := new(syncBuffer), new(syncBuffer)
+	AddSink("dbg", DBG, PlainFormat, NewStreamSink(dbg))
+	AddSink("warn", WARN, nil, NewStreamSink(warn))
+	defer func() {
+		_ = RemoveSink("dbg")
+		_ = RemoveSink("warn")
+	}()
+	Println(DBG, "sink debug message")
+	Println(WARN, "sink warning message")
+	Flush()
+	if s := dbg.String(); s != "sink debug messagesink warning message" {
+		t.Fatalf("debug sink: '%s'", s)
+	}
+	if s := warn.String(); strings.Contains(s, "debug") || !strings.Contains(s, "[WRN] sink warning message") {
+		t.Fatalf("warning sink: '%s'", s)
+	}
+	// change level at runtime
+	if err := SetSinkLevel("dbg", INFO); err != nil {
+		t.Fatal(err)
+	}
+	Println(DBG, "sink suppressed message")
+	Flush()
+	if strings.Contains(dbg.String(), "suppressed") {
+		t.Fatal("message not filtered")
+	}
+	if err := SetSinkLevel("none", INFO); !errors.Is(err, ErrSinkUnknown) {
+		t.Fatalf("expected unknown sink: %v", err)
+	}
+	if names := Sinks(); len(names) != 2 || names[0] != "dbg" || names[1] != "warn" {
+		t.Fatalf("wrong sinks: %v", names)
+	}
+}
+
+func TestFileSinkRotate(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.log")
+	s, err := NewFileSink(name, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, msg := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if err = s.WriteLog(INFO, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for suffix, exp := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		data, err := os.ReadFile(name + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != exp {
+			t.Fatalf("'%s': '%s'", suffix, data)
+		}
+	}
+	if _, err = os.Stat(name + ".3"); err == nil {
+		t.Fatal("too many backups")
+	}
+}
+
+func TestJournalSink(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "journal")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	old := JournalSocket
+	JournalSocket = sock
+	defer func() { JournalSocket = old }()
+
+	s, err := NewJournalSink("gospel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.WriteLog(WARN, "two\nlines"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "PRIORITY=4\nSYSLOG_IDENTIFIER=gospel\nMESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\n"
+	if string(buf[:n]) != exp {
+		t.Fatalf("wrong datagram: %q", buf[:n])
+	}
+}
+
+func TestAddSinks(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "spec.log")
+	if err := AddSinks("stderr:INFO, file:DBG:" + name); err != nil {
+		t.Fatal(err)
+	}
+	Println(DBG, "sink spec message")
+	Flush()
+	_ = RemoveSink("stderr")
+	_ = RemoveSink("file")
+	if data, err := os.ReadFile(name); err != nil || !strings.Contains(string(data), "sink spec message") {
+		t.Fatalf("file sink: '%s', %v", data, err)
+	}
+	for _, spec := range []string{"stderr", "stderr:LOUD", "file:DBG", "pipe:INFO"} {
+		if err := AddSinks(spec); !errors.Is(err, ErrSinkSpec) {
+			t.Fatalf("'%s': expected spec error: %v", spec, err)
+		}
+	}
+}
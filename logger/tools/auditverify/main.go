@@ -0,0 +1,63 @@
+package main
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/logger"
+)
+
+func main() {
+	// handle command-line arguments
+	var key string
+	flag.StringVar(&key, "k", "", "public Ed25519 key of signer (hex)")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: auditverify [-k <pubkey>] <log directory>")
+		os.Exit(1)
+	}
+	var pub *ed25519.PublicKey
+	if len(key) > 0 {
+		buf, err := hex.DecodeString(key)
+		if err != nil || len(buf) != 32 {
+			fmt.Println("<<< ERROR: invalid public key")
+			os.Exit(1)
+		}
+		pub = ed25519.NewPublicKeyFromBytes(buf)
+	} else {
+		fmt.Println("<<< WARNING: no public key -- anchor signatures not checked")
+	}
+	// verify audit log
+	rpt, err := logger.VerifyAuditLog(flag.Arg(0), pub)
+	if err != nil {
+		fmt.Println("<<< ERROR: " + err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("<<<     Events: %d\n", rpt.Events)
+	fmt.Printf("<<<    Anchors: %d\n", rpt.Anchors)
+	fmt.Printf("<<< Unanchored: %d\n", rpt.Unanchored)
+	fmt.Printf("<<<       Head: %s\n", hex.EncodeToString(rpt.Head))
+}
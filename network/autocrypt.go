@@ -0,0 +1,272 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+//======================================================================
+// Autocrypt (Level 1): opportunistic end-to-end encryption of mail.
+// Senders announce their public key in an "Autocrypt" header; receivers
+// track the state of peers and derive a recommendation whether to
+// encrypt messages to a peer (see https://autocrypt.org/level1.html).
+//======================================================================
+
+// Error codes
+var (
+	ErrAutocryptHeader = errors.New("invalid Autocrypt header")
+	ErrAutocryptKey    = errors.New("invalid Autocrypt key")
+)
+
+// Autocrypt parameters
+const (
+	AutocryptStale = 35 * 24 * time.Hour // age of a key considered stale
+)
+
+// Autocrypt preferences of peers
+const (
+	AutocryptNoPreference = "nopreference"
+	AutocryptMutual       = "mutual"
+	AutocryptReset        = "reset"
+)
+
+// Autocrypt recommendations for outgoing mail
+const (
+	AutocryptDisable    = iota // no key available
+	AutocryptDiscourage        // key available but stale
+	AutocryptAvailable         // encryption possible
+	AutocryptEncrypt           // encryption recommended
+)
+
+//----------------------------------------------------------------------
+// Autocrypt header
+//----------------------------------------------------------------------
+
+// AutocryptHeader is the content of an "Autocrypt" mail header
+type AutocryptHeader struct {
+	Addr          string // email address of the key owner
+	PreferEncrypt bool   // "prefer-encrypt=mutual"?
+	KeyData       []byte // binary OpenPGP public key (transferable)
+}
+
+// NewAutocryptHeader creates a header for the public key of an entity.
+func NewAutocryptHeader(ent *openpgp.Entity, addr string, mutual bool) (*AutocryptHeader, error) {
+	buf := new(bytes.Buffer)
+	if err := ent.Serialize(buf); err != nil {
+		return nil, err
+	}
+	return &AutocryptHeader{
+		Addr:          addr,
+		PreferEncrypt: mutual,
+		KeyData:       buf.Bytes(),
+	}, nil
+}
+
+// ParseAutocryptHeader parses the value of an "Autocrypt" header.
+// Unknown critical attributes (not starting with '_') invalidate the
+// header; the key data must be a valid OpenPGP public key.
+func ParseAutocryptHeader(val string) (h *AutocryptHeader, err error) {
+	h = new(AutocryptHeader)
+	for _, attr := range strings.Split(val, ";") {
+		attr = strings.TrimSpace(attr)
+		if len(attr) == 0 {
+			continue
+		}
+		key, value, ok := strings.Cut(attr, "=")
+		if !ok {
+			return nil, ErrAutocryptHeader
+		}
+		switch strings.TrimSpace(key) {
+		case "addr":
+			h.Addr = strings.TrimSpace(value)
+		case "prefer-encrypt":
+			h.PreferEncrypt = strings.TrimSpace(value) == AutocryptMutual
+		case "keydata":
+			value = strings.Join(strings.Fields(value), "")
+			if h.KeyData, err = base64.StdEncoding.DecodeString(value); err != nil {
+				return nil, ErrAutocryptHeader
+			}
+		default:
+			// non-critical attributes are ignored
+			if !strings.HasPrefix(key, "_") {
+				return nil, ErrAutocryptHeader
+			}
+		}
+	}
+	if len(h.Addr) == 0 || len(h.KeyData) == 0 {
+		return nil, ErrAutocryptHeader
+	}
+	if _, err = h.Entity(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Entity returns the OpenPGP entity for the key data.
+func (h *AutocryptHeader) Entity() (*openpgp.Entity, error) {
+	list, err := openpgp.ReadKeyRing(bytes.NewReader(h.KeyData))
+	if err != nil || len(list) != 1 {
+		return nil, ErrAutocryptKey
+	}
+	return list[0], nil
+}
+
+// String returns the (folded) header value.
+func (h *AutocryptHeader) String() string {
+	var buf strings.Builder
+	buf.WriteString("addr=" + h.Addr + ";")
+	if h.PreferEncrypt {
+		buf.WriteString(" prefer-encrypt=" + AutocryptMutual + ";")
+	}
+	buf.WriteString(" keydata=")
+	enc := base64.StdEncoding.EncodeToString(h.KeyData)
+	for len(enc) > 0 {
+		n := 72
+		if n > len(enc) {
+			n = len(enc)
+		}
+		buf.WriteString("\r\n " + enc[:n])
+		enc = enc[n:]
+	}
+	return buf.String()
+}
+
+// autocryptFrom returns the valid Autocrypt header for a sender address
+// from a list of header values (nil if none or more than one is valid).
+func autocryptFrom(from string, values []string) (res *AutocryptHeader) {
+	for _, val := range values {
+		h, err := ParseAutocryptHeader(val)
+		if err != nil || !strings.EqualFold(h.Addr, from) {
+			continue
+		}
+		if res != nil {
+			// multiple valid headers: ignore all
+			return nil
+		}
+		res = h
+	}
+	return
+}
+
+//----------------------------------------------------------------------
+// Peer state
+//----------------------------------------------------------------------
+
+// AutocryptPeer is the Autocrypt state for a peer address
+type AutocryptPeer struct {
+	Addr          string    // peer address
+	LastSeen      time.Time // date of last message seen
+	Timestamp     time.Time // date of last message with Autocrypt header
+	KeyData       []byte    // public key of peer
+	PreferEncrypt string    // peer preference
+}
+
+// AutocryptStore keeps the Autocrypt state of peers
+type AutocryptStore struct {
+	peers map[string]*AutocryptPeer // peers (by lower-case address)
+	lock  sync.Mutex                // lock for concurrent access
+}
+
+// NewAutocryptStore creates a new (empty) peer state store.
+func NewAutocryptStore() *AutocryptStore {
+	return &AutocryptStore{
+		peers: make(map[string]*AutocryptPeer),
+	}
+}
+
+// Peer returns (a copy of) the state of a peer.
+func (s *AutocryptStore) Peer(addr string) (*AutocryptPeer, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p, ok := s.peers[strings.ToLower(addr)]
+	if !ok {
+		return nil, false
+	}
+	cp := *p
+	return &cp, true
+}
+
+// Update the state of a peer from an incoming message sent at 'date'
+// with a (possibly nil) Autocrypt header.
+func (s *AutocryptStore) Update(addr string, date time.Time, h *AutocryptHeader) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// messages from the future are dated now
+	if now := time.Now(); date.After(now) {
+		date = now
+	}
+	key := strings.ToLower(addr)
+	p, ok := s.peers[key]
+	if !ok {
+		if h == nil {
+			return
+		}
+		p = &AutocryptPeer{Addr: addr}
+		s.peers[key] = p
+	}
+	// ignore messages older than the last Autocrypt header
+	if ok && !date.After(p.Timestamp) {
+		return
+	}
+	if date.After(p.LastSeen) {
+		p.LastSeen = date
+	}
+	if h == nil {
+		p.PreferEncrypt = AutocryptReset
+		return
+	}
+	p.Timestamp = date
+	p.KeyData = h.KeyData
+	p.PreferEncrypt = AutocryptNoPreference
+	if h.PreferEncrypt {
+		p.PreferEncrypt = AutocryptMutual
+	}
+}
+
+// UpdateFrom updates the state of the sender of a parsed message.
+func (s *AutocryptStore) UpdateFrom(mc *MailContent) {
+	s.Update(mc.From, mc.Date, mc.Autocrypt)
+}
+
+// Recommend returns the recommendation for encrypting a message to a
+// peer; 'mutual' is the preference of the sender.
+func (s *AutocryptStore) Recommend(addr string, mutual bool) int {
+	p, ok := s.Peer(addr)
+	if !ok || len(p.KeyData) == 0 {
+		return AutocryptDisable
+	}
+	if p.Timestamp.Before(p.LastSeen.Add(-AutocryptStale)) {
+		return AutocryptDiscourage
+	}
+	if mutual && p.PreferEncrypt == AutocryptMutual {
+		return AutocryptEncrypt
+	}
+	return AutocryptAvailable
+}
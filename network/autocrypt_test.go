@@ -0,0 +1,134 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+func TestAutocryptHeader(t *testing.T) {
+	alice, err := openpgp.NewEntity("Alice", "", "alice@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewAutocryptHeader(alice, "alice@example.org", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(h.String(), "\r\n") {
+		if len(line) > 78 {
+			t.Fatal("header line too long")
+		}
+	}
+	msg, err := NewMessageBuilder().
+		From("Alice", "alice@example.org").
+		To("Bob", "bob@example.org").
+		Subject("Hello").
+		Autocrypt(h).
+		Text("Hello Bob!\n").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc, err := ParseMailMessage(bytes.NewReader(msg), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mc.Autocrypt == nil || !mc.Autocrypt.PreferEncrypt {
+		t.Fatal("Autocrypt header not parsed")
+	}
+	if !bytes.Equal(mc.Autocrypt.KeyData, h.KeyData) {
+		t.Fatal("key data mismatch")
+	}
+	if mc.Date.IsZero() {
+		t.Fatal("date not parsed")
+	}
+	// invalid headers
+	for _, val := range []string{
+		"addr=alice@example.org",
+		strings.Replace(h.String(), "keydata", "foo=bar; keydata", 1),
+		"addr=alice@example.org; keydata=AAAA",
+	} {
+		if _, err = ParseAutocryptHeader(val); err == nil {
+			t.Fatalf("invalid header accepted: %s", val)
+		}
+	}
+	// non-critical attribute and sender mismatch
+	val := "_foo=bar; " + h.String()
+	if autocryptFrom("alice@example.org", []string{val}) == nil {
+		t.Fatal("non-critical attribute not ignored")
+	}
+	if autocryptFrom("bob@example.org", []string{val}) != nil {
+		t.Fatal("sender mismatch accepted")
+	}
+	if autocryptFrom("alice@example.org", []string{val, val}) != nil {
+		t.Fatal("multiple headers accepted")
+	}
+}
+
+func TestAutocryptStore(t *testing.T) {
+	h := &AutocryptHeader{
+		Addr:          "bob@example.org",
+		PreferEncrypt: true,
+		KeyData:       []byte{1, 2, 3},
+	}
+	s := NewAutocryptStore()
+	addr := "Bob@Example.org"
+	if s.Recommend(addr, true) != AutocryptDisable {
+		t.Fatal("expected 'disable'")
+	}
+	t0 := time.Now().Add(-100 * 24 * time.Hour)
+	s.Update(addr, t0, h)
+	if s.Recommend(addr, true) != AutocryptEncrypt {
+		t.Fatal("expected 'encrypt'")
+	}
+	if s.Recommend(addr, false) != AutocryptAvailable {
+		t.Fatal("expected 'available'")
+	}
+	// older message without header: no change
+	s.Update(addr, t0.Add(-time.Hour), nil)
+	if p, _ := s.Peer(addr); p.PreferEncrypt != AutocryptMutual {
+		t.Fatal("state changed by older message")
+	}
+	// newer message without header: reset
+	s.Update(addr, t0.Add(time.Hour), nil)
+	if p, _ := s.Peer(addr); p.PreferEncrypt != AutocryptReset {
+		t.Fatal("expected 'reset' state")
+	}
+	if s.Recommend(addr, true) != AutocryptAvailable {
+		t.Fatal("expected 'available'")
+	}
+	// much later message without header: stale key
+	s.Update(addr, t0.Add(50*24*time.Hour), nil)
+	if s.Recommend(addr, true) != AutocryptDiscourage {
+		t.Fatal("expected 'discourage'")
+	}
+	// new header re-enables encryption
+	s.Update(addr, time.Now(), h)
+	if s.Recommend(addr, true) != AutocryptEncrypt {
+		t.Fatal("expected 'encrypt'")
+	}
+}
@@ -0,0 +1,203 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+//======================================================================
+// Dual-stack dialing with "Happy Eyeballs" (RFC 8305): all addresses
+// of a host are tried in interleaved address family order (IPv6 first);
+// a new connection attempt is started whenever the previous attempt
+// failed or a delay has passed. The first established connection wins.
+//======================================================================
+
+// Error codes
+var (
+	ErrHappyNoAddress = errors.New("no addresses for host")
+)
+
+// Happy Eyeballs parameters
+const (
+	HappyAttemptDelay = 250 * time.Millisecond // default attempt delay
+)
+
+// HappyDialer for dual-stack TCP connections
+type HappyDialer struct {
+	Delay     time.Duration // connection attempt delay (0: default)
+	Timeout   time.Duration // overall timeout (0: none)
+	KeepAlive time.Duration // keep-alive period (see net.Dialer)
+	Resolver  *net.Resolver // name resolver (nil: default resolver)
+
+	// hooks for name resolution and single connection attempts
+	// (for testing)
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+	dial   func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// HappyDial connects to an address using a default Happy Eyeballs dialer.
+func HappyDial(network, address string) (net.Conn, error) {
+	return new(HappyDialer).DialContext(context.Background(), network, address)
+}
+
+// Dial connects to an address.
+func (d *HappyDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to an address with given context. Only "tcp"
+// connections are raced; other networks are dialed directly.
+func (d *HappyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	if network != "tcp" {
+		return d.dialSingle(ctx, network, address)
+	}
+	// resolve host addresses
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	lookup := d.lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIPAddr
+		if d.Resolver != nil {
+			lookup = d.Resolver.LookupIPAddr
+		}
+	}
+	ips, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, ErrHappyNoAddress
+	}
+	addrs := HappySort(ips)
+
+	// race connection attempts
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result)
+	pending, next := 0, 0
+	start := func() {
+		endp := net.JoinHostPort(addrs[next].String(), port)
+		next++
+		pending++
+		go func() {
+			conn, err := d.dialSingle(ctx, "tcp", endp)
+			select {
+			case results <- result{conn, err}:
+			case <-ctx.Done():
+				// a winner was found already (or dialing is aborted)
+				if conn != nil {
+					conn.Close()
+				}
+			}
+		}()
+	}
+	delay := d.Delay
+	if delay <= 0 {
+		delay = HappyAttemptDelay
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	start()
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			// failed attempt: start next attempt immediately
+			if next < len(addrs) {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				start()
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				start()
+				timer.Reset(delay)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, firstErr
+}
+
+// dialSingle connects to a single address.
+func (d *HappyDialer) dialSingle(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.dial != nil {
+		return d.dial(ctx, network, addr)
+	}
+	dialer := &net.Dialer{
+		KeepAlive: d.KeepAlive,
+		Resolver:  d.Resolver,
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// HappySort returns the list of addresses in interleaved address family
+// order, starting with IPv6 (RFC 8305, section 4).
+func HappySort(addrs []net.IPAddr) (list []net.IPAddr) {
+	var v4, v6 []net.IPAddr
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			v4 = append(v4, a)
+		} else {
+			v6 = append(v6, a)
+		}
+	}
+	for len(v4) > 0 || len(v6) > 0 {
+		if len(v6) > 0 {
+			list = append(list, v6[0])
+			v6 = v6[1:]
+		}
+		if len(v4) > 0 {
+			list = append(list, v4[0])
+			v4 = v4[1:]
+		}
+	}
+	return
+}
@@ -0,0 +1,149 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHappySort(t *testing.T) {
+	var addrs []net.IPAddr
+	for _, s := range []string{"10.0.0.1", "10.0.0.2", "2001:db8::1", "10.0.0.3", "2001:db8::2"} {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(s)})
+	}
+	var list []string
+	for _, a := range HappySort(addrs) {
+		list = append(list, a.IP.String())
+	}
+	if strings.Join(list, ",") != "2001:db8::1,10.0.0.1,2001:db8::2,10.0.0.2,10.0.0.3" {
+		t.Fatalf("wrong order: %v", list)
+	}
+}
+
+// happyTest creates a dialer for a dual-stack host; connection attempts
+// to IPv6 addresses are handled by 'v6' and recorded.
+func happyTest(v6 func(ctx context.Context) error) (d *HappyDialer, attempts func() []string) {
+	var (
+		lock sync.Mutex
+		list []string
+	)
+	d = &HappyDialer{
+		Delay:   50 * time.Millisecond,
+		Timeout: 5 * time.Second,
+		lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			return []net.IPAddr{
+				{IP: net.ParseIP("2001:db8::1")},
+				{IP: net.ParseIP("127.0.0.1")},
+			}, nil
+		},
+	}
+	d.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		lock.Lock()
+		list = append(list, addr)
+		lock.Unlock()
+		if strings.HasPrefix(addr, "[") {
+			return nil, v6(ctx)
+		}
+		return new(net.Dialer).DialContext(ctx, network, addr)
+	}
+	attempts = func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, list...)
+	}
+	return
+}
+
+func TestHappyDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	endp := net.JoinHostPort("dual.example.org", port)
+
+	// IPv6 attempt hangs: IPv4 wins after the attempt delay
+	d, attempts := happyTest(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	start := time.Now()
+	conn, err := d.Dial("tcp", endp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if time.Since(start) < d.Delay {
+		t.Fatal("IPv4 attempt started too early")
+	}
+	if a := attempts(); len(a) != 2 || !strings.HasPrefix(a[0], "[2001:db8::1]") {
+		t.Fatalf("wrong attempts: %v", a)
+	}
+
+	// IPv6 attempt fails: IPv4 is tried immediately
+	d, _ = happyTest(func(ctx context.Context) error {
+		return errors.New("unreachable")
+	})
+	d.Delay = time.Hour
+	if conn, err = d.Dial("tcp", endp); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// all attempts fail: first error is reported
+	d, attempts = happyTest(func(ctx context.Context) error {
+		return errors.New("unreachable")
+	})
+	l.Close()
+	if _, err = d.Dial("tcp", endp); err == nil || err.Error() != "unreachable" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(attempts()) != 2 {
+		t.Fatal("not all addresses tried")
+	}
+}
+
+func TestHappyDialNoAddress(t *testing.T) {
+	d := &HappyDialer{
+		lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			return nil, nil
+		},
+	}
+	if _, err := d.Dial("tcp", "example.org:80"); !errors.Is(err, ErrHappyNoAddress) {
+		t.Fatalf("empty lookup not detected: %v", err)
+	}
+}
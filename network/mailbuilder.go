@@ -0,0 +1,500 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Error codes
+var (
+	ErrMailNoSender    = errors.New("mail sender missing")
+	ErrMailNoRecipient = errors.New("mail recipient missing")
+	ErrMailNoBody      = errors.New("mail body missing")
+	ErrMailHeader      = errors.New("invalid mail header")
+)
+
+//----------------------------------------------------------------------
+// Mail message builder: creates header-complete RFC 5322 messages with
+// RFC 2047 encoded headers, suitable transfer encodings for text and
+// binary parts, alternative (text/HTML) bodies, inline images
+// (multipart/related) and attachments (multipart/mixed).
+//----------------------------------------------------------------------
+
+// mailPart is a binary part of a message (inline image or attachment)
+type mailPart struct {
+	name  string // file name
+	ctype string // content type
+	cid   string // content identifier (inline parts)
+	data  []byte // content
+}
+
+// MessageBuilder assembles a mail message.
+type MessageBuilder struct {
+	from    *mail.Address        // sender
+	to      []*mail.Address      // recipients
+	cc      []*mail.Address      // carbon-copy recipients
+	bcc     []*mail.Address      // blind carbon-copy recipients
+	subject string               // subject line
+	date    time.Time            // date of message
+	header  textproto.MIMEHeader // additional headers
+	text    []byte               // plain text body
+	html    []byte               // HTML body
+	inline  []*mailPart          // inline parts (referenced by HTML)
+	attach  []*mailPart          // attachments
+}
+
+// NewMessageBuilder creates a new (empty) mail message builder.
+func NewMessageBuilder() *MessageBuilder {
+	return &MessageBuilder{
+		to:     make([]*mail.Address, 0),
+		cc:     make([]*mail.Address, 0),
+		bcc:    make([]*mail.Address, 0),
+		header: make(textproto.MIMEHeader),
+		inline: make([]*mailPart, 0),
+		attach: make([]*mailPart, 0),
+	}
+}
+
+// From sets the sender of the message.
+func (b *MessageBuilder) From(name, addr string) *MessageBuilder {
+	b.from = &mail.Address{Name: name, Address: addr}
+	return b
+}
+
+// To adds a recipient.
+func (b *MessageBuilder) To(name, addr string) *MessageBuilder {
+	b.to = append(b.to, &mail.Address{Name: name, Address: addr})
+	return b
+}
+
+// Cc adds a carbon-copy recipient.
+func (b *MessageBuilder) Cc(name, addr string) *MessageBuilder {
+	b.cc = append(b.cc, &mail.Address{Name: name, Address: addr})
+	return b
+}
+
+// Bcc adds a blind carbon-copy recipient (not listed in the headers).
+func (b *MessageBuilder) Bcc(name, addr string) *MessageBuilder {
+	b.bcc = append(b.bcc, &mail.Address{Name: name, Address: addr})
+	return b
+}
+
+// Subject sets the subject line.
+func (b *MessageBuilder) Subject(subj string) *MessageBuilder {
+	b.subject = subj
+	return b
+}
+
+// Date sets the date of the message (default: time of build).
+func (b *MessageBuilder) Date(t time.Time) *MessageBuilder {
+	b.date = t
+	return b
+}
+
+// Header sets an additional header (non-ASCII values are encoded). Header
+// names must be RFC 5322 field names; values must not contain line
+// breaks other than folding (checked on build).
+func (b *MessageBuilder) Header(key, value string) *MessageBuilder {
+	b.header.Set(key, value)
+	return b
+}
+
+// Autocrypt sets the Autocrypt header announcing the sender key.
+func (b *MessageBuilder) Autocrypt(h *AutocryptHeader) *MessageBuilder {
+	b.header.Set("Autocrypt", h.String())
+	return b
+}
+
+// Text sets the plain text body.
+func (b *MessageBuilder) Text(body string) *MessageBuilder {
+	b.text = []byte(body)
+	return b
+}
+
+// HTML sets the HTML body; inline parts are referenced by "cid:<id>".
+func (b *MessageBuilder) HTML(body string) *MessageBuilder {
+	b.html = []byte(body)
+	return b
+}
+
+// Inline adds an inline part (like an image) referenced from the HTML
+// body by "cid:<id>".
+func (b *MessageBuilder) Inline(cid, name, ctype string, data []byte) *MessageBuilder {
+	b.inline = append(b.inline, &mailPart{name: name, ctype: ctype, cid: cid, data: data})
+	return b
+}
+
+// Attach adds an attachment.
+func (b *MessageBuilder) Attach(name, ctype string, data []byte) *MessageBuilder {
+	b.attach = append(b.attach, &mailPart{name: name, ctype: ctype, data: data})
+	return b
+}
+
+// Recipients returns the envelope recipients (To, Cc and Bcc).
+func (b *MessageBuilder) Recipients() []string {
+	list := make([]string, 0, len(b.to)+len(b.cc)+len(b.bcc))
+	for _, grp := range [][]*mail.Address{b.to, b.cc, b.bcc} {
+		for _, a := range grp {
+			list = append(list, a.Address)
+		}
+	}
+	return list
+}
+
+// Build the message (with CRLF line endings) that can be sent as-is
+// by SendMailMessage.
+func (b *MessageBuilder) Build() ([]byte, error) {
+	if b.from == nil {
+		return nil, ErrMailNoSender
+	}
+	if len(b.to)+len(b.cc)+len(b.bcc) == 0 {
+		return nil, ErrMailNoRecipient
+	}
+	if b.text == nil && b.html == nil {
+		return nil, ErrMailNoBody
+	}
+	for _, p := range b.inline {
+		if strings.ContainsAny(p.cid, "\r\n<>") {
+			return nil, ErrMailHeader
+		}
+	}
+	// assemble message header
+	date := b.date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("From", b.from.String())
+	if len(b.to) > 0 {
+		hdr.Set("To", addrList(b.to))
+	}
+	if len(b.cc) > 0 {
+		hdr.Set("Cc", addrList(b.cc))
+	}
+	hdr.Set("Subject", encodeHeader(b.subject))
+	hdr.Set("Date", date.Format(time.RFC1123Z))
+	msgID, err := messageID(b.from.Address)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Set("Message-ID", msgID)
+	hdr.Set("MIME-Version", "1.0")
+	for k, v := range b.header {
+		for _, val := range v {
+			hdr.Add(k, encodeHeader(val))
+		}
+	}
+	// assemble body
+	buf := new(bytes.Buffer)
+	if err = b.writeMixed(&mailTarget{w: buf, hdr: hdr}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//----------------------------------------------------------------------
+// internal methods
+//----------------------------------------------------------------------
+
+// writeMixed writes the message with attachments
+func (b *MessageBuilder) writeMixed(t *mailTarget) error {
+	if len(b.attach) == 0 {
+		return b.writeRelated(t)
+	}
+	return t.multipart("multipart/mixed", func(mw *multipart.Writer) error {
+		if err := b.writeRelated(&mailTarget{mw: mw}); err != nil {
+			return err
+		}
+		for _, a := range b.attach {
+			if err := writeBinary(mw, a, "attachment"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeRelated writes the body with inline parts
+func (b *MessageBuilder) writeRelated(t *mailTarget) error {
+	if len(b.inline) == 0 || b.html == nil {
+		return b.writeAlternative(t)
+	}
+	return t.multipart("multipart/related; type=\"text/html\"", func(mw *multipart.Writer) error {
+		if err := b.writeAlternative(&mailTarget{mw: mw}); err != nil {
+			return err
+		}
+		for _, p := range b.inline {
+			if err := writeBinary(mw, p, "inline"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeAlternative writes the text and/or HTML body
+func (b *MessageBuilder) writeAlternative(t *mailTarget) error {
+	if b.text != nil && b.html != nil {
+		return t.multipart("multipart/alternative", func(mw *multipart.Writer) error {
+			if err := (&mailTarget{mw: mw}).text("text/plain", b.text); err != nil {
+				return err
+			}
+			return (&mailTarget{mw: mw}).text("text/html", b.html)
+		})
+	}
+	if b.html != nil {
+		return t.text("text/html", b.html)
+	}
+	return t.text("text/plain", b.text)
+}
+
+//----------------------------------------------------------------------
+// MIME entity output
+//----------------------------------------------------------------------
+
+// mailTarget is the destination of a MIME entity: either the message
+// itself (writer and message header) or a part of a multipart entity.
+type mailTarget struct {
+	w   io.Writer            // message writer (top-level)
+	hdr textproto.MIMEHeader // message header (top-level)
+	mw  *multipart.Writer    // parent multipart (nested)
+}
+
+// create the entity with given header and return the body writer
+func (t *mailTarget) create(hdr textproto.MIMEHeader) (io.Writer, error) {
+	if t.mw != nil {
+		return t.mw.CreatePart(hdr)
+	}
+	for k, v := range hdr {
+		t.hdr[k] = v
+	}
+	return t.w, writeHeader(t.w, t.hdr)
+}
+
+// multipart writes a multipart entity; parts are written by 'f'.
+func (t *mailTarget) multipart(ctype string, f func(*multipart.Writer) error) error {
+	// get boundary for the entity
+	boundary := multipart.NewWriter(nil).Boundary()
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("Content-Type", ctype+"; boundary=\""+boundary+"\"")
+	w, err := t.create(hdr)
+	if err != nil {
+		return err
+	}
+	if t.mw == nil {
+		if _, err = io.WriteString(w, "This is a multi-part message in MIME format.\r\n"); err != nil {
+			return err
+		}
+	}
+	mw := multipart.NewWriter(w)
+	if err = mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	if err = f(mw); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// text writes a text entity (quoted-printable if required)
+func (t *mailTarget) text(ctype string, body []byte) (err error) {
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("Content-Type", ctype+"; charset=utf-8")
+	qp := needsQP(body)
+	if qp {
+		hdr.Set("Content-Transfer-Encoding", "quoted-printable")
+	} else {
+		hdr.Set("Content-Transfer-Encoding", "7bit")
+	}
+	var w io.Writer
+	if w, err = t.create(hdr); err != nil {
+		return
+	}
+	if !qp {
+		_, err = w.Write(crlf(body))
+		return
+	}
+	qw := quotedprintable.NewWriter(w)
+	if _, err = qw.Write(crlf(body)); err != nil {
+		return
+	}
+	return qw.Close()
+}
+
+//----------------------------------------------------------------------
+// helper functions
+//----------------------------------------------------------------------
+
+// writeBinary writes a base64-encoded part
+func writeBinary(mw *multipart.Writer, p *mailPart, disp string) error {
+	hdr := make(textproto.MIMEHeader)
+	ctype := p.ctype
+	if len(ctype) == 0 {
+		ctype = "application/octet-stream"
+	}
+	params := map[string]string{}
+	if len(p.name) > 0 {
+		params["name"] = p.name
+	}
+	hdr.Set("Content-Type", mime.FormatMediaType(ctype, params))
+	hdr.Set("Content-Transfer-Encoding", "base64")
+	dparams := map[string]string{}
+	if len(p.name) > 0 {
+		dparams["filename"] = p.name
+	}
+	hdr.Set("Content-Disposition", mime.FormatMediaType(disp, dparams))
+	if len(p.cid) > 0 {
+		hdr.Set("Content-ID", "<"+p.cid+">")
+	}
+	pw, err := mw.CreatePart(hdr)
+	if err != nil {
+		return err
+	}
+	enc := base64.StdEncoding.EncodeToString(p.data)
+	for len(enc) > 76 {
+		if _, err = io.WriteString(pw, enc[:76]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[76:]
+	}
+	_, err = io.WriteString(pw, enc+"\r\n")
+	return err
+}
+
+// writeHeader writes a message header (sorted keys, then empty line)
+func writeHeader(w io.Writer, hdr textproto.MIMEHeader) error {
+	keys := make([]string, 0, len(hdr))
+	for k := range hdr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !validHeaderName(k) {
+			return ErrMailHeader
+		}
+		for _, v := range hdr[k] {
+			if !validHeaderValue(v) {
+				return ErrMailHeader
+			}
+			if _, err := fmt.Fprintf(w, "%s: %s\r\n", k, v); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+// validHeaderName checks a header field name (RFC 5322, 3.6.8: printable
+// US-ASCII characters except colon).
+func validHeaderName(k string) bool {
+	if len(k) == 0 {
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		if k[i] < 33 || k[i] > 126 || k[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue checks that a header value contains no line breaks
+// except for folding (CRLF followed by white space).
+func validHeaderValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '\r':
+			if i+2 >= len(v) || v[i+1] != '\n' || (v[i+2] != ' ' && v[i+2] != '\t') {
+				return false
+			}
+			i++
+		case '\n':
+			return false
+		}
+	}
+	return true
+}
+
+// encodeHeader encodes a header value as RFC 2047 encoded-word if it
+// contains non-ASCII characters.
+func encodeHeader(s string) string {
+	for _, r := range s {
+		if r > 127 {
+			return mime.QEncoding.Encode("utf-8", s)
+		}
+	}
+	return s
+}
+
+// addrList formats a list of addresses
+func addrList(list []*mail.Address) string {
+	out := make([]string, len(list))
+	for i, a := range list {
+		out[i] = a.String()
+	}
+	return strings.Join(out, ", ")
+}
+
+// messageID creates a unique message identifier
+func messageID(from string) (string, error) {
+	rnd := make([]byte, 16)
+	if _, err := rand.Read(rnd); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if idx := strings.LastIndex(from, "@"); idx != -1 {
+		domain = from[idx+1:]
+	}
+	return "<" + hex.EncodeToString(rnd) + "@" + domain + ">", nil
+}
+
+// needsQP returns true if a text needs quoted-printable encoding
+// (non-ASCII characters or lines longer than 76 characters).
+func needsQP(body []byte) bool {
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(line) > 76 {
+			return true
+		}
+	}
+	return is8Bit(body)
+}
+
+// crlf normalizes line endings to CRLF
+func crlf(body []byte) []byte {
+	body = bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n"))
+}
@@ -0,0 +1,169 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestMessageBuilder(t *testing.T) {
+	img := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 100)
+	b := NewMessageBuilder().
+		From("Jörg Müller", "joerg@example.org").
+		To("Bob", "bob@example.org").
+		Bcc("", "hidden@example.org").
+		Subject("Grüße aus Köln").
+		Text("Hallo Bob,\nschöne Grüße!\n").
+		HTML("<p>Hallo Bob, <img src=\"cid:logo\"></p>").
+		Inline("logo", "logo.png", "image/png", img).
+		Attach("notes.txt", "text/plain", []byte("notes"))
+	msg, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Recipients()) != 2 {
+		t.Fatal("wrong recipients")
+	}
+	if is8Bit(msg) {
+		t.Fatal("message not 7-bit clean")
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// check headers
+	dec := new(mime.WordDecoder)
+	subj, err := dec.DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subj != "Grüße aus Köln" {
+		t.Fatalf("subject '%s': %v", subj, err)
+	}
+	from, err := m.Header.AddressList("From")
+	if err != nil || from[0].Name != "Jörg Müller" {
+		t.Fatalf("from %v: %v", from, err)
+	}
+	if strings.Contains(string(msg), "hidden@") {
+		t.Fatal("Bcc recipient listed")
+	}
+	for _, k := range []string{"To", "Date", "Message-Id", "Mime-Version"} {
+		if len(m.Header.Get(k)) == 0 {
+			t.Fatalf("missing header %s", k)
+		}
+	}
+	if _, err = m.Header.Date(); err != nil {
+		t.Fatal(err)
+	}
+	// check structure: mixed(related(alternative(text,html),image),attachment)
+	var walk func(ct string, body io.Reader) []string
+	walk = func(ct string, body io.Reader) []string {
+		mt, params, err := mime.ParseMediaType(ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(mt, "multipart/") {
+			return []string{mt}
+		}
+		out := []string{mt + "("}
+		rdr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := rdr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Header.Get("Content-Type") == "text/plain; charset=utf-8" {
+				// multipart.Reader decodes quoted-printable transparently
+				data, _ := io.ReadAll(p)
+				if string(data) != "Hallo Bob,\r\nschöne Grüße!\r\n" {
+					t.Fatalf("wrong text '%s'", data)
+				}
+			}
+			if cid := p.Header.Get("Content-Id"); len(cid) > 0 && cid != "<logo>" {
+				t.Fatalf("wrong content id %s", cid)
+			}
+			out = append(out, walk(p.Header.Get("Content-Type"), p)...)
+		}
+		return append(out, ")")
+	}
+	res := strings.Join(walk(m.Header.Get("Content-Type"), m.Body), " ")
+	exp := "multipart/mixed( multipart/related( multipart/alternative( text/plain text/html ) image/png ) text/plain )"
+	if res != exp {
+		t.Fatalf("wrong structure: %s", res)
+	}
+}
+
+func TestMessageBuilderPlain(t *testing.T) {
+	if _, err := NewMessageBuilder().Text("x").Build(); err != ErrMailNoSender {
+		t.Fatal("missing sender accepted")
+	}
+	if _, err := NewMessageBuilder().From("", "a@b.c").Text("x").Build(); err != ErrMailNoRecipient {
+		t.Fatal("missing recipient accepted")
+	}
+	long := strings.Repeat("x", 100)
+	msg, err := NewMessageBuilder().From("", "a@b.c").To("", "d@e.f").Subject("plain").Text(long).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Header.Get("Content-Transfer-Encoding") != "quoted-printable" {
+		t.Fatal("long line not encoded")
+	}
+	data, err := io.ReadAll(quotedprintable.NewReader(m.Body))
+	if err != nil || string(data) != long {
+		t.Fatalf("wrong body '%s': %v", data, err)
+	}
+}
+
+func TestMessageBuilderInjection(t *testing.T) {
+	build := func(f func(*MessageBuilder)) error {
+		b := NewMessageBuilder().From("", "a@b.c").To("", "d@e.f").Text("x")
+		f(b)
+		_, err := b.Build()
+		return err
+	}
+	for i, f := range []func(*MessageBuilder){
+		func(b *MessageBuilder) { b.Subject("hi\r\nBcc: evil@example.com") },
+		func(b *MessageBuilder) { b.Subject("hi\nBcc: evil@example.com") },
+		func(b *MessageBuilder) { b.Header("X-Test", "v\rBcc: evil@example.com") },
+		func(b *MessageBuilder) { b.Header("X-Test", "v\r\n") },
+		func(b *MessageBuilder) { b.Header("X Test", "value") },
+		func(b *MessageBuilder) { b.Header("X-Test:", "value") },
+		func(b *MessageBuilder) { b.HTML("<p/>").Inline("id>\r\nX-Evil: 1", "a.png", "image/png", []byte{1}) },
+	} {
+		if err := build(f); err != ErrMailHeader {
+			t.Fatalf("#%d: header injection accepted: %v", i, err)
+		}
+	}
+	if err := build(func(b *MessageBuilder) { b.Subject("Grüße").Header("X-Test", "folded\r\n value") }); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,176 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	gerr "github.com/bfix/gospel/errors"
+)
+
+//======================================================================
+// Streamed parsing of (plain) mail messages: attachments above a size
+// threshold are written to a caller-supplied sink instead of being
+// held in memory; size limits abort parsing early.
+//======================================================================
+
+// Error codes
+var (
+	ErrMailPartTooLarge = errors.New("mail part exceeds size limit")
+)
+
+// MailSink returns the writer for the content of a large attachment.
+// Only the header fields of the attachment are set on invocation. A
+// nil writer discards the content; writers implementing io.Closer are
+// closed after the content is written. Returning an error aborts the
+// parsing process.
+type MailSink func(att *MailAttachment) (io.Writer, error)
+
+// MailStreamOptions for parsing mail messages
+type MailStreamOptions struct {
+	Threshold int64    // max. size of attachments kept in memory
+	MaxPart   int64    // max. size of a single part (0: unlimited)
+	MaxTotal  int64    // max. size of the message (0: unlimited)
+	Sink      MailSink // sink for large attachments (nil: discard)
+}
+
+// limit the size of a part reader (if required)
+func (o *MailStreamOptions) limit(r io.Reader) io.Reader {
+	if o == nil || o.MaxPart <= 0 {
+		return r
+	}
+	return &limitReader{r: r, max: o.MaxPart, err: ErrMailPartTooLarge}
+}
+
+// parsePlainStream disassembles a plain email message with streaming
+// of large attachments.
+func parsePlainStream(ct string, body io.Reader, opts *MailStreamOptions) (mc *MailContent, err error) {
+	mc = new(MailContent)
+	mc.Mode = modePLAIN
+	boundary := extractValue(ct, "boundary")
+	rdr := multipart.NewReader(body, boundary)
+	for {
+		var part *multipart.Part
+		if part, err = rdr.NextPart(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		// decode content (quoted-printable is handled by multipart)
+		var in io.Reader = part
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			in = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		in = opts.limit(in)
+
+		ct = part.Header.Get("Content-Type")
+		mt, _, _ := mime.ParseMediaType(ct)
+		disp, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		switch {
+		case mt == "text/plain" && disp != "attachment" && len(mc.Body) == 0:
+			var data []byte
+			if data, err = io.ReadAll(in); err != nil {
+				return
+			}
+			mc.Body = string(data)
+		case mt == "application/pgp-keys" && mc.Key == nil:
+			if mc.Key, err = io.ReadAll(in); err != nil {
+				return
+			}
+		default:
+			att := &MailAttachment{
+				Header: part.Header,
+				Name:   part.FileName(),
+				Type:   mt,
+			}
+			if err = att.store(in, opts); err != nil {
+				return
+			}
+			mc.Attachments = append(mc.Attachments, att)
+		}
+	}
+}
+
+// store the content of an attachment in memory (if small enough) or
+// write it to the sink.
+func (a *MailAttachment) store(r io.Reader, opts *MailStreamOptions) (err error) {
+	buf := new(bytes.Buffer)
+	if a.Size, err = io.CopyN(buf, r, opts.Threshold+1); err != nil && err != io.EOF {
+		return
+	}
+	if a.Size <= opts.Threshold {
+		a.Data = buf.Bytes()
+		return nil
+	}
+	// stream content to sink
+	a.Streamed = true
+	var w io.Writer
+	if opts.Sink != nil {
+		if w, err = opts.Sink(a); err != nil {
+			return
+		}
+	}
+	if w == nil {
+		w = io.Discard
+	}
+	if c, ok := w.(io.Closer); ok {
+		defer func() {
+			if errC := c.Close(); err == nil {
+				err = errC
+			}
+		}()
+	}
+	if _, err = w.Write(buf.Bytes()); err != nil {
+		return
+	}
+	var n int64
+	n, err = io.Copy(w, r)
+	a.Size += n
+	return
+}
+
+//----------------------------------------------------------------------
+// helpers
+//----------------------------------------------------------------------
+
+// limitReader fails if more than 'max' bytes are read.
+type limitReader struct {
+	r   io.Reader // underlying reader
+	n   int64     // number of bytes read
+	max int64     // max. number of bytes
+	err error     // error if limit is exceeded
+}
+
+// Read from the underlying reader and check the limit.
+func (l *limitReader) Read(p []byte) (n int, err error) {
+	n, err = l.r.Read(p)
+	if l.n += int64(n); l.n > l.max {
+		err = gerr.New(l.err, "%d > %d", l.n, l.max)
+	}
+	return
+}
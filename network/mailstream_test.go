@@ -0,0 +1,104 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// sinkBuffer records if it was closed
+type sinkBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (s *sinkBuffer) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestParseMailStream(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789abcdef"), 64*1024) // 1MB
+	msg, err := NewMessageBuilder().
+		From("Alice", "alice@example.org").
+		To("Bob", "bob@example.org").
+		Subject("Files").
+		Text("Hello Bob!\n").
+		Attach("small.txt", "text/plain", []byte("small")).
+		Attach("big.bin", "application/octet-stream", big).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stream large attachments to sink
+	sinks := make(map[string]*sinkBuffer)
+	opts := &MailStreamOptions{
+		Threshold: 1024,
+		Sink: func(att *MailAttachment) (io.Writer, error) {
+			s := new(sinkBuffer)
+			sinks[att.Name] = s
+			return s, nil
+		},
+	}
+	mc, err := ParseMailStream(bytes.NewReader(msg), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mc.Body != "Hello Bob!\r\n" {
+		t.Fatalf("wrong body: %q", mc.Body)
+	}
+	if len(mc.Attachments) != 2 {
+		t.Fatalf("wrong number of attachments: %d", len(mc.Attachments))
+	}
+	small, large := mc.Attachments[0], mc.Attachments[1]
+	if small.Streamed || string(small.Data) != "small" || small.Name != "small.txt" {
+		t.Fatal("small attachment mismatch")
+	}
+	if !large.Streamed || large.Data != nil || large.Size != int64(len(big)) {
+		t.Fatal("large attachment not streamed")
+	}
+	s, ok := sinks["big.bin"]
+	if !ok || !s.closed || !bytes.Equal(s.Bytes(), big) {
+		t.Fatal("sink content mismatch")
+	}
+	// size limits
+	opts = &MailStreamOptions{MaxPart: 4096}
+	if _, err = ParseMailStream(bytes.NewReader(msg), nil, opts); !errors.Is(err, ErrMailPartTooLarge) {
+		t.Fatalf("part limit not enforced: %v", err)
+	}
+	opts = &MailStreamOptions{MaxTotal: 4096}
+	if _, err = ParseMailStream(bytes.NewReader(msg), nil, opts); !errors.Is(err, ErrMailTooLarge) {
+		t.Fatalf("total limit not enforced: %v", err)
+	}
+	// early abort by sink
+	errAbort := errors.New("abort")
+	opts = &MailStreamOptions{
+		Sink: func(att *MailAttachment) (io.Writer, error) {
+			return nil, errAbort
+		},
+	}
+	if _, err = ParseMailStream(bytes.NewReader(msg), nil, opts); !errors.Is(err, errAbort) {
+		t.Fatalf("sink abort ignored: %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gtime "github.com/bfix/gospel/time"
+)
+
+//======================================================================
+// Stream wrappers for bandwidth accounting and throttling: a Meter
+// counts the bytes transferred over any number of connections (and
+// reports them as metrics); a Throttle caps the transfer rates per
+// direction and limits the amount of data sent in a period (like an
+// upload target). Both can be shared by all connections of a transport.
+//======================================================================
+
+// Error codes
+var (
+	ErrThrottleTarget = errors.New("upload target exceeded")
+)
+
+//----------------------------------------------------------------------
+// Bandwidth accounting
+//----------------------------------------------------------------------
+
+// Meter accumulates the traffic of metered connections.
+type Meter struct {
+	name    string        // metric name prefix
+	read    atomic.Uint64 // total bytes read
+	written atomic.Uint64 // total bytes written
+	conns   atomic.Int64  // number of open connections
+}
+
+// NewMeter creates a meter reporting metrics with given name prefix
+// (like "p2p_tor").
+func NewMeter(name string) *Meter {
+	return &Meter{name: name}
+}
+
+// BytesRead returns the total number of bytes read.
+func (m *Meter) BytesRead() uint64 {
+	return m.read.Load()
+}
+
+// BytesWritten returns the total number of bytes written.
+func (m *Meter) BytesWritten() uint64 {
+	return m.written.Load()
+}
+
+// Connections returns the number of open metered connections.
+func (m *Meter) Connections() int {
+	return int(m.conns.Load())
+}
+
+// Metrics returns the traffic totals and the number of open connections.
+func (m *Meter) Metrics() []*Metric {
+	return []*Metric{
+		{
+			Name:  m.name + "_bytes_read_total",
+			Help:  "Total number of bytes read",
+			Type:  MetricCounter,
+			Value: float64(m.read.Load()),
+		},
+		{
+			Name:  m.name + "_bytes_written_total",
+			Help:  "Total number of bytes written",
+			Type:  MetricCounter,
+			Value: float64(m.written.Load()),
+		},
+		{
+			Name:  m.name + "_connections",
+			Help:  "Number of open metered connections",
+			Type:  MetricGauge,
+			Value: float64(m.conns.Load()),
+		},
+	}
+}
+
+// MeteredConn counts the bytes transferred over a connection.
+type MeteredConn struct {
+	net.Conn
+	meter   *Meter        // shared meter (optional)
+	read    atomic.Uint64 // bytes read on connection
+	written atomic.Uint64 // bytes written on connection
+	closed  atomic.Bool   // connection closed?
+}
+
+// NewMeteredConn wraps a connection; traffic is added to the meter (if
+// not nil).
+func NewMeteredConn(conn net.Conn, m *Meter) *MeteredConn {
+	if m != nil {
+		m.conns.Add(1)
+	}
+	return &MeteredConn{Conn: conn, meter: m}
+}
+
+// Read from the connection.
+func (c *MeteredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.read.Add(uint64(n))
+		if c.meter != nil {
+			c.meter.read.Add(uint64(n))
+		}
+	}
+	return n, err
+}
+
+// Write to the connection.
+func (c *MeteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.written.Add(uint64(n))
+		if c.meter != nil {
+			c.meter.written.Add(uint64(n))
+		}
+	}
+	return n, err
+}
+
+// Close the connection.
+func (c *MeteredConn) Close() error {
+	if !c.closed.Swap(true) && c.meter != nil {
+		c.meter.conns.Add(-1)
+	}
+	return c.Conn.Close()
+}
+
+// BytesRead returns the number of bytes read on the connection.
+func (c *MeteredConn) BytesRead() uint64 {
+	return c.read.Load()
+}
+
+// BytesWritten returns the number of bytes written on the connection.
+func (c *MeteredConn) BytesWritten() uint64 {
+	return c.written.Load()
+}
+
+//----------------------------------------------------------------------
+// Bandwidth throttling
+//----------------------------------------------------------------------
+
+// rateBucket is a token bucket for a transfer rate; transfers can
+// take more tokens than available and are delayed until the debt is
+// paid off.
+type rateBucket struct {
+	rate   float64   // bytes per second (0: unlimited)
+	tokens float64   // available bytes
+	last   time.Time // time of last update
+}
+
+// reserve 'n' bytes at given time and return the delay for the transfer.
+func (b *rateBucket) reserve(now time.Time, n int) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	// refill bucket (burst size is one second worth of data)
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	if b.tokens -= float64(n); b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// chunk returns the max. size of a single transfer.
+func (b *rateBucket) chunk(size int) int {
+	if b.rate > 0 && float64(size) > b.rate {
+		size = int(b.rate)
+		if size < 1 {
+			size = 1
+		}
+	}
+	return size
+}
+
+// Throttle limits the transfer rates (bytes per second) of throttled
+// connections and the amount of data sent per target period.
+type Throttle struct {
+	lock   sync.Mutex
+	in     rateBucket    // rate limit for reading
+	out    rateBucket    // rate limit for writing
+	target uint64        // max. bytes written per period (0: unlimited)
+	period time.Duration // target period
+	start  time.Time     // start of current period
+	sent   uint64        // bytes written in current period
+	clock  gtime.Clock   // clock for delays
+}
+
+// NewThrottle creates a throttle for given read and write rates (in
+// bytes per second; 0: unlimited). A nil clock uses real time.
+func NewThrottle(clk gtime.Clock, readRate, writeRate int) *Throttle {
+	if clk == nil {
+		clk = gtime.Real
+	}
+	now := clk.Now()
+	return &Throttle{
+		in:    rateBucket{rate: float64(readRate), tokens: float64(readRate), last: now},
+		out:   rateBucket{rate: float64(writeRate), tokens: float64(writeRate), last: now},
+		start: now,
+		clock: clk,
+	}
+}
+
+// SetTarget limits the number of bytes written per period (like 24h);
+// writes fail with ErrThrottleTarget once the target is reached. A zero
+// target removes the limit.
+func (t *Throttle) SetTarget(target uint64, period time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.target = target
+	t.period = period
+	t.start = t.clock.Now()
+	t.sent = 0
+}
+
+// Remaining returns the number of bytes that can be written in the
+// current target period (and false if no target is set).
+func (t *Throttle) Remaining() (uint64, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.target == 0 {
+		return 0, false
+	}
+	t.roll()
+	if t.sent >= t.target {
+		return 0, true
+	}
+	return t.target - t.sent, true
+}
+
+// roll over to a new target period if the current one has ended.
+func (t *Throttle) roll() {
+	if now := t.clock.Now(); t.period > 0 && now.Sub(t.start) >= t.period {
+		t.start = now
+		t.sent = 0
+	}
+}
+
+// waitRead delays after 'n' bytes have been read.
+func (t *Throttle) waitRead(n int) {
+	t.lock.Lock()
+	d := t.in.reserve(t.clock.Now(), n)
+	t.lock.Unlock()
+	if d > 0 {
+		t.clock.Sleep(d)
+	}
+}
+
+// reserveTarget reserves 'n' bytes of the upload target for a write; the
+// write is refused as a whole if it would exceed the target.
+func (t *Throttle) reserveTarget(n int) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.target > 0 {
+		t.roll()
+		if t.sent+uint64(n) > t.target {
+			return ErrThrottleTarget
+		}
+		t.sent += uint64(n)
+	}
+	return nil
+}
+
+// releaseTarget returns 'n' reserved but unwritten bytes to the target.
+func (t *Throttle) releaseTarget(n int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.target > 0 {
+		if uint64(n) > t.sent {
+			n = int(t.sent)
+		}
+		t.sent -= uint64(n)
+	}
+}
+
+// waitWrite delays before 'n' bytes are written.
+func (t *Throttle) waitWrite(n int) {
+	t.lock.Lock()
+	d := t.out.reserve(t.clock.Now(), n)
+	t.lock.Unlock()
+	if d > 0 {
+		t.clock.Sleep(d)
+	}
+}
+
+// ThrottledConn is a connection with limited transfer rates.
+type ThrottledConn struct {
+	net.Conn
+	throttle *Throttle
+}
+
+// NewThrottledConn wraps a connection with a (shared) throttle.
+func NewThrottledConn(conn net.Conn, t *Throttle) *ThrottledConn {
+	return &ThrottledConn{Conn: conn, throttle: t}
+}
+
+// Read from the connection.
+func (c *ThrottledConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p[:c.throttle.in.chunk(len(p))])
+	if n > 0 {
+		c.throttle.waitRead(n)
+	}
+	return n, err
+}
+
+// Write to the connection (in chunks that comply with the rate limit).
+// The upload target is checked for the whole buffer before anything is
+// written, so a message is either sent completely or not at all.
+func (c *ThrottledConn) Write(p []byte) (n int, err error) {
+	if err = c.throttle.reserveTarget(len(p)); err != nil {
+		return
+	}
+	for n < len(p) {
+		size := c.throttle.out.chunk(len(p) - n)
+		c.throttle.waitWrite(size)
+		var k int
+		k, err = c.Conn.Write(p[n : n+size])
+		if n += k; err != nil {
+			c.throttle.releaseTarget(len(p) - n)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,123 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	gtime "github.com/bfix/gospel/time"
+)
+
+func TestMeteredConn(t *testing.T) {
+	m := NewMeter("test")
+	a, b := net.Pipe()
+	ca := NewMeteredConn(a, m)
+	cb := NewMeteredConn(b, m)
+	done := make(chan struct{})
+	go func() {
+		_, _ = ca.Write(make([]byte, 100))
+		close(done)
+	}()
+	if _, err := io.ReadFull(cb, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if ca.BytesWritten() != 100 || cb.BytesRead() != 100 || ca.BytesRead() != 0 {
+		t.Fatalf("conn counters: %d %d %d", ca.BytesWritten(), cb.BytesRead(), ca.BytesRead())
+	}
+	if m.BytesRead() != 100 || m.BytesWritten() != 100 || m.Connections() != 2 {
+		t.Fatalf("meter: %d %d %d", m.BytesRead(), m.BytesWritten(), m.Connections())
+	}
+	ca.Close()
+	ca.Close()
+	cb.Close()
+	list := m.Metrics()
+	if len(list) != 3 || list[0].Name != "test_bytes_read_total" || list[0].Value != 100 || list[2].Value != 0 {
+		t.Fatalf("metrics: %v", FormatMetrics(list))
+	}
+}
+
+func TestThrottledConn(t *testing.T) {
+	clk := gtime.NewFakeClock(time.Now())
+	th := NewThrottle(clk, 0, 1000)
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	go func() {
+		_, _ = io.Copy(io.Discard, b)
+	}()
+	c := NewThrottledConn(a, th)
+
+	// first second worth of data passes immediately
+	if n, err := c.Write(make([]byte, 1000)); err != nil || n != 1000 {
+		t.Fatalf("burst: %d %v", n, err)
+	}
+	// next write is delayed until the tokens are replenished
+	done := make(chan error)
+	go func() {
+		_, err := c.Write(make([]byte, 500))
+		done <- err
+	}()
+	clk.BlockUntil(1)
+	select {
+	case <-done:
+		t.Fatal("write not delayed")
+	default:
+	}
+	clk.Advance(500 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// upload target
+	th = NewThrottle(clk, 0, 0)
+	th.SetTarget(1500, time.Hour)
+	c = NewThrottledConn(a, th)
+	if _, err := c.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if rem, ok := th.Remaining(); !ok || rem != 500 {
+		t.Fatalf("remaining: %d %v", rem, ok)
+	}
+	if _, err := c.Write(make([]byte, 1000)); err != ErrThrottleTarget {
+		t.Fatalf("target: %v", err)
+	}
+	clk.Advance(time.Hour)
+	if rem, _ := th.Remaining(); rem != 1500 {
+		t.Fatalf("new period: %d", rem)
+	}
+	if _, err := c.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	// target is checked for the whole write (not per chunk)
+	th = NewThrottle(clk, 0, 100)
+	th.SetTarget(250, time.Hour)
+	c = NewThrottledConn(a, th)
+	if n, err := c.Write(make([]byte, 300)); err != ErrThrottleTarget || n != 0 {
+		t.Fatalf("partial write: %d %v", n, err)
+	}
+	if rem, _ := th.Remaining(); rem != 250 {
+		t.Fatalf("target consumed: %d", rem)
+	}
+}
@@ -0,0 +1,368 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/data"
+	gerr "github.com/bfix/gospel/errors"
+	"github.com/bfix/gospel/network/tor"
+	gtime "github.com/bfix/gospel/time"
+)
+
+// Error codes
+var (
+	ErrBootstrapFormat = errors.New("invalid bootstrap list")
+	ErrBootstrapSig    = errors.New("invalid bootstrap signature")
+	ErrBootstrapIssuer = errors.New("unexpected bootstrap issuer")
+	ErrBootstrapFetch  = errors.New("bootstrap list not fetched")
+	ErrBootstrapStale  = errors.New("bootstrap list outdated")
+)
+
+// Bootstrap list limits
+var (
+	BootstrapMaxSize int64 = 1 << 20             // max. size of a fetched list
+	BootstrapMaxAge        = 30 * 24 * time.Hour // max. age of a fetched list
+	BootstrapMaxSkew       = time.Hour           // max. creation time in the future
+)
+
+// bootstrapLabel is prepended to the list data for signing
+var bootstrapLabel = []byte("gospel/p2p/bootstrap")
+
+//======================================================================
+// Bootstrap lists: a portable format for the address book of a node so
+// new nodes can join a network without hard-coded peers. Lists are
+// signed by an issuer (a node key) and can be exchanged as JSON or as
+// text with one peer per line:
+//
+//     # comment
+//     @issuer <address>
+//     @created <unix time>
+//     <address> [<network>:<endpoint>]... [seen=<unix time>]
+//     @signature <hex>
+//
+// The signature covers the canonical JSON representation of the list,
+// so both formats carry the same signature.
+//======================================================================
+
+// PeerRecord is the bootstrap information for a peer
+type PeerRecord struct {
+	Addr       string   `json:"addr"`                 // P2P address
+	Transports []string `json:"transports,omitempty"` // "<network>:<endpoint>"
+	LastSeen   int64    `json:"lastSeen,omitempty"`   // last seen (Unix epoch)
+}
+
+// BootstrapList is a (signed) list of peers
+type BootstrapList struct {
+	Issuer    string        `json:"issuer,omitempty"`    // address of signer
+	Created   int64         `json:"created"`             // creation (Unix epoch)
+	Peers     []*PeerRecord `json:"peers"`               // list of peers
+	Signature string        `json:"signature,omitempty"` // EdDSA signature (hex)
+}
+
+// signedData returns the data signed by the issuer (canonical JSON).
+func (b *BootstrapList) signedData() []byte {
+	bb := *b
+	bb.Signature = ""
+	buf, _ := data.CanonicalJSON(&bb)
+	return concat(bootstrapLabel, buf)
+}
+
+// Sign the list with the private key of the issuer.
+func (b *BootstrapList) Sign(prv *ed25519.PrivateKey) error {
+	b.Issuer = NewAddressFromKey(prv.Public()).String()
+	sig, err := prv.EdSign(b.signedData())
+	if err != nil {
+		return err
+	}
+	b.Signature = hex.EncodeToString(sig.Bytes())
+	return nil
+}
+
+// Verify the signature of the list; the list must be signed by the
+// given issuer.
+func (b *BootstrapList) Verify(issuer *Address) error {
+	if issuer == nil || b.Issuer != issuer.String() {
+		return ErrBootstrapIssuer
+	}
+	buf, err := hex.DecodeString(b.Signature)
+	if err != nil {
+		return ErrBootstrapSig
+	}
+	sig, err := ed25519.NewEdSignatureFromBytes(buf)
+	if err != nil {
+		return ErrBootstrapSig
+	}
+	if ok, err := issuer.PublicKey().EdVerify(b.signedData(), sig); !ok || err != nil {
+		return ErrBootstrapSig
+	}
+	return nil
+}
+
+// JSON returns the list in JSON format.
+func (b *BootstrapList) JSON() ([]byte, error) {
+	return json.MarshalIndent(b, "", "  ")
+}
+
+// Text returns the list in text format.
+func (b *BootstrapList) Text() string {
+	buf := new(strings.Builder)
+	if len(b.Issuer) > 0 {
+		fmt.Fprintf(buf, "@issuer %s\n", b.Issuer)
+	}
+	fmt.Fprintf(buf, "@created %d\n", b.Created)
+	for _, p := range b.Peers {
+		buf.WriteString(p.Addr)
+		for _, t := range p.Transports {
+			buf.WriteString(" " + t)
+		}
+		if p.LastSeen != 0 {
+			fmt.Fprintf(buf, " seen=%d", p.LastSeen)
+		}
+		buf.WriteString("\n")
+	}
+	if len(b.Signature) > 0 {
+		fmt.Fprintf(buf, "@signature %s\n", b.Signature)
+	}
+	return buf.String()
+}
+
+// ParseBootstrap reads a bootstrap list in JSON or text format.
+func ParseBootstrap(buf []byte) (b *BootstrapList, err error) {
+	buf = bytes.TrimSpace(buf)
+	if len(buf) > 0 && buf[0] == '{' {
+		b = new(BootstrapList)
+		if err = json.Unmarshal(buf, b); err != nil {
+			return nil, gerr.New(ErrBootstrapFormat, "%s", err.Error())
+		}
+		return
+	}
+	b = new(BootstrapList)
+	rdr := bufio.NewScanner(bytes.NewReader(buf))
+	for line := 1; rdr.Scan(); line++ {
+		f := strings.Fields(rdr.Text())
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		if strings.HasPrefix(f[0], "@") {
+			// directives
+			if len(f) != 2 {
+				return nil, gerr.New(ErrBootstrapFormat, "line %d", line)
+			}
+			switch f[0] {
+			case "@issuer":
+				b.Issuer = f[1]
+			case "@created":
+				if b.Created, err = strconv.ParseInt(f[1], 10, 64); err != nil {
+					return nil, gerr.New(ErrBootstrapFormat, "line %d", line)
+				}
+			case "@signature":
+				b.Signature = f[1]
+			default:
+				return nil, gerr.New(ErrBootstrapFormat, "line %d: unknown directive", line)
+			}
+			continue
+		}
+		// peer record
+		p := &PeerRecord{Addr: f[0]}
+		for _, t := range f[1:] {
+			if seen, ok := strings.CutPrefix(t, "seen="); ok {
+				if p.LastSeen, err = strconv.ParseInt(seen, 10, 64); err != nil {
+					return nil, gerr.New(ErrBootstrapFormat, "line %d", line)
+				}
+				continue
+			}
+			p.Transports = append(p.Transports, t)
+		}
+		b.Peers = append(b.Peers, p)
+	}
+	return b, rdr.Err()
+}
+
+//----------------------------------------------------------------------
+// Export/import of peers
+//----------------------------------------------------------------------
+
+// ExportPeers returns the address book of the node as a (unsigned)
+// bootstrap list.
+func (n *Node) ExportPeers() *BootstrapList {
+	b := &BootstrapList{
+		Created: n.clock.Now().Unix(),
+	}
+	for _, addr := range n.buckets.Peers() {
+		p := &PeerRecord{Addr: addr.String()}
+		if endp := n.conn.Resolve(addr); endp != nil {
+			p.Transports = []string{endp.Network() + ":" + endp.String()}
+		}
+		if pv := n.hello.Peer(addr); pv != nil {
+			p.LastSeen = pv.Seen.Unix()
+		}
+		b.Peers = append(b.Peers, p)
+	}
+	return b
+}
+
+// ImportPeers learns the peers of a bootstrap list and returns the
+// number of peers learned. Only endpoints for the transport of the
+// node are used; peers with invalid addresses are skipped.
+func (n *Node) ImportPeers(b *BootstrapList) (count int) {
+	for _, p := range b.Peers {
+		addr, err := NewAddressFromString(p.Addr)
+		if err != nil || addr.Equals(n.addr) {
+			continue
+		}
+		if len(p.Transports) == 0 {
+			// network address is computed from the P2P address
+			// (like for the Tor transport)
+			if n.Learn(addr, "") == nil {
+				count++
+			}
+			continue
+		}
+		for _, t := range p.Transports {
+			netw, endp, ok := strings.Cut(t, ":")
+			if !ok {
+				continue
+			}
+			if na, err := n.conn.NewAddress(endp); err != nil || na.Network() != netw {
+				continue
+			}
+			if n.Learn(addr, endp) == nil {
+				count++
+				break
+			}
+		}
+	}
+	return
+}
+
+//----------------------------------------------------------------------
+// Fetching bootstrap lists
+//----------------------------------------------------------------------
+
+// BootstrapFetcher retrieves signed bootstrap lists over HTTP(S) (or
+// through Tor). Lists older than MaxAge or older than the last list
+// accepted by the fetcher are rejected, so a (signed) outdated list
+// can't be replayed.
+type BootstrapFetcher struct {
+	Client *http.Client  // HTTP client (nil: default client)
+	Issuer *Address      // expected signer of lists
+	MaxAge time.Duration // max. age of lists (0: BootstrapMaxAge)
+	Clock  gtime.Clock   // clock for age checks (nil: real time)
+	last   int64         // creation time of last accepted list
+	lock   sync.Mutex    // lock for last
+}
+
+// NewTorBootstrapFetcher creates a fetcher that retrieves lists through
+// a Tor service (like from onion services).
+func NewTorBootstrapFetcher(srv *tor.Service, issuer *Address) *BootstrapFetcher {
+	return &BootstrapFetcher{
+		Client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, netw, addr string) (net.Conn, error) {
+					return srv.DialTimeout(netw, addr, time.Minute)
+				},
+			},
+		},
+		Issuer: issuer,
+	}
+}
+
+// Fetch a bootstrap list from an URL and verify its signature.
+func (f *BootstrapFetcher) Fetch(ctx context.Context, url string) (*BootstrapList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	cl := f.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, gerr.New(ErrBootstrapFetch, "status %d", resp.StatusCode)
+	}
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, BootstrapMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > BootstrapMaxSize {
+		return nil, gerr.New(ErrBootstrapFetch, "list too large")
+	}
+	b, err := ParseBootstrap(buf)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.Verify(f.Issuer); err != nil {
+		return nil, err
+	}
+	if err = f.check(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// check the creation time of a (verified) list and remember it if the
+// list is accepted.
+func (f *BootstrapFetcher) check(b *BootstrapList) error {
+	maxAge := f.MaxAge
+	if maxAge == 0 {
+		maxAge = BootstrapMaxAge
+	}
+	clk := f.Clock
+	if clk == nil {
+		clk = gtime.Real
+	}
+	now := clk.Now()
+	created := time.Unix(b.Created, 0)
+	if created.Before(now.Add(-maxAge)) {
+		return gerr.New(ErrBootstrapStale, "created %s", created.UTC().Format(time.RFC3339))
+	}
+	if created.After(now.Add(BootstrapMaxSkew)) {
+		return gerr.New(ErrBootstrapFormat, "creation time in the future")
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if b.Created < f.last {
+		return gerr.New(ErrBootstrapStale, "older than last accepted list")
+	}
+	f.last = b.Created
+	return nil
+}
@@ -0,0 +1,159 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+)
+
+func TestBootstrapList(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trans := NewLocalTransport()
+	names := []string{"n1", "n2", "n3"}
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	n1, n2, n3 := nodes[0], nodes[1], nodes[2]
+	if err := n1.Learn(n2.Address(), names[1]); err != nil {
+		t.Fatal(err)
+	}
+	// export and sign address book of n1
+	b := n1.ExportPeers()
+	if len(b.Peers) != 1 || b.Peers[0].Transports[0] != "local:n2" {
+		t.Fatalf("wrong export: %v", b.Peers)
+	}
+	_, issuer := ed25519.NewKeypair()
+	issuerAddr := NewAddressFromKey(issuer.Public())
+	if err := b.Sign(issuer); err != nil {
+		t.Fatal(err)
+	}
+	// both formats carry the signature
+	js, err := b.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, buf := range [][]byte{js, []byte(b.Text())} {
+		bb, err := ParseBootstrap(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = bb.Verify(issuerAddr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// tampered list and wrong issuer
+	bb, _ := ParseBootstrap([]byte(b.Text()))
+	bb.Peers[0].Transports[0] = "local:evil"
+	if err = bb.Verify(issuerAddr); err != ErrBootstrapSig {
+		t.Fatalf("tampered list accepted: %v", err)
+	}
+	if err = b.Verify(n1.Address()); err != ErrBootstrapIssuer {
+		t.Fatalf("wrong issuer accepted: %v", err)
+	}
+	if _, err = ParseBootstrap([]byte("@unknown x")); err == nil {
+		t.Fatal("invalid list accepted")
+	}
+	// fetch list and import into n3
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(b.Text()))
+	}))
+	defer srv.Close()
+	f := &BootstrapFetcher{Issuer: issuerAddr}
+	if bb, err = f.Fetch(ctx, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (&BootstrapFetcher{Issuer: n1.Address()}).Fetch(ctx, srv.URL); err != ErrBootstrapIssuer {
+		t.Fatalf("wrong issuer accepted: %v", err)
+	}
+	if n := n3.ImportPeers(bb); n != 1 {
+		t.Fatalf("wrong number of imported peers: %d", n)
+	}
+	if err = n2.Learn(n3.Address(), names[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err = n3.PingService().Ping(ctx, n2.Address(), 5*time.Second, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBootstrapStale(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, issuer := ed25519.NewKeypair()
+	issuerAddr := NewAddressFromKey(issuer.Public())
+	var list []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(list)
+	}))
+	defer srv.Close()
+	serve := func(created time.Time) {
+		b := &BootstrapList{Created: created.Unix()}
+		if err := b.Sign(issuer); err != nil {
+			t.Fatal(err)
+		}
+		list = []byte(b.Text())
+	}
+	f := &BootstrapFetcher{Issuer: issuerAddr, MaxAge: 24 * time.Hour}
+	now := time.Now()
+
+	// outdated list
+	serve(now.Add(-48 * time.Hour))
+	if _, err := f.Fetch(ctx, srv.URL); !errors.Is(err, ErrBootstrapStale) {
+		t.Fatalf("outdated list accepted: %v", err)
+	}
+	// list from the future
+	serve(now.Add(2 * BootstrapMaxSkew))
+	if _, err := f.Fetch(ctx, srv.URL); !errors.Is(err, ErrBootstrapFormat) {
+		t.Fatalf("future list accepted: %v", err)
+	}
+	// newer list followed by a replayed older list
+	serve(now.Add(-time.Hour))
+	if _, err := f.Fetch(ctx, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Fetch(ctx, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	serve(now.Add(-2 * time.Hour))
+	if _, err := f.Fetch(ctx, srv.URL); !errors.Is(err, ErrBootstrapStale) {
+		t.Fatalf("replayed list accepted: %v", err)
+	}
+}
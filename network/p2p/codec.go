@@ -0,0 +1,169 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/bfix/gospel/data"
+)
+
+// Error codes
+var (
+	ErrCodecUnknown = errors.New("unknown message codec")
+	ErrCodecID      = errors.New("invalid codec identifier")
+)
+
+//======================================================================
+// Message codecs:
+// Messages are serialized with a pluggable wire encoding (codec) so
+// that implementations of the protocol in other languages can use a
+// common encoding (like CBOR or protobuf). The default codec (ID 0) is
+// the binary encoding of the 'data' package and is always available.
+//
+// Each registered codec is announced by a capability bit; a node uses
+// its default codec for messages to a peer only if the peer announced
+// support for it (and falls back to the data codec otherwise). The ID
+// of the codec used for a message is stored in the upper four bits of
+// the capabilities field in the packet header (authenticated as part
+// of the encrypted body). The packet envelope itself has a fixed binary
+// layout as it is processed before anything is known about the sender.
+//======================================================================
+
+// Codec parameters
+const (
+	CodecData     = 0      // ID of default codec (data package)
+	CodecMaxID    = 8      // max. ID of a registered codec
+	CapCodecMask  = 0xF000 // codec of a packet body in packet capabilities
+	capCodecShift = 12
+)
+
+// Codec is a pluggable message encoding
+type Codec interface {
+	// ID of the codec (1..CodecMaxID for registered codecs)
+	ID() uint8
+
+	// Encode a message into binary data
+	Encode(msg Message) ([]byte, error)
+
+	// Decode binary data into a message; the factory returns an empty
+	// message for a given message type (or nil if the type is unknown).
+	Decode(buf []byte, factory func(mt uint16) Message) (Message, error)
+}
+
+// CapCodec returns the capability bit announcing support for a codec.
+func CapCodec(id uint8) uint16 {
+	if id == CodecData || id > CodecMaxID {
+		return 0
+	}
+	return 1 << (3 + id)
+}
+
+// list of registered codecs and the selected default
+var (
+	codecs = map[uint8]Codec{
+		CodecData: new(DataCodec),
+	}
+	codecDefault uint8 = CodecData
+	codecLock    sync.RWMutex
+)
+
+// RegisterCodec adds a message codec. If 'dflt' is set, the codec is
+// used for outgoing messages to peers that support it (all registered
+// codecs can be decoded).
+func RegisterCodec(c Codec, dflt bool) error {
+	if id := c.ID(); id == CodecData || id > CodecMaxID {
+		return ErrCodecID
+	}
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecs[c.ID()] = c
+	if dflt {
+		codecDefault = c.ID()
+	}
+	return nil
+}
+
+// codecCaps returns the capability bits of all registered codecs.
+func codecCaps() (caps uint16) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	for id := range codecs {
+		caps |= CapCodec(id)
+	}
+	return
+}
+
+// selectCodec returns the codec for messages to a peer with given
+// (common) capabilities.
+func selectCodec(common uint16) Codec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	if c := codecs[codecDefault]; common&CapCodec(codecDefault) != 0 {
+		return c
+	}
+	return codecs[CodecData]
+}
+
+// getCodec returns the codec for a given ID.
+func getCodec(id uint8) (Codec, error) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	c, ok := codecs[id]
+	if !ok {
+		return nil, ErrCodecUnknown
+	}
+	return c, nil
+}
+
+//----------------------------------------------------------------------
+// Default codec (binary encoding of the 'data' package)
+//----------------------------------------------------------------------
+
+// DataCodec encodes messages with the 'data' package.
+type DataCodec struct{}
+
+// ID of the codec
+func (c *DataCodec) ID() uint8 {
+	return CodecData
+}
+
+// Encode a message into binary data
+func (c *DataCodec) Encode(msg Message) ([]byte, error) {
+	return data.Marshal(msg)
+}
+
+// Decode binary data into a message
+func (c *DataCodec) Decode(buf []byte, factory func(mt uint16) Message) (Message, error) {
+	if len(buf) < HdrSize {
+		return nil, ErrMessageParse
+	}
+	msg := factory(binary.BigEndian.Uint16(buf[2:4]))
+	if msg == nil {
+		return nil, ErrMessageParse
+	}
+	if err := data.Unmarshal(msg, buf); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,144 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+)
+
+// testCodec is the data codec with reversed byte order
+type testCodec struct {
+	DataCodec
+}
+
+func (c *testCodec) ID() uint8 {
+	return 1
+}
+
+func (c *testCodec) Encode(msg Message) ([]byte, error) {
+	buf, err := c.DataCodec.Encode(msg)
+	return reverse(buf), err
+}
+
+func (c *testCodec) Decode(buf []byte, factory func(mt uint16) Message) (Message, error) {
+	return c.DataCodec.Decode(reverse(buf), factory)
+}
+
+func reverse(buf []byte) []byte {
+	out := make([]byte, len(buf))
+	for i, b := range buf {
+		out[len(buf)-1-i] = b
+	}
+	return out
+}
+
+func TestCodec(t *testing.T) {
+	if err := RegisterCodec(new(DataCodec), false); err != ErrCodecID {
+		t.Fatal("data codec re-registered")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trans := NewLocalTransport()
+	names := []string{"n1", "n2"}
+	nodes := make([]*Node, 2)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	n1, n2 := nodes[0], nodes[1]
+	if err := n1.Learn(n2.Address(), names[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := n2.Learn(n1.Address(), names[0]); err != nil {
+		t.Fatal(err)
+	}
+	// without codec support the data codec is used
+	wrap := func(n *Node, rcv *Address) *Packet {
+		msg := NewPingMsg()
+		msg.Header().Sender = n.Address()
+		msg.Header().Receiver = rcv
+		pkt, err := n.Wrap(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pkt
+	}
+	timeout := 5 * time.Second
+	if _, err := n2.HelloService().Hello(ctx, n1.Address(), timeout); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := wrap(n1, n2.Address()); pkt.Codec() != CodecData {
+		t.Fatal("unexpected codec")
+	}
+	// register alternative codec as default
+	if err := RegisterCodec(new(testCodec), true); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		codecLock.Lock()
+		delete(codecs, 1)
+		codecDefault = CodecData
+		codecLock.Unlock()
+	}()
+	if _, err := n2.HelloService().Hello(ctx, n1.Address(), timeout); err != nil {
+		t.Fatal(err)
+	}
+	pkt := wrap(n1, n2.Address())
+	if pkt.Codec() != 1 {
+		t.Fatal("codec not negotiated")
+	}
+	msg, err := n2.Unwrap(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.Header().Sender.Equals(n1.Address()) {
+		t.Fatal("message mismatch")
+	}
+	// unknown codec
+	pkt.Caps |= 0x7 << capCodecShift
+	if _, err = n2.Unwrap(pkt); err != ErrCodecUnknown {
+		t.Fatalf("expected unknown codec: %v", err)
+	}
+	// message exchange with alternative codec
+	buf := bytes.Repeat([]byte("codec"), 1000)
+	id := n1.Put(buf)
+	out, err := n2.BlobService().Get(ctx, id, []*Address{n1.Address()}, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf) {
+		t.Fatal("blob mismatch")
+	}
+}
@@ -0,0 +1,184 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Error codes
+var (
+	ErrCompressUnknown = errors.New("unknown compression method")
+	ErrCompressSize    = errors.New("decompressed message too large")
+)
+
+// CompressThreshold is the minimum payload size (in bytes) for compression
+var CompressThreshold = 512
+
+//======================================================================
+// Payload compression:
+// Message payloads (everything after the message header) above a size
+// threshold are compressed if the receiver announced the capability
+// 'CapCompress'. Compressed messages are flagged in the header; the
+// first byte of the compressed payload identifies the method. Methods
+// are pluggable; DEFLATE is always available.
+//======================================================================
+
+// Compressor is a pluggable compression method
+type Compressor interface {
+	// ID of the compression method
+	ID() uint8
+
+	// Compress data
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress data (with given maximum output size)
+	Decompress(data []byte, max int) ([]byte, error)
+}
+
+// list of registered compressors and the selected default
+var (
+	compressors = map[uint8]Compressor{
+		CompressDeflate: new(DeflateCompressor),
+	}
+	compressDefault uint8 = CompressDeflate
+	compressLock    sync.RWMutex
+)
+
+// RegisterCompressor adds a compression method. If 'dflt' is set, the
+// method is used for outgoing messages (all registered methods can be
+// decompressed).
+func RegisterCompressor(c Compressor, dflt bool) {
+	compressLock.Lock()
+	defer compressLock.Unlock()
+	compressors[c.ID()] = c
+	if dflt {
+		compressDefault = c.ID()
+	}
+}
+
+// compressMsg compresses the payload of a binary message if it is worth
+// it. Returns the unchanged buffer otherwise.
+func compressMsg(buf []byte) []byte {
+	if len(buf)-HdrSize < CompressThreshold {
+		return buf
+	}
+	compressLock.RLock()
+	c := compressors[compressDefault]
+	compressLock.RUnlock()
+
+	out, err := c.Compress(buf[HdrSize:])
+	if err != nil || len(out)+1 >= len(buf)-HdrSize {
+		return buf
+	}
+	res := make([]byte, HdrSize, HdrSize+1+len(out))
+	copy(res, buf[:HdrSize])
+	res = append(res, c.ID())
+	res = append(res, out...)
+
+	// update header (size and flags)
+	binary.BigEndian.PutUint16(res[0:2], uint16(len(res)))
+	flags := binary.BigEndian.Uint32(res[4:8])
+	binary.BigEndian.PutUint32(res[4:8], flags|MsgfCompressed)
+	return res
+}
+
+// decompressMsg restores a compressed binary message. Uncompressed
+// messages are returned unchanged.
+func decompressMsg(buf []byte) ([]byte, error) {
+	if len(buf) < HdrSize+1 {
+		return buf, nil
+	}
+	flags := binary.BigEndian.Uint32(buf[4:8])
+	if flags&MsgfCompressed == 0 {
+		return buf, nil
+	}
+	compressLock.RLock()
+	c, ok := compressors[buf[HdrSize]]
+	compressLock.RUnlock()
+	if !ok {
+		return nil, ErrCompressUnknown
+	}
+	out, err := c.Decompress(buf[HdrSize+1:], MaxMsgSize-HdrSize)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]byte, HdrSize, HdrSize+len(out))
+	copy(res, buf[:HdrSize])
+	res = append(res, out...)
+
+	// restore header (size and flags)
+	binary.BigEndian.PutUint16(res[0:2], uint16(len(res)))
+	binary.BigEndian.PutUint32(res[4:8], flags&^MsgfCompressed)
+	return res, nil
+}
+
+//----------------------------------------------------------------------
+// DEFLATE compression (RFC 1951)
+//----------------------------------------------------------------------
+
+// Compression methods
+const (
+	CompressDeflate = 1 // DEFLATE (RFC 1951)
+)
+
+// DeflateCompressor implements DEFLATE compression
+type DeflateCompressor struct{}
+
+// ID of the compression method
+func (c *DeflateCompressor) ID() uint8 {
+	return CompressDeflate
+}
+
+// Compress data
+func (c *DeflateCompressor) Compress(data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	wrt, err := flate.NewWriter(buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = wrt.Write(data); err != nil {
+		return nil, err
+	}
+	if err = wrt.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress data (with given maximum output size)
+func (c *DeflateCompressor) Decompress(data []byte, max int) ([]byte, error) {
+	rdr := flate.NewReader(bytes.NewReader(data))
+	defer rdr.Close()
+	out, err := io.ReadAll(io.LimitReader(rdr, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, ErrCompressSize
+	}
+	return out, nil
+}
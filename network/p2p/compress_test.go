@@ -0,0 +1,110 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/data"
+)
+
+func TestCompressMsg(t *testing.T) {
+	msg, _ := NewBlobChunkRespMsg().(*BlobChunkRespMsg)
+	msg.Sender = newTestAddress()
+	msg.Receiver = newTestAddress()
+	msg.Set(bytes.Repeat([]byte("gospel"), 1000), nil)
+	buf, err := data.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmp := compressMsg(buf)
+	if len(cmp) >= len(buf) {
+		t.Fatal("message not compressed")
+	}
+	out, err := decompressMsg(cmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf) {
+		t.Fatal("message mismatch")
+	}
+	// small messages are not compressed
+	buf = buf[:HdrSize+10]
+	if !bytes.Equal(compressMsg(buf), buf) {
+		t.Fatal("small message compressed")
+	}
+	// decompression is limited
+	c := new(DeflateCompressor)
+	bomb, err := c.Compress(make([]byte, 2*MaxMsgSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Decompress(bomb, MaxMsgSize); err != ErrCompressSize {
+		t.Fatalf("decompression not limited: %v", err)
+	}
+}
+
+func TestCompressTransfer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trans := NewLocalTransport()
+	names := []string{"n1", "n2"}
+	nodes := make([]*Node, 2)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		n.SetCapabilities(CapCompress)
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	n1, n2 := nodes[0], nodes[1]
+	if err := n1.Learn(n2.Address(), names[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := n2.Learn(n1.Address(), names[0]); err != nil {
+		t.Fatal(err)
+	}
+	timeout := 5 * time.Second
+	if _, err := n2.HelloService().Hello(ctx, n1.Address(), timeout); err != nil {
+		t.Fatal(err)
+	}
+	// transfer compressible blob
+	blob := bytes.Repeat([]byte("compress me!"), 10000)
+	id := n1.Put(blob)
+	buf, err := n2.BlobService().Get(ctx, id, []*Address{n1.Address()}, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, blob) {
+		t.Fatal("blob mismatch")
+	}
+}
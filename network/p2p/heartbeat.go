@@ -0,0 +1,197 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	gtime "github.com/bfix/gospel/time"
+)
+
+//======================================================================
+// Heartbeats on stream connections: the sender of a long-lived stream
+// connection periodically writes a ping frame that is answered by the
+// receiver with a pong frame on the same connection. The round-trip
+// time is measured; a connection is considered dead if too many pings
+// are not answered in time (instead of waiting for OS-level timeouts
+// that can take many minutes over Tor).
+//
+// Frames on a stream connection are either packets (starting with the
+// packet size) or heartbeat frames: a zero size field (packets are
+// never empty) followed by the frame type and a 64-bit nonce.
+//======================================================================
+
+// Heartbeat defaults
+const (
+	HeartbeatInterval = 30 * time.Second // time between pings
+	HeartbeatMisses   = 3                // unanswered pings before a connection is dead
+)
+
+// frame types on stream connections
+const (
+	framePacket = iota
+	framePing
+	framePong
+)
+
+// heartbeatFrameSize is the size of a ping/pong frame
+const heartbeatFrameSize = 2 + 1 + 8
+
+// Error codes
+var (
+	ErrFrameSize = errors.New("invalid frame size")
+	ErrFrameType = errors.New("invalid frame type")
+)
+
+// heartbeatFrame assembles a ping or pong frame.
+func heartbeatFrame(kind int, nonce uint64) []byte {
+	buf := make([]byte, heartbeatFrameSize)
+	buf[2] = byte(kind)
+	binary.BigEndian.PutUint64(buf[3:], nonce)
+	return buf
+}
+
+// readFrame reads the next frame from a stream into a buffer. For
+// packets the packet size is returned; for heartbeat frames the nonce
+// is in the first eight bytes of the buffer.
+func readFrame(rdr io.Reader, buf []byte) (kind, n int, err error) {
+	if _, err = io.ReadFull(rdr, buf[:2]); err != nil {
+		return
+	}
+	size := int(binary.BigEndian.Uint16(buf[:2]))
+	if size == 0 {
+		// heartbeat frame
+		if _, err = io.ReadFull(rdr, buf[:heartbeatFrameSize-2]); err != nil {
+			return
+		}
+		kind = int(buf[0])
+		if kind != framePing && kind != framePong {
+			err = ErrFrameType
+			return
+		}
+		copy(buf, buf[1:9])
+		return kind, 8, nil
+	}
+	if size < PacketHdrSize || size > len(buf) {
+		err = ErrFrameSize
+		return
+	}
+	_, err = io.ReadFull(rdr, buf[2:size])
+	return framePacket, size, err
+}
+
+//----------------------------------------------------------------------
+
+// Heartbeat tracks the liveness and round-trip time of a connection.
+type Heartbeat struct {
+	interval time.Duration // time between pings
+	misses   int           // max. number of unanswered pings
+	clock    gtime.Clock   // clock for time measurement
+
+	lock     sync.Mutex
+	seq      uint64        // nonce of last ping
+	sent     time.Time     // time of last ping
+	missed   int           // number of unanswered pings
+	rtt      time.Duration // last measured round-trip time
+	lastPong time.Time     // time of last pong
+}
+
+// NewHeartbeat creates a new heartbeat monitor.
+func NewHeartbeat(clk gtime.Clock, interval time.Duration, misses int) *Heartbeat {
+	if interval <= 0 {
+		interval = HeartbeatInterval
+	}
+	if misses <= 0 {
+		misses = HeartbeatMisses
+	}
+	return &Heartbeat{
+		interval: interval,
+		misses:   misses,
+		clock:    clk,
+	}
+}
+
+// Interval returns the time between pings.
+func (h *Heartbeat) Interval() time.Duration {
+	return h.interval
+}
+
+// Ping returns the next ping frame; if too many pings have not been
+// answered, the connection is dead and no frame is returned.
+func (h *Heartbeat) Ping() (frame []byte, dead bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.missed >= h.misses {
+		return nil, true
+	}
+	h.missed++
+	h.seq++
+	h.sent = h.clock.Now()
+	return heartbeatFrame(framePing, h.seq), false
+}
+
+// Pong handles an answer to a ping; pongs for older pings are ignored.
+func (h *Heartbeat) Pong(nonce uint64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if nonce != h.seq {
+		return
+	}
+	h.lastPong = h.clock.Now()
+	h.rtt = h.lastPong.Sub(h.sent)
+	h.missed = 0
+}
+
+// RTT returns the last measured round-trip time (0 if not measured).
+func (h *Heartbeat) RTT() time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.rtt
+}
+
+// Missed returns the number of unanswered pings.
+func (h *Heartbeat) Missed() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.missed
+}
+
+// LastPong returns the time of the last answered ping.
+func (h *Heartbeat) LastPong() time.Time {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.lastPong
+}
+
+//----------------------------------------------------------------------
+
+// ConnMetrics describe the state of an open stream connection to a peer
+type ConnMetrics struct {
+	Peer     string        // network address of peer
+	RTT      time.Duration // last measured round-trip time
+	Missed   int           // number of unanswered pings
+	LastPong time.Time     // time of last answered ping
+	LastUsed time.Time     // time of last packet sent
+}
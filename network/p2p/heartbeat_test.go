@@ -0,0 +1,97 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	gtime "github.com/bfix/gospel/time"
+)
+
+func TestReadFrame(t *testing.T) {
+	pkt := make([]byte, PacketHdrSize+10)
+	binary.BigEndian.PutUint16(pkt, uint16(len(pkt)))
+	pkt[len(pkt)-1] = 0x42
+
+	stream := new(bytes.Buffer)
+	stream.Write(heartbeatFrame(framePing, 17))
+	stream.Write(pkt)
+	stream.Write(heartbeatFrame(framePong, 18))
+
+	buf := make([]byte, MaxMsgSize)
+	for i, exp := range []int{framePing, framePacket, framePong} {
+		kind, n, err := readFrame(stream, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != exp {
+			t.Fatalf("frame #%d: wrong kind %d", i, kind)
+		}
+		switch kind {
+		case framePacket:
+			if n != len(pkt) || !bytes.Equal(buf[:n], pkt) {
+				t.Fatal("packet mismatch")
+			}
+		default:
+			if binary.BigEndian.Uint64(buf[:8]) != uint64(16+kind) {
+				t.Fatal("nonce mismatch")
+			}
+		}
+	}
+	// invalid frames
+	if _, _, err := readFrame(bytes.NewReader([]byte{0, 5}), buf); err != ErrFrameSize {
+		t.Fatalf("expected size error: %v", err)
+	}
+	bad := heartbeatFrame(framePacket, 1)
+	if _, _, err := readFrame(bytes.NewReader(bad), buf); err != ErrFrameType {
+		t.Fatalf("expected type error: %v", err)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	clk := gtime.NewFakeClock(time.Unix(1700000000, 0))
+	hb := NewHeartbeat(clk, time.Second, 2)
+
+	// answered ping
+	frame, dead := hb.Ping()
+	if dead {
+		t.Fatal("connection dead")
+	}
+	nonce := binary.BigEndian.Uint64(frame[3:])
+	clk.Advance(20 * time.Millisecond)
+	hb.Pong(nonce)
+	if hb.RTT() != 20*time.Millisecond || hb.Missed() != 0 {
+		t.Fatalf("wrong state: rtt=%s, missed=%d", hb.RTT(), hb.Missed())
+	}
+	// unanswered pings (late pongs are ignored)
+	hb.Ping()
+	hb.Ping()
+	hb.Pong(nonce)
+	if hb.Missed() != 2 {
+		t.Fatal("late pong accepted")
+	}
+	if _, dead = hb.Ping(); !dead {
+		t.Fatal("dead connection not detected")
+	}
+}
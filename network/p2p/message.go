@@ -44,17 +44,39 @@ const (
 	// (If you have multiple responses for a single request, leave out
 	// odd numbers from sequence)
 	//==================================================================
-	ReqPING  = 1 // PING to check if a node is alive
-	RespPING = 2 // response to PING
-	ReqNODE  = 3 // FIND_NODE returns a list of nodes "near" the requested one
-	RespNODE = 4 // response to FIND_NODE
-	ReqRELAY = 5 // relay message to another node (response-less)
+	ReqPING    = 1  // PING to check if a node is alive
+	RespPING   = 2  // response to PING
+	ReqNODE    = 3  // FIND_NODE returns a list of nodes "near" the requested one
+	RespNODE   = 4  // response to FIND_NODE
+	ReqRELAY   = 5  // relay message to another node (response-less)
+	ReqSMSG    = 7  // encrypted session message
+	RespSMSG   = 8  // acknowledge session message
+	ReqMDEP    = 9  // deposit message in mailbox
+	RespMDEP   = 10 // response to mailbox deposit
+	ReqMGET    = 11 // fetch messages from mailbox (with receipts)
+	RespMGET   = 12 // response to mailbox fetch
+	ReqMSTAT   = 13 // query status of deposited messages
+	RespMSTAT  = 14 // response to status query
+	ReqBINFO   = 15 // query blob information
+	RespBINFO  = 16 // response to blob query
+	ReqBCHUNK  = 17 // request blob chunk
+	RespBCHUNK = 18 // response with blob chunk (and proof)
+	ReqPROBE   = 19 // ask peer to dial back an advertised endpoint
+	RespPROBE  = 20 // dial-back (direct) or probe report (routed)
+	ReqHELLO   = 21 // announce protocol version and capabilities
+	RespHELLO  = 22 // response to HELLO
+	ReqNPUT    = 23 // store name record
+	RespNPUT   = 24 // response to name record store
+	ReqNGET    = 25 // resolve name
+	RespNGET   = 26 // response with name record
 )
 
 // message flags
 const (
 	MsgfRelay = 1 // Message was forwarded (sender != originator)
 	MsgfDrop  = 2 // Drop message without processing (cover traffic)
+
+	MsgfCompressed = 4 // Message payload is compressed
 )
 
 var (
@@ -91,6 +113,18 @@ func (s *String) String() string {
 	return string(s.Data)
 }
 
+// Hash is a 32-byte hash value (or identifier) in binary format
+type Hash struct {
+	Data []byte `size:"32"` // hash value
+}
+
+// NewHash encapsulates a hash value
+func NewHash(h []byte) *Hash {
+	return &Hash{
+		Data: h,
+	}
+}
+
 //----------------------------------------------------------------------
 // Message interface
 //----------------------------------------------------------------------
@@ -24,11 +24,14 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bfix/gospel/crypto/ed25519"
 	"github.com/bfix/gospel/data"
 	"github.com/bfix/gospel/logger"
+	gtime "github.com/bfix/gospel/time"
 )
 
 // Error codes
@@ -39,6 +42,7 @@ var (
 	ErrNodeSendNoReceiver = errors.New("send has no recipient")
 	ErrNodeResolve        = errors.New("can't resolve network address")
 	ErrNodeMsgType        = errors.New("invalid message type")
+	ErrNodeShutdown       = errors.New("node is shut down")
 )
 
 // constants
@@ -65,14 +69,28 @@ type Node struct {
 	ping   *PingService
 	lookup *LookupService
 	relay  *RelayService
+	blob   *BlobService
+	reach  *ReachService
+	hello  *HelloService
+	names  *NameService
 
 	inCh chan Message // channel for incoming messages
 	conn Connector    // send/receive stub
 
-	buckets *BucketList  // routing table
-	srvcs   *ServiceList // list of services
+	buckets *BucketList                // routing table
+	srvcs   *ServiceList               // list of services
+	policy  atomic.Pointer[PeerPolicy] // peer authentication policy (optional)
+	caps    uint32                     // capabilities of node
+	clock   gtime.Clock                // clock for time-dependent behavior
 
 	lastID uint64 // last used identifier
+
+	// shutdown handling
+	handlers sync.WaitGroup     // in-flight message handlers
+	stopping bool               // shutdown in progress?
+	stopped  chan struct{}      // closed when shutdown is complete
+	cancel   context.CancelFunc // cancel context of running node
+	runLock  sync.Mutex
 }
 
 // NewNode instantiates a new local node with given private key.
@@ -82,11 +100,13 @@ func NewNode(prv *ed25519.PrivateKey) (n *Node, err error) {
 	addr := NewAddressFromKey(pub)
 	logger.Printf(logger.INFO, "[%.8s] Creating node...\n", addr)
 	n = &Node{
-		prvKey: prv,
-		addr:   addr,
-		inCh:   make(chan Message),
-		conn:   nil,
-		srvcs:  NewServiceList(),
+		prvKey:  prv,
+		addr:    addr,
+		inCh:    make(chan Message),
+		conn:    nil,
+		srvcs:   NewServiceList(),
+		clock:   gtime.Real,
+		stopped: make(chan struct{}),
 	}
 	// add all standard services (P2P)
 	n.ping = NewPingService()
@@ -95,12 +115,78 @@ func NewNode(prv *ed25519.PrivateKey) (n *Node, err error) {
 	n.AddService(n.lookup)
 	n.relay = NewRelayService()
 	n.AddService(n.relay)
+	n.blob = NewBlobService()
+	n.AddService(n.blob)
+	n.reach = NewReachService()
+	n.AddService(n.reach)
+	n.hello = NewHelloService()
+	n.AddService(n.hello)
+	n.names = NewNameService()
+	n.AddService(n.names)
 
 	// set node attributes with back references
-	n.buckets = NewBucketList(addr, n.ping)
+	n.buckets, err = NewBucketList(addr, n.ping, nil)
 	return
 }
 
+//----------------------------------------------------------------------
+// Clock
+//----------------------------------------------------------------------
+
+// SetClock sets the clock used by the node (its transport, routing table
+// and services) for epochs, connection TTLs, retries and expiration. Must
+// be called before the node is connected and running; simulations use a
+// virtual clock.
+func (n *Node) SetClock(clk gtime.Clock) {
+	n.clock = clk
+	n.buckets.SetClock(clk)
+}
+
+// Clock returns the clock used by the node.
+func (n *Node) Clock() gtime.Clock {
+	return n.clock
+}
+
+//----------------------------------------------------------------------
+// Protocol capabilities
+//----------------------------------------------------------------------
+
+// SetCapabilities sets the capabilities announced by the node.
+func (n *Node) SetCapabilities(caps uint16) {
+	atomic.StoreUint32(&n.caps, uint32(caps))
+}
+
+// Capabilities returns the capabilities announced by the node
+// (including the support for registered codecs).
+func (n *Node) Capabilities() uint16 {
+	return uint16(atomic.LoadUint32(&n.caps)) | codecCaps()
+}
+
+//----------------------------------------------------------------------
+// Routing table
+//----------------------------------------------------------------------
+
+// SetRouting replaces the routing table of the node with a table using
+// the given configuration. Known peers are transferred to the new table.
+// Must be called before the node is running.
+func (n *Node) SetRouting(cfg *BucketConfig) error {
+	bl, err := NewBucketList(n.addr, n.ping, cfg)
+	if err != nil {
+		return err
+	}
+	bl.SetClock(n.clock)
+	for _, addr := range n.buckets.Peers() {
+		bl.Add(addr)
+	}
+	n.buckets = bl
+	return nil
+}
+
+// Routing returns the routing table of the node.
+func (n *Node) Routing() *BucketList {
+	return n.buckets
+}
+
 //----------------------------------------------------------------------
 // Address handling
 //----------------------------------------------------------------------
@@ -110,6 +196,31 @@ func (n *Node) Address() *Address {
 	return n.addr
 }
 
+//----------------------------------------------------------------------
+// Peer authentication
+//----------------------------------------------------------------------
+
+// SetPolicy sets the authentication policy for peers. A nil policy
+// accepts all peers. The policy can be changed while the node is running.
+func (n *Node) SetPolicy(p *PeerPolicy) {
+	n.policy.Store(p)
+}
+
+// Policy returns the current peer authentication policy (can be nil).
+func (n *Node) Policy() *PeerPolicy {
+	return n.policy.Load()
+}
+
+// Admit checks if a peer (with optional network address) is accepted
+// by the authentication policy of the node.
+func (n *Node) Admit(addr *Address, netw net.Addr) error {
+	p := n.policy.Load()
+	if p == nil {
+		return nil
+	}
+	return p.Check(addr, netw)
+}
+
 //----------------------------------------------------------------------
 // Service handling
 //----------------------------------------------------------------------
@@ -131,6 +242,11 @@ func (n *Node) Service(name string) Service {
 	return n.srvcs.Get(name)
 }
 
+// Services returns the list of services running on the node
+func (n *Node) Services() *ServiceList {
+	return n.srvcs
+}
+
 // PingService returns the PING service instance
 func (n *Node) PingService() *PingService {
 	return n.ping
@@ -146,6 +262,44 @@ func (n *Node) RelayService() *RelayService {
 	return n.relay
 }
 
+// BlobService returns the BLOB service instance
+func (n *Node) BlobService() *BlobService {
+	return n.blob
+}
+
+// HelloService returns the HELLO service instance
+func (n *Node) HelloService() *HelloService {
+	return n.hello
+}
+
+// ReachService returns the REACH service instance
+func (n *Node) ReachService() *ReachService {
+	return n.reach
+}
+
+// NameService returns the name service of the node
+func (n *Node) NameService() *NameService {
+	return n.names
+}
+
+// ResolveName resolves a human-readable label into a name record
+// (target address and optional endpoint) using the name service.
+func (n *Node) ResolveName(ctx context.Context, label string, timeout time.Duration) (*NameRecord, error) {
+	return n.names.Resolve(ctx, label, timeout)
+}
+
+// Put a blob into the local store of the node and return its
+// (content-addressed) identifier.
+func (n *Node) Put(blob []byte) []byte {
+	return n.blob.Put(blob)
+}
+
+// Get a blob by its identifier. If the blob is not stored locally, it
+// is retrieved from the closest known peers.
+func (n *Node) Get(ctx context.Context, id []byte, timeout time.Duration) ([]byte, error) {
+	return n.blob.Get(ctx, id, n.Closest(BlobPeers), timeout)
+}
+
 //----------------------------------------------------------------------
 // Message exchange (incoming and outgoing messages)
 //----------------------------------------------------------------------
@@ -282,14 +436,42 @@ func (n *Node) Unpack(buf []byte, size int) (msg Message, err error) {
 
 // Wrap message into a packet
 func (n *Node) Wrap(msg Message) (pkt *Packet, err error) {
+	hdr := msg.Header()
+	if !n.addr.Equals(hdr.Sender) {
+		return nil, ErrPacketSenderMismatch
+	}
+	// encode message with a codec the receiver can handle
+	common := n.hello.Common(hdr.Receiver)
+	codec := selectCodec(common)
+	buf, err := codec.Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	// compress payload if the receiver can handle it
+	if codec.ID() == CodecData && common&CapCompress != 0 {
+		buf = compressMsg(buf)
+	}
 	// wrap the message into a packet
-	return NewPacket(msg, n.prvKey)
+	caps := n.Capabilities() | uint16(codec.ID())<<capCodecShift
+	return NewPacketFromDataCaps(buf, n.prvKey, hdr.Receiver.PublicKey(), caps)
 }
 
 // Unwrap packet into a message
 func (n *Node) Unwrap(pkt *Packet) (msg Message, err error) {
+	codec, err := getCodec(pkt.Codec())
+	if err != nil {
+		return nil, err
+	}
+	mf := func(buf []byte) (Message, error) {
+		return codec.Decode(buf, n.srvcs.NewMessage)
+	}
 	// decrypt packet into message
-	return pkt.Unwrap(n.prvKey, n.srvcs.MessageFactory)
+	if msg, err = pkt.Unwrap(n.prvKey, mf); err != nil {
+		return
+	}
+	// learn protocol information of sender
+	n.hello.learn(msg.Header().Sender, pkt.Version, pkt.Caps)
+	return
 }
 
 //----------------------------------------------------------------------
@@ -307,6 +489,10 @@ func (n *Node) Learn(addr *Address, endp string) error {
 		if err != nil {
 			return err
 		}
+		// check peer against policy
+		if err = n.Admit(addr, netw); err != nil {
+			return err
+		}
 		return n.conn.Learn(addr, netw)
 	}
 	return nil
@@ -339,13 +525,22 @@ func (n *Node) Sample(num int, skip *Address) []*Address {
 // Run the node with services
 //----------------------------------------------------------------------
 
-// Run the local node
+// Run the local node until the context is cancelled or the node is
+// shut down.
 func (n *Node) Run(ctx context.Context) {
 
+	// the context of a running node is cancelled on shutdown
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	n.runLock.Lock()
+	n.cancel = cancel
+	n.runLock.Unlock()
+
 	// we do periodic jobs once every minute
 	// and remember the epoch we are in
 	epoch := 0
-	period := time.NewTicker(NodeTick)
+	period := n.clock.NewTicker(NodeTick)
+	defer period.Stop()
 
 	// run bucket list processor
 	n.buckets.Run(ctx)
@@ -358,7 +553,17 @@ func (n *Node) Run(ctx context.Context) {
 		select {
 		// process incoming message
 		case msg := <-n.inCh:
+			// no new handlers during shutdown
+			n.runLock.Lock()
+			if n.stopping {
+				n.runLock.Unlock()
+				logger.Printf(logger.DBG, "[%.8s] Shutting down: dropping message %s\n", n.addr, msg)
+				continue
+			}
+			n.handlers.Add(1)
+			n.runLock.Unlock()
 			go func() {
+				defer n.handlers.Done()
 				hdr := msg.Header()
 
 				switch hdr.Type % 2 {
@@ -383,17 +588,77 @@ func (n *Node) Run(ctx context.Context) {
 			}()
 
 		// periodic jobs
-		case <-period.C:
+		case <-period.C():
 			epoch++
 			n.conn.Epoch(epoch)
 
-		// externally cancelled
+		// externally cancelled or shut down
 		case <-ctx.Done():
 			return
+		case <-n.stopped:
+			return
 		}
 	}
 }
 
+// Shutdown the node gracefully: the connector stops accepting incoming
+// messages, in-flight message handlers are drained (until the context
+// is done), then the node context is cancelled and the connector
+// releases its resources (like hidden services and open connections).
+// Returns the context error if handlers were still pending; Stopped()
+// reports the completion of the shutdown.
+func (n *Node) Shutdown(ctx context.Context) (err error) {
+	n.runLock.Lock()
+	if n.stopping {
+		n.runLock.Unlock()
+		return ErrNodeShutdown
+	}
+	n.stopping = true
+	n.runLock.Unlock()
+	logger.Printf(logger.INFO, "[%.8s] Shutting down...\n", n.addr)
+
+	// stop accepting new connections and packets
+	st, ok := n.conn.(Stopper)
+	if ok {
+		if e := st.Stop(); e != nil {
+			logger.Printf(logger.WARN, "[%.8s] Stopping connector failed: %s\n", n.addr, e.Error())
+		}
+	}
+	// drain in-flight handlers
+	drained := make(chan struct{})
+	go func() {
+		n.handlers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		logger.Printf(logger.WARN, "[%.8s] Shutdown: handlers still pending\n", n.addr)
+		err = ctx.Err()
+	}
+	// cancel remaining work of the running node
+	n.runLock.Lock()
+	if n.cancel != nil {
+		n.cancel()
+	}
+	n.runLock.Unlock()
+
+	// release connector resources
+	if ok {
+		if e := st.Close(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	close(n.stopped)
+	logger.Printf(logger.INFO, "[%.8s] Shutdown complete\n", n.addr)
+	return
+}
+
+// Stopped returns a channel that is closed when the node is shut down.
+func (n *Node) Stopped() <-chan struct{} {
+	return n.stopped
+}
+
 //----------------------------------------------------------------------
 // Helper methods
 //----------------------------------------------------------------------
@@ -405,7 +670,6 @@ func (n *Node) Closest(num int) []*Address {
 
 // NextID returns the next unique identifier for this node context
 func (n *Node) NextID() uint64 {
-	n.lastID++
-	return n.lastID
+	return atomic.AddUint64(&n.lastID, 1)
 
 }
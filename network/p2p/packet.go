@@ -22,21 +22,45 @@ package p2p
 
 import (
 	"bytes"
-	"crypto/rand"
 	"crypto/sha256"
 	"errors"
 
+	"github.com/bfix/gospel/crypto"
+	"github.com/bfix/gospel/crypto/aead"
 	"github.com/bfix/gospel/crypto/ed25519"
 	"github.com/bfix/gospel/data"
 	"github.com/bfix/gospel/math"
-	chacha "golang.org/x/crypto/chacha20poly1305"
 )
 
+// packetKeyLabel is the context label for the derivation of packet keys
+const packetKeyLabel = "gospel/p2p/packet"
+
+// Wire format versions
+const (
+	ProtocolVersion    = 1 // version of the wire format
+	MinProtocolVersion = 1 // oldest supported version of the wire format
+)
+
+// Capabilities of a node (bit field); unknown bits are ignored.
+const (
+	CapCompress = 1 << iota // node handles compressed payloads
+	CapFragment             // node handles fragmented messages
+)
+
+// PacketHdrSize is the size of the (unencrypted) packet header (size,
+// version, capabilities and key exchange token).
+const PacketHdrSize = 2 + 2 + 2 + 32
+
+// PacketOverhead is the size difference between a packet and the
+// wrapped message (packet header, nonce and tag).
+const PacketOverhead = PacketHdrSize + 24 + 16
+
 // Error messages
 var (
 	ErrPacketSenderMismatch = errors.New("sender not matching message header")
 	ErrPacketIntegrity      = errors.New("packet integrity violated")
 	ErrPacketSizeMismatch   = errors.New("packet size mismatch")
+	ErrPacketVersion        = errors.New("unsupported packet version")
 )
 
 //----------------------------------------------------------------------
@@ -58,7 +82,7 @@ var (
 //     into a packet and derives a value 'h' as 'h = SHA256(m) mod N'
 //     where 'N' is the group order of Ed25519.
 // (2) The sender computes 'Q = [h*d_s]P_r' (=[h*d_s*d_r]G) as a shared
-//     secret and derives a symmetric encryption key from it.
+//     secret and derives a symmetric encryption key from it (HKDF).
 // (3) The message is encrypted and stored in the 'Body' field of the
 //     packet; the length of the encrypted message can be slightly greater
 //     than the plain message (encryption overhead).
@@ -79,15 +103,35 @@ var (
 //
 //----------------------------------------------------------------------
 
+// Versioning:
+// ===========
+// The packet header carries the version of the wire format and the
+// capabilities of the sender. Both fields are authenticated as
+// associated data of the encrypted body. Receivers reject packets with
+// unsupported versions; capabilities announce optional features (like
+// compression) a peer can handle, so new features can be rolled out
+// without breaking older nodes.
+//
+//----------------------------------------------------------------------
+
 // Packet data structure
 type Packet struct {
-	Size uint16 `order:"big"` // size of packet (including this field)
-	KXT  []byte `size:"32"`   // Key Exchange Token
-	Body []byte `size:"*"`    // encrypted body
+	Size    uint16 `order:"big"` // size of packet (including this field)
+	Version uint16 `order:"big"` // version of wire format
+	Caps    uint16 `order:"big"` // capabilities of sender
+	KXT     []byte `size:"32"`   // Key Exchange Token
+	Body    []byte `size:"*"`    // encrypted body
 }
 
-// NewPacket creates a new packet from a message.
+// NewPacket creates a new packet from a message (without announcing
+// any capabilities).
 func NewPacket(msg Message, skey *ed25519.PrivateKey) (*Packet, error) {
+	return NewPacketCaps(msg, skey, 0)
+}
+
+// NewPacketCaps creates a new packet from a message with the given
+// capabilities of the sender.
+func NewPacketCaps(msg Message, skey *ed25519.PrivateKey, caps uint16) (*Packet, error) {
 	// check if sender is correctly specified in message
 	hdr := msg.Header()
 	sAddr := NewAddressFromKey(skey.Public())
@@ -101,11 +145,22 @@ func NewPacket(msg Message, skey *ed25519.PrivateKey) (*Packet, error) {
 	}
 	// get keys from peers
 	rkey := hdr.Receiver.PublicKey()
-	return NewPacketFromData(buf, skey, rkey)
+	return NewPacketFromDataCaps(buf, skey, rkey, caps)
 }
 
-// NewPacketFromData creates a new packet from a binary object.
+// NewPacketFromData creates a new packet from a binary object (without
+// announcing any capabilities).
 func NewPacketFromData(buf []byte, sender *ed25519.PrivateKey, receiver *ed25519.PublicKey) (*Packet, error) {
+	return NewPacketFromDataCaps(buf, sender, receiver, 0)
+}
+
+// NewPacketFromDataCaps creates a new packet from a binary object with
+// the given capabilities of the sender.
+func NewPacketFromDataCaps(buf []byte, sender *ed25519.PrivateKey, receiver *ed25519.PublicKey, caps uint16) (*Packet, error) {
+	pkt := &Packet{
+		Version: ProtocolVersion,
+		Caps:    caps,
+	}
 
 	// compute 'r = SHA256(b) mod N'
 	rb := sha256.Sum256(buf)
@@ -114,43 +169,49 @@ func NewPacketFromData(buf []byte, sender *ed25519.PrivateKey, receiver *ed25519
 
 	// compute shared secret and derive encryption key
 	Q := receiver.Mult(h.Mul(sender.D))
-
-	// encrypt body with ChaCha20-Poly1305 AEAD
-	aead, err := chacha.New(Q.Bytes())
+	cipher, err := packetCipher(Q)
 	if err != nil {
 		return nil, err
 	}
-	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(buf)+aead.Overhead())
-	if _, err = rand.Read(nonce); err != nil {
+	// encrypt body
+	if pkt.Body, err = cipher.Seal(buf, pkt.header()); err != nil {
 		return nil, err
 	}
-	enc := aead.Seal(nonce, nonce, buf, nil)
 
 	// assemble packet.
 	pubS := sender.Public()
-	return &Packet{
-		Size: uint16(34 + len(enc)),
-		KXT:  pubS.Mult(h).Bytes(),
-		Body: enc,
-	}, nil
+	pkt.Size = uint16(PacketHdrSize + len(pkt.Body))
+	pkt.KXT = pubS.Mult(h).Bytes()
+	return pkt, nil
 }
 
 // Unpack a packet
 func (p *Packet) Unpack(receiver *ed25519.PrivateKey) ([]byte, error) {
+	// check version
+	if p.Version < MinProtocolVersion || p.Version > ProtocolVersion {
+		return nil, ErrPacketVersion
+	}
 	// compute shared secret and derive encryption key
 	Q := ed25519.NewPublicKeyFromBytes(p.KXT).Mult(receiver.D)
-	// decrypt with ChaCha20-Poly1305 AEAD
-	aead, err := chacha.New(Q.Bytes())
+	cipher, err := packetCipher(Q)
 	if err != nil {
 		return nil, err
 	}
-	nonce := p.Body[:aead.NonceSize()]
-	enc := p.Body[aead.NonceSize():]
-	buf, err := aead.Open(nil, nonce, enc, nil)
-	if err != nil {
-		return nil, err
+	// decrypt body
+	return cipher.Open(p.Body, p.header())
+}
+
+// Codec returns the ID of the codec used for the message in the body.
+func (p *Packet) Codec() uint8 {
+	return uint8(p.Caps >> capCodecShift)
+}
+
+// header returns the versioning fields (authenticated in the body)
+func (p *Packet) header() []byte {
+	return []byte{
+		byte(p.Version >> 8), byte(p.Version),
+		byte(p.Caps >> 8), byte(p.Caps),
 	}
-	return buf, nil
 }
 
 // Unwrap a packet
@@ -164,7 +225,13 @@ func (p *Packet) Unwrap(receiver *ed25519.PrivateKey, mf MessageFactory) (Messag
 	h := math.NewIntFromBytes(rb[:])
 	h = h.Mod(ed25519.GetCurve().N)
 
-	// reconstruct message
+	// reconstruct (decompressed) message; only messages encoded with
+	// the default codec can be compressed.
+	if p.Codec() == CodecData {
+		if buf, err = decompressMsg(buf); err != nil {
+			return nil, err
+		}
+	}
 	msg, err := mf(buf)
 	if err != nil {
 		return nil, err
@@ -177,3 +244,13 @@ func (p *Packet) Unwrap(receiver *ed25519.PrivateKey, mf MessageFactory) (Messag
 	// return decrypted message
 	return msg, nil
 }
+
+// packetCipher returns the XChaCha20-Poly1305 AEAD for a packet with
+// the key derived from the shared secret.
+func packetCipher(Q *ed25519.PublicKey) (*aead.AEAD, error) {
+	key, err := crypto.DeriveKey(Q.Bytes(), packetKeyLabel, aead.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return aead.NewXChaCha20Poly1305(key)
+}
@@ -128,3 +128,35 @@ func TestPacket(t *testing.T) {
 		t.Fatal("Message mismatch")
 	}
 }
+
+func TestPacketVersion(t *testing.T) {
+	pubS, prvS := ed25519.NewKeypair()
+	pubR, prvR := ed25519.NewKeypair()
+	msg := &TestMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize,
+			Type:     ReqPING,
+			Receiver: NewAddressFromKey(pubR),
+			Sender:   NewAddressFromKey(pubS),
+		},
+	}
+	buf, err := data.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt, err := NewPacketFromDataCaps(buf, prvS, pubR, CapCompress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// header fields are authenticated
+	pkt.Caps = 0
+	if _, err = pkt.Unpack(prvR); err == nil {
+		t.Fatal("modified capabilities accepted")
+	}
+	// unsupported version
+	pkt.Caps = CapCompress
+	pkt.Version = ProtocolVersion + 1
+	if _, err = pkt.Unpack(prvR); err != ErrPacketVersion {
+		t.Fatalf("unsupported version accepted: %v", err)
+	}
+}
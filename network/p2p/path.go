@@ -0,0 +1,245 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// Error codes
+var (
+	ErrPathConfig   = errors.New("invalid path configuration")
+	ErrPathNoRelays = errors.New("not enough relays for path")
+)
+
+// Path parameters
+const (
+	PathMinHops = 1 // min. number of relays in a path
+	PathMaxHops = 3 // max. number of relays in a path
+)
+
+//----------------------------------------------------------------------
+// Relay path selection:
+// Relay chains for onion-like routing (see Node.RelayedMessage) are
+// selected from the routing table subject to constraints: relays can be
+// required to be in distinct networks (IPv4 /16, IPv6 /32 or distinct
+// onion services, i.e. distinct Tor circuits) and relays used in recent
+// paths can be excluded. Paths to a destination are cached and rotated
+// periodically.
+//----------------------------------------------------------------------
+
+// PathConfig are the constraints for relay paths
+type PathConfig struct {
+	Hops          int           // number of relays (1..3)
+	DistinctNet   bool          // relays in distinct networks?
+	ExcludeRecent int           // number of recently used relays to avoid
+	Rotate        time.Duration // lifetime of a path (0: new path every time)
+}
+
+// DefaultPathConfig returns the default path constraints.
+func DefaultPathConfig() *PathConfig {
+	return &PathConfig{
+		Hops:          2,
+		DistinctNet:   true,
+		ExcludeRecent: 0,
+		Rotate:        10 * time.Minute,
+	}
+}
+
+// Check the path constraints
+func (c *PathConfig) Check() error {
+	if c.Hops < PathMinHops || c.Hops > PathMaxHops {
+		return fmt.Errorf("%w: hops %d not in [%d,%d]", ErrPathConfig, c.Hops, PathMinHops, PathMaxHops)
+	}
+	if c.ExcludeRecent < 0 || c.Rotate < 0 {
+		return ErrPathConfig
+	}
+	return nil
+}
+
+// relayPath is a cached path to a destination
+type relayPath struct {
+	hops    []*Address // relays (in RelayedMessage order)
+	created time.Time  // time of creation
+}
+
+// PathSelector selects relay paths for a node
+type PathSelector struct {
+	node   *Node                 // node selecting paths
+	cfg    *PathConfig           // path constraints
+	paths  map[string]*relayPath // cached paths (by destination)
+	recent []*Address            // recently used relays (newest last)
+	lock   sync.Mutex            // lock for concurrent access
+}
+
+// NewPathSelector creates a path selector for a node. If 'cfg' is nil,
+// the default constraints are used.
+func NewPathSelector(n *Node, cfg *PathConfig) (*PathSelector, error) {
+	if cfg == nil {
+		cfg = DefaultPathConfig()
+	}
+	if err := cfg.Check(); err != nil {
+		return nil, err
+	}
+	return &PathSelector{
+		node:   n,
+		cfg:    cfg,
+		paths:  make(map[string]*relayPath),
+		recent: make([]*Address, 0),
+	}, nil
+}
+
+// Select returns a relay path to a destination: a cached path is used
+// until it expires. The relays are in the order expected by
+// RelayedMessage (the last relay is the first hop).
+func (ps *PathSelector) Select(dst *Address) ([]*Address, error) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	now := ps.node.Clock().Now()
+	key := dst.String()
+	if p, ok := ps.paths[key]; ok && ps.cfg.Rotate > 0 && now.Sub(p.created) < ps.cfg.Rotate {
+		return p.hops, nil
+	}
+	hops, err := ps.newPath(dst)
+	if err != nil {
+		return nil, err
+	}
+	ps.paths[key] = &relayPath{
+		hops:    hops,
+		created: now,
+	}
+	return hops, nil
+}
+
+// Invalidate the cached path to a destination (e.g. after a failure).
+func (ps *PathSelector) Invalidate(dst *Address) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	delete(ps.paths, dst.String())
+}
+
+// Wrap a message into a nested relay message along the selected path.
+func (ps *PathSelector) Wrap(msg Message) (Message, error) {
+	hops, err := ps.Select(msg.Header().Receiver)
+	if err != nil {
+		return nil, err
+	}
+	return ps.node.RelayedMessage(msg, hops)
+}
+
+// newPath selects a new path (must be called with lock held)
+func (ps *PathSelector) newPath(dst *Address) ([]*Address, error) {
+	self := ps.node.Address()
+	used := make(map[string]bool)
+	for _, a := range ps.recent {
+		used[a.String()] = true
+	}
+	groups := make(map[string]bool)
+	if netw := ps.node.Resolve(dst); netw != nil {
+		groups[NetGroup(netw)] = true
+	}
+	// collect candidates in random order (unpredictable relay selection)
+	cand := ps.node.Routing().Peers()
+	if err := shuffle(len(cand), func(i, j int) { cand[i], cand[j] = cand[j], cand[i] }); err != nil {
+		return nil, err
+	}
+
+	hops := make([]*Address, 0, ps.cfg.Hops)
+	for _, a := range cand {
+		if len(hops) == ps.cfg.Hops {
+			break
+		}
+		if a.Equals(self) || a.Equals(dst) || used[a.String()] {
+			continue
+		}
+		// relays must be reachable
+		netw := ps.node.Resolve(a)
+		if netw == nil {
+			continue
+		}
+		if ps.cfg.DistinctNet {
+			grp := NetGroup(netw)
+			if groups[grp] {
+				continue
+			}
+			groups[grp] = true
+		}
+		hops = append(hops, a)
+	}
+	if len(hops) < ps.cfg.Hops {
+		return nil, ErrPathNoRelays
+	}
+	// remember recently used relays
+	if ps.cfg.ExcludeRecent > 0 {
+		ps.recent = append(ps.recent, hops...)
+		if n := len(ps.recent) - ps.cfg.ExcludeRecent; n > 0 {
+			ps.recent = ps.recent[n:]
+		}
+	}
+	return hops, nil
+}
+
+// shuffle elements with a cryptographically secure random generator
+// (Fisher-Yates).
+func shuffle(n int, swap func(i, j int)) error {
+	for i := n - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return err
+		}
+		swap(i, int(j.Int64()))
+	}
+	return nil
+}
+
+// NetGroup returns the network group of an address: the /16 network for
+// IPv4, the /32 network for IPv6 and the onion for Tor addresses. Each
+// onion is reached over its own circuit, so distinct onions are distinct
+// groups. Other addresses are their own group.
+func NetGroup(addr net.Addr) string {
+	var ip net.IP
+	switch x := addr.(type) {
+	case *net.UDPAddr:
+		ip = x.IP
+	case *net.TCPAddr:
+		ip = x.IP
+	case *TorAddress:
+		return "tor:" + x.String()
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			host = addr.String()
+		}
+		if ip = net.ParseIP(host); ip == nil {
+			return addr.Network() + ":" + addr.String()
+		}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d", ip4[0], ip4[1])
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
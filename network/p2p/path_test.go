@@ -0,0 +1,159 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	gtime "github.com/bfix/gospel/time"
+)
+
+func TestNetGroup(t *testing.T) {
+	list := []struct {
+		addr net.Addr
+		grp  string
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1}, "10.1"},
+		{&net.UDPAddr{IP: net.ParseIP("10.1.200.3"), Port: 2}, "10.1"},
+		{&net.TCPAddr{IP: net.ParseIP("10.2.2.3"), Port: 1}, "10.2"},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8:1::1"), Port: 1}, "2001:db8::"},
+	}
+	for _, e := range list {
+		if grp := NetGroup(e.addr); grp != e.grp {
+			t.Fatalf("%s: got %s, expected %s", e.addr, grp, e.grp)
+		}
+	}
+}
+
+func TestPathSelector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// create fully connected nodes sharing a virtual clock
+	clk := gtime.NewFakeClock(time.Now())
+	trans := NewLocalTransport()
+	names := []string{"n0", "n1", "n2", "n3", "n4", "n5"}
+	nodes := make([]*Node, len(names))
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.SetClock(clk)
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+	}
+	for i, n := range nodes {
+		for j, peer := range nodes {
+			if i != j {
+				if err := n.Learn(peer.Address(), names[j]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	// check configuration
+	if _, err := NewPathSelector(nodes[0], &PathConfig{Hops: 4}); !errors.Is(err, ErrPathConfig) {
+		t.Fatal("invalid hops accepted")
+	}
+	ps, err := NewPathSelector(nodes[0], &PathConfig{
+		Hops:          2,
+		DistinctNet:   true,
+		ExcludeRecent: 2,
+		Rotate:        time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := nodes[5].Address()
+	path, err := ps.Select(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) != 2 || path[0].Equals(path[1]) {
+		t.Fatal("invalid path")
+	}
+	for _, hop := range path {
+		if hop.Equals(dst) || hop.Equals(nodes[0].Address()) {
+			t.Fatal("endpoint used as relay")
+		}
+	}
+	// cached path until rotation
+	again, err := ps.Select(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again[0].Equals(path[0]) || !again[1].Equals(path[1]) {
+		t.Fatal("path not cached")
+	}
+	// new path after rotation must avoid recent relays
+	clk.Advance(2 * time.Minute)
+	next, err := ps.Select(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hop := range next {
+		if hop.Equals(path[0]) || hop.Equals(path[1]) {
+			t.Fatal("recent relay re-used")
+		}
+	}
+	// only the last relays are excluded
+	ps.Invalidate(dst)
+	third, err := ps.Select(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hop := range third {
+		if hop.Equals(next[0]) || hop.Equals(next[1]) {
+			t.Fatal("recent relay re-used")
+		}
+	}
+	// not enough relays left
+	ps.cfg.Hops = 3
+	ps.Invalidate(dst)
+	if _, err = ps.Select(dst); !errors.Is(err, ErrPathNoRelays) {
+		t.Fatalf("expected no relays: %v", err)
+	}
+	// wrap message (sender must announce its own endpoint)
+	if err = nodes[0].Learn(nodes[0].Address(), names[0]); err != nil {
+		t.Fatal(err)
+	}
+	ps.cfg.Hops = 2
+	ps.Invalidate(dst)
+	msg, _ := NewPingMsg().(*PingMsg)
+	msg.Sender = nodes[0].Address()
+	msg.Receiver = dst
+	wrapped, err := ps.Wrap(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped.Header().Type != ReqRELAY {
+		t.Fatal("message not relayed")
+	}
+}
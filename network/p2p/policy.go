@@ -0,0 +1,227 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	gerr "github.com/bfix/gospel/errors"
+)
+
+// Error codes
+var (
+	ErrPolicyDenied     = errors.New("peer is denied")
+	ErrPolicyNotAllowed = errors.New("peer not allowed")
+	ErrPolicyPinChanged = errors.New("pinned endpoint changed")
+	ErrPolicyVetoed     = errors.New("peer vetoed")
+)
+
+//======================================================================
+// Peer authentication policy:
+// A policy restricts the set of peers a node is talking to. Peers are
+// identified by their P2P address; a policy can hold an allowlist (if
+// not empty, only listed peers are accepted) and a denylist (listed
+// peers are always rejected).
+// Network endpoints of peers are pinned on first use (TOFU): if a peer
+// shows up later with a different endpoint, the change is reported
+// to the application and the peer is either rejected or re-pinned.
+// Applications can register vetos to reject peers based on custom
+// decisions.
+//======================================================================
+
+// PeerVeto is a function called for every peer that passed the
+// list and pinning checks. A non-nil error rejects the peer.
+// The network address can be nil if it is unknown (e.g. for relayed
+// messages).
+type PeerVeto func(addr *Address, netw net.Addr) error
+
+// PinAlert is called if a peer is seen with an endpoint different from
+// the pinned one.
+type PinAlert func(addr *Address, pinned, seen string)
+
+// PeerPolicy for authentication of peers
+type PeerPolicy struct {
+	// Repin accepts changed endpoints and pins the new endpoint (after
+	// alerting). If not set, peers with changed endpoints are rejected.
+	Repin bool
+
+	// OnPinChange is called whenever a pinned endpoint changed
+	OnPinChange PinAlert
+
+	allow map[string]bool   // allowed peers
+	deny  map[string]bool   // denied peers
+	pins  map[string]string // pinned endpoints of peers
+	vetos []PeerVeto        // list of application vetos
+	lock  sync.RWMutex      // lock for concurrent access
+}
+
+// NewPeerPolicy returns a new (open) policy: all peers are accepted and
+// endpoints are pinned on first use.
+func NewPeerPolicy() *PeerPolicy {
+	return &PeerPolicy{
+		Repin:       false,
+		OnPinChange: nil,
+		allow:       make(map[string]bool),
+		deny:        make(map[string]bool),
+		pins:        make(map[string]string),
+		vetos:       make([]PeerVeto, 0),
+	}
+}
+
+// Allow adds peer addresses to the allowlist. As soon as the allowlist
+// has entries, only listed peers are accepted.
+func (p *PeerPolicy) Allow(addrs ...*Address) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, addr := range addrs {
+		p.allow[addr.String()] = true
+	}
+}
+
+// Disallow removes peer addresses from the allowlist.
+func (p *PeerPolicy) Disallow(addrs ...*Address) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, addr := range addrs {
+		delete(p.allow, addr.String())
+	}
+}
+
+// Deny adds peer addresses to the denylist.
+func (p *PeerPolicy) Deny(addrs ...*Address) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, addr := range addrs {
+		p.deny[addr.String()] = true
+	}
+}
+
+// Undeny removes peer addresses from the denylist.
+func (p *PeerPolicy) Undeny(addrs ...*Address) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, addr := range addrs {
+		delete(p.deny, addr.String())
+	}
+}
+
+// Pin the endpoint of a peer explicitly (replacing an existing pin).
+func (p *PeerPolicy) Pin(addr *Address, endp string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.pins[addr.String()] = endp
+}
+
+// Unpin removes the pinned endpoint of a peer; the next endpoint seen
+// for the peer is pinned again.
+func (p *PeerPolicy) Unpin(addr *Address) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.pins, addr.String())
+}
+
+// Pinned returns the pinned endpoint of a peer (or an empty string
+// if no endpoint is pinned yet).
+func (p *PeerPolicy) Pinned(addr *Address) string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.pins[addr.String()]
+}
+
+// Pins returns a copy of all pinned peer endpoints (mapping the string
+// representation of a peer address to its endpoint).
+func (p *PeerPolicy) Pins() map[string]string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	res := make(map[string]string)
+	for k, v := range p.pins {
+		res[k] = v
+	}
+	return res
+}
+
+// AddVeto registers an application veto function.
+func (p *PeerPolicy) AddVeto(f PeerVeto) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.vetos = append(p.vetos, f)
+}
+
+// Check if a peer (with optional network address) is accepted by the
+// policy. The network endpoint is pinned on first use.
+func (p *PeerPolicy) Check(addr *Address, netw net.Addr) (err error) {
+	key := addr.String()
+
+	// check allow/deny lists
+	p.lock.RLock()
+	denied := p.deny[key]
+	allowed := len(p.allow) == 0 || p.allow[key]
+	vetos := p.vetos
+	p.lock.RUnlock()
+	if denied {
+		return gerr.New(ErrPolicyDenied, "%.8s", addr)
+	}
+	if !allowed {
+		return gerr.New(ErrPolicyNotAllowed, "%.8s", addr)
+	}
+	// check pinned endpoint (if network address is known)
+	if netw != nil {
+		if err = p.pin(addr, netw.String()); err != nil {
+			return
+		}
+	}
+	// ask application
+	for _, veto := range vetos {
+		if err = veto(addr, netw); err != nil {
+			return gerr.New(ErrPolicyVetoed, "%.8s: %s", addr, err.Error())
+		}
+	}
+	return nil
+}
+
+// pin endpoint for peer (trust-on-first-use)
+func (p *PeerPolicy) pin(addr *Address, endp string) error {
+	p.lock.Lock()
+	key := addr.String()
+	pinned, ok := p.pins[key]
+	if !ok || pinned == endp {
+		// first use or unchanged endpoint
+		p.pins[key] = endp
+		p.lock.Unlock()
+		return nil
+	}
+	// endpoint changed
+	if p.Repin {
+		p.pins[key] = endp
+	}
+	repin, alert := p.Repin, p.OnPinChange
+	p.lock.Unlock()
+
+	if alert != nil {
+		alert(addr, pinned, endp)
+	}
+	if !repin {
+		return gerr.New(ErrPolicyPinChanged, "%.8s: %s -> %s", addr, pinned, endp)
+	}
+	return nil
+}
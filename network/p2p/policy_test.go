@@ -0,0 +1,140 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+)
+
+func newTestAddress() *Address {
+	pub, _ := ed25519.NewKeypair()
+	return NewAddressFromKey(pub)
+}
+
+func TestPolicyLists(t *testing.T) {
+	a1 := newTestAddress()
+	a2 := newTestAddress()
+	p := NewPeerPolicy()
+
+	// open policy
+	if err := p.Check(a1, nil); err != nil {
+		t.Fatal(err)
+	}
+	// denylist
+	p.Deny(a1)
+	if err := p.Check(a1, nil); !errors.Is(err, ErrPolicyDenied) {
+		t.Fatalf("expected denied peer: %v", err)
+	}
+	p.Undeny(a1)
+	// allowlist
+	p.Allow(a1)
+	if err := p.Check(a1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Check(a2, nil); !errors.Is(err, ErrPolicyNotAllowed) {
+		t.Fatalf("expected disallowed peer: %v", err)
+	}
+	// veto
+	p.AddVeto(func(addr *Address, netw net.Addr) error {
+		return errors.New("no way")
+	})
+	if err := p.Check(a1, nil); !errors.Is(err, ErrPolicyVetoed) {
+		t.Fatalf("expected vetoed peer: %v", err)
+	}
+}
+
+func TestPolicyPinning(t *testing.T) {
+	addr := newTestAddress()
+	e1 := NewLocalAddress("peer1")
+	e2 := NewLocalAddress("peer2")
+
+	alerts := 0
+	p := NewPeerPolicy()
+	p.OnPinChange = func(a *Address, pinned, seen string) {
+		if pinned != e1.String() || seen != e2.String() {
+			t.Fatalf("wrong pin alert: %s -> %s", pinned, seen)
+		}
+		alerts++
+	}
+	// first use
+	if err := p.Check(addr, e1); err != nil {
+		t.Fatal(err)
+	}
+	if p.Pinned(addr) != e1.String() {
+		t.Fatal("endpoint not pinned")
+	}
+	// changed endpoint
+	if err := p.Check(addr, e2); !errors.Is(err, ErrPolicyPinChanged) {
+		t.Fatalf("expected pin change: %v", err)
+	}
+	// re-pinning
+	p.Repin = true
+	if err := p.Check(addr, e2); err != nil {
+		t.Fatal(err)
+	}
+	if p.Pinned(addr) != e2.String() {
+		t.Fatal("endpoint not re-pinned")
+	}
+	if alerts != 2 {
+		t.Fatalf("expected 2 alerts, got %d", alerts)
+	}
+}
+
+func TestPolicySwap(t *testing.T) {
+	_, prv := ed25519.NewKeypair()
+	n, err := NewNode(prv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := newTestAddress()
+
+	// replace the policy while peers are admitted
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			p := NewPeerPolicy()
+			if i%2 == 1 {
+				p.Deny(addr)
+			}
+			n.SetPolicy(p)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if err := n.Admit(addr, nil); err != nil && !errors.Is(err, ErrPolicyDenied) {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+	n.SetPolicy(nil)
+	if n.Policy() != nil || n.Admit(addr, nil) != nil {
+		t.Fatal("policy not removed")
+	}
+}
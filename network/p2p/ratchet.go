@@ -0,0 +1,313 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/bfix/gospel/crypto"
+	"github.com/bfix/gospel/crypto/aead"
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/math"
+)
+
+// Error codes
+var (
+	ErrRatchetNoChain = errors.New("no sending chain in ratchet")
+	ErrRatchetSkip    = errors.New("too many skipped messages")
+	ErrRatchetKey     = errors.New("invalid ratchet key")
+)
+
+// RatchetMaxSkip is the maximum number of message keys skipped in a
+// single chain. At most twice as many skipped keys are stored for
+// out-of-order messages; the oldest keys are evicted first.
+var RatchetMaxSkip = 1000
+
+//======================================================================
+// Double ratchet (see "The Double Ratchet Algorithm", Signal, 2016):
+// Every message is encrypted with a new message key derived from a
+// symmetric-key ratchet (chain key). A Diffie-Hellman ratchet step is
+// performed whenever a new ratchet key of the peer is received; it
+// resets the chains and provides forward secrecy and recovery from
+// compromised chain keys.
+// Ed25519 keys are used for Diffie-Hellman key agreement ('[d_a]P_b').
+// Both parties start with fresh ratchet keys: the first sending chain of
+// the initiator is derived from the shared secret alone; the responder
+// learns the initial ratchet key of the initiator from the first message.
+//======================================================================
+
+// ratchetHeader is sent along with every encrypted message
+type ratchetHeader struct {
+	DH []byte // ratchet public key of sender
+	PN uint32 // number of messages in previous sending chain
+	N  uint32 // message number in sending chain
+}
+
+// bytes returns the binary representation of a header
+func (h *ratchetHeader) bytes() []byte {
+	buf := make([]byte, 40)
+	copy(buf, h.DH)
+	binary.BigEndian.PutUint32(buf[32:], h.PN)
+	binary.BigEndian.PutUint32(buf[36:], h.N)
+	return buf
+}
+
+// ratchet state of a session
+type ratchet struct {
+	dhs     *ed25519.PrivateKey // own ratchet key
+	dhr     *ed25519.PublicKey  // peer ratchet key
+	rk      []byte              // root key
+	cks     []byte              // sending chain key
+	ckr     []byte              // receiving chain key
+	ns, nr  uint32              // message numbers (sending, receiving)
+	pn      uint32              // number of messages in previous sending chain
+	skipped map[string][]byte   // skipped message keys
+	order   []string            // skipped message keys (oldest first)
+}
+
+// newRatchetInitiator creates the ratchet of the party starting the
+// session: 'sk' is the shared secret.
+func newRatchetInitiator(sk []byte) (r *ratchet, err error) {
+	_, dhs := ed25519.NewKeypair()
+	r = &ratchet{
+		dhs:     dhs,
+		skipped: make(map[string][]byte),
+	}
+	r.rk, r.cks, err = kdfRK(sk, nil)
+	return
+}
+
+// newRatchetResponder creates the ratchet of the responding party: 'sk'
+// is the shared secret and 'peer' the initial ratchet key of the
+// initiator (from the first received message). The responder uses a
+// fresh ratchet key for its first sending chain.
+func newRatchetResponder(sk, peer []byte) (r *ratchet, err error) {
+	_, dhs := ed25519.NewKeypair()
+	r = &ratchet{
+		dhs:     dhs,
+		skipped: make(map[string][]byte),
+	}
+	if r.dhr, err = ratchetKey(peer); err != nil {
+		return
+	}
+	if r.rk, r.ckr, err = kdfRK(sk, nil); err != nil {
+		return
+	}
+	r.rk, r.cks, err = kdfRK(r.rk, dh(r.dhs, r.dhr))
+	return
+}
+
+// encrypt a message with associated data
+func (r *ratchet) encrypt(plain, ad []byte) (hdr *ratchetHeader, ct []byte, err error) {
+	if r.cks == nil {
+		return nil, nil, ErrRatchetNoChain
+	}
+	var mk []byte
+	r.cks, mk = kdfCK(r.cks)
+	hdr = &ratchetHeader{
+		DH: r.dhs.Public().Bytes(),
+		PN: r.pn,
+		N:  r.ns,
+	}
+	r.ns++
+	ct, err = seal(mk, plain, concat(ad, hdr.bytes()))
+	return
+}
+
+// decrypt a message with associated data. The state of the ratchet is
+// only changed if the message is authentic.
+func (r *ratchet) decrypt(hdr *ratchetHeader, ct, ad []byte) ([]byte, error) {
+	ad = concat(ad, hdr.bytes())
+
+	// check for skipped message
+	id := skipID(hdr.DH, hdr.N)
+	if mk, ok := r.skipped[id]; ok {
+		plain, err := open(mk, ct, ad)
+		if err == nil {
+			r.drop(id)
+		}
+		return plain, err
+	}
+	// work on a copy of the state
+	s := r.clone()
+	if s.dhr == nil || !bytes.Equal(hdr.DH, s.dhr.Bytes()) {
+		// DH ratchet step
+		if err := s.skip(hdr.PN); err != nil {
+			return nil, err
+		}
+		if err := s.step(hdr.DH); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.skip(hdr.N); err != nil {
+		return nil, err
+	}
+	var mk []byte
+	s.ckr, mk = kdfCK(s.ckr)
+	s.nr++
+	plain, err := open(mk, ct, ad)
+	if err != nil {
+		return nil, err
+	}
+	// commit new state
+	*r = *s
+	return plain, nil
+}
+
+// skip message keys in the receiving chain up to message 'n'
+func (r *ratchet) skip(n uint32) error {
+	if r.ckr == nil {
+		return nil
+	}
+	if int(n)-int(r.nr) > RatchetMaxSkip {
+		return ErrRatchetSkip
+	}
+	var mk []byte
+	for r.nr < n {
+		r.ckr, mk = kdfCK(r.ckr)
+		id := skipID(r.dhr.Bytes(), r.nr)
+		r.skipped[id] = mk
+		r.order = append(r.order, id)
+		r.nr++
+	}
+	// evict oldest skipped keys
+	if k := len(r.order) - 2*RatchetMaxSkip; k > 0 {
+		for _, id := range r.order[:k] {
+			delete(r.skipped, id)
+		}
+		r.order = append([]string(nil), r.order[k:]...)
+	}
+	return nil
+}
+
+// drop a used skipped message key
+func (r *ratchet) drop(id string) {
+	delete(r.skipped, id)
+	for i, oid := range r.order {
+		if oid == id {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// step performs a DH ratchet step for a new peer ratchet key
+func (r *ratchet) step(key []byte) (err error) {
+	if r.dhr, err = ratchetKey(key); err != nil {
+		return
+	}
+	r.pn = r.ns
+	r.ns, r.nr = 0, 0
+	if r.rk, r.ckr, err = kdfRK(r.rk, dh(r.dhs, r.dhr)); err != nil {
+		return
+	}
+	_, r.dhs = ed25519.NewKeypair()
+	r.rk, r.cks, err = kdfRK(r.rk, dh(r.dhs, r.dhr))
+	return
+}
+
+// clone the ratchet state
+func (r *ratchet) clone() *ratchet {
+	s := *r
+	s.skipped = make(map[string][]byte)
+	for k, v := range r.skipped {
+		s.skipped[k] = v
+	}
+	s.order = append([]string(nil), r.order...)
+	return &s
+}
+
+//----------------------------------------------------------------------
+// Helper functions
+//----------------------------------------------------------------------
+
+// ratchetKey decodes a ratchet key of the peer: the key must be a point
+// on the curve outside of the small-order subgroup.
+func ratchetKey(buf []byte) (*ed25519.PublicKey, error) {
+	if len(buf) != 32 {
+		return nil, ErrRatchetKey
+	}
+	pub := ed25519.NewPublicKeyFromBytes(buf)
+	if pub == nil || !pub.Q.IsOnCurve() || pub.Q.Mult(math.EIGHT).IsInf() {
+		return nil, ErrRatchetKey
+	}
+	return pub, nil
+}
+
+// dh computes a shared secret from a private and a public key
+func dh(prv *ed25519.PrivateKey, pub *ed25519.PublicKey) []byte {
+	return pub.Mult(prv.D).Bytes()
+}
+
+// kdfRK derives a new root key and chain key
+func kdfRK(rk, dhOut []byte) (rk2, ck []byte, err error) {
+	var out []byte
+	if out, err = crypto.HKDF(sha256.New, dhOut, rk, []byte("gospel/p2p/ratchet"), 64); err != nil {
+		return
+	}
+	return out[:32], out[32:], nil
+}
+
+// kdfCK derives the next chain key and a message key
+func kdfCK(ck []byte) (ck2, mk []byte) {
+	h := hmac.New(sha256.New, ck)
+	h.Write([]byte{1})
+	mk = h.Sum(nil)
+	h = hmac.New(sha256.New, ck)
+	h.Write([]byte{2})
+	ck2 = h.Sum(nil)
+	return
+}
+
+// seal a message with a message key
+func seal(mk, plain, ad []byte) ([]byte, error) {
+	a, err := aead.NewXChaCha20Poly1305(mk)
+	if err != nil {
+		return nil, err
+	}
+	return a.Seal(plain, ad)
+}
+
+// open a message with a message key
+func open(mk, ct, ad []byte) ([]byte, error) {
+	a, err := aead.NewXChaCha20Poly1305(mk)
+	if err != nil {
+		return nil, err
+	}
+	return a.Open(ct, ad)
+}
+
+// concat returns a new byte array with the concatenated arguments
+func concat(a, b []byte) []byte {
+	buf := make([]byte, 0, len(a)+len(b))
+	return append(append(buf, a...), b...)
+}
+
+// skipID returns the identifier of a skipped message key
+func skipID(key []byte, n uint32) string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(key), n)
+}
@@ -30,7 +30,9 @@ import (
 	"time"
 
 	"github.com/bfix/gospel/crypto/ed25519"
+	gerr "github.com/bfix/gospel/errors"
 	"github.com/bfix/gospel/math"
+	gtime "github.com/bfix/gospel/time"
 )
 
 const (
@@ -159,60 +161,126 @@ type drop struct {
 }
 
 // create a new drop instance
-func newDrop(addr *Address) *drop {
+func newDrop(addr *Address, now time.Time) *drop {
 	return &drop{
 		addr: addr,
-		seen: time.Now(),
+		seen: now,
 	}
 }
 
 // update drop instance
-func (d *drop) update() {
-	d.seen = time.Now()
+func (d *drop) update(now time.Time) {
+	d.seen = now
 }
 
 // check if a drop has expired
-func (d *drop) expired() bool {
-	return time.Since(d.seen).Seconds() > BucketTTLSecs
+func (d *drop) expired(now time.Time, ttl time.Duration) bool {
+	return now.Sub(d.seen) > ttl
 }
 
 //----------------------------------------------------------------------
-// Routing buckets
+// Routing table configuration
 //----------------------------------------------------------------------
 
+// Eviction policies for stale peers in full buckets
+const (
+	EvictPing  = iota // ping stale peer and evict it if unresponsive
+	EvictStale        // evict stale peers without pinging
+	EvictNever        // never evict peers (new peers are cached only)
+)
+
 var (
 	// KBuckets is the number of entries in a bucket per address bit.
 	KBuckets int = 20
+
+	// ErrBucketConfig for invalid routing table configurations
+	ErrBucketConfig = errors.New("invalid bucket configuration")
 )
 
+// BucketConfig holds the parameters of a routing table. Small private
+// networks can do with few (or even one) buckets, while large public
+// networks profit from bigger buckets and deeper replacement caches.
+type BucketConfig struct {
+	K            int           // max. number of peers in a bucket
+	Buckets      int           // number of buckets (1..256)
+	Replacements int           // depth of replacement cache per bucket
+	TTL          time.Duration // time after which a peer is stale
+	Eviction     int           // eviction policy for stale peers
+}
+
+// DefaultBucketConfig returns the standard (Kademlia) configuration.
+func DefaultBucketConfig() *BucketConfig {
+	return &BucketConfig{
+		K:            KBuckets,
+		Buckets:      256,
+		Replacements: KBuckets,
+		TTL:          BucketTTLSecs * time.Second,
+		Eviction:     EvictPing,
+	}
+}
+
+// Check the configuration for valid values.
+func (c *BucketConfig) Check() error {
+	switch {
+	case c.K < 1:
+		return gerr.New(ErrBucketConfig, "bucket size %d", c.K)
+	case c.Buckets < 1 || c.Buckets > 256:
+		return gerr.New(ErrBucketConfig, "bucket count %d", c.Buckets)
+	case c.Replacements < 0:
+		return gerr.New(ErrBucketConfig, "cache depth %d", c.Replacements)
+	case c.TTL <= 0:
+		return gerr.New(ErrBucketConfig, "TTL %s", c.TTL)
+	case c.Eviction < EvictPing || c.Eviction > EvictNever:
+		return gerr.New(ErrBucketConfig, "eviction policy %d", c.Eviction)
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------
+// Routing buckets
+//----------------------------------------------------------------------
+
 // Bucket is used to store nodes depending on their distance to a
 // reference node (the local node usually). All addresses in one bucket have
 // the same distance value.
 // A bucket is ordered: LRU addresses are at the beginning of the list, the
 // MRU addresses are at the end (Kademlia scheme)
+// Peers that don't fit into a full bucket are kept in a replacement cache
+// (MRU at the end) and replace evicted peers.
 type Bucket struct {
-	num   int        // bucket number (for log purposes)
-	addrs []*drop    // list of addresses
-	lock  sync.Mutex // lock for list access
-	count int        // number of addresses in bucket
+	num   int         // bucket number (for log purposes)
+	addrs []*drop     // list of addresses
+	cache []*drop     // replacement cache
+	depth int         // max. size of replacement cache
+	lock  sync.Mutex  // lock for list access
+	count int         // number of addresses in bucket
+	clock gtime.Clock // clock for ageing entries
 }
 
-// NewBucket returns a new bucket of given length.
+// NewBucket returns a new bucket of default size.
 func NewBucket(n int) *Bucket {
+	return newBucket(n, KBuckets, KBuckets)
+}
+
+// create a new bucket with given size and cache depth
+func newBucket(n, k, depth int) *Bucket {
 	return &Bucket{
 		num:   n,
-		addrs: make([]*drop, KBuckets),
+		addrs: make([]*drop, k),
+		cache: make([]*drop, 0),
+		depth: depth,
 		count: 0,
+		clock: gtime.Real,
 	}
 }
 
 // Add address to bucket. Returns false if the bucket is full.
 func (b *Bucket) Add(addr *Address) bool {
-	if b.count < KBuckets {
-		b.lock.Lock()
-		b.addrs[b.count] = newDrop(addr)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.count < len(b.addrs) {
+		b.addrs[b.count] = newDrop(addr, b.clock.Now())
 		b.count++
-		b.lock.Unlock()
 		return true
 	}
 	return false
@@ -235,43 +303,107 @@ func (b *Bucket) Contains(addr *Address) int {
 	return -1
 }
 
-// Expired returns true if the indexed drop has expired.
-func (b *Bucket) Expired(pos int) bool {
+// Expired returns true if the indexed drop is older than ttl.
+func (b *Bucket) Expired(pos int, ttl time.Duration) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
 	// check range
-	if pos < 0 || pos > b.count-2 {
+	if pos < 0 || pos >= b.count {
 		// skip if outside of range
 		return false
 	}
-	return b.addrs[pos].expired()
+	return b.addrs[pos].expired(b.clock.Now(), ttl)
 }
 
 // Update changes and moves the address from position to end of list (tail)
 // If addr is nil, the currently stored address is moved.
 func (b *Bucket) Update(pos int, drop *drop) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
 	// check range
-	if pos < 0 || pos > b.count-2 {
+	if pos < 0 || pos >= b.count {
 		// skip if outside of range
 		return
 	}
-	b.lock.Lock()
 	if drop == nil {
 		drop = b.addrs[pos]
-		drop.update()
+		drop.update(b.clock.Now())
 	}
 	copy(b.addrs[pos:b.count-1], b.addrs[pos+1:b.count])
 	b.addrs[b.count-1] = drop
-	b.lock.Unlock()
+}
+
+// Remove the address at given position. The most recent entry in the
+// replacement cache (if any) takes its place.
+func (b *Bucket) Remove(pos int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	// check range
+	if pos < 0 || pos >= b.count {
+		return
+	}
+	copy(b.addrs[pos:b.count-1], b.addrs[pos+1:b.count])
+	b.count--
+	b.addrs[b.count] = nil
+	if n := len(b.cache); n > 0 {
+		b.addrs[b.count] = b.cache[n-1]
+		b.cache = b.cache[:n-1]
+		b.count++
+	}
+}
+
+// Cache an address in the replacement cache. If the cache is full, the
+// oldest entry is dropped.
+func (b *Bucket) Cache(addr *Address) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.depth == 0 {
+		return
+	}
+	// move known address to the tail
+	for i, d := range b.cache {
+		if d.addr.Equals(addr) {
+			b.cache = append(b.cache[:i], b.cache[i+1:]...)
+			break
+		}
+	}
+	if len(b.cache) == b.depth {
+		b.cache = b.cache[1:]
+	}
+	b.cache = append(b.cache, newDrop(addr, b.clock.Now()))
+}
+
+// Entries returns the addresses in the bucket (LRU first)
+func (b *Bucket) Entries() []*Address {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	res := make([]*Address, b.count)
+	for i := range res {
+		res[i] = b.addrs[i].addr
+	}
+	return res
 }
 
 // Count returns the number of addresses in bucket
 func (b *Bucket) Count() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
 	return b.count
 }
 
+// Cached returns the number of addresses in the replacement cache
+func (b *Bucket) Cached() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.cache)
+}
+
 // MRU returns the most recently used entries in bucket, offs=0 refers to
 // latest entry, offs=1 to second-latest and so on. offset must be smaller
 // then the number of addresses in the bucket.
 func (b *Bucket) MRU(offs int) *Address {
+	b.lock.Lock()
+	defer b.lock.Unlock()
 	// check range
 	if offs < 0 || offs >= b.count {
 		return nil
@@ -286,30 +418,52 @@ func (b *Bucket) MRU(offs int) *Address {
 type BucketList struct {
 	addr  *Address             // base address for distance
 	ping  *PingService         // ping helper
+	cfg   *BucketConfig        // routing table configuration
 	list  []*Bucket            // distance-indexed buckets
 	queue chan *BucketListTask // process queue
 }
 
 // NewBucketList returns a new BucketList with given address as reference
-// point for distances.
-func NewBucketList(addr *Address, ping *PingService) *BucketList {
+// point for distances. If no configuration is given, the default
+// configuration is used.
+func NewBucketList(addr *Address, ping *PingService, cfg *BucketConfig) (*BucketList, error) {
+	if cfg == nil {
+		cfg = DefaultBucketConfig()
+	} else if err := cfg.Check(); err != nil {
+		return nil, err
+	}
 	bl := &BucketList{
 		addr:  addr,
 		ping:  ping,
-		list:  make([]*Bucket, 256),
+		cfg:   cfg,
+		list:  make([]*Bucket, cfg.Buckets),
 		queue: make(chan *BucketListTask, 10), // buffered channel for tasks
 	}
 	for i := range bl.list {
-		bl.list[i] = NewBucket(i)
+		bl.list[i] = newBucket(i, cfg.K, cfg.Replacements)
+	}
+	return bl, nil
+}
+
+// SetClock sets the clock used for ageing the entries of all buckets.
+func (bl *BucketList) SetClock(clk gtime.Clock) {
+	for _, b := range bl.list {
+		b.lock.Lock()
+		b.clock = clk
+		b.lock.Unlock()
 	}
-	return bl
+}
+
+// Config returns the configuration of the routing table.
+func (bl *BucketList) Config() *BucketConfig {
+	return bl.cfg
 }
 
 // BucketListTask describes a maintenance job on the bucket list
 type BucketListTask struct {
 	// what to do:
 	// 0 = delete address
-	// 1 = add address (if oldest peer is unresponsive)
+	// 1 = check oldest peer (and evict it if unresponsive)
 	job int
 
 	// Address to be processed (with bucket number)
@@ -317,10 +471,23 @@ type BucketListTask struct {
 	k    int
 }
 
+// bucket index for an address: the farthest distances have their own
+// buckets, all nearer distances share the first bucket.
+func (bl *BucketList) index(addr *Address) int {
+	k := addr.Distance(bl.addr).BitLen() - 1
+	if k < 0 {
+		return -1
+	}
+	if k -= 256 - len(bl.list); k < 0 {
+		k = 0
+	}
+	return k
+}
+
 // Add a new peer to the routing table (possibly)
 func (bl *BucketList) Add(addr *Address) {
 	// compute the distance to reference
-	k := addr.Distance(bl.addr).BitLen() - 1
+	k := bl.index(addr)
 	if k < 0 {
 		// no need to add our own address :)
 		return
@@ -333,30 +500,62 @@ func (bl *BucketList) Add(addr *Address) {
 		return
 	}
 	// can we simply add the address to the bucket?
-	if !b.Add(addr) {
-		// no, we need to process address separately.
-		if b.Expired(0) && bl.ping != nil {
-			// LRU entry is expired and can be replaced
-			bl.queue <- &BucketListTask{
+	if b.Add(addr) {
+		return
+	}
+	// no: remember address as a replacement and handle stale peers.
+	b.Cache(addr)
+	if !b.Expired(0, bl.cfg.TTL) {
+		return
+	}
+	switch bl.cfg.Eviction {
+	case EvictStale:
+		b.Remove(0)
+	case EvictPing:
+		if bl.ping != nil {
+			// LRU entry is expired and must be checked
+			bl.schedule(&BucketListTask{
 				job:  1,
-				addr: addr,
+				addr: b.MRU(b.Count() - 1),
 				k:    k,
-			}
+			})
 		}
 	}
 }
 
+// Remove a peer from the routing table. Its place is taken by a peer
+// from the replacement cache (if available).
+func (bl *BucketList) Remove(addr *Address) {
+	bl.schedule(&BucketListTask{
+		job:  0,
+		addr: addr,
+		k:    bl.index(addr),
+	})
+}
+
+// schedule task for processing (drop it if the queue is full)
+func (bl *BucketList) schedule(task *BucketListTask) {
+	if task.k < 0 {
+		return
+	}
+	select {
+	case bl.queue <- task:
+	default:
+	}
+}
+
 // Closest returns the n closest nodes we know of
 // The number of returned nodes can be smaller if the node does not know
 // about that many more nodes. Addresses are ordered by distance and MRU.
 func (bl *BucketList) Closest(n int) (res []*Address) {
 	// collect closest nodes from buckets
 	tmp := make([]*Address, 0)
+collect:
 	for _, bkt := range bl.list {
 		for i := 0; i < bkt.Count(); i++ {
 			tmp = append(tmp, bkt.MRU(i))
 			if len(tmp) == n {
-				return
+				break collect
 			}
 		}
 	}
@@ -371,6 +570,46 @@ func (bl *BucketList) Closest(n int) (res []*Address) {
 	return
 }
 
+// Peers returns all peers in the routing table
+func (bl *BucketList) Peers() []*Address {
+	res := make([]*Address, 0)
+	for _, b := range bl.list {
+		res = append(res, b.Entries()...)
+	}
+	return res
+}
+
+// BucketMetrics describe the fill levels of a routing table
+type BucketMetrics struct {
+	Buckets int   // number of buckets
+	Peers   int   // number of peers in buckets
+	Cached  int   // number of peers in replacement caches
+	Full    int   // number of full buckets
+	Empty   int   // number of empty buckets
+	Fill    []int // number of peers per bucket
+}
+
+// Metrics returns the current fill levels of the routing table.
+func (bl *BucketList) Metrics() *BucketMetrics {
+	m := &BucketMetrics{
+		Buckets: len(bl.list),
+		Fill:    make([]int, len(bl.list)),
+	}
+	for i, b := range bl.list {
+		n := b.Count()
+		m.Fill[i] = n
+		m.Peers += n
+		m.Cached += b.Cached()
+		switch n {
+		case 0:
+			m.Empty++
+		case bl.cfg.K:
+			m.Full++
+		}
+	}
+	return m
+}
+
 // Run the processing loop for the bucket list.
 func (bl *BucketList) Run(ctx context.Context) {
 	go func() {
@@ -378,24 +617,26 @@ func (bl *BucketList) Run(ctx context.Context) {
 			select {
 			// process new addresses
 			case task := <-bl.queue:
+				buck := bl.list[task.k]
 				switch task.job {
 				// delete address from bucket
 				case 0:
-					panic("not implemented")
+					buck.Remove(buck.Contains(task.addr))
 
-				// add address if oldest peer is unresponsive
+				// evict oldest peer if unresponsive
 				case 1:
-					// get address to check from indexed bucker
-					buck := bl.list[task.k]
-					drop := buck.addrs[0]
-					if drop.expired() {
-						// ping address with short timeout
-						if err := bl.ping.Ping(ctx, task.addr, PingTimeout, 0); err != nil {
-							// ping failed: use new address drop
-							drop = newDrop(task.addr)
-							// update bucket
-							buck.Update(0, drop)
-						}
+					// check if the oldest peer is still the same and
+					// still stale
+					if pos := buck.Contains(task.addr); pos != 0 || !buck.Expired(0, bl.cfg.TTL) {
+						continue
+					}
+					// ping address with short timeout
+					if err := bl.ping.Ping(ctx, task.addr, PingTimeout, 0); err != nil {
+						// ping failed: replace peer from cache
+						buck.Remove(0)
+					} else {
+						// peer is alive: move to tail
+						buck.Update(0, nil)
 					}
 				}
 
@@ -23,6 +23,7 @@ package p2p
 import (
 	"crypto/rand"
 	"testing"
+	"time"
 )
 
 func TestAddressString(t *testing.T) {
@@ -47,3 +48,56 @@ func TestAddressString(t *testing.T) {
 		}
 	}
 }
+
+func TestBucketConfig(t *testing.T) {
+	cfg := DefaultBucketConfig()
+	cfg.K = 0
+	if _, err := NewBucketList(newTestAddress(), nil, cfg); err == nil {
+		t.Fatal("invalid bucket size accepted")
+	}
+	cfg.K, cfg.Buckets = 2, 257
+	if _, err := NewBucketList(newTestAddress(), nil, cfg); err == nil {
+		t.Fatal("invalid bucket count accepted")
+	}
+}
+
+func TestBucketReplacement(t *testing.T) {
+	// single bucket with two entries and stale-peer eviction
+	cfg := &BucketConfig{
+		K:            2,
+		Buckets:      1,
+		Replacements: 2,
+		TTL:          time.Hour,
+		Eviction:     EvictStale,
+	}
+	bl, err := NewBucketList(newTestAddress(), nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := make([]*Address, 5)
+	for i := range addrs {
+		addrs[i] = newTestAddress()
+		bl.Add(addrs[i])
+	}
+	m := bl.Metrics()
+	if m.Peers != 2 || m.Cached != 2 || m.Full != 1 || m.Empty != 0 {
+		t.Fatalf("wrong metrics: %v", m)
+	}
+	// fresh peers are not evicted
+	b := bl.list[0]
+	if b.Contains(addrs[0]) != 0 || b.Contains(addrs[1]) != 1 {
+		t.Fatal("peers evicted")
+	}
+	// stale peer is replaced by the most recent cached peer
+	b.addrs[0].seen = time.Now().Add(-2 * time.Hour)
+	bl.Add(addrs[2])
+	if b.Contains(addrs[0]) != -1 || b.Contains(addrs[2]) == -1 {
+		t.Fatal("stale peer not replaced")
+	}
+	if m = bl.Metrics(); m.Peers != 2 || m.Cached != 1 {
+		t.Fatalf("wrong metrics: %v", m)
+	}
+	if len(bl.Peers()) != 2 {
+		t.Fatal("wrong number of peers")
+	}
+}
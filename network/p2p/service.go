@@ -21,13 +21,10 @@ package p2p
 //----------------------------------------------------------------------
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
 	"errors"
 	"time"
 
-	"github.com/bfix/gospel/data"
 	"github.com/bfix/gospel/logger"
 )
 
@@ -192,8 +189,10 @@ func (s *ServiceImpl) Task(ctx context.Context, m Message, f *TaskHandler) (err
 		tick.Stop()
 	case <-ctrl:
 	}
-	// unregister handler
-	err = s.listeners.Remove(txid)
+	// unregister handler (keep timeout error)
+	if e := s.listeners.Remove(txid); err == nil {
+		err = e
+	}
 	return
 }
 
@@ -213,27 +212,21 @@ func NewServiceList() *ServiceList {
 	}
 }
 
-// MessageFactory re-creates a message from binary data.
+// MessageFactory re-creates a message from binary data (encoded with
+// the default codec).
 func (sl *ServiceList) MessageFactory(buf []byte) (msg Message, err error) {
-	// read the type of the message
-	var mt uint16
-	if err = binary.Read(bytes.NewBuffer(buf[2:4]), binary.BigEndian, &mt); err != nil {
-		return
-	}
-	// create empty message of given type
+	return new(DataCodec).Decode(buf, sl.NewMessage)
+}
+
+// NewMessage returns an empty message of given type (or nil if no
+// service handles the type).
+func (sl *ServiceList) NewMessage(mt uint16) Message {
 	for _, srv := range sl.srvcs {
-		if msg = srv.NewMessage(int(mt)); msg != nil {
-			// parse binary data
-			if err = data.Unmarshal(msg, buf); err != nil {
-				msg = nil
-				return
-			}
-			return
+		if msg := srv.NewMessage(int(mt)); msg != nil {
+			return msg
 		}
 	}
-	// we failed to re-create the message
-	err = ErrMessageParse
-	return
+	return nil
 }
 
 // Add service to list
@@ -258,6 +251,23 @@ func (sl *ServiceList) Get(name string) Service {
 	return nil
 }
 
+// List returns all services in the list
+func (sl *ServiceList) List() []Service {
+	res := make([]Service, len(sl.srvcs))
+	copy(res, sl.srvcs)
+	return res
+}
+
+// ServiceOf returns the (first) service of type T in the list.
+func ServiceOf[T Service](sl *ServiceList) (srv T, ok bool) {
+	for _, s := range sl.srvcs {
+		if srv, ok = s.(T); ok {
+			return
+		}
+	}
+	return
+}
+
 // Respond to service requests
 func (sl *ServiceList) Respond(ctx context.Context, msg Message) (bool, error) {
 	hdr := msg.Header()
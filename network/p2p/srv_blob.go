@@ -0,0 +1,534 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/data"
+	"github.com/bfix/gospel/logger"
+)
+
+// Error codes
+var (
+	ErrBlobNotFound   = errors.New("blob not found")
+	ErrBlobInvalid    = errors.New("invalid blob information")
+	ErrBlobIncomplete = errors.New("blob download incomplete")
+	ErrBlobResponse   = errors.New("invalid blob response")
+)
+
+// Blob transfer parameters
+var (
+	BlobChunkSize = 32 * 1024 // size of blob chunks
+	BlobMaxFail   = 3         // max. number of failed requests per peer
+	BlobPeers     = 8         // number of peers to ask (if not specified)
+
+	BlobMaxSize uint64 = 64 * 1024 * 1024 // max. size of a downloaded blob
+)
+
+//----------------------------------------------------------------------
+// BINFO messages (blob information request and response)
+//----------------------------------------------------------------------
+
+// BlobInfoMsg queries information about a blob
+type BlobInfoMsg struct {
+	MsgHeader
+
+	Root []byte `size:"32"` // blob identifier (root hash)
+}
+
+// String returns human-readable message
+func (m *BlobInfoMsg) String() string {
+	return fmt.Sprintf("BINFO{%.8s -> %.8s, #%d}[%.8s]", m.Sender, m.Receiver, m.TxID, hex.EncodeToString(m.Root))
+}
+
+// NewBlobInfoMsg creates an empty blob information request
+func NewBlobInfoMsg() Message {
+	return &BlobInfoMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 32,
+			TxID:     0,
+			Type:     ReqBINFO,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Root: make([]byte, 32),
+	}
+}
+
+// BlobInfoRespMsg returns information about a blob
+type BlobInfoRespMsg struct {
+	MsgHeader
+
+	Root   []byte `size:"32"` // blob identifier (root hash)
+	Found  bool   // blob available?
+	Size   uint64 `order:"big"` // size of blob
+	Chunks uint32 `order:"big"` // number of chunks
+}
+
+// String returns human-readable message
+func (m *BlobInfoRespMsg) String() string {
+	return fmt.Sprintf("BINFO_RESP{%.8s -> %.8s, #%d}[%v,%d]", m.Sender, m.Receiver, m.TxID, m.Found, m.Size)
+}
+
+// NewBlobInfoRespMsg creates an empty blob information response
+func NewBlobInfoRespMsg() Message {
+	return &BlobInfoRespMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 45,
+			TxID:     0,
+			Type:     RespBINFO,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Root:   make([]byte, 32),
+		Found:  false,
+		Size:   0,
+		Chunks: 0,
+	}
+}
+
+//----------------------------------------------------------------------
+// BCHUNK messages (chunk request and response)
+//----------------------------------------------------------------------
+
+// BlobChunkMsg requests a chunk of a blob
+type BlobChunkMsg struct {
+	MsgHeader
+
+	Root  []byte `size:"32"`   // blob identifier (root hash)
+	Index uint32 `order:"big"` // index of chunk
+}
+
+// String returns human-readable message
+func (m *BlobChunkMsg) String() string {
+	return fmt.Sprintf("BCHUNK{%.8s -> %.8s, #%d}[%.8s,%d]", m.Sender, m.Receiver, m.TxID, hex.EncodeToString(m.Root), m.Index)
+}
+
+// NewBlobChunkMsg creates an empty chunk request
+func NewBlobChunkMsg() Message {
+	return &BlobChunkMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 36,
+			TxID:     0,
+			Type:     ReqBCHUNK,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Root:  make([]byte, 32),
+		Index: 0,
+	}
+}
+
+// BlobChunkRespMsg returns a chunk of a blob with its inclusion proof
+type BlobChunkRespMsg struct {
+	MsgHeader
+
+	Root  []byte  `size:"32"`   // blob identifier (root hash)
+	Index uint32  `order:"big"` // index of chunk
+	Found bool    // chunk available?
+	Num   uint8   // number of hashes in proof
+	Proof []*Hash `size:"Num"`  // inclusion proof of chunk
+	Len   uint16  `order:"big"` // size of chunk
+	Chunk []byte  `size:"Len"`  // chunk data
+}
+
+// String returns human-readable message
+func (m *BlobChunkRespMsg) String() string {
+	return fmt.Sprintf("BCHUNK_RESP{%.8s -> %.8s, #%d}[%d,%d]", m.Sender, m.Receiver, m.TxID, m.Index, m.Len)
+}
+
+// NewBlobChunkRespMsg creates an empty chunk response
+func NewBlobChunkRespMsg() Message {
+	return &BlobChunkRespMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 40,
+			TxID:     0,
+			Type:     RespBCHUNK,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Root:  make([]byte, 32),
+		Index: 0,
+		Found: false,
+		Num:   0,
+		Proof: make([]*Hash, 0),
+		Len:   0,
+		Chunk: nil,
+	}
+}
+
+// Set chunk data and proof
+func (m *BlobChunkRespMsg) Set(chunk []byte, proof [][]byte) {
+	m.Found = true
+	for _, h := range proof {
+		m.Proof = append(m.Proof, NewHash(h))
+	}
+	m.Num = uint8(len(proof))
+	m.Chunk = chunk
+	m.Len = uint16(len(chunk))
+	m.Size += uint16(32*len(proof)) + m.Len
+}
+
+//----------------------------------------------------------------------
+// Blob service:
+// Blobs (large binary objects) are split into chunks and identified by
+// the root hash of the Merkle tree over its chunks (content addressing).
+// Chunks are retrieved in parallel from multiple peers; each chunk is
+// verified with its inclusion proof. Interrupted downloads keep the
+// verified chunks and are resumed on the next attempt.
+//----------------------------------------------------------------------
+
+// blob stored on the node
+type blob struct {
+	size   uint64           // size of blob
+	chunks [][]byte         // list of chunks
+	tree   *data.MerkleTree // hash tree over chunks
+}
+
+// download of a blob in progress
+type download struct {
+	size   uint64        // size of blob
+	chunks [][]byte      // list of chunks (nil if missing)
+	count  int           // number of missing chunks
+	done   chan struct{} // closed when all chunks are received
+}
+
+// newDownload creates a new download for a blob with n chunks.
+func newDownload(size uint64, n int) *download {
+	return &download{
+		size:   size,
+		chunks: make([][]byte, n),
+		count:  n,
+		done:   make(chan struct{}),
+	}
+}
+
+// BlobService for transfer of blobs between nodes
+type BlobService struct {
+	ServiceImpl
+
+	blobs   map[string]*blob     // available blobs
+	partial map[string]*download // downloads in progress
+	lock    sync.Mutex           // lock for concurrent access
+}
+
+// NewBlobService creates a new service instance
+func NewBlobService() *BlobService {
+	srv := &BlobService{
+		ServiceImpl: *NewServiceImpl(),
+		blobs:       make(map[string]*blob),
+		partial:     make(map[string]*download),
+	}
+	// defined message instantiators
+	srv.factories[ReqBINFO] = NewBlobInfoMsg
+	srv.factories[RespBINFO] = NewBlobInfoRespMsg
+	srv.factories[ReqBCHUNK] = NewBlobChunkMsg
+	srv.factories[RespBCHUNK] = NewBlobChunkRespMsg
+
+	// defined known labels
+	srv.labels[ReqBINFO] = "BINFO"
+	srv.labels[RespBINFO] = "BINFO_RESP"
+	srv.labels[ReqBCHUNK] = "BCHUNK"
+	srv.labels[RespBCHUNK] = "BCHUNK_RESP"
+	return srv
+}
+
+// Name is a human-readble and short service description like "PING"
+func (s *BlobService) Name() string {
+	return "blob"
+}
+
+// NewMessage creates an empty service message of given type
+func (s *BlobService) NewMessage(mt int) Message {
+	if fac, ok := s.factories[mt]; ok {
+		return fac()
+	}
+	return nil
+}
+
+// Put a blob into the local store and return its identifier.
+func (s *BlobService) Put(buf []byte) []byte {
+	b := newBlob(buf)
+	root := b.tree.Root()
+	s.lock.Lock()
+	s.blobs[hex.EncodeToString(root)] = b
+	s.lock.Unlock()
+	return root
+}
+
+// Has returns true if the blob is available locally.
+func (s *BlobService) Has(root []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.blobs[hex.EncodeToString(root)]
+	return ok
+}
+
+// Remove a blob (and partial downloads) from the local store.
+func (s *BlobService) Remove(root []byte) {
+	key := hex.EncodeToString(root)
+	s.lock.Lock()
+	delete(s.blobs, key)
+	delete(s.partial, key)
+	s.lock.Unlock()
+}
+
+// Get a blob: if it is not available locally, it is downloaded from the
+// given peers. Failed downloads can be resumed by calling Get again.
+func (s *BlobService) Get(ctx context.Context, root []byte, peers []*Address, timeout time.Duration) ([]byte, error) {
+	key := hex.EncodeToString(root)
+	s.lock.Lock()
+	if b, ok := s.blobs[key]; ok {
+		s.lock.Unlock()
+		return bytes.Join(b.chunks, nil), nil
+	}
+	dl, ok := s.partial[key]
+	s.lock.Unlock()
+
+	// start new download
+	if !ok {
+		var err error
+		if dl, err = s.info(ctx, root, peers, timeout); err != nil {
+			return nil, err
+		}
+		// a concurrent call might have started the same download
+		s.lock.Lock()
+		if cur, ok := s.partial[key]; ok {
+			dl = cur
+		} else {
+			s.partial[key] = dl
+		}
+		s.lock.Unlock()
+	}
+	// queue missing chunks
+	n := len(dl.chunks)
+	queue := make(chan int, n)
+	s.lock.Lock()
+	for i, c := range dl.chunks {
+		if c == nil {
+			queue <- i
+		}
+	}
+	missing := dl.count
+	s.lock.Unlock()
+
+	// retrieve chunks from peers in parallel
+	if missing > 0 {
+		var wg sync.WaitGroup
+		for _, peer := range peers {
+			wg.Add(1)
+			go func(peer *Address) {
+				defer wg.Done()
+				fails := 0
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-dl.done:
+						return
+					case idx := <-queue:
+						chunk, err := s.chunk(ctx, peer, root, idx, n, dl.size, timeout)
+						if err != nil {
+							logger.Printf(logger.WARN, "[%.8s] Chunk %d from %.8s failed: %s\n", s.node.Address(), idx, peer, err.Error())
+							queue <- idx
+							if fails++; fails >= BlobMaxFail {
+								return
+							}
+							continue
+						}
+						s.lock.Lock()
+						if dl.chunks[idx] == nil {
+							dl.chunks[idx] = chunk
+							if dl.count--; dl.count == 0 {
+								close(dl.done)
+							}
+						}
+						s.lock.Unlock()
+					}
+				}
+			}(peer)
+		}
+		wg.Wait()
+	}
+	// check for complete download
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if dl.count > 0 {
+		return nil, ErrBlobIncomplete
+	}
+	b := &blob{
+		size:   dl.size,
+		chunks: dl.chunks,
+		tree:   data.NewMerkleTree(dl.chunks),
+	}
+	delete(s.partial, key)
+	s.blobs[key] = b
+	return bytes.Join(b.chunks, nil), nil
+}
+
+// Respond to a service request from peer.
+func (s *BlobService) Respond(ctx context.Context, m Message) (bool, error) {
+	var resp Message
+	switch msg := m.(type) {
+	case *BlobInfoMsg:
+		r, _ := NewBlobInfoRespMsg().(*BlobInfoRespMsg)
+		r.Root = msg.Root
+		s.lock.Lock()
+		if b, ok := s.blobs[hex.EncodeToString(msg.Root)]; ok {
+			r.Found = true
+			r.Size = b.size
+			r.Chunks = uint32(len(b.chunks))
+		}
+		s.lock.Unlock()
+		resp = r
+
+	case *BlobChunkMsg:
+		r, _ := NewBlobChunkRespMsg().(*BlobChunkRespMsg)
+		r.Root = msg.Root
+		r.Index = msg.Index
+		s.lock.Lock()
+		if b, ok := s.blobs[hex.EncodeToString(msg.Root)]; ok && int(msg.Index) < len(b.chunks) {
+			r.Set(b.chunks[msg.Index], b.tree.Proof(int(msg.Index)))
+		}
+		s.lock.Unlock()
+		resp = r
+
+	default:
+		return false, nil
+	}
+	// send response
+	hdr := m.Header()
+	rh := resp.Header()
+	rh.TxID = hdr.TxID
+	rh.Sender = hdr.Receiver
+	rh.Receiver = hdr.Sender
+	return true, s.Send(ctx, resp)
+}
+
+//----------------------------------------------------------------------
+// internal methods
+//----------------------------------------------------------------------
+
+// info queries peers for blob information and returns a new download.
+func (s *BlobService) info(ctx context.Context, root []byte, peers []*Address, timeout time.Duration) (*download, error) {
+	for _, peer := range peers {
+		req, _ := NewBlobInfoMsg().(*BlobInfoMsg)
+		req.TxID = s.node.NextID()
+		req.Sender = s.node.Address()
+		req.Receiver = peer
+		req.Root = root
+		m, err := s.request(ctx, req, timeout)
+		if err != nil {
+			continue
+		}
+		resp, ok := m.(*BlobInfoRespMsg)
+		if !ok || !resp.Found {
+			continue
+		}
+		// check size and number of chunks before allocating anything
+		if resp.Size > BlobMaxSize {
+			return nil, ErrBlobInvalid
+		}
+		n := (resp.Size + uint64(BlobChunkSize) - 1) / uint64(BlobChunkSize)
+		if n != uint64(resp.Chunks) {
+			return nil, ErrBlobInvalid
+		}
+		return newDownload(resp.Size, int(n)), nil
+	}
+	return nil, ErrBlobNotFound
+}
+
+// chunk requests a chunk from a peer and verifies it.
+func (s *BlobService) chunk(ctx context.Context, peer *Address, root []byte, idx, n int, size uint64, timeout time.Duration) ([]byte, error) {
+	req, _ := NewBlobChunkMsg().(*BlobChunkMsg)
+	req.TxID = s.node.NextID()
+	req.Sender = s.node.Address()
+	req.Receiver = peer
+	req.Root = root
+	req.Index = uint32(idx)
+	m, err := s.request(ctx, req, timeout)
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := m.(*BlobChunkRespMsg)
+	if !ok || !resp.Found || int(resp.Index) != idx {
+		return nil, ErrBlobResponse
+	}
+	// check size and proof
+	expSize := BlobChunkSize
+	if idx == n-1 {
+		expSize = int(size) - (n-1)*BlobChunkSize
+	}
+	if len(resp.Chunk) != expSize {
+		return nil, ErrBlobResponse
+	}
+	proof := make([][]byte, len(resp.Proof))
+	for i, h := range resp.Proof {
+		proof[i] = h.Data
+	}
+	if !data.VerifyMerkleProof(root, resp.Chunk, idx, n, proof) {
+		return nil, ErrBlobResponse
+	}
+	return resp.Chunk, nil
+}
+
+// request sends a message to a peer and returns the response.
+func (s *BlobService) request(ctx context.Context, req Message, timeout time.Duration) (resp Message, err error) {
+	hdlr := &TaskHandler{
+		msgHdlr: func(ctx context.Context, m Message) (bool, error) {
+			resp = m
+			return true, nil
+		},
+		timeout: timeout,
+	}
+	if err = s.Task(ctx, req, hdlr); err == nil && resp == nil {
+		err = ErrNodeTimeout
+	}
+	return
+}
+
+// newBlob splits data into chunks and computes the hash tree
+func newBlob(buf []byte) *blob {
+	chunks := make([][]byte, 0, (len(buf)+BlobChunkSize-1)/BlobChunkSize)
+	for pos := 0; pos < len(buf); pos += BlobChunkSize {
+		end := pos + BlobChunkSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		chunks = append(chunks, buf[pos:end])
+	}
+	return &blob{
+		size:   uint64(len(buf)),
+		chunks: chunks,
+		tree:   data.NewMerkleTree(chunks),
+	}
+}
@@ -0,0 +1,253 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+)
+
+func TestBlob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// create nodes (two seeders, one leecher)
+	trans := NewLocalTransport()
+	names := []string{"seed1", "seed2", "leech"}
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	for i, n := range nodes {
+		for j, peer := range nodes {
+			if i != j {
+				if err := n.Learn(peer.Address(), names[j]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	timeout := 5 * time.Second
+
+	// store blob on seeders
+	blob := make([]byte, 7*BlobChunkSize+1234)
+	if _, err := rand.Read(blob); err != nil {
+		t.Fatal(err)
+	}
+	id := nodes[0].Put(blob)
+	if !bytes.Equal(id, nodes[1].Put(blob)) {
+		t.Fatal("blob identifier mismatch")
+	}
+	// unknown blob
+	leech := nodes[2]
+	if _, err := leech.Get(ctx, make([]byte, 32), timeout); err != ErrBlobNotFound {
+		t.Fatalf("unknown blob found: %v", err)
+	}
+	// retrieve blob from both seeders
+	buf, err := leech.Get(ctx, id, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, blob) || !leech.BlobService().Has(id) {
+		t.Fatal("blob mismatch")
+	}
+}
+
+func TestBlobResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trans := NewLocalTransport()
+	names := []string{"seed", "leech"}
+	nodes := make([]*Node, 2)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	seed, leech := nodes[0], nodes[1]
+	if err := leech.Learn(seed.Address(), names[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := seed.Learn(leech.Address(), names[1]); err != nil {
+		t.Fatal(err)
+	}
+	timeout := 5 * time.Second
+
+	// simulate an interrupted download (some chunks already received)
+	blob := make([]byte, 5*BlobChunkSize)
+	if _, err := rand.Read(blob); err != nil {
+		t.Fatal(err)
+	}
+	id := seed.Put(blob)
+	b := newBlob(blob)
+	dl := newDownload(b.size, len(b.chunks))
+	dl.count -= 2
+	dl.chunks[0] = b.chunks[0]
+	dl.chunks[3] = b.chunks[3]
+	srv := leech.BlobService()
+	srv.partial[hex.EncodeToString(id)] = dl
+
+	// download fails if seeder is gone
+	seed.BlobService().Remove(id)
+	peers := []*Address{seed.Address()}
+	if _, err := srv.Get(ctx, id, peers, time.Second); err != ErrBlobIncomplete {
+		t.Fatalf("expected incomplete download: %v", err)
+	}
+	// resume download
+	seed.Put(blob)
+	buf, err := srv.Get(ctx, id, peers, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, blob) {
+		t.Fatal("blob mismatch")
+	}
+}
+
+func TestBlobInvalidInfo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trans := NewLocalTransport()
+	names := []string{"seed", "leech"}
+	nodes := make([]*Node, 2)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	seed, leech := nodes[0], nodes[1]
+	if err := leech.Learn(seed.Address(), names[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := seed.Learn(leech.Address(), names[1]); err != nil {
+		t.Fatal(err)
+	}
+	peers := []*Address{seed.Address()}
+	srv := seed.BlobService()
+
+	// seeder announces a blob exceeding the size limit
+	id := make([]byte, 32)
+	id[0] = 1
+	n := int((BlobMaxSize+1)/uint64(BlobChunkSize)) + 1
+	srv.blobs[hex.EncodeToString(id)] = &blob{
+		size:   BlobMaxSize + 1,
+		chunks: make([][]byte, n),
+	}
+	if _, err := leech.BlobService().Get(ctx, id, peers, time.Second); err != ErrBlobInvalid {
+		t.Fatalf("oversized blob accepted: %v", err)
+	}
+	// seeder announces an inconsistent number of chunks
+	id[0] = 2
+	srv.blobs[hex.EncodeToString(id)] = &blob{
+		size:   uint64(BlobChunkSize),
+		chunks: make([][]byte, 1000),
+	}
+	if _, err := leech.BlobService().Get(ctx, id, peers, time.Second); err != ErrBlobInvalid {
+		t.Fatalf("inconsistent blob accepted: %v", err)
+	}
+}
+
+func TestBlobConcurrentGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trans := NewLocalTransport()
+	names := []string{"seed", "leech"}
+	nodes := make([]*Node, 2)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	seed, leech := nodes[0], nodes[1]
+	if err := leech.Learn(seed.Address(), names[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := seed.Learn(leech.Address(), names[1]); err != nil {
+		t.Fatal(err)
+	}
+	blob := make([]byte, 9*BlobChunkSize+17)
+	if _, err := rand.Read(blob); err != nil {
+		t.Fatal(err)
+	}
+	id := seed.Put(blob)
+
+	// concurrent downloads of the same blob must all complete
+	peers := []*Address{seed.Address()}
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			buf, err := leech.BlobService().Get(ctx, id, peers, 5*time.Second)
+			if err == nil && !bytes.Equal(buf, blob) {
+				err = ErrBlobResponse
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(20 * time.Second):
+			t.Fatal("concurrent download stalled")
+		}
+	}
+}
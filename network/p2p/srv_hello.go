@@ -0,0 +1,246 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// Error codes
+var (
+	ErrHelloIncompatible = errors.New("incompatible protocol version")
+)
+
+//----------------------------------------------------------------------
+// HELLO message (request and response)
+//----------------------------------------------------------------------
+
+// HelloMsg announces the supported protocol versions and capabilities
+// of a node. It is used for requests (HELLO) and responses (HELLO_RESP).
+type HelloMsg struct {
+	MsgHeader
+
+	Version    uint16 `order:"big"` // protocol version of sender
+	MinVersion uint16 `order:"big"` // oldest version supported by sender
+	Caps       uint16 `order:"big"` // capabilities of sender
+}
+
+// String returns human-readable message
+func (m *HelloMsg) String() string {
+	label := "HELLO"
+	if m.Type == RespHELLO {
+		label = "HELLO_RESP"
+	}
+	return fmt.Sprintf("%s{%.8s -> %.8s, #%d}[v%d/v%d,%04x]", label, m.Sender, m.Receiver, m.TxID,
+		m.Version, m.MinVersion, m.Caps)
+}
+
+// NewHelloMsg creates an empty HELLO request
+func NewHelloMsg() Message {
+	return newHelloMsg(ReqHELLO)
+}
+
+// NewHelloRespMsg creates an empty HELLO response
+func NewHelloRespMsg() Message {
+	return newHelloMsg(RespHELLO)
+}
+
+// create a new HELLO message of given type
+func newHelloMsg(mt uint16) *HelloMsg {
+	return &HelloMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 6,
+			TxID:     0,
+			Type:     mt,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Version:    ProtocolVersion,
+		MinVersion: MinProtocolVersion,
+		Caps:       0,
+	}
+}
+
+//----------------------------------------------------------------------
+// Hello service:
+// Nodes learn the protocol version and capabilities of peers from the
+// header of every received packet; an explicit HELLO handshake can be
+// used to negotiate before optional features are used. Features must
+// only be used if both peers announce the capability.
+//----------------------------------------------------------------------
+
+// PeerVersion holds the protocol information of a peer
+type PeerVersion struct {
+	Version    uint16    // protocol version used by peer
+	MinVersion uint16    // oldest version supported (0 if unknown)
+	Caps       uint16    // capabilities of peer
+	Seen       time.Time // time of last update
+}
+
+// HelloService negotiates protocol versions and capabilities with peers
+type HelloService struct {
+	ServiceImpl
+
+	peers map[string]*PeerVersion // protocol information of peers
+	lock  sync.Mutex              // lock for concurrent access
+}
+
+// NewHelloService creates a new service instance
+func NewHelloService() *HelloService {
+	srv := &HelloService{
+		ServiceImpl: *NewServiceImpl(),
+		peers:       make(map[string]*PeerVersion),
+	}
+	// defined message instantiators
+	srv.factories[ReqHELLO] = NewHelloMsg
+	srv.factories[RespHELLO] = NewHelloRespMsg
+
+	// defined known labels
+	srv.labels[ReqHELLO] = "HELLO"
+	srv.labels[RespHELLO] = "HELLO_RESP"
+	return srv
+}
+
+// Name is a human-readble and short service description like "PING"
+func (s *HelloService) Name() string {
+	return "hello"
+}
+
+// NewMessage creates an empty service message of given type
+func (s *HelloService) NewMessage(mt int) Message {
+	if fac, ok := s.factories[mt]; ok {
+		return fac()
+	}
+	return nil
+}
+
+// Peer returns the protocol information of a peer (or nil if unknown).
+func (s *HelloService) Peer(addr *Address) *PeerVersion {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if pv, ok := s.peers[addr.String()]; ok {
+		res := *pv
+		return &res
+	}
+	return nil
+}
+
+// Common returns the capabilities shared by the local node and a peer.
+// No capabilities are shared with unknown peers.
+func (s *HelloService) Common(addr *Address) uint16 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if pv, ok := s.peers[addr.String()]; ok {
+		return pv.Caps & s.node.Capabilities()
+	}
+	return 0
+}
+
+// Hello performs a handshake with a peer and returns its protocol
+// information. An error is returned if the protocol versions are not
+// compatible.
+func (s *HelloService) Hello(ctx context.Context, rcv *Address, timeout time.Duration) (pv *PeerVersion, err error) {
+	req := newHelloMsg(ReqHELLO)
+	req.TxID = s.node.NextID()
+	req.Sender = s.node.Address()
+	req.Receiver = rcv
+	req.Caps = s.node.Capabilities()
+
+	var resp *HelloMsg
+	hdlr := &TaskHandler{
+		msgHdlr: func(ctx context.Context, m Message) (bool, error) {
+			var ok bool
+			resp, ok = m.(*HelloMsg)
+			return ok, nil
+		},
+		timeout: timeout,
+	}
+	if err = s.Task(ctx, req, hdlr); err != nil {
+		return
+	}
+	if resp == nil {
+		return nil, ErrNodeTimeout
+	}
+	if pv, err = s.negotiate(resp); err != nil {
+		return nil, err
+	}
+	return pv, nil
+}
+
+// Respond to a service request from peer.
+func (s *HelloService) Respond(ctx context.Context, m Message) (bool, error) {
+	msg, ok := m.(*HelloMsg)
+	if !ok || msg.Type != ReqHELLO {
+		return false, nil
+	}
+	// we answer even incompatible peers (so they know about us)
+	if _, err := s.negotiate(msg); err != nil {
+		logger.Printf(logger.WARN, "[%.8s] Hello from %.8s: %s\n", s.node.Address(), msg.Sender, err.Error())
+	}
+	resp := newHelloMsg(RespHELLO)
+	resp.TxID = msg.TxID
+	resp.Sender = msg.Receiver
+	resp.Receiver = msg.Sender
+	resp.Caps = s.node.Capabilities()
+	return true, s.Send(ctx, resp)
+}
+
+// learn protocol information of a peer from a packet header
+func (s *HelloService) learn(addr *Address, version, caps uint16) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := addr.String()
+	pv, ok := s.peers[key]
+	if !ok {
+		pv = new(PeerVersion)
+		s.peers[key] = pv
+	}
+	pv.Version = version
+	pv.Caps = caps &^ CapCodecMask
+	pv.Seen = s.node.Clock().Now()
+}
+
+// negotiate protocol with peer based on a HELLO message
+func (s *HelloService) negotiate(m *HelloMsg) (*PeerVersion, error) {
+	pv := &PeerVersion{
+		Version:    m.Version,
+		MinVersion: m.MinVersion,
+		Caps:       m.Caps,
+		Seen:       s.node.Clock().Now(),
+	}
+	s.lock.Lock()
+	s.peers[m.Sender.String()] = pv
+	s.lock.Unlock()
+
+	// check for a common protocol version
+	if m.Version < MinProtocolVersion || m.MinVersion > ProtocolVersion {
+		return pv, ErrHelloIncompatible
+	}
+	res := *pv
+	return &res, nil
+}
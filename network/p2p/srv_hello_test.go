@@ -0,0 +1,86 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+)
+
+func TestHello(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trans := NewLocalTransport()
+	names := []string{"old", "new"}
+	nodes := make([]*Node, 2)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	n1, n2 := nodes[0], nodes[1]
+	if err := n1.Learn(n2.Address(), names[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := n2.Learn(n1.Address(), names[0]); err != nil {
+		t.Fatal(err)
+	}
+	n1.SetCapabilities(CapCompress)
+	n2.SetCapabilities(CapCompress | CapFragment)
+
+	// explicit handshake
+	pv, err := n1.HelloService().Hello(ctx, n2.Address(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pv.Version != ProtocolVersion || pv.Caps != CapCompress|CapFragment {
+		t.Fatalf("wrong peer version: %v", pv)
+	}
+	if caps := n1.HelloService().Common(n2.Address()); caps != CapCompress {
+		t.Fatalf("wrong common capabilities: %04x", caps)
+	}
+	// responder learned the capabilities from the packet
+	if caps := n2.HelloService().Common(n1.Address()); caps != CapCompress {
+		t.Fatalf("wrong common capabilities: %04x", caps)
+	}
+	// typed service access
+	if srv, ok := ServiceOf[*HelloService](n1.Services()); !ok || srv != n1.HelloService() {
+		t.Fatal("typed service access failed")
+	}
+	// incompatible peer
+	msg := newHelloMsg(RespHELLO)
+	msg.Sender = n2.Address()
+	msg.MinVersion = ProtocolVersion + 1
+	if _, err = n1.HelloService().negotiate(msg); err != ErrHelloIncompatible {
+		t.Fatalf("incompatible version accepted: %v", err)
+	}
+}
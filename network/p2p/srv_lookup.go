@@ -27,6 +27,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/bfix/gospel/concurrent"
 	"github.com/bfix/gospel/data"
 	gerr "github.com/bfix/gospel/errors"
 	"github.com/bfix/gospel/logger"
@@ -119,12 +120,16 @@ func (m *FindNodeRespMsg) Add(e *Endpoint) {
 // LookupService to resolve node addresses (routing)
 type LookupService struct {
 	ServiceImpl
+
+	// concurrent node lookups for the same address are shared
+	lookups *concurrent.Inflight[string, *Endpoint]
 }
 
 // NewLookupService creates a new service instance
 func NewLookupService() *LookupService {
 	srv := &LookupService{
 		ServiceImpl: *NewServiceImpl(),
+		lookups:     concurrent.NewInflight[string, *Endpoint](),
 	}
 	// defined message instantiators
 	srv.factories[ReqNODE] = NewFindNodeMsg
@@ -169,7 +174,7 @@ func (s *LookupService) Respond(ctx context.Context, m Message) (bool, error) {
 		})
 	} else {
 		// return closest nodes in our routing table
-		for _, addr := range s.Node().Closest(KBuckets) {
+		for _, addr := range s.Node().Closest(s.Node().Routing().Config().K) {
 			netw = s.Node().Resolve(addr)
 			resp.Add(&Endpoint{
 				Addr: addr,
@@ -229,8 +234,17 @@ func (s *LookupService) Request(ctx context.Context, rcv, addr *Address, timeout
 // address list, the referenced nodes are queried for a result.
 type Query func(ctx context.Context, peer, addr *Address) interface{}
 
-// LookupNode a node endpoint address.
+// LookupNode a node endpoint address. Concurrent lookups for the same
+// address share a single lookup.
 func (s *LookupService) LookupNode(ctx context.Context, addr *Address, timeout time.Duration) (entry *Endpoint, err error) {
+	entry, _, err = s.lookups.Do(ctx, addr.String(), func(ctx context.Context) (*Endpoint, error) {
+		return s.lookupNode(ctx, addr, timeout)
+	})
+	return
+}
+
+// lookupNode performs a node lookup.
+func (s *LookupService) lookupNode(ctx context.Context, addr *Address, timeout time.Duration) (entry *Endpoint, err error) {
 	sAddr := s.Node().Address()
 	logger.Printf(logger.INFO, "[%.8s] Lookup for '%.8s':\n", sAddr, addr)
 
@@ -256,15 +270,21 @@ func (s *LookupService) LookupNode(ctx context.Context, addr *Address, timeout t
 		return peers
 	}
 	// call the resolver
+	return LookupValue[*Endpoint](ctx, s, addr, query, timeout)
+}
+
+// LookupValue is a typed lookup: the final result of the resolver must be
+// of type T.
+func LookupValue[T any](ctx context.Context, s *LookupService, addr *Address, resolver Query, timeout time.Duration) (val T, err error) {
 	var res interface{}
-	res, err = s.Lookup(ctx, addr, query, timeout)
-	if err != nil {
+	if res, err = s.Lookup(ctx, addr, resolver, timeout); err != nil || res == nil {
 		return
 	}
-	if res == nil {
-		return
+	var ok bool
+	if val, ok = res.(T); !ok {
+		err = gerr.New(ErrLookupFailed, "unexpected result type %T", res)
 	}
-	return res.(*Endpoint), nil
+	return
 }
 
 // Lookup with specific resolver logic to handle mutlitple lookup scenarios.
@@ -307,7 +327,7 @@ func (s *LookupService) Lookup(ctx context.Context, addr *Address, resolver Quer
 		}
 	}
 	// start resolver with closest nodes
-	closest := s.Node().Closest(KBuckets)
+	closest := s.Node().Closest(s.Node().Routing().Config().K)
 	out := make(chan interface{})
 	defer close(out)
 	for {
@@ -0,0 +1,833 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/data"
+)
+
+// Error codes
+var (
+	ErrMailboxRefused  = errors.New("mailbox refused deposit")
+	ErrMailboxFull     = errors.New("mailbox full")
+	ErrMailboxResponse = errors.New("invalid mailbox response")
+	ErrMailboxAuth     = errors.New("unauthorized mailbox request")
+)
+
+// Mailbox parameters
+var (
+	MailboxMaxItems   = 64                 // max. number of messages per recipient
+	MailboxMaxTotal   = 4096               // max. number of messages in mailbox
+	MailboxMaxTTL     = 7 * 24 * time.Hour // max. lifetime of messages
+	MailboxReceiptTTL = 7 * 24 * time.Hour // lifetime of retrieval receipts
+	MailboxFetchMax   = 16                 // max. number of messages per fetch
+	MailboxMaxSender  = 256                // max. number of messages per depositor
+	MailboxAuthWindow = 5 * time.Minute    // max. clock skew of signed requests
+)
+
+// Mailbox deposit status
+const (
+	MailOK      = 0 // message stored
+	MailRefused = 1 // node is not a mailbox
+	MailFull    = 2 // mailbox is full
+)
+
+// Mailbox message states
+const (
+	MailUnknown   = 0 // message unknown (or expired)
+	MailPending   = 1 // message waiting for retrieval
+	MailRetrieved = 2 // message retrieved (receipt available)
+)
+
+// receiptLabel is prepended to message identifiers for signing receipts
+var receiptLabel = []byte("gospel/p2p/mailbox/receipt")
+
+// mailAuthLabel is prepended to fetch and status requests for signing
+var mailAuthLabel = []byte("gospel/p2p/mailbox/auth")
+
+//----------------------------------------------------------------------
+// Mailbox data structures
+//----------------------------------------------------------------------
+
+// MailItem is a message stored in a mailbox
+type MailItem struct {
+	ID     []byte   `size:"32"` // message identifier
+	Sender *Address // depositor of message
+	Expire uint64   `order:"big"` // expiration (Unix epoch)
+	Size   uint16   `order:"big"` // size of packet
+	Pkt    []byte   `size:"Size"` // packet (encrypted for recipient)
+}
+
+// MailReceipt is a proof of retrieval for a message: the recipient signs
+// the message identifier.
+type MailReceipt struct {
+	ID  []byte `size:"32"` // message identifier
+	Sig []byte `size:"64"` // EdDSA signature of recipient
+}
+
+// NewMailReceipt creates a signed receipt for a message identifier.
+func NewMailReceipt(id []byte, prv *ed25519.PrivateKey) (*MailReceipt, error) {
+	sig, err := prv.EdSign(concat(receiptLabel, id))
+	if err != nil {
+		return nil, err
+	}
+	return &MailReceipt{
+		ID:  id,
+		Sig: sig.Bytes(),
+	}, nil
+}
+
+// Verify a receipt for a recipient.
+func (r *MailReceipt) Verify(rcpt *Address) bool {
+	sig, err := ed25519.NewEdSignatureFromBytes(r.Sig)
+	if err != nil {
+		return false
+	}
+	ok, err := rcpt.PublicKey().EdVerify(concat(receiptLabel, r.ID), sig)
+	return ok && err == nil
+}
+
+// MailStatus is the state of a deposited message
+type MailStatus struct {
+	ID    []byte `size:"32"` // message identifier
+	State uint8  // message state
+	Sig   []byte `size:"64"` // receipt signature (if retrieved)
+}
+
+//----------------------------------------------------------------------
+// MDEP messages (deposit request and response)
+//----------------------------------------------------------------------
+
+// MailDepositMsg stores a packet for a recipient in a mailbox
+type MailDepositMsg struct {
+	MsgHeader
+
+	Rcpt *Address // recipient of packet
+	TTL  uint32   `order:"big"` // lifetime of message (in seconds)
+	Len  uint16   `order:"big"` // size of packet
+	Pkt  []byte   `size:"Len"`  // packet (encrypted for recipient)
+}
+
+// String returns human-readable message
+func (m *MailDepositMsg) String() string {
+	return fmt.Sprintf("MDEP{%.8s -> %.8s, #%d}[%.8s,%d]", m.Sender, m.Receiver, m.TxID, m.Rcpt, m.Len)
+}
+
+// NewMailDepositMsg creates an empty deposit request
+func NewMailDepositMsg() Message {
+	return &MailDepositMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + AddrSize + 6,
+			TxID:     0,
+			Type:     ReqMDEP,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Rcpt: nil,
+		TTL:  0,
+		Len:  0,
+		Pkt:  nil,
+	}
+}
+
+// MailDepositRespMsg is the response to a deposit request
+type MailDepositRespMsg struct {
+	MsgHeader
+
+	Status uint8  // deposit status
+	ID     []byte `size:"32"` // message identifier
+}
+
+// String returns human-readable message
+func (m *MailDepositRespMsg) String() string {
+	return fmt.Sprintf("MDEP_RESP{%.8s -> %.8s, #%d}[%d]", m.Sender, m.Receiver, m.TxID, m.Status)
+}
+
+// NewMailDepositRespMsg creates an empty deposit response
+func NewMailDepositRespMsg() Message {
+	return &MailDepositRespMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 33,
+			TxID:     0,
+			Type:     RespMDEP,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Status: MailOK,
+		ID:     make([]byte, 32),
+	}
+}
+
+//----------------------------------------------------------------------
+// MGET messages (fetch request and response)
+//----------------------------------------------------------------------
+
+// MailFetchMsg fetches messages from a mailbox. It carries receipts for
+// messages retrieved in a previous fetch (which are then removed from
+// the mailbox). Requests are signed by the owner of the mailbox entries.
+type MailFetchMsg struct {
+	MsgHeader
+
+	Max      uint16         `order:"big"` // max. number of messages
+	Time     uint64         `order:"big"` // time of request (Unix epoch)
+	Sig      []byte         `size:"64"`   // EdDSA signature of sender
+	Num      uint16         `order:"big"` // number of receipts
+	Receipts []*MailReceipt `size:"Num"`  // list of receipts
+}
+
+// String returns human-readable message
+func (m *MailFetchMsg) String() string {
+	return fmt.Sprintf("MGET{%.8s -> %.8s, #%d}[%d]", m.Sender, m.Receiver, m.TxID, m.Num)
+}
+
+// NewMailFetchMsg creates an empty fetch request
+func NewMailFetchMsg() Message {
+	return &MailFetchMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 76,
+			TxID:     0,
+			Type:     ReqMGET,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Max:      0,
+		Time:     0,
+		Sig:      make([]byte, 64),
+		Num:      0,
+		Receipts: make([]*MailReceipt, 0),
+	}
+}
+
+// Sign the request with the private key of the sender.
+func (m *MailFetchMsg) Sign(prv *ed25519.PrivateKey) (err error) {
+	m.Sig, err = mailSign(prv, m.signedData())
+	return
+}
+
+// Verify the signature of the request.
+func (m *MailFetchMsg) Verify() bool {
+	return mailVerify(m.Sender, m.signedData(), m.Sig)
+}
+
+// signedData returns the data signed by the sender (the message
+// without flags and signature).
+func (m *MailFetchMsg) signedData() []byte {
+	mm := *m
+	mm.Flags = 0
+	mm.Sig = make([]byte, 64)
+	buf, _ := data.Marshal(&mm)
+	return concat(mailAuthLabel, buf)
+}
+
+// Add a receipt to the request
+func (m *MailFetchMsg) Add(r *MailReceipt) {
+	m.Receipts = append(m.Receipts, r)
+	m.Num++
+	m.Size += 96
+}
+
+// MailFetchRespMsg returns messages from a mailbox
+type MailFetchRespMsg struct {
+	MsgHeader
+
+	More  uint16      `order:"big"` // number of remaining messages
+	Num   uint16      `order:"big"` // number of messages
+	Items []*MailItem `size:"Num"`  // list of messages
+}
+
+// String returns human-readable message
+func (m *MailFetchRespMsg) String() string {
+	return fmt.Sprintf("MGET_RESP{%.8s -> %.8s, #%d}[%d,%d]", m.Sender, m.Receiver, m.TxID, m.Num, m.More)
+}
+
+// NewMailFetchRespMsg creates an empty fetch response
+func NewMailFetchRespMsg() Message {
+	return &MailFetchRespMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 4,
+			TxID:     0,
+			Type:     RespMGET,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		More:  0,
+		Num:   0,
+		Items: make([]*MailItem, 0),
+	}
+}
+
+// Add a message to the response (if the maximum message size is not
+// exceeded).
+func (m *MailFetchRespMsg) Add(item *MailItem) bool {
+	size := 42 + int(AddrSize) + int(item.Size)
+	if int(m.Size)+size > MaxMsgSize-PacketOverhead {
+		return false
+	}
+	m.Items = append(m.Items, item)
+	m.Num++
+	m.Size += uint16(size)
+	return true
+}
+
+//----------------------------------------------------------------------
+// MSTAT messages (status request and response)
+//----------------------------------------------------------------------
+
+// MailStatusMsg queries the state of deposited messages. Requests are
+// signed by the depositor.
+type MailStatusMsg struct {
+	MsgHeader
+
+	Time uint64  `order:"big"` // time of request (Unix epoch)
+	Sig  []byte  `size:"64"`   // EdDSA signature of sender
+	Num  uint16  `order:"big"` // number of identifiers
+	IDs  []*Hash `size:"Num"`  // list of message identifiers
+}
+
+// String returns human-readable message
+func (m *MailStatusMsg) String() string {
+	return fmt.Sprintf("MSTAT{%.8s -> %.8s, #%d}[%d]", m.Sender, m.Receiver, m.TxID, m.Num)
+}
+
+// NewMailStatusMsg creates an empty status request
+func NewMailStatusMsg() Message {
+	return &MailStatusMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 74,
+			TxID:     0,
+			Type:     ReqMSTAT,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Time: 0,
+		Sig:  make([]byte, 64),
+		Num:  0,
+		IDs:  make([]*Hash, 0),
+	}
+}
+
+// Sign the request with the private key of the sender.
+func (m *MailStatusMsg) Sign(prv *ed25519.PrivateKey) (err error) {
+	m.Sig, err = mailSign(prv, m.signedData())
+	return
+}
+
+// Verify the signature of the request.
+func (m *MailStatusMsg) Verify() bool {
+	return mailVerify(m.Sender, m.signedData(), m.Sig)
+}
+
+// signedData returns the data signed by the sender (the message
+// without flags and signature).
+func (m *MailStatusMsg) signedData() []byte {
+	mm := *m
+	mm.Flags = 0
+	mm.Sig = make([]byte, 64)
+	buf, _ := data.Marshal(&mm)
+	return concat(mailAuthLabel, buf)
+}
+
+// Add a message identifier to the request
+func (m *MailStatusMsg) Add(id []byte) {
+	m.IDs = append(m.IDs, NewHash(id))
+	m.Num++
+	m.Size += 32
+}
+
+// MailStatusRespMsg returns the state of deposited messages
+type MailStatusRespMsg struct {
+	MsgHeader
+
+	Num  uint16        `order:"big"` // number of entries
+	List []*MailStatus `size:"Num"`  // list of states
+}
+
+// String returns human-readable message
+func (m *MailStatusRespMsg) String() string {
+	return fmt.Sprintf("MSTAT_RESP{%.8s -> %.8s, #%d}[%d]", m.Sender, m.Receiver, m.TxID, m.Num)
+}
+
+// NewMailStatusRespMsg creates an empty status response
+func NewMailStatusRespMsg() Message {
+	return &MailStatusRespMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 2,
+			TxID:     0,
+			Type:     RespMSTAT,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Num:  0,
+		List: make([]*MailStatus, 0),
+	}
+}
+
+// Add a state to the response
+func (m *MailStatusRespMsg) Add(s *MailStatus) {
+	m.List = append(m.List, s)
+	m.Num++
+	m.Size += 97
+}
+
+//----------------------------------------------------------------------
+// Mailbox service:
+// Designated nodes (mailboxes) store packets for offline recipients;
+// the packets are encrypted for the recipient, so the mailbox can't
+// read the messages. Recipients collect their messages when they come
+// online and sign receipts (proof of retrieval) for every collected
+// message; depositors can query the state of their messages and get
+// the receipts.
+// Messages expire after their lifetime; the number of stored messages
+// is limited per recipient, per depositor and in total.
+// Fetch and status requests are signed by the recipient (or depositor)
+// and must be recent, so only the owner can access its entries.
+//----------------------------------------------------------------------
+
+// mailReceipt is a stored retrieval receipt
+type mailReceipt struct {
+	depositor *Address // depositor of message
+	sig       []byte   // receipt signature
+	expire    int64    // expiration of receipt
+}
+
+// MailboxService stores messages for offline peers (if enabled) and
+// deposits/collects messages in/from remote mailboxes.
+type MailboxService struct {
+	ServiceImpl
+
+	serve    bool                    // act as mailbox for other nodes?
+	boxes    map[string][]*MailItem  // stored messages per recipient
+	total    int                     // total number of stored messages
+	senders  map[string]int          // number of stored messages per depositor
+	receipts map[string]*mailReceipt // receipts for retrieved messages
+	lock     sync.Mutex              // lock for concurrent access
+}
+
+// NewMailboxService creates a new service instance. If 'serve' is set,
+// the node stores messages for other nodes.
+func NewMailboxService(serve bool) *MailboxService {
+	srv := &MailboxService{
+		ServiceImpl: *NewServiceImpl(),
+		serve:       serve,
+		boxes:       make(map[string][]*MailItem),
+		senders:     make(map[string]int),
+		receipts:    make(map[string]*mailReceipt),
+	}
+	// defined message instantiators
+	srv.factories[ReqMDEP] = NewMailDepositMsg
+	srv.factories[RespMDEP] = NewMailDepositRespMsg
+	srv.factories[ReqMGET] = NewMailFetchMsg
+	srv.factories[RespMGET] = NewMailFetchRespMsg
+	srv.factories[ReqMSTAT] = NewMailStatusMsg
+	srv.factories[RespMSTAT] = NewMailStatusRespMsg
+
+	// defined known labels
+	srv.labels[ReqMDEP] = "MDEP"
+	srv.labels[RespMDEP] = "MDEP_RESP"
+	srv.labels[ReqMGET] = "MGET"
+	srv.labels[RespMGET] = "MGET_RESP"
+	srv.labels[ReqMSTAT] = "MSTAT"
+	srv.labels[RespMSTAT] = "MSTAT_RESP"
+	return srv
+}
+
+// Name is a human-readble and short service description like "PING"
+func (s *MailboxService) Name() string {
+	return "mailbox"
+}
+
+// NewMessage creates an empty service message of given type
+func (s *MailboxService) NewMessage(mt int) Message {
+	if fac, ok := s.factories[mt]; ok {
+		return fac()
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------
+// Client side
+//----------------------------------------------------------------------
+
+// Deposit a message for its receiver in a mailbox. The message is
+// wrapped into a packet for the receiver. Returns the message identifier
+// assigned by the mailbox.
+func (s *MailboxService) Deposit(ctx context.Context, mbox *Address, msg Message, ttl, timeout time.Duration) ([]byte, error) {
+	// wrap message for recipient
+	pkt, err := s.node.Wrap(msg)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := data.Marshal(pkt)
+	if err != nil {
+		return nil, err
+	}
+	// assemble request
+	req, _ := NewMailDepositMsg().(*MailDepositMsg)
+	req.TxID = s.node.NextID()
+	req.Sender = s.node.Address()
+	req.Receiver = mbox
+	req.Rcpt = msg.Header().Receiver
+	req.TTL = uint32(ttl / time.Second)
+	req.Len = uint16(len(buf))
+	req.Pkt = buf
+	req.Size += req.Len
+
+	// send request and wait for response
+	m, err := s.request(ctx, req, timeout)
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := m.(*MailDepositRespMsg)
+	if !ok {
+		return nil, ErrMailboxResponse
+	}
+	switch resp.Status {
+	case MailOK:
+		return resp.ID, nil
+	case MailFull:
+		return nil, ErrMailboxFull
+	}
+	return nil, ErrMailboxRefused
+}
+
+// Collect all messages for this node from a mailbox. Receipts for the
+// collected messages are signed and handed to the mailbox.
+func (s *MailboxService) Collect(ctx context.Context, mbox *Address, timeout time.Duration) ([]Message, error) {
+	list := make([]Message, 0)
+	var receipts []*MailReceipt
+	for {
+		// assemble request
+		req, _ := NewMailFetchMsg().(*MailFetchMsg)
+		req.TxID = s.node.NextID()
+		req.Sender = s.node.Address()
+		req.Receiver = mbox
+		req.Max = uint16(MailboxFetchMax)
+		for _, r := range receipts {
+			req.Add(r)
+		}
+		req.Time = uint64(s.node.Clock().Now().Unix())
+		if err := req.Sign(s.node.prvKey); err != nil {
+			return list, err
+		}
+		// send request and wait for response
+		m, err := s.request(ctx, req, timeout)
+		if err != nil {
+			return list, err
+		}
+		resp, ok := m.(*MailFetchRespMsg)
+		if !ok {
+			return list, ErrMailboxResponse
+		}
+		if len(resp.Items) == 0 {
+			break
+		}
+		// unwrap messages and sign receipts
+		receipts = make([]*MailReceipt, 0, len(resp.Items))
+		for _, item := range resp.Items {
+			pkt := new(Packet)
+			if err = data.Unmarshal(pkt, item.Pkt); err == nil {
+				var msg Message
+				if msg, err = s.node.Unwrap(pkt); err == nil {
+					list = append(list, msg)
+				}
+			}
+			// undecryptable messages are acknowledged too
+			r, err := NewMailReceipt(item.ID, s.node.prvKey)
+			if err != nil {
+				return list, err
+			}
+			receipts = append(receipts, r)
+		}
+	}
+	return list, nil
+}
+
+// Status returns the state of deposited messages in a mailbox.
+func (s *MailboxService) Status(ctx context.Context, mbox *Address, ids [][]byte, timeout time.Duration) ([]*MailStatus, error) {
+	// assemble request
+	req, _ := NewMailStatusMsg().(*MailStatusMsg)
+	req.TxID = s.node.NextID()
+	req.Sender = s.node.Address()
+	req.Receiver = mbox
+	for _, id := range ids {
+		req.Add(id)
+	}
+	req.Time = uint64(s.node.Clock().Now().Unix())
+	if err := req.Sign(s.node.prvKey); err != nil {
+		return nil, err
+	}
+	// send request and wait for response
+	m, err := s.request(ctx, req, timeout)
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := m.(*MailStatusRespMsg)
+	if !ok {
+		return nil, ErrMailboxResponse
+	}
+	return resp.List, nil
+}
+
+// request sends a message to a mailbox and returns the response.
+func (s *MailboxService) request(ctx context.Context, req Message, timeout time.Duration) (resp Message, err error) {
+	hdlr := &TaskHandler{
+		msgHdlr: func(ctx context.Context, m Message) (bool, error) {
+			resp = m
+			return true, nil
+		},
+		timeout: timeout,
+	}
+	if err = s.Task(ctx, req, hdlr); err == nil && resp == nil {
+		err = ErrNodeTimeout
+	}
+	return
+}
+
+//----------------------------------------------------------------------
+// Mailbox side
+//----------------------------------------------------------------------
+
+// Respond to a service request from peer.
+func (s *MailboxService) Respond(ctx context.Context, m Message) (bool, error) {
+	var resp Message
+	switch msg := m.(type) {
+	case *MailDepositMsg:
+		resp = s.deposit(msg)
+	case *MailFetchMsg:
+		if !msg.Verify() || !mailRecent(s.node.Clock().Now(), msg.Time) {
+			return true, ErrMailboxAuth
+		}
+		resp = s.fetch(msg)
+	case *MailStatusMsg:
+		if !msg.Verify() || !mailRecent(s.node.Clock().Now(), msg.Time) {
+			return true, ErrMailboxAuth
+		}
+		resp = s.status(msg)
+	default:
+		return false, nil
+	}
+	// send response
+	hdr := m.Header()
+	rh := resp.Header()
+	rh.TxID = hdr.TxID
+	rh.Sender = hdr.Receiver
+	rh.Receiver = hdr.Sender
+	return true, s.Send(ctx, resp)
+}
+
+// deposit a message in the mailbox
+func (s *MailboxService) deposit(msg *MailDepositMsg) Message {
+	resp, _ := NewMailDepositRespMsg().(*MailDepositRespMsg)
+	if !s.serve {
+		resp.Status = MailRefused
+		return resp
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.expire()
+
+	// check limits
+	key := msg.Rcpt.String()
+	box := s.boxes[key]
+	sender := msg.Sender.String()
+	if len(box) >= MailboxMaxItems || s.total >= MailboxMaxTotal || s.senders[sender] >= MailboxMaxSender {
+		resp.Status = MailFull
+		return resp
+	}
+	ttl := time.Duration(msg.TTL) * time.Second
+	if ttl <= 0 || ttl > MailboxMaxTTL {
+		ttl = MailboxMaxTTL
+	}
+	// store message
+	id := sha256.Sum256(concat(msg.Rcpt.Data, msg.Pkt))
+	item := &MailItem{
+		ID:     id[:],
+		Sender: msg.Sender,
+		Expire: uint64(s.node.Clock().Now().Add(ttl).Unix()),
+		Size:   msg.Len,
+		Pkt:    msg.Pkt,
+	}
+	s.boxes[key] = append(box, item)
+	s.total++
+	s.senders[sender]++
+	resp.ID = item.ID
+	return resp
+}
+
+// fetch messages from the mailbox (and process receipts)
+func (s *MailboxService) fetch(msg *MailFetchMsg) Message {
+	resp, _ := NewMailFetchRespMsg().(*MailFetchRespMsg)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.expire()
+
+	// process receipts: remove retrieved messages
+	key := msg.Sender.String()
+	box := s.boxes[key]
+	for _, r := range msg.Receipts {
+		if !r.Verify(msg.Sender) {
+			continue
+		}
+		for i, item := range box {
+			if string(item.ID) == string(r.ID) {
+				s.receipts[hex.EncodeToString(r.ID)] = &mailReceipt{
+					depositor: item.Sender,
+					sig:       r.Sig,
+					expire:    s.node.Clock().Now().Add(MailboxReceiptTTL).Unix(),
+				}
+				box = append(box[:i], box[i+1:]...)
+				s.remove(item)
+				break
+			}
+		}
+	}
+	if len(box) == 0 {
+		delete(s.boxes, key)
+	} else {
+		s.boxes[key] = box
+	}
+	// return messages
+	for _, item := range box {
+		if int(resp.Num) >= int(msg.Max) || !resp.Add(item) {
+			break
+		}
+	}
+	resp.More = uint16(len(box)) - resp.Num
+	return resp
+}
+
+// status of deposited messages
+func (s *MailboxService) status(msg *MailStatusMsg) Message {
+	resp, _ := NewMailStatusRespMsg().(*MailStatusRespMsg)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.expire()
+
+	for _, id := range msg.IDs {
+		st := &MailStatus{
+			ID:    id.Data,
+			State: MailUnknown,
+			Sig:   make([]byte, 64),
+		}
+		if r, ok := s.receipts[hex.EncodeToString(id.Data)]; ok && r.depositor.Equals(msg.Sender) {
+			st.State = MailRetrieved
+			st.Sig = r.sig
+		} else if s.pending(id.Data, msg.Sender) {
+			st.State = MailPending
+		}
+		resp.Add(st)
+	}
+	return resp
+}
+
+// pending returns true if a message from depositor is stored
+func (s *MailboxService) pending(id []byte, depositor *Address) bool {
+	for _, box := range s.boxes {
+		for _, item := range box {
+			if string(item.ID) == string(id) && item.Sender.Equals(depositor) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remove a message from the counters (must be called with lock held)
+func (s *MailboxService) remove(item *MailItem) {
+	s.total--
+	key := item.Sender.String()
+	if s.senders[key]--; s.senders[key] <= 0 {
+		delete(s.senders, key)
+	}
+}
+
+// expire messages and receipts (must be called with lock held)
+func (s *MailboxService) expire() {
+	now := s.node.Clock().Now().Unix()
+	for key, box := range s.boxes {
+		keep := box[:0]
+		for _, item := range box {
+			if int64(item.Expire) > now {
+				keep = append(keep, item)
+			} else {
+				s.remove(item)
+			}
+		}
+		if len(keep) == 0 {
+			delete(s.boxes, key)
+		} else {
+			s.boxes[key] = keep
+		}
+	}
+	for id, r := range s.receipts {
+		if r.expire <= now {
+			delete(s.receipts, id)
+		}
+	}
+}
+
+//----------------------------------------------------------------------
+// helpers
+//----------------------------------------------------------------------
+
+// mailSign signs request data with a private key.
+func mailSign(prv *ed25519.PrivateKey, buf []byte) ([]byte, error) {
+	sig, err := prv.EdSign(buf)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Bytes(), nil
+}
+
+// mailVerify checks the signature of a sender for request data.
+func mailVerify(sender *Address, buf, sig []byte) bool {
+	if sender == nil {
+		return false
+	}
+	es, err := ed25519.NewEdSignatureFromBytes(sig)
+	if err != nil {
+		return false
+	}
+	ok, err := sender.PublicKey().EdVerify(buf, es)
+	return ok && err == nil
+}
+
+// mailRecent returns true if a request time is within the time window
+// around 'now'.
+func mailRecent(now time.Time, t uint64) bool {
+	d := now.Sub(time.Unix(int64(t), 0))
+	return d < MailboxAuthWindow && d > -MailboxAuthWindow
+}
@@ -0,0 +1,203 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	gtime "github.com/bfix/gospel/time"
+)
+
+func TestMailbox(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// create nodes (sender, mailbox, recipient)
+	trans := NewLocalTransport()
+	names := []string{"sender", "mailbox", "rcpt"}
+	nodes := make([]*Node, 3)
+	srvs := make([]*MailboxService, 3)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		srvs[i] = NewMailboxService(i == 1)
+		n.AddService(srvs[i])
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	for i, n := range nodes {
+		for j, peer := range nodes {
+			if i != j {
+				if err := n.Learn(peer.Address(), names[j]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	sender, mbox, rcpt := nodes[0], nodes[1], nodes[2]
+	timeout := 5 * time.Second
+
+	// deposit message for recipient
+	msg, _ := NewPingMsg().(*PingMsg)
+	msg.TxID = 4711
+	msg.Sender = sender.Address()
+	msg.Receiver = rcpt.Address()
+	id, err := srvs[0].Deposit(ctx, mbox.Address(), msg, time.Hour, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// non-mailbox nodes refuse deposits
+	if _, err = srvs[0].Deposit(ctx, rcpt.Address(), msg, time.Hour, timeout); err != ErrMailboxRefused {
+		t.Fatalf("deposit not refused: %v", err)
+	}
+	st, err := srvs[0].Status(ctx, mbox.Address(), [][]byte{id}, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st) != 1 || st[0].State != MailPending {
+		t.Fatal("message not pending")
+	}
+	// collect messages
+	list, err := srvs[2].Collect(ctx, mbox.Address(), timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Header().TxID != 4711 || !list[0].Header().Sender.Equals(sender.Address()) {
+		t.Fatal("wrong collected messages")
+	}
+	// check receipt
+	if st, err = srvs[0].Status(ctx, mbox.Address(), [][]byte{id}, timeout); err != nil {
+		t.Fatal(err)
+	}
+	if len(st) != 1 || st[0].State != MailRetrieved {
+		t.Fatal("message not retrieved")
+	}
+	r := &MailReceipt{ID: st[0].ID, Sig: st[0].Sig}
+	if !r.Verify(rcpt.Address()) {
+		t.Fatal("invalid receipt")
+	}
+	// mailbox is empty
+	if list, err = srvs[2].Collect(ctx, mbox.Address(), timeout); err != nil || len(list) != 0 {
+		t.Fatal("mailbox not empty")
+	}
+
+	// forged fetch request (wrong signer) is rejected
+	req, _ := NewMailFetchMsg().(*MailFetchMsg)
+	req.TxID = 1
+	req.Sender = rcpt.Address()
+	req.Receiver = mbox.Address()
+	req.Time = uint64(time.Now().Unix())
+	if err = req.Sign(sender.prvKey); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := srvs[1].Respond(ctx, req); !ok || err != ErrMailboxAuth {
+		t.Fatalf("forged fetch accepted: %v", err)
+	}
+	// stale request is rejected
+	req.Time = uint64(time.Now().Add(-2 * MailboxAuthWindow).Unix())
+	if err = req.Sign(rcpt.prvKey); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := srvs[1].Respond(ctx, req); !ok || err != ErrMailboxAuth {
+		t.Fatalf("stale fetch accepted: %v", err)
+	}
+	// per-depositor quota
+	max := MailboxMaxSender
+	MailboxMaxSender = 1
+	defer func() { MailboxMaxSender = max }()
+	if _, err = srvs[0].Deposit(ctx, mbox.Address(), msg, time.Hour, timeout); err != nil {
+		t.Fatal(err)
+	}
+	msg.TxID++
+	if _, err = srvs[0].Deposit(ctx, mbox.Address(), msg, time.Hour, timeout); err != ErrMailboxFull {
+		t.Fatalf("depositor quota exceeded: %v", err)
+	}
+}
+
+func TestMailboxExpiry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// nodes run on a virtual clock (far from real time)
+	clk := gtime.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	trans := NewLocalTransport()
+	names := []string{"sender", "mailbox"}
+	nodes := make([]*Node, 2)
+	srvs := make([]*MailboxService, 2)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.SetClock(clk)
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		srvs[i] = NewMailboxService(i == 1)
+		n.AddService(srvs[i])
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	if err := nodes[0].Learn(nodes[1].Address(), names[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[1].Learn(nodes[0].Address(), names[0]); err != nil {
+		t.Fatal(err)
+	}
+	mbox := nodes[1].Address()
+	timeout := 5 * time.Second
+
+	msg, _ := NewPingMsg().(*PingMsg)
+	msg.Sender = nodes[0].Address()
+	msg.Receiver = nodes[0].Address()
+	id, err := srvs[0].Deposit(ctx, mbox, msg, time.Hour, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := func() uint8 {
+		st, err := srvs[0].Status(ctx, mbox, [][]byte{id}, timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st[0].State
+	}
+	if state() != MailPending {
+		t.Fatal("message not pending")
+	}
+	clk.Advance(30 * time.Minute)
+	if state() != MailPending {
+		t.Fatal("message expired early")
+	}
+	clk.Advance(time.Hour)
+	if state() != MailUnknown {
+		t.Fatal("message not expired")
+	}
+}
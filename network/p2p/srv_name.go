@@ -0,0 +1,680 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/data"
+)
+
+// Error codes
+var (
+	ErrNameInvalid  = errors.New("invalid name record")
+	ErrNameTaken    = errors.New("name owned by another peer")
+	ErrNameStale    = errors.New("stale name record")
+	ErrNameRefused  = errors.New("name record refused")
+	ErrNameNotFound = errors.New("name not found")
+	ErrNameUnknown  = errors.New("name not registered by node")
+)
+
+// Name service parameters
+var (
+	NameMaxTTL     = 30 * 24 * time.Hour // max. lifetime of a name record
+	NameMaxRecords = 4096                // max. number of stored records
+	NameReplicas   = 3                   // number of peers storing a record
+	NameMaxLabel   = 255                 // max. length of a label
+)
+
+// Name record store status
+const (
+	NameOK      = 0 // record stored
+	NameTakenSt = 1 // label owned by another peer
+	NameStaleSt = 2 // record version not newer than stored record
+	NameInvSt   = 3 // invalid record (signature, namespace, expiry)
+	NameFullSt  = 4 // store is full
+)
+
+// nameLabel is prepended to record data for signing
+var nameLabel = []byte("gospel/p2p/name")
+
+//----------------------------------------------------------------------
+// Name records
+//----------------------------------------------------------------------
+
+// NameRecord binds a human-readable label to a node address (and an
+// optional endpoint like an onion address). Records are signed by their
+// owner and versioned; a newer version replaces older ones.
+//
+// Labels live in one of two namespaces:
+//   - "name": first-come-first-served; the first owner keeps the label
+//     until the record expires.
+//   - "name@<address>": key-owned; only the peer with the given address
+//     can register the label.
+type NameRecord struct {
+	Label    *String  // human-readable label
+	Owner    *Address // owner of record (signer)
+	Target   *Address // node address the label resolves to
+	Endpoint *String  // endpoint (optional)
+	Version  uint32   `order:"big"` // version of record
+	Expire   uint64   `order:"big"` // expiration (Unix epoch)
+	Sig      []byte   `size:"64"`   // EdDSA signature of owner
+}
+
+// NewNameRecord creates a new (unsigned) name record.
+func NewNameRecord(label string, owner, target *Address, endp string, version uint32, expire time.Time) *NameRecord {
+	return &NameRecord{
+		Label:    NewString(label),
+		Owner:    owner,
+		Target:   target,
+		Endpoint: NewString(endp),
+		Version:  version,
+		Expire:   uint64(expire.Unix()),
+		Sig:      make([]byte, 64),
+	}
+}
+
+// Size of the binary representation
+func (r *NameRecord) Size() uint16 {
+	return r.Label.Size() + r.Endpoint.Size() + 2*AddrSize + 76
+}
+
+// String returns a human-readable record
+func (r *NameRecord) String() string {
+	return fmt.Sprintf("Name{%s -> %.8s,v%d,owner=%.8s}", r.Label, r.Target, r.Version, r.Owner)
+}
+
+// signedData returns the data signed by the owner.
+func (r *NameRecord) signedData() []byte {
+	rr := *r
+	rr.Sig = make([]byte, 64)
+	buf, _ := data.Marshal(&rr)
+	return concat(nameLabel, buf)
+}
+
+// Sign the record with the private key of the owner.
+func (r *NameRecord) Sign(prv *ed25519.PrivateKey) error {
+	sig, err := prv.EdSign(r.signedData())
+	if err != nil {
+		return err
+	}
+	r.Sig = sig.Bytes()
+	return nil
+}
+
+// Verify the signature and namespace of the record.
+func (r *NameRecord) Verify() bool {
+	label := r.Label.String()
+	if len(label) == 0 || len(label) > NameMaxLabel {
+		return false
+	}
+	// check key-owned namespace
+	if pos := strings.LastIndex(label, "@"); pos != -1 {
+		if label[pos+1:] != r.Owner.String() {
+			return false
+		}
+	}
+	sig, err := ed25519.NewEdSignatureFromBytes(r.Sig)
+	if err != nil {
+		return false
+	}
+	ok, err := r.Owner.PublicKey().EdVerify(r.signedData(), sig)
+	return ok && err == nil
+}
+
+// Expired returns true if the record is expired at given time.
+func (r *NameRecord) Expired(now time.Time) bool {
+	return int64(r.Expire) <= now.Unix()
+}
+
+// NameKey returns the DHT key (address) for a label.
+func NameKey(label string) *Address {
+	h := sha256.Sum256(concat(nameLabel, []byte(label)))
+	return NewAddress(h[:])
+}
+
+//----------------------------------------------------------------------
+// NPUT messages (store name record)
+//----------------------------------------------------------------------
+
+// NamePutMsg stores a name record on a peer
+type NamePutMsg struct {
+	MsgHeader
+
+	Rec *NameRecord // name record
+}
+
+// String returns human-readable message
+func (m *NamePutMsg) String() string {
+	return fmt.Sprintf("NPUT{%.8s -> %.8s, #%d}[%s]", m.Sender, m.Receiver, m.TxID, m.Rec)
+}
+
+// NewNamePutMsg creates an empty store request
+func NewNamePutMsg() Message {
+	return &NamePutMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize,
+			TxID:     0,
+			Type:     ReqNPUT,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Rec: nil,
+	}
+}
+
+// Set the record for the request
+func (m *NamePutMsg) Set(rec *NameRecord) *NamePutMsg {
+	m.Rec = rec
+	m.Size = HdrSize + rec.Size()
+	return m
+}
+
+// NamePutRespMsg is the response to a store request
+type NamePutRespMsg struct {
+	MsgHeader
+
+	Status uint8 // store status
+}
+
+// String returns human-readable message
+func (m *NamePutRespMsg) String() string {
+	return fmt.Sprintf("NPUT_RESP{%.8s -> %.8s, #%d}[%d]", m.Sender, m.Receiver, m.TxID, m.Status)
+}
+
+// NewNamePutRespMsg creates an empty store response
+func NewNamePutRespMsg() Message {
+	return &NamePutRespMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 1,
+			TxID:     0,
+			Type:     RespNPUT,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Status: NameOK,
+	}
+}
+
+//----------------------------------------------------------------------
+// NGET messages (query name record)
+//----------------------------------------------------------------------
+
+// NameGetMsg queries a name record from a peer
+type NameGetMsg struct {
+	MsgHeader
+
+	Label *String // label to resolve
+}
+
+// String returns human-readable message
+func (m *NameGetMsg) String() string {
+	return fmt.Sprintf("NGET{%.8s -> %.8s, #%d}[%s]", m.Sender, m.Receiver, m.TxID, m.Label)
+}
+
+// NewNameGetMsg creates an empty query
+func NewNameGetMsg() Message {
+	return &NameGetMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize,
+			TxID:     0,
+			Type:     ReqNGET,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Label: nil,
+	}
+}
+
+// Set the label for the query
+func (m *NameGetMsg) Set(label string) *NameGetMsg {
+	m.Label = NewString(label)
+	m.Size = HdrSize + m.Label.Size()
+	return m
+}
+
+// NameGetRespMsg returns a name record (if found)
+type NameGetRespMsg struct {
+	MsgHeader
+
+	Found uint8       // record found?
+	Rec   *NameRecord `opt:"(IsFound)"` // name record
+}
+
+// String returns human-readable message
+func (m *NameGetRespMsg) String() string {
+	return fmt.Sprintf("NGET_RESP{%.8s -> %.8s, #%d}[%d]", m.Sender, m.Receiver, m.TxID, m.Found)
+}
+
+// NewNameGetRespMsg creates an empty query response
+func NewNameGetRespMsg() Message {
+	return &NameGetRespMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 1,
+			TxID:     0,
+			Type:     RespNGET,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Found: 0,
+		Rec:   nil,
+	}
+}
+
+// IsFound returns true if the response contains a record
+func (m *NameGetRespMsg) IsFound() bool {
+	return m.Found != 0
+}
+
+// Set the record in the response
+func (m *NameGetRespMsg) Set(rec *NameRecord) *NameGetRespMsg {
+	m.Found = 1
+	m.Rec = rec
+	m.Size = HdrSize + 1 + rec.Size()
+	return m
+}
+
+//----------------------------------------------------------------------
+// Name service:
+// Name records are stored on the peers closest (in the DHT sense) to
+// the key derived from the label. Peers only accept valid records
+// (signature, namespace, expiry) from the owner of the label; records
+// expire and must be renewed by their owner before expiration.
+//----------------------------------------------------------------------
+
+// NameService stores name records for the network and registers and
+// resolves names for the local node.
+type NameService struct {
+	ServiceImpl
+
+	records map[string]*NameRecord // stored records (by label)
+	own     map[string]*NameRecord // records registered by this node
+	lock    sync.Mutex             // lock for concurrent access
+}
+
+// NewNameService creates a new service instance
+func NewNameService() *NameService {
+	srv := &NameService{
+		ServiceImpl: *NewServiceImpl(),
+		records:     make(map[string]*NameRecord),
+		own:         make(map[string]*NameRecord),
+	}
+	// defined message instantiators
+	srv.factories[ReqNPUT] = NewNamePutMsg
+	srv.factories[RespNPUT] = NewNamePutRespMsg
+	srv.factories[ReqNGET] = NewNameGetMsg
+	srv.factories[RespNGET] = NewNameGetRespMsg
+
+	// defined known labels
+	srv.labels[ReqNPUT] = "NPUT"
+	srv.labels[RespNPUT] = "NPUT_RESP"
+	srv.labels[ReqNGET] = "NGET"
+	srv.labels[RespNGET] = "NGET_RESP"
+	return srv
+}
+
+// Name is a human-readble and short service description like "PING"
+func (s *NameService) Name() string {
+	return "name"
+}
+
+// NewMessage creates an empty service message of given type
+func (s *NameService) NewMessage(mt int) Message {
+	if fac, ok := s.factories[mt]; ok {
+		return fac()
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------
+// Client side
+//----------------------------------------------------------------------
+
+// Register a label for a target address (and optional endpoint) for
+// given lifetime. Registering an already registered label publishes a
+// new version of the record. Returns the published record; the record
+// was accepted by a majority of the replicas.
+func (s *NameService) Register(ctx context.Context, label string, target *Address, endp string, ttl, timeout time.Duration) (*NameRecord, error) {
+	if ttl <= 0 || ttl > NameMaxTTL {
+		ttl = NameMaxTTL
+	}
+	s.lock.Lock()
+	version := uint32(1)
+	if rec, ok := s.own[label]; ok {
+		version = rec.Version + 1
+	}
+	s.lock.Unlock()
+
+	// create and sign record
+	expire := s.node.Clock().Now().Add(ttl)
+	rec := NewNameRecord(label, s.node.Address(), target, endp, version, expire)
+	if err := rec.Sign(s.node.prvKey); err != nil {
+		return nil, err
+	}
+	if !rec.Verify() {
+		return nil, ErrNameInvalid
+	}
+	if err := s.publish(ctx, rec, timeout); err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	s.own[label] = rec
+	s.lock.Unlock()
+	return rec, nil
+}
+
+// Renew a label registered by this node (new version with given lifetime).
+func (s *NameService) Renew(ctx context.Context, label string, ttl, timeout time.Duration) (*NameRecord, error) {
+	s.lock.Lock()
+	rec, ok := s.own[label]
+	s.lock.Unlock()
+	if !ok {
+		return nil, ErrNameUnknown
+	}
+	return s.Register(ctx, label, rec.Target, rec.Endpoint.String(), ttl, timeout)
+}
+
+// RenewExpiring renews all labels registered by this node that expire
+// within given duration. Returns the first error encountered.
+func (s *NameService) RenewExpiring(ctx context.Context, within, ttl, timeout time.Duration) (err error) {
+	limit := s.node.Clock().Now().Add(within)
+	s.lock.Lock()
+	labels := make([]string, 0)
+	for label, rec := range s.own {
+		if rec.Expired(limit) {
+			labels = append(labels, label)
+		}
+	}
+	s.lock.Unlock()
+	for _, label := range labels {
+		if _, e := s.Renew(ctx, label, ttl, timeout); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Resolve a label into a name record. The peers closest to the label
+// are queried and the valid record with the highest version is returned;
+// a stored record is only used if no replica has a newer version.
+func (s *NameService) Resolve(ctx context.Context, label string, timeout time.Duration) (*NameRecord, error) {
+	now := s.node.Clock().Now()
+	var best *NameRecord
+	better := func(rec *NameRecord) {
+		if rec == nil || rec.Label.String() != label || rec.Expired(now) || !rec.Verify() {
+			return
+		}
+		if best == nil || (rec.Owner.Equals(best.Owner) && rec.Version > best.Version) {
+			best = rec
+		}
+	}
+	s.lock.Lock()
+	better(s.records[label])
+	s.lock.Unlock()
+
+	// query peers
+	for _, peer := range s.replicas(ctx, NameKey(label), timeout) {
+		req, _ := NewNameGetMsg().(*NameGetMsg)
+		req.TxID = s.node.NextID()
+		req.Sender = s.node.Address()
+		req.Receiver = peer
+		req.Set(label)
+		m, err := s.request(ctx, req, timeout)
+		if err != nil {
+			continue
+		}
+		if resp, ok := m.(*NameGetRespMsg); ok && resp.IsFound() {
+			better(resp.Rec)
+		}
+	}
+	if best == nil {
+		return nil, ErrNameNotFound
+	}
+	// cache result
+	s.lock.Lock()
+	s.store(best)
+	s.lock.Unlock()
+	return best, nil
+}
+
+// publish a record on the peers closest to the label. The record must be
+// accepted by a majority of the replicas; a single replica reporting the
+// label as taken fails the registration (first-come-first-served).
+func (s *NameService) publish(ctx context.Context, rec *NameRecord, timeout time.Duration) error {
+	label := rec.Label.String()
+	now := s.node.Clock().Now()
+	s.lock.Lock()
+	if old, ok := s.records[label]; ok && !old.Expired(now) && !old.Owner.Equals(rec.Owner) {
+		s.lock.Unlock()
+		return ErrNameTaken
+	}
+	s.lock.Unlock()
+
+	var err error
+	stored := 0
+	peers := s.replicas(ctx, NameKey(label), timeout)
+	for _, peer := range peers {
+		req, _ := NewNamePutMsg().(*NamePutMsg)
+		req.TxID = s.node.NextID()
+		req.Sender = s.node.Address()
+		req.Receiver = peer
+		req.Set(rec)
+		m, e := s.request(ctx, req, timeout)
+		if e != nil {
+			err = e
+			continue
+		}
+		resp, ok := m.(*NamePutRespMsg)
+		if !ok {
+			err = ErrNameRefused
+			continue
+		}
+		switch e = nameStatusError(resp.Status); e {
+		case nil:
+			stored++
+		case ErrNameTaken:
+			return e
+		default:
+			err = e
+		}
+	}
+	// require a quorum of replicas
+	if len(peers) > 0 && stored <= len(peers)/2 {
+		if err == nil {
+			err = ErrNameRefused
+		}
+		return err
+	}
+	// keep a local copy
+	s.lock.Lock()
+	st := s.store(rec)
+	s.lock.Unlock()
+	if len(peers) == 0 {
+		return nameStatusError(st)
+	}
+	return nil
+}
+
+// replicas returns the peers closest to a key: the closest known peers
+// are asked for closer peers (single round).
+func (s *NameService) replicas(ctx context.Context, key *Address, timeout time.Duration) []*Address {
+	self := s.node.Address()
+	cand := make(map[string]*Address)
+	for _, p := range s.node.Routing().Peers() {
+		cand[p.String()] = p
+	}
+	list := sortByDistance(cand, key)
+	if len(list) > Alpha {
+		list = list[:Alpha]
+	}
+	for _, peer := range list {
+		res, err := s.node.LookupService().Request(ctx, peer, key, timeout)
+		if err != nil {
+			continue
+		}
+		for _, e := range res {
+			if e.Addr.Equals(self) {
+				continue
+			}
+			if _, ok := cand[e.Addr.String()]; !ok {
+				_ = s.node.Learn(e.Addr, e.Endp.String())
+				cand[e.Addr.String()] = e.Addr
+			}
+		}
+	}
+	list = sortByDistance(cand, key)
+	if len(list) > NameReplicas {
+		list = list[:NameReplicas]
+	}
+	return list
+}
+
+// request sends a message to a peer and returns the response.
+func (s *NameService) request(ctx context.Context, req Message, timeout time.Duration) (resp Message, err error) {
+	hdlr := &TaskHandler{
+		msgHdlr: func(ctx context.Context, m Message) (bool, error) {
+			resp = m
+			return true, nil
+		},
+		timeout: timeout,
+	}
+	if err = s.Task(ctx, req, hdlr); err == nil && resp == nil {
+		err = ErrNodeTimeout
+	}
+	return
+}
+
+//----------------------------------------------------------------------
+// Store side
+//----------------------------------------------------------------------
+
+// Respond to a service request from peer.
+func (s *NameService) Respond(ctx context.Context, m Message) (bool, error) {
+	var resp Message
+	switch msg := m.(type) {
+	case *NamePutMsg:
+		r, _ := NewNamePutRespMsg().(*NamePutRespMsg)
+		s.lock.Lock()
+		r.Status = s.store(msg.Rec)
+		s.lock.Unlock()
+		resp = r
+	case *NameGetMsg:
+		r, _ := NewNameGetRespMsg().(*NameGetRespMsg)
+		s.lock.Lock()
+		s.expire()
+		if rec, ok := s.records[msg.Label.String()]; ok {
+			r.Set(rec)
+		}
+		s.lock.Unlock()
+		resp = r
+	default:
+		return false, nil
+	}
+	// send response
+	hdr := m.Header()
+	rh := resp.Header()
+	rh.TxID = hdr.TxID
+	rh.Sender = hdr.Receiver
+	rh.Receiver = hdr.Sender
+	return true, s.Send(ctx, resp)
+}
+
+// store a record (must be called with lock held)
+func (s *NameService) store(rec *NameRecord) uint8 {
+	now := s.node.Clock().Now()
+	if rec == nil || rec.Expired(now) || !rec.Verify() {
+		return NameInvSt
+	}
+	// limit lifetime (allow for some clock skew)
+	if int64(rec.Expire) > now.Add(NameMaxTTL+time.Hour).Unix() {
+		return NameInvSt
+	}
+	s.expire()
+	label := rec.Label.String()
+	if old, ok := s.records[label]; ok {
+		if !old.Owner.Equals(rec.Owner) {
+			return NameTakenSt
+		}
+		if rec.Version <= old.Version {
+			if rec.Version == old.Version && string(rec.Sig) == string(old.Sig) {
+				return NameOK
+			}
+			return NameStaleSt
+		}
+	} else if len(s.records) >= NameMaxRecords {
+		return NameFullSt
+	}
+	s.records[label] = rec
+	return NameOK
+}
+
+// expire records (must be called with lock held)
+func (s *NameService) expire() {
+	now := s.node.Clock().Now()
+	for label, rec := range s.records {
+		if rec.Expired(now) {
+			delete(s.records, label)
+		}
+	}
+}
+
+//----------------------------------------------------------------------
+// helpers
+//----------------------------------------------------------------------
+
+// nameStatusError maps a store status to an error
+func nameStatusError(st uint8) error {
+	switch st {
+	case NameOK:
+		return nil
+	case NameTakenSt:
+		return ErrNameTaken
+	case NameStaleSt:
+		return ErrNameStale
+	case NameInvSt:
+		return ErrNameInvalid
+	}
+	return ErrNameRefused
+}
+
+// sortByDistance returns addresses ordered by distance to a key.
+func sortByDistance(m map[string]*Address, key *Address) []*Address {
+	list := make([]*Address, 0, len(m))
+	for _, a := range m {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Distance(key).Cmp(list[j].Distance(key)) < 0
+	})
+	return list
+}
@@ -0,0 +1,137 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	gtime "github.com/bfix/gospel/time"
+)
+
+func TestNameService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// create fully connected nodes sharing a virtual clock
+	clk := gtime.NewFakeClock(time.Now())
+	trans := NewLocalTransport()
+	names := []string{"n0", "n1", "n2", "n3", "n4"}
+	nodes := make([]*Node, len(names))
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.SetClock(clk)
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	for i, n := range nodes {
+		for j, peer := range nodes {
+			if i != j {
+				if err := n.Learn(peer.Address(), names[j]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	timeout := 5 * time.Second
+
+	// register name
+	ns := nodes[0].NameService()
+	rec, err := ns.Register(ctx, "alice", nodes[0].Address(), "alice.onion", time.Hour, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Version != 1 {
+		t.Fatalf("version %d", rec.Version)
+	}
+	// resolve from other node
+	res, err := nodes[4].ResolveName(ctx, "alice", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Target.Equals(nodes[0].Address()) || res.Endpoint.String() != "alice.onion" {
+		t.Fatalf("wrong record %s", res)
+	}
+	// first-come-first-served: label is taken
+	if _, err = nodes[1].NameService().Register(ctx, "alice", nodes[1].Address(), "", time.Hour, timeout); err != ErrNameTaken {
+		t.Fatalf("taken label registered: %v", err)
+	}
+	// key-owned namespace
+	owned := "bob@" + nodes[2].Address().String()
+	if _, err = nodes[1].NameService().Register(ctx, owned, nodes[1].Address(), "", time.Hour, timeout); err != ErrNameInvalid {
+		t.Fatalf("foreign key-owned label registered: %v", err)
+	}
+	if _, err = nodes[2].NameService().Register(ctx, owned, nodes[2].Address(), "", time.Hour, timeout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = nodes[3].ResolveName(ctx, owned, timeout); err != nil {
+		t.Fatal(err)
+	}
+	// renewal (new version)
+	clk.Advance(50 * time.Minute)
+	if err = ns.RenewExpiring(ctx, 15*time.Minute, time.Hour, timeout); err != nil {
+		t.Fatal(err)
+	}
+	if rec, err = nodes[3].ResolveName(ctx, "alice", timeout); err != nil || rec.Version != 2 {
+		t.Fatalf("renewal failed: %v", err)
+	}
+	// cached record (version 1) is superseded by replicas
+	if rec, err = nodes[4].ResolveName(ctx, "alice", timeout); err != nil || rec.Version != 2 {
+		t.Fatalf("stale record resolved: %v", err)
+	}
+	// expiration
+	clk.Advance(2 * time.Hour)
+	if _, err = nodes[4].ResolveName(ctx, "alice", timeout); err != ErrNameNotFound {
+		t.Fatalf("expired name resolved: %v", err)
+	}
+	// unknown names
+	if _, err = nodes[4].ResolveName(ctx, "carol", timeout); err != ErrNameNotFound {
+		t.Fatalf("unknown name resolved: %v", err)
+	}
+}
+
+func TestNameRecord(t *testing.T) {
+	_, prv := ed25519.NewKeypair()
+	owner := NewAddressFromKey(prv.Public())
+	rec := NewNameRecord("test", owner, owner, "", 1, time.Now().Add(time.Hour))
+	if rec.Verify() {
+		t.Fatal("unsigned record verified")
+	}
+	if err := rec.Sign(prv); err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Verify() {
+		t.Fatal("signed record not verified")
+	}
+	rec.Version++
+	if rec.Verify() {
+		t.Fatal("modified record verified")
+	}
+}
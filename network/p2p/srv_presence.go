@@ -0,0 +1,281 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Presence parameters
+var (
+	PresenceTick        = time.Second      // check interval for due probes
+	PresenceMinInterval = 15 * time.Second // probe interval (unconfirmed state)
+	PresenceMaxInterval = 5 * time.Minute  // max. probe interval (stable state)
+	PresenceTimeout     = 10 * time.Second // timeout for a single probe
+	PresenceFailures    = 2                // failed probes before going offline
+	PresenceQueue       = 64               // size of event queue per subscriber
+)
+
+//----------------------------------------------------------------------
+// Presence service:
+// Nodes subscribe to liveness changes of a set of peers. Peers are
+// probed with PING requests; the probe interval grows while the state
+// of a peer is stable and shrinks if a probe fails (to confirm a state
+// change quickly). Subscribers receive an event whenever a peer goes
+// online or offline.
+//----------------------------------------------------------------------
+
+// PresenceEvent signals a liveness change of a peer
+type PresenceEvent struct {
+	Peer     *Address  // peer address
+	Online   bool      // peer is online?
+	LastSeen time.Time // time of last successful probe (zero if never)
+}
+
+// PresenceSub is a subscription for liveness changes of peers. Events
+// are dropped if the subscriber does not consume them fast enough.
+type PresenceSub struct {
+	C <-chan *PresenceEvent // channel for events
+
+	ch    chan *PresenceEvent // event channel
+	peers map[string]bool     // subscribed peers
+}
+
+// presence is the liveness state of a peer
+type presence struct {
+	addr     *Address      // peer address
+	known    bool          // state known?
+	online   bool          // peer is online?
+	lastSeen time.Time     // time of last response
+	fails    int           // number of consecutive failed probes
+	interval time.Duration // current probe interval
+	next     time.Time     // time of next probe
+	probing  bool          // probe in progress?
+	refs     int           // number of subscriptions
+}
+
+// PresenceService tracks the liveness of peers for subscribers.
+type PresenceService struct {
+	ServiceImpl
+
+	peers map[string]*presence      // tracked peers
+	subs  map[*PresenceSub]struct{} // active subscriptions
+	lock  sync.Mutex                // lock for concurrent access
+}
+
+// NewPresenceService creates a new service instance
+func NewPresenceService() *PresenceService {
+	return &PresenceService{
+		ServiceImpl: *NewServiceImpl(),
+		peers:       make(map[string]*presence),
+		subs:        make(map[*PresenceSub]struct{}),
+	}
+}
+
+// Name is a human-readble and short service description like "PING"
+func (s *PresenceService) Name() string {
+	return "presence"
+}
+
+// NewMessage creates an empty service message of given type
+// (the service uses PING messages only).
+func (s *PresenceService) NewMessage(mt int) Message {
+	return nil
+}
+
+// Subscribe to liveness changes of peers.
+func (s *PresenceService) Subscribe(peers ...*Address) *PresenceSub {
+	ch := make(chan *PresenceEvent, PresenceQueue)
+	sub := &PresenceSub{
+		C:     ch,
+		ch:    ch,
+		peers: make(map[string]bool),
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subs[sub] = struct{}{}
+	now := s.node.Clock().Now()
+	for _, addr := range peers {
+		key := addr.String()
+		if sub.peers[key] {
+			continue
+		}
+		sub.peers[key] = true
+		p, ok := s.peers[key]
+		if !ok {
+			p = &presence{
+				addr:     addr,
+				interval: PresenceMinInterval,
+				next:     now,
+			}
+			s.peers[key] = p
+		}
+		p.refs++
+		// report known state to new subscriber
+		if p.known {
+			s.notify(sub, p)
+		}
+	}
+	return sub
+}
+
+// Unsubscribe from liveness changes (closes the event channel).
+func (s *PresenceService) Unsubscribe(sub *PresenceSub) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.subs[sub]; !ok {
+		return
+	}
+	delete(s.subs, sub)
+	for key := range sub.peers {
+		if p, ok := s.peers[key]; ok {
+			if p.refs--; p.refs == 0 {
+				delete(s.peers, key)
+			}
+		}
+	}
+	close(sub.ch)
+}
+
+// Status returns the current state of a tracked peer (or nil if the
+// peer is not tracked or its state is still unknown).
+func (s *PresenceService) Status(addr *Address) *PresenceEvent {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if p, ok := s.peers[addr.String()]; ok && p.known {
+		return p.event()
+	}
+	return nil
+}
+
+// Online returns true if a tracked peer is known to be online.
+func (s *PresenceService) Online(addr *Address) bool {
+	ev := s.Status(addr)
+	return ev != nil && ev.Online
+}
+
+// Seen reports activity of a peer observed elsewhere (e.g. a received
+// message); a tracked peer is considered online.
+func (s *PresenceService) Seen(addr *Address) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if p, ok := s.peers[addr.String()]; ok {
+		s.update(p, true)
+	}
+}
+
+// Run the prober until the context is cancelled.
+func (s *PresenceService) Run(ctx context.Context) {
+	clk := s.node.Clock()
+	tick := clk.NewTicker(PresenceTick)
+	go func() {
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C():
+				s.probeDue(ctx)
+			}
+		}
+	}()
+}
+
+// probeDue starts probes for all peers that are due.
+func (s *PresenceService) probeDue(ctx context.Context) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.node.Clock().Now()
+	for _, p := range s.peers {
+		if p.probing || now.Before(p.next) {
+			continue
+		}
+		p.probing = true
+		go func(p *presence) {
+			err := s.node.PingService().Ping(ctx, p.addr, PresenceTimeout, 0)
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			p.probing = false
+			if ctx.Err() == nil {
+				s.update(p, err == nil)
+			}
+		}(p)
+	}
+}
+
+// update the state of a peer after a probe (must be called with lock held)
+func (s *PresenceService) update(p *presence, ok bool) {
+	now := s.node.Clock().Now()
+	changed := false
+	if ok {
+		p.lastSeen = now
+		p.fails = 0
+		changed = !p.known || !p.online
+		p.online = true
+	} else {
+		p.fails++
+		if p.fails >= PresenceFailures || !p.known {
+			changed = !p.known || p.online
+			p.online = false
+		}
+	}
+	p.known = true
+
+	// adapt probe interval: grow while stable, reset on doubt
+	switch {
+	case changed || (!ok && p.online):
+		p.interval = PresenceMinInterval
+	default:
+		if p.interval *= 2; p.interval > PresenceMaxInterval {
+			p.interval = PresenceMaxInterval
+		}
+	}
+	p.next = now.Add(p.interval)
+
+	// notify subscribers
+	if changed {
+		key := p.addr.String()
+		for sub := range s.subs {
+			if sub.peers[key] {
+				s.notify(sub, p)
+			}
+		}
+	}
+}
+
+// notify a subscriber (non-blocking; must be called with lock held)
+func (s *PresenceService) notify(sub *PresenceSub, p *presence) {
+	select {
+	case sub.ch <- p.event():
+	default:
+	}
+}
+
+// event returns the current state as an event
+func (p *presence) event() *PresenceEvent {
+	return &PresenceEvent{
+		Peer:     p.addr,
+		Online:   p.online,
+		LastSeen: p.lastSeen,
+	}
+}
@@ -0,0 +1,117 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	gtime "github.com/bfix/gospel/time"
+)
+
+func TestPresence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	timeout := PresenceTimeout
+	PresenceTimeout = 200 * time.Millisecond
+	defer func() { PresenceTimeout = timeout }()
+
+	// create nodes with a shared virtual clock
+	clk := gtime.NewFakeClock(time.Now())
+	trans := NewLocalTransport()
+	names := []string{"watcher", "peer1", "peer2"}
+	nodes := make([]*Node, 3)
+	ctxs := make([]context.CancelFunc, 3)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.SetClock(clk)
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		var ctxNode context.Context
+		ctxNode, ctxs[i] = context.WithCancel(ctx)
+		go n.Run(ctxNode)
+	}
+	for i, n := range nodes {
+		for j, peer := range nodes {
+			if i != j {
+				if err := n.Learn(peer.Address(), names[j]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	// start presence service on watcher
+	srv := NewPresenceService()
+	nodes[0].AddService(srv)
+	srv.Run(ctx)
+	sub := srv.Subscribe(nodes[1].Address(), nodes[2].Address())
+
+	// wait for event (advancing the virtual clock)
+	state := make(map[string]*PresenceEvent)
+	wait := func(peer *Node, online bool) *PresenceEvent {
+		key := peer.Address().String()
+		for i := 0; i < 1000; i++ {
+			if ev, ok := state[key]; ok && ev.Online == online {
+				return ev
+			}
+			clk.Advance(PresenceTick)
+			select {
+			case ev := <-sub.C:
+				state[ev.Peer.String()] = ev
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		t.Fatalf("no presence event (online=%v)", online)
+		return nil
+	}
+	wait(nodes[1], true)
+	wait(nodes[2], true)
+	if !srv.Online(nodes[1].Address()) {
+		t.Fatal("peer not online")
+	}
+	// take peer offline
+	ctxs[2]()
+	ev := wait(nodes[2], false)
+	if ev.LastSeen.IsZero() {
+		t.Fatal("last seen not set")
+	}
+	if srv.Online(nodes[2].Address()) || !srv.Online(nodes[1].Address()) {
+		t.Fatal("wrong presence state")
+	}
+	// activity reported elsewhere
+	srv.Seen(nodes[2].Address())
+	wait(nodes[2], true)
+
+	// unsubscribe
+	srv.Unsubscribe(sub)
+	if srv.Status(nodes[1].Address()) != nil {
+		t.Fatal("peer still tracked")
+	}
+}
@@ -0,0 +1,412 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/logger"
+)
+
+// Error codes
+var (
+	ErrReachTarget  = errors.New("dial-back target not on requester host")
+	ErrReachUnknown = errors.New("requester endpoint unknown")
+	ErrReachLimit   = errors.New("dial-back rate exceeded")
+)
+
+// ReachDialInterval is the min. time between dial-backs to the same host
+var ReachDialInterval = 10 * time.Second
+
+//----------------------------------------------------------------------
+// PROBE message (request)
+//----------------------------------------------------------------------
+
+// ProbeMsg asks a peer to dial back the (advertised) endpoint of the
+// sender.
+type ProbeMsg struct {
+	MsgHeader
+
+	Endp *String // advertised endpoint of sender
+}
+
+// String returns human-readable message
+func (m *ProbeMsg) String() string {
+	return fmt.Sprintf("PROBE{%.8s -> %.8s, #%d}[%s]", m.Sender, m.Receiver, m.TxID, m.Endp)
+}
+
+// NewProbeMsg creates an empty PROBE request
+func NewProbeMsg() Message {
+	return &ProbeMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 2,
+			TxID:     0,
+			Type:     ReqPROBE,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Endp: NewString(""),
+	}
+}
+
+// Set the endpoint to be probed
+func (m *ProbeMsg) Set(endp string) *ProbeMsg {
+	m.Size -= m.Endp.Size()
+	m.Endp = NewString(endp)
+	m.Size += m.Endp.Size()
+	return m
+}
+
+//----------------------------------------------------------------------
+// PROBE_RESP message (response)
+//----------------------------------------------------------------------
+
+// Probe results reported by a peer
+const (
+	ProbeDialed  = 0 // dial-back was sent to endpoint
+	ProbeFailed  = 1 // dial-back failed (endpoint invalid or unreachable)
+	ProbeRefused = 2 // dial-back refused (requester unknown or rate exceeded)
+)
+
+// ProbeRespMsg is either sent directly to the probed endpoint (dial-back)
+// or as a report routed to the prober.
+type ProbeRespMsg struct {
+	MsgHeader
+
+	Direct bool  // message is a dial-back
+	Status uint8 // probe status (for reports)
+}
+
+// String returns human-readable message
+func (m *ProbeRespMsg) String() string {
+	return fmt.Sprintf("PROBE_RESP{%.8s -> %.8s, #%d}[%v,%d]", m.Sender, m.Receiver, m.TxID, m.Direct, m.Status)
+}
+
+// NewProbeRespMsg creates an empty PROBE response
+func NewProbeRespMsg() Message {
+	return &ProbeRespMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 2,
+			TxID:     0,
+			Type:     RespPROBE,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		Direct: false,
+		Status: ProbeDialed,
+	}
+}
+
+//----------------------------------------------------------------------
+// Reachability service:
+// A node asks sampled peers to dial back its advertised endpoint. A
+// dial-back that arrives at the endpoint proves that the node is
+// reachable from the outside on that transport. Peers additionally
+// report the outcome of the dial-back on the regular route, so a node
+// can tell a failed dial-back apart from an unresponsive peer.
+// The dial-back is encrypted for (and only accepted by) the prober, so
+// it can't be used to inject traffic into foreign endpoints. To prevent
+// the use of peers as traffic reflectors, a dial-back only goes to the
+// host the request was observed from (the port can differ) and the
+// number of dial-backs to a host is rate-limited.
+//----------------------------------------------------------------------
+
+// Reachability status of a node on a transport
+const (
+	ReachUnknown = iota // no (usable) probe results
+	ReachPublic         // endpoint is reachable from the outside
+	ReachPrivate        // endpoint is not reachable (NAT, firewall, ...)
+)
+
+// ReachLabel returns a human-readable reachability status
+func ReachLabel(status int) string {
+	switch status {
+	case ReachPublic:
+		return "public"
+	case ReachPrivate:
+		return "private"
+	}
+	return "unknown"
+}
+
+// ProbeResult is the outcome of a reachability probe
+type ProbeResult struct {
+	Network    string // network of endpoint ("udp", "tor",...)
+	Endpoint   string // probed endpoint
+	Confirmed  int    // number of dial-backs received
+	Failed     int    // number of failed dial-backs
+	Unanswered int    // number of unresponsive peers
+	Status     int    // resulting reachability status
+}
+
+// String returns a human-readable probe result
+func (r *ProbeResult) String() string {
+	return fmt.Sprintf("%s(%s)=%s[%d/%d/%d]", r.Network, r.Endpoint, ReachLabel(r.Status),
+		r.Confirmed, r.Failed, r.Unanswered)
+}
+
+// ReachChange is called whenever the reachability status of a network
+// changes (e.g. to enable relay mode or to request port mappings).
+type ReachChange func(network string, status int)
+
+// ReachService for probing the reachability of a node
+type ReachService struct {
+	ServiceImpl
+
+	// OnChange is called if the status of a network changes
+	OnChange ReachChange
+
+	status map[string]int       // reachability status per network
+	dialed map[string]time.Time // last dial-back per host
+	lock   sync.Mutex           // lock for concurrent access
+}
+
+// NewReachService creates a new service instance
+func NewReachService() *ReachService {
+	srv := &ReachService{
+		ServiceImpl: *NewServiceImpl(),
+		OnChange:    nil,
+		status:      make(map[string]int),
+		dialed:      make(map[string]time.Time),
+	}
+	// defined message instantiators
+	srv.factories[ReqPROBE] = NewProbeMsg
+	srv.factories[RespPROBE] = NewProbeRespMsg
+
+	// defined known labels
+	srv.labels[ReqPROBE] = "PROBE"
+	srv.labels[RespPROBE] = "PROBE_RESP"
+	return srv
+}
+
+// Name is a human-readble and short service description like "PING"
+func (s *ReachService) Name() string {
+	return "reach"
+}
+
+// NewMessage creates an empty service message of given type
+func (s *ReachService) NewMessage(mt int) Message {
+	if fac, ok := s.factories[mt]; ok {
+		return fac()
+	}
+	return nil
+}
+
+// Status returns the last known reachability status for a network.
+func (s *ReachService) Status(network string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.status[network]
+}
+
+// Probe the reachability of an advertised endpoint by asking 'num'
+// peers to dial back. The status for the network of the endpoint is
+// updated with the result.
+func (s *ReachService) Probe(ctx context.Context, endp string, num int, timeout time.Duration) (*ProbeResult, error) {
+	netw, err := s.node.NewNetworkAddr(endp)
+	if err != nil {
+		return nil, err
+	}
+	res := &ProbeResult{
+		Network:  netw.Network(),
+		Endpoint: endp,
+	}
+	// ask peers in parallel
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for _, peer := range s.node.Closest(num) {
+		wg.Add(1)
+		go func(peer *Address) {
+			defer wg.Done()
+			status := s.probe(ctx, peer, endp, timeout)
+			lock.Lock()
+			switch status {
+			case ReachPublic:
+				res.Confirmed++
+			case ReachPrivate:
+				res.Failed++
+			default:
+				res.Unanswered++
+			}
+			lock.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+
+	// a single dial-back proves reachability
+	switch {
+	case res.Confirmed > 0:
+		res.Status = ReachPublic
+	case res.Failed > 0:
+		res.Status = ReachPrivate
+	default:
+		res.Status = ReachUnknown
+	}
+	logger.Printf(logger.INFO, "[%.8s] Reachability: %s\n", s.node.Address(), res)
+
+	// update status (if known)
+	if res.Status != ReachUnknown {
+		s.lock.Lock()
+		changed := s.status[res.Network] != res.Status
+		s.status[res.Network] = res.Status
+		notify := s.OnChange
+		s.lock.Unlock()
+		if changed && notify != nil {
+			notify(res.Network, res.Status)
+		}
+	}
+	return res, nil
+}
+
+// Respond to a service request from peer.
+func (s *ReachService) Respond(ctx context.Context, m Message) (bool, error) {
+	msg, ok := m.(*ProbeMsg)
+	if !ok {
+		return false, nil
+	}
+	// dial back to advertised endpoint
+	status := uint8(ProbeDialed)
+	if err := s.dialBack(ctx, msg); err != nil {
+		logger.Printf(logger.WARN, "[%.8s] Dial-back to %s failed: %s\n", s.node.Address(), msg.Endp, err.Error())
+		status = ProbeFailed
+		if err == ErrReachUnknown || err == ErrReachLimit {
+			status = ProbeRefused
+		}
+	}
+	// report back to prober
+	resp, _ := NewProbeRespMsg().(*ProbeRespMsg)
+	resp.TxID = msg.TxID
+	resp.Sender = msg.Receiver
+	resp.Receiver = msg.Sender
+	resp.Status = status
+	return true, s.Send(ctx, resp)
+}
+
+//----------------------------------------------------------------------
+// internal methods
+//----------------------------------------------------------------------
+
+// probe asks a single peer to dial back.
+func (s *ReachService) probe(ctx context.Context, peer *Address, endp string, timeout time.Duration) int {
+	req, _ := NewProbeMsg().(*ProbeMsg)
+	req.TxID = s.node.NextID()
+	req.Sender = s.node.Address()
+	req.Receiver = peer
+	req.Set(endp)
+
+	// handle dial-back and report
+	var lock sync.Mutex
+	status, done, dialed := ReachUnknown, false, false
+	hdlr := &TaskHandler{
+		msgHdlr: func(ctx context.Context, m Message) (bool, error) {
+			resp, ok := m.(*ProbeRespMsg)
+			if !ok {
+				return false, nil
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if done {
+				return false, nil
+			}
+			switch {
+			case resp.Direct:
+				status, done = ReachPublic, true
+			case resp.Status == ProbeFailed:
+				status, done = ReachPrivate, true
+			case resp.Status == ProbeRefused:
+				done = true
+			default:
+				// dial-back sent: wait for its arrival
+				dialed = true
+			}
+			return done, nil
+		},
+		timeout: timeout,
+	}
+	if err := s.Task(ctx, req, hdlr); err != nil {
+		logger.Printf(logger.WARN, "[%.8s] Probe via %.8s failed: %s\n", s.node.Address(), peer, err.Error())
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	done = true
+	if status == ReachUnknown && dialed {
+		// dial-back was sent but never arrived
+		status = ReachPrivate
+	}
+	return status
+}
+
+// dialBack sends a (direct) response to the advertised endpoint.
+func (s *ReachService) dialBack(ctx context.Context, msg *ProbeMsg) error {
+	netw, err := s.node.NewNetworkAddr(msg.Endp.String())
+	if err != nil {
+		return err
+	}
+	// target must be on the observed host of the requester
+	from := s.node.Resolve(msg.Sender)
+	if from == nil {
+		return ErrReachUnknown
+	}
+	host := endpointHost(netw)
+	if from.Network() != netw.Network() || endpointHost(from) != host {
+		return ErrReachTarget
+	}
+	// limit dial-backs per host
+	now := s.node.Clock().Now()
+	s.lock.Lock()
+	for h, t := range s.dialed {
+		if now.Sub(t) >= ReachDialInterval {
+			delete(s.dialed, h)
+		}
+	}
+	if _, ok := s.dialed[host]; ok {
+		s.lock.Unlock()
+		return ErrReachLimit
+	}
+	s.dialed[host] = now
+	s.lock.Unlock()
+	resp, _ := NewProbeRespMsg().(*ProbeRespMsg)
+	resp.TxID = msg.TxID
+	resp.Sender = msg.Receiver
+	resp.Receiver = msg.Sender
+	resp.Direct = true
+	pkt, err := s.node.Wrap(resp)
+	if err != nil {
+		return err
+	}
+	return s.node.SendRaw(ctx, netw, pkt)
+}
+
+// endpointHost returns the host part of a network address (or the whole
+// address if it has no port).
+func endpointHost(addr net.Addr) string {
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
@@ -0,0 +1,101 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+)
+
+func TestReachability(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trans := NewLocalTransport()
+	names := []string{"prober", "peer1", "peer2"}
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, names[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	for i, n := range nodes {
+		for j, peer := range nodes {
+			if i != j {
+				if err := n.Learn(peer.Address(), names[j]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	srv := nodes[0].ReachService()
+	changes := 0
+	srv.OnChange = func(network string, status int) {
+		changes++
+	}
+	timeout := 5 * time.Second
+
+	// reachable endpoint
+	res, err := srv.Probe(ctx, names[0], 2, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != ReachPublic || res.Confirmed != 2 {
+		t.Fatalf("endpoint not reachable: %s", res)
+	}
+	if srv.Status("local") != ReachPublic {
+		t.Fatal("status not updated")
+	}
+	// misconfigured endpoint
+	if res, err = srv.Probe(ctx, "nowhere", 2, timeout); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != ReachPrivate || res.Failed != 2 {
+		t.Fatalf("endpoint reachable: %s", res)
+	}
+	// foreign endpoint is not dialed
+	if res, err = srv.Probe(ctx, names[1], 2, timeout); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != ReachPrivate || res.Failed != 2 {
+		t.Fatalf("foreign endpoint dialed: %s", res)
+	}
+	// repeated dial-backs to the same host are refused
+	if res, err = srv.Probe(ctx, names[0], 2, timeout); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != ReachUnknown || res.Unanswered != 2 {
+		t.Fatalf("dial-back not rate-limited: %s", res)
+	}
+	if changes != 2 {
+		t.Fatalf("expected 2 status changes, got %d", changes)
+	}
+}
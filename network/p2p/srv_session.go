@@ -0,0 +1,550 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/crypto"
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/logger"
+)
+
+// Error codes
+var (
+	ErrSessionUnknown  = errors.New("unknown session")
+	ErrSessionPeer     = errors.New("session peer mismatch")
+	ErrSessionTooLarge = errors.New("session payload too large")
+)
+
+// Session service parameters
+var (
+	SessionRetry    = 30 * time.Second // initial retry interval
+	SessionMaxTries = 8                // max. number of delivery attempts
+	SessionRelays   = 2                // number of relays for retries
+	SessionSeenMax  = 4096             // max. number of remembered messages
+	SessionMax      = 256              // max. number of sessions
+	SessionMaxIdle  = 24 * time.Hour   // max. idle time of a session
+)
+
+// SessionOverhead is the size of a session message without payload
+const SessionOverhead = HdrSize + 73 + 24 + 16
+
+// SessionRelayOverhead is the size added to a session message for each
+// relay layer (relay header, next hop with endpoint and packet); the
+// endpoint of a hop is assumed to be at most 128 bytes long.
+var SessionRelayOverhead = int(HdrSize+AddrSize+2+128) + PacketOverhead
+
+//----------------------------------------------------------------------
+// SMSG message (request)
+//----------------------------------------------------------------------
+
+// SessionMsg carries an encrypted application payload in a session
+type SessionMsg struct {
+	MsgHeader
+
+	SID  []byte `size:"32"` // session identifier (ephemeral key of initiator)
+	Role uint8  // 1 if sent by initiator of the session
+	DH   []byte `size:"32"`   // ratchet key of sender
+	PN   uint32 `order:"big"` // number of messages in previous chain
+	N    uint32 `order:"big"` // message number in chain
+	Body []byte `size:"*"`    // encrypted payload
+}
+
+// String returns human-readable message
+func (m *SessionMsg) String() string {
+	return fmt.Sprintf("SMSG{%.8s -> %.8s, #%d}[%d]", m.Sender, m.Receiver, m.TxID, len(m.Body))
+}
+
+// NewSessionMsg creates an empty session message
+func NewSessionMsg() Message {
+	return &SessionMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize + 73,
+			TxID:     0,
+			Type:     ReqSMSG,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+		SID:  nil,
+		Role: 0,
+		DH:   nil,
+		PN:   0,
+		N:    0,
+		Body: nil,
+	}
+}
+
+// header returns the ratchet header of the message
+func (m *SessionMsg) header() *ratchetHeader {
+	return &ratchetHeader{
+		DH: m.DH,
+		PN: m.PN,
+		N:  m.N,
+	}
+}
+
+// ad returns the associated data for encryption: sender, receiver and
+// session parameters.
+func (m *SessionMsg) ad() []byte {
+	buf := make([]byte, 0, 97)
+	buf = append(buf, m.Sender.Data...)
+	buf = append(buf, m.Receiver.Data...)
+	buf = append(buf, m.SID...)
+	return append(buf, m.Role)
+}
+
+// id returns a unique identifier for the message
+func (m *SessionMsg) id() string {
+	return hex.EncodeToString(m.SID) + skipID(m.DH, m.N)
+}
+
+//----------------------------------------------------------------------
+// SMSG_ACK message (response)
+//----------------------------------------------------------------------
+
+// SessionAckMsg acknowledges the receipt of a session message
+type SessionAckMsg struct {
+	MsgHeader
+}
+
+// String returns human-readable message
+func (m *SessionAckMsg) String() string {
+	return fmt.Sprintf("SMSG_ACK{%.8s -> %.8s, #%d}", m.Sender, m.Receiver, m.TxID)
+}
+
+// NewSessionAckMsg creates an empty acknowledgement
+func NewSessionAckMsg() Message {
+	return &SessionAckMsg{
+		MsgHeader: MsgHeader{
+			Size:     HdrSize,
+			TxID:     0,
+			Type:     RespSMSG,
+			Flags:    0,
+			Sender:   nil,
+			Receiver: nil,
+		},
+	}
+}
+
+//----------------------------------------------------------------------
+// Session service:
+// Application payloads are exchanged with peers in end-to-end encrypted
+// sessions with forward secrecy (double ratchet).
+//
+// Sessions are established without interaction (X3DH-style) from the
+// Ed25519 identities of both nodes and an ephemeral key 'E' of the
+// initiator: the shared secret is derived from '[i_A]I_B' and '[e]I_B'.
+// Both peers use fresh ratchet keys; the first messages of a session
+// are still only protected by the long-term key of the responder (until
+// its first reply).
+// Each session is identified by the ephemeral key of its initiator; if
+// both peers start a session at the same time, both sessions are used.
+//
+// Payloads are delivered at least once: undelivered messages are queued
+// and re-sent (via relays after the first attempt) until acknowledged;
+// the application must handle duplicates if the acknowledgement gets
+// lost. The retry loop must be started with 'Run()'.
+//
+// At most 'SessionMax' sessions are kept: if the limit is exceeded, the
+// least recently used session is dropped. Sessions idle for longer than
+// 'SessionMaxIdle' are dropped by the retry loop. A peer that still uses
+// a dropped session must start a new one.
+//----------------------------------------------------------------------
+
+// SessionHandler is called for every payload received in a session
+type SessionHandler func(sender *Address, payload []byte)
+
+// session with a peer
+type session struct {
+	peer      *Address  // address of peer
+	sid       []byte    // session identifier
+	initiator bool      // session started by us?
+	r         *ratchet  // ratchet state
+	used      time.Time // time of last use
+}
+
+// key returns the session key (role and identifier)
+func (s *session) key() string {
+	return sessionKey(s.initiator, s.sid)
+}
+
+// sessionKey returns the key for a session with given role and
+// identifier.
+func sessionKey(initiator bool, sid []byte) string {
+	if initiator {
+		return "I" + hex.EncodeToString(sid)
+	}
+	return "R" + hex.EncodeToString(sid)
+}
+
+// pending message (waiting for acknowledgement)
+type pending struct {
+	msg   *SessionMsg // message to deliver
+	tries int         // number of delivery attempts
+	next  time.Time   // time of next attempt
+}
+
+// SessionService for encrypted messaging between nodes
+type SessionService struct {
+	ServiceImpl
+
+	// Deliver is called for received payloads
+	Deliver SessionHandler
+
+	sessions map[string]*session // sessions (by identifier)
+	current  map[string]*session // sessions used for sending (by peer)
+	outbox   map[uint64]*pending // pending messages (by transaction id)
+	seen     map[string]bool     // recently received messages
+	seenList []string            // order of received messages
+	lock     sync.Mutex          // lock for concurrent access
+}
+
+// NewSessionService creates a new service instance
+func NewSessionService() *SessionService {
+	srv := &SessionService{
+		ServiceImpl: *NewServiceImpl(),
+		sessions:    make(map[string]*session),
+		current:     make(map[string]*session),
+		outbox:      make(map[uint64]*pending),
+		seen:        make(map[string]bool),
+		seenList:    make([]string, 0),
+	}
+	// defined message instantiators
+	srv.factories[ReqSMSG] = NewSessionMsg
+	srv.factories[RespSMSG] = NewSessionAckMsg
+
+	// defined known labels
+	srv.labels[ReqSMSG] = "SMSG"
+	srv.labels[RespSMSG] = "SMSG_ACK"
+	return srv
+}
+
+// Name is a human-readble and short service description like "PING"
+func (s *SessionService) Name() string {
+	return "session"
+}
+
+// NewMessage creates an empty service message of given type
+func (s *SessionService) NewMessage(mt int) Message {
+	switch mt {
+	case ReqSMSG:
+		return NewSessionMsg()
+	case RespSMSG:
+		return NewSessionAckMsg()
+	}
+	return nil
+}
+
+// Pending returns the number of unacknowledged messages.
+func (s *SessionService) Pending() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.outbox)
+}
+
+// SendPayload sends an application payload to a peer. A new session is
+// started if no session with the peer exists. If the peer can't be
+// reached, the message is queued for later delivery.
+func (s *SessionService) SendPayload(ctx context.Context, rcv *Address, payload []byte) error {
+	if len(payload) > MaxMsgSize-SessionOverhead-(SessionRelays+1)*SessionRelayOverhead {
+		return ErrSessionTooLarge
+	}
+	s.lock.Lock()
+	// get (or start) session with peer
+	key := rcv.String()
+	sess, ok := s.current[key]
+	if !ok {
+		var err error
+		if sess, err = s.initiate(rcv); err != nil {
+			s.lock.Unlock()
+			return err
+		}
+		s.current[key] = sess
+	}
+	s.store(sess)
+	// assemble message
+	msg, _ := NewSessionMsg().(*SessionMsg)
+	msg.TxID = s.node.NextID()
+	msg.Sender = s.node.Address()
+	msg.Receiver = rcv
+	msg.SID = sess.sid
+	if sess.initiator {
+		msg.Role = 1
+	}
+	hdr, ct, err := sess.r.encrypt(payload, msg.ad())
+	if err != nil {
+		s.lock.Unlock()
+		return err
+	}
+	msg.DH, msg.PN, msg.N = hdr.DH, hdr.PN, hdr.N
+	msg.Body = ct
+	msg.Size += uint16(len(ct))
+
+	// queue message and try to deliver it
+	p := &pending{msg: msg}
+	s.outbox[msg.TxID] = p
+	s.lock.Unlock()
+	s.deliver(ctx, p)
+	return nil
+}
+
+// Run the retry loop for undelivered messages.
+func (s *SessionService) Run(ctx context.Context) {
+	tick := s.node.Clock().NewTicker(SessionRetry / 4)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C():
+			// collect due messages
+			due := make([]*pending, 0)
+			s.lock.Lock()
+			for id, p := range s.outbox {
+				if p.next.After(now) {
+					continue
+				}
+				if p.tries >= SessionMaxTries {
+					logger.Printf(logger.WARN, "[%.8s] Dropping undelivered message #%d\n", s.node.Address(), id)
+					delete(s.outbox, id)
+					continue
+				}
+				due = append(due, p)
+			}
+			s.expire(now)
+			s.lock.Unlock()
+			// re-send messages
+			for _, p := range due {
+				s.deliver(ctx, p)
+			}
+		}
+	}
+}
+
+// Respond to a service request from peer.
+func (s *SessionService) Respond(ctx context.Context, m Message) (bool, error) {
+	// check we are responsible for this
+	hdr := m.Header()
+	if hdr.Type != ReqSMSG {
+		return false, nil
+	}
+	// cast will succeed because type of message is checked
+	msg, _ := m.(*SessionMsg)
+
+	// get (or accept) session
+	s.lock.Lock()
+	key := sessionKey(msg.Role == 0, msg.SID)
+	sess, ok := s.sessions[key]
+	if !ok {
+		if msg.Role != 1 {
+			s.lock.Unlock()
+			return true, ErrSessionUnknown
+		}
+		var err error
+		if sess, err = s.accept(hdr.Sender, msg.SID, msg.DH); err != nil {
+			s.lock.Unlock()
+			return true, err
+		}
+	} else if !sess.peer.Equals(hdr.Sender) {
+		s.lock.Unlock()
+		return true, ErrSessionPeer
+	}
+	// decrypt payload
+	id := msg.id()
+	payload, err := sess.r.decrypt(msg.header(), msg.Body, msg.ad())
+	if err != nil {
+		if !s.seen[id] {
+			s.lock.Unlock()
+			return true, err
+		}
+		// duplicate message: acknowledge again
+		payload, err = nil, nil
+	} else {
+		// remember session and message
+		s.store(sess)
+		if _, ok := s.current[hdr.Sender.String()]; !ok {
+			s.current[hdr.Sender.String()] = sess
+		}
+		s.remember(id)
+	}
+	s.lock.Unlock()
+
+	// deliver payload to application
+	if payload != nil && s.Deliver != nil {
+		s.Deliver(hdr.Sender, payload)
+	}
+	// acknowledge message
+	ack, _ := NewSessionAckMsg().(*SessionAckMsg)
+	ack.TxID = hdr.TxID
+	ack.Sender = hdr.Receiver
+	ack.Receiver = hdr.Sender
+	return true, s.Send(ctx, ack)
+}
+
+// Listen to service responses from peer.
+func (s *SessionService) Listen(ctx context.Context, m Message) (bool, error) {
+	// check we are responsible for this
+	hdr := m.Header()
+	if hdr.Type != RespSMSG {
+		return false, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p, ok := s.outbox[hdr.TxID]
+	if !ok || !p.msg.Receiver.Equals(hdr.Sender) {
+		return false, nil
+	}
+	delete(s.outbox, hdr.TxID)
+	return true, nil
+}
+
+//----------------------------------------------------------------------
+// internal methods
+//----------------------------------------------------------------------
+
+// deliver a pending message (directly on first attempt, relayed later)
+func (s *SessionService) deliver(ctx context.Context, p *pending) {
+	s.lock.Lock()
+	p.tries++
+	p.next = s.node.Clock().Now().Add(SessionRetry << (p.tries - 1))
+	tries := p.tries
+	s.lock.Unlock()
+
+	var msg Message = p.msg
+	if tries > 1 && SessionRelays > 0 {
+		if hops := s.node.Sample(SessionRelays, p.msg.Receiver); hops != nil {
+			// relaying changes the message (flags): work on a copy
+			cp := *p.msg
+			if m, err := s.node.RelayedMessage(&cp, hops); err == nil && int(m.Header().Size) <= MaxMsgSize {
+				m.Header().TxID = p.msg.TxID
+				msg = m
+			}
+		}
+	}
+	if err := s.node.Send(ctx, msg); err != nil {
+		logger.Printf(logger.INFO, "[%.8s] Message #%d queued: %s\n", s.node.Address(), p.msg.TxID, err.Error())
+	}
+}
+
+// initiate a new session with a peer (as initiator)
+func (s *SessionService) initiate(peer *Address) (*session, error) {
+	pubE, prvE := ed25519.NewKeypair()
+	pubP := peer.PublicKey()
+	sid := pubE.Bytes()
+	sk, err := sessionSecret(dh(s.node.prvKey, pubP), dh(prvE, pubP), sid)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRatchetInitiator(sk)
+	if err != nil {
+		return nil, err
+	}
+	return &session{
+		peer:      peer,
+		sid:       sid,
+		initiator: true,
+		r:         r,
+	}, nil
+}
+
+// accept a session from a peer (as responder): 'key' is the initial
+// ratchet key of the initiator.
+func (s *SessionService) accept(peer *Address, sid, key []byte) (*session, error) {
+	pubE, err := ratchetKey(sid)
+	if err != nil {
+		return nil, err
+	}
+	sk, err := sessionSecret(dh(s.node.prvKey, peer.PublicKey()), dh(s.node.prvKey, pubE), sid)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRatchetResponder(sk, key)
+	if err != nil {
+		return nil, err
+	}
+	return &session{
+		peer:      peer,
+		sid:       sid,
+		initiator: false,
+		r:         r,
+	}, nil
+}
+
+// store a session and drop the least recently used sessions if the
+// limit is exceeded (must be called with lock held).
+func (s *SessionService) store(sess *session) {
+	sess.used = s.node.Clock().Now()
+	s.sessions[sess.key()] = sess
+	for len(s.sessions) > SessionMax {
+		var lru *session
+		for _, c := range s.sessions {
+			if c != sess && (lru == nil || c.used.Before(lru.used)) {
+				lru = c
+			}
+		}
+		if lru == nil {
+			break
+		}
+		s.drop(lru)
+	}
+}
+
+// expire sessions idle for too long (must be called with lock held).
+func (s *SessionService) expire(now time.Time) {
+	for _, sess := range s.sessions {
+		if now.Sub(sess.used) > SessionMaxIdle {
+			s.drop(sess)
+		}
+	}
+}
+
+// drop a session (must be called with lock held).
+func (s *SessionService) drop(sess *session) {
+	logger.Printf(logger.DBG, "[%.8s] Dropping session %s\n", s.node.Address(), sess.key())
+	delete(s.sessions, sess.key())
+	key := sess.peer.String()
+	if cur, ok := s.current[key]; ok && cur == sess {
+		delete(s.current, key)
+	}
+}
+
+// remember a received message (for duplicate detection)
+func (s *SessionService) remember(id string) {
+	s.seen[id] = true
+	s.seenList = append(s.seenList, id)
+	if len(s.seenList) > SessionSeenMax {
+		delete(s.seen, s.seenList[0])
+		s.seenList = s.seenList[1:]
+	}
+}
+
+// sessionSecret derives the shared session secret
+func sessionSecret(dh1, dh2, sid []byte) ([]byte, error) {
+	return crypto.HKDF(sha256.New, concat(dh1, dh2), sid, []byte("gospel/p2p/session"), 32)
+}
@@ -0,0 +1,276 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	gtime "github.com/bfix/gospel/time"
+)
+
+func TestRatchet(t *testing.T) {
+	sk := make([]byte, 32)
+	alice, err := newRatchetInitiator(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad := []byte("ad")
+
+	// Alice sends three messages (delivered out of order)
+	type msg struct {
+		hdr *ratchetHeader
+		ct  []byte
+	}
+	out := make([]msg, 3)
+	for i := range out {
+		hdr, ct, err := alice.encrypt([]byte(fmt.Sprintf("msg %d", i)), ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = msg{hdr, ct}
+	}
+	// responder learns the ratchet key of the initiator
+	bob, err := newRatchetResponder(sk, out[2].hdr.DH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{2, 0, 1} {
+		plain, err := bob.decrypt(out[i].hdr, out[i].ct, ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plain) != fmt.Sprintf("msg %d", i) {
+			t.Fatal("plaintext mismatch")
+		}
+	}
+	// replay fails
+	if _, err = bob.decrypt(out[1].hdr, out[1].ct, ad); err == nil {
+		t.Fatal("replayed message accepted")
+	}
+	// Bob replies (DH ratchet step)
+	hdr, ct, err := bob.encrypt([]byte("reply"), ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := alice.decrypt(hdr, ct, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != "reply" {
+		t.Fatal("reply mismatch")
+	}
+	// tampered message does not change state
+	hdr, ct, _ = alice.encrypt([]byte("next"), ad)
+	ct[len(ct)-1] ^= 1
+	if _, err = bob.decrypt(hdr, ct, ad); err == nil {
+		t.Fatal("tampered message accepted")
+	}
+	ct[len(ct)-1] ^= 1
+	if _, err = bob.decrypt(hdr, ct, ad); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRatchetSkipped(t *testing.T) {
+	sk := make([]byte, 32)
+	alice, err := newRatchetInitiator(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad := []byte("ad")
+
+	// invalid ratchet keys of the peer are rejected
+	identity := make([]byte, 32)
+	identity[0] = 1
+	for _, key := range [][]byte{nil, make([]byte, 31), identity} {
+		if _, err = newRatchetResponder(sk, key); err != ErrRatchetKey {
+			t.Fatalf("invalid ratchet key accepted: %v", err)
+		}
+	}
+	// skipped keys are evicted (oldest first)
+	max := RatchetMaxSkip
+	RatchetMaxSkip = 10
+	defer func() { RatchetMaxSkip = max }()
+	first, firstCt, _ := alice.encrypt([]byte("first"), ad)
+	var bob *ratchet
+	for i := 0; i < 5; i++ {
+		for j := 1; j < RatchetMaxSkip; j++ {
+			if _, _, err = alice.encrypt([]byte("skipped"), ad); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hdr, ct, _ := alice.encrypt([]byte("last"), ad)
+		if bob == nil {
+			if bob, err = newRatchetResponder(sk, hdr.DH); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err = bob.decrypt(hdr, ct, ad); err != nil {
+			t.Fatal(err)
+		}
+		if len(bob.skipped) > 2*RatchetMaxSkip || len(bob.order) != len(bob.skipped) {
+			t.Fatalf("skipped keys not evicted: %d", len(bob.skipped))
+		}
+	}
+	if _, err = bob.decrypt(first, firstCt, ad); err == nil {
+		t.Fatal("evicted message key used")
+	}
+}
+
+func TestSessionService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// create two nodes on a local transport
+	trans := NewLocalTransport()
+	nodes := make([]*Node, 2)
+	srvs := make([]*SessionService, 2)
+	recv := make(chan []byte, 4)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, fmt.Sprintf("node%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		srvs[i] = NewSessionService()
+		srvs[i].Deliver = func(sender *Address, payload []byte) {
+			recv <- payload
+		}
+		n.AddService(srvs[i])
+		nodes[i] = n
+	}
+	for i, n := range nodes {
+		peer := nodes[1-i]
+		if err := n.Learn(peer.Address(), fmt.Sprintf("node%d", 1-i)); err != nil {
+			t.Fatal(err)
+		}
+		go n.Run(ctx)
+	}
+	// exchange messages in both directions
+	expect := func(data []byte) {
+		select {
+		case in := <-recv:
+			if !bytes.Equal(in, data) {
+				t.Fatal("payload mismatch")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("payload not delivered")
+		}
+	}
+	for i, data := range [][]byte{[]byte("hello"), []byte("world"), []byte("again")} {
+		if err := srvs[i%2].SendPayload(ctx, nodes[1-i%2].Address(), data); err != nil {
+			t.Fatal(err)
+		}
+		expect(data)
+	}
+	// all messages acknowledged
+	for i := 0; i < 50; i++ {
+		if srvs[0].Pending()+srvs[1].Pending() == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("messages not acknowledged")
+}
+
+func TestSessionLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	max := SessionMax
+	SessionMax = 2
+	defer func() { SessionMax = max }()
+
+	// hub (node0) with three peers on a virtual clock
+	clk := gtime.NewFakeClock(time.Now())
+	trans := NewLocalTransport()
+	nodes := make([]*Node, 4)
+	srvs := make([]*SessionService, 4)
+	recv := make(chan []byte, 4)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.SetClock(clk)
+		if err = trans.Register(ctx, n, fmt.Sprintf("node%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		srvs[i] = NewSessionService()
+		n.AddService(srvs[i])
+		nodes[i] = n
+	}
+	srvs[0].Deliver = func(sender *Address, payload []byte) {
+		recv <- payload
+	}
+	for i, n := range nodes[1:] {
+		if err := n.Learn(nodes[0].Address(), "node0"); err != nil {
+			t.Fatal(err)
+		}
+		if err := nodes[0].Learn(n.Address(), fmt.Sprintf("node%d", i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range nodes {
+		go n.Run(ctx)
+	}
+	// each peer starts a session with the hub
+	for i := 1; i < len(nodes); i++ {
+		clk.Advance(time.Minute)
+		data := []byte(fmt.Sprintf("msg %d", i))
+		if err := srvs[i].SendPayload(ctx, nodes[0].Address(), data); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case in := <-recv:
+			if !bytes.Equal(in, data) {
+				t.Fatal("payload mismatch")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("payload not delivered")
+		}
+	}
+	// the least recently used session is dropped
+	hub := srvs[0]
+	hub.lock.Lock()
+	if len(hub.sessions) != SessionMax {
+		t.Fatalf("session limit not enforced: %d", len(hub.sessions))
+	}
+	if _, ok := hub.current[nodes[1].Address().String()]; ok {
+		t.Fatal("least recently used session kept")
+	}
+	// idle sessions expire
+	hub.expire(clk.Now().Add(SessionMaxIdle + time.Second))
+	n := len(hub.sessions) + len(hub.current)
+	hub.lock.Unlock()
+	if n != 0 {
+		t.Fatal("idle sessions not expired")
+	}
+}
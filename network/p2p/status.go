@@ -0,0 +1,77 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"github.com/bfix/gospel/network"
+)
+
+//----------------------------------------------------------------------
+// Node status for status endpoints
+//----------------------------------------------------------------------
+
+// NodeStatus is a metrics source reporting the routing table and the
+// open peer connections of a node; it can be registered with a
+// network.StatusServer.
+type NodeStatus struct {
+	node *Node
+}
+
+// NewNodeStatus creates a metrics source for a node.
+func NewNodeStatus(n *Node) *NodeStatus {
+	return &NodeStatus{node: n}
+}
+
+// Metrics returns peer counts, bucket fill levels and connection
+// round-trip times.
+func (s *NodeStatus) Metrics() (list []*network.Metric) {
+	gauge := func(name, help string, val float64, labels map[string]string) {
+		list = append(list, &network.Metric{
+			Name:   name,
+			Help:   help,
+			Type:   network.MetricGauge,
+			Labels: labels,
+			Value:  val,
+		})
+	}
+	if bl := s.node.Routing(); bl != nil {
+		m := bl.Metrics()
+		gauge("p2p_peers", "Number of peers in routing table", float64(m.Peers), nil)
+		gauge("p2p_peers_cached", "Number of peers in replacement caches", float64(m.Cached), nil)
+		gauge("p2p_buckets_full", "Number of full buckets", float64(m.Full), nil)
+		gauge("p2p_buckets_empty", "Number of empty buckets", float64(m.Empty), nil)
+	}
+	// connection-oriented transports report open connections
+	if c, ok := s.node.conn.(interface{ Metrics() []*ConnMetrics }); ok {
+		conns := c.Metrics()
+		gauge("p2p_connections", "Number of open peer connections", float64(len(conns)), nil)
+		for _, cm := range conns {
+			gauge("p2p_connection_rtt_seconds", "Round-trip time of peer connection", cm.RTT.Seconds(), map[string]string{"peer": cm.Peer})
+		}
+	}
+	// metered transports report their traffic
+	if c, ok := s.node.conn.(interface{ Meter() *network.Meter }); ok {
+		if m := c.Meter(); m != nil {
+			list = append(list, m.Metrics()...)
+		}
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/network"
+)
+
+func TestNodeStatus(t *testing.T) {
+	_, prv := ed25519.NewKeypair()
+	n, err := NewNode(prv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := n.Routing()
+	for i := 0; i < 3; i++ {
+		bl.Add(newTestAddress())
+	}
+	out := network.FormatMetrics(NewNodeStatus(n).Metrics())
+	if !strings.Contains(out, "p2p_peers 3\n") || !strings.Contains(out, "# TYPE p2p_buckets_empty gauge") {
+		t.Fatalf("wrong metrics:\n%s", out)
+	}
+	// no connection metrics without connection-oriented transport
+	if strings.Contains(out, "p2p_connections") {
+		t.Fatalf("unexpected connection metrics:\n%s", out)
+	}
+}
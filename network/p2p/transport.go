@@ -24,6 +24,9 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync"
+
+	gerr "github.com/bfix/gospel/errors"
 )
 
 // Internal constants
@@ -46,6 +49,9 @@ var (
 	ErrTransUnknownReceiver = errors.New("unknown receiver")
 	ErrTransAddressInvalid  = errors.New("invalid network address")
 	ErrTransInvalidConfig   = errors.New("invalid configuration type")
+	ErrTransQueueFull       = errors.New("send queue full")
+	ErrTransNotReady        = errors.New("transport not ready")
+	ErrTransMsgSize         = errors.New("message exceeds max. size")
 )
 
 //======================================================================
@@ -77,6 +83,17 @@ type Connector interface {
 	Epoch(int)
 }
 
+// Stopper is implemented by connectors that hold resources (listeners,
+// hidden services, open connections) and support a graceful shutdown.
+type Stopper interface {
+	// Stop accepting incoming connections and packets.
+	Stop() error
+
+	// Close open connections and release all resources; the context
+	// limits the time to wait for pending operations.
+	Close(ctx context.Context) error
+}
+
 // TransportConfig is used for transport-specific configurations
 type TransportConfig interface {
 	TransportType() string // return type of associated transport
@@ -94,3 +111,46 @@ type Transport interface {
 	// Close transport
 	Close() error
 }
+
+//----------------------------------------------------------------------
+// Receive buffers
+//----------------------------------------------------------------------
+
+// checkMsgSize validates a configured max. message size of a transport
+// and returns the effective size (0: default size).
+func checkMsgSize(size int) (int, error) {
+	if size == 0 {
+		return MaxMsgSize, nil
+	}
+	if size <= PacketOverhead || size > MaxMsgSize {
+		return 0, gerr.New(ErrTransInvalidConfig, "max. message size %d", size)
+	}
+	return size, nil
+}
+
+// bufferPool provides receive buffers of a fixed size, so that
+// concurrent readers don't share (or repeatedly allocate) buffers.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// newBufferPool creates a pool for buffers of given size.
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+// get a buffer from the pool.
+func (p *bufferPool) get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// put a buffer back into the pool.
+func (p *bufferPool) put(buf *[]byte) {
+	p.pool.Put(buf)
+}
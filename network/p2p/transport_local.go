@@ -99,6 +99,10 @@ func (c *LocalConnector) Send(ctx context.Context, dst net.Addr, pkt *Packet) er
 		if err != nil {
 			return err
 		}
+		// check if sender is accepted by receiver
+		if err = node.Admit(msg.Header().Sender, nil); err != nil {
+			return err
+		}
 		go func() {
 			node.Handle() <- msg
 		}()
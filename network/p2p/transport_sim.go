@@ -0,0 +1,453 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	gtime "github.com/bfix/gospel/time"
+)
+
+//======================================================================
+// SimTransport: in-process transport for simulations and tests.
+// Many nodes communicate through a simulated network that injects
+// latency, packet loss, reordering and network partitions. Packets are
+// either delivered in real time or driven by a virtual clock that is
+// advanced explicitly by the simulation.
+//======================================================================
+
+//----------------------------------------------------------------------
+// Virtual clock
+//----------------------------------------------------------------------
+
+// SimClock is a virtual clock: scheduled events are executed when the
+// clock is advanced past their execution time. Nodes in a simulation
+// can use the clock for their time-dependent behavior (see Node.SetClock).
+type SimClock struct {
+	*gtime.FakeClock
+}
+
+// NewSimClock creates a virtual clock starting at given time.
+func NewSimClock(start time.Time) *SimClock {
+	return &SimClock{
+		FakeClock: gtime.NewFakeClock(start),
+	}
+}
+
+// Schedule an action after given delay (relative to virtual time).
+func (c *SimClock) Schedule(delay time.Duration, f func()) {
+	c.AfterFunc(delay, f)
+}
+
+//----------------------------------------------------------------------
+// Latency models
+//----------------------------------------------------------------------
+
+// LatencyFunc returns the latency for a packet (using the random
+// generator of the simulation).
+type LatencyFunc func(rnd *rand.Rand) time.Duration
+
+// FixedLatency returns a constant latency.
+func FixedLatency(d time.Duration) LatencyFunc {
+	return func(*rand.Rand) time.Duration {
+		return d
+	}
+}
+
+// UniformLatency returns latencies uniformly distributed in [min,max).
+func UniformLatency(min, max time.Duration) LatencyFunc {
+	return func(rnd *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(rnd.Int63n(int64(max-min)))
+	}
+}
+
+// NormalLatency returns normally distributed latencies (cut off at 0).
+func NormalLatency(mean, dev time.Duration) LatencyFunc {
+	return func(rnd *rand.Rand) time.Duration {
+		d := time.Duration(rnd.NormFloat64()*float64(dev)) + mean
+		if d < 0 {
+			d = 0
+		}
+		return d
+	}
+}
+
+//----------------------------------------------------------------------
+// Connector
+//----------------------------------------------------------------------
+
+// SimConnector is used in SimTransport
+type SimConnector struct {
+	trans *SimTransport
+	node  *Node
+
+	cache map[string]string // known endpoints of peers
+	addrs []*Address        // known peers (for sampling)
+	lock  sync.Mutex
+}
+
+// NewAddress returns a new network address for the transport based on an
+// endpoint specification.
+func (c *SimConnector) NewAddress(endp string) (net.Addr, error) {
+	return NewSimAddress(endp), nil
+}
+
+// Sample returns a random collection of node/network address pairs this node
+// has learned during up-time.
+func (c *SimConnector) Sample(num int, skip *Address) []*Address {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// collect candidates
+	list := make([]*Address, 0, len(c.addrs))
+	for _, addr := range c.addrs {
+		if !addr.Equals(skip) {
+			list = append(list, addr)
+		}
+	}
+	if num > MaxSample {
+		num = MaxSample
+	}
+	if num > len(list) {
+		return nil
+	}
+	c.trans.shuffle(len(list), func(i, j int) {
+		list[i], list[j] = list[j], list[i]
+	})
+	return list[:num]
+}
+
+// Send a packet through the simulated network.
+func (c *SimConnector) Send(ctx context.Context, dst net.Addr, pkt *Packet) error {
+	if _, ok := dst.(*SimAddress); !ok {
+		return ErrTransAddressInvalid
+	}
+	return c.trans.send(c.node, dst.String(), pkt)
+}
+
+// Listen to messages from "outside" not necessary in simulated transport
+func (c *SimConnector) Listen(ctx context.Context, ch chan Message) {
+}
+
+// Learn network address of node address
+func (c *SimConnector) Learn(addr *Address, endp net.Addr) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := addr.String()
+	if _, ok := c.cache[key]; !ok {
+		c.addrs = append(c.addrs, addr)
+	}
+	c.cache[key] = endp.String()
+	return nil
+}
+
+// Resolve node address into a network address
+func (c *SimConnector) Resolve(addr *Address) net.Addr {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	netw, ok := c.cache[addr.String()]
+	if !ok {
+		return nil
+	}
+	return NewSimAddress(netw)
+}
+
+// Epoch step: perform periodic tasks
+func (c *SimConnector) Epoch(epoch int) {
+}
+
+//----------------------------------------------------------------------
+// Transport implementation
+//----------------------------------------------------------------------
+
+// SimAddress is the network address (net.Addr) of a simulated node.
+type SimAddress struct {
+	LocalAddress
+}
+
+// NewSimAddress creates a new address with given name
+func NewSimAddress(name string) *SimAddress {
+	return &SimAddress{
+		LocalAddress: LocalAddress{
+			Name: name,
+		},
+	}
+}
+
+// Network returns the network label of the address
+func (a *SimAddress) Network() string {
+	return "sim"
+}
+
+// SimStats are counters for simulated packets
+type SimStats struct {
+	Sent      int // number of packets sent
+	Delivered int // number of packets delivered
+	Lost      int // number of packets dropped (loss)
+	Blocked   int // number of packets dropped (partition)
+	Reordered int // number of reordered packets
+}
+
+// simQueue delivers messages to a node in the order they arrive (in
+// real or virtual time).
+type simQueue struct {
+	msgs   []Message     // queued messages
+	signal chan struct{} // new messages available
+	lock   sync.Mutex    // lock for concurrent access
+}
+
+// push a message to the end of the queue.
+func (q *simQueue) push(msg Message) {
+	q.lock.Lock()
+	q.msgs = append(q.msgs, msg)
+	q.lock.Unlock()
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// run the queue: hand messages to the node in order.
+func (q *simQueue) run(ctx context.Context, ch chan Message) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.signal:
+		}
+		for {
+			q.lock.Lock()
+			if len(q.msgs) == 0 {
+				q.lock.Unlock()
+				break
+			}
+			msg := q.msgs[0]
+			q.msgs[0] = nil
+			q.msgs = q.msgs[1:]
+			q.lock.Unlock()
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// SimTransport simulates a network of local nodes.
+type SimTransport struct {
+	clock   *SimClock           // virtual clock (nil for real time)
+	rnd     *rand.Rand          // random generator (seeded)
+	latency LatencyFunc         // latency model
+	loss    float64             // probability of packet loss
+	reorder float64             // probability of reordering
+	delay   time.Duration       // extra delay for reordered packets
+	groups  map[string]int      // partition group of endpoints
+	nodes   map[string]*Node    // registered nodes (by endpoint)
+	queues  map[*Node]*simQueue // delivery queues of nodes
+	addrs   map[string]bool     // registered node addresses
+	stats   SimStats            // packet statistics
+	lock    sync.Mutex          // lock for concurrent access
+}
+
+// NewSimTransport creates a simulated network. The seed makes simulation
+// runs reproducible; if a virtual clock is given, packets are delivered
+// only when the clock is advanced (otherwise in real time).
+func NewSimTransport(seed int64, clock *SimClock) *SimTransport {
+	return &SimTransport{
+		clock:   clock,
+		rnd:     rand.New(rand.NewSource(seed)), //nolint:gosec // simulation only
+		latency: FixedLatency(0),
+		groups:  make(map[string]int),
+		nodes:   make(map[string]*Node),
+		queues:  make(map[*Node]*simQueue),
+		addrs:   make(map[string]bool),
+	}
+}
+
+// SetLatency sets the latency model for packets.
+func (t *SimTransport) SetLatency(f LatencyFunc) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.latency = f
+}
+
+// SetLoss sets the probability (0..1) of packet loss.
+func (t *SimTransport) SetLoss(p float64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.loss = p
+}
+
+// SetReorder sets the probability (0..1) that a packet is held back by
+// an extra delay (so later packets overtake it).
+func (t *SimTransport) SetReorder(p float64, delay time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.reorder = p
+	t.delay = delay
+}
+
+// Partition the network: endpoints in different groups can't reach each
+// other. Endpoints not listed in any group form a group of their own.
+func (t *SimTransport) Partition(groups ...[]string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.groups = make(map[string]int)
+	for i, grp := range groups {
+		for _, endp := range grp {
+			t.groups[endp] = i + 1
+		}
+	}
+}
+
+// Heal all network partitions.
+func (t *SimTransport) Heal() {
+	t.Partition()
+}
+
+// Stats returns the current packet statistics.
+func (t *SimTransport) Stats() SimStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.stats
+}
+
+// Open transport based on configuration
+func (t *SimTransport) Open(cfg TransportConfig) error {
+	// nothing to setup...
+	return nil
+}
+
+// Register a node for participation in the simulated network.
+func (t *SimTransport) Register(ctx context.Context, n *Node, endp string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// check for already registered address or endpoint
+	addr := n.Address().String()
+	if _, ok := t.nodes[endp]; ok || t.addrs[addr] {
+		return ErrTransAddressDup
+	}
+	t.nodes[endp] = n
+	t.addrs[addr] = true
+	q := &simQueue{
+		signal: make(chan struct{}, 1),
+	}
+	t.queues[n] = q
+	go q.run(ctx, n.Handle())
+
+	// create a connector for node
+	conn := &SimConnector{
+		trans: t,
+		node:  n,
+		cache: make(map[string]string),
+		addrs: make([]*Address, 0),
+	}
+	return n.Connect(conn)
+}
+
+// Close transport
+func (t *SimTransport) Close() error {
+	return nil
+}
+
+// send packet from node to endpoint (with simulated network effects)
+func (t *SimTransport) send(from *Node, endp string, pkt *Packet) error {
+	t.lock.Lock()
+	t.stats.Sent++
+
+	// find receiver and sender endpoint
+	rcv, ok := t.nodes[endp]
+	if !ok {
+		t.lock.Unlock()
+		return ErrTransUnknownReceiver
+	}
+	src := ""
+	for e, n := range t.nodes {
+		if n == from {
+			src = e
+			break
+		}
+	}
+	// check for partition (silent drop)
+	if t.groups[src] != t.groups[endp] {
+		t.stats.Blocked++
+		t.lock.Unlock()
+		return nil
+	}
+	// check for loss (silent drop)
+	if t.loss > 0 && t.rnd.Float64() < t.loss {
+		t.stats.Lost++
+		t.lock.Unlock()
+		return nil
+	}
+	// compute delay
+	delay := t.latency(t.rnd)
+	if t.reorder > 0 && t.rnd.Float64() < t.reorder {
+		delay += t.delay
+		t.stats.Reordered++
+	}
+	clock := t.clock
+	queue := t.queues[rcv]
+	t.lock.Unlock()
+
+	// schedule delivery
+	deliver := func() {
+		msg, err := rcv.Unwrap(pkt)
+		if err != nil {
+			return
+		}
+		// check if sender is accepted by receiver
+		if rcv.Admit(msg.Header().Sender, nil) != nil {
+			return
+		}
+		t.lock.Lock()
+		t.stats.Delivered++
+		t.lock.Unlock()
+		queue.push(msg)
+	}
+	switch {
+	case clock != nil:
+		clock.Schedule(delay, deliver)
+	case delay > 0:
+		time.AfterFunc(delay, deliver)
+	default:
+		deliver()
+	}
+	return nil
+}
+
+// shuffle with the simulation random generator
+func (t *SimTransport) shuffle(n int, swap func(i, j int)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.rnd.Shuffle(n, swap)
+}
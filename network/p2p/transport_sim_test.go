@@ -0,0 +1,170 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+)
+
+func newSimNetwork(ctx context.Context, t *testing.T, trans *SimTransport, num int) ([]*Node, []string) {
+	nodes := make([]*Node, num)
+	endps := make([]string, num)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		endps[i] = fmt.Sprintf("sim%d", i)
+		if err = trans.Register(ctx, n, endps[i]); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		go n.Run(ctx)
+	}
+	for i, n := range nodes {
+		for j, peer := range nodes {
+			if i != j {
+				if err := n.Learn(peer.Address(), endps[j]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	return nodes, endps
+}
+
+func TestSimTransportClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := NewSimClock(time.Unix(0, 0))
+	trans := NewSimTransport(4711, clock)
+	trans.SetLatency(UniformLatency(50*time.Millisecond, 150*time.Millisecond))
+	nodes, _ := newSimNetwork(ctx, t, trans, 2)
+
+	// ping is answered only while the clock advances
+	done := make(chan error)
+	go func() {
+		done <- nodes[0].PingService().Ping(ctx, nodes[1].Address(), 5*time.Second, 0)
+	}()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st := trans.Stats(); st.Delivered != 2 {
+				t.Fatalf("wrong stats: %v", st)
+			}
+			if clock.Now().Before(time.Unix(0, 0).Add(100 * time.Millisecond)) {
+				t.Fatal("latency not simulated")
+			}
+			return
+		case <-time.After(time.Millisecond):
+			clock.Advance(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestSimTransportFaults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trans := NewSimTransport(4711, nil)
+	nodes, endps := newSimNetwork(ctx, t, trans, 3)
+	ping := func(i, j int) error {
+		return nodes[i].PingService().Ping(ctx, nodes[j].Address(), 200*time.Millisecond, 0)
+	}
+	if err := ping(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	// partition
+	trans.Partition(endps[:2], endps[2:])
+	if err := ping(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ping(0, 2); err != ErrNodeTimeout {
+		t.Fatalf("ping across partition: %v", err)
+	}
+	trans.Heal()
+	if err := ping(0, 2); err != nil {
+		t.Fatal(err)
+	}
+	// packet loss
+	trans.SetLoss(1)
+	if err := ping(1, 2); err != ErrNodeTimeout {
+		t.Fatalf("ping with total loss: %v", err)
+	}
+	if st := trans.Stats(); st.Blocked != 1 || st.Lost != 1 {
+		t.Fatalf("wrong stats: %v", st)
+	}
+}
+
+func TestSimTransportOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// nodes are not running: received messages stay in the node queue
+	trans := NewSimTransport(4711, nil)
+	nodes := make([]*Node, 2)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		n, err := NewNode(prv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, n, fmt.Sprintf("sim%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+	}
+	// packets without delay are delivered in the order they are sent
+	num := 100
+	for i := 0; i < num; i++ {
+		msg, _ := NewPingMsg().(*PingMsg)
+		msg.TxID = uint64(i)
+		msg.Sender = nodes[0].Address()
+		msg.Receiver = nodes[1].Address()
+		pkt, err := nodes[0].Wrap(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.send(nodes[0], "sim1", pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < num; i++ {
+		select {
+		case msg := <-nodes[1].Handle():
+			if msg.Header().TxID != uint64(i) {
+				t.Fatalf("message #%d delivered as #%d", msg.Header().TxID, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not delivered")
+		}
+	}
+}
@@ -22,19 +22,24 @@ package p2p
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/bfix/gospel/concurrent"
 	"github.com/bfix/gospel/data"
 	"github.com/bfix/gospel/logger"
 	"github.com/bfix/gospel/network"
 	"github.com/bfix/gospel/network/tor"
+	gtime "github.com/bfix/gospel/time"
 )
 
 //======================================================================
@@ -115,6 +120,40 @@ type TorTransportConfig struct {
 	// PeerTTL defines (in seconds) how long connections are kept-alive
 	// after a message has been send.
 	PeerTTL int `json:"peerTTL"`
+	// Heartbeat defines (in seconds) the interval between heartbeats on
+	// open connections (0: default interval, negative: no heartbeats).
+	Heartbeat int `json:"heartbeat"`
+	// HeartbeatMisses is the number of unanswered heartbeats after which
+	// a connection is considered dead and re-dialed (0: default).
+	HeartbeatMisses int `json:"heartbeatMisses"`
+	// MaxDials limits the number of concurrent outgoing connection
+	// attempts (0: default).
+	MaxDials int `json:"maxDials"`
+	// DialQueue is the max. number of packets queued for a peer while
+	// a connection to it is being established (0: default).
+	DialQueue int `json:"dialQueue"`
+	// Bridges are bridge lines ("[transport] host:port [fingerprint]
+	// [key=value...]") used to reach the Tor network (optional).
+	Bridges []string `json:"bridges"`
+	// Transports are pluggable transport plugins used by bridges like
+	// "obfs4 exec /usr/bin/obfs4proxy" (optional).
+	Transports []string `json:"transports"`
+	// Bootstrap defines (in seconds) how long a dial waits for Tor to
+	// complete its bootstrap (0: default).
+	Bootstrap int `json:"bootstrap"`
+	// Metered enables traffic accounting on peer connections (reported
+	// as node status metrics).
+	Metered bool `json:"metered"`
+	// MaxUpload and MaxDownload limit the transfer rates (in bytes per
+	// second) over all peer connections (0: unlimited).
+	MaxUpload   int `json:"maxUpload"`
+	MaxDownload int `json:"maxDownload"`
+	// UploadTarget limits the data sent to peers (in MiB per 24 hours;
+	// 0: unlimited).
+	UploadTarget int `json:"uploadTarget"`
+	// MaxMsgSize limits the size of packets sent and received over peer
+	// connections (0: default MaxMsgSize).
+	MaxMsgSize int `json:"maxMsgSize"`
 }
 
 // TransportType returns the kind of transport implementation targeted
@@ -129,14 +168,49 @@ func (c *TorTransportConfig) TransportType() string {
 
 // TorConnection is an open TCP connection to a hidden servoice of a peer
 type TorConnection struct {
-	conn net.Conn      // hidden service connection
-	last time.Time     // last used
-	ttl  time.Duration // time-to-live after last send
+	conn  net.Conn      // hidden service connection
+	last  time.Time     // last used
+	ttl   time.Duration // time-to-live after last send
+	clock gtime.Clock   // clock for expiration
+	hb    *Heartbeat    // heartbeat monitor (or nil)
+	wlock sync.Mutex    // serialize writes (packets and pings)
 }
 
 // Expired connection?
 func (c *TorConnection) Expired() bool {
-	return time.Now().After(c.last.Add(c.ttl))
+	return c.clock.Now().After(c.last.Add(c.ttl))
+}
+
+// write a frame to the connection
+func (c *TorConnection) write(buf []byte) (err error) {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	_, err = c.conn.Write(buf)
+	return
+}
+
+// Tor transport defaults
+const (
+	TorMaxDials  = 4                // concurrent outgoing connection attempts
+	TorDialQueue = 32               // packets queued per peer during connect
+	TorBootstrap = 5 * time.Minute  // max. wait for bootstrap when dialing
+	TorShutdown  = 10 * time.Second // max. wait for connectors on close
+)
+
+// TorRedialRetry is the retry policy for re-establishing connections to
+// peers after a connection died.
+var TorRedialRetry = &concurrent.RetryPolicy{
+	MaxAttempts: 4,
+	Initial:     5 * time.Second,
+	Max:         time.Minute,
+	Factor:      2,
+	Jitter:      0.2,
+}
+
+// torDial is the state of a pending connection attempt to a peer
+type torDial struct {
+	queue [][]byte  // packets to be sent after connect
+	last  time.Time // last use of a previous connection (re-dial)
 }
 
 // TorConnector is a stub between a node and the Tor-based transport
@@ -148,16 +222,26 @@ type TorConnector struct {
 	port    int           // hidden service listener port
 	hshost  string        // host running the node hodden service
 	conn    net.Listener  // hidden service listener
-	running bool          // connector running?
+	hs      *tor.Onion    // running hidden service
+	lstLock sync.Mutex    // guard listener and hidden service
+	running atomic.Bool   // connector running?
 
-	// map of open connections
+	// map of open connections and pending connection attempts
 	openList map[string]*TorConnection
+	dialList map[string]*torDial
 	openLock sync.Mutex
 	ttlConn  int
 
+	// inbound connections and their readers
+	inbound map[net.Conn]struct{}
+	readers sync.WaitGroup
+	closed  bool          // connector closed? (guarded by openLock)
+	stop    chan struct{} // closed when the connector is closed
+
 	// list of last-seen peer addresses
-	sample []*Address
-	pos    int
+	sample     []*Address
+	pos        int
+	sampleLock sync.Mutex
 }
 
 // NewTorConnector creates a connector on transport for a given node
@@ -173,9 +257,11 @@ func NewTorConnector(trans *TorTransport, node *Node, port int) (*TorConnector,
 		port:     port,
 		hshost:   trans.host,
 		conn:     nil,
-		running:  false,
 		openList: make(map[string]*TorConnection),
+		dialList: make(map[string]*torDial),
 		ttlConn:  trans.peerTTL,
+		inbound:  make(map[net.Conn]struct{}),
+		stop:     make(chan struct{}),
 		sample:   make([]*Address, SampleCache),
 		pos:      0,
 	}, nil
@@ -213,6 +299,9 @@ func (c *TorConnector) Sample(num int, skip *Address) []*Address {
 	if num > MaxSample {
 		num = MaxSample
 	}
+	c.sampleLock.Lock()
+	defer c.sampleLock.Unlock()
+
 	// check if request can be satisfied
 	if num > c.pos-2 {
 		// too few entries
@@ -241,45 +330,232 @@ loop:
 	return res
 }
 
-// Send message from node to the UDP network.
+// Send message from node to the Tor network. Send does not block while
+// a connection to the destination is established: the packet is queued
+// and sent after the connection is up.
 func (c *TorConnector) Send(ctx context.Context, dst net.Addr, pkt *Packet) (err error) {
+	var buf []byte
+	if buf, err = data.Marshal(pkt); err != nil {
+		return
+	}
+	if len(buf) > c.trans.msgSize {
+		return ErrTransMsgSize
+	}
+	onion := dst.String()
 	c.openLock.Lock()
-	defer c.openLock.Unlock()
+	if c.closed {
+		c.openLock.Unlock()
+		return ErrTransClosed
+	}
 
 	// check if we have an open connection to the destination
-	var conn net.Conn
-	tc, ok := c.openList[dst.String()]
-	if ok {
+	if tc, ok := c.openList[onion]; ok {
 		// re-use existing connection
-		conn = tc.conn
-		tc.last = time.Now()
-	} else {
-		// connect to peer
-		endp := fmt.Sprintf("%s:14235", dst.String())
-		logger.Printf(logger.DBG, "[%.8s] Connecting to hidden service %s", c.node.Address(), endp)
-		if conn, err = c.trans.ctrl.DialTimeout("tcp", endp, time.Minute); err != nil {
-			return err
+		tc.last = tc.clock.Now()
+		c.openLock.Unlock()
+		return tc.write(buf)
+	}
+	defer c.openLock.Unlock()
+
+	// queue packet if a connection attempt is pending
+	if d, ok := c.dialList[onion]; ok {
+		if len(d.queue) >= c.trans.dialQueue {
+			return ErrTransQueueFull
 		}
-		c.openList[dst.String()] = &TorConnection{
-			conn: conn,
-			last: time.Now(),
-			ttl:  time.Duration(c.ttlConn) * time.Second,
+		d.queue = append(d.queue, buf)
+		return nil
+	}
+	// start new connection attempt
+	c.dialList[onion] = &torDial{
+		queue: [][]byte{buf},
+	}
+	go c.connect(onion)
+	return nil
+}
+
+// connect to a peer with a pending connection attempt. The number of
+// concurrent attempts is limited; queued packets are sent (in order)
+// after the connection is established. Re-dials are retried according
+// to TorRedialRetry.
+func (c *TorConnector) connect(onion string) {
+	// re-dials of dead connections are retried with backoff
+	policy := &concurrent.RetryPolicy{MaxAttempts: 1}
+	c.openLock.Lock()
+	if !c.dialList[onion].last.IsZero() {
+		p := *TorRedialRetry
+		p.Clock = c.node.Clock()
+		policy = &p
+	}
+	c.openLock.Unlock()
+	var tc *TorConnection
+	err := concurrent.Retry(context.Background(), policy, func(context.Context) (err error) {
+		c.trans.dials <- struct{}{}
+		tc, err = c.dial(onion)
+		<-c.trans.dials
+		return
+	})
+
+	c.openLock.Lock()
+	d := c.dialList[onion]
+	delete(c.dialList, onion)
+	if c.closed {
+		// connector closed while dialing
+		c.openLock.Unlock()
+		if err == nil {
+			tc.conn.Close()
 		}
+		return
 	}
-	// send packet
-	var buf []byte
-	if buf, err = data.Marshal(pkt); err != nil {
+	if err != nil {
+		c.openLock.Unlock()
+		logger.Printf(logger.WARN, "[%.8s] Connecting to %s failed (%d packets dropped): %s",
+			c.node.Address(), onion, len(d.queue), err.Error())
+		return
+	}
+	if !d.last.IsZero() {
+		tc.last = d.last
+	}
+	// send queued packets before other senders can use the connection
+	tc.wlock.Lock()
+	c.openList[onion] = tc
+	c.openLock.Unlock()
+	go c.monitor(onion, tc)
+	for _, buf := range d.queue {
+		if _, err = tc.conn.Write(buf); err != nil {
+			logger.Printf(logger.WARN, "[%.8s] Sending queued packet to %s failed: %s",
+				c.node.Address(), onion, err.Error())
+			break
+		}
+	}
+	tc.wlock.Unlock()
+}
+
+// dial the hidden service of a peer
+func (c *TorConnector) dial(onion string) (*TorConnection, error) {
+	endp := fmt.Sprintf("%s:14235", onion)
+	logger.Printf(logger.DBG, "[%.8s] Connecting to hidden service %s", c.node.Address(), endp)
+	conn, err := c.trans.dialService(endp)
+	if err != nil {
+		return nil, err
+	}
+	clk := c.node.Clock()
+	tc := &TorConnection{
+		conn:  conn,
+		last:  clk.Now(),
+		ttl:   time.Duration(c.ttlConn) * time.Second,
+		clock: clk,
+	}
+	if c.trans.hbInterval >= 0 {
+		tc.hb = NewHeartbeat(clk, c.trans.hbInterval, c.trans.hbMisses)
+	}
+	return tc, nil
+}
+
+// monitor the heartbeat of an open connection: pings are sent
+// periodically and answers are processed. Dead connections are closed
+// and re-dialed.
+func (c *TorConnector) monitor(onion string, tc *TorConnection) {
+	if tc.hb == nil {
 		return
 	}
-	_, err = conn.Write(buf)
+	// handle pongs from peer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, heartbeatFrameSize)
+		for {
+			kind, _, err := readFrame(tc.conn, buf)
+			if err != nil {
+				return
+			}
+			if kind == framePong {
+				tc.hb.Pong(binary.BigEndian.Uint64(buf[:8]))
+			}
+		}
+	}()
+	// send pings
+	tick := tc.clock.NewTicker(tc.hb.Interval())
+	defer tick.Stop()
+	for alive := true; alive; {
+		select {
+		case <-done:
+			// connection closed (by peer or on expiration)
+			alive = false
+		case <-tick.C():
+			frame, dead := tc.hb.Ping()
+			if !dead {
+				if err := tc.write(frame); err == nil {
+					continue
+				}
+			}
+			logger.Printf(logger.WARN, "[%.8s] Connection to %s is dead", c.node.Address(), onion)
+			alive = false
+		}
+	}
+	tc.conn.Close()
+	if c.drop(onion, tc) && !tc.Expired() {
+		go c.redial(onion, tc.last)
+	}
+}
+
+// drop a connection from the list of open connections (if it is still
+// listed); returns true if the connection was dropped.
+func (c *TorConnector) drop(onion string, tc *TorConnection) bool {
+	c.openLock.Lock()
+	defer c.openLock.Unlock()
+	if c.openList[onion] != tc {
+		return false
+	}
+	delete(c.openList, onion)
+	return true
+}
+
+// redial a peer after a connection died; the new connection keeps the
+// time of last use of the old connection (for expiration).
+func (c *TorConnector) redial(onion string, last time.Time) {
+	c.openLock.Lock()
+	defer c.openLock.Unlock()
+	if c.closed {
+		return
+	}
+	if _, ok := c.openList[onion]; ok {
+		// connection was re-established by a send meanwhile
+		return
+	}
+	if _, ok := c.dialList[onion]; ok {
+		return
+	}
+	c.dialList[onion] = &torDial{last: last}
+	go c.connect(onion)
+}
+
+// Meter returns the traffic meter of the transport (or nil).
+func (c *TorConnector) Meter() *network.Meter {
+	return c.trans.meter
+}
+
+// Metrics returns the state of all open connections to peers.
+func (c *TorConnector) Metrics() (list []*ConnMetrics) {
+	c.openLock.Lock()
+	defer c.openLock.Unlock()
+	for onion, tc := range c.openList {
+		m := &ConnMetrics{
+			Peer:     onion,
+			LastUsed: tc.last,
+		}
+		if tc.hb != nil {
+			m.RTT = tc.hb.RTT()
+			m.Missed = tc.hb.Missed()
+			m.LastPong = tc.hb.LastPong()
+		}
+		list = append(list, m)
+	}
 	return
 }
 
 // Listen on an UDP address/port for incoming packets
 func (c *TorConnector) Listen(ctx context.Context, ch chan Message) {
 
-	// allocate buffer space
-	buffer := make([]byte, MaxMsgSize)
 	nodeAddr := c.node.Address()
 
 	// assemble listener configuration
@@ -292,51 +568,100 @@ func (c *TorConnector) Listen(ctx context.Context, ch chan Message) {
 	}
 
 	// connector up and running
-	c.running = true
+	c.running.Store(true)
+	clk := c.node.Clock()
 	go func() {
-		var err error
-		for c.running {
-			// start listener
-			endp := fmt.Sprintf("0.0.0.0:%d", c.port)
-			if c.conn, err = cfg.Listen(ctx, "tcp", endp); err != nil {
-				logger.Printf(logger.ERROR, "[%.8s] ERROR: Failed to (re-)start TCP listener", nodeAddr)
-				logger.Printf(logger.ERROR, "       %s", err.Error())
-				// wait some time, then retry
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			if c.port == 0 {
-				c.port = c.conn.Addr().(*net.TCPAddr).Port
-				logger.Printf(logger.DBG, "[%.8s] Local onion port is %d", nodeAddr, c.port)
-			}
-			// start hidden service
-			hs, err := tor.NewOnion(c.node.prvKey)
+		// (re-)start listener and hidden service with backoff
+		policy := &concurrent.RetryPolicy{
+			Initial: 3 * time.Second,
+			Max:     time.Minute,
+			Factor:  2,
+			Jitter:  0.2,
+			Clock:   clk,
+			Retryable: func(error) bool {
+				return c.running.Load()
+			},
+		}
+		endp := ""
+		for c.running.Load() {
+			var hs *tor.Onion
+			err := concurrent.Retry(ctx, policy, func(ctx context.Context) (err error) {
+				c.lstLock.Lock()
+				defer c.lstLock.Unlock()
+				if !c.running.Load() {
+					// connector stopped
+					return concurrent.Permanent(ErrTransClosed)
+				}
+				// start listener
+				endp = net.JoinHostPort("", strconv.Itoa(c.port))
+				if c.conn, err = cfg.Listen(ctx, "tcp", endp); err != nil {
+					logger.Printf(logger.ERROR, "[%.8s] ERROR: Failed to (re-)start TCP listener", nodeAddr)
+					logger.Printf(logger.ERROR, "       %s", err.Error())
+					return
+				}
+				if c.port == 0 {
+					c.port = c.conn.Addr().(*net.TCPAddr).Port
+					logger.Printf(logger.DBG, "[%.8s] Local onion port is %d", nodeAddr, c.port)
+				}
+				// start hidden service
+				if hs, err = tor.NewOnion(c.node.prvKey); err != nil {
+					logger.Printf(logger.ERROR, "[%.8s] Failed to create Tor onion", nodeAddr)
+					logger.Printf(logger.ERROR, "       %s", err.Error())
+					_ = c.conn.Close()
+					return
+				}
+				hs.AddPort(14235, net.JoinHostPort(c.hshost, strconv.Itoa(c.port)))
+				if err = hs.Start(c.trans.ctrl); err != nil {
+					logger.Printf(logger.ERROR, "[%.8s] Failed to start Tor onion", nodeAddr)
+					logger.Printf(logger.ERROR, "       %s", err.Error())
+					_ = c.conn.Close()
+					return
+				}
+				c.hs = hs
+				return
+			})
 			if err != nil {
-				logger.Printf(logger.ERROR, "[%.8s] Failed to create Tor onion", nodeAddr)
-				logger.Printf(logger.ERROR, "       %s", err.Error())
-				// wait some time, then retry
-				time.Sleep(3 * time.Second)
-				continue
+				// connector stopped or context done
+				return
 			}
-			hs.AddPort(14235, fmt.Sprintf("%s:%d", c.hshost, c.port))
-			if err = hs.Start(c.trans.ctrl); err != nil {
-				logger.Printf(logger.ERROR, "[%.8s] Failed to start Tor onion", nodeAddr)
-				logger.Printf(logger.ERROR, "       %s", err.Error())
-				// wait some time, then retry
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			for c.running {
+			for c.running.Load() {
 				// wait for incoming data
 				conn, err := c.conn.Accept()
 				if err != nil {
+					if !c.running.Load() {
+						// connector stopped: hidden service is removed
+						// when the connector is closed.
+						return
+					}
 					logger.Printf(logger.ERROR, "[%.8s] Listener failed: %s", nodeAddr, err.Error())
 					break
 				}
+				// keep track of inbound connections
+				c.openLock.Lock()
+				if c.closed {
+					c.openLock.Unlock()
+					conn.Close()
+					continue
+				}
+				c.inbound[conn] = struct{}{}
+				c.readers.Add(1)
+				c.openLock.Unlock()
 				go func(cn net.Conn) {
+					defer func(raw net.Conn) {
+						c.openLock.Lock()
+						delete(c.inbound, raw)
+						c.openLock.Unlock()
+						c.readers.Done()
+					}(cn)
+					cn = c.trans.wrap(cn)
+					// each connection reads into its own (pooled) buffer
+					bufs := c.trans.bufs
+					bp := bufs.get()
+					defer bufs.put(bp)
+					buffer := *bp
 					for {
-						// read packet
-						n, err := cn.Read(buffer)
+						// read next frame
+						kind, n, err := readFrame(cn, buffer)
 						if err != nil {
 							if err != io.EOF {
 								logger.Printf(logger.ERROR, "[%.8s] Reading packet failed: %s", nodeAddr, err.Error())
@@ -346,6 +671,15 @@ func (c *TorConnector) Listen(ctx context.Context, ch chan Message) {
 							cn.Close()
 							return
 						}
+						if kind == framePing {
+							// answer heartbeat
+							pong := heartbeatFrame(framePong, binary.BigEndian.Uint64(buffer[:8]))
+							if _, err = cn.Write(pong); err != nil {
+								cn.Close()
+								return
+							}
+							continue
+						}
 						logger.Printf(logger.DBG, "[%.8s] Got %d packet bytes", nodeAddr, n)
 
 						// convert to message
@@ -361,30 +695,113 @@ func (c *TorConnector) Listen(ctx context.Context, ch chan Message) {
 							logger.Printf(logger.WARN, "[%.8s] Dropping packet from '%.8s'", nodeAddr, hdr.Receiver)
 							return
 						}
+						// check if sender is accepted (network address is
+						// derived from the P2P address)
+						if err = c.node.Admit(hdr.Sender, nil); err != nil {
+							logger.Printf(logger.WARN, "[%.8s] Rejecting packet: %s", nodeAddr, err.Error())
+							cn.Close()
+							return
+						}
 						// tell transport and node about the sender (in case it is unknown and not forwarded)
 						if hdr.Flags&MsgfRelay == 0 {
 							_ = c.Learn(hdr.Sender, nil)
 							_ = c.node.Learn(hdr.Sender, "")
 						}
 						// let the node handle the message
-						ch <- msg
+						select {
+						case ch <- msg:
+						case <-c.stop:
+							cn.Close()
+							return
+						}
 					}
 				}(conn)
 			}
 			// close the listener
 			logger.Printf(logger.WARN, "[%.8s] Closing listener and hidden service", nodeAddr)
+			c.lstLock.Lock()
 			_ = hs.Stop(c.trans.ctrl)
 			_ = c.conn.Close()
 			c.conn = nil
+			c.hs = nil
+			c.lstLock.Unlock()
 			// wait before retrying
-			time.Sleep(10 * time.Second)
+			clk.Sleep(gtime.Jitter(10*time.Second, 0.2))
 		}
 	}()
 }
 
+// Stop accepting incoming connections; the hidden service stays
+// published until the connector is closed.
+func (c *TorConnector) Stop() error {
+	c.lstLock.Lock()
+	defer c.lstLock.Unlock()
+	c.running.Store(false)
+	if c.conn != nil {
+		if err := c.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close the connector: the hidden service is removed (DEL_ONION), all
+// connections are closed and pending dials are dropped. Waits for the
+// readers of inbound connections to terminate (until the context is
+// done).
+func (c *TorConnector) Close(ctx context.Context) (err error) {
+	if err = c.Stop(); err != nil {
+		return
+	}
+
+	// remove hidden service
+	c.lstLock.Lock()
+	if c.hs != nil {
+		err = c.hs.Stop(c.trans.ctrl)
+		c.hs = nil
+	}
+	c.lstLock.Unlock()
+
+	// close all connections
+	c.openLock.Lock()
+	if c.closed {
+		c.openLock.Unlock()
+		return ErrTransClosed
+	}
+	c.closed = true
+	close(c.stop)
+	for _, tc := range c.openList {
+		tc.conn.Close()
+	}
+	for cn := range c.inbound {
+		cn.Close()
+	}
+	c.openList = make(map[string]*TorConnection)
+	c.dialList = make(map[string]*torDial)
+	c.openLock.Unlock()
+
+	// wait for readers
+	done := make(chan struct{})
+	go func() {
+		c.readers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return
+}
+
 // Learn network address of node address is obsolete if Tor transport
 // is used; the network address can be computed from the P2P address.
 func (c *TorConnector) Learn(addr *Address, endp net.Addr) error {
+	c.sampleLock.Lock()
+	defer c.sampleLock.Unlock()
+
 	// just keep a list of sampled addresses
 	c.sample[c.pos%SampleCache] = addr
 	c.pos++
@@ -417,13 +834,34 @@ type TorTransport struct {
 	// Tor service controller
 	ctrl *tor.Service
 	// nodes registered with transport
-	registry map[string]bool
+	registry map[string]*TorConnector
 	// transport initialized (opened)?
 	active bool
 	// host that runs hidden services
 	host string
 	// keep-alive time for peer connections
 	peerTTL int
+	// heartbeat interval and max. number of missed heartbeats
+	hbInterval time.Duration
+	hbMisses   int
+	// slots for concurrent connection attempts
+	dials chan struct{}
+	// max. number of queued packets per pending connection
+	dialQueue int
+	// dial a hidden service (for testing)
+	dial func(endp string) (net.Conn, error)
+	// closed when Tor is bootstrapped (nil: no wait)
+	ready chan struct{}
+	// max. wait for bootstrap when dialing
+	bootWait time.Duration
+	// stop waiting for bootstrap
+	cancel context.CancelFunc
+	// traffic accounting and throttling of peer connections (optional)
+	meter    *network.Meter
+	throttle *network.Throttle
+	// max. packet size and pool of receive buffers
+	msgSize int
+	bufs    *bufferPool
 }
 
 // NewTorTransport instantiates a new Tor transport layer where the
@@ -431,14 +869,59 @@ type TorTransport struct {
 func NewTorTransport() *TorTransport {
 	// instantiate Tor transport
 	return &TorTransport{
-		ctrl:     nil,
-		registry: make(map[string]bool),
-		active:   false,
-		host:     "localhost",
-		peerTTL:  600, // default TTL is 10 minutes
+		ctrl:      nil,
+		registry:  make(map[string]*TorConnector),
+		active:    false,
+		host:      "localhost",
+		peerTTL:   600, // default TTL is 10 minutes
+		dials:     make(chan struct{}, TorMaxDials),
+		dialQueue: TorDialQueue,
+		bootWait:  TorBootstrap,
+		msgSize:   MaxMsgSize,
+		bufs:      newBufferPool(MaxMsgSize),
 	}
 }
 
+// dialService connects to a hidden service endpoint (once Tor has
+// completed its bootstrap).
+func (t *TorTransport) dialService(endp string) (conn net.Conn, err error) {
+	if t.ready != nil {
+		timer := time.NewTimer(t.bootWait)
+		select {
+		case <-t.ready:
+			timer.Stop()
+		case <-timer.C:
+			return nil, ErrTransNotReady
+		}
+	}
+	if t.dial != nil {
+		conn, err = t.dial(endp)
+	} else {
+		conn, err = t.ctrl.DialTimeout("tcp", endp, time.Minute)
+	}
+	if err != nil {
+		return
+	}
+	return t.wrap(conn), nil
+}
+
+// wrap a peer connection for traffic accounting and throttling.
+func (t *TorTransport) wrap(conn net.Conn) net.Conn {
+	if t.throttle != nil {
+		conn = network.NewThrottledConn(conn, t.throttle)
+	}
+	if t.meter != nil {
+		conn = network.NewMeteredConn(conn, t.meter)
+	}
+	return conn
+}
+
+// Meter returns the traffic meter of peer connections (or nil if
+// accounting is not enabled).
+func (t *TorTransport) Meter() *network.Meter {
+	return t.meter
+}
+
 // Open transport based on configuration
 func (t *TorTransport) Open(cfg TransportConfig) (err error) {
 	// check for inactive transport
@@ -458,6 +941,47 @@ func (t *TorTransport) Open(cfg TransportConfig) (err error) {
 	if torCfg.PeerTTL > 0 {
 		t.peerTTL = torCfg.PeerTTL
 	}
+	// set heartbeat parameters
+	t.hbInterval = time.Duration(torCfg.Heartbeat) * time.Second
+	t.hbMisses = torCfg.HeartbeatMisses
+	// set limits for connection attempts
+	if torCfg.MaxDials > 0 {
+		t.dials = make(chan struct{}, torCfg.MaxDials)
+	}
+	if torCfg.DialQueue > 0 {
+		t.dialQueue = torCfg.DialQueue
+	}
+	if torCfg.Bootstrap > 0 {
+		t.bootWait = time.Duration(torCfg.Bootstrap) * time.Second
+	}
+	// set max. message size
+	if t.msgSize, err = checkMsgSize(torCfg.MaxMsgSize); err != nil {
+		return
+	}
+	t.bufs = newBufferPool(t.msgSize)
+	// set traffic accounting and limits
+	if torCfg.Metered {
+		t.meter = network.NewMeter("p2p_tor")
+	}
+	if torCfg.MaxUpload > 0 || torCfg.MaxDownload > 0 || torCfg.UploadTarget > 0 {
+		t.throttle = network.NewThrottle(nil, torCfg.MaxDownload, torCfg.MaxUpload)
+		if torCfg.UploadTarget > 0 {
+			t.throttle.SetTarget(uint64(torCfg.UploadTarget)<<20, 24*time.Hour)
+		}
+	}
+	// parse bridge configuration
+	bridges := make([]*tor.Bridge, len(torCfg.Bridges))
+	for i, line := range torCfg.Bridges {
+		if bridges[i], err = tor.ParseBridge(line); err != nil {
+			return
+		}
+	}
+	plugins := make([]*tor.TransportPlugin, len(torCfg.Transports))
+	for i, spec := range torCfg.Transports {
+		if plugins[i], err = tor.ParseTransportPlugin(spec); err != nil {
+			return
+		}
+	}
 	// connect to the Tor service through the control port
 	netw, endp, err := network.SplitNetworkEndpoint(torCfg.Ctrl)
 	if err != nil {
@@ -468,9 +992,27 @@ func (t *TorTransport) Open(cfg TransportConfig) (err error) {
 		return
 	}
 	// perform authentication
-	if err = t.ctrl.Authenticate(torCfg.Auth); err == nil {
-		t.active = true
+	if err = t.ctrl.Authenticate(torCfg.Auth); err != nil {
+		return
 	}
+	// use bridges (and pluggable transports)
+	if len(bridges) > 0 {
+		if err = t.ctrl.SetBridges(bridges, plugins); err != nil {
+			return
+		}
+	}
+	// wait for bootstrap before dialing peers
+	var ctx context.Context
+	ctx, t.cancel = context.WithCancel(context.Background())
+	t.ready = make(chan struct{})
+	go func() {
+		// dials proceed if the bootstrap status is not available
+		if err := t.ctrl.WaitBootstrapped(ctx, time.Second); err != nil && ctx.Err() == nil {
+			logger.Printf(logger.WARN, "[tor] Bootstrap status failed: %s\n", err.Error())
+		}
+		close(t.ready)
+	}()
+	t.active = true
 	return
 }
 
@@ -503,17 +1045,34 @@ func (t *TorTransport) Register(ctx context.Context, n *Node, endp string) (err
 	if conn, err = NewTorConnector(t, n, port); err != nil {
 		return
 	}
-	err = n.Connect(conn)
+	if err = n.Connect(conn); err != nil {
+		return
+	}
+	t.registry[addr] = conn
 	logger.Printf(logger.DBG, "[%.8s] Registered with transport at %s\n", addr, conn.addr)
 	return
 }
 
-// Close transport
+// Close transport: registered connectors are closed (hidden services
+// removed, connections closed) before the controller is closed.
 func (t *TorTransport) Close() error {
 	// check for active (open) transport
 	if !t.active {
 		return ErrTransClosed
 	}
-	// close controller
+	t.active = false
+	// shut down registered connectors
+	ctx, cancel := context.WithTimeout(context.Background(), TorShutdown)
+	defer cancel()
+	for addr, conn := range t.registry {
+		if err := conn.Close(ctx); err != nil && err != ErrTransClosed {
+			logger.Printf(logger.WARN, "[%.8s] Closing connector failed: %s", addr, err.Error())
+		}
+	}
+	t.registry = make(map[string]*TorConnector)
+	// stop waiting for bootstrap and close controller
+	if t.cancel != nil {
+		t.cancel()
+	}
 	return t.ctrl.Close()
 }
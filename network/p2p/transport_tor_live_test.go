@@ -0,0 +1,51 @@
+//go:build tor
+
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/network/tor"
+)
+
+// TestTorTransportLive runs the Tor transport test against a throwaway
+// Tor process:
+//
+//	go test -tags tor -run TorTransportLive ./network/p2p/...
+func TestTorTransportLive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+	p, err := tor.StartProcess(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	// hidden services need some time to be published
+	start := time.Now()
+	testTorTransport(t, "tcp:"+p.Ctrl, p.Passwd, func() bool {
+		return time.Since(start) > time.Minute
+	})
+}
@@ -0,0 +1,501 @@
+package p2p
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/network"
+	"github.com/bfix/gospel/network/tor"
+)
+
+func TestTorTransportMock(t *testing.T) {
+	mock, err := tor.NewMockTor("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	testTorTransport(t, "tcp:"+mock.Endpoint(), "secret", func() bool {
+		return len(mock.Onions()) == 2
+	})
+}
+
+// testTorTransport exchanges messages between two nodes over a Tor
+// transport; 'ready' reports if the hidden services of the nodes are
+// published.
+func testTorTransport(t *testing.T, ctrl, auth string, ready func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trans := NewTorTransport()
+	err := trans.Open(&TorTransportConfig{
+		Ctrl:      ctrl,
+		Auth:      auth,
+		HSHost:    "127.0.0.1",
+		PeerTTL:   60,
+		Heartbeat: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Close()
+
+	nodes := make([]*Node, 2)
+	for i := range nodes {
+		_, prv := ed25519.NewKeypair()
+		if nodes[i], err = NewNode(prv); err != nil {
+			t.Fatal(err)
+		}
+		if err = trans.Register(ctx, nodes[i], ""); err != nil {
+			t.Fatal(err)
+		}
+		go nodes[i].Run(ctx)
+	}
+	// wait for hidden services
+	for deadline := time.Now().Add(3 * time.Minute); !ready(); {
+		if time.Now().After(deadline) {
+			t.Fatal("hidden services not published")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	// ping in both directions
+	for i, n := range nodes {
+		peer := nodes[1-i].Address()
+		if err = n.PingService().Ping(ctx, peer, time.Minute, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// heartbeats measure the round-trip time of open connections
+	conn, _ := nodes[0].conn.(*TorConnector)
+	for deadline := time.Now().Add(time.Minute); ; {
+		if list := conn.Metrics(); len(list) == 1 && list[0].RTT > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no heartbeat round-trip measured")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestTorDialQueue(t *testing.T) {
+	trans := NewTorTransport()
+	trans.dials = make(chan struct{}, 1)
+	trans.dialQueue = 3
+	trans.hbInterval = -1
+
+	// dialing blocks until released; count concurrent dials
+	var (
+		lock    sync.Mutex
+		active  int
+		maxAct  int
+		servers = make(map[string]net.Conn)
+	)
+	release := make(chan struct{})
+	trans.dial = func(endp string) (net.Conn, error) {
+		lock.Lock()
+		if active++; active > maxAct {
+			maxAct = active
+		}
+		lock.Unlock()
+		<-release
+		cli, srv := net.Pipe()
+		lock.Lock()
+		active--
+		servers[endp] = srv
+		lock.Unlock()
+		return cli, nil
+	}
+	_, prv := ed25519.NewKeypair()
+	node, err := NewNode(prv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := NewTorConnector(trans, node, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mkPacket := func(i byte) *Packet {
+		return &Packet{
+			Size: PacketHdrSize + 1,
+			KXT:  make([]byte, 32),
+			Body: []byte{i},
+		}
+	}
+	dstA := &TorAddress{addr: "a.onion"}
+	dstB := &TorAddress{addr: "b.onion"}
+
+	// sends don't block while connecting; the queue is bounded
+	for i := byte(0); i < 3; i++ {
+		if err = conn.Send(context.Background(), dstA, mkPacket(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = conn.Send(context.Background(), dstA, mkPacket(3)); err != ErrTransQueueFull {
+		t.Fatalf("expected full queue: %v", err)
+	}
+	if err = conn.Send(context.Background(), dstB, mkPacket(0)); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+
+	// queued packets arrive in order
+	srvConn := func(endp string) net.Conn {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			lock.Lock()
+			c, ok := servers[endp]
+			lock.Unlock()
+			if ok {
+				return c
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("no connection to %s", endp)
+		return nil
+	}
+	buf := make([]byte, MaxMsgSize)
+	srvA := srvConn("a.onion:14235")
+	for i := byte(0); i < 3; i++ {
+		if _, n, err := readFrame(srvA, buf); err != nil || buf[n-1] != i {
+			t.Fatalf("packet #%d mismatch (%v)", i, err)
+		}
+	}
+	// open connection is used directly
+	go func() {
+		_ = conn.Send(context.Background(), dstA, mkPacket(7))
+	}()
+	if _, n, err := readFrame(srvA, buf); err != nil || buf[n-1] != 7 {
+		t.Fatalf("direct packet mismatch (%v)", err)
+	}
+	if _, n, err := readFrame(srvConn("b.onion:14235"), buf); err != nil || buf[n-1] != 0 {
+		t.Fatalf("packet mismatch (%v)", err)
+	}
+	if maxAct != 1 {
+		t.Fatalf("dial limit exceeded: %d", maxAct)
+	}
+}
+
+func TestTorBootstrapWait(t *testing.T) {
+	mock, err := tor.NewMockTor("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	srv, err := mock.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if err = srv.SetConf("status/bootstrap-phase", "NOTICE BOOTSTRAP PROGRESS=50 TAG=loading_descriptors"); err != nil {
+		t.Fatal(err)
+	}
+	trans := NewTorTransport()
+	err = trans.Open(&TorTransportConfig{
+		Ctrl:       "tcp:" + mock.Endpoint(),
+		Auth:       "secret",
+		Bridges:    []string{"obfs4 192.0.2.3:443 cert=abc iat-mode=0"},
+		Transports: []string{"obfs4 exec /usr/bin/obfs4proxy"},
+		Bootstrap:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Close()
+	if list, err := srv.Bridges(); err != nil || len(list) != 1 {
+		t.Fatalf("bridges not configured: %v %v", list, err)
+	}
+	// no dialing before bootstrap is complete
+	dialed := make(chan string, 1)
+	trans.dial = func(endp string) (net.Conn, error) {
+		dialed <- endp
+		return nil, net.ErrClosed
+	}
+	if _, err = trans.dialService("peer.onion:14235"); err != ErrTransNotReady {
+		t.Fatalf("expected not ready: %v", err)
+	}
+	if err = srv.SetConf("status/bootstrap-phase", "NOTICE BOOTSTRAP PROGRESS=100 TAG=done"); err != nil {
+		t.Fatal(err)
+	}
+	trans.bootWait = 10 * time.Second
+	if _, err = trans.dialService("peer.onion:14235"); err != net.ErrClosed || len(dialed) != 1 {
+		t.Fatalf("dial after bootstrap: %v", err)
+	}
+	// invalid bridge configuration
+	if err = NewTorTransport().Open(&TorTransportConfig{Bridges: []string{"obfs4"}}); !errors.Is(err, tor.ErrBridgeInvalid) {
+		t.Fatalf("expected bridge error: %v", err)
+	}
+}
+
+func TestTorMeteredConn(t *testing.T) {
+	mock, err := tor.NewMockTor("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	trans := NewTorTransport()
+	err = trans.Open(&TorTransportConfig{
+		Ctrl:         "tcp:" + mock.Endpoint(),
+		Auth:         "secret",
+		Metered:      true,
+		UploadTarget: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Close()
+	a, b := net.Pipe()
+	defer b.Close()
+	go func() {
+		_, _ = io.Copy(io.Discard, b)
+	}()
+	trans.dial = func(endp string) (net.Conn, error) {
+		return a, nil
+	}
+	conn, err := trans.dialService("peer.onion:14235")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if m := trans.Meter(); m == nil || m.BytesWritten() != 1000 || m.Connections() != 1 {
+		t.Fatalf("meter: %v", m)
+	}
+	// upload target (1 MiB) exceeded
+	if _, err = conn.Write(make([]byte, 1<<20)); err != network.ErrThrottleTarget {
+		t.Fatalf("expected target error: %v", err)
+	}
+	conn.Close()
+	if trans.Meter().Connections() != 0 {
+		t.Fatal("connection not closed")
+	}
+}
+
+func TestTorConcurrentInbound(t *testing.T) {
+	mock, err := tor.NewMockTor("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	trans := NewTorTransport()
+	if err = trans.Open(&TorTransportConfig{
+		Ctrl:       "tcp:" + mock.Endpoint(),
+		Auth:       "secret",
+		HSHost:     "127.0.0.1",
+		MaxMsgSize: 4096,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Close()
+
+	// receiving node listens on a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endp := l.Addr().String()
+	l.Close()
+	_, prv := ed25519.NewKeypair()
+	node, err := NewNode(prv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	conn, err := NewTorConnector(trans, node, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const numPeers, numMsgs = 8, 5
+	ch := make(chan Message, numPeers*numMsgs)
+	conn.Listen(ctx, ch)
+
+	// peers send packets over concurrent inbound connections
+	errs := make(chan error, numPeers)
+	for i := 0; i < numPeers; i++ {
+		go func() {
+			_, prv := ed25519.NewKeypair()
+			peer, err := NewNode(prv)
+			if err != nil {
+				errs <- err
+				return
+			}
+			var c net.Conn
+			for deadline := time.Now().Add(10 * time.Second); ; {
+				if c, err = net.Dial("tcp", endp); err == nil {
+					break
+				}
+				if time.Now().After(deadline) {
+					errs <- err
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			defer c.Close()
+			for j := 0; j < numMsgs; j++ {
+				msg := NewPingMsg()
+				hdr := msg.Header()
+				hdr.Sender = peer.Address()
+				hdr.Receiver = node.Address()
+				buf, err := peer.Pack(msg)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if _, err = c.Write(buf); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < numPeers; i++ {
+		if err = <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	senders := make(map[string]int)
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < numPeers*numMsgs; i++ {
+		select {
+		case msg := <-ch:
+			senders[msg.Header().Sender.String()]++
+		case <-timeout:
+			t.Fatalf("only %d of %d messages received", i, numPeers*numMsgs)
+		}
+	}
+	if len(senders) != numPeers {
+		t.Fatalf("messages from %d peers", len(senders))
+	}
+	// oversized packets are rejected
+	big := &Packet{
+		Size: PacketHdrSize + 5000,
+		KXT:  make([]byte, 32),
+		Body: make([]byte, 5000),
+	}
+	if err = conn.Send(ctx, &TorAddress{addr: "a.onion"}, big); err != ErrTransMsgSize {
+		t.Fatalf("expected size error: %v", err)
+	}
+	if err = NewTorTransport().Open(&TorTransportConfig{MaxMsgSize: 70000}); !errors.Is(err, ErrTransInvalidConfig) {
+		t.Fatalf("expected config error: %v", err)
+	}
+}
+
+func TestTorShutdown(t *testing.T) {
+	mock, err := tor.NewMockTor("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	trans := NewTorTransport()
+	if err = trans.Open(&TorTransportConfig{
+		Ctrl:   "tcp:" + mock.Endpoint(),
+		Auth:   "secret",
+		HSHost: "127.0.0.1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Close()
+
+	// run node with hidden service on a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endp := l.Addr().String()
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	_, prv := ed25519.NewKeypair()
+	node, err := NewNode(prv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = trans.Register(context.Background(), node, strconv.Itoa(port)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go node.Run(ctx)
+	for deadline := time.Now().Add(10 * time.Second); len(mock.Onions()) != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("hidden service not published")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// open inbound connection
+	var in net.Conn
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if in, err = net.Dial("tcp", endp); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer in.Close()
+	if _, err = in.Write(heartbeatFrame(framePing, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = readFrame(in, make([]byte, heartbeatFrameSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	// graceful shutdown
+	sctx, scancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer scancel()
+	if err = node.Shutdown(sctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-node.Stopped():
+	default:
+		t.Fatal("shutdown not reported")
+	}
+	if n := len(mock.Onions()); n != 0 {
+		t.Fatalf("hidden service not removed (%d)", n)
+	}
+	if err = in.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = in.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("inbound connection not closed: %v", err)
+	}
+	if _, err = net.Dial("tcp", endp); err == nil {
+		t.Fatal("listener still accepting")
+	}
+	if err = node.Shutdown(sctx); err != ErrNodeShutdown {
+		t.Fatalf("second shutdown: %v", err)
+	}
+	if err = node.conn.Send(ctx, &TorAddress{addr: "a.onion"}, &Packet{Size: PacketHdrSize, KXT: make([]byte, 32)}); err != ErrTransClosed {
+		t.Fatalf("send after shutdown: %v", err)
+	}
+}
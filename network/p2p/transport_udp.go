@@ -22,14 +22,19 @@ package p2p
 
 import (
 	"context"
+	"errors"
 	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/bfix/gospel/concurrent"
 	"github.com/bfix/gospel/data"
 	"github.com/bfix/gospel/logger"
+	"github.com/bfix/gospel/network"
+	gtime "github.com/bfix/gospel/time"
 )
 
 //======================================================================
@@ -42,11 +47,13 @@ import (
 
 // UDPConnector is a stub between a node and the UDP transport implementation.
 type UDPConnector struct {
-	trans   *UDPTransport
-	node    *Node
-	addr    *net.UDPAddr
-	conn    net.PacketConn
-	running bool
+	trans    *UDPTransport
+	node     *Node
+	addr     *net.UDPAddr
+	conn     net.PacketConn
+	connLock sync.Mutex    // guard connection
+	running  atomic.Bool   // connector running?
+	stop     chan struct{} // closed when the connector is closed
 
 	cache  map[string]*net.UDPAddr
 	sample []*Address
@@ -58,14 +65,14 @@ type UDPConnector struct {
 func NewUDPConnector(trans *UDPTransport, node *Node, addr *net.UDPAddr) *UDPConnector {
 	// assemble connector
 	conn := &UDPConnector{
-		trans:   trans,
-		node:    node,
-		addr:    addr,
-		conn:    nil,
-		running: false,
-		cache:   make(map[string]*net.UDPAddr),
-		sample:  make([]*Address, SampleCache),
-		pos:     0,
+		trans:  trans,
+		node:   node,
+		addr:   addr,
+		conn:   nil,
+		stop:   make(chan struct{}),
+		cache:  make(map[string]*net.UDPAddr),
+		sample: make([]*Address, SampleCache),
+		pos:    0,
 	}
 	// register our own node
 	_ = conn.Learn(node.Address(), addr)
@@ -115,15 +122,21 @@ loop:
 // Send message from node to the UDP network.
 func (c *UDPConnector) Send(ctx context.Context, dst net.Addr, pkt *Packet) error {
 	// check if we have an UDP connection
-	if c.conn == nil {
+	c.connLock.Lock()
+	conn := c.conn
+	c.connLock.Unlock()
+	if conn == nil {
 		return ErrTransClosed
 	}
 	buf, err := data.Marshal(pkt)
 	if err != nil {
 		return err
 	}
+	if len(buf) > c.trans.msgSize {
+		return ErrTransMsgSize
+	}
 	// do the UDP transfer
-	n, err := c.conn.WriteTo(buf, dst)
+	n, err := conn.WriteTo(buf, dst)
 	if err != nil {
 		return ErrTransWrite
 	}
@@ -136,8 +149,6 @@ func (c *UDPConnector) Send(ctx context.Context, dst net.Addr, pkt *Packet) erro
 // Listen on an UDP address/port for incoming packets
 func (c *UDPConnector) Listen(ctx context.Context, ch chan Message) {
 
-	// allocate buffer space
-	buffer := make([]byte, MaxMsgSize)
 	nodeAddr := c.node.Address()
 
 	// assemble listener configuration
@@ -150,21 +161,50 @@ func (c *UDPConnector) Listen(ctx context.Context, ch chan Message) {
 	}
 
 	// connector up and running
-	c.running = true
+	c.running.Store(true)
+	clk := c.node.Clock()
 	go func() {
-		var err error
-		for c.running {
-			if c.conn, err = cfg.ListenPacket(ctx, "udp", c.addr.String()); err != nil {
-				logger.Printf(logger.ERROR, "[%.8s] ERROR: Failed to (re-start) UDP connection", nodeAddr)
-				logger.Printf(logger.ERROR, "       %s\n", err.Error())
-				// wait some time, then retry
-				time.Sleep(3 * time.Second)
-				continue
+		// (re-)start listener with backoff
+		policy := &concurrent.RetryPolicy{
+			Initial: 3 * time.Second,
+			Max:     time.Minute,
+			Factor:  2,
+			Jitter:  0.2,
+			Clock:   clk,
+			Retryable: func(error) bool {
+				return c.running.Load()
+			},
+		}
+		for c.running.Load() {
+			err := concurrent.Retry(ctx, policy, func(ctx context.Context) (err error) {
+				c.connLock.Lock()
+				defer c.connLock.Unlock()
+				if !c.running.Load() {
+					// connector stopped
+					return concurrent.Permanent(ErrTransClosed)
+				}
+				if c.conn, err = c.trans.listenPacket(ctx, cfg, c.addr.String()); err != nil {
+					logger.Printf(logger.ERROR, "[%.8s] ERROR: Failed to (re-start) UDP connection", nodeAddr)
+					logger.Printf(logger.ERROR, "       %s\n", err.Error())
+				}
+				return
+			})
+			if err != nil {
+				// connector stopped or context done
+				return
 			}
-			for c.running {
+			// read packets into a (pooled) buffer
+			bufs := c.trans.bufs
+			bp := bufs.get()
+			buffer := *bp
+			for c.running.Load() {
 				// read single UDP packet
 				n, addr, err := c.conn.ReadFrom(buffer)
 				if err != nil {
+					if !c.running.Load() {
+						// connector stopped
+						break
+					}
 					logger.Printf(logger.ERROR, "[%.8s] Listener failed: %s\n", nodeAddr, err.Error())
 					break
 				}
@@ -183,24 +223,72 @@ func (c *UDPConnector) Listen(ctx context.Context, ch chan Message) {
 					logger.Printf(logger.WARN, "[%.8s] Dropping packet from '%.8s'\n", nodeAddr, hdr.Receiver)
 					continue
 				}
+				// check if sender is accepted (network address is only
+				// known for messages that are not forwarded)
+				var netw net.Addr
+				if hdr.Flags&MsgfRelay == 0 {
+					netw = addr
+				}
+				if err = c.node.Admit(hdr.Sender, netw); err != nil {
+					logger.Printf(logger.WARN, "[%.8s] Rejecting packet: %s\n", nodeAddr, err.Error())
+					continue
+				}
 				// tell transport and node about the sender (in case it is unknown and not forwarded)
 				if hdr.Flags&MsgfRelay == 0 {
 					_ = c.Learn(hdr.Sender, addr)
 					_ = c.node.Learn(hdr.Sender, "")
 				}
 				// let the node handle the message
-				ch <- msg
+				select {
+				case ch <- msg:
+				case <-c.stop:
+				}
 			}
+			bufs.put(bp)
 			// close the listener
 			logger.Printf(logger.WARN, "[%.8s] Closing listener\n", nodeAddr)
+			c.connLock.Lock()
 			c.conn.Close()
 			c.conn = nil
+			c.connLock.Unlock()
+			if !c.running.Load() {
+				return
+			}
 			// wait before retrying
-			time.Sleep(10 * time.Second)
+			clk.Sleep(gtime.Jitter(10*time.Second, 0.2))
 		}
 	}()
 }
 
+// Stop receiving packets.
+func (c *UDPConnector) Stop() error {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	c.running.Store(false)
+	if c.conn != nil {
+		if err := c.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close the connector (the UDP socket is closed by Stop).
+func (c *UDPConnector) Close(ctx context.Context) error {
+	if err := c.Stop(); err != nil {
+		return err
+	}
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	select {
+	case <-c.stop:
+		return ErrTransClosed
+	default:
+		close(c.stop)
+	}
+	return nil
+}
+
 // Learn network address of node address
 func (c *UDPConnector) Learn(addr *Address, endp net.Addr) error {
 	c.lock.Lock()
@@ -239,11 +327,38 @@ func (c *UDPConnector) Epoch(epoch int) {
 // Internet-based transport layer
 //----------------------------------------------------------------------
 
+// UDPTransportConfig specifies the (optional) configuration parameters
+// of an UDP-based transport for the P2P network.
+type UDPTransportConfig struct {
+	// Proxy is the URL of a SOCKS5 proxy ("socks5://host:port") that
+	// relays the datagrams (UDP ASSOCIATE); empty for direct access.
+	Proxy string `json:"proxy"`
+	// Timeout defines (in seconds) how long negotiations with the
+	// proxy may take (0: no timeout).
+	Timeout int `json:"timeout"`
+	// MaxMsgSize limits the size of datagrams sent and received (0:
+	// default MaxMsgSize).
+	MaxMsgSize int `json:"maxMsgSize"`
+}
+
+// TransportType returns the kind of transport implementation targeted
+// by the configuration information.
+func (c *UDPTransportConfig) TransportType() string {
+	return "udp"
+}
+
 // UDPTransport handles the transport of packets between nodes over the
 // internet using the UDP protocol.
 type UDPTransport struct {
 	// nodes registered with transport
-	registry map[string]bool
+	registry map[string]*UDPConnector
+
+	// proxy for datagrams (nil: direct)
+	proxy network.Proxy
+
+	// max. datagram size and pool of receive buffers
+	msgSize int
+	bufs    *bufferPool
 }
 
 // NewUDPTransport instantiates a new UDP transport layer where the
@@ -251,16 +366,53 @@ type UDPTransport struct {
 func NewUDPTransport() *UDPTransport {
 	// instantiate transport
 	return &UDPTransport{
-		registry: make(map[string]bool),
+		registry: make(map[string]*UDPConnector),
+		msgSize:  MaxMsgSize,
+		bufs:     newBufferPool(MaxMsgSize),
 	}
 }
 
 // Open transport based on configuration
 func (t *UDPTransport) Open(cfg TransportConfig) error {
-	// nothing to setup...
+	// no configuration: direct access
+	if cfg == nil {
+		return nil
+	}
+	// check for matching configuration type
+	if cfg.TransportType() != "udp" {
+		return ErrTransInvalidConfig
+	}
+	udpCfg, ok := cfg.(*UDPTransportConfig)
+	if !ok {
+		return ErrTransInvalidConfig
+	}
+	// set max. datagram size
+	size, err := checkMsgSize(udpCfg.MaxMsgSize)
+	if err != nil {
+		return err
+	}
+	t.msgSize = size
+	t.bufs = newBufferPool(size)
+	// set proxy for datagrams
+	if len(udpCfg.Proxy) > 0 {
+		timeout := time.Duration(udpCfg.Timeout) * time.Second
+		proxy, err := network.NewSocks5Proxy(udpCfg.Proxy, timeout)
+		if err != nil {
+			return err
+		}
+		t.proxy = proxy
+	}
 	return nil
 }
 
+// listenPacket returns a datagram connection (directly or proxied).
+func (t *UDPTransport) listenPacket(ctx context.Context, cfg *net.ListenConfig, addr string) (net.PacketConn, error) {
+	if t.proxy != nil {
+		return t.proxy.ListenPacket("udp", addr)
+	}
+	return cfg.ListenPacket(ctx, "udp", addr)
+}
+
 // Register a node for participation in the transport layer.
 func (t *UDPTransport) Register(ctx context.Context, n *Node, endp string) error {
 	// get the associated network address
@@ -274,14 +426,20 @@ func (t *UDPTransport) Register(ctx context.Context, n *Node, endp string) error
 		return ErrTransAddressDup
 	}
 	// connect to suitable connector
-	if err = n.Connect(NewUDPConnector(t, n, netwAddr)); err != nil {
+	conn := NewUDPConnector(t, n, netwAddr)
+	if err = n.Connect(conn); err != nil {
 		return err
 	}
+	t.registry[addr] = conn
 	logger.Printf(logger.DBG, "[%.8s] Registered with transport at %s\n", addr, netwAddr)
 	return nil
 }
 
-// Close transport
+// Close transport and all registered connectors.
 func (t *UDPTransport) Close() error {
+	for _, conn := range t.registry {
+		_ = conn.Close(context.Background())
+	}
+	t.registry = make(map[string]*UDPConnector)
 	return nil
 }
@@ -76,7 +76,7 @@ func POP3Connect(service, proxy string) (sess *POP3Session, err error) {
 		return
 	}
 	if proxy == "" {
-		sess.c0, err = net.Dial("tcp", uSrv.Host)
+		sess.c0, err = HappyDial("tcp", uSrv.Host)
 	} else {
 		var (
 			host, portS string
@@ -22,8 +22,11 @@ package network
 
 import (
 	"bytes"
+	"context"
+	gocrypto "crypto"
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -35,25 +38,126 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/bfix/gospel/concurrent"
 	"github.com/bfix/gospel/crypto"
 	gerr "github.com/bfix/gospel/errors"
 	"github.com/bfix/gospel/logger"
 )
 
+// Error codes
+var (
+	ErrMailTooLarge = errors.New("mail exceeds server size limit")
+	ErrMail8Bit     = errors.New("8-bit mail not supported by server")
+	ErrMailNoDSN    = errors.New("delivery status notifications not supported by server")
+	ErrMailNoTLS    = errors.New("STARTTLS not supported by server")
+	ErrMailParam    = errors.New("invalid mail address or parameter")
+)
+
+// MailDSN specifies a request for delivery status notifications (RFC 3461)
+type MailDSN struct {
+	Notify   []string // conditions: "SUCCESS", "FAILURE", "DELAY" (or "NEVER")
+	Ret      string   // return "FULL" message or "HDRS" only
+	EnvID    string   // envelope identifier (returned in notifications)
+	Required bool     // fail if the server does not support DSN
+}
+
+// MailSendOptions are options for sending mail messages
+type MailSendOptions struct {
+	// RootCAs for server certificate verification (nil: system roots)
+	RootCAs *x509.CertPool
+	// ServerName for certificate verification (default: host name)
+	ServerName string
+	// InsecureSkipVerify disables server certificate verification
+	InsecureSkipVerify bool
+	// RequireTLS fails if a plain connection can't be upgraded with
+	// STARTTLS (instead of sending the mail unencrypted).
+	RequireTLS bool
+	// DSN requests delivery status notifications (optional)
+	DSN *MailDSN
+	// Retry policy for failed attempts (nil: single attempt); only
+	// transient errors are retried unless the policy classifies errors.
+	Retry *concurrent.RetryPolicy
+}
+
+// MailSendResult is returned by the server after a mail is accepted
+type MailSendResult struct {
+	QueueID string // queue identifier (if reported by server)
+	Reply   string // final server reply
+	DSN     bool   // delivery status notifications requested?
+}
+
 // SendMailMessage handles outgoing message to SMTP server.
 //
 //   - The connections to the service can be either plain (port 25)
 //     or SSL/TLS (port 465)
 //
 //   - If the server supports STARTTLS and the channel is not already
-//     encrypted (via SSL), the application will use the "STLS" command
-//     to initiate a channel encryption.
+//     encrypted (via SSL), the application will use the "STARTTLS"
+//     command to initiate a channel encryption.
 //
 // - Connections can be tunneled through any SOCKS5 proxy (like Tor)
+//
+// Server certificates are not verified; use SendMail for verified
+// connections and further options.
 func SendMailMessage(host, proxy, fromAddr, toAddr string, body []byte) (err error) {
+	opts := &MailSendOptions{
+		InsecureSkipVerify: true,
+	}
+	_, err = SendMail(host, proxy, fromAddr, toAddr, body, opts)
+	return
+}
+
+// SendMail sends a mail message to an SMTP server (see SendMailMessage)
+// with options for certificate verification and delivery status
+// notifications. Server extensions are honored: messages exceeding the
+// advertised SIZE limit are rejected before transmission and 8-bit
+// content is announced with BODY=8BITMIME (or rejected if the server
+// does not support it). The queue identifier reported by the server is
+// returned so senders can correlate bounces. If a retry policy is
+// specified, sending is repeated on transient failures (network errors
+// and temporary 4xx server replies).
+func SendMail(host, proxy, fromAddr, toAddr string, body []byte, opts *MailSendOptions) (res *MailSendResult, err error) {
+	if opts == nil {
+		opts = new(MailSendOptions)
+	}
+	if opts.Retry == nil {
+		return sendMail(host, proxy, fromAddr, toAddr, body, opts)
+	}
+	policy := *opts.Retry
+	if policy.Retryable == nil {
+		policy.Retryable = IsTransientMailError
+	}
+	err = concurrent.Retry(context.Background(), &policy, func(context.Context) (err error) {
+		res, err = sendMail(host, proxy, fromAddr, toAddr, body, opts)
+		return
+	})
+	return
+}
+
+// IsTransientMailError returns true if sending a mail failed for a
+// reason that may go away (network errors and 4xx server replies).
+func IsTransientMailError(err error) bool {
+	var perr *textproto.Error
+	if errors.As(err, &perr) {
+		return perr.Code >= 400 && perr.Code < 500
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr)
+}
+
+// sendMail performs a single attempt to send a mail message.
+//
+//nolint:gocyclo // life sometimes is complex...
+func sendMail(host, proxy, fromAddr, toAddr string, body []byte, opts *MailSendOptions) (res *MailSendResult, err error) {
+	// envelope data is sent verbatim in SMTP commands
+	if err = checkMailParams(fromAddr, toAddr, opts.DSN); err != nil {
+		return
+	}
 	var (
 		c0  net.Conn
 		c1  *tls.Conn
@@ -76,7 +180,7 @@ func SendMailMessage(host, proxy, fromAddr, toAddr string, body []byte) (err err
 		return
 	}
 	if proxy == "" {
-		c0, err = net.Dial("tcp", uSrv.Host)
+		c0, err = HappyDial("tcp", uSrv.Host)
 	} else {
 		var (
 			host, portS string
@@ -101,54 +205,196 @@ func SendMailMessage(host, proxy, fromAddr, toAddr string, body []byte) (err err
 	}
 
 	sslConfig := &tls.Config{
-		InsecureSkipVerify: true, //nolint:gosec // intentional
+		RootCAs:            opts.RootCAs,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify, //nolint:gosec // optional
+	}
+	if len(sslConfig.ServerName) == 0 {
+		sslConfig.ServerName = uSrv.Hostname()
 	}
 	if uSrv.Scheme == "smtps" {
 		c1 = tls.Client(c0, sslConfig)
 		if err = c1.Handshake(); err != nil {
 			return
 		}
-		cli, err = smtp.NewClient(c1, uSrv.Host)
+		cli, err = smtp.NewClient(c1, uSrv.Hostname())
 	} else {
-		cli, err = smtp.NewClient(c0, uSrv.Host)
+		cli, err = smtp.NewClient(c0, uSrv.Hostname())
 		if err == nil {
-			if ok, _ := cli.Extension("STLS"); ok {
+			if ok, _ := cli.Extension("STARTTLS"); ok {
 				err = cli.StartTLS(sslConfig)
+			} else if opts.RequireTLS {
+				err = ErrMailNoTLS
 			}
 		}
 	}
 	if err != nil {
 		return
 	}
-	pw, _ := uSrv.User.Password()
-	auth := smtp.PlainAuth("", uSrv.User.Username(), pw, uSrv.Host)
-	if err = cli.Auth(auth); err != nil {
-		return
+	if uSrv.User != nil {
+		pw, _ := uSrv.User.Password()
+		auth := smtp.PlainAuth("", uSrv.User.Username(), pw, uSrv.Hostname())
+		if err = cli.Auth(auth); err != nil {
+			return
+		}
+	}
+	res = new(MailSendResult)
+
+	// assemble MAIL parameters from server extensions
+	mailCmd := "MAIL FROM:<" + fromAddr + ">"
+	if ok, limit := cli.Extension("SIZE"); ok {
+		if max, e := strconv.ParseInt(limit, 10, 64); e == nil && max > 0 && int64(len(body)) > max {
+			err = gerr.New(ErrMailTooLarge, "%d > %d", len(body), max)
+			return
+		}
+		mailCmd += fmt.Sprintf(" SIZE=%d", len(body))
 	}
-	if err = cli.Mail(fromAddr); err != nil {
+	if is8Bit(body) {
+		if ok, _ := cli.Extension("8BITMIME"); !ok {
+			err = ErrMail8Bit
+			return
+		}
+		mailCmd += " BODY=8BITMIME"
+	}
+	rcptCmd := "RCPT TO:<" + toAddr + ">"
+	if dsn := opts.DSN; dsn != nil {
+		if ok, _ := cli.Extension("DSN"); ok {
+			res.DSN = true
+			if len(dsn.Ret) > 0 {
+				mailCmd += " RET=" + dsn.Ret
+			}
+			if len(dsn.EnvID) > 0 {
+				mailCmd += " ENVID=" + xtext(dsn.EnvID)
+			}
+			if len(dsn.Notify) > 0 {
+				rcptCmd += " NOTIFY=" + strings.Join(dsn.Notify, ",")
+			}
+			rcptCmd += " ORCPT=rfc822;" + xtext(toAddr)
+		} else if dsn.Required {
+			err = ErrMailNoDSN
+			return
+		}
+	}
+	// send envelope
+	if err = smtpCmd(cli, 250, mailCmd); err != nil {
 		return
 	}
-	if err = cli.Rcpt(toAddr); err != nil {
+	if err = smtpCmd(cli, 25, rcptCmd); err != nil {
 		return
 	}
-	wrt, err := cli.Data()
-	if err != nil {
+	// send message
+	if err = smtpCmd(cli, 354, "DATA"); err != nil {
 		return
 	}
+	wrt := cli.Text.DotWriter()
 	if _, err = wrt.Write(body); err != nil {
 		return
 	}
 	if err = wrt.Close(); err != nil {
 		return
 	}
+	if _, res.Reply, err = cli.Text.ReadResponse(250); err != nil {
+		return
+	}
+	res.QueueID = queueID(res.Reply)
 	err = cli.Quit()
 	return
 }
 
+// checkMailParams rejects envelope addresses and DSN parameters that
+// could inject SMTP commands or break the command syntax.
+func checkMailParams(fromAddr, toAddr string, dsn *MailDSN) error {
+	invalid := func(s string) bool {
+		for _, c := range []byte(s) {
+			if c < 32 || c == 127 || c == '<' || c == '>' {
+				return true
+			}
+		}
+		return false
+	}
+	if invalid(fromAddr) {
+		return gerr.New(ErrMailParam, "sender %q", fromAddr)
+	}
+	if len(toAddr) == 0 || invalid(toAddr) {
+		return gerr.New(ErrMailParam, "recipient %q", toAddr)
+	}
+	if dsn == nil {
+		return nil
+	}
+	if len(dsn.Ret) > 0 && !strings.EqualFold(dsn.Ret, "FULL") && !strings.EqualFold(dsn.Ret, "HDRS") {
+		return gerr.New(ErrMailParam, "RET=%q", dsn.Ret)
+	}
+	for _, n := range dsn.Notify {
+		switch strings.ToUpper(n) {
+		case "SUCCESS", "FAILURE", "DELAY", "NEVER":
+		default:
+			return gerr.New(ErrMailParam, "NOTIFY=%q", n)
+		}
+	}
+	if invalid(dsn.EnvID) || len(dsn.EnvID) > 100 {
+		return gerr.New(ErrMailParam, "ENVID=%q", dsn.EnvID)
+	}
+	return nil
+}
+
+// smtpCmd sends a command and checks the response code
+func smtpCmd(cli *smtp.Client, code int, cmd string) error {
+	id, err := cli.Text.Cmd("%s", cmd)
+	if err != nil {
+		return err
+	}
+	cli.Text.StartResponse(id)
+	defer cli.Text.EndResponse(id)
+	_, _, err = cli.Text.ReadResponse(code)
+	return err
+}
+
+// is8Bit returns true if the data contains non-ASCII bytes
+func is8Bit(data []byte) bool {
+	for _, b := range data {
+		if b > 127 {
+			return true
+		}
+	}
+	return false
+}
+
+// xtext encodes a string for use in DSN parameters (RFC 3461)
+func xtext(s string) string {
+	var buf strings.Builder
+	for _, b := range []byte(s) {
+		if b < 33 || b > 126 || b == '+' || b == '=' {
+			fmt.Fprintf(&buf, "+%02X", b)
+		} else {
+			buf.WriteByte(b)
+		}
+	}
+	return buf.String()
+}
+
+// queueID extracts the queue identifier from a server reply like
+// "2.0.0 Ok: queued as 4F2X1T3mzbz9rxk" or "OK id=1qYqgR-0004aB-Ja".
+func queueID(reply string) string {
+	for _, marker := range []string{"queued as ", "id=", "queued "} {
+		if idx := strings.Index(reply, marker); idx != -1 {
+			if f := strings.Fields(reply[idx+len(marker):]); len(f) > 0 {
+				return strings.Trim(f[0], "<>()[],;")
+			}
+		}
+	}
+	return ""
+}
+
 // MailAttachment is a data structure for data attached to a mail.
 type MailAttachment struct {
 	Header textproto.MIMEHeader
 	Data   []byte
+
+	// set by streamed parsing (see ParseMailStream)
+	Name     string // file name (if any)
+	Type     string // media type (without parameters)
+	Size     int64  // size of (decoded) content
+	Streamed bool   // content written to sink (Data is nil)?
 }
 
 // CreateMailMessage creates a (plain) SMTP email with body and
@@ -192,6 +438,59 @@ func CreateMailMessage(body []byte, att []*MailAttachment) (msg []byte, err erro
 
 // EncryptMailMessage encrypts a mail with given public key.
 func EncryptMailMessage(key, body []byte) (cipher []byte, err error) {
+	return encryptMail(key, body, nil)
+}
+
+// SignAndEncryptMailMessage signs a mail with the private key of an
+// OpenPGP entity and encrypts it with the given public key (combined
+// signing and encryption as defined in RFC 3156, section 6.2).
+func SignAndEncryptMailMessage(signer *openpgp.Entity, key, body []byte) (cipher []byte, err error) {
+	return encryptMail(key, body, signer)
+}
+
+// SignMailMessage creates a PGP/MIME signed message (multipart/signed as
+// defined in RFC 3156) from a MIME entity (like the output of
+// CreateMailMessage) with the private key of an OpenPGP entity. Line
+// endings of the entity are canonicalized to CRLF before signing.
+func SignMailMessage(signer *openpgp.Entity, body []byte) (msg []byte, err error) {
+	// canonicalize signed entity
+	body = bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n"))
+	body = bytes.TrimRight(body, "\n")
+	body = bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n"))
+
+	// create detached signature
+	sig := new(bytes.Buffer)
+	cfg := &packet.Config{DefaultHash: gocrypto.SHA256}
+	if err = openpgp.ArmoredDetachSign(sig, signer, bytes.NewReader(body), cfg); err != nil {
+		return
+	}
+	tmp := make([]byte, 30)
+	if _, err = io.ReadFull(rand.Reader, tmp); err != nil {
+		return
+	}
+	bndry := fmt.Sprintf("%x", tmp)
+	out := new(bytes.Buffer)
+	out.WriteString(
+		"MIME-Version: 1.0\r\n" +
+			"Content-Type: multipart/signed; micalg=pgp-sha256;\r\n" +
+			" protocol=\"application/pgp-signature\";\r\n" +
+			" boundary=\"" + bndry + "\"\r\n\r\n" +
+			"This is an OpenPGP/MIME signed message (RFC 4880 and 3156)\r\n" +
+			"--" + bndry + "\r\n")
+	out.Write(body)
+	out.WriteString(
+		"\r\n--" + bndry + "\r\n" +
+			"Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n" +
+			"Content-Description: OpenPGP digital signature\r\n" +
+			"Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n")
+	out.Write(bytes.ReplaceAll(sig.Bytes(), []byte("\n"), []byte("\r\n")))
+	out.WriteString("\r\n--" + bndry + "--\r\n")
+	msg = out.Bytes()
+	return
+}
+
+// encryptMail encrypts (and optionally signs) a mail message.
+func encryptMail(key, body []byte, signer *openpgp.Entity) (cipher []byte, err error) {
 	rdr := bytes.NewBuffer(key)
 	var keyring openpgp.EntityList
 	if keyring, err = openpgp.ReadArmoredKeyRing(rdr); err != nil {
@@ -204,7 +503,7 @@ func EncryptMailMessage(key, body []byte) (cipher []byte, err error) {
 		err = gerr.New(err, "no armorer created")
 		return
 	}
-	if wrt, err = openpgp.Encrypt(ct, []*openpgp.Entity{keyring[0]}, nil, &openpgp.FileHints{IsBinary: true}, nil); err != nil {
+	if wrt, err = openpgp.Encrypt(ct, []*openpgp.Entity{keyring[0]}, signer, &openpgp.FileHints{IsBinary: true}, nil); err != nil {
 		return
 	}
 	if _, err = wrt.Write(body); err != nil {
@@ -253,6 +552,10 @@ type MailContent struct {
 	Subject string // subject line
 	Body    string // message body
 	Key     []byte // attached key or signing key (public)
+
+	Date        time.Time         // message date (zero if missing)
+	Autocrypt   *AutocryptHeader  // Autocrypt header of sender (or nil)
+	Attachments []*MailAttachment // attachments (streamed parsing only)
 }
 
 // MailUserInfo is a callback function to request user information:
@@ -290,10 +593,20 @@ const (
 
 // ParseMailMessage dissects an incoming mail message
 func ParseMailMessage(msg io.Reader, getInfo MailUserInfo) (mc *MailContent, err error) {
+	return ParseMailStream(msg, getInfo, nil)
+}
+
+// ParseMailStream dissects an incoming mail message; if options are
+// given, size limits are enforced and large attachments of plain
+// messages are streamed to a sink instead of being held in memory.
+func ParseMailStream(msg io.Reader, getInfo MailUserInfo, opts *MailStreamOptions) (mc *MailContent, err error) {
 	var (
 		m                *mail.Message
 		fromAddr, toAddr *mail.Address
 	)
+	if opts != nil && opts.MaxTotal > 0 {
+		msg = &limitReader{r: msg, max: opts.MaxTotal, err: ErrMailTooLarge}
+	}
 	if m, err = mail.ReadMessage(msg); err != nil {
 		return
 	}
@@ -309,12 +622,16 @@ func ParseMailMessage(msg io.Reader, getInfo MailUserInfo) (mc *MailContent, err
 		mc.Mode = modePLAIN
 		mc.Key = nil
 		var data []byte
-		if data, err = io.ReadAll(m.Body); err != nil {
+		if data, err = io.ReadAll(opts.limit(m.Body)); err != nil {
 			return
 		}
 		mc.Body = string(data)
 	} else if strings.HasPrefix(ct, ctMPMIX) {
-		mc, err = ParsePlain(ct, m.Body)
+		if opts != nil {
+			mc, err = parsePlainStream(ct, m.Body, opts)
+		} else {
+			mc, err = ParsePlain(ct, m.Body)
+		}
 	} else if strings.HasPrefix(ct, ctMPENC) {
 		mc, err = ParseEncrypted(ct, fromAddr.Address, getInfo, m.Body)
 	} else if strings.HasPrefix(ct, ctMPSIGN) {
@@ -330,6 +647,10 @@ func ParseMailMessage(msg io.Reader, getInfo MailUserInfo) (mc *MailContent, err
 	mc.From = fromAddr.Address
 	mc.To = toAddr.Address
 	mc.Subject = m.Header.Get("Subject")
+	if mc.Date, err = m.Header.Date(); err != nil {
+		mc.Date, err = time.Time{}, nil
+	}
+	mc.Autocrypt = autocryptFrom(mc.From, m.Header["Autocrypt"])
 	return
 }
 
@@ -416,49 +737,48 @@ func ParseEncrypted(ct, addr string, getInfo MailUserInfo, body io.Reader) (mc *
 				}
 				return buf.Bytes(), nil
 			}
-			id := getIdentity(getInfo, infoIDENTITY, "")
+			// keyring for decryption and signature verification
+			keyring := openpgp.EntityList{getIdentity(getInfo, infoIDENTITY, "")}
+			sender := getIdentity(getInfo, infoSENDER, addr)
+			if sender != nil {
+				keyring = append(keyring, sender)
+			}
 			var md *openpgp.MessageDetails
-			if md, err = openpgp.ReadMessage(rdr.Body, openpgp.EntityList{id}, prompt, nil); err != nil {
+			if md, err = openpgp.ReadMessage(rdr.Body, keyring, prompt, nil); err != nil {
+				return
+			}
+			var content []byte
+			if content, err = io.ReadAll(md.UnverifiedBody); err != nil {
 				return
 			}
 			if md.IsSigned {
 				mc.Mode = modeSIGNENC
-				id := getIdentity(getInfo, infoSENDER, addr)
-				if id == nil {
+				if md.SignedBy == nil || sender == nil || md.SignedBy.Entity != sender {
+					// unknown signer
 					mc.Mode = modeUSIGNENC
-					var content []byte
-					if content, err = io.ReadAll(md.UnverifiedBody); err != nil {
-						return
-					}
 					mc.Body = string(content)
 					continue
 				}
-				md.SignedBy = crypto.GetKeyFromIdentity(id, crypto.KeySign)
-				md.SignedByKeyId = md.SignedBy.PublicKey.KeyId
-				if mc.Key, err = crypto.GetArmoredPublicKey(id); err != nil {
-					return
-				}
-				var content []byte
-				if content, err = io.ReadAll(md.UnverifiedBody); err != nil {
-					return
-				}
 				if md.SignatureError != nil {
 					err = md.SignatureError
 					return
 				}
-				logger.Println(logger.INFO, "Signature verified OK")
-
-				var m *mail.Message
-				if m, err = mail.ReadMessage(bytes.NewBuffer(content)); err != nil {
+				if mc.Key, err = crypto.GetArmoredPublicKey(sender); err != nil {
 					return
 				}
-				ct = m.Header.Get("Content-Type")
-				var mc2 *MailContent
-				if mc2, err = ParsePlain(ct, m.Body); err != nil {
-					return
-				}
-				mc.Body = mc2.Body
+				logger.Println(logger.INFO, "Signature verified OK")
+			}
+			// parse decrypted message
+			var m *mail.Message
+			if m, err = mail.ReadMessage(bytes.NewBuffer(content)); err != nil {
+				return
+			}
+			ct = m.Header.Get("Content-Type")
+			var mc2 *MailContent
+			if mc2, err = ParsePlain(ct, m.Body); err != nil {
+				return
 			}
+			mc.Body = mc2.Body
 		default:
 			err = errors.New("Unhandled MIME part: " + ct)
 			return
@@ -466,12 +786,19 @@ func ParseEncrypted(ct, addr string, getInfo MailUserInfo, body io.Reader) (mc *
 	}
 }
 
-// ParseSigned reads an unencrypted, but signed message.
+// ParseSigned reads an unencrypted, but signed message. The signature
+// is verified over the complete (canonicalized) signed MIME entity as
+// defined in RFC 3156.
 func ParseSigned(ct, addr string, getInfo MailUserInfo, body io.Reader) (mc *MailContent, err error) {
 	mc = new(MailContent)
 	mc.Mode = modeSIGN
 	boundary := extractValue(ct, "boundary")
-	rdr := multipart.NewReader(body, boundary)
+	var data []byte
+	if data, err = io.ReadAll(body); err != nil {
+		return
+	}
+	signed := signedEntity(data, boundary)
+	rdr := multipart.NewReader(bytes.NewReader(data), boundary)
 	for {
 		// get next mime part
 		var part *multipart.Part
@@ -491,14 +818,20 @@ func ParseSigned(ct, addr string, getInfo MailUserInfo, body io.Reader) (mc *Mai
 				return
 			}
 			mc.Body = string(data)
+		case strings.HasPrefix(ct, ctMPMIX):
+			var mc2 *MailContent
+			if mc2, err = ParsePlain(ct, part); err != nil {
+				return
+			}
+			mc.Body = mc2.Body
+			mc.Key = mc2.Key
 		case strings.HasPrefix(ct, "application/pgp-signature;"):
 			id := getIdentity(getInfo, infoSENDER, addr)
 			if id == nil {
 				mc.Mode = modeUSIGN
 				continue
 			}
-			buf := bytes.NewBufferString(mc.Body)
-			if _, err = openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{id}, buf, part, nil); err != nil {
+			if _, err = openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{id}, bytes.NewReader(signed), part, nil); err != nil {
 				return
 			}
 			logger.Println(logger.INFO, "Signature verified OK")
@@ -506,6 +839,27 @@ func ParseSigned(ct, addr string, getInfo MailUserInfo, body io.Reader) (mc *Mai
 	}
 }
 
+// signedEntity returns the (canonicalized) first part of a multipart
+// message: the signed MIME entity of a multipart/signed message.
+func signedEntity(data []byte, boundary string) []byte {
+	delim := []byte("--" + boundary)
+	start := bytes.Index(data, delim)
+	if start == -1 {
+		return nil
+	}
+	data = data[start+len(delim):]
+	if idx := bytes.IndexByte(data, '\n'); idx != -1 {
+		data = data[idx+1:]
+	}
+	end := bytes.Index(data, append([]byte("\n"), delim...))
+	if end == -1 {
+		return nil
+	}
+	data = bytes.TrimSuffix(data[:end], []byte("\r"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+}
+
 // Extract value from string ('... key="value" ...')
 func extractValue(s, key string) string {
 	idx := strings.Index(s, key)
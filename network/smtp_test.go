@@ -0,0 +1,426 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/bfix/gospel/concurrent"
+	"github.com/bfix/gospel/crypto"
+)
+
+// fakeSMTP is a minimal SMTP server for tests
+type fakeSMTP struct {
+	l    net.Listener
+	ext  []string // advertised extensions
+	cmds []string // received commands
+	body string   // received message
+	fail []string // replies to RCPT before accepting
+	lock sync.Mutex
+}
+
+// newFakeSMTP starts a fake server (with TLS if 'cfg' is not nil)
+func newFakeSMTP(t *testing.T, cfg *tls.Config, ext ...string) *fakeSMTP {
+	t.Helper()
+	var (
+		l   net.Listener
+		err error
+	)
+	if cfg != nil {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", cfg)
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{l: l, ext: ext}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+	rdr := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for _, l := range lines {
+			_, _ = conn.Write([]byte(l + "\r\n"))
+		}
+	}
+	reply("220 fake ESMTP")
+	for {
+		line, err := rdr.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		s.lock.Lock()
+		s.cmds = append(s.cmds, cmd)
+		s.lock.Unlock()
+		switch verb := strings.ToUpper(strings.Fields(cmd)[0]); verb {
+		case "EHLO":
+			out := []string{"250-fake"}
+			for _, e := range s.ext {
+				out = append(out, "250-"+e)
+			}
+			out[len(out)-1] = "250 " + out[len(out)-1][4:]
+			reply(out...)
+		case "DATA":
+			reply("354 go ahead")
+			var body strings.Builder
+			for {
+				l, err := rdr.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				body.WriteString(l)
+			}
+			s.lock.Lock()
+			s.body = body.String()
+			s.lock.Unlock()
+			reply("250 2.0.0 Ok: queued as 4F2X1T3mzb")
+		case "RCPT":
+			s.lock.Lock()
+			if len(s.fail) > 0 {
+				reply(s.fail[0])
+				s.fail = s.fail[1:]
+			} else {
+				reply("250 ok")
+			}
+			s.lock.Unlock()
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTP) command(prefix string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.cmds {
+		if strings.HasPrefix(c, prefix) {
+			return c
+		}
+	}
+	return ""
+}
+
+func TestSendMailExtensions(t *testing.T) {
+	srv := newFakeSMTP(t, nil, "SIZE 100", "8BITMIME", "DSN")
+	host := "smtp://" + srv.l.Addr().String()
+	opts := &MailSendOptions{
+		DSN: &MailDSN{
+			Notify: []string{"SUCCESS", "FAILURE"},
+			Ret:    "HDRS",
+			EnvID:  "id+1",
+		},
+	}
+	res, err := SendMail(host, "", "alice@example.org", "bob@example.org", []byte("Subject: Grüße\r\n\r\nHallo\r\n"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.QueueID != "4F2X1T3mzb" || !res.DSN {
+		t.Fatalf("wrong result: %v", res)
+	}
+	mail := srv.command("MAIL FROM:")
+	for _, p := range []string{"SIZE=27", "BODY=8BITMIME", "RET=HDRS", "ENVID=id+2B1"} {
+		if !strings.Contains(mail, p) {
+			t.Fatalf("missing '%s' in '%s'", p, mail)
+		}
+	}
+	if rcpt := srv.command("RCPT TO:"); !strings.Contains(rcpt, "NOTIFY=SUCCESS,FAILURE") {
+		t.Fatalf("missing DSN in '%s'", rcpt)
+	}
+	// size limit
+	_, err = SendMail(host, "", "alice@example.org", "bob@example.org", make([]byte, 101), opts)
+	if !errors.Is(err, ErrMailTooLarge) {
+		t.Fatalf("expected size error: %v", err)
+	}
+	// no 8BITMIME and DSN support
+	srv = newFakeSMTP(t, nil)
+	host = "smtp://" + srv.l.Addr().String()
+	if _, err = SendMail(host, "", "a@b.c", "d@e.f", []byte("Grüße"), nil); !errors.Is(err, ErrMail8Bit) {
+		t.Fatalf("expected 8bit error: %v", err)
+	}
+	opts.DSN.Required = true
+	if _, err = SendMail(host, "", "a@b.c", "d@e.f", []byte("Hi"), opts); !errors.Is(err, ErrMailNoDSN) {
+		t.Fatalf("expected DSN error: %v", err)
+	}
+}
+
+func TestSendMailInjection(t *testing.T) {
+	srv := newFakeSMTP(t, nil, "DSN")
+	host := "smtp://" + srv.l.Addr().String()
+	for _, addr := range []string{
+		"a@b.c>\r\nRCPT TO:<x@y.z",
+		"a@b.c\r\nDATA",
+		"a@b.c\nQUIT",
+		"<a@b.c>",
+	} {
+		if _, err := SendMail(host, "", addr, "d@e.f", []byte("Hi"), nil); !errors.Is(err, ErrMailParam) {
+			t.Fatalf("sender %q accepted: %v", addr, err)
+		}
+		if _, err := SendMail(host, "", "d@e.f", addr, []byte("Hi"), nil); !errors.Is(err, ErrMailParam) {
+			t.Fatalf("recipient %q accepted: %v", addr, err)
+		}
+	}
+	for _, dsn := range []*MailDSN{
+		{Ret: "FULL\r\nDATA"},
+		{Notify: []string{"SUCCESS\r\nDATA"}},
+		{EnvID: "id\r\nDATA"},
+	} {
+		opts := &MailSendOptions{DSN: dsn}
+		if _, err := SendMail(host, "", "a@b.c", "d@e.f", []byte("Hi"), opts); !errors.Is(err, ErrMailParam) {
+			t.Fatalf("DSN %v accepted: %v", dsn, err)
+		}
+	}
+	if c := srv.command("MAIL"); c != "" {
+		t.Fatalf("command sent: %s", c)
+	}
+}
+
+func TestSendMailRequireTLS(t *testing.T) {
+	srv := newFakeSMTP(t, nil)
+	host := "smtp://" + srv.l.Addr().String()
+	opts := &MailSendOptions{RequireTLS: true}
+	if _, err := SendMail(host, "", "a@b.c", "d@e.f", []byte("Hi"), opts); !errors.Is(err, ErrMailNoTLS) {
+		t.Fatalf("expected TLS error: %v", err)
+	}
+	if c := srv.command("MAIL"); c != "" {
+		t.Fatalf("mail sent without TLS: %s", c)
+	}
+}
+
+func TestSendMailRetry(t *testing.T) {
+	srv := newFakeSMTP(t, nil)
+	srv.fail = []string{"451 4.7.1 try again later", "421 4.3.2 busy"}
+	host := "smtp://" + srv.l.Addr().String()
+	attempts := 0
+	opts := &MailSendOptions{
+		Retry: &concurrent.RetryPolicy{
+			MaxAttempts: 3,
+			Initial:     time.Millisecond,
+			OnRetry: func(int, error, time.Duration) {
+				attempts++
+			},
+		},
+	}
+	if _, err := SendMail(host, "", "a@b.c", "d@e.f", []byte("Hi"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 retries, got %d", attempts)
+	}
+	// permanent failure: no retry
+	attempts = 0
+	srv.fail = []string{"550 5.1.1 no such user"}
+	_, err := SendMail(host, "", "a@b.c", "d@e.f", []byte("Hi"), opts)
+	if err == nil || IsTransientMailError(err) {
+		t.Fatalf("expected permanent error: %v", err)
+	}
+	if attempts != 0 {
+		t.Fatalf("unexpected retries: %d", attempts)
+	}
+	// policy-related errors are not retried either
+	if _, err = SendMail(host, "", "a@b.c", "d@e.f", []byte("Grüße"), opts); !errors.Is(err, ErrMail8Bit) || attempts != 0 {
+		t.Fatalf("expected 8bit error: %v (%d retries)", err, attempts)
+	}
+}
+
+func TestSendMailVerify(t *testing.T) {
+	// create self-signed server certificate
+	prv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &prv.PublicKey, prv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newFakeSMTP(t, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: prv}},
+		MinVersion:   tls.VersionTLS12,
+	})
+	host := "smtps://" + srv.l.Addr().String()
+
+	// unknown certificate authority
+	opts := &MailSendOptions{ServerName: "localhost"}
+	if _, err = SendMail(host, "", "a@b.c", "d@e.f", []byte("Hi"), opts); err == nil {
+		t.Fatal("unverified certificate accepted")
+	}
+	// custom root
+	opts.RootCAs = x509.NewCertPool()
+	opts.RootCAs.AddCert(cert)
+	if _, err = SendMail(host, "", "a@b.c", "d@e.f", []byte("Hi"), opts); err != nil {
+		t.Fatal(err)
+	}
+	// legacy interface skips verification
+	if err = SendMailMessage(host, "", "a@b.c", "d@e.f", []byte("Hi")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pgpUserInfo returns identities for mail parsing
+func pgpUserInfo(self, sender *openpgp.Entity) MailUserInfo {
+	return func(key int, data string) interface{} {
+		switch key {
+		case infoIDENTITY:
+			return self
+		case infoSENDER:
+			return sender
+		case infoPASSPHRASE:
+			return ""
+		}
+		return nil
+	}
+}
+
+func TestSignMailMessage(t *testing.T) {
+	alice, err := openpgp.NewEntity("Alice", "", "alice@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := CreateMailMessage([]byte("Hello Bob,\nthis is signed.\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := SignMailMessage(alice, inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := "From: alice@example.org\r\nTo: bob@example.org\r\nSubject: signed\r\n"
+	msg := append([]byte(hdr), signed...)
+	mc, err := ParseMailMessage(bytes.NewReader(msg), pgpUserInfo(nil, alice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mc.Mode != modeSIGN || !strings.HasPrefix(mc.Body, "Hello Bob,") {
+		t.Fatalf("wrong content: %d '%s'", mc.Mode, mc.Body)
+	}
+	// signature survives transport with LF line endings
+	msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	if _, err = ParseMailMessage(bytes.NewReader(msg), pgpUserInfo(nil, alice)); err != nil {
+		t.Fatal(err)
+	}
+	// tampered message
+	msg = bytes.Replace(msg, []byte("signed."), []byte("forged."), 1)
+	if _, err = ParseMailMessage(bytes.NewReader(msg), pgpUserInfo(nil, alice)); err == nil {
+		t.Fatal("tampered message verified")
+	}
+}
+
+func TestSignAndEncryptMailMessage(t *testing.T) {
+	alice, err := openpgp.NewEntity("Alice", "", "alice@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := openpgp.NewEntity("Bob", "", "bob@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.GetArmoredPublicKey(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := CreateMailMessage([]byte("Secret and signed"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := SignAndEncryptMailMessage(alice, key, inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := "From: alice@example.org\r\nTo: bob@example.org\r\nSubject: secret\r\n"
+	mc, err := ParseMailMessage(bytes.NewReader(append([]byte(hdr), enc...)), pgpUserInfo(bob, alice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mc.Mode != modeSIGNENC || mc.Body != "Secret and signed" {
+		t.Fatalf("wrong content: %d '%s'", mc.Mode, mc.Body)
+	}
+	// unknown signer
+	mc, err = ParseMailMessage(bytes.NewReader(append([]byte(hdr), enc...)), pgpUserInfo(bob, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mc.Mode != modeUSIGNENC {
+		t.Fatalf("wrong mode %d", mc.Mode)
+	}
+	// encrypted only
+	if enc, err = EncryptMailMessage(key, inner); err != nil {
+		t.Fatal(err)
+	}
+	mc, err = ParseMailMessage(bytes.NewReader(append([]byte(hdr), enc...)), pgpUserInfo(bob, alice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mc.Mode != modeENC || mc.Body != "Secret and signed" {
+		t.Fatalf("wrong content: %d '%s'", mc.Mode, mc.Body)
+	}
+}
@@ -19,13 +19,15 @@ package network
 //
 // SPDX-License-Identifier: AGPL3.0-or-later
 //----------------------------------------------------------------------
-
 import (
+	"bytes"
 	"errors"
+	"io"
 	"net"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	gerr "github.com/bfix/gospel/errors"
@@ -44,15 +46,109 @@ var socksState = []string{
 	"to X'FF' unassigned",
 }
 
+// SOCKS5 commands
+const (
+	socksCmdConnect   = 1
+	socksCmdBind      = 2
+	socksCmdAssociate = 3
+)
+
 // Error codes
 var (
-	ErrSocksUnsupportedProtocol = errors.New("unsupported protocol (TCP only)")
+	ErrSocksUnsupportedProtocol = errors.New("unsupported protocol")
 	ErrSocksInvalidProxyScheme  = errors.New("invalid proxy scheme")
 	ErrSocksInvalidHost         = errors.New("invalid host definition (missing port)")
 	ErrSocksInvalidPort         = errors.New("invalid host definition (port out of range)")
 	ErrSocksProxyFailed         = errors.New("proxy server failed")
+	ErrSocksInvalidReply        = errors.New("invalid proxy server reply")
+	ErrSocksInvalidAddress      = errors.New("invalid address")
 )
 
+//----------------------------------------------------------------------
+// Proxy abstraction
+//----------------------------------------------------------------------
+
+// Proxy abstracts stream and datagram networking, so that transports
+// can work with direct or proxied connections transparently.
+type Proxy interface {
+	// Dial connects to a remote address.
+	Dial(network, addr string) (net.Conn, error)
+
+	// Listen for incoming stream connections: a direct listener binds
+	// to the given local address; a proxied listener waits for a single
+	// connection from the given (expected) remote address.
+	Listen(network, addr string) (net.Listener, error)
+
+	// ListenPacket returns a datagram connection bound to a local address.
+	ListenPacket(network, addr string) (net.PacketConn, error)
+}
+
+// Direct is a Proxy that uses the local network stack.
+var Direct Proxy = direct{}
+
+// direct networking without proxy
+type direct struct{}
+
+func (direct) Dial(network, addr string) (net.Conn, error) {
+	return HappyDial(network, addr)
+}
+
+func (direct) Listen(network, addr string) (net.Listener, error) {
+	return net.Listen(network, addr)
+}
+
+func (direct) ListenPacket(network, addr string) (net.PacketConn, error) {
+	return net.ListenPacket(network, addr)
+}
+
+// Socks5Proxy is a Proxy that uses a SOCKS5 proxy server: CONNECT for
+// dialing, BIND for listening and UDP ASSOCIATE for datagrams.
+type Socks5Proxy struct {
+	URL     string        // proxy URL ("socks5://host:port")
+	Timeout time.Duration // timeout for proxy negotiation (0: none)
+}
+
+// NewSocks5Proxy returns a new SOCKS5 proxy for given URL.
+func NewSocks5Proxy(proxy string, timeout time.Duration) (*Socks5Proxy, error) {
+	if _, err := socksProxyHost(proxy); err != nil {
+		return nil, err
+	}
+	return &Socks5Proxy{URL: proxy, Timeout: timeout}, nil
+}
+
+// Dial connects to a remote address through the proxy.
+func (p *Socks5Proxy) Dial(network, addr string) (net.Conn, error) {
+	host, port, err := socksSplitAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return Socks5ConnectTimeout(network, host, port, p.URL, p.Timeout)
+}
+
+// Listen waits for a connection from a remote address through the proxy.
+func (p *Socks5Proxy) Listen(network, addr string) (net.Listener, error) {
+	if network != "tcp" {
+		return nil, ErrSocksUnsupportedProtocol
+	}
+	host, port, err := socksSplitAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return Socks5Bind(host, port, p.URL, p.Timeout)
+}
+
+// ListenPacket returns a datagram connection relayed by the proxy.
+func (p *Socks5Proxy) ListenPacket(network, addr string) (net.PacketConn, error) {
+	if !strings.HasPrefix(network, "udp") {
+		return nil, ErrSocksUnsupportedProtocol
+	}
+	return Socks5Associate(network, addr, p.URL, p.Timeout)
+}
+
+//----------------------------------------------------------------------
+// SOCKS5 CONNECT
+//----------------------------------------------------------------------
+
 // Socks5Connect connects to a SOCKS5 proxy.
 func Socks5Connect(proto string, addr string, port int, proxy string) (net.Conn, error) {
 	return Socks5ConnectTimeout(proto, addr, port, proxy, 0)
@@ -64,58 +160,354 @@ func Socks5ConnectTimeout(proto string, addr string, port int, proxy string, tim
 		err = ErrSocksUnsupportedProtocol
 		return
 	}
-	p, err := url.Parse(proxy)
+	if conn, err = socksOpen(proxy, timeout); err != nil {
+		return
+	}
+	if _, _, err = socksRequest(conn, socksCmdConnect, addr, port, timeout); err != nil {
+		conn.Close()
+		return
+	}
+	// remove timeout from connection
+	var zero time.Time
+	err = conn.SetDeadline(zero)
+	// return connection
+	return
+}
+
+//----------------------------------------------------------------------
+// SOCKS5 BIND
+//----------------------------------------------------------------------
+
+// Socks5Bind asks a SOCKS5 proxy to accept a single incoming connection
+// from the expected remote address (usually the peer of a connection
+// established with Socks5Connect). The address the proxy listens on is
+// returned by the listener's Addr() method and must be passed to the
+// remote peer.
+func Socks5Bind(addr string, port int, proxy string, timeout time.Duration) (lst net.Listener, err error) {
+	var conn net.Conn
+	if conn, err = socksOpen(proxy, timeout); err != nil {
+		return
+	}
+	var (
+		host  string
+		bport int
+	)
+	if host, bport, err = socksRequest(conn, socksCmdBind, addr, port, timeout); err != nil {
+		conn.Close()
+		return
+	}
+	// wait for the incoming connection without timeout
+	var zero time.Time
+	if err = conn.SetDeadline(zero); err != nil {
+		conn.Close()
+		return
+	}
+	// if the proxy listens on all interfaces, use the proxy address
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host, _, _ = net.SplitHostPort(conn.RemoteAddr().String())
+	}
+	lst = &socksListener{
+		conn: conn,
+		addr: socksNetAddr("tcp", host, bport),
+	}
+	return
+}
+
+// socksListener accepts a single connection through a SOCKS5 proxy.
+type socksListener struct {
+	conn     net.Conn    // control connection (becomes data connection)
+	addr     net.Addr    // address the proxy listens on
+	accepted atomic.Bool // Accept() called?
+	returned atomic.Bool // connection handed out?
+}
+
+// Accept waits for the incoming connection. Only one connection can be
+// accepted; subsequent calls fail with net.ErrClosed.
+func (l *socksListener) Accept() (net.Conn, error) {
+	if l.accepted.Swap(true) {
+		return nil, net.ErrClosed
+	}
+	// second reply is sent when the remote peer has connected
+	host, port, err := socksReply(l.conn, 0)
 	if err != nil {
+		l.conn.Close()
+		return nil, err
+	}
+	l.returned.Store(true)
+	return &socksConn{
+		Conn:   l.conn,
+		local:  l.addr,
+		remote: socksNetAddr("tcp", host, port),
+	}, nil
+}
+
+// Close the listener; an accepted connection is not affected.
+func (l *socksListener) Close() error {
+	if l.returned.Load() {
+		return nil
+	}
+	return l.conn.Close()
+}
+
+// Addr returns the address the proxy listens on.
+func (l *socksListener) Addr() net.Addr {
+	return l.addr
+}
+
+// socksConn is a proxied connection with endpoint addresses as seen
+// by the proxy.
+type socksConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *socksConn) LocalAddr() net.Addr  { return c.local }
+func (c *socksConn) RemoteAddr() net.Addr { return c.remote }
+
+//----------------------------------------------------------------------
+// SOCKS5 UDP ASSOCIATE
+//----------------------------------------------------------------------
+
+// Socks5Associate establishes an UDP relay through a SOCKS5 proxy. The
+// returned connection sends and receives datagrams through the relay;
+// addresses are those of the remote peers. The relay is released when
+// the connection is closed (or the proxy terminates the association).
+// 'laddr' is the local address of the datagram socket (empty: any).
+func Socks5Associate(network, laddr string, proxy string, timeout time.Duration) (pc net.PacketConn, err error) {
+	if laddr == "" {
+		laddr = ":0"
+	}
+	var ctrl net.Conn
+	if ctrl, err = socksOpen(proxy, timeout); err != nil {
 		return
 	}
-	if len(p.Scheme) > 0 && p.Scheme != "socks5" {
-		err = gerr.New(ErrSocksInvalidProxyScheme, "scheme %s", p.Scheme)
+	// the client address is not known in advance (NAT): let the
+	// proxy accept datagrams from any address/port.
+	var (
+		host string
+		port int
+	)
+	if host, port, err = socksRequest(ctrl, socksCmdAssociate, "0.0.0.0", 0, timeout); err != nil {
+		ctrl.Close()
 		return
 	}
-	idx := strings.Index(p.Host, ":")
-	if idx == -1 {
-		err = ErrSocksInvalidHost
+	// if the relay listens on all interfaces, use the proxy address
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsUnspecified() {
+		rhost, _, _ := net.SplitHostPort(ctrl.RemoteAddr().String())
+		if ip = net.ParseIP(rhost); ip == nil {
+			ctrl.Close()
+			err = gerr.New(ErrSocksInvalidReply, "relay address %s", host)
+			return
+		}
+	}
+	var zero time.Time
+	if err = ctrl.SetDeadline(zero); err != nil {
+		ctrl.Close()
 		return
 	}
-	var pPort int
-	if pPort, err = strconv.Atoi(p.Host[idx+1:]); err != nil || pPort < 1 || pPort > 65535 {
-		err = gerr.New(ErrSocksInvalidPort, "port %d", pPort)
+	var udp net.PacketConn
+	if udp, err = net.ListenPacket(network, laddr); err != nil {
+		ctrl.Close()
 		return
 	}
-	if timeout == 0 {
-		conn, err = net.Dial("tcp", p.Host)
-	} else {
-		conn, err = net.DialTimeout("tcp", p.Host, timeout)
+	c := &socksPacketConn{
+		ctrl:  ctrl,
+		udp:   udp,
+		relay: &net.UDPAddr{IP: ip, Port: port},
 	}
+	// the association ends when the control connection terminates
+	go func() {
+		_, _ = io.Copy(io.Discard, ctrl)
+		udp.Close()
+	}()
+	pc = c
+	return
+}
+
+// socksPacketConn is a datagram connection relayed by a SOCKS5 proxy.
+type socksPacketConn struct {
+	ctrl  net.Conn       // control connection
+	udp   net.PacketConn // local datagram socket
+	relay *net.UDPAddr   // relay address of the proxy
+}
+
+// ReadFrom receives a datagram from a remote peer. Datagrams not sent
+// by the relay and fragmented datagrams are dropped.
+func (c *socksPacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	// header: RSV(2), FRAG(1), ATYP(1), DST.ADDR (max. 256), DST.PORT(2)
+	buf := make([]byte, len(p)+262)
+	for {
+		var from net.Addr
+		if n, from, err = c.udp.ReadFrom(buf); err != nil {
+			return 0, nil, err
+		}
+		if ua, ok := from.(*net.UDPAddr); !ok || !ua.IP.Equal(c.relay.IP) || ua.Port != c.relay.Port {
+			continue
+		}
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+		rdr := bytes.NewReader(buf[3:n])
+		host, port, err := socksReadAddr(rdr)
+		if err != nil {
+			continue
+		}
+		return copy(p, buf[n-rdr.Len():n]), socksNetAddr("udp", host, port), nil
+	}
+}
+
+// WriteTo sends a datagram to a remote peer through the relay.
+func (c *socksPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	host, port, err := socksSplitAddr(addr.String())
+	if err != nil {
+		return 0, err
+	}
+	hdr, err := socksAddrBytes(host, port)
 	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 0, 3+len(hdr)+len(p))
+	buf = append(buf, 0, 0, 0)
+	buf = append(buf, hdr...)
+	buf = append(buf, p...)
+	if _, err = c.udp.WriteTo(buf, c.relay); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close the datagram connection and release the relay.
+func (c *socksPacketConn) Close() error {
+	err := c.udp.Close()
+	c.ctrl.Close()
+	return err
+}
+
+// LocalAddr returns the address of the local datagram socket.
+func (c *socksPacketConn) LocalAddr() net.Addr {
+	return c.udp.LocalAddr()
+}
+
+// SetDeadline sets read and write deadlines.
+func (c *socksPacketConn) SetDeadline(t time.Time) error {
+	return c.udp.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline.
+func (c *socksPacketConn) SetReadDeadline(t time.Time) error {
+	return c.udp.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline.
+func (c *socksPacketConn) SetWriteDeadline(t time.Time) error {
+	return c.udp.SetWriteDeadline(t)
+}
+
+//----------------------------------------------------------------------
+// SOCKS5 protocol helpers
+//----------------------------------------------------------------------
+
+// SocksAddr is a (named) endpoint address reported by a proxy that is
+// not resolved to an IP address.
+type SocksAddr struct {
+	Host string
+	Port int
+}
+
+// Network returns the name of the network.
+func (a *SocksAddr) Network() string {
+	return "socks5"
+}
+
+// String returns the address in "host:port" notation.
+func (a *SocksAddr) String() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+// socksNetAddr returns a network address for host and port.
+func socksNetAddr(network, host string, port int) net.Addr {
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return &SocksAddr{Host: host, Port: port}
+	case network == "udp":
+		return &net.UDPAddr{IP: ip, Port: port}
+	}
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
+// socksSplitAddr splits an endpoint address into host and port.
+func socksSplitAddr(addr string) (host string, port int, err error) {
+	var portS string
+	if host, portS, err = net.SplitHostPort(addr); err != nil {
+		err = ErrSocksInvalidHost
 		return
 	}
+	if port, err = strconv.Atoi(portS); err != nil || port < 0 || port > 65535 {
+		err = gerr.New(ErrSocksInvalidPort, "port %s", portS)
+	}
+	return
+}
 
-	data := make([]byte, 1024)
+// socksProxyHost returns the endpoint of the proxy server.
+func socksProxyHost(proxy string) (string, error) {
+	p, err := url.Parse(proxy)
+	if err != nil {
+		return "", err
+	}
+	if len(p.Scheme) > 0 && p.Scheme != "socks5" {
+		return "", gerr.New(ErrSocksInvalidProxyScheme, "scheme %s", p.Scheme)
+	}
+	_, pPortS, errH := net.SplitHostPort(p.Host)
+	if errH != nil {
+		return "", ErrSocksInvalidHost
+	}
+	var pPort int
+	if pPort, err = strconv.Atoi(pPortS); err != nil || pPort < 1 || pPort > 65535 {
+		return "", gerr.New(ErrSocksInvalidPort, "port %d", pPort)
+	}
+	return p.Host, nil
+}
 
-	//-----------------------------------------------------------------
-	// negotiate authentication
-	//-----------------------------------------------------------------
-	data[0] = 5 // SOCKS version
-	data[1] = 1 // One available authentication method
-	data[2] = 0 // No authentication required
+// socksDeadline sets a deadline on the connection (if timeout is set).
+func socksDeadline(conn net.Conn, timeout time.Duration) error {
 	if timeout > 0 {
-		if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
-			return
-		}
+		return conn.SetDeadline(time.Now().Add(timeout))
+	}
+	return nil
+}
+
+// socksOpen connects to a proxy server and negotiates authentication.
+func socksOpen(proxy string, timeout time.Duration) (conn net.Conn, err error) {
+	var host string
+	if host, err = socksProxyHost(proxy); err != nil {
+		return
+	}
+	dialer := &HappyDialer{Timeout: timeout}
+	if conn, err = dialer.Dial("tcp", host); err != nil {
+		return
+	}
+	data := []byte{
+		5, // SOCKS version
+		1, // One available authentication method
+		0, // No authentication required
+	}
+	if err = socksDeadline(conn, timeout); err != nil {
+		conn.Close()
+		return
 	}
 	var n int
-	if n, err = conn.Write(data[:3]); n != 3 {
+	if n, err = conn.Write(data); n != 3 {
 		err = gerr.New(err, "failed to write to proxy server")
 		conn.Close()
 		return
 	}
-	if timeout > 0 {
-		if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
-			return
-		}
+	if err = socksDeadline(conn, timeout); err != nil {
+		conn.Close()
+		return
 	}
-	if n, err = conn.Read(data); n != 2 {
+	if _, err = io.ReadFull(conn, data[:2]); err != nil {
 		err = gerr.New(err, "failed to read from proxy server")
 		conn.Close()
 		return
@@ -125,50 +517,102 @@ func Socks5ConnectTimeout(proto string, addr string, port int, proxy string, tim
 		conn.Close()
 		return
 	}
+	return
+}
 
-	//-----------------------------------------------------------------
-	// connect to target (request/reply processing)
-	//-----------------------------------------------------------------
-	dn := []byte(addr)
-	size := len(dn)
+// socksRequest sends a command to the proxy and returns the bound
+// address from the reply.
+func socksRequest(conn net.Conn, cmd byte, addr string, port int, timeout time.Duration) (string, int, error) {
+	dn, err := socksAddrBytes(addr, port)
+	if err != nil {
+		return "", 0, err
+	}
+	data := append([]byte{
+		5,   // SOCKS versions
+		cmd, // command
+		0,   // reserved
+	}, dn...)
+	if err = socksDeadline(conn, timeout); err != nil {
+		return "", 0, err
+	}
+	if n, err := conn.Write(data); n != len(data) {
+		return "", 0, gerr.New(err, "failed to write to proxy server")
+	}
+	return socksReply(conn, timeout)
+}
 
-	data[0] = 5          // SOCKS versions
-	data[1] = 1          // connect to target
-	data[2] = 0          // reserved
-	data[3] = 3          // domain name specified
-	data[4] = byte(size) // length of domain name
-	for i, v := range dn {
-		data[5+i] = v
+// socksReply reads a reply from the proxy and returns the address in it.
+func socksReply(conn net.Conn, timeout time.Duration) (host string, port int, err error) {
+	if err = socksDeadline(conn, timeout); err != nil {
+		return
 	}
-	data[5+size] = (byte)(port / 256)
-	data[6+size] = (byte)(port % 256)
-	if timeout > 0 {
-		if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
-			return
+	hdr := make([]byte, 3)
+	if _, err = io.ReadFull(conn, hdr); err != nil {
+		return
+	}
+	if hdr[0] != 5 {
+		err = gerr.New(ErrSocksInvalidReply, "version %d", hdr[0])
+		return
+	}
+	if hdr[1] != 0 {
+		state := socksState[len(socksState)-1]
+		if int(hdr[1]) < len(socksState) {
+			state = socksState[hdr[1]]
 		}
+		err = gerr.New(ErrSocksProxyFailed, state)
+		return
 	}
-	if n, err = conn.Write(data[:7+size]); n != (7 + size) {
-		err = gerr.New(err, "failed to write to proxy server")
-		conn.Close()
+	return socksReadAddr(conn)
+}
+
+// socksAddrBytes encodes an address (ATYP, ADDR, PORT).
+func socksAddrBytes(addr string, port int) ([]byte, error) {
+	var dn []byte
+	if ip := net.ParseIP(strings.Trim(addr, "[]")); ip == nil {
+		if len(addr) == 0 || len(addr) > 255 {
+			return nil, gerr.New(ErrSocksInvalidAddress, "host '%s'", addr)
+		}
+		dn = append([]byte{3, byte(len(addr))}, addr...) // domain name
+	} else if ip4 := ip.To4(); ip4 != nil {
+		dn = append([]byte{1}, ip4...) // IPv4 address
+	} else {
+		dn = append([]byte{4}, ip.To16()...) // IPv6 address
+	}
+	return append(dn, byte(port/256), byte(port%256)), nil
+}
+
+// socksReadAddr decodes an address (ATYP, ADDR, PORT).
+func socksReadAddr(r io.Reader) (host string, port int, err error) {
+	buf := make([]byte, 256)
+	if _, err = io.ReadFull(r, buf[:1]); err != nil {
 		return
 	}
-	if timeout > 0 {
-		if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+	switch buf[0] {
+	case 1, 4:
+		size := net.IPv4len
+		if buf[0] == 4 {
+			size = net.IPv6len
+		}
+		if _, err = io.ReadFull(r, buf[:size]); err != nil {
 			return
 		}
-	}
-	if _, err = conn.Read(data); err != nil {
-		conn.Close()
+		host = net.IP(buf[:size]).String()
+	case 3:
+		if _, err = io.ReadFull(r, buf[:1]); err != nil {
+			return
+		}
+		size := int(buf[0])
+		if _, err = io.ReadFull(r, buf[:size]); err != nil {
+			return
+		}
+		host = string(buf[:size])
+	default:
+		err = gerr.New(ErrSocksInvalidReply, "address type %d", buf[0])
 		return
 	}
-	if data[1] != 0 {
-		err = gerr.New(ErrSocksProxyFailed, socksState[data[1]])
-		conn.Close()
+	if _, err = io.ReadFull(r, buf[:2]); err != nil {
 		return
 	}
-	// remove timeout from connection
-	var zero time.Time
-	err = conn.SetDeadline(zero)
-	// return connection
+	port = int(buf[0])<<8 | int(buf[1])
 	return
 }
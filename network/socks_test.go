@@ -0,0 +1,286 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeSocks is a minimal SOCKS5 proxy for tests (no authentication;
+// CONNECT, BIND and UDP ASSOCIATE on the loopback interface).
+type fakeSocks struct {
+	l net.Listener
+}
+
+// newFakeSocks starts a fake proxy and returns its URL.
+func newFakeSocks(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSocks{l: l}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return "socks5://" + l.Addr().String()
+}
+
+func (s *fakeSocks) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// reply sends a reply with bound address.
+func (s *fakeSocks) reply(conn net.Conn, rc byte, addr net.Addr) {
+	host, port, _ := socksSplitAddr(addr.String())
+	dn, _ := socksAddrBytes(host, port)
+	_, _ = conn.Write(append([]byte{5, rc, 0}, dn...))
+}
+
+func (s *fakeSocks) handle(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return
+	}
+	host, port, err := socksReadAddr(conn)
+	if err != nil {
+		return
+	}
+	switch buf[1] {
+	case socksCmdConnect:
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			s.reply(conn, 5, conn.LocalAddr())
+			return
+		}
+		defer target.Close()
+		s.reply(conn, 0, target.LocalAddr())
+		go func() { _, _ = io.Copy(target, conn) }()
+		_, _ = io.Copy(conn, target)
+
+	case socksCmdBind:
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			s.reply(conn, 1, conn.LocalAddr())
+			return
+		}
+		s.reply(conn, 0, l.Addr())
+		peer, err := l.Accept()
+		l.Close()
+		if err != nil {
+			return
+		}
+		defer peer.Close()
+		s.reply(conn, 0, peer.RemoteAddr())
+		go func() { _, _ = io.Copy(peer, conn) }()
+		_, _ = io.Copy(conn, peer)
+
+	case socksCmdAssociate:
+		relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			s.reply(conn, 1, conn.LocalAddr())
+			return
+		}
+		defer relay.Close()
+		s.reply(conn, 0, relay.LocalAddr())
+		go func() {
+			var client net.Addr
+			pkt := make([]byte, 2048)
+			for {
+				n, from, err := relay.ReadFrom(pkt)
+				if err != nil {
+					return
+				}
+				if client == nil || from.String() == client.String() {
+					// datagram from client: unwrap and forward
+					client = from
+					rdr := bytes.NewReader(pkt[3:n])
+					host, port, err := socksReadAddr(rdr)
+					if err != nil {
+						continue
+					}
+					dst := &net.UDPAddr{IP: net.ParseIP(host), Port: port}
+					_, _ = relay.WriteTo(pkt[n-rdr.Len():n], dst)
+					continue
+				}
+				// datagram from peer: wrap and send to client
+				host, port, _ := socksSplitAddr(from.String())
+				dn, _ := socksAddrBytes(host, port)
+				out := append(append([]byte{0, 0, 0}, dn...), pkt[:n]...)
+				_, _ = relay.WriteTo(out, client)
+			}
+		}()
+		// association lasts as long as the control connection
+		_, _ = io.Copy(io.Discard, conn)
+
+	default:
+		s.reply(conn, 7, conn.LocalAddr())
+	}
+}
+
+func TestSocks5Connect(t *testing.T) {
+	proxy := newFakeSocks(t)
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		c, err := echo.Accept()
+		if err == nil {
+			_, _ = io.Copy(c, c)
+			c.Close()
+		}
+	}()
+	p, err := NewSocks5Proxy(proxy, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("echo: %q %v", buf, err)
+	}
+	// unsupported command and invalid proxies
+	if _, err = Socks5Connect("udp", "127.0.0.1", 1, proxy); err != ErrSocksUnsupportedProtocol {
+		t.Fatalf("protocol: %v", err)
+	}
+	if _, err = NewSocks5Proxy("http://127.0.0.1:8080", 0); err == nil {
+		t.Fatal("invalid scheme accepted")
+	}
+	if _, err = NewSocks5Proxy("socks5://127.0.0.1", 0); err != ErrSocksInvalidHost {
+		t.Fatalf("missing port: %v", err)
+	}
+}
+
+func TestSocks5Bind(t *testing.T) {
+	p, err := NewSocks5Proxy(newFakeSocks(t), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := p.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, ok := l.Addr().(*net.TCPAddr); !ok {
+		t.Fatalf("bound address: %v", l.Addr())
+	}
+	// remote peer connects to the address announced by the proxy
+	peer, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != peer.LocalAddr().String() {
+		t.Fatalf("remote address: %v != %v", conn.RemoteAddr(), peer.LocalAddr())
+	}
+	if _, err = peer.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read: %q %v", buf, err)
+	}
+	if _, err = l.Accept(); err != net.ErrClosed {
+		t.Fatalf("second accept: %v", err)
+	}
+}
+
+func TestSocks5Associate(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+	p, err := NewSocks5Proxy(newFakeSocks(t), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pc net.PacketConn
+	if pc, err = p.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if err = pc.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pc.WriteTo([]byte("datagram"), echo.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "datagram" || from.String() != echo.LocalAddr().String() {
+		t.Fatalf("received %q from %v", buf[:n], from)
+	}
+	if _, ok := from.(*net.UDPAddr); !ok {
+		t.Fatalf("address type: %T", from)
+	}
+	// closing the datagram connection ends the association
+	pc.Close()
+	if _, _, err = pc.ReadFrom(buf); err == nil {
+		t.Fatal("read after close")
+	}
+	if _, err = p.ListenPacket("tcp", ""); err != ErrSocksUnsupportedProtocol {
+		t.Fatalf("protocol: %v", err)
+	}
+}
@@ -0,0 +1,378 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bfix/gospel/logger"
+)
+
+//======================================================================
+// Status endpoint for daemons: health, build info and metrics (in the
+// Prometheus text exposition format) over a local HTTP endpoint.
+// Metrics are collected from registered sources when requested.
+//======================================================================
+
+// Metric types
+const (
+	MetricGauge   = "gauge"
+	MetricCounter = "counter"
+	MetricSummary = "summary"
+)
+
+// Metric is a single (labelled) value.
+type Metric struct {
+	Name   string            // metric name (like "p2p_peers")
+	Help   string            // short description
+	Type   string            // metric type (gauge, counter, summary)
+	Labels map[string]string // optional labels
+	Value  float64           // current value
+}
+
+// MetricsSource provides metrics for the status endpoint.
+type MetricsSource interface {
+	Metrics() []*Metric
+}
+
+// MetricsFunc is a function used as a metrics source.
+type MetricsFunc func() []*Metric
+
+// Metrics returns the result of the function call.
+func (f MetricsFunc) Metrics() []*Metric {
+	return f()
+}
+
+// HealthChecker is implemented by metrics sources that can report an
+// unhealthy state (non-nil error).
+type HealthChecker interface {
+	Health() error
+}
+
+//----------------------------------------------------------------------
+// Summary of observations (like request latencies)
+//----------------------------------------------------------------------
+
+// Summary collects observations and reports count, sum and quantiles
+// (over a sliding window of the latest observations).
+type Summary struct {
+	sync.Mutex
+	name   string    // metric name
+	help   string    // metric description
+	window []float64 // latest observations (ring buffer)
+	pos    int       // next position in window
+	count  uint64    // total number of observations
+	sum    float64   // sum of all observations
+}
+
+// SummaryQuantiles reported by a summary
+var SummaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+// NewSummary creates a summary keeping the latest 'size' observations.
+// A summary with a non-positive size keeps no observations (quantiles
+// are reported as NaN).
+func NewSummary(name, help string, size int) *Summary {
+	if size < 0 {
+		size = 0
+	}
+	return &Summary{
+		name:   name,
+		help:   help,
+		window: make([]float64, 0, size),
+	}
+}
+
+// Observe adds a new observation.
+func (s *Summary) Observe(v float64) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.window) < cap(s.window) {
+		s.window = append(s.window, v)
+	} else if cap(s.window) > 0 {
+		s.window[s.pos] = v
+		s.pos = (s.pos + 1) % cap(s.window)
+	}
+	s.count++
+	s.sum += v
+}
+
+// ObserveDuration adds a duration (in seconds) as new observation.
+func (s *Summary) ObserveDuration(d time.Duration) {
+	s.Observe(d.Seconds())
+}
+
+// Metrics returns quantiles, sum and count of the summary.
+func (s *Summary) Metrics() (list []*Metric) {
+	s.Lock()
+	defer s.Unlock()
+	vals := make([]float64, len(s.window))
+	copy(vals, s.window)
+	sort.Float64s(vals)
+	for _, q := range SummaryQuantiles {
+		v := math.NaN()
+		if n := len(vals); n > 0 {
+			v = vals[int(q*float64(n-1)+0.5)]
+		}
+		list = append(list, &Metric{
+			Name:   s.name,
+			Help:   s.help,
+			Type:   MetricSummary,
+			Labels: map[string]string{"quantile": strconv.FormatFloat(q, 'g', -1, 64)},
+			Value:  v,
+		})
+	}
+	list = append(list,
+		&Metric{Name: s.name + "_sum", Type: MetricSummary, Value: s.sum},
+		&Metric{Name: s.name + "_count", Type: MetricSummary, Value: float64(s.count)},
+	)
+	return
+}
+
+//----------------------------------------------------------------------
+// Status server
+//----------------------------------------------------------------------
+
+// StatusServer is an embeddable HTTP status endpoint with handlers for
+// "/health", "/info" (build info as JSON) and "/metrics". It should
+// listen on a local address only; to make it available remotely, it
+// can be published as an onion service (see tor.ExposeStatus).
+type StatusServer struct {
+	mtx     sync.Mutex
+	info    map[string]string // build and daemon info
+	sources []MetricsSource   // registered metrics sources
+	started time.Time         // start time of server
+	srv     *http.Server      // HTTP server (if started)
+	addr    net.Addr          // listen address (if started)
+}
+
+// NewStatusServer creates a new status server; build info is taken
+// from the running binary.
+func NewStatusServer() *StatusServer {
+	s := &StatusServer{
+		info: map[string]string{
+			"goversion": runtime.Version(),
+		},
+		started: time.Now(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		s.info["path"] = bi.Path
+		s.info["version"] = bi.Main.Version
+		for _, set := range bi.Settings {
+			if set.Key == "vcs.revision" {
+				s.info["revision"] = set.Value
+			}
+		}
+	}
+	return s
+}
+
+// SetInfo sets a custom info value (like the daemon name).
+func (s *StatusServer) SetInfo(key, val string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.info[key] = val
+}
+
+// Register a metrics source.
+func (s *StatusServer) Register(src MetricsSource) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.sources = append(s.sources, src)
+}
+
+// Handler returns the HTTP handler for the status endpoint.
+func (s *StatusServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/info", s.handleInfo)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	return mux
+}
+
+// Start the status server on a (local) address like "127.0.0.1:9100".
+func (s *StatusServer) Start(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	s.srv = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.addr = l.Addr()
+	srv := s.srv
+	s.mtx.Unlock()
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Printf(logger.ERROR, "[status] server failed: %s\n", err.Error())
+		}
+	}()
+	return nil
+}
+
+// Addr returns the listen address of a started server.
+func (s *StatusServer) Addr() net.Addr {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.addr
+}
+
+// Close a started server.
+func (s *StatusServer) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.srv == nil {
+		return nil
+	}
+	err := s.srv.Close()
+	s.srv, s.addr = nil, nil
+	return err
+}
+
+// handle "/health": report failed health checks
+func (s *StatusServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	var failed []string
+	for _, src := range s.list() {
+		if hc, ok := src.(HealthChecker); ok {
+			if err := hc.Health(); err != nil {
+				failed = append(failed, err.Error())
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(failed) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(w, strings.Join(failed, "\n")+"\n")
+		return
+	}
+	_, _ = io.WriteString(w, "OK\n")
+}
+
+// handle "/info": build and daemon info as JSON
+func (s *StatusServer) handleInfo(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	buf, err := json.Marshal(s.info)
+	s.mtx.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(buf)
+}
+
+// handle "/metrics": all metrics in text exposition format
+func (s *StatusServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	list := []*Metric{
+		{Name: "process_uptime_seconds", Help: "Time since start of status server", Type: MetricGauge, Value: time.Since(s.started).Seconds()},
+		{Name: "go_goroutines", Help: "Number of goroutines", Type: MetricGauge, Value: float64(runtime.NumGoroutine())},
+	}
+	s.mtx.Lock()
+	info := make(map[string]string)
+	for k, v := range s.info {
+		info[k] = v
+	}
+	s.mtx.Unlock()
+	list = append(list, &Metric{Name: "build_info", Help: "Build information", Type: MetricGauge, Labels: info, Value: 1})
+	for _, src := range s.list() {
+		list = append(list, src.Metrics()...)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = io.WriteString(w, FormatMetrics(list))
+}
+
+// list returns a copy of the registered sources.
+func (s *StatusServer) list() []MetricsSource {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]MetricsSource{}, s.sources...)
+}
+
+// FormatMetrics renders metrics in the Prometheus text exposition
+// format; HELP and TYPE lines are written once per metric family.
+func FormatMetrics(list []*Metric) string {
+	buf := new(strings.Builder)
+	seen := make(map[string]bool)
+	for _, m := range list {
+		family := m.Name
+		if m.Type == MetricSummary {
+			family = strings.TrimSuffix(strings.TrimSuffix(family, "_sum"), "_count")
+		}
+		if !seen[family] {
+			seen[family] = true
+			if len(m.Help) > 0 {
+				fmt.Fprintf(buf, "# HELP %s %s\n", family, strings.ReplaceAll(m.Help, "\n", " "))
+			}
+			if len(m.Type) > 0 {
+				fmt.Fprintf(buf, "# TYPE %s %s\n", family, m.Type)
+			}
+		}
+		buf.WriteString(m.Name)
+		if len(m.Labels) > 0 {
+			keys := make([]string, 0, len(m.Labels))
+			for k := range m.Labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for i, k := range keys {
+				sep := ","
+				if i == 0 {
+					sep = "{"
+				}
+				fmt.Fprintf(buf, "%s%s=\"%s\"", sep, k, labelEscaper.Replace(m.Labels[k]))
+			}
+			buf.WriteByte('}')
+		}
+		buf.WriteByte(' ')
+		buf.WriteString(formatValue(m.Value))
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// escape label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatValue of a metric
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
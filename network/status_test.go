@@ -0,0 +1,124 @@
+package network
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSource is a metrics source with health check
+type testSource struct {
+	err error
+}
+
+func (s *testSource) Metrics() []*Metric {
+	return []*Metric{
+		{Name: "test_peers", Help: "Number of peers", Type: MetricGauge, Labels: map[string]string{"net": "a\"b"}, Value: 3},
+	}
+}
+
+func (s *testSource) Health() error {
+	return s.err
+}
+
+func TestFormatMetrics(t *testing.T) {
+	sum := NewSummary("rpc_latency_seconds", "RPC latency", 4)
+	for _, d := range []time.Duration{4, 1, 2, 3, 5} {
+		sum.ObserveDuration(d * time.Second)
+	}
+	exp := `# HELP rpc_latency_seconds RPC latency
+# TYPE rpc_latency_seconds summary
+rpc_latency_seconds{quantile="0.5"} 3
+rpc_latency_seconds{quantile="0.9"} 5
+rpc_latency_seconds{quantile="0.99"} 5
+rpc_latency_seconds_sum 15
+rpc_latency_seconds_count 5
+`
+	if s := FormatMetrics(sum.Metrics()); s != exp {
+		t.Fatalf("wrong summary:\n%s", s)
+	}
+	// summary without observation window
+	sum = NewSummary("rpc_errors", "RPC errors", -1)
+	sum.Observe(2)
+	exp = `# HELP rpc_errors RPC errors
+# TYPE rpc_errors summary
+rpc_errors{quantile="0.5"} NaN
+rpc_errors{quantile="0.9"} NaN
+rpc_errors{quantile="0.99"} NaN
+rpc_errors_sum 2
+rpc_errors_count 1
+`
+	if s := FormatMetrics(sum.Metrics()); s != exp {
+		t.Fatalf("wrong summary:\n%s", s)
+	}
+}
+
+func TestStatusServer(t *testing.T) {
+	src := new(testSource)
+	s := NewStatusServer()
+	s.SetInfo("daemon", "testd")
+	s.Register(src)
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	get := func(path string) (int, string) {
+		resp, err := http.Get("http://" + s.Addr().String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	// health
+	if rc, body := get("/health"); rc != http.StatusOK || body != "OK\n" {
+		t.Fatalf("health: %d '%s'", rc, body)
+	}
+	src.err = errors.New("no peers")
+	if rc, body := get("/health"); rc != http.StatusServiceUnavailable || body != "no peers\n" {
+		t.Fatalf("unhealthy: %d '%s'", rc, body)
+	}
+	// info
+	_, body := get("/info")
+	info := make(map[string]string)
+	if err := json.Unmarshal([]byte(body), &info); err != nil || info["daemon"] != "testd" || len(info["goversion"]) == 0 {
+		t.Fatalf("info: '%s' %v", body, err)
+	}
+	// metrics
+	_, body = get("/metrics")
+	for _, line := range []string{
+		"# TYPE test_peers gauge",
+		`test_peers{net="a\"b"} 3`,
+		"# TYPE go_goroutines gauge",
+		`daemon="testd"`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("missing '%s' in metrics:\n%s", line, body)
+		}
+	}
+}
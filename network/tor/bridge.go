@@ -0,0 +1,246 @@
+package tor
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	gerr "github.com/bfix/gospel/errors"
+)
+
+// Error codes
+var (
+	ErrBridgeInvalid    = errors.New("invalid bridge line")
+	ErrPluginInvalid    = errors.New("invalid transport plugin")
+	ErrBootstrapInvalid = errors.New("invalid bootstrap status")
+)
+
+//======================================================================
+// Bridges and pluggable transports (configured via the control port)
+//======================================================================
+
+// Bridge is a Tor bridge relay (optionally reached through a pluggable
+// transport like "obfs4" or "snowflake").
+type Bridge struct {
+	Transport   string   // pluggable transport ("" for plain bridges)
+	Addr        string   // bridge address ("host:port")
+	Fingerprint string   // relay fingerprint (optional)
+	Args        []string // transport arguments ("key=value")
+}
+
+// ParseBridge parses a bridge line like
+// "obfs4 192.0.2.1:443 <fingerprint> cert=... iat-mode=0".
+func ParseBridge(line string) (b *Bridge, err error) {
+	f := strings.Fields(line)
+	b = new(Bridge)
+	// optional transport name precedes the address
+	if len(f) > 0 {
+		if _, _, e := net.SplitHostPort(f[0]); e != nil {
+			b.Transport = f[0]
+			f = f[1:]
+		}
+	}
+	if len(f) == 0 {
+		return nil, gerr.New(ErrBridgeInvalid, "'%s'", line)
+	}
+	if _, _, err = net.SplitHostPort(f[0]); err != nil {
+		return nil, gerr.New(ErrBridgeInvalid, "'%s': %s", line, err.Error())
+	}
+	b.Addr = f[0]
+	f = f[1:]
+	if len(f) > 0 && !strings.Contains(f[0], "=") {
+		b.Fingerprint = f[0]
+		f = f[1:]
+	}
+	for _, arg := range f {
+		if !strings.Contains(arg, "=") {
+			return nil, gerr.New(ErrBridgeInvalid, "'%s': argument '%s'", line, arg)
+		}
+	}
+	b.Args = f
+	return b, nil
+}
+
+// String returns the bridge line.
+func (b *Bridge) String() string {
+	var parts []string
+	if len(b.Transport) > 0 {
+		parts = append(parts, b.Transport)
+	}
+	parts = append(parts, b.Addr)
+	if len(b.Fingerprint) > 0 {
+		parts = append(parts, b.Fingerprint)
+	}
+	return strings.Join(append(parts, b.Args...), " ")
+}
+
+// TransportPlugin is a client-side pluggable transport binary
+// providing one or more transports (like "obfs4,meek_lite").
+type TransportPlugin struct {
+	Transports []string // provided transports
+	Exec       string   // path to plugin binary
+	Args       []string // command-line arguments
+}
+
+// String returns the plugin in "ClientTransportPlugin" format.
+func (p *TransportPlugin) String() string {
+	s := strings.Join(p.Transports, ",") + " exec " + p.Exec
+	if len(p.Args) > 0 {
+		s += " " + strings.Join(p.Args, " ")
+	}
+	return s
+}
+
+// ParseTransportPlugin parses a plugin specification like
+// "obfs4,meek_lite exec /usr/bin/lyrebird".
+func ParseTransportPlugin(spec string) (*TransportPlugin, error) {
+	f := strings.Fields(spec)
+	if len(f) < 3 || f[1] != "exec" {
+		return nil, gerr.New(ErrPluginInvalid, "'%s'", spec)
+	}
+	return &TransportPlugin{
+		Transports: strings.Split(f[0], ","),
+		Exec:       f[2],
+		Args:       f[3:],
+	}, nil
+}
+
+// SetBridges configures Tor to connect through the given bridges and
+// pluggable transport plugins (replacing previous settings). An empty
+// list of bridges disables the use of bridges.
+func (s *Service) SetBridges(bridges []*Bridge, plugins []*TransportPlugin) error {
+	if len(bridges) == 0 {
+		if err := s.SetConf("UseBridges", "0"); err != nil {
+			return err
+		}
+		return s.ResetConf("Bridge ClientTransportPlugin")
+	}
+	cmd := "SETCONF UseBridges=1"
+	for _, b := range bridges {
+		cmd += " Bridge=" + strconv.Quote(b.String())
+	}
+	for _, p := range plugins {
+		cmd += " ClientTransportPlugin=" + strconv.Quote(p.String())
+	}
+	_, err := s.execute(cmd)
+	return err
+}
+
+// Bridges returns the configured bridges.
+func (s *Service) Bridges() (list []*Bridge, err error) {
+	var res map[string][]string
+	if res, err = s.GetConf("Bridge"); err != nil {
+		return
+	}
+	for _, line := range res["Bridge"] {
+		if len(line) == 0 {
+			continue
+		}
+		var b *Bridge
+		if b, err = ParseBridge(line); err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return
+}
+
+//----------------------------------------------------------------------
+// Bootstrap status
+//----------------------------------------------------------------------
+
+// BootstrapStatus is the bootstrap progress of a Tor client.
+type BootstrapStatus struct {
+	Progress int    // progress in percent
+	Tag      string // bootstrap phase (like "conn_done" or "done")
+	Summary  string // human-readable description of phase
+	Warning  string // problem description (if bootstrapping is stuck)
+}
+
+// Done returns true if Tor is fully bootstrapped.
+func (b *BootstrapStatus) Done() bool {
+	return b.Progress >= 100
+}
+
+// BootstrapPhase returns the current bootstrap status.
+func (s *Service) BootstrapPhase() (*BootstrapStatus, error) {
+	res, err := s.GetInfo([]string{"status/bootstrap-phase"})
+	if err != nil {
+		return nil, err
+	}
+	vals := res["status/bootstrap-phase"]
+	if len(vals) == 0 {
+		return nil, ErrBootstrapInvalid
+	}
+	// "<severity> BOOTSTRAP PROGRESS=<n> TAG=<tag> SUMMARY=<quoted> ..."
+	st := new(BootstrapStatus)
+	found := false
+	for _, arg := range splitArgs(vals[0]) {
+		key, val, ok := strings.Cut(arg, "=")
+		if !ok {
+			continue
+		}
+		val = strings.Trim(val, "\"")
+		switch key {
+		case "PROGRESS":
+			if st.Progress, err = strconv.Atoi(val); err != nil {
+				return nil, gerr.New(ErrBootstrapInvalid, "'%s'", vals[0])
+			}
+			found = true
+		case "TAG":
+			st.Tag = val
+		case "SUMMARY":
+			st.Summary = val
+		case "WARNING":
+			st.Warning = val
+		}
+	}
+	if !found {
+		return nil, gerr.New(ErrBootstrapInvalid, "'%s'", vals[0])
+	}
+	return st, nil
+}
+
+// WaitBootstrapped polls the bootstrap status (in given intervals)
+// until Tor is fully bootstrapped or the context is done.
+func (s *Service) WaitBootstrapped(ctx context.Context, interval time.Duration) error {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		st, err := s.BootstrapPhase()
+		if err != nil {
+			return err
+		}
+		if st.Done() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return gerr.New(ctx.Err(), "bootstrap at %d%% (%s)", st.Progress, st.Tag)
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package tor
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseBridge(t *testing.T) {
+	for _, line := range []string{
+		"192.0.2.1:9001",
+		"192.0.2.2:443 4352E58420E68F5E40BF7C74FADDCCD9D1349413",
+		"obfs4 192.0.2.3:443 4352E58420E68F5E40BF7C74FADDCCD9D1349413 cert=ssH+9rP8dG2NLDN2XuFw63hIO/9MNNinLmxQDpVa+7kTOa9/m+tGWT1SmSYpQ9uTBGa6Hw iat-mode=0",
+		"snowflake [2001:db8::1]:80 2B280B23E1107BB62ABFC40DDCC8824814F80A72 url=https://snowflake-broker.example/ ice=stun:stun.example:3478",
+	} {
+		b, err := ParseBridge(line)
+		if err != nil {
+			t.Fatalf("'%s': %v", line, err)
+		}
+		if b.String() != line {
+			t.Fatalf("'%s' != '%s'", b, line)
+		}
+	}
+	for _, line := range []string{"", "obfs4", "obfs4 192.0.2.3", "192.0.2.1:9001 FINGERPRINT junk"} {
+		if _, err := ParseBridge(line); !errors.Is(err, ErrBridgeInvalid) {
+			t.Fatalf("'%s': expected error: %v", line, err)
+		}
+	}
+	p, err := ParseTransportPlugin("obfs4,meek_lite exec /usr/bin/lyrebird -enableLogging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Transports) != 2 || p.Exec != "/usr/bin/lyrebird" || p.String() != "obfs4,meek_lite exec /usr/bin/lyrebird -enableLogging" {
+		t.Fatalf("wrong plugin: %v", p)
+	}
+	if _, err = ParseTransportPlugin("obfs4 /usr/bin/lyrebird"); !errors.Is(err, ErrPluginInvalid) {
+		t.Fatalf("expected error: %v", err)
+	}
+}
+
+func TestBridgesAndBootstrap(t *testing.T) {
+	mock, err := NewMockTor("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	ctrl, err := mock.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctrl.Close()
+
+	// configure bridges
+	b1, _ := ParseBridge("obfs4 192.0.2.3:443 4352E58420E68F5E40BF7C74FADDCCD9D1349413 cert=abc iat-mode=0")
+	b2, _ := ParseBridge("obfs4 192.0.2.4:443 cert=def iat-mode=1")
+	p, _ := ParseTransportPlugin("obfs4 exec /usr/bin/obfs4proxy")
+	if err = ctrl.SetBridges([]*Bridge{b1, b2}, []*TransportPlugin{p}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := ctrl.Bridges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].String() != b1.String() || list[1].String() != b2.String() {
+		t.Fatalf("wrong bridges: %v", list)
+	}
+	conf, err := ctrl.GetConf("UseBridges")
+	if err != nil || conf["UseBridges"][0] != "1" {
+		t.Fatalf("bridges not enabled: %v %v", conf, err)
+	}
+	// disable bridges
+	if err = ctrl.SetBridges(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if conf, err = ctrl.GetConf("UseBridges"); err != nil || conf["UseBridges"][0] != "0" {
+		t.Fatalf("bridges not disabled: %v %v", conf, err)
+	}
+
+	// bootstrap status
+	st, err := ctrl.BootstrapPhase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Done() || st.Tag != "done" || st.Summary != "Done" {
+		t.Fatalf("wrong status: %+v", st)
+	}
+	mock.lock.Lock()
+	mock.conf["status/bootstrap-phase"] = []string{`WARN BOOTSTRAP PROGRESS=10 TAG=conn_done SUMMARY="Connected to a relay" WARNING="Connection refused" REASON=CONNECTREFUSED`}
+	mock.lock.Unlock()
+	if st, err = ctrl.BootstrapPhase(); err != nil {
+		t.Fatal(err)
+	}
+	if st.Done() || st.Progress != 10 || st.Warning != "Connection refused" {
+		t.Fatalf("wrong status: %+v", st)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = ctrl.WaitBootstrapped(ctx, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout: %v", err)
+	}
+}
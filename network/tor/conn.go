@@ -70,7 +70,7 @@ func (s *Service) DialTimeout(netw, address string, timeout time.Duration, flags
 			return nil, err
 		}
 	}
-	proxy := fmt.Sprintf("socks5://%s:%s", s.host, socks)
+	proxy := "socks5://" + net.JoinHostPort(s.host, socks)
 	// connect through Tor proxy
 	return network.Socks5ConnectTimeout(netw, host, int(port), proxy, timeout)
 }
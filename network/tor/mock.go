@@ -0,0 +1,494 @@
+package tor
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+)
+
+//======================================================================
+// Mock Tor service: a minimal in-process implementation of the Tor
+// control port protocol and of a SOCKS5 proxy for unit tests. Hidden
+// services added with ADD_ONION are reachable through the mock SOCKS
+// port (onion addresses are mapped to their local port targets), so
+// consumers of Service and Onion can be tested without a Tor process.
+//======================================================================
+
+// Error codes
+var (
+	ErrMockClosed = errors.New("mock Tor service closed")
+)
+
+// MockTor is a mock Tor service listening on a local control port and
+// a local SOCKS port.
+type MockTor struct {
+	// Clearnet allows connections to non-onion destinations
+	Clearnet bool
+
+	passwd string                    // control port password
+	ctrl   net.Listener              // control port listener
+	socks  net.Listener              // SOCKS port listener
+	conf   map[string][]string       // configuration settings
+	onions map[string]map[int]string // port mappings of hidden services
+	conns  map[net.Conn]bool         // open connections
+	closed bool                      // service closed?
+	lock   sync.Mutex                // lock for concurrent access
+}
+
+// NewMockTor starts a mock Tor service on local ports. Clients must
+// authenticate with the given password.
+func NewMockTor(passwd string) (m *MockTor, err error) {
+	m = &MockTor{
+		passwd: passwd,
+		conf:   make(map[string][]string),
+		onions: make(map[string]map[int]string),
+		conns:  make(map[net.Conn]bool),
+	}
+	if m.ctrl, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	if m.socks, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		m.ctrl.Close()
+		return nil, err
+	}
+	m.conf["SocksPort"] = []string{m.socks.Addr().String()}
+	m.conf["status/circuit-established"] = []string{"1"}
+	m.conf["status/bootstrap-phase"] = []string{"NOTICE BOOTSTRAP PROGRESS=100 TAG=done SUMMARY=\"Done\""}
+	m.conf["circuit-status"] = []string{"1 BUILT $0000~guard,$1111~middle,$2222~exit BUILD_FLAGS=NEED_CAPACITY PURPOSE=GENERAL TIME_CREATED=2023-06-01T12:00:00.000000"}
+	m.conf["stream-status"] = []string{}
+	m.conf["traffic/read"] = []string{"0"}
+	m.conf["traffic/written"] = []string{"0"}
+	go m.serve(m.ctrl, m.handleControl)
+	go m.serve(m.socks, m.handleSocks)
+	return m, nil
+}
+
+// Endpoint returns the control port endpoint ("host:port").
+func (m *MockTor) Endpoint() string {
+	return m.ctrl.Addr().String()
+}
+
+// SocksPort returns the SOCKS port endpoint ("host:port").
+func (m *MockTor) SocksPort() string {
+	return m.socks.Addr().String()
+}
+
+// Service returns a new authenticated controller for the mock service.
+func (m *MockTor) Service() (*Service, error) {
+	srv, err := NewService("tcp", m.Endpoint())
+	if err != nil {
+		return nil, err
+	}
+	if err = srv.Authenticate(m.passwd); err != nil {
+		srv.Close()
+		return nil, err
+	}
+	return srv, nil
+}
+
+// Onions returns the service identifiers of running hidden services.
+func (m *MockTor) Onions() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	list := make([]string, 0, len(m.onions))
+	for id := range m.onions {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Close the mock service (and all open connections).
+func (m *MockTor) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return ErrMockClosed
+	}
+	m.closed = true
+	for conn := range m.conns {
+		conn.Close()
+	}
+	m.socks.Close()
+	return m.ctrl.Close()
+}
+
+//----------------------------------------------------------------------
+// internal methods
+//----------------------------------------------------------------------
+
+// serve accepts connections on a listener
+func (m *MockTor) serve(l net.Listener, hdlr func(net.Conn)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		if !m.track(conn, true) {
+			conn.Close()
+			return
+		}
+		go func() {
+			defer m.track(conn, false)
+			defer conn.Close()
+			hdlr(conn)
+		}()
+	}
+}
+
+// track open connections
+func (m *MockTor) track(conn net.Conn, add bool) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if add {
+		if m.closed {
+			return false
+		}
+		m.conns[conn] = true
+	} else {
+		delete(m.conns, conn)
+	}
+	return true
+}
+
+// handleControl processes control port commands
+func (m *MockTor) handleControl(conn net.Conn) {
+	rdr := bufio.NewReader(conn)
+	authenticated := false
+	for {
+		line, err := rdr.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		cmd = strings.ToUpper(cmd)
+		var resp []string
+		switch {
+		case cmd == "AUTHENTICATE":
+			if strings.Trim(args, "\"") != m.passwd {
+				resp = []string{"515 Authentication failed"}
+				break
+			}
+			authenticated = true
+		case cmd == "QUIT":
+			_, _ = conn.Write([]byte("250 closing connection\r\n"))
+			return
+		case !authenticated:
+			resp = []string{"514 Authentication required."}
+		default:
+			resp = m.command(cmd, args)
+		}
+		// send reply lines
+		if len(resp) == 0 || !strings.HasPrefix(resp[len(resp)-1], "5") {
+			resp = append(resp, "250 OK")
+		}
+		out := ""
+		for i, r := range resp {
+			if i < len(resp)-1 {
+				out += "250-" + r + "\r\n"
+			} else {
+				out += r + "\r\n"
+			}
+		}
+		if _, err = conn.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+// command executes an authenticated control command and returns the
+// reply lines (an error reply is returned as the last line).
+func (m *MockTor) command(cmd, args string) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	resp := make([]string, 0)
+	switch cmd {
+	case "GETCONF", "GETINFO":
+		for _, key := range strings.Fields(args) {
+			if cmd == "GETINFO" && key == "onions/current" {
+				ids := make([]string, 0, len(m.onions))
+				for id := range m.onions {
+					ids = append(ids, id)
+				}
+				sort.Strings(ids)
+				for _, id := range ids {
+					resp = append(resp, key+"="+id)
+				}
+				continue
+			}
+			vals, ok := m.conf[key]
+			if !ok {
+				return []string{"552 Unrecognized key \"" + key + "\""}
+			}
+			for _, v := range vals {
+				resp = append(resp, key+"="+v)
+			}
+		}
+	case "SETCONF":
+		// repeated keys set a list of values
+		set := make(map[string][]string)
+		for _, kv := range splitArgs(args) {
+			k, v, _ := strings.Cut(kv, "=")
+			set[k] = append(set[k], strings.Trim(v, "\""))
+		}
+		for k, v := range set {
+			m.conf[k] = v
+		}
+	case "RESETCONF":
+		for _, k := range strings.Fields(args) {
+			delete(m.conf, k)
+		}
+	case "MAPADDRESS":
+		resp = append(resp, strings.Fields(args)...)
+	case "SETEVENTS", "SAVECONF", "SIGNAL":
+	case "ADD_ONION":
+		return m.addOnion(strings.Fields(args))
+	case "DEL_ONION":
+		if _, ok := m.onions[args]; !ok {
+			return []string{"552 Unknown Onion Service id"}
+		}
+		delete(m.onions, args)
+	default:
+		return []string{"510 Unrecognized command \"" + cmd + "\""}
+	}
+	return resp
+}
+
+// addOnion handles an ADD_ONION command (must be called with lock held)
+func (m *MockTor) addOnion(args []string) []string {
+	if len(args) == 0 {
+		return []string{"512 Missing argument"}
+	}
+	// get key for hidden service
+	var (
+		key    interface{}
+		newKey string
+	)
+	spec, blob, _ := strings.Cut(args[0], ":")
+	switch {
+	case spec == "NEW" && blob == "ED25519-V3":
+		_, prv := ed25519.NewKeypair()
+		key = prv
+		newKey = "ED25519-V3:" + base64.StdEncoding.EncodeToString(prv.TorBlob())
+	case spec == "NEW" && blob == "RSA1024":
+		prv, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			return []string{"551 " + err.Error()}
+		}
+		key = prv
+		newKey = "RSA1024:" + base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(prv))
+	case spec == "ED25519-V3" || spec == "RSA1024":
+		data, err := base64.StdEncoding.DecodeString(blob)
+		if err == nil {
+			if spec == "ED25519-V3" {
+				key, err = ed25519.NewPrivateKeyFromTorBlob(data)
+			} else {
+				key, err = x509.ParsePKCS1PrivateKey(data)
+			}
+		}
+		if err != nil {
+			return []string{"513 Invalid key blob"}
+		}
+	default:
+		return []string{"513 Invalid key type"}
+	}
+	o := &Onion{key: key}
+	id, err := o.ServiceID()
+	if err != nil {
+		return []string{"551 " + err.Error()}
+	}
+	if _, ok := m.onions[id]; ok {
+		return []string{"550 Onion address collision"}
+	}
+	// collect port mappings
+	ports := make(map[int]string)
+	for _, arg := range args[1:] {
+		val, ok := strings.CutPrefix(arg, "Port=")
+		if !ok {
+			continue
+		}
+		port, tgt, _ := strings.Cut(val, ",")
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return []string{"512 Invalid VIRTPORT/TARGET"}
+		}
+		if len(tgt) == 0 {
+			tgt = "127.0.0.1:" + port
+		} else if _, err = strconv.Atoi(tgt); err == nil {
+			tgt = "127.0.0.1:" + tgt
+		}
+		ports[p] = tgt
+	}
+	if len(ports) == 0 {
+		return []string{"512 Missing 'Port' argument"}
+	}
+	m.onions[id] = ports
+	resp := []string{"ServiceID=" + id}
+	if len(newKey) > 0 {
+		resp = append(resp, "PrivateKey="+newKey)
+	}
+	return resp
+}
+
+// handleSocks processes a SOCKS5 connect request
+func (m *MockTor) handleSocks(conn net.Conn) {
+	reply := func(code byte) {
+		_, _ = conn.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0})
+	}
+	// negotiate authentication
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+	// read connect request
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	if buf[1] != 1 {
+		reply(7)
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return
+		}
+		host = net.IP(buf[:4]).String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return
+		}
+		host = string(buf[:n])
+	default:
+		reply(8)
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	port := int(binary.BigEndian.Uint16(buf[:2]))
+
+	// resolve target
+	var tgt string
+	if id, ok := strings.CutSuffix(host, ".onion"); ok {
+		m.lock.Lock()
+		tgt = m.onions[id][port]
+		m.lock.Unlock()
+		if len(tgt) == 0 {
+			reply(4)
+			return
+		}
+	} else if m.Clearnet {
+		tgt = net.JoinHostPort(host, strconv.Itoa(port))
+	} else {
+		reply(2)
+		return
+	}
+	out, err := net.Dial("tcp", tgt)
+	if err != nil {
+		reply(5)
+		return
+	}
+	defer out.Close()
+	if !m.track(out, true) {
+		return
+	}
+	defer m.track(out, false)
+	reply(0)
+
+	// relay data in both directions
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		if tc, ok := dst.(*net.TCPConn); ok {
+			_ = tc.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go pipe(out, conn)
+	go pipe(conn, out)
+	<-done
+	<-done
+}
+
+//----------------------------------------------------------------------
+// Helper functions
+//----------------------------------------------------------------------
+
+// splitArgs splits a command argument string at spaces outside of
+// quoted strings.
+func splitArgs(s string) []string {
+	var (
+		list  []string
+		cur   strings.Builder
+		quote bool
+	)
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quote = !quote
+			cur.WriteRune(r)
+		case r == ' ' && !quote:
+			if cur.Len() > 0 {
+				list = append(list, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		list = append(list, cur.String())
+	}
+	return list
+}
+
+// String returns a human-readable description of the mock service.
+func (m *MockTor) String() string {
+	return fmt.Sprintf("MockTor{ctrl=%s,socks=%s}", m.Endpoint(), m.SocksPort())
+}
@@ -0,0 +1,160 @@
+package tor
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+)
+
+func TestMockService(t *testing.T) {
+	mock, err := NewMockTor("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	// authentication required
+	ctrl, err := NewService("tcp", mock.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctrl.Close()
+	if _, err = ctrl.GetConf("SocksPort"); err == nil {
+		t.Fatal("unauthenticated access")
+	}
+	if err = ctrl.Authenticate("wrong"); err == nil {
+		t.Fatal("wrong password accepted")
+	}
+	if err = ctrl.Authenticate("secret"); err != nil {
+		t.Fatal(err)
+	}
+	// configuration
+	port, err := ctrl.GetSocksPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != mock.SocksPort() {
+		t.Fatalf("socks port %s != %s", port, mock.SocksPort())
+	}
+	if err = ctrl.SetConf("Nickname", "gospel test"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := ctrl.GetConf("Nickname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := list["Nickname"]; len(v) != 1 || v[0] != "gospel test" {
+		t.Fatalf("wrong config value %v", v)
+	}
+	if err = ctrl.Signal("NEWNYM"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctrl.execute("FOOBAR"); err == nil {
+		t.Fatal("unknown command accepted")
+	}
+}
+
+func TestMockOnion(t *testing.T) {
+	mock, err := NewMockTor("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	ctrl, err := mock.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctrl.Close()
+
+	// start a simple echo server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				_, _ = conn.Write([]byte(line))
+			}()
+		}
+	}()
+	// start hidden services with given and generated keys
+	_, prv := ed25519.NewKeypair()
+	for _, key := range []interface{}{prv, "ED25519-V3"} {
+		// (the service id of a generated key is unknown before start)
+		hs, err := NewOnion(key)
+		if _, ok := key.(string); err != nil && !ok {
+			t.Fatal(err)
+		}
+		hs.AddPort(80, listener.Addr().String())
+		if err = hs.Start(ctrl); err != nil {
+			t.Fatal(err)
+		}
+		id, err := hs.ServiceID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// connect to echo server through hidden service
+		conn, err := ctrl.DialTimeout("tcp", id+".onion:80", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Write([]byte("TEST\n")); err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if res := strings.TrimSpace(string(data)); res != "TEST" {
+			t.Fatalf("Received '%s' instead of 'TEST'", res)
+		}
+		// stop hidden service
+		if err = hs.Stop(ctrl); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ctrl.DialTimeout("tcp", id+".onion:80", time.Second); err == nil {
+			t.Fatal("stopped onion reachable")
+		}
+	}
+	if len(mock.Onions()) != 0 {
+		t.Fatal("onions left running")
+	}
+	// no clearnet access by default
+	if _, err = ctrl.DialTimeout("tcp", listener.Addr().String(), time.Second); err == nil {
+		t.Fatal("clearnet reachable")
+	}
+}
@@ -21,7 +21,6 @@ package tor
 //----------------------------------------------------------------------
 
 import (
-	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1" //nolint:gosec // required
 	"crypto/x509"
@@ -34,7 +33,6 @@ import (
 
 	"github.com/bfix/gospel/crypto/ed25519"
 	gerr "github.com/bfix/gospel/errors"
-	"github.com/bfix/gospel/math"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -129,17 +127,8 @@ func (o *Onion) Start(srv *Service) (err error) {
 	// assemble private service key blob
 	switch prv := o.key.(type) {
 	case *ed25519.PrivateKey:
-		kd := make([]byte, 64)
-		// fill right half with random data
-		if _, err = rand.Read(kd[32:]); err != nil {
-			return
-		}
-		// left half is private scalar in little endian order
-		data := prv.D.Bytes()
-		dl := len(data)
-		for i, b := range data {
-			kd[dl-i-1] = b
-		}
+		// expanded key (scalar in little endian order and nonce)
+		kd := prv.TorBlob()
 		cmd += fmt.Sprintf("ED25519-V3:%s", base64.StdEncoding.EncodeToString(kd))
 	case *rsa.PrivateKey:
 		if prv.Size() != 128 {
@@ -212,8 +201,9 @@ func (o *Onion) Start(srv *Service) (err error) {
 			}
 			switch spec {
 			case "ED25519-V3":
-				d := math.NewIntFromBytes(data[:32])
-				o.key = ed25519.NewPrivateKeyFromD(d)
+				if o.key, err = ed25519.NewPrivateKeyFromTorBlob(data); err != nil {
+					return
+				}
 			case "RSA1024":
 				if o.key, err = x509.ParsePKCS1PrivateKey(data); err != nil {
 					return
@@ -0,0 +1,191 @@
+package tor
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//======================================================================
+// Throwaway Tor process for integration tests: a private Tor instance
+// is started in a temporary data directory with random control and
+// SOCKS ports and a random control password. The process is terminated
+// and its data removed on Stop().
+//======================================================================
+
+// Error codes
+var (
+	ErrProcNotFound  = errors.New("tor executable not found")
+	ErrProcBootstrap = errors.New("tor bootstrap failed")
+)
+
+// Process is a throwaway Tor process
+type Process struct {
+	Ctrl   string // control port endpoint ("host:port")
+	Socks  string // SOCKS port endpoint ("host:port")
+	Passwd string // control port password
+
+	cmd *exec.Cmd // running Tor process
+	dir string    // temporary data directory
+}
+
+// StartProcess launches a new Tor process and waits until it is fully
+// bootstrapped (or the context expires). If 'bin' is empty, the "tor"
+// executable is searched in $PATH.
+func StartProcess(ctx context.Context, bin string) (p *Process, err error) {
+	if len(bin) == 0 {
+		if bin, err = exec.LookPath("tor"); err != nil {
+			return nil, ErrProcNotFound
+		}
+	}
+	p = new(Process)
+	// create random password and its hash
+	pw := make([]byte, 16)
+	if _, err = rand.Read(pw); err != nil {
+		return nil, err
+	}
+	p.Passwd = hex.EncodeToString(pw)
+	out, err := exec.CommandContext(ctx, bin, "--quiet", "--hash-password", p.Passwd).Output()
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Fields(string(out))
+	if len(lines) == 0 {
+		return nil, ErrProcBootstrap
+	}
+	hash := lines[len(lines)-1]
+
+	// allocate ports and data directory
+	if p.Ctrl, err = freePort(); err != nil {
+		return nil, err
+	}
+	if p.Socks, err = freePort(); err != nil {
+		return nil, err
+	}
+	if p.dir, err = os.MkdirTemp("", "gospel-tor-"); err != nil {
+		return nil, err
+	}
+	rc := fmt.Sprintf("DataDirectory %s\nControlPort %s\nSocksPort %s\nHashedControlPassword %s\nLog notice stdout\n",
+		filepath.Join(p.dir, "data"), p.Ctrl, p.Socks, hash)
+	torrc := filepath.Join(p.dir, "torrc")
+	if err = os.WriteFile(torrc, []byte(rc), 0o600); err != nil {
+		p.cleanup()
+		return nil, err
+	}
+	// start process
+	p.cmd = exec.Command(bin, "-f", torrc) //nolint:gosec // intentional
+	if err = p.cmd.Start(); err != nil {
+		p.cleanup()
+		return nil, err
+	}
+	// wait for bootstrap to complete
+	if err = p.bootstrap(ctx); err != nil {
+		_ = p.Stop()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Service returns a new authenticated controller for the process.
+func (p *Process) Service() (*Service, error) {
+	srv, err := NewService("tcp", p.Ctrl)
+	if err != nil {
+		return nil, err
+	}
+	if err = srv.Authenticate(p.Passwd); err != nil {
+		srv.Close()
+		return nil, err
+	}
+	return srv, nil
+}
+
+// Stop the Tor process and remove its data.
+func (p *Process) Stop() (err error) {
+	if p.cmd != nil && p.cmd.Process != nil {
+		if err = p.cmd.Process.Kill(); err == nil {
+			_ = p.cmd.Wait()
+		}
+	}
+	p.cleanup()
+	return
+}
+
+// cleanup removes the data directory
+func (p *Process) cleanup() {
+	if len(p.dir) > 0 {
+		os.RemoveAll(p.dir)
+		p.dir = ""
+	}
+}
+
+// bootstrap waits until Tor has completed its bootstrap
+func (p *Process) bootstrap(ctx context.Context) error {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	var srv *Service
+	defer func() {
+		if srv != nil {
+			srv.Close()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return ErrProcBootstrap
+		case <-tick.C:
+		}
+		// connect to control port (once available)
+		if srv == nil {
+			var err error
+			if srv, err = p.Service(); err != nil {
+				srv = nil
+				continue
+			}
+		}
+		st, err := srv.BootstrapPhase()
+		if err != nil {
+			return err
+		}
+		if st.Done() {
+			return nil
+		}
+	}
+}
+
+// freePort returns an available local TCP endpoint
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
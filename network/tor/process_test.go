@@ -0,0 +1,45 @@
+//go:build tor
+
+package tor
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"time"
+)
+
+// Run the live tests against a throwaway Tor process (unless a Tor
+// service is specified in the environment):
+//
+//	go test -tags tor ./network/tor/...
+func init() {
+	harness = func() (proto, endp, passwd string, stop func(), err error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+		defer cancel()
+		var p *Process
+		if p, err = StartProcess(ctx, ""); err != nil {
+			return
+		}
+		stop = func() { _ = p.Stop() }
+		return "tcp", p.Ctrl, p.Passwd, stop, nil
+	}
+}
@@ -42,6 +42,7 @@ import (
 var (
 	ErrTorNoSocksPort = errors.New("no SocksPort found")
 	ErrTorNotLocal    = errors.New("tor service not local")
+	ErrTorInfo        = errors.New("invalid tor info value")
 )
 
 // Service instance to communicate commands (and responses) with a
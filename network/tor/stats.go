@@ -0,0 +1,217 @@
+package tor
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	gerr "github.com/bfix/gospel/errors"
+)
+
+//======================================================================
+// Statistics of a Tor service (based on GETINFO requests)
+//======================================================================
+
+// Traffic is the number of bytes read and written by Tor.
+type Traffic struct {
+	Read    uint64
+	Written uint64
+}
+
+// Traffic returns the total number of bytes read and written by Tor.
+func (s *Service) Traffic() (t *Traffic, err error) {
+	var res map[string][]string
+	if res, err = s.GetInfo([]string{"traffic/read", "traffic/written"}); err != nil {
+		return
+	}
+	t = new(Traffic)
+	if t.Read, err = infoUint(res, "traffic/read"); err != nil {
+		return nil, err
+	}
+	if t.Written, err = infoUint(res, "traffic/written"); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Circuit describes a Tor circuit
+type Circuit struct {
+	ID        string    // circuit identifier
+	Status    string    // LAUNCHED, BUILT, GUARD_WAIT, EXTENDED, FAILED, CLOSED
+	Path      []string  // relays ("$<fingerprint>~<nickname>")
+	Flags     []string  // build flags (like "IS_INTERNAL")
+	Purpose   string    // circuit purpose (like "GENERAL" or "HS_SERVICE_INTRO")
+	HSState   string    // hidden service state (optional)
+	RendQuery string    // onion address the circuit is used for (optional)
+	Created   time.Time // time the circuit was created (zero if unknown)
+}
+
+// Age returns the time since the circuit was created.
+func (c *Circuit) Age() time.Duration {
+	if c.Created.IsZero() {
+		return 0
+	}
+	return time.Since(c.Created)
+}
+
+// Circuits returns the list of current circuits.
+func (s *Service) Circuits() (list []*Circuit, err error) {
+	var lines []string
+	if lines, err = s.infoLines("circuit-status"); err != nil {
+		return
+	}
+	for _, line := range lines {
+		// "<CircuitID> <CircStatus> [<Path>] [<Key>=<Value> ...]"
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		c := &Circuit{
+			ID:     f[0],
+			Status: f[1],
+		}
+		for _, arg := range f[2:] {
+			key, val, ok := strings.Cut(arg, "=")
+			if !ok {
+				c.Path = strings.Split(arg, ",")
+				continue
+			}
+			switch key {
+			case "BUILD_FLAGS":
+				c.Flags = strings.Split(val, ",")
+			case "PURPOSE":
+				c.Purpose = val
+			case "HS_STATE":
+				c.HSState = val
+			case "REND_QUERY":
+				c.RendQuery = val
+			case "TIME_CREATED":
+				c.Created, _ = time.ParseInLocation("2006-01-02T15:04:05.999999999", val, time.UTC)
+			}
+		}
+		list = append(list, c)
+	}
+	return
+}
+
+// Stream describes a Tor stream
+type Stream struct {
+	ID      string // stream identifier
+	Status  string // NEW, SENTCONNECT, SUCCEEDED, FAILED, CLOSED, ...
+	Circuit string // identifier of circuit used (or "0")
+	Target  string // target address ("<host>:<port>")
+}
+
+// Streams returns the list of current streams.
+func (s *Service) Streams() (list []*Stream, err error) {
+	var lines []string
+	if lines, err = s.infoLines("stream-status"); err != nil {
+		return
+	}
+	for _, line := range lines {
+		// "<StreamID> <StreamStatus> <CircuitID> <Target>"
+		if f := strings.Fields(line); len(f) >= 4 {
+			list = append(list, &Stream{
+				ID:      f[0],
+				Status:  f[1],
+				Circuit: f[2],
+				Target:  f[3],
+			})
+		}
+	}
+	return
+}
+
+// IntroStatus is the state of introduction points of an onion service.
+type IntroStatus struct {
+	ServiceID   string // onion service (without ".onion")
+	Established int    // number of established introduction points
+	Pending     int    // number of introduction points being established
+}
+
+// IntroPoints returns the introduction point status of onion services
+// (derived from the circuits to introduction points). Onion services
+// of this control connection are included even without circuits.
+func (s *Service) IntroPoints() (map[string]*IntroStatus, error) {
+	list, err := s.Circuits()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]*IntroStatus)
+	entry := func(id string) *IntroStatus {
+		e, ok := res[id]
+		if !ok {
+			e = &IntroStatus{ServiceID: id}
+			res[id] = e
+		}
+		return e
+	}
+	// onion services (if supported by Tor)
+	if lines, err := s.infoLines("onions/current"); err == nil {
+		for _, id := range lines {
+			entry(id)
+		}
+	}
+	for _, c := range list {
+		if c.Purpose != "HS_SERVICE_INTRO" || len(c.RendQuery) == 0 {
+			continue
+		}
+		e := entry(c.RendQuery)
+		switch {
+		case c.HSState == "HSSI_ESTABLISHED":
+			e.Established++
+		case c.Status != "FAILED" && c.Status != "CLOSED":
+			e.Pending++
+		}
+	}
+	return res, nil
+}
+
+// infoLines returns the non-empty lines of a (multi-line) info value.
+func (s *Service) infoLines(key string) (lines []string, err error) {
+	var res map[string][]string
+	if res, err = s.GetInfo([]string{key}); err != nil {
+		return
+	}
+	for _, val := range res[key] {
+		for _, line := range strings.Split(val, "\n") {
+			if line = strings.TrimSpace(line); len(line) > 0 {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return
+}
+
+// infoUint returns a numeric info value.
+func infoUint(res map[string][]string, key string) (uint64, error) {
+	vals := res[key]
+	if len(vals) == 0 {
+		return 0, gerr.New(ErrTorInfo, "missing '%s'", key)
+	}
+	v, err := strconv.ParseUint(vals[0], 10, 64)
+	if err != nil {
+		return 0, gerr.New(ErrTorInfo, "'%s': %s", key, err.Error())
+	}
+	return v, nil
+}
@@ -0,0 +1,111 @@
+package tor
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+)
+
+func TestStats(t *testing.T) {
+	mock, err := NewMockTor("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	ctrl, err := mock.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctrl.Close()
+
+	// start onion service
+	_, prv := ed25519.NewKeypair()
+	hs, err := NewOnion(prv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.AddPort(80, "127.0.0.1:8080")
+	if err = hs.Start(ctrl); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := hs.ServiceID()
+
+	// set statistics
+	mock.lock.Lock()
+	mock.conf["traffic/read"] = []string{"123456"}
+	mock.conf["traffic/written"] = []string{"7890"}
+	mock.conf["circuit-status"] = append(mock.conf["circuit-status"],
+		"2 BUILT $3333~a,$4444~b PURPOSE=HS_SERVICE_INTRO HS_STATE=HSSI_ESTABLISHED REND_QUERY="+id,
+		"3 EXTENDED $5555~c PURPOSE=HS_SERVICE_INTRO HS_STATE=HSSI_CONNECTING REND_QUERY="+id,
+	)
+	mock.conf["stream-status"] = []string{"17 SUCCEEDED 1 example.org:443"}
+	mock.lock.Unlock()
+
+	tr, err := ctrl.Traffic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Read != 123456 || tr.Written != 7890 {
+		t.Fatalf("wrong traffic: %v", tr)
+	}
+	circs, err := ctrl.Circuits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(circs) != 3 {
+		t.Fatalf("wrong number of circuits: %d", len(circs))
+	}
+	c := circs[0]
+	if c.ID != "1" || c.Status != "BUILT" || c.Purpose != "GENERAL" || len(c.Path) != 3 ||
+		len(c.Flags) != 1 || c.Flags[0] != "NEED_CAPACITY" {
+		t.Fatalf("wrong circuit: %+v", c)
+	}
+	if !c.Created.Equal(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)) || c.Age() <= 0 {
+		t.Fatalf("wrong creation time: %v", c.Created)
+	}
+	streams, err := ctrl.Streams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 1 || streams[0].Circuit != "1" || streams[0].Target != "example.org:443" {
+		t.Fatalf("wrong streams: %v", streams)
+	}
+	intros, err := ctrl.IntroPoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := intros[id]; !ok || e.Established != 1 || e.Pending != 1 || len(intros) != 1 {
+		t.Fatalf("wrong intro points: %v", intros)
+	}
+	// onion services without intro circuits are listed
+	mock.lock.Lock()
+	mock.conf["circuit-status"] = mock.conf["circuit-status"][:1]
+	mock.lock.Unlock()
+	if intros, err = ctrl.IntroPoints(); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := intros[id]; !ok || e.Established != 0 {
+		t.Fatalf("wrong intro points: %v", intros)
+	}
+}
@@ -0,0 +1,158 @@
+package tor
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/bfix/gospel/network"
+)
+
+// Error codes
+var (
+	ErrTorNoCircuit     = errors.New("no Tor circuit established")
+	ErrStatusNotStarted = errors.New("status server not started")
+)
+
+//----------------------------------------------------------------------
+// Tor status for status endpoints
+//----------------------------------------------------------------------
+
+// Status is a metrics source reporting the circuit state of a Tor
+// service; it can be registered with a network.StatusServer.
+type Status struct {
+	srv *Service
+}
+
+// NewStatus creates a metrics source for a Tor service.
+func NewStatus(srv *Service) *Status {
+	return &Status{srv: srv}
+}
+
+// Metrics returns traffic counters, the number of circuits per state
+// and purpose, the number of streams per state and the introduction
+// point status of onion services.
+func (s *Status) Metrics() (list []*network.Metric) {
+	add := func(name, help, typ string, val float64, labels map[string]string) {
+		list = append(list, &network.Metric{
+			Name:   name,
+			Help:   help,
+			Type:   typ,
+			Labels: labels,
+			Value:  val,
+		})
+	}
+	est := 0.
+	if s.Health() == nil {
+		est = 1
+	}
+	add("tor_circuit_established", "Tor has established a circuit", network.MetricGauge, est, nil)
+	if t, err := s.srv.Traffic(); err == nil {
+		add("tor_traffic_read_bytes", "Bytes read by Tor", network.MetricCounter, float64(t.Read), nil)
+		add("tor_traffic_written_bytes", "Bytes written by Tor", network.MetricCounter, float64(t.Written), nil)
+	}
+	if circs, err := s.srv.Circuits(); err == nil {
+		count := make(map[[2]string]int)
+		for _, c := range circs {
+			count[[2]string{c.Status, c.Purpose}]++
+		}
+		for _, k := range sortedKeys(count) {
+			add("tor_circuits", "Number of Tor circuits by state and purpose", network.MetricGauge,
+				float64(count[k]), map[string]string{"state": k[0], "purpose": k[1]})
+		}
+	}
+	if streams, err := s.srv.Streams(); err == nil {
+		count := make(map[string]int)
+		for _, st := range streams {
+			count[st.Status]++
+		}
+		states := make([]string, 0, len(count))
+		for state := range count {
+			states = append(states, state)
+		}
+		sort.Strings(states)
+		for _, state := range states {
+			add("tor_streams", "Number of Tor streams by state", network.MetricGauge,
+				float64(count[state]), map[string]string{"state": state})
+		}
+	}
+	if intros, err := s.srv.IntroPoints(); err == nil {
+		ids := make([]string, 0, len(intros))
+		for id := range intros {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			e := intros[id]
+			add("tor_onion_intro_points", "Introduction points of onion services", network.MetricGauge,
+				float64(e.Established), map[string]string{"onion": id, "state": "established"})
+			add("tor_onion_intro_points", "Introduction points of onion services", network.MetricGauge,
+				float64(e.Pending), map[string]string{"onion": id, "state": "pending"})
+		}
+	}
+	return
+}
+
+// sortedKeys returns the (state, purpose) keys of a counter map in
+// sorted order.
+func sortedKeys(m map[[2]string]int) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// Health reports an error if Tor has no established circuit.
+func (s *Status) Health() error {
+	res, err := s.srv.GetInfo([]string{"status/circuit-established"})
+	if err != nil {
+		return err
+	}
+	if v := res["status/circuit-established"]; len(v) == 0 || v[0] != "1" {
+		return ErrTorNoCircuit
+	}
+	return nil
+}
+
+// ExposeStatus publishes a (started) status server as a hidden service
+// on port 80 of an onion with the given private key.
+func ExposeStatus(srv *Service, status *network.StatusServer, key interface{}) (o *Onion, err error) {
+	addr := status.Addr()
+	if addr == nil {
+		return nil, ErrStatusNotStarted
+	}
+	if o, err = NewOnion(key); err != nil {
+		return
+	}
+	o.AddPort(80, addr.String())
+	if err = o.Start(srv); err != nil {
+		o = nil
+	}
+	return
+}
@@ -0,0 +1,92 @@
+package tor
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bfix/gospel/crypto/ed25519"
+	"github.com/bfix/gospel/network"
+)
+
+func TestStatus(t *testing.T) {
+	mock, err := NewMockTor("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	ctrl, err := mock.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctrl.Close()
+
+	// Tor metrics
+	status := NewStatus(ctrl)
+	if err = status.Health(); err != nil {
+		t.Fatal(err)
+	}
+	out := network.FormatMetrics(status.Metrics())
+	for _, line := range []string{"tor_circuit_established 1", `tor_circuits{purpose="GENERAL",state="BUILT"} 1`} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("missing '%s' in:\n%s", line, out)
+		}
+	}
+	// status server as onion service
+	srv := network.NewStatusServer()
+	srv.Register(status)
+	_, prv := ed25519.NewKeypair()
+	if _, err = ExposeStatus(ctrl, srv, prv); err != ErrStatusNotStarted {
+		t.Fatalf("expected not started: %v", err)
+	}
+	if err = srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	hs, err := ExposeStatus(ctrl, srv, prv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = hs.Stop(ctrl) }()
+	id, _ := hs.ServiceID()
+	cl := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, netw, addr string) (net.Conn, error) {
+				return ctrl.DialTimeout(netw, addr, time.Second)
+			},
+		},
+	}
+	resp, err := cl.Get("http://" + id + ".onion/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "OK\n" {
+		t.Fatalf("health: %d '%s'", resp.StatusCode, body)
+	}
+}
@@ -40,6 +40,10 @@ var (
 	testHost  string                      // host running hidden test server
 	err       error                       // last error code
 	socksPort = make(map[string][]string) // port mappings
+
+	// harness starts a throwaway Tor process if no Tor service is
+	// specified in the environment (only set with build tag "tor").
+	harness func() (proto, endp, passwd string, stop func(), err error)
 )
 
 //----------------------------------------------------------------------
@@ -66,22 +70,41 @@ func TestMain(m *testing.M) {
 	if len(testHost) == 0 {
 		testHost = "127.0.0.1"
 	}
-	if passwd = os.Getenv("TOR_CONTROL_PASSWORD"); len(passwd) == 0 {
-		fmt.Println("Skipping 'network/tor' tests!")
-		return
+	if passwd = os.Getenv("TOR_CONTROL_PASSWORD"); len(passwd) == 0 && harness != nil {
+		// start a throwaway Tor process
+		var stop func()
+		if proto, endp, passwd, stop, err = harness(); err != nil {
+			fmt.Printf("ERROR: %s\n", err.Error())
+			rc = 1
+			return
+		}
+		defer stop()
 	}
-	// instaniate new service for tests
-	srv, err = NewService(proto, endp)
-	if err != nil {
-		fmt.Printf("ERROR: %s\n", err.Error())
-		rc = 1
-		return
+	if len(passwd) == 0 {
+		fmt.Println("Skipping live 'network/tor' tests!")
+	} else {
+		// instaniate new service for tests
+		srv, err = NewService(proto, endp)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err.Error())
+			rc = 1
+			return
+		}
 	}
 	// run test cases
 	rc = m.Run()
 	// clean-up
-	if err = srv.Close(); err != nil {
-		rc = 1
+	if srv != nil {
+		if err = srv.Close(); err != nil {
+			rc = 1
+		}
+	}
+}
+
+// liveTest skips tests that require a running Tor service
+func liveTest(t *testing.T) {
+	if srv == nil {
+		t.Skip("no Tor service available")
 	}
 }
 
@@ -90,12 +113,14 @@ func TestMain(m *testing.M) {
 //----------------------------------------------------------------------
 
 func TestAuthentication(t *testing.T) {
+	liveTest(t)
 	if err = srv.Authenticate(passwd); err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestGetConf(t *testing.T) {
+	liveTest(t)
 	list, err := srv.GetConf("SocksPort")
 	if err != nil {
 		t.Fatal(err)
@@ -112,6 +137,7 @@ func TestGetConf(t *testing.T) {
 }
 
 func TestSocksPort(t *testing.T) {
+	liveTest(t)
 	for proxy, flags := range socksPort {
 		found, err := srv.GetSocksPort(flags...)
 		if err != nil {
@@ -128,6 +154,7 @@ func TestSocksPort(t *testing.T) {
 //----------------------------------------------------------------------
 
 func TestDial(t *testing.T) {
+	liveTest(t)
 	// connect through Tor to website
 	conn, err := srv.DialTimeout("tcp", "ipify.org:80", time.Minute)
 	if err != nil {
@@ -152,6 +179,7 @@ func TestDial(t *testing.T) {
 }
 
 func TestDialOnion(t *testing.T) {
+	liveTest(t)
 	// connect to Riseup through Tor
 	conn, err := srv.DialTimeout("tcp", "vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd.onion:80", time.Minute)
 	if err != nil {
@@ -175,6 +203,7 @@ func TestDialOnion(t *testing.T) {
 //----------------------------------------------------------------------
 
 func TestOnion(t *testing.T) {
+	liveTest(t)
 	if testing.Short() {
 		t.Skip("skipping onion test in short mode.")
 	}
@@ -29,8 +29,12 @@ import (
 // Tor utility functions
 //======================================================================
 
-// IsTorExit checks if source is a TOR exit node
+// IsTorExit checks if source is a TOR exit node (the exit list service
+// only knows IPv4 addresses; IPv6 sources are never reported as exits)
 func IsTorExit(src net.IP) bool {
+	if src.To4() == nil {
+		return false
+	}
 	return checkTor(revAddr(src))
 }
 
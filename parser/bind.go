@@ -0,0 +1,378 @@
+package parser
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"encoding"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	gerr "github.com/bfix/gospel/errors"
+)
+
+//======================================================================
+// Binding of parser output to Go structs:
+//
+// Configuration structs are populated directly from the parser event
+// stream. Struct fields are matched against parameter names (case-
+// insensitive) or against the name given in a 'name' tag; fields can
+// be skipped with 'name:"-"'. Fields can have a default value (tag
+// 'default') or be marked as required (tag 'required:"true"').
+//
+// Supported field types are strings, booleans, integers (including
+// time.Duration as a duration string), floats, types implementing
+// encoding.TextUnmarshaler, nested structs (named lists), pointers to
+// supported types, slices of supported types (lists of unnamed
+// elements) and maps with string keys (named lists).
+//======================================================================
+
+// Error codes
+var (
+	ErrBindTarget   = errors.New("bind target must be a pointer to struct")
+	ErrBindType     = errors.New("type mismatch")
+	ErrBindUnknown  = errors.New("unknown parameter")
+	ErrBindRequired = errors.New("required parameter missing")
+	ErrBindParse    = errors.New("parse failed")
+)
+
+// Bind populates a struct from a data definition read from a stream.
+func Bind(obj interface{}, rdr io.Reader) error {
+	return BindWith(obj, rdr, nil)
+}
+
+// BindWith populates a struct from a data definition read from a
+// stream; if 'opts' is not nil, includes and variable substitution are
+// handled (see ParseWith).
+func BindWith(obj interface{}, rdr io.Reader, opts *Options) error {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ErrBindTarget
+	}
+	b := &binder{
+		stack: []*frame{newFrame(v.Elem(), "", nil)},
+	}
+	brdr := bufio.NewReader(rdr)
+	var err error
+	if opts != nil {
+		err = ParseWith(brdr, b.callback, opts)
+	} else {
+		err = Parser(brdr, b.callback)
+	}
+	if b.err != nil {
+		return b.err
+	}
+	if err != nil {
+		return gerr.New(ErrBindParse, "%s", err.Error())
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------
+
+// frame is a (nested) target value during binding
+type frame struct {
+	v     reflect.Value   // target value
+	path  string          // access path (for error reporting)
+	set   map[string]bool // struct fields set from input
+	store func()          // store value in parent (for map elements)
+	count int             // number of list elements
+	key   reflect.Value   // key of pending map element
+}
+
+// newFrame creates a new binding frame
+func newFrame(v reflect.Value, path string, store func()) *frame {
+	return &frame{
+		v:     v,
+		path:  path,
+		set:   make(map[string]bool),
+		store: store,
+	}
+}
+
+// binder handles parser events
+type binder struct {
+	stack []*frame // nested targets
+	err   error    // first error encountered
+}
+
+// callback for parser events
+func (b *binder) callback(mode int, param *Parameter) bool {
+	if b.err != nil {
+		return false
+	}
+	top := b.stack[len(b.stack)-1]
+	switch mode {
+	case VAR:
+		var tgt reflect.Value
+		if tgt, b.err = b.target(top, param.Name, false); b.err == nil {
+			path := top.child(param.Name)
+			b.err = setValue(tgt, unquote(param.Value), path)
+			top.stored(tgt)
+		}
+	case LIST:
+		if param.Value == "{" {
+			var tgt reflect.Value
+			if tgt, b.err = b.target(top, param.Name, true); b.err == nil {
+				f := newFrame(tgt, top.child(param.Name), nil)
+				f.store = func() { top.stored(tgt) }
+				b.stack = append(b.stack, f)
+			}
+		} else if len(b.stack) > 1 {
+			b.err = b.finish(top)
+			b.stack = b.stack[:len(b.stack)-1]
+		}
+	case DONE:
+		if len(b.stack) == 1 {
+			b.err = b.finish(top)
+		}
+	}
+	return b.err == nil
+}
+
+// target returns the value to be set for a named (or unnamed) parameter
+// in the current frame. For map elements, a new value is returned that
+// is stored in the map by calling 'stored'.
+func (b *binder) target(f *frame, name string, list bool) (tgt reflect.Value, err error) {
+	v := deref(f.v)
+	name = unquote(name)
+	switch v.Kind() {
+	case reflect.Struct:
+		if len(name) == 0 {
+			return tgt, gerr.New(ErrBindUnknown, "%s: unnamed parameter", f.child(name))
+		}
+		idx := fieldIndex(v.Type(), name)
+		if idx == -1 {
+			return tgt, gerr.New(ErrBindUnknown, "%s", f.child(name))
+		}
+		f.set[v.Type().Field(idx).Name] = true
+		return v.Field(idx), nil
+
+	case reflect.Slice:
+		if len(name) > 0 {
+			return tgt, gerr.New(ErrBindUnknown, "%s: named element in list", f.child(name))
+		}
+		f.count++
+		v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+		return v.Index(v.Len() - 1), nil
+
+	case reflect.Map:
+		if len(name) == 0 {
+			return tgt, gerr.New(ErrBindUnknown, "%s: unnamed element in map", f.child(name))
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return tgt, gerr.New(ErrBindType, "%s: map key must be string", f.path)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		key := reflect.ValueOf(name).Convert(v.Type().Key())
+		f.key = key
+		return elem, nil
+	}
+	if list {
+		return tgt, gerr.New(ErrBindType, "%s: list assigned to %s", f.child(name), v.Type())
+	}
+	return tgt, gerr.New(ErrBindUnknown, "%s: not a list", f.path)
+}
+
+// finish a frame: apply defaults, check required fields and store
+// the value in its parent.
+func (b *binder) finish(f *frame) error {
+	if err := finishStruct(deref(f.v), f.set, f.path); err != nil {
+		return err
+	}
+	if f.store != nil {
+		f.store()
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------
+// frame helpers
+//----------------------------------------------------------------------
+
+// stored is called when a target value is complete: pending map
+// elements are stored in the map.
+func (f *frame) stored(tgt reflect.Value) {
+	if f.key.IsValid() {
+		deref(f.v).SetMapIndex(f.key, tgt)
+		f.key = reflect.Value{}
+	}
+}
+
+// child returns the path of a child element
+func (f *frame) child(name string) string {
+	path := f.path
+	if len(name) == 0 {
+		return path + "/#" + strconv.Itoa(f.count)
+	}
+	return path + "/" + unquote(name)
+}
+
+//----------------------------------------------------------------------
+// helper functions
+//----------------------------------------------------------------------
+
+var (
+	typeDuration = reflect.TypeOf(time.Duration(0))
+	typeText     = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// deref allocates and dereferences pointers
+func deref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// fieldIndex returns the index of the struct field for a name
+func fieldIndex(t reflect.Type, name string) int {
+	for i := 0; i < t.NumField(); i++ {
+		fld := t.Field(i)
+		if !fld.IsExported() {
+			continue
+		}
+		tag, ok := fld.Tag.Lookup("name")
+		if tag == "-" {
+			continue
+		}
+		if ok && tag == name {
+			return i
+		}
+		if !ok && strings.EqualFold(fld.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// finishStruct applies default values and checks required fields
+// for all fields not set from input.
+func finishStruct(v reflect.Value, set map[string]bool, path string) error {
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		fld := t.Field(i)
+		if !fld.IsExported() || fld.Tag.Get("name") == "-" || set[fld.Name] {
+			continue
+		}
+		name := fld.Tag.Get("name")
+		if len(name) == 0 {
+			name = fld.Name
+		}
+		fpath := path + "/" + name
+		if def, ok := fld.Tag.Lookup("default"); ok {
+			if err := setValue(v.Field(i), def, fpath); err != nil {
+				return err
+			}
+			continue
+		}
+		if fld.Tag.Get("required") == "true" {
+			return gerr.New(ErrBindRequired, "%s", fpath)
+		}
+		// nested structs can have defaults and required fields
+		if fld.Type.Kind() == reflect.Struct && !isScalar(v.Field(i)) {
+			if err := finishStruct(v.Field(i), nil, fpath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isScalar returns true if the value is set from a single string
+func isScalar(v reflect.Value) bool {
+	if v.CanAddr() && v.Addr().Type().Implements(typeText) {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Map:
+		return false
+	}
+	return true
+}
+
+// setValue converts a string value to the type of the target
+func setValue(v reflect.Value, s, path string) (err error) {
+	mismatch := func() error {
+		return gerr.New(ErrBindType, "%s: can't convert '%s' to %s", path, s, v.Type())
+	}
+	v = deref(v)
+	if v.CanAddr() {
+		if tu, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			if err = tu.UnmarshalText([]byte(s)); err != nil {
+				return gerr.New(ErrBindType, "%s: %s", path, err.Error())
+			}
+			return nil
+		}
+	}
+	if v.Type() == typeDuration {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return mismatch()
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return mismatch()
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return mismatch()
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return mismatch()
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return mismatch()
+		}
+		v.SetFloat(f)
+	default:
+		return mismatch()
+	}
+	return nil
+}
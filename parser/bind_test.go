@@ -0,0 +1,122 @@
+package parser
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindPort struct {
+	Port    uint16 `required:"true"`
+	Service string `default:"unknown"`
+}
+
+type bindHost struct {
+	Name    string `required:"true"`
+	Address net.IP
+	Ports   []*bindPort
+}
+
+type bindConfig struct {
+	Service struct {
+		Name string
+		Port int `default:"2342"`
+	}
+	Timeout  time.Duration `default:"30s"`
+	Debug    bool          `name:"debug"`
+	Ratio    float64
+	Tags     []string
+	Labels   map[string]string
+	Machines []bindHost
+	Limits   *struct {
+		Max int `default:"10"`
+	}
+	internal int
+}
+
+func TestBind(t *testing.T) {
+	cfg := "# test configuration\n" +
+		"Service={ Name=\">Y< Test Service\" },\n" +
+		"debug=true, Ratio=0.5,\n" +
+		"Tags={ a, b, \"c d\" },\n" +
+		"Labels={ env=prod, zone=\"eu-1\" },\n" +
+		"Machines={\n" +
+		"\t{ Name=hades, Address=192.168.23.254, Ports={\n" +
+		"\t\t{ Port=22, Service=ssh },\n" +
+		"\t\t{ Port=53 }\n" +
+		"\t}},\n" +
+		"\t{ Name=olymp, Address=192.168.23.13 }\n" +
+		"},\n" +
+		"Limits={}"
+	c := new(bindConfig)
+	if err := Bind(c, strings.NewReader(cfg)); err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case c.Service.Name != ">Y< Test Service" || c.Service.Port != 2342:
+		t.Fatalf("service: %v", c.Service)
+	case c.Timeout != 30*time.Second || !c.Debug || c.Ratio != 0.5:
+		t.Fatalf("scalars: %v %v %v", c.Timeout, c.Debug, c.Ratio)
+	case strings.Join(c.Tags, "|") != "a|b|c d":
+		t.Fatalf("tags: %v", c.Tags)
+	case len(c.Labels) != 2 || c.Labels["zone"] != "eu-1":
+		t.Fatalf("labels: %v", c.Labels)
+	case len(c.Machines) != 2 || !c.Machines[0].Address.Equal(net.ParseIP("192.168.23.254")):
+		t.Fatalf("machines: %v", c.Machines)
+	case len(c.Machines[0].Ports) != 2 || c.Machines[0].Ports[1].Service != "unknown":
+		t.Fatalf("ports: %v", c.Machines[0].Ports)
+	case c.Limits == nil || c.Limits.Max != 10:
+		t.Fatalf("limits: %v", c.Limits)
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	list := []struct {
+		cfg string
+		err error
+		msg string
+	}{
+		{"Ratio=high", ErrBindType, "/Ratio"},
+		{"Machines={ { Name=x, Ports={ { Port=99999 } } } }", ErrBindType, "/Machines/#1/Ports/#1/Port"},
+		{"Machines={ { Address=10.0.0.1 } }", ErrBindRequired, "/Machines/#1/Name"},
+		{"Unknown=1", ErrBindUnknown, "/Unknown"},
+		{"Debug=true", ErrBindUnknown, "/Debug"},
+		{"Service=1", ErrBindType, "/Service"},
+		{"Tags={ a=b }", ErrBindUnknown, "/Tags/a"},
+		{"Service={ Name=x", ErrBindParse, ""},
+	}
+	for _, e := range list {
+		err := Bind(new(bindConfig), strings.NewReader(e.cfg))
+		if !errors.Is(err, e.err) {
+			t.Fatalf("'%s': expected '%v', got '%v'", e.cfg, e.err, err)
+		}
+		if !strings.Contains(err.Error(), e.msg) {
+			t.Fatalf("'%s': missing path '%s' in '%v'", e.cfg, e.msg, err)
+		}
+	}
+	if err := Bind(bindConfig{}, strings.NewReader("")); err != ErrBindTarget {
+		t.Fatal("invalid target accepted")
+	}
+}
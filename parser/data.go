@@ -38,13 +38,24 @@ type Data struct {
 // Read data definition from reader and re-built as internal data
 // structure.
 func (d *Data) Read(rdr *bufio.Reader) error {
+	return Parser(rdr, d.builder())
+}
+
+// ReadWith reads a data definition with includes and variable
+// substitution (see ParseWith).
+func (d *Data) ReadWith(rdr *bufio.Reader, opts *Options) error {
+	return ParseWith(rdr, d.builder(), opts)
+}
+
+// builder returns a parser callback that re-builds the data structure.
+func (d *Data) builder() Callback {
 
 	// variable during parsing
 	stack := data.NewVector() // tree of data
 	curr := d                 // current data reference
 
 	// define callback method (as closure)
-	callback := func(mode int, param *Parameter) bool {
+	return func(mode int, param *Parameter) bool {
 
 		// check for terminating parser...
 		if param == nil {
@@ -98,9 +109,6 @@ func (d *Data) Read(rdr *bufio.Reader) error {
 		// report success
 		return true
 	}
-
-	// start parser
-	return Parser(rdr, callback)
 }
 
 // Write data structure to stream writer.
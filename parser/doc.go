@@ -82,5 +82,30 @@ package parser
  *  N.B.: Top-level parameters of form 'name=value' MUST always be
  *  terminated by a comma character (',') except if it is the very
  *  last parameter in a stream!
+ *
+ * --------------------------------------------------------------------
+ *  [3] Includes and variables:
+ * --------------------------------------------------------------------
+ *     The ParseWith method (and Data.ReadWith) extends the format:
+ *
+ *      <Include>   ::= '@include' '=' <Value>
+ *
+ *  An include directive can appear wherever a parameter is allowed;
+ *  the parameters of the named stream are merged into the current
+ *  list. Relative names are resolved against the directory of the
+ *  including stream; include cycles are detected and reported.
+ *     Values can reference variables (environment variables by
+ *  default) as '${NAME}' or '${NAME:-default}'; a literal '$' is
+ *  written as '$$'. References to undefined variables without a
+ *  default value are errors.
+ *
+ * --------------------------------------------------------------------
+ *  [4] Binding:
+ * --------------------------------------------------------------------
+ *     The Bind method populates an annotated Go struct directly from
+ *  the parser events: fields are matched by name (or 'name' tag) and
+ *  can have 'default' values or be 'required'. Lists map to slices,
+ *  nested structs and string-keyed maps. Errors report the access path
+ *  of the offending parameter (like "/Machines/#2/Port").
  * --------------------------------------------------------------------
  */
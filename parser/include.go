@@ -0,0 +1,235 @@
+package parser
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gerr "github.com/bfix/gospel/errors"
+)
+
+// Error codes
+var (
+	ErrIncludeCycle = errors.New("include cycle")
+	ErrIncludeDepth = errors.New("include nesting too deep")
+	ErrIncludeName  = errors.New("invalid include name")
+	ErrUndefinedVar = errors.New("undefined variable")
+	ErrInvalidVar   = errors.New("invalid variable reference")
+)
+
+// Include is the name of the include directive: a parameter
+// '@include="<name>"' is replaced by the parameters of the named
+// stream (merged into the current list).
+const Include = "@include"
+
+// Options for extended parsing (includes and variable substitution)
+type Options struct {
+	// Open a named stream for inclusion (default: open file)
+	Open func(name string) (io.ReadCloser, error)
+	// Lookup a variable (default: environment variable)
+	Lookup func(key string) (string, bool)
+	// Dir is the base directory for relative include names
+	Dir string
+	// MaxDepth is the max. nesting level of includes (default: 16)
+	MaxDepth int
+}
+
+// ParseWith reads data definitions like Parser, but handles include
+// directives and variable substitution in values transparently:
+// '${NAME}' is replaced by the value of the variable NAME (an error
+// if it is undefined), '${NAME:-default}' uses a default value for
+// undefined variables and '$$' is a literal '$'. Included streams are
+// parsed recursively (with cycle detection); relative names are
+// resolved against the directory of the including stream. The
+// callback is not notified of DONE events of included streams.
+func ParseWith(rdr *bufio.Reader, cb Callback, opts *Options) error {
+	if opts == nil {
+		opts = new(Options)
+	}
+	p := &includer{
+		opts:   opts,
+		cb:     cb,
+		active: make(map[string]bool),
+	}
+	if p.opts.Open == nil {
+		p.opts.Open = func(name string) (io.ReadCloser, error) {
+			return os.Open(name) //nolint:gosec // intended
+		}
+	}
+	if p.opts.Lookup == nil {
+		p.opts.Lookup = os.LookupEnv
+	}
+	if p.opts.MaxDepth <= 0 {
+		p.opts.MaxDepth = 16
+	}
+	return p.parse(rdr, opts.Dir, 0)
+}
+
+// ParseFile parses a named file with includes and variable substitution.
+func ParseFile(name string, cb Callback, opts *Options) error {
+	if opts == nil {
+		opts = new(Options)
+	}
+	f, err := os.Open(name) //nolint:gosec // intended
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	o := *opts
+	o.Dir = filepath.Dir(name)
+	return ParseWith(bufio.NewReader(f), cb, &o)
+}
+
+//----------------------------------------------------------------------
+
+// includer handles include directives and variable substitution for
+// nested streams.
+type includer struct {
+	opts   *Options        // parser options
+	cb     Callback        // callback of the worker
+	active map[string]bool // names of streams being parsed
+	err    error           // first error encountered
+}
+
+// parse a (nested) stream
+func (p *includer) parse(rdr *bufio.Reader, dir string, depth int) error {
+	callback := func(mode int, param *Parameter) bool {
+		// skip further events after an error
+		if p.err != nil {
+			return false
+		}
+		if mode == VAR && param != nil {
+			if param.Name == Include {
+				if p.err = p.include(unquote(param.Value), dir, depth); p.err != nil {
+					return false
+				}
+				return true
+			}
+			if param.Value, p.err = p.expand(param.Value); p.err != nil {
+				return false
+			}
+		}
+		// DONE is only reported for the top-level stream
+		if mode == DONE && depth > 0 {
+			return true
+		}
+		return p.cb(mode, param)
+	}
+	err := Parser(rdr, callback)
+	if p.err != nil {
+		return p.err
+	}
+	return err
+}
+
+// include a named stream
+func (p *includer) include(name, dir string, depth int) (err error) {
+	if len(name) == 0 {
+		return ErrIncludeName
+	}
+	if depth+1 > p.opts.MaxDepth {
+		return gerr.New(ErrIncludeDepth, "include '%s'", name)
+	}
+	if name, err = p.expand(name); err != nil {
+		return
+	}
+	if !filepath.IsAbs(name) && len(dir) > 0 {
+		name = filepath.Join(dir, name)
+	}
+	name = filepath.Clean(name)
+	if p.active[name] {
+		return gerr.New(ErrIncludeCycle, "include '%s'", name)
+	}
+	p.active[name] = true
+	defer delete(p.active, name)
+
+	var rc io.ReadCloser
+	if rc, err = p.opts.Open(name); err != nil {
+		return
+	}
+	defer rc.Close()
+	if err = p.parse(bufio.NewReader(rc), filepath.Dir(name), depth+1); err != nil {
+		// add context to plain parser errors
+		var e *gerr.Error
+		if !errors.As(err, &e) {
+			err = gerr.New(err, "include '%s'", name)
+		}
+	}
+	return
+}
+
+// expand variable references in a value
+func (p *includer) expand(val string) (string, error) {
+	if !strings.Contains(val, "$") {
+		return val, nil
+	}
+	var out strings.Builder
+	for {
+		pos := strings.IndexByte(val, '$')
+		if pos == -1 || pos == len(val)-1 {
+			out.WriteString(val)
+			return out.String(), nil
+		}
+		out.WriteString(val[:pos])
+		val = val[pos+1:]
+		switch val[0] {
+		case '$':
+			// escaped '$'
+			out.WriteByte('$')
+			val = val[1:]
+		case '{':
+			end := strings.IndexByte(val, '}')
+			if end == -1 {
+				return "", gerr.New(ErrInvalidVar, "unterminated '${%s'", val[1:])
+			}
+			ref := val[1:end]
+			val = val[end+1:]
+			key, def, hasDef := strings.Cut(ref, ":-")
+			if len(key) == 0 {
+				return "", gerr.New(ErrInvalidVar, "empty variable name")
+			}
+			v, ok := p.opts.Lookup(key)
+			if !ok {
+				if !hasDef {
+					return "", gerr.New(ErrUndefinedVar, "'%s'", key)
+				}
+				v = def
+			}
+			out.WriteString(v)
+		default:
+			// not a variable reference
+			out.WriteByte('$')
+		}
+	}
+}
+
+// unquote removes surrounding double quotes from a value
+func unquote(val string) string {
+	if size := len(val); size > 1 && val[0] == '"' && val[size-1] == '"' {
+		return val[1 : size-1]
+	}
+	return val
+}
@@ -0,0 +1,134 @@
+package parser
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// memFiles returns an opener for in-memory streams
+func memFiles(files map[string]string) func(string) (io.ReadCloser, error) {
+	return func(name string) (io.ReadCloser, error) {
+		s, ok := files[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return io.NopCloser(strings.NewReader(s)), nil
+	}
+}
+
+func TestParseInclude(t *testing.T) {
+	files := map[string]string{
+		"main.cfg":      "Name=main, @include=\"sub/hosts.cfg\", Port=${PORT:-80}",
+		"sub/hosts.cfg": "Hosts={ @include=\"host.cfg\", { Addr=${ADDR} } }",
+		"sub/host.cfg":  "{ Addr=\"$${literal}\" }",
+	}
+	vars := map[string]string{"ADDR": "10.0.0.1"}
+	opts := &Options{
+		Open: memFiles(files),
+		Lookup: func(key string) (string, bool) {
+			v, ok := vars[key]
+			return v, ok
+		},
+	}
+	d := new(Data)
+	rdr := bufio.NewReader(strings.NewReader(files["main.cfg"]))
+	if err := d.ReadWith(rdr, opts); err != nil {
+		t.Fatal(err)
+	}
+	check := func(path, val string) {
+		e := d.Lookup(path)
+		if e == nil {
+			t.Fatalf("%s: not found", path)
+		}
+		if e.Value != val {
+			t.Fatalf("%s: '%s' != '%s'", path, e.Value, val)
+		}
+	}
+	check("/Name", "main")
+	check("/Port", "80")
+	check("/Hosts/#1/Addr", "${literal}")
+	check("/Hosts/#2/Addr", "10.0.0.1")
+
+	// undefined variable
+	files["main.cfg"] = "Port=${PORT}"
+	rdr = bufio.NewReader(strings.NewReader(files["main.cfg"]))
+	if err := new(Data).ReadWith(rdr, opts); !errors.Is(err, ErrUndefinedVar) {
+		t.Fatalf("expected undefined variable: %v", err)
+	}
+	// include cycle
+	files["a.cfg"] = "A=1, @include=\"b.cfg\""
+	files["b.cfg"] = "B=1, @include=\"a.cfg\""
+	rdr = bufio.NewReader(strings.NewReader("@include=\"a.cfg\""))
+	if err := new(Data).ReadWith(rdr, opts); !errors.Is(err, ErrIncludeCycle) {
+		t.Fatalf("expected include cycle: %v", err)
+	}
+	// missing stream
+	rdr = bufio.NewReader(strings.NewReader("@include=\"none.cfg\""))
+	if err := new(Data).ReadWith(rdr, opts); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing file: %v", err)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("main.cfg", "A=1, @include=\"conf/sub.cfg\", C=3")
+	write("conf/sub.cfg", "B=${GOSPEL_TEST_B}, @include=\"${GOSPEL_TEST_INC}\"")
+	write("conf/leaf.cfg", "Leaf=1")
+	t.Setenv("GOSPEL_TEST_B", "2")
+	t.Setenv("GOSPEL_TEST_INC", "leaf.cfg")
+
+	names := make([]string, 0)
+	done := 0
+	err := ParseFile(filepath.Join(dir, "main.cfg"), func(mode int, param *Parameter) bool {
+		switch mode {
+		case VAR:
+			names = append(names, param.Name+"="+param.Value)
+		case DONE:
+			done++
+		}
+		return true
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done != 1 {
+		t.Fatalf("DONE reported %d times", done)
+	}
+	if res := strings.Join(names, ","); res != "A=1,B=2,Leaf=1,C=3" {
+		t.Fatalf("wrong parameters: %s", res)
+	}
+}
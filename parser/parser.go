@@ -25,6 +25,7 @@ import (
 	"errors"
 	"io"
 	"strconv"
+	"strings"
 	"unicode"
 
 	"github.com/bfix/gospel/data"
@@ -82,6 +83,7 @@ func Parser(rdr *bufio.Reader, cb Callback) error {
 	skip := true                // skip white spaces (outside string)?
 	escaped := false            // last character was escape?
 	comment := false            // we are inside commnent
+	varRef := false             // we are inside a variable reference
 	buf := ""                   // buffer for string assembly
 	param := new(Parameter)     // parameter instance
 	stack := data.NewIntStack() // stack for nested values
@@ -103,12 +105,19 @@ func Parser(rdr *bufio.Reader, cb Callback) error {
 						// yes: named parameter
 						param.Value = buf
 						cb(VAR, param)
+						stack.Pop()
+						stack.Pop()
 					} else if stack.Peek() == VAR {
 						// yes: unnamed parameter
 						param.Name = ""
 						param.Value = buf
 						cb(VAR, param)
-					} else if stack.Len() > 1 {
+						stack.Pop()
+					}
+				}
+				// check for unterminated lists
+				for stack.Len() > 0 {
+					if stack.Pop() == LIST {
 						// signal parser error
 						cb(ERROR, nil)
 						return mkError("Pre-mature end of data", line, offset)
@@ -206,7 +215,8 @@ func Parser(rdr *bufio.Reader, cb Callback) error {
 						stack.Pop()
 					}
 					// named parameter; check first character
-					if !unicode.IsLetter(r) && r != '"' {
+					// ('@' starts a directive like '@include')
+					if !unicode.IsLetter(r) && r != '"' && r != '@' {
 						cb(ERROR, nil)
 						return mkError("Invalid parameter name", line, offset)
 					}
@@ -297,10 +307,20 @@ func Parser(rdr *bufio.Reader, cb Callback) error {
 							stack.Push(VALUE)
 							buf = string(r)
 							state = 4
-						} else {
-							// parse new parameter
+						} else if r == '"' || r == '\\' {
+							// parse new parameter (quoted or escaped
+							// characters can't be read again)
 							buf = string(r)
 							state = 1
+						} else {
+							// parse new parameter: re-read character
+							// so it is checked as start of a name (or
+							// as end of an empty list)
+							if err = rdr.UnreadRune(); err != nil {
+								return err
+							}
+							buf = ""
+							state = 1
 						}
 					}
 				}
@@ -312,8 +332,16 @@ func Parser(rdr *bufio.Reader, cb Callback) error {
 			{
 				// drop escapes: use escaped character directly.
 				if !escaped {
+					// check for variable reference ("${...}")
+					if r == '{' && strings.HasSuffix(buf, "$") && !strings.HasSuffix(buf, "$$") {
+						varRef = true
+					} else if varRef && r == '}' {
+						varRef = false
+						buf += string(r)
+						continue
+					}
 					// check for termination of value
-					if skip && (r == '}' || r == ',') {
+					if skip && !varRef && (r == '}' || r == ',') {
 						// notify value complete
 						param.Value = buf
 						cb(VAR, param)
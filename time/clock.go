@@ -0,0 +1,357 @@
+package time
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+//======================================================================
+// Clock abstraction: time-dependent code should use a Clock instance
+// instead of calling the functions of the standard "time" package
+// directly. Production code uses the real clock (Real), tests and
+// simulations use a virtual clock (FakeClock) that only advances on
+// request.
+//======================================================================
+
+// Timer is a single event timer (see time.Timer)
+type Timer interface {
+	// Stop the timer. Returns false if the timer already expired.
+	Stop() bool
+
+	// Reset the timer to expire after given duration. Returns true if
+	// the timer was active.
+	Reset(d time.Duration) bool
+}
+
+// Ticker delivers ticks at intervals (see time.Ticker)
+type Ticker interface {
+	// C returns the channel for ticks
+	C() <-chan time.Time
+
+	// Stop the ticker
+	Stop()
+
+	// Reset the ticker period
+	Reset(d time.Duration)
+}
+
+// Clock provides the current time and timers/tickers.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// Since returns the time elapsed since 't'
+	Since(t time.Time) time.Duration
+
+	// Sleep for given duration
+	Sleep(d time.Duration)
+
+	// After returns a channel that receives the current time after 'd'
+	After(d time.Duration) <-chan time.Time
+
+	// AfterFunc calls 'f' after 'd'
+	AfterFunc(d time.Duration, f func()) Timer
+
+	// NewTicker returns a new ticker with given period
+	NewTicker(d time.Duration) Ticker
+}
+
+//----------------------------------------------------------------------
+// Real clock
+//----------------------------------------------------------------------
+
+// Real is the system clock. Time values carry a monotonic clock
+// reading, so durations between them are not affected by changes of
+// the wall clock.
+var Real Clock = realClock{}
+
+// realClock is a thin wrapper around the "time" package
+type realClock struct{}
+
+// Now returns the current time
+func (realClock) Now() time.Time { return time.Now() }
+
+// Since returns the time elapsed since 't'
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// Sleep for given duration
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// After returns a channel that receives the current time after 'd'
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// AfterFunc calls 'f' after 'd'
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// NewTicker returns a new ticker with given period
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{time.NewTicker(d)}
+}
+
+// realTicker wraps a time.Ticker
+type realTicker struct {
+	*time.Ticker
+}
+
+// C returns the channel for ticks
+func (t *realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+//----------------------------------------------------------------------
+// Fake (virtual) clock
+//----------------------------------------------------------------------
+
+// fakeEvent is a scheduled event
+type fakeEvent struct {
+	at    time.Time // execution time
+	seq   uint64    // sequence number (for stable ordering)
+	f     func()    // action
+	index int       // index in queue (-1 if not queued)
+}
+
+// fakeQueue is a priority queue of events (earliest first)
+type fakeQueue []*fakeEvent
+
+func (q fakeQueue) Len() int { return len(q) }
+func (q fakeQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q fakeQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *fakeQueue) Push(x interface{}) {
+	ev := x.(*fakeEvent)
+	ev.index = len(*q)
+	*q = append(*q, ev)
+}
+func (q *fakeQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	ev := old[n-1]
+	ev.index = -1
+	*q = old[:n-1]
+	return ev
+}
+
+// FakeClock is a virtual clock: scheduled events (timers, tickers,
+// sleepers) are executed when the clock is advanced past their
+// execution time.
+type FakeClock struct {
+	now   time.Time  // current virtual time
+	seq   uint64     // last event sequence number
+	queue fakeQueue  // pending events
+	lock  sync.Mutex // lock for concurrent access
+	cond  *sync.Cond // signal for new events
+}
+
+// NewFakeClock creates a virtual clock starting at given time.
+func NewFakeClock(start time.Time) *FakeClock {
+	c := &FakeClock{
+		now:   start,
+		queue: make(fakeQueue, 0),
+	}
+	c.cond = sync.NewCond(&c.lock)
+	return c
+}
+
+// Now returns the current virtual time.
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Since returns the (virtual) time elapsed since 't'
+func (c *FakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Pending returns the number of scheduled events.
+func (c *FakeClock) Pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.queue)
+}
+
+// BlockUntil waits until at least 'n' events are scheduled (e.g. by
+// go-routines calling Sleep).
+func (c *FakeClock) BlockUntil(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for len(c.queue) < n {
+		c.cond.Wait()
+	}
+}
+
+// Advance the clock by given duration and execute all events that are
+// due (in order). Events scheduled by executed events are processed as
+// well if they fall into the time window. Returns the number of executed
+// events.
+func (c *FakeClock) Advance(d time.Duration) (n int) {
+	c.lock.Lock()
+	end := c.now.Add(d)
+	for len(c.queue) > 0 && !c.queue[0].at.After(end) {
+		ev := heap.Pop(&c.queue).(*fakeEvent)
+		c.now = ev.at
+		c.lock.Unlock()
+		ev.f()
+		n++
+		c.lock.Lock()
+	}
+	c.now = end
+	c.lock.Unlock()
+	return
+}
+
+// schedule an event after given delay (relative to virtual time).
+func (c *FakeClock) schedule(ev *fakeEvent, d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.seq++
+	ev.at = c.now.Add(d)
+	ev.seq = c.seq
+	heap.Push(&c.queue, ev)
+	c.cond.Broadcast()
+}
+
+// cancel a scheduled event. Returns true if the event was pending.
+func (c *FakeClock) cancel(ev *fakeEvent) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if ev.index < 0 {
+		return false
+	}
+	heap.Remove(&c.queue, ev.index)
+	return true
+}
+
+// Sleep blocks until the clock is advanced by given duration.
+func (c *FakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-c.After(d)
+}
+
+// After returns a channel that receives the virtual time after 'd'
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() {
+		ch <- c.Now()
+	})
+	return ch
+}
+
+// AfterFunc calls 'f' after 'd' (while advancing the clock)
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{
+		clk: c,
+		ev:  &fakeEvent{f: f, index: -1},
+	}
+	c.schedule(t.ev, d)
+	return t
+}
+
+// NewTicker returns a new ticker with given period
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	t := &fakeTicker{
+		clk:    c,
+		ch:     make(chan time.Time, 1),
+		period: d,
+	}
+	t.ev = &fakeEvent{f: t.tick, index: -1}
+	c.schedule(t.ev, d)
+	return t
+}
+
+// fakeTimer is a timer on a virtual clock
+type fakeTimer struct {
+	clk *FakeClock
+	ev  *fakeEvent
+}
+
+// Stop the timer. Returns false if the timer already expired.
+func (t *fakeTimer) Stop() bool {
+	return t.clk.cancel(t.ev)
+}
+
+// Reset the timer to expire after given duration.
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	active := t.clk.cancel(t.ev)
+	t.clk.schedule(t.ev, d)
+	return active
+}
+
+// fakeTicker is a ticker on a virtual clock
+type fakeTicker struct {
+	clk    *FakeClock
+	ch     chan time.Time
+	ev     *fakeEvent
+	period time.Duration
+	lock   sync.Mutex
+	done   bool
+}
+
+// tick is executed at the end of each period
+func (t *fakeTicker) tick() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.done {
+		return
+	}
+	// drop tick if not consumed (like time.Ticker)
+	select {
+	case t.ch <- t.clk.Now():
+	default:
+	}
+	t.clk.schedule(t.ev, t.period)
+}
+
+// C returns the channel for ticks
+func (t *fakeTicker) C() <-chan time.Time { return t.ch }
+
+// Stop the ticker
+func (t *fakeTicker) Stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.done = true
+	t.clk.cancel(t.ev)
+}
+
+// Reset the ticker period
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.clk.cancel(t.ev)
+	t.period = d
+	t.done = false
+	t.clk.schedule(t.ev, d)
+}
@@ -0,0 +1,156 @@
+package time
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var epoch = time.Unix(1700000000, 0)
+
+func TestFakeTimer(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	var fired int32
+	tmr := clk.AfterFunc(time.Second, func() { atomic.AddInt32(&fired, 1) })
+	if n := clk.Advance(999 * time.Millisecond); n != 0 {
+		t.Fatal("timer fired early")
+	}
+	if n := clk.Advance(time.Millisecond); n != 1 || atomic.LoadInt32(&fired) != 1 {
+		t.Fatal("timer not fired")
+	}
+	if tmr.Stop() {
+		t.Fatal("stopped expired timer")
+	}
+	if tmr.Reset(time.Second) {
+		t.Fatal("reset reports active timer")
+	}
+	if !tmr.Stop() || clk.Pending() != 0 {
+		t.Fatal("timer not stopped")
+	}
+	clk.Advance(time.Hour)
+	if atomic.LoadInt32(&fired) != 1 {
+		t.Fatal("stopped timer fired")
+	}
+	if !clk.Now().Equal(epoch.Add(time.Hour + time.Second)) {
+		t.Fatal("wrong time")
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	tck := clk.NewTicker(time.Minute)
+	count := 0
+	for i := 0; i < 10; i++ {
+		clk.Advance(time.Minute)
+		select {
+		case ts := <-tck.C():
+			if !ts.Equal(epoch.Add(time.Duration(i+1) * time.Minute)) {
+				t.Fatalf("tick at %v", ts)
+			}
+			count++
+		default:
+		}
+	}
+	tck.Stop()
+	clk.Advance(time.Hour)
+	if count != 10 || len(tck.C()) != 0 || clk.Pending() != 0 {
+		t.Fatalf("%d ticks", count)
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	done := make(chan time.Duration)
+	go func() {
+		start := clk.Now()
+		clk.Sleep(5 * time.Second)
+		done <- clk.Since(start)
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(5 * time.Second)
+	if d := <-done; d != 5*time.Second {
+		t.Fatalf("slept %v", d)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	d := 10 * time.Second
+	for i := 0; i < 1000; i++ {
+		if j := Jitter(d, 0.2); j < 8*time.Second || j > 12*time.Second {
+			t.Fatalf("jitter out of range: %v", j)
+		}
+	}
+	if Jitter(d, 0) != d {
+		t.Fatal("jitter without factor")
+	}
+}
+
+func TestJitterTicker(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	tck := NewJitterTicker(clk, time.Minute, 0.5)
+	last, count := epoch, 0
+	for i := 0; i < 3600; i++ {
+		clk.Advance(time.Second)
+		select {
+		case ts := <-tck.C:
+			if d := ts.Sub(last); d < 30*time.Second || d > 90*time.Second {
+				t.Fatalf("tick interval %v", d)
+			}
+			last = ts
+			count++
+		default:
+		}
+	}
+	if count < 40 || count > 120 {
+		t.Fatalf("%d ticks in an hour", count)
+	}
+	tck.Stop()
+	if clk.Pending() != 0 {
+		t.Fatal("ticker not stopped")
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	d := NewDeadline(clk, time.Minute)
+	if d.Expired() || d.Remaining() != time.Minute {
+		t.Fatal("fresh deadline expired")
+	}
+	clk.Advance(30 * time.Second)
+	d.Extend(30 * time.Second)
+	if d.Remaining() != time.Minute || !d.Time().Equal(epoch.Add(90*time.Second)) {
+		t.Fatal("extend failed")
+	}
+	clk.Advance(time.Minute)
+	if !d.Expired() || d.Remaining() != 0 {
+		t.Fatal("deadline not expired")
+	}
+	d.Reset(time.Second)
+	if d.Expired() {
+		t.Fatal("reset failed")
+	}
+	// real clock
+	if NewDeadline(Real, -time.Second).Remaining() != 0 {
+		t.Fatal("real deadline")
+	}
+}
@@ -0,0 +1,150 @@
+package time
+
+//----------------------------------------------------------------------
+// This file is part of Gospel.
+// Copyright (C) 2011-2023 Bernd Fix  >Y<
+//
+// Gospel is free software: you can redistribute it and/or modify it
+// under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// Gospel is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+// SPDX-License-Identifier: AGPL3.0-or-later
+//----------------------------------------------------------------------
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//----------------------------------------------------------------------
+// Jitter
+//----------------------------------------------------------------------
+
+// Jitter returns a random duration in the range [d-f*d, d+f*d] with
+// 0 <= f <= 1. Jitter is used to de-synchronize periodic tasks of
+// many peers (like reconnects or announcements).
+func Jitter(d time.Duration, f float64) time.Duration {
+	if f <= 0 {
+		return d
+	}
+	if f > 1 {
+		f = 1
+	}
+	delta := float64(d) * f * (2*rand.Float64() - 1) //nolint:gosec // no crypto
+	return d + time.Duration(delta)
+}
+
+//----------------------------------------------------------------------
+// Jittered ticker
+//----------------------------------------------------------------------
+
+// JitterTicker delivers ticks on channel C with a period that varies
+// randomly around a mean value (see Jitter).
+type JitterTicker struct {
+	C <-chan time.Time // channel for ticks
+
+	ch     chan time.Time // tick channel
+	clk    Clock          // clock in use
+	period time.Duration  // mean period
+	jitter float64        // jitter factor
+	timer  Timer          // timer for next tick
+	lock   sync.Mutex     // lock for concurrent access
+	done   bool           // ticker stopped?
+}
+
+// NewJitterTicker creates a new jittered ticker on a clock.
+func NewJitterTicker(clk Clock, d time.Duration, f float64) *JitterTicker {
+	ch := make(chan time.Time, 1)
+	t := &JitterTicker{
+		C:      ch,
+		ch:     ch,
+		clk:    clk,
+		period: d,
+		jitter: f,
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.timer = clk.AfterFunc(Jitter(d, f), t.tick)
+	return t
+}
+
+// tick is executed at the end of each period
+func (t *JitterTicker) tick() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.done {
+		return
+	}
+	// drop tick if not consumed
+	select {
+	case t.ch <- t.clk.Now():
+	default:
+	}
+	t.timer.Reset(Jitter(t.period, t.jitter))
+}
+
+// Stop the ticker
+func (t *JitterTicker) Stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.done = true
+	t.timer.Stop()
+}
+
+//----------------------------------------------------------------------
+// Deadlines
+//----------------------------------------------------------------------
+
+// Deadline is a point in time after which an operation (or a cached
+// item like a connection) expires. Deadlines are based on a clock; for
+// the real clock they are immune to changes of the wall clock.
+type Deadline struct {
+	clk Clock     // clock in use
+	at  time.Time // expiration time
+}
+
+// NewDeadline returns a deadline 'd' from now.
+func NewDeadline(clk Clock, d time.Duration) *Deadline {
+	return &Deadline{
+		clk: clk,
+		at:  clk.Now().Add(d),
+	}
+}
+
+// Time returns the expiration time.
+func (d *Deadline) Time() time.Time {
+	return d.at
+}
+
+// Remaining returns the time left before expiration (or 0 if expired)
+func (d *Deadline) Remaining() time.Duration {
+	if r := d.at.Sub(d.clk.Now()); r > 0 {
+		return r
+	}
+	return 0
+}
+
+// Expired returns true if the deadline has passed.
+func (d *Deadline) Expired() bool {
+	return !d.clk.Now().Before(d.at)
+}
+
+// Reset the deadline to 'dur' from now.
+func (d *Deadline) Reset(dur time.Duration) {
+	d.at = d.clk.Now().Add(dur)
+}
+
+// Extend the deadline by given duration.
+func (d *Deadline) Extend(dur time.Duration) {
+	d.at = d.at.Add(dur)
+}